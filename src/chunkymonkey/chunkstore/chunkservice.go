@@ -3,12 +3,19 @@ package chunkstore
 import (
 	"os"
 
+	"chunkymonkey/storage"
 	. "chunkymonkey/types"
+	"nbt"
 )
 
+// request is a request to load a chunk, or to write it if chunkTag is set, or
+// to run a function with the store if run is set.
 type request struct {
 	chunkLoc     ChunkXz
 	responseChan chan<- ChunkResult
+	chunkTag     nbt.ITag
+	writeChan    chan<- os.Error
+	run          func()
 }
 
 type IChunkStoreForeground interface {
@@ -32,11 +39,27 @@ func NewChunkService(store IChunkStoreForeground) (s *ChunkService) {
 func (s *ChunkService) Serve() {
 	for {
 		request := <-s.requests
+		if request.run != nil {
+			request.run()
+			continue
+		}
+		if request.chunkTag != nil {
+			request.writeChan <- s.writeChunk(request.chunkLoc, request.chunkTag)
+			continue
+		}
 		reader, err := s.store.LoadChunk(request.chunkLoc)
 		request.responseChan <- ChunkResult{reader, err}
 	}
 }
 
+func (s *ChunkService) writeChunk(chunkLoc ChunkXz, chunkTag nbt.ITag) os.Error {
+	writer, ok := s.store.(IChunkWriter)
+	if !ok {
+		return ReadOnlyStoreError(false)
+	}
+	return writer.WriteChunk(chunkLoc, chunkTag)
+}
+
 func (s *ChunkService) LoadChunk(chunkLoc ChunkXz) <-chan ChunkResult {
 	responseChan := make(chan ChunkResult)
 
@@ -47,3 +70,52 @@ func (s *ChunkService) LoadChunk(chunkLoc ChunkXz) <-chan ChunkResult {
 
 	return responseChan
 }
+
+// WriteChunk writes the chunk once the store has finished with earlier
+// requests. The result can be ignored, as it has room to be sent without being
+// received.
+func (s *ChunkService) WriteChunk(chunkLoc ChunkXz, chunkTag nbt.ITag) <-chan os.Error {
+	writeChan := make(chan os.Error, 1)
+
+	s.requests <- request{
+		chunkLoc:  chunkLoc,
+		chunkTag:  chunkTag,
+		writeChan: writeChan,
+	}
+
+	return writeChan
+}
+
+// ChunkVersion implements IChunkVersions, for stores that keep versions.
+func (s *ChunkService) ChunkVersion(chunkLoc ChunkXz) (version storage.Version, ok bool) {
+	s.runVersions(func(versions IChunkVersions) {
+		version, ok = versions.ChunkVersion(chunkLoc)
+	})
+	return
+}
+
+// SetChunkVersion implements IChunkVersions, for stores that keep versions.
+func (s *ChunkService) SetChunkVersion(chunkLoc ChunkXz, version storage.Version) (ok bool) {
+	s.runVersions(func(versions IChunkVersions) {
+		ok = versions.SetChunkVersion(chunkLoc, version)
+	})
+	return
+}
+
+// runVersions runs f with the store's versions once the store has finished
+// with earlier requests. f isn't run if the store doesn't keep versions.
+func (s *ChunkService) runVersions(f func(versions IChunkVersions)) {
+	versions, ok := s.store.(IChunkVersions)
+	if !ok {
+		return
+	}
+
+	done := make(chan bool)
+	s.requests <- request{
+		run: func() {
+			f(versions)
+			done <- true
+		},
+	}
+	<-done
+}
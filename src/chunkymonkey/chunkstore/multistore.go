@@ -3,13 +3,17 @@ package chunkstore
 import (
 	"os"
 
+	"chunkymonkey/storage"
 	. "chunkymonkey/types"
+	"nbt"
 )
 
 // MultiStore provides the ability to load a chunk from one or more potential
 // sources of chunk data. The primary purpose of this is to read from a
 // persistant store first, then fall back to generating a chunk if the
-// persistant store does not have it. MultiStore implements IChunkStore.
+// persistant store does not have it. Chunks are written to the first store that
+// can write them. MultiStore implements IChunkStoreForeground, IChunkWriter
+// and IChunkVersions.
 type MultiStore struct {
 	stores []IChunkStore
 }
@@ -39,3 +43,42 @@ func (s *MultiStore) LoadChunk(chunkLoc ChunkXz) (reader IChunkReader, err os.Er
 
 	return nil, NoSuchChunkError(false)
 }
+
+func (s *MultiStore) WriteChunk(chunkLoc ChunkXz, chunkTag nbt.ITag) os.Error {
+	for _, store := range s.stores {
+		err := <-store.WriteChunk(chunkLoc, chunkTag)
+		if _, ok := err.(ReadOnlyStoreError); ok {
+			// Fall through to next chunk store.
+			continue
+		}
+		return err
+	}
+
+	return ReadOnlyStoreError(false)
+}
+
+// ChunkVersion returns the version of the chunk known to the first store that
+// knows it.
+func (s *MultiStore) ChunkVersion(chunkLoc ChunkXz) (version storage.Version, ok bool) {
+	for _, store := range s.stores {
+		if versions, isVersions := store.(IChunkVersions); isVersions {
+			if version, ok = versions.ChunkVersion(chunkLoc); ok {
+				return
+			}
+		}
+	}
+	return
+}
+
+// SetChunkVersion sets the version of the chunk in the first store that keeps
+// versions.
+func (s *MultiStore) SetChunkVersion(chunkLoc ChunkXz, version storage.Version) (ok bool) {
+	for _, store := range s.stores {
+		if versions, isVersions := store.(IChunkVersions); isVersions {
+			if versions.SetChunkVersion(chunkLoc, version) {
+				return true
+			}
+		}
+	}
+	return false
+}
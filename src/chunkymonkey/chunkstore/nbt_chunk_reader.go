@@ -29,6 +29,14 @@ func newNbtChunkReader(reader io.Reader) (r *nbtChunkReader, err os.Error) {
 	return
 }
 
+// NewNbtChunkReader creates an IChunkReader for a chunk that is already in its
+// NBT form, in the format of chunk files.
+func NewNbtChunkReader(chunkTag nbt.ITag) IChunkReader {
+	return &nbtChunkReader{
+		chunkTag: chunkTag,
+	}
+}
+
 func (r *nbtChunkReader) ChunkLoc() ChunkXz {
 	return ChunkXz{
 		X: ChunkCoord(r.chunkTag.Lookup("Level/xPos").(*nbt.Int).Value),
@@ -65,20 +73,10 @@ func (r *nbtChunkReader) Entities() (entities []gamerules.INonPlayerEntity) {
 	entities = make([]gamerules.INonPlayerEntity, 0, len(entities))
 
 	for _, entityTag := range entityListTag.Value {
-		entityObjectId, ok := entityTag.Lookup("id").(*nbt.String)
-
-		if !ok {
-			log.Printf("missing or bad entity type ID in NBT: %s", entityObjectId)
+		if entity, err := gamerules.NewEntityFromNbt(entityTag); err != nil {
+			log.Printf("Error reading entity NBT: %s", err)
 		} else {
-			if entity := gamerules.NewEntityByTypeName(entityObjectId.Value); entity == nil {
-				log.Printf("Found unhandled entity type: %s", entityObjectId.Value)
-			} else {
-				if err := entity.ReadNbt(entityTag); err != nil {
-					log.Printf("Error reading entity NBT: %s", err)
-				} else {
-					entities = append(entities, entity)
-				}
-			}
+			entities = append(entities, entity)
 		}
 	}
 
@@ -0,0 +1,91 @@
+package chunkstore
+
+import (
+	"os"
+
+	"chunkymonkey/storage"
+	. "chunkymonkey/types"
+	"nbt"
+)
+
+// chunkStoreStorage reads and writes chunks in an IStorage, such as a storage
+// server.
+type chunkStoreStorage struct {
+	store     storage.IStorage
+	dimension DimensionId
+
+	// The stored version of each chunk that has been read or written, keyed
+	// by ChunkKey. Chunks are only written over the version that was read, so
+	// that a server that no longer holds a chunk can't replace a newer one.
+	versions map[uint64]storage.Version
+	// Chunks that have been written by someone else since their version was
+	// read, keyed by ChunkKey. They are no longer written until read again.
+	conflicts map[uint64]bool
+}
+
+// NewChunkStoreStorage creates an IChunkStoreForeground that reads and writes
+// the chunks of a dimension in an IStorage. It also implements IChunkWriter
+// and IChunkVersions.
+func NewChunkStoreStorage(store storage.IStorage, dimension DimensionId) IChunkStoreForeground {
+	return &chunkStoreStorage{
+		store:     store,
+		dimension: dimension,
+		versions:  make(map[uint64]storage.Version),
+		conflicts: make(map[uint64]bool),
+	}
+}
+
+func (s *chunkStoreStorage) LoadChunk(chunkLoc ChunkXz) (reader IChunkReader, err os.Error) {
+	chunkTag, version, err := s.store.GetChunk(s.dimension, chunkLoc)
+	if err != nil {
+		return
+	}
+	key := chunkLoc.ChunkKey()
+	s.versions[key] = version
+	s.conflicts[key] = false, false
+	if chunkTag == nil {
+		return nil, NoSuchChunkError(false)
+	}
+
+	return NewNbtChunkReader(chunkTag), nil
+}
+
+// WriteChunk writes over the version of the chunk that was last read or
+// written, or that was handed over with it from another chunk server. A chunk
+// whose version isn't known isn't written, and returns UnknownVersionError.
+// After a version mismatch the chunk isn't written again until it is next
+// read, as that would lose the changes written by someone else, and
+// ErrVersionMismatch is returned each time.
+func (s *chunkStoreStorage) WriteChunk(chunkLoc ChunkXz, chunkTag nbt.ITag) os.Error {
+	key := chunkLoc.ChunkKey()
+	if s.conflicts[key] {
+		return storage.ErrVersionMismatch
+	}
+	expected, ok := s.versions[key]
+	if !ok {
+		return UnknownVersionError(false)
+	}
+
+	version, err := s.store.PutChunk(s.dimension, chunkLoc, expected, chunkTag)
+	if err != nil {
+		if err == storage.ErrVersionMismatch {
+			s.conflicts[key] = true
+		}
+		return err
+	}
+	s.versions[key] = version
+
+	return nil
+}
+
+func (s *chunkStoreStorage) ChunkVersion(chunkLoc ChunkXz) (version storage.Version, ok bool) {
+	version, ok = s.versions[chunkLoc.ChunkKey()]
+	return
+}
+
+func (s *chunkStoreStorage) SetChunkVersion(chunkLoc ChunkXz, version storage.Version) (ok bool) {
+	key := chunkLoc.ChunkKey()
+	s.versions[key] = version
+	s.conflicts[key] = false, false
+	return true
+}
@@ -0,0 +1,145 @@
+package chunkstore
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"chunkymonkey/storage"
+	. "chunkymonkey/types"
+	"nbt"
+)
+
+func testChunkTag(value int32) *nbt.Compound {
+	return &nbt.Compound{
+		map[string]nbt.ITag{
+			"Level": &nbt.Compound{
+				map[string]nbt.ITag{
+					"Value": &nbt.Int{value},
+				},
+			},
+		},
+	}
+}
+
+// readOnlyStore is an IChunkStoreForeground that has no chunks and can't write
+// them.
+type readOnlyStore struct{}
+
+func (s readOnlyStore) LoadChunk(chunkLoc ChunkXz) (reader IChunkReader, err os.Error) {
+	return nil, NoSuchChunkError(false)
+}
+
+func TestChunkStoreStorageWriteChunk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chunkstore_test")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	diskStorage := storage.NewDiskStorage(dir)
+	loc := ChunkXz{3, -4}
+
+	store := NewChunkStoreStorage(diskStorage, DimensionNormal)
+	if _, err := store.LoadChunk(loc); err != NoSuchChunkError(false) {
+		t.Fatalf("expected NoSuchChunkError, got %v", err)
+	}
+
+	// Chunks are written to the first store that can write them.
+	readOnly := NewChunkService(readOnlyStore{})
+	storageService := NewChunkService(store)
+	multi := NewChunkService(NewMultiStore([]IChunkStore{readOnly, storageService}))
+	go readOnly.Serve()
+	go storageService.Serve()
+	go multi.Serve()
+
+	if err := <-multi.WriteChunk(loc, testChunkTag(1)); err != nil {
+		t.Fatalf("WriteChunk: %v", err)
+	}
+	if err := <-readOnly.WriteChunk(loc, testChunkTag(1)); err != ReadOnlyStoreError(false) {
+		t.Errorf("expected ReadOnlyStoreError, got %v", err)
+	}
+	if err := <-multi.WriteChunk(loc, testChunkTag(2)); err != nil {
+		t.Fatalf("WriteChunk: %v", err)
+	}
+
+	// A chunk written by someone else since it was last written isn't
+	// replaced, nor written again until it is next read.
+	if _, err := diskStorage.PutChunk(DimensionNormal, loc, storage.AnyVersion, testChunkTag(3)); err != nil {
+		t.Fatalf("PutChunk: %v", err)
+	}
+	if err := <-multi.WriteChunk(loc, testChunkTag(4)); err != storage.ErrVersionMismatch {
+		t.Errorf("expected ErrVersionMismatch, got %v", err)
+	}
+	if err := <-multi.WriteChunk(loc, testChunkTag(5)); err != storage.ErrVersionMismatch {
+		t.Errorf("expected ErrVersionMismatch, got %v", err)
+	}
+	expectStoredValue(t, diskStorage, loc, 3)
+
+	if _, err := store.LoadChunk(loc); err != nil {
+		t.Fatalf("LoadChunk: %v", err)
+	}
+	if err := <-multi.WriteChunk(loc, testChunkTag(6)); err != nil {
+		t.Fatalf("WriteChunk: %v", err)
+	}
+	expectStoredValue(t, diskStorage, loc, 6)
+}
+
+func TestChunkStoreStorageHandOverVersion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chunkstore_test")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	diskStorage := storage.NewDiskStorage(dir)
+	loc := ChunkXz{3, -4}
+
+	newStore := func() *ChunkService {
+		service := NewChunkService(NewChunkStoreStorage(diskStorage, DimensionNormal))
+		multi := NewChunkService(NewMultiStore([]IChunkStore{service}))
+		go service.Serve()
+		go multi.Serve()
+		return multi
+	}
+	oldHolder, newHolder := newStore(), newStore()
+
+	<-oldHolder.LoadChunk(loc)
+	if err := <-oldHolder.WriteChunk(loc, testChunkTag(1)); err != nil {
+		t.Fatalf("WriteChunk: %v", err)
+	}
+
+	// A chunk whose version isn't known isn't written.
+	if err := <-newHolder.WriteChunk(loc, testChunkTag(2)); err != UnknownVersionError(false) {
+		t.Errorf("expected UnknownVersionError, got %v", err)
+	}
+
+	// The new holder writes over the version handed over by the old one.
+	version, ok := oldHolder.ChunkVersion(loc)
+	if !ok {
+		t.Fatalf("expected the old holder to know the chunk's version")
+	}
+	if !newHolder.SetChunkVersion(loc, version) {
+		t.Fatalf("expected the new holder to keep versions")
+	}
+	if err := <-newHolder.WriteChunk(loc, testChunkTag(3)); err != nil {
+		t.Fatalf("WriteChunk: %v", err)
+	}
+	expectStoredValue(t, diskStorage, loc, 3)
+
+	// The old holder can no longer write the chunk.
+	if err := <-oldHolder.WriteChunk(loc, testChunkTag(4)); err != storage.ErrVersionMismatch {
+		t.Errorf("expected ErrVersionMismatch, got %v", err)
+	}
+	expectStoredValue(t, diskStorage, loc, 3)
+}
+
+func expectStoredValue(t *testing.T, diskStorage storage.IStorage, loc ChunkXz, expected int32) {
+	chunkTag, _, err := diskStorage.GetChunk(DimensionNormal, loc)
+	if err != nil {
+		t.Fatalf("GetChunk: %v", err)
+	}
+	if value, ok := chunkTag.Lookup("Level/Value").(*nbt.Int); !ok || value.Value != expected {
+		t.Errorf("expected value %d, got %v", expected, chunkTag.Lookup("Level/Value"))
+	}
+}
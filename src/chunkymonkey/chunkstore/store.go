@@ -5,6 +5,7 @@ import (
 	"os"
 
 	"chunkymonkey/gamerules"
+	"chunkymonkey/storage"
 	. "chunkymonkey/types"
 	"nbt"
 )
@@ -19,6 +20,31 @@ type IChunkStore interface {
 	Serve()
 
 	LoadChunk(chunkLoc ChunkXz) (result <-chan ChunkResult)
+
+	// WriteChunk stores the NBT data of a chunk, in the form that
+	// NewNbtChunkReader reads. It returns ReadOnlyStoreError if the store
+	// can't write chunks.
+	WriteChunk(chunkLoc ChunkXz, chunkTag nbt.ITag) (result <-chan os.Error)
+}
+
+// IChunkWriter is implemented by IChunkStoreForegrounds that can write chunks
+// as well as read them.
+type IChunkWriter interface {
+	WriteChunk(chunkLoc ChunkXz, chunkTag nbt.ITag) os.Error
+}
+
+// IChunkVersions is implemented by chunk stores that write each chunk over the
+// stored version that they last read or wrote. The versions move with chunks
+// handed off to another chunk server, so that the new holder writes over the
+// versions written by the old one.
+type IChunkVersions interface {
+	// ChunkVersion returns the stored version of the chunk, once earlier
+	// writes have finished. ok is false if the version isn't known.
+	ChunkVersion(chunkLoc ChunkXz) (version storage.Version, ok bool)
+
+	// SetChunkVersion sets the stored version that the chunk is next written
+	// over. It returns false if the store doesn't keep versions.
+	SetChunkVersion(chunkLoc ChunkXz, version storage.Version) (ok bool)
 }
 
 type IChunkReader interface {
@@ -78,3 +104,15 @@ type NoSuchChunkError bool
 func (err NoSuchChunkError) String() string {
 	return "Chunk does not exist."
 }
+
+type ReadOnlyStoreError bool
+
+func (err ReadOnlyStoreError) String() string {
+	return "Chunk store can't write chunks."
+}
+
+type UnknownVersionError bool
+
+func (err UnknownVersionError) String() string {
+	return "Chunk's stored version is not known."
+}
@@ -0,0 +1,140 @@
+package client
+
+import (
+	"io"
+	"math"
+	"os"
+	"time"
+
+	"chunkymonkey/proto"
+	. "chunkymonkey/types"
+)
+
+const (
+	// The height of the player's eyes above their feet.
+	stanceNormal = 1.62
+
+	// How far the client walks each tick, a little under the official
+	// client's walking speed.
+	walkBlocksPerTick = 0.2
+
+	tickNs = NanosecondsInSecond / TicksPerSecond
+)
+
+var (
+	ErrClosed    = os.NewError("connection closed")
+	ErrTimeout   = os.NewError("timed out")
+	ErrEmptyHand = os.NewError("nothing held")
+)
+
+// WaitSpawn waits for the server to place the player in the world, for up to
+// timeoutNs nanoseconds.
+func (client *Client) WaitSpawn(timeoutNs int64) os.Error {
+	select {
+	case <-client.spawnedChan:
+		return nil
+	case <-client.done:
+		return ErrClosed
+	case <-time.After(timeoutNs):
+	}
+	return ErrTimeout
+}
+
+// Chat sends a chat message, or a command if it starts with "/".
+func (client *Client) Chat(message string) os.Error {
+	return client.send(func(writer io.Writer) os.Error {
+		return proto.WriteChatMessage(writer, message)
+	})
+}
+
+// WalkTo walks in a straight line to the target, a tick's walk at a time. It
+// follows the ground of the chunks that the server has sent, and keeps its
+// height elsewhere. Blocks in the way are walked over, as the server doesn't
+// check.
+func (client *Client) WalkTo(target AbsXyz) os.Error {
+	for {
+		position, look := client.Position()
+
+		dx, dz := target.X-position.X, target.Z-position.Z
+		distance := AbsCoord(math.Sqrt(float64(dx*dx + dz*dz)))
+		arrived := distance <= walkBlocksPerTick
+		if arrived {
+			position.X, position.Z = target.X, target.Z
+		} else {
+			position.X += dx * walkBlocksPerTick / distance
+			position.Z += dz * walkBlocksPerTick / distance
+			look.Yaw = AngleDegrees(math.Atan2(float64(-dx), float64(dz)) * 180 / math.Pi)
+		}
+
+		if y, ok := client.GroundAt(position.ToBlockXyz()); ok {
+			position.Y = y
+		} else if arrived {
+			position.Y = target.Y
+		}
+
+		if err := client.move(&position, &look); err != nil {
+			return err
+		}
+		if arrived {
+			return nil
+		}
+
+		select {
+		case <-client.done:
+			return ErrClosed
+		case <-time.After(tickNs):
+		}
+	}
+	return nil
+}
+
+// move tells the server that the player has moved.
+func (client *Client) move(position *AbsXyz, look *LookDegrees) os.Error {
+	client.lock.Lock()
+	client.position = *position
+	client.look = *look
+	client.lock.Unlock()
+
+	return client.send(func(writer io.Writer) os.Error {
+		return proto.ClientWritePlayerPositionLook(writer, position, position.Y+stanceNormal, look, true)
+	})
+}
+
+// Dig digs out the block at loc, hitting it on the given face. The server
+// decides whether the block breaks.
+func (client *Client) Dig(loc BlockXyz, face Face) os.Error {
+	return client.send(func(writer io.Writer) os.Error {
+		if err := proto.WritePlayerBlockHit(writer, DigStarted, &loc, face); err != nil {
+			return err
+		}
+		return proto.WritePlayerBlockHit(writer, DigBlockBroke, &loc, face)
+	})
+}
+
+// Place uses the held item on the given face of the block at loc, which
+// places it against that face if it is a block.
+func (client *Client) Place(loc BlockXyz, face Face) os.Error {
+	held := client.Held()
+	if held.ItemTypeId < 0 || held.Count <= 0 {
+		return ErrEmptyHand
+	}
+
+	return client.send(func(writer io.Writer) os.Error {
+		return proto.WritePlayerBlockInteract(writer, held.ItemTypeId, &loc, face, held.Count, held.Data)
+	})
+}
+
+// Hold switches the held item to the given hotbar slot, from 0 to 8.
+func (client *Client) Hold(slot SlotId) os.Error {
+	if slot < 0 || int(slot) >= inventorySize-inventoryHotbarSlot {
+		return os.NewError("no such hotbar slot")
+	}
+
+	client.lock.Lock()
+	client.holding = slot
+	client.lock.Unlock()
+
+	return client.send(func(writer io.Writer) os.Error {
+		return proto.WriteHoldingChange(writer, slot)
+	})
+}
@@ -0,0 +1,211 @@
+// Package client connects to a server as a player, keeps track of what the
+// server says about the world, and acts in it. It is used to run headless bots
+// against a server.
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+
+	"chunkymonkey/proto"
+	. "chunkymonkey/types"
+)
+
+// The number of chat messages held for Messages before further ones are
+// dropped.
+const messageBufferSize = 64
+
+// Client is a connection to a server as a player. Its methods may be called
+// from any goroutine.
+type Client struct {
+	username string
+	conn     net.Conn
+	codec    *proto.Codec
+
+	sendLock sync.Mutex
+
+	// What the server has told the client. Updated by the receive loop.
+	lock      sync.Mutex
+	entityId  EntityId
+	dimension DimensionId
+	gameMode  GameMode
+	health    Health
+	food      int16
+	position  AbsXyz
+	look      LookDegrees
+	spawned   bool
+	chunks    map[uint64]*chunk
+	entities  map[EntityId]*Entity
+	inventory [inventorySize]proto.WindowSlot
+	holding   SlotId // Hotbar slot held, from 0 to 8.
+	stats     Stats
+
+	messages    chan string
+	spawnedChan chan bool // Closed once the player is placed in the world.
+
+	// Closed when the connection ends, at which point err holds why.
+	done chan bool
+	err  os.Error
+}
+
+// Stats counts what the client has received from the server.
+type Stats struct {
+	Packets int64
+	Bytes   int64
+}
+
+// Dial connects to the server at addr and logs in as username, speaking the
+// given protocol version. Only servers that don't authenticate players with
+// minecraft.net can be logged in to.
+func Dial(addr, username string, version int32) (client *Client, err os.Error) {
+	codec, ok := proto.CodecForVersion(version)
+	if !ok {
+		return nil, fmt.Errorf("unsupported protocol version %d", version)
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return
+	}
+
+	if client, err = Login(conn, username, codec); err != nil {
+		conn.Close()
+	}
+	return
+}
+
+// Login logs in as username over conn, and starts receiving packets from the
+// server. The client is placed in the world some time after it returns (see
+// WaitSpawn).
+func Login(conn net.Conn, username string, codec *proto.Codec) (client *Client, err os.Error) {
+	if err = proto.ClientWriteHandshake(conn, username); err != nil {
+		return
+	}
+
+	reader := bufio.NewReader(conn)
+
+	serverId, err := proto.ClientReadHandshake(reader)
+	if err != nil {
+		return
+	}
+	if serverId != "-" {
+		return nil, os.NewError("server requires minecraft.net authentication")
+	}
+
+	if err = codec.ClientWriteLogin(conn, username); err != nil {
+		return
+	}
+
+	client = &Client{
+		username:    username,
+		conn:        conn,
+		codec:       codec,
+		entityId:    NoEntityId,
+		chunks:      make(map[uint64]*chunk),
+		entities:    make(map[EntityId]*Entity),
+		messages:    make(chan string, messageBufferSize),
+		spawnedChan: make(chan bool),
+		done:        make(chan bool),
+	}
+	for i := range client.inventory {
+		client.inventory[i].ItemTypeId = -1
+	}
+
+	// The server's login packet is read along with everything else.
+	go client.receiveLoop(reader)
+
+	return
+}
+
+// Username returns the name that the client logged in with.
+func (client *Client) Username() string {
+	return client.username
+}
+
+// Close disconnects from the server, and waits for the receive loop to stop.
+func (client *Client) Close() {
+	client.send(func(writer io.Writer) os.Error {
+		return proto.WriteDisconnect(writer, "Quitting")
+	})
+	client.conn.Close()
+	<-client.done
+}
+
+// Done returns a channel that is closed when the connection to the server
+// ends.
+func (client *Client) Done() <-chan bool {
+	return client.done
+}
+
+// Err returns why the connection ended. It must only be called once Done is
+// closed.
+func (client *Client) Err() os.Error {
+	return client.err
+}
+
+// Messages returns a channel of the chat messages sent by the server. Messages
+// are dropped if they are not taken from it quickly enough.
+func (client *Client) Messages() <-chan string {
+	return client.messages
+}
+
+// Stats returns what the client has received so far.
+func (client *Client) Stats() Stats {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	return client.stats
+}
+
+func (client *Client) receiveLoop(reader io.Reader) {
+	defer close(client.done)
+
+	counter := &countingReader{reader: reader}
+	handler := &packetHandler{client}
+
+	for {
+		if err := client.codec.ClientReadPacket(counter, handler); err != nil {
+			if client.err == nil {
+				client.err = err
+			}
+			client.conn.Close()
+			return
+		}
+
+		client.lock.Lock()
+		client.stats.Packets++
+		client.stats.Bytes = counter.count
+		client.lock.Unlock()
+	}
+}
+
+// send writes the packets written by fn to the server together.
+func (client *Client) send(fn func(writer io.Writer) os.Error) os.Error {
+	buf := new(bytes.Buffer)
+	if err := fn(buf); err != nil {
+		return err
+	}
+
+	client.sendLock.Lock()
+	defer client.sendLock.Unlock()
+
+	_, err := client.conn.Write(buf.Bytes())
+	return err
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (r *countingReader) Read(p []byte) (n int, err os.Error) {
+	n, err = r.reader.Read(p)
+	r.count += int64(n)
+	return
+}
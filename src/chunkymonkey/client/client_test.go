@@ -0,0 +1,111 @@
+package client
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"chunkymonkey/proto"
+	. "chunkymonkey/types"
+)
+
+// fakeServer logs the client in over conn, and sends it the given packets.
+// Everything that the client sends after logging in is discarded.
+func fakeServer(conn net.Conn, packets []byte) os.Error {
+	if _, err := proto.ServerReadHandshake(conn); err != nil {
+		return err
+	}
+	if err := proto.ServerWriteHandshake(conn, "-"); err != nil {
+		return err
+	}
+	if _, _, err := proto.ServerReadLogin(conn); err != nil {
+		return err
+	}
+
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			if _, err := conn.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	_, err := conn.Write(packets)
+	return err
+}
+
+func TestClient(t *testing.T) {
+	codec, _ := proto.CodecForVersion(proto.ProtocolVersionBeta18)
+
+	// A chunk with a pillar of stone from the bottom to y=64.
+	blocks := make([]byte, chunkBlocks)
+	for y := SubChunkCoord(0); y <= 64; y++ {
+		index, _ := (&SubChunkXyz{1, y, 2}).BlockIndex()
+		index.SetBlockId(blocks, 1)
+	}
+	blockData := make([]byte, chunkBlocks>>1)
+
+	packets := new(bytes.Buffer)
+	codec.ServerWriteLogin(packets, 7, 0, GameModeSurvival, DimensionNormal)
+	proto.WritePreChunk(packets, &ChunkXz{0, 0}, ChunkInit)
+	proto.WriteMapChunk(packets, &ChunkXz{0, 0}, blocks, blockData, blockData, blockData)
+	proto.ServerWritePlayerPositionLook(packets, &AbsXyz{1.5, 65, 2.5}, 65+stanceNormal, &LookDegrees{90, 0}, false)
+	proto.WriteNamedEntitySpawn(packets, 8, "bob", &AbsIntXyz{64, 2080, 64}, &LookBytes{}, 0)
+	proto.WriteEntityRelMove(packets, 8, &RelMove{16, 0, -8})
+	proto.WriteBlockChange(packets, &BlockXyz{1, 64, 2}, BlockIdAir, 0)
+	proto.WriteWindowSetSlot(packets, WindowIdInventory, inventoryHotbarSlot, 4, 10, 0)
+	proto.WriteChatMessage(packets, "done")
+
+	clientConn, serverConn := net.Pipe()
+	serverErr := make(chan os.Error, 1)
+	go func() {
+		serverErr <- fakeServer(serverConn, packets.Bytes())
+	}()
+
+	client, err := Login(clientConn, "alice", codec)
+	if err != nil {
+		t.Fatalf("Error logging in: %v", err)
+	}
+	defer client.Close()
+
+	if err := client.WaitSpawn(5 * NanosecondsInSecond); err != nil {
+		t.Fatalf("Error waiting to spawn: %v", err)
+	}
+	select {
+	case <-client.Messages():
+	case <-time.After(5 * NanosecondsInSecond):
+		t.Fatalf("Timed out waiting for chat message")
+	}
+	if err := <-serverErr; err != nil {
+		t.Fatalf("Server error: %v", err)
+	}
+
+	if entityId := client.EntityId(); entityId != 7 {
+		t.Errorf("Expected entity ID 7, got %d", entityId)
+	}
+	if position, _ := client.Position(); position.X != 1.5 || position.Y != 65 || position.Z != 2.5 {
+		t.Errorf("Expected to be at (1.5, 65, 2.5), got %v", position)
+	}
+
+	if blockId, _, ok := client.BlockAt(&BlockXyz{1, 63, 2}); !ok || blockId != 1 {
+		t.Errorf("Expected stone, got %d (known %t)", blockId, ok)
+	}
+	if y, ok := client.GroundAt(&BlockXyz{1, 0, 2}); !ok || y != 64 {
+		t.Errorf("Expected ground at 64 after block change, got %v (known %t)", y, ok)
+	}
+	if _, _, ok := client.BlockAt(&BlockXyz{16, 64, 2}); ok {
+		t.Errorf("Expected block in unsent chunk to be unknown")
+	}
+
+	entities := client.Entities()
+	if len(entities) != 1 || entities[0].Name != "bob" || entities[0].Position.X != 80 || entities[0].Position.Z != 56 {
+		t.Errorf("Expected bob to have moved, got %v", entities)
+	}
+
+	if held := client.Held(); held.ItemTypeId != 4 || held.Count != 10 {
+		t.Errorf("Expected to hold 10 cobblestone, got %v", held)
+	}
+}
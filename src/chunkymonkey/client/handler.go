@@ -0,0 +1,321 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"chunkymonkey/proto"
+	. "chunkymonkey/types"
+)
+
+// packetHandler applies the packets from the server to the client. Its
+// methods are called from the client's receive loop.
+type packetHandler struct {
+	client *Client
+}
+
+func (h *packetHandler) ClientPacketLogin(entityId EntityId, mapSeed RandomSeed, gameMode GameMode, dimension DimensionId) {
+	client := h.client
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	client.entityId = entityId
+	client.gameMode = gameMode
+	client.dimension = dimension
+}
+
+func (h *packetHandler) PacketKeepAlive(id int32) {
+	h.client.send(func(writer io.Writer) os.Error {
+		return h.client.codec.WriteKeepAlive(writer, id)
+	})
+}
+
+func (h *packetHandler) PacketChatMessage(message string) {
+	select {
+	case h.client.messages <- message:
+	default:
+	}
+}
+
+func (h *packetHandler) PacketDisconnect(reason string) {
+	h.client.err = fmt.Errorf("disconnected by server: %s", reason)
+}
+
+func (h *packetHandler) PacketRespawn(dimension DimensionId) {
+	client := h.client
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	// The client is sent the chunks and entities of the new dimension afresh.
+	client.dimension = dimension
+	client.chunks = make(map[uint64]*chunk)
+	client.entities = make(map[EntityId]*Entity)
+}
+
+// PacketPlayerPosition is the first half of the server placing the player.
+// The look follows in PacketPlayerLook.
+func (h *packetHandler) PacketPlayerPosition(position *AbsXyz, stance AbsCoord, onGround bool) {
+	client := h.client
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	client.position = *position
+}
+
+func (h *packetHandler) PacketPlayerLook(look *LookDegrees, onGround bool) {
+	client := h.client
+	client.lock.Lock()
+	client.look = *look
+	position := client.position
+	spawned := client.spawned
+	client.spawned = true
+	client.lock.Unlock()
+
+	// Confirm the position, as the official client does.
+	client.send(func(writer io.Writer) os.Error {
+		return proto.ClientWritePlayerPositionLook(writer, &position, position.Y+stanceNormal, look, false)
+	})
+
+	if !spawned {
+		close(client.spawnedChan)
+	}
+}
+
+func (h *packetHandler) PacketUpdateHealth(health Health, food int16, foodSaturation float32) {
+	client := h.client
+	client.lock.Lock()
+	client.health = health
+	client.food = food
+	dimension, gameMode := client.dimension, client.gameMode
+	client.lock.Unlock()
+
+	if health <= 0 {
+		client.send(func(writer io.Writer) os.Error {
+			return client.codec.WriteRespawn(writer, dimension, gameMode)
+		})
+	}
+}
+
+func (h *packetHandler) PacketPreChunk(chunkLoc *ChunkXz, mode ChunkLoadMode) {
+	client := h.client
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	// The chunk's blocks are known once its map chunk packet arrives.
+	if mode == ChunkUnload {
+		client.chunks[chunkLoc.ChunkKey()] = nil, false
+	}
+}
+
+func (h *packetHandler) PacketMapChunk(position *BlockXyz, size *SubChunkSize, data []byte) {
+	if size.X != ChunkSizeH-1 || size.Y != ChunkSizeY-1 || size.Z != ChunkSizeH-1 || position.Y != 0 {
+		// The server only sends whole chunks.
+		log.Printf("%s: ignoring map chunk of size %v at %v", h.client.username, *size, *position)
+		return
+	}
+
+	c, err := readChunkData(data)
+	if err != nil {
+		log.Printf("%s: bad map chunk at %v: %v", h.client.username, *position, err)
+		return
+	}
+
+	chunkLoc, _ := position.ToChunkLocal()
+
+	client := h.client
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	client.chunks[chunkLoc.ChunkKey()] = c
+}
+
+func (h *packetHandler) PacketBlockChangeMulti(chunkLoc *ChunkXz, blockCoords []SubChunkXyz, blockTypes []BlockId, blockMetaData []byte) {
+	client := h.client
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	for i := range blockCoords {
+		client.setBlock(chunkLoc.ToBlockXyz(&blockCoords[i]), blockTypes[i], blockMetaData[i])
+	}
+}
+
+func (h *packetHandler) PacketBlockChange(blockLoc *BlockXyz, blockType BlockId, blockMetaData byte) {
+	client := h.client
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	client.setBlock(blockLoc, blockType, blockMetaData)
+}
+
+func (h *packetHandler) PacketNamedEntitySpawn(entityId EntityId, name string, position *AbsIntXyz, look *LookBytes, currentItem ItemTypeId) {
+	h.addEntity(&Entity{EntityId: entityId, Name: name, Position: *position, Look: *look})
+}
+
+func (h *packetHandler) PacketItemSpawn(entityId EntityId, itemTypeId ItemTypeId, count ItemCount, data ItemData, position *AbsIntXyz, orientation *OrientationBytes) {
+	h.addEntity(&Entity{EntityId: entityId, ItemTypeId: itemTypeId, Position: *position})
+}
+
+func (h *packetHandler) PacketObjectSpawn(entityId EntityId, objType ObjTypeId, position *AbsIntXyz, objectData *proto.ObjectData) {
+	h.addEntity(&Entity{EntityId: entityId, Position: *position})
+}
+
+func (h *packetHandler) PacketEntitySpawn(entityId EntityId, mobType EntityMobType, position *AbsIntXyz, look *LookBytes, data []proto.EntityMetadata) {
+	h.addEntity(&Entity{EntityId: entityId, MobType: mobType, Position: *position, Look: *look})
+}
+
+func (h *packetHandler) PacketPaintingSpawn(entityId EntityId, title string, position *BlockXyz, paintingType PaintingTypeId) {
+	h.addEntity(&Entity{
+		EntityId: entityId,
+		Position: AbsIntXyz{
+			AbsIntCoord(position.X) * PixelsPerBlock,
+			AbsIntCoord(position.Y) * PixelsPerBlock,
+			AbsIntCoord(position.Z) * PixelsPerBlock,
+		},
+	})
+}
+
+func (h *packetHandler) addEntity(entity *Entity) {
+	client := h.client
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	client.entities[entity.EntityId] = entity
+}
+
+func (h *packetHandler) PacketEntityDestroy(entityId EntityId) {
+	h.removeEntity(entityId)
+}
+
+func (h *packetHandler) PacketItemCollect(collectedItem EntityId, collector EntityId) {
+	h.removeEntity(collectedItem)
+}
+
+func (h *packetHandler) removeEntity(entityId EntityId) {
+	client := h.client
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	client.entities[entityId] = nil, false
+}
+
+func (h *packetHandler) PacketEntityRelMove(entityId EntityId, movement *RelMove) {
+	client := h.client
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	if entity, ok := client.entities[entityId]; ok {
+		entity.Position.IAdd(AbsIntCoord(movement.X), AbsIntCoord(movement.Y), AbsIntCoord(movement.Z))
+	}
+}
+
+func (h *packetHandler) PacketEntityLook(entityId EntityId, look *LookBytes) {
+	client := h.client
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	if entity, ok := client.entities[entityId]; ok {
+		entity.Look = *look
+	}
+}
+
+func (h *packetHandler) PacketEntityTeleport(entityId EntityId, position *AbsIntXyz, look *LookBytes) {
+	client := h.client
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	if entity, ok := client.entities[entityId]; ok {
+		entity.Position = *position
+		entity.Look = *look
+	}
+}
+
+func (h *packetHandler) PacketWindowItems(windowId WindowId, items []proto.WindowSlot) {
+	if windowId != WindowIdInventory {
+		return
+	}
+
+	client := h.client
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	copy(client.inventory[:], items)
+}
+
+func (h *packetHandler) PacketWindowSetSlot(windowId WindowId, slot SlotId, itemTypeId ItemTypeId, amount ItemCount, data ItemData) {
+	if windowId != WindowIdInventory || slot < 0 || int(slot) >= inventorySize {
+		return
+	}
+
+	client := h.client
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	client.inventory[slot] = proto.WindowSlot{ItemTypeId: itemTypeId, Count: amount, Data: data}
+}
+
+// Packets that the client has no use for.
+
+func (h *packetHandler) PacketEntityAction(entityId EntityId, action EntityAction) {}
+
+func (h *packetHandler) PacketUseEntity(user EntityId, target EntityId, leftClick bool) {}
+
+func (h *packetHandler) PacketPlayerBlockHit(status DigStatus, blockLoc *BlockXyz, face Face) {}
+
+func (h *packetHandler) PacketPlayerBlockInteract(itemTypeId ItemTypeId, blockLoc *BlockXyz, face Face, amount ItemCount, data ItemData) {
+}
+
+func (h *packetHandler) PacketEntityAnimation(entityId EntityId, animation EntityAnimation) {}
+
+func (h *packetHandler) PacketUnknown0x1b(field1, field2 float32, field3, field4 bool, field5, field6 float32) {
+}
+
+func (h *packetHandler) PacketUnknown0x3d(field1, field2 int32, field3 int8, field4, field5 int32) {}
+
+func (h *packetHandler) PacketWindowTransaction(windowId WindowId, txId TxId, accepted bool) {}
+
+func (h *packetHandler) PacketSignUpdate(position *BlockXyz, lines [4]string) {}
+
+func (h *packetHandler) PacketTimeUpdate(time Ticks) {}
+
+func (h *packetHandler) PacketBedUse(entityId EntityId, flag bool, bedLoc *BlockXyz) {}
+
+func (h *packetHandler) PacketEntityEquipment(entityId EntityId, slot SlotId, itemTypeId ItemTypeId, data ItemData) {
+}
+
+func (h *packetHandler) PacketSpawnPosition(position *BlockXyz) {}
+
+func (h *packetHandler) PacketExperience(experience, level int8, totalExperience int16) {}
+
+func (h *packetHandler) PacketEntityVelocity(entityId EntityId, velocity *Velocity) {}
+
+func (h *packetHandler) PacketEntity(entityId EntityId) {}
+
+func (h *packetHandler) PacketEntityStatus(entityId EntityId, status EntityStatus) {}
+
+func (h *packetHandler) PacketEntityAttach(entityId EntityId, vehicleId EntityId) {}
+
+func (h *packetHandler) PacketEntityMetadata(entityId EntityId, metadata []proto.EntityMetadata) {}
+
+func (h *packetHandler) PacketNoteBlockPlay(position *BlockXyz, instrument InstrumentId, pitch NotePitch) {
+}
+
+func (h *packetHandler) PacketExplosion(position *AbsXyz, power float32, blockOffsets []proto.ExplosionOffsetXyz) {
+}
+
+func (h *packetHandler) PacketBedInvalid(field1 byte) {}
+
+func (h *packetHandler) PacketWeather(entityId EntityId, raining bool, position *AbsIntXyz) {}
+
+func (h *packetHandler) PacketWindowOpen(windowId WindowId, invTypeId InvTypeId, windowTitle string, numSlots byte) {
+}
+
+func (h *packetHandler) PacketWindowProgressBar(windowId WindowId, prgBarId PrgBarId, value PrgBarValue) {
+}
+
+func (h *packetHandler) PacketUnknown0x83(field1, field2 int16, field3 string) {}
+
+func (h *packetHandler) PacketIncrementStatistic(statisticId StatisticId, delta int8) {}
+
+func (h *packetHandler) PacketPlayerListItem(name string, online bool, ping int16) {}
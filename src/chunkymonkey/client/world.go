@@ -0,0 +1,173 @@
+package client
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"os"
+
+	"chunkymonkey/proto"
+	. "chunkymonkey/types"
+)
+
+const (
+	// The number of slots in the player's inventory window, and the first of
+	// the hotbar's slots within it.
+	inventorySize       = 45
+	inventoryHotbarSlot = 36
+
+	chunkBlocks = ChunkSizeH * ChunkSizeH * ChunkSizeY
+)
+
+// chunk holds the blocks of a chunk, as sent by the server.
+type chunk struct {
+	blocks    []byte
+	blockData []byte
+}
+
+func newChunk() *chunk {
+	return &chunk{
+		blocks:    make([]byte, chunkBlocks),
+		blockData: make([]byte, chunkBlocks>>1),
+	}
+}
+
+// readChunkData reads the blocks of a whole chunk from the compressed data of
+// a map chunk packet. The light levels that follow are discarded.
+func readChunkData(data []byte) (c *chunk, err os.Error) {
+	reader, err := zlib.NewReader(bytes.NewBuffer(data))
+	if err != nil {
+		return
+	}
+	defer reader.Close()
+
+	c = newChunk()
+	if _, err = io.ReadFull(reader, c.blocks); err != nil {
+		return nil, err
+	}
+	if _, err = io.ReadFull(reader, c.blockData); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Entity is what the client knows of an entity other than itself.
+type Entity struct {
+	EntityId   EntityId
+	Name       string        // Set for players.
+	MobType    EntityMobType // Set for mobs.
+	ItemTypeId ItemTypeId    // Set for items.
+	Position   AbsIntXyz
+	Look       LookBytes
+}
+
+// EntityId returns the client's own entity ID, or NoEntityId if it hasn't
+// logged in yet.
+func (client *Client) EntityId() EntityId {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	return client.entityId
+}
+
+// Position returns where the client is, and where it is looking.
+func (client *Client) Position() (position AbsXyz, look LookDegrees) {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	return client.position, client.look
+}
+
+// Health returns the client's health and food levels.
+func (client *Client) Health() (health Health, food int16) {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	return client.health, client.food
+}
+
+// BlockAt returns the block at the given location. ok is false if the server
+// hasn't sent the chunk that it is in.
+func (client *Client) BlockAt(loc *BlockXyz) (blockId BlockId, data byte, ok bool) {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	c, index, ok := client.chunkAt(loc)
+	if !ok {
+		return
+	}
+	return index.BlockId(c.blocks), index.BlockData(c.blockData), true
+}
+
+// GroundAt returns the height of the top of the highest block that isn't air
+// in the column containing loc. ok is false if the server hasn't sent the chunk
+// that it is in.
+func (client *Client) GroundAt(loc *BlockXyz) (y AbsCoord, ok bool) {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	column := *loc
+	for column.Y = ChunkSizeY - 1; column.Y >= 0; column.Y-- {
+		c, index, ok := client.chunkAt(&column)
+		if !ok {
+			return 0, false
+		}
+		if index.BlockId(c.blocks) != BlockIdAir {
+			return AbsCoord(column.Y) + 1, true
+		}
+	}
+	return 0, true
+}
+
+// Entities returns the entities that the server has shown the client.
+func (client *Client) Entities() []Entity {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	entities := make([]Entity, 0, len(client.entities))
+	for _, entity := range client.entities {
+		entities = append(entities, *entity)
+	}
+	return entities
+}
+
+// Inventory returns the slots of the player's inventory window. Empty slots
+// have an ItemTypeId of -1.
+func (client *Client) Inventory() []proto.WindowSlot {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	inventory := make([]proto.WindowSlot, len(client.inventory))
+	copy(inventory, client.inventory[:])
+	return inventory
+}
+
+// Held returns the contents of the hotbar slot that the player is holding.
+func (client *Client) Held() proto.WindowSlot {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	return client.inventory[inventoryHotbarSlot+int(client.holding)]
+}
+
+// chunkAt returns the chunk containing loc, and the index of loc within it.
+// The client's lock must be held.
+func (client *Client) chunkAt(loc *BlockXyz) (c *chunk, index BlockIndex, ok bool) {
+	if loc.Y < 0 {
+		return
+	}
+	chunkLoc, subLoc := loc.ToChunkLocal()
+	if c, ok = client.chunks[chunkLoc.ChunkKey()]; !ok {
+		return
+	}
+	index, ok = subLoc.BlockIndex()
+	return
+}
+
+// setBlock records a change to a block. The client's lock must be held.
+func (client *Client) setBlock(loc *BlockXyz, blockId BlockId, data byte) {
+	if c, index, ok := client.chunkAt(loc); ok {
+		index.SetBlockId(c.blocks, blockId)
+		index.SetBlockData(c.blockData, data)
+	}
+}
@@ -1,11 +1,13 @@
 package command
 
 import (
+	"os"
 	"testing"
 
 	"gomock.googlecode.com/hg/gomock"
 
 	"chunkymonkey/gamerules"
+	"chunkymonkey/types"
 	"testmatcher"
 )
 
@@ -53,4 +55,41 @@ func TestCommandFramework(t *testing.T) {
 		mockPlayer.EXPECT().EchoMessage("Description: Shows a list of all commands."),
 	)
 	cf.Process(mockPlayer, "/help help", mockGame)
+
+	mockGame.EXPECT().WorldTime().Return(types.Ticks(30000))
+	mockPlayer.EXPECT().EchoMessage("The time is 30000 (6000 into the day)")
+	cf.Process(mockPlayer, "/time", mockGame)
+
+	mockGame.EXPECT().WorldTime().Return(types.Ticks(30000))
+	mockGame.EXPECT().SetWorldTime(types.Ticks(37800))
+	mockPlayer.EXPECT().EchoMessage("Set the time to 37800")
+	cf.Process(mockPlayer, "/time set night", mockGame)
+
+	mockGame.EXPECT().WorldTime().Return(types.Ticks(30000))
+	mockGame.EXPECT().SetWorldTime(types.Ticks(30100))
+	mockPlayer.EXPECT().EchoMessage("Set the time to 30100")
+	cf.Process(mockPlayer, "/time add 100", mockGame)
+
+	mockPlayer.EXPECT().EchoMessage("time [set <ticks|day|night>|add <ticks>]")
+	cf.Process(mockPlayer, "/time set noon", mockGame)
+
+	mockGame.EXPECT().SetWeather(types.WeatherThunder)
+	mockPlayer.EXPECT().EchoMessage("Set the weather to thunder")
+	cf.Process(mockPlayer, "/weather thunder", mockGame)
+
+	mockPlayer.EXPECT().EchoMessage("weather <clear|rain|thunder>")
+	cf.Process(mockPlayer, "/weather snow", mockGame)
+
+	mockGame.EXPECT().WorldNames().Return([]string{"creative", "world"})
+	mockPlayer.EXPECT().EchoMessage("Worlds: creative, world")
+	cf.Process(mockPlayer, "/world", mockGame)
+
+	mockPlayer.EXPECT().GetEntityId().Return(types.EntityId(5))
+	mockGame.EXPECT().MovePlayerToWorld(types.EntityId(5), "creative")
+	cf.Process(mockPlayer, "/world creative", mockGame)
+
+	mockPlayer.EXPECT().GetEntityId().Return(types.EntityId(5))
+	mockGame.EXPECT().MovePlayerToWorld(types.EntityId(5), "nowhere").Return(os.NewError("There is no world named \"nowhere\"."))
+	mockPlayer.EXPECT().EchoMessage("There is no world named \"nowhere\".")
+	cf.Process(mockPlayer, "/world nowhere", mockGame)
 }
@@ -17,6 +17,9 @@ func getCommands() map[string]*Command {
 	cmds[killCmd] = NewCommand(killCmd, killDesc, killUsage, cmdKill)
 	cmds[tellCmd] = NewCommand(tellCmd, tellDesc, tellUsage, cmdTell)
 	cmds[giveCmd] = NewCommand(giveCmd, giveDesc, giveUsage, cmdGive)
+	cmds[timeCmd] = NewCommand(timeCmd, timeDesc, timeUsage, cmdTime)
+	cmds[weatherCmd] = NewCommand(weatherCmd, weatherDesc, weatherUsage, cmdWeather)
+	cmds[worldCmd] = NewCommand(worldCmd, worldDesc, worldUsage, cmdWorld)
 	return cmds
 }
 
@@ -222,3 +225,103 @@ func cmdGive(player gamerules.IPlayerClient, message string, cmdHandler gamerule
 		target.EchoMessage(msg)
 	}
 }
+
+// /time [set <ticks|day|night>|add <ticks>]
+const timeCmd = "time"
+const timeUsage = "time [set <ticks|day|night>|add <ticks>]"
+const timeDesc = "Shows or changes the time of day in the world."
+
+func cmdTime(player gamerules.IPlayerClient, message string, cmdHandler gamerules.IGame) {
+	args := strings.Split(message, " ")
+	if len(args) == 1 {
+		now := cmdHandler.WorldTime()
+		player.EchoMessage(fmt.Sprintf("The time is %d (%d into the day)", now, now.TimeOfDay()))
+		return
+	}
+	if len(args) != 3 {
+		player.EchoMessage(timeUsage)
+		return
+	}
+
+	var newTime Ticks
+	switch args[1] {
+	case "set":
+		switch args[2] {
+		case "day":
+			newTime = TimeOfDayDawnEnd
+		case "night":
+			newTime = TimeOfDayDuskEnd
+		default:
+			ticks, err := strconv.Atoi64(args[2])
+			if err != nil {
+				player.EchoMessage(timeUsage)
+				return
+			}
+			newTime = Ticks(ticks)
+		}
+		// Keep the day count, so that only the time of day changes.
+		now := cmdHandler.WorldTime()
+		newTime += now - now.TimeOfDay()
+	case "add":
+		ticks, err := strconv.Atoi64(args[2])
+		if err != nil {
+			player.EchoMessage(timeUsage)
+			return
+		}
+		newTime = cmdHandler.WorldTime() + Ticks(ticks)
+	default:
+		player.EchoMessage(timeUsage)
+		return
+	}
+
+	cmdHandler.SetWorldTime(newTime)
+	player.EchoMessage(fmt.Sprintf("Set the time to %d", newTime))
+}
+
+// /weather <clear|rain|thunder>
+const weatherCmd = "weather"
+const weatherUsage = "weather <clear|rain|thunder>"
+const weatherDesc = "Changes the weather in the world."
+
+func cmdWeather(player gamerules.IPlayerClient, message string, cmdHandler gamerules.IGame) {
+	args := strings.Split(message, " ")
+	if len(args) != 2 {
+		player.EchoMessage(weatherUsage)
+		return
+	}
+
+	var weather Weather
+	switch args[1] {
+	case "clear":
+		weather = WeatherClear
+	case "rain":
+		weather = WeatherRain
+	case "thunder":
+		weather = WeatherThunder
+	default:
+		player.EchoMessage(weatherUsage)
+		return
+	}
+
+	cmdHandler.SetWeather(weather)
+	player.EchoMessage(fmt.Sprintf("Set the weather to %v", weather))
+}
+
+// /world [<name>]
+const worldCmd = "world"
+const worldUsage = "world [<name>]"
+const worldDesc = "Lists the worlds on the server, or moves you to the named world."
+
+func cmdWorld(player gamerules.IPlayerClient, message string, cmdHandler gamerules.IGame) {
+	args := strings.Split(message, " ")
+	switch len(args) {
+	case 1:
+		player.EchoMessage("Worlds: " + strings.Join(cmdHandler.WorldNames(), ", "))
+	case 2:
+		if err := cmdHandler.MovePlayerToWorld(player.GetEntityId(), args[1]); err != nil {
+			player.EchoMessage(err.String())
+		}
+	default:
+		player.EchoMessage(worldUsage)
+	}
+}
@@ -1,6 +1,7 @@
 package entity
 
 import (
+	"math"
 	"sync"
 
 	. "chunkymonkey/types"
@@ -8,24 +9,46 @@ import (
 
 type EntityManager struct {
 	nextEntityId EntityId
+	firstId      EntityId
+	lastId       EntityId
 	entities     map[EntityId]bool
 	lock         sync.Mutex
 }
 
 func (mgr *EntityManager) Init() {
+	mgr.InitRange(math.MinInt32, math.MaxInt32)
+
 	mgr.lock.Lock()
 	defer mgr.lock.Unlock()
-
 	mgr.nextEntityId = 0
+}
+
+// InitRange initializes the manager to only create EntityIds from firstId to
+// lastId inclusive. This allows separate processes that create entities (such
+// as chunk servers) to share the EntityId space without clashing.
+func (mgr *EntityManager) InitRange(firstId, lastId EntityId) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	mgr.nextEntityId = firstId
+	mgr.firstId = firstId
+	mgr.lastId = lastId
 	mgr.entities = make(map[EntityId]bool)
 }
 
+func (mgr *EntityManager) followingId(entityId EntityId) EntityId {
+	if entityId >= mgr.lastId {
+		return mgr.firstId
+	}
+	return entityId + 1
+}
+
 func (mgr *EntityManager) createEntityId() EntityId {
 	// Search for next free ID
 	entityId := mgr.nextEntityId
 	_, exists := mgr.entities[entityId]
 	for exists {
-		entityId++
+		entityId = mgr.followingId(entityId)
 		if entityId == mgr.nextEntityId {
 			// TODO Better handling of this? It shouldn't happen, realistically - but
 			// neither should it explode.
@@ -33,7 +56,7 @@ func (mgr *EntityManager) createEntityId() EntityId {
 		}
 		_, exists = mgr.entities[entityId]
 	}
-	mgr.nextEntityId = entityId + 1
+	mgr.nextEntityId = mgr.followingId(entityId)
 
 	return entityId
 }
@@ -57,3 +80,17 @@ func (mgr *EntityManager) RemoveEntityById(entityId EntityId) {
 
 	mgr.entities[entityId] = false, false
 }
+
+// AddEntityId adds an entity with the given EntityId to the manager, such as
+// one that was created by another process. It returns false if the EntityId
+// is already in use.
+func (mgr *EntityManager) AddEntityId(entityId EntityId) bool {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	if mgr.entities[entityId] {
+		return false
+	}
+	mgr.entities[entityId] = true
+	return true
+}
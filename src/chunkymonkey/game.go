@@ -3,11 +3,14 @@ package chunkymonkey
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"net"
 	"os"
 	"rand"
 	"regexp"
+	"sort"
 	"time"
 
 	"chunkymonkey/command"
@@ -16,25 +19,54 @@ import (
 	"chunkymonkey/player"
 	"chunkymonkey/proto"
 	"chunkymonkey/server_auth"
+	"chunkymonkey/shardlookup"
 	"chunkymonkey/shardserver"
+	"chunkymonkey/storage"
 	. "chunkymonkey/types"
-	"chunkymonkey/worldstore"
 	"nbt"
 )
 
+// How long all players must be asleep for before the night is skipped.
+const minSleepTicks = 100
+
+// How often the world time and weather are written to the level data.
+const levelDataSaveInterval = 60 * TicksPerSecond
+
+// The range of EntityIds given to players, and to entities in shards hosted in
+// this process. It must not overlap with the ranges of chunk servers, which
+// start at 1<<30 by default, or of other frontends sharing the world. They may
+// only be changed before NewGame is called.
+var (
+	FirstEntityId = EntityId(1)
+	LastEntityId  = EntityId(1<<30 - 1)
+)
+
 // We regard usernames as valid if they don't contain "dangerous" characters.
 // That is: characters that might be abused in filename components, etc.
 var validPlayerUsername = regexp.MustCompile(`^[\-a-zA-Z0-9_]+$`)
 
 type Game struct {
-	chunkManager  *shardserver.LocalShardManager
 	entityManager EntityManager
-	worldStore    *worldstore.WorldStore
+
+	// The worlds hosted by the game, by name. Players are stored in, and first
+	// log in to, the default world.
+	worlds       map[string]*world
+	defaultWorld *world
 
 	// Mapping between entityId/name and player object
 	players     map[EntityId]*player.Player
 	playerNames map[string]*player.Player
 
+	// The time at which each sleeping player fell asleep.
+	sleepers map[EntityId]Ticks
+
+	// The latest round trip time, in milliseconds, of each player's
+	// connection, as shown in the player list.
+	pings map[EntityId]int16
+
+	// The ID of the last keep-alive sent to players.
+	keepAliveId int32
+
 	// Channels for events/actions
 	workQueue        chan func(*Game)
 	playerConnect    chan *player.Player
@@ -42,32 +74,86 @@ type Game struct {
 
 	// Server information
 	time                Ticks
+	weather             weather
+	rand                *rand.Rand
 	serverId            string
 	UnderMaintenanceMsg string // if set, logins are disallowed.
 }
 
-func NewGame(worldPath string) (game *Game, err os.Error) {
-	worldStore, err := worldstore.LoadWorldStore(worldPath)
-	if err != nil {
-		return nil, err
+// NewGame creates a game for the worlds at worldPaths, the first of which is
+// the default world. If lookupServerAddr is set, the shards of chunks in the
+// world are hosted by the chunk servers that the lookup server at that address
+// assigns them to. Otherwise, if chunkServerAddr is set, they are all hosted
+// by the chunk server at that address. If neither is set, they are hosted in
+// this process, and there may be more than one world.
+func NewGame(worldPaths []string, chunkServerAddr string, lookupServerAddr string, storageServerAddr string) (game *Game, err os.Error) {
+	if len(worldPaths) == 0 {
+		return nil, os.NewError("no worlds given")
+	}
+	if len(worldPaths) > 1 && (chunkServerAddr != "" || lookupServerAddr != "") {
+		// Chunk servers only know of shards by their dimension.
+		return nil, os.NewError("multiple worlds can only be hosted in this process")
+	}
+
+	var store storage.IStorage
+	if storageServerAddr != "" {
+		store = storage.NewRemoteStorage(storageServerAddr)
+	}
+
+	var lookup shardlookup.IShardLookup
+	if lookupServerAddr != "" {
+		lookup = shardlookup.NewRemoteLookup(lookupServerAddr)
 	}
 
 	game = &Game{
+		worlds:           make(map[string]*world),
 		players:          make(map[EntityId]*player.Player),
 		playerNames:      make(map[string]*player.Player),
+		sleepers:         make(map[EntityId]Ticks),
+		pings:            make(map[EntityId]int16),
 		workQueue:        make(chan func(*Game), 256),
 		playerConnect:    make(chan *player.Player),
 		playerDisconnect: make(chan EntityId),
-		time:             worldStore.Time,
-		worldStore:       worldStore,
+		rand:             rand.New(rand.NewSource(time.UTC().Seconds())),
 	}
 
-	game.entityManager.Init()
+	game.entityManager.InitRange(FirstEntityId, LastEntityId)
+
+	for i, worldPath := range worldPaths {
+		// Only the default world uses the storage service, as it doesn't
+		// distinguish chunks in different worlds.
+		worldStore := store
+		if i > 0 {
+			worldStore = nil
+		}
+
+		var w *world
+		if w, err = loadWorld(worldPath, worldStore, &game.entityManager, lookup, chunkServerAddr); err != nil {
+			return nil, err
+		}
+		if _, ok := game.worlds[w.name]; ok {
+			return nil, fmt.Errorf("more than one world named %q", w.name)
+		}
+		game.worlds[w.name] = w
+		if game.defaultWorld == nil {
+			game.defaultWorld = w
+		}
+	}
 
-	game.serverId = fmt.Sprintf("%016x", rand.NewSource(worldStore.Seed).Int63())
+	game.time = game.defaultWorld.store.Time
+	game.weather = weather{game.defaultWorld.store.Weather}
+
+	game.serverId = fmt.Sprintf("%016x", rand.NewSource(game.defaultWorld.store.Seed).Int63())
 	//game.serverId = "-"
 
-	game.chunkManager = shardserver.NewLocalShardManager(worldStore.ChunkStore, &game.entityManager)
+	if lookup == nil && chunkServerAddr == "" {
+		worldManagers := make(map[string]map[DimensionId]shardserver.IShardManager)
+		for name, w := range game.worlds {
+			worldManagers[name] = w.shardManagers
+		}
+		shardserver.PublishShardStats(worldManagers)
+	}
+	game.setWorldState()
 
 	// TODO: Load the prefix from a config file
 	gamerules.CommandFramework = command.NewCommandFramework("/")
@@ -98,6 +184,28 @@ func (game *Game) mainLoop() {
 func (game *Game) onPlayerConnect(newPlayer *player.Player) {
 	game.players[newPlayer.GetEntityId()] = newPlayer
 	game.playerNames[newPlayer.Name()] = newPlayer
+
+	buf := new(bytes.Buffer)
+	codec := newPlayer.Codec()
+	if game.weather.Raining {
+		codec.WriteBedInvalid(buf, proto.BedInvalidReasonRainStart)
+	}
+
+	// The new player is told of everyone in the game, and everyone else is
+	// told of the new player.
+	for entityId, p := range game.players {
+		codec.WritePlayerListItem(buf, p.Name(), true, game.pings[entityId])
+	}
+	game.multicastCodecPacket(func(codec *proto.Codec, writer io.Writer) {
+		codec.WritePlayerListItem(writer, newPlayer.Name(), true, 0)
+	}, proto.PacketPriorityHigh, newPlayer)
+
+	// The packets are sent from the player's main loop so that they follow the
+	// login packet.
+	packet := buf.Bytes()
+	newPlayer.Enqueue(func(p *player.Player) {
+		p.TransmitPacket(packet, proto.PacketPriorityHigh)
+	})
 }
 
 // A player has disconnected from the server
@@ -105,19 +213,138 @@ func (game *Game) onPlayerDisconnect(entityId EntityId) {
 	oldPlayer := game.players[entityId]
 	game.players[entityId] = nil, false
 	game.playerNames[oldPlayer.Name()] = nil, false
+	game.sleepers[entityId] = 0, false
+	game.pings[entityId] = 0, false
 	game.entityManager.RemoveEntityById(entityId)
 
+	game.multicastCodecPacket(func(codec *proto.Codec, writer io.Writer) {
+		codec.WritePlayerListItem(writer, oldPlayer.Name(), false, 0)
+	}, proto.PacketPriorityHigh, nil)
+
+	if oldPlayer.Transferred() {
+		// The frontend that the player was transferred to writes their data.
+		return
+	}
+
 	playerData := oldPlayer.WriteNbt()
 
-	if err := game.worldStore.WritePlayerData(oldPlayer.Name(), playerData); err != nil {
+	if err := game.defaultWorld.store.WritePlayerData(oldPlayer.Name(), playerData); err != nil {
 		log.Printf("Failed when writing player data: %s", err)
 	}
 }
 
 func (game *Game) onTick() {
 	game.time++
+
+	if game.weather.tick(game.rand) {
+		game.onWeatherChange()
+	}
+
+	if game.weather.Weather() == WeatherThunder {
+		game.lightningTick()
+	}
+
+	game.sleepTick()
+
 	if game.time%TicksPerSecond == 0 {
 		game.sendTimeUpdate()
+		game.sendKeepAlives()
+		game.setWorldState()
+	}
+
+	if game.time%player.FoodTickInterval == 0 {
+		for _, p := range game.players {
+			p.FoodTick()
+		}
+	}
+
+	if game.time%levelDataSaveInterval == 0 {
+		game.saveLevelData()
+	}
+}
+
+// onWeatherChange informs players and shards of a change in the weather.
+func (game *Game) onWeatherChange() {
+	game.sendWeather()
+	game.setWorldState()
+}
+
+// lightningTick randomly strikes lightning near each player during a
+// thunderstorm.
+func (game *Game) lightningTick() {
+	for _, p := range game.players {
+		if game.rand.Intn(lightningChancePerPlayer) != 0 {
+			continue
+		}
+		dx := BlockCoord(game.rand.Intn(2*lightningRadius+1) - lightningRadius)
+		dz := BlockCoord(game.rand.Intn(2*lightningRadius+1) - lightningRadius)
+
+		// The player's position is only known to its main loop. A strike is
+		// skipped if it has fallen behind.
+		p.TryEnqueue(func(p *player.Player) {
+			if p.Dimension() != DimensionNormal {
+				return
+			}
+			pos := p.Position()
+			blockLoc := pos.ToBlockXyz()
+			// The worlds are not modified after NewGame, so are safe to read here.
+			if w, ok := game.worlds[p.World()]; ok {
+				w.shardManagers[DimensionNormal].StrikeLightning(blockLoc.X+dx, blockLoc.Z+dz)
+			}
+		})
+	}
+}
+
+// sleepTick skips the night once every player has been asleep for long
+// enough, and wakes sleeping players when the day comes.
+func (game *Game) sleepTick() {
+	if len(game.sleepers) == 0 {
+		return
+	}
+
+	if !game.time.IsNight() {
+		game.wakeSleepers()
+		return
+	}
+
+	if len(game.sleepers) < len(game.players) {
+		return
+	}
+	for _, since := range game.sleepers {
+		if game.time-since < minSleepTicks {
+			return
+		}
+	}
+
+	// Skip to the next morning, which also brings an end to any rain.
+	game.time += TicksPerDay - game.time.TimeOfDay()
+	if game.weather.Weather() != WeatherClear {
+		game.weather.set(WeatherClear, game.rand)
+		game.sendWeather()
+	}
+	game.sendTimeUpdate()
+	game.setWorldState()
+
+	game.wakeSleepers()
+}
+
+// wakeSleepers gets all sleeping players out of bed.
+func (game *Game) wakeSleepers() {
+	for entityId := range game.sleepers {
+		if player, ok := game.players[entityId]; ok {
+			player.WakeUp()
+		}
+		game.sleepers[entityId] = 0, false
+	}
+}
+
+// saveLevelData writes the world time and weather to the level data of each
+// world.
+func (game *Game) saveLevelData() {
+	for _, w := range game.worlds {
+		if err := w.store.WriteLevelData(game.time, &game.weather.WeatherData); err != nil {
+			log.Printf("Failed when writing level data for world %q: %s", w.name, err)
+		}
 	}
 }
 
@@ -139,6 +366,12 @@ func (game *Game) login(conn net.Conn) {
 		}
 	}()
 
+	// A client that stops part way through logging in is dropped. The player's
+	// receive loop sets the timeout again once they have logged in.
+	if player.IdleTimeout > 0 {
+		conn.SetReadTimeout(player.IdleTimeout)
+	}
+
 	var username string
 	if username, err = proto.ServerReadHandshake(conn); err != nil {
 		clientErr = os.NewError("Handshake error.")
@@ -190,7 +423,8 @@ func (game *Game) login(conn net.Conn) {
 		log.Print("Client ", conn.RemoteAddr(), " passed minecraft.net authentication")
 	}
 
-	if _, err = proto.ServerReadLogin(conn); err != nil {
+	var codec *proto.Codec
+	if _, codec, err = proto.ServerReadLogin(conn); err != nil {
 		clientErr = os.NewError("Login error.")
 		return
 	}
@@ -198,12 +432,12 @@ func (game *Game) login(conn net.Conn) {
 	entityId := game.entityManager.NewEntity()
 
 	var playerData nbt.ITag
-	if playerData, err = game.worldStore.PlayerData(username); err != nil {
+	if playerData, err = game.defaultWorld.store.PlayerData(username); err != nil {
 		clientErr = os.NewError("Error reading user data. Please contact the server administrator.")
 		return
 	}
 
-	player := player.NewPlayer(entityId, game.chunkManager, conn, username, game.worldStore.SpawnPosition, game.playerDisconnect, game)
+	player := player.NewPlayer(entityId, conn, codec, username, game.defaultWorld.name, game.defaultWorld.store.SpawnPosition, game.playerDisconnect, game)
 	if playerData != nil {
 		if err = player.ReadNbt(playerData); err != nil {
 			// Don't let the player log in, as they will only have default inventory
@@ -215,6 +449,14 @@ func (game *Game) login(conn net.Conn) {
 		}
 	}
 
+	// The worlds map is not modified after NewGame, so is safe to read here.
+	w, ok := game.worlds[player.World()]
+	if !ok {
+		log.Printf("Player %s was in unknown world %q, moving to %q", username, player.World(), game.defaultWorld.name)
+		w = game.defaultWorld
+	}
+	player.SetWorld(w.name, w.store.SpawnPosition, w.store.GameMode)
+
 	game.playerConnect <- player
 	player.Start()
 }
@@ -239,27 +481,85 @@ func (game *Game) Serve(addr string) {
 
 // Utility functions
 
-// Send a time/keepalive packet
+// Send the time to every player
 func (game *Game) sendTimeUpdate() {
 	buf := new(bytes.Buffer)
 	proto.ServerWriteTimeUpdate(buf, game.time)
+	game.multicastPacket(buf.Bytes(), proto.PacketPriorityHigh, nil)
+}
+
+// sendKeepAlives sends a keep-alive to every player. Each player measures the
+// round trip time of their connection from the reply.
+func (game *Game) sendKeepAlives() {
+	game.keepAliveId++
+	if game.keepAliveId <= 0 {
+		// Zero is the ID that clients before ProtocolVersionBeta18 reply with.
+		game.keepAliveId = 1
+	}
+
+	for _, p := range game.players {
+		p.SendKeepAlive(game.keepAliveId)
+	}
+}
+
+// setWorldState informs the shards in each dimension of each world of the
+// current time and weather. It only ever rains in the normal dimension.
+func (game *Game) setWorldState() {
+	for _, w := range game.worlds {
+		for dimension, mgr := range w.shardManagers {
+			weather := WeatherClear
+			if dimension == DimensionNormal {
+				weather = game.weather.Weather()
+			}
+			mgr.SetWorldState(game.time, weather)
+		}
+	}
+}
 
-	// The "keep-alive" packet to client(s) sent here as well, as there
-	// seems no particular reason to send time and keep-alive separately
-	// for now.
-	proto.WriteKeepAlive(buf)
+// Send the rain state to every player. Clients don't distinguish thunder from
+// rain beyond the lightning strikes they see.
+func (game *Game) sendWeather() {
+	var reason byte = proto.BedInvalidReasonRainStop
+	if game.weather.Raining {
+		reason = proto.BedInvalidReasonRainStart
+	}
 
-	game.multicastPacket(buf.Bytes(), nil)
+	game.multicastCodecPacket(func(codec *proto.Codec, writer io.Writer) {
+		codec.WriteBedInvalid(writer, reason)
+	}, proto.PacketPriorityNormal, nil)
 }
 
 // Send a packet to every player connected to the server
-func (game *Game) multicastPacket(packet []byte, except interface{}) {
+func (game *Game) multicastPacket(packet []byte, priority proto.PacketPriority, except interface{}) {
 	for _, player := range game.players {
 		if player == except {
 			continue
 		}
 
-		player.TransmitPacket(packet)
+		player.TransmitPacket(packet, priority)
+	}
+}
+
+// Send a packet to every player connected to the server, where the packet
+// differs between protocol versions. write is called once for each version
+// that players are connected with.
+func (game *Game) multicastCodecPacket(write func(codec *proto.Codec, writer io.Writer), priority proto.PacketPriority, except interface{}) {
+	packets := make(map[*proto.Codec][]byte)
+	for _, player := range game.players {
+		if player == except {
+			continue
+		}
+
+		codec := player.Codec()
+		packet, ok := packets[codec]
+		if !ok {
+			buf := new(bytes.Buffer)
+			write(codec, buf)
+			packet = buf.Bytes()
+			packets[codec] = packet
+		}
+
+		player.TransmitPacket(packet, priority)
 	}
 }
 
@@ -276,7 +576,7 @@ func (game *Game) BroadcastMessage(msg string) {
 	proto.WriteChatMessage(buf, msg)
 
 	game.enqueue(func(_ *Game) {
-		game.multicastPacket(buf.Bytes(), nil)
+		game.multicastPacket(buf.Bytes(), proto.PacketPriorityHigh, nil)
 	})
 }
 
@@ -312,3 +612,109 @@ func (game *Game) PlayerByName(name string) gamerules.IPlayerClient {
 	})
 	return <-result
 }
+
+func (game *Game) WorldTime() Ticks {
+	result := make(chan Ticks)
+	game.enqueue(func(_ *Game) {
+		result <- game.time
+		close(result)
+	})
+	return <-result
+}
+
+func (game *Game) SetWorldTime(time Ticks) {
+	game.enqueue(func(_ *Game) {
+		game.time = time
+		game.sendTimeUpdate()
+		game.setWorldState()
+	})
+}
+
+func (game *Game) SetWeather(weather Weather) {
+	game.enqueue(func(_ *Game) {
+		prior := game.weather.Weather()
+		game.weather.set(weather, game.rand)
+		if prior != game.weather.Weather() {
+			game.onWeatherChange()
+		}
+	})
+}
+
+func (game *Game) ShardConnecter(worldName string, dimension DimensionId) gamerules.IShardConnecter {
+	// The worlds are not modified after NewGame, so are safe to read here.
+	if w, ok := game.worlds[worldName]; ok {
+		if mgr, ok := w.shardManagers[dimension]; ok {
+			return mgr
+		}
+	}
+	return nil
+}
+
+func (game *Game) WorldNames() []string {
+	names := make([]string, 0, len(game.worlds))
+	for name := range game.worlds {
+		names = append(names, name)
+	}
+	sort.SortStrings(names)
+	return names
+}
+
+func (game *Game) MovePlayerToWorld(entityId EntityId, worldName string) os.Error {
+	result := make(chan os.Error)
+	game.enqueue(func(_ *Game) {
+		result <- game.movePlayerToWorld(entityId, worldName)
+	})
+	return <-result
+}
+
+func (game *Game) movePlayerToWorld(entityId EntityId, worldName string) os.Error {
+	player, ok := game.players[entityId]
+	if !ok {
+		return os.NewError("Player is not logged in.")
+	}
+
+	permissions := gamerules.Permissions.UserPermissions(player.Name())
+	if !permissions.Has("admin.commands.world") {
+		return os.NewError("You do not have permission to change world.")
+	}
+
+	w, ok := game.worlds[worldName]
+	if !ok {
+		return fmt.Errorf("There is no world named %q.", worldName)
+	}
+
+	player.ChangeWorld(w.name, w.store.SpawnPosition, w.store.GameMode)
+	return nil
+}
+
+func (game *Game) SetPlayerLatency(entityId EntityId, latency int64) {
+	game.enqueue(func(_ *Game) {
+		p, ok := game.players[entityId]
+		if !ok {
+			return
+		}
+
+		ping := int16(latency / 1e6)
+		if latency/1e6 > math.MaxInt16 {
+			ping = math.MaxInt16
+		}
+		game.pings[entityId] = ping
+
+		game.multicastCodecPacket(func(codec *proto.Codec, writer io.Writer) {
+			codec.WritePlayerListItem(writer, p.Name(), true, ping)
+		}, proto.PacketPriorityHigh, nil)
+	})
+}
+
+func (game *Game) SetPlayerAsleep(entityId EntityId, asleep bool) {
+	game.enqueue(func(_ *Game) {
+		if _, ok := game.players[entityId]; !ok {
+			return
+		}
+		if asleep {
+			game.sleepers[entityId] = game.time
+		} else {
+			game.sleepers[entityId] = 0, false
+		}
+	})
+}
@@ -27,6 +27,18 @@ type IChunkBlock interface {
 
 	// AddActiveBlockIndex flags a block in the chunk itself as active by index.
 	AddActiveBlockIndex(blockIndex BlockIndex)
+
+	// SkyLight returns the current level of light from the sky, for the time
+	// of day and the weather. Intended for use by mob spawning and plant growth.
+	SkyLight() byte
+
+	// SkyLightAt returns the current level of light from the sky that reaches
+	// the given block in the chunk.
+	SkyLightAt(blockIndex BlockIndex) byte
+
+	// SetBlockAt sets a block in any loaded chunk in the same shard. Returns
+	// false if the block could not be set.
+	SetBlockAt(blockLoc *BlockXyz, blockId BlockId, blockData byte) bool
 }
 
 // IUnsubscribed is the interface by which blocks (and potentially other
@@ -0,0 +1,97 @@
+package gamerules
+
+import (
+	"math"
+	"os"
+
+	. "chunkymonkey/types"
+)
+
+// Bed block metadata. The lower two bits are the direction from the foot of
+// the bed to its head.
+const (
+	BedDataDirectionMask = 0x3
+	BedDataOccupied      = 0x4
+	BedDataHead          = 0x8
+)
+
+// BedDirectionFromYaw returns the direction (as stored in bed metadata) that a
+// bed placed by someone looking in the given direction faces.
+func BedDirectionFromYaw(yaw AngleDegrees) byte {
+	return byte(int(math.Floor(float64(yaw)*4/360+0.5)) & BedDataDirectionMask)
+}
+
+// BedHeadOffset returns the offset from the foot of a bed to its head for the
+// given bed metadata.
+func BedHeadOffset(data byte) (dx, dz BlockCoord) {
+	switch data & BedDataDirectionMask {
+	case 0:
+		dz = 1
+	case 1:
+		dx = -1
+	case 2:
+		dz = -1
+	case 3:
+		dx = 1
+	}
+	return
+}
+
+// bedOtherHalf returns the location of the other half of the bed that the
+// given block is part of.
+func bedOtherHalf(blockLoc *BlockXyz, data byte) *BlockXyz {
+	dx, dz := BedHeadOffset(data)
+	if data&BedDataHead != 0 {
+		dx, dz = -dx, -dz
+	}
+	return blockLoc.AddXyz(dx, 0, dz)
+}
+
+func makeBedAspect() (aspect IBlockAspect) {
+	return &BedAspect{}
+}
+
+// BedAspect is the behaviour of the two blocks that make up a bed. Players
+// can sleep in a bed at night, which also sets where they respawn. Getting into
+// bed is handled by the chunk, which can reach both halves of the bed.
+type BedAspect struct {
+	BreakOn DigStatus
+}
+
+func (aspect *BedAspect) setAttrs(blockAttrs *BlockAttrs) {
+}
+
+func (aspect *BedAspect) Name() string {
+	return "Bed"
+}
+
+func (aspect *BedAspect) Check() os.Error {
+	return nil
+}
+
+func (aspect *BedAspect) Hit(instance *BlockInstance, player IPlayerClient, digStatus DigStatus) (destroyed bool) {
+	return aspect.BreakOn == digStatus
+}
+
+func (aspect *BedAspect) Interact(instance *BlockInstance, player IPlayerClient) {
+}
+
+func (aspect *BedAspect) InventoryClick(instance *BlockInstance, player IPlayerClient, click *Click) {
+}
+
+func (aspect *BedAspect) InventoryUnsubscribed(instance *BlockInstance, player IPlayerClient) {
+}
+
+func (aspect *BedAspect) Destroy(instance *BlockInstance) {
+	// Only the hit half of the bed is destroyed normally, so the other half is
+	// removed here without dropping a second bed.
+	if otherLoc := bedOtherHalf(&instance.BlockLoc, instance.Data); otherLoc != nil {
+		instance.Chunk.SetBlockAt(otherLoc, BlockIdAir, 0)
+	}
+
+	spawnItemInBlock(instance, ItemTypeIdBed, 1, 0)
+}
+
+func (aspect *BedAspect) Tick(instance *BlockInstance) bool {
+	return false
+}
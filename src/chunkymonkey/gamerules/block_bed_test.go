@@ -0,0 +1,56 @@
+package gamerules
+
+import (
+	"testing"
+
+	. "chunkymonkey/types"
+)
+
+func TestBedDirectionFromYaw(t *testing.T) {
+	type Test struct {
+		yaw      AngleDegrees
+		expected byte
+	}
+
+	tests := []Test{
+		{0, 0},
+		{44, 0},
+		{46, 1},
+		{90, 1},
+		{180, 2},
+		{270, 3},
+		{359, 0},
+		{-90, 3},
+		{450, 1},
+	}
+
+	for _, test := range tests {
+		result := BedDirectionFromYaw(test.yaw)
+		if result != test.expected {
+			t.Errorf("BedDirectionFromYaw(%v) expected %d got %d", test.yaw, test.expected, result)
+		}
+	}
+}
+
+func TestBedOtherHalf(t *testing.T) {
+	type Test struct {
+		loc      BlockXyz
+		data     byte
+		expected BlockXyz
+	}
+
+	tests := []Test{
+		{BlockXyz{0, 64, 0}, 0, BlockXyz{0, 64, 1}},
+		{BlockXyz{0, 64, 1}, 0 | BedDataHead, BlockXyz{0, 64, 0}},
+		{BlockXyz{5, 64, 5}, 1, BlockXyz{4, 64, 5}},
+		{BlockXyz{5, 64, 5}, 2 | BedDataOccupied, BlockXyz{5, 64, 4}},
+		{BlockXyz{5, 64, 5}, 3 | BedDataHead, BlockXyz{4, 64, 5}},
+	}
+
+	for _, test := range tests {
+		result := bedOtherHalf(&test.loc, test.data)
+		if result == nil || result.X != test.expected.X || result.Y != test.expected.Y || result.Z != test.expected.Z {
+			t.Errorf("bedOtherHalf(%v, %#x) expected %v got %v", test.loc, test.data, test.expected, result)
+		}
+	}
+}
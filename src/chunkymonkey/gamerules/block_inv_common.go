@@ -1,5 +1,19 @@
 package gamerules
 
+import (
+	"os"
+
+	"chunkymonkey/nbtutil"
+	. "chunkymonkey/types"
+	"nbt"
+)
+
+// iInventoryAspect is implemented by InventoryAspect, and aspects that embed
+// it.
+type iInventoryAspect interface {
+	blockInv(instance *BlockInstance, create bool) *blockInventory
+}
+
 // InventoryAspect is the common behaviour for blocks that have inventory.
 type InventoryAspect struct {
 	StandardAspect
@@ -56,3 +70,80 @@ func (aspect *InventoryAspect) blockInv(instance *BlockInstance, create bool) *b
 
 	return blkInv
 }
+
+// iProgressNbt is implemented by inventories with progress that moves with
+// them, such as a furnace's fuel and reaction.
+type iProgressNbt interface {
+	writeProgressNbt(tags map[string]nbt.ITag)
+	readProgressNbt(tag nbt.ITag) os.Error
+}
+
+// BlockInventoryNbt returns a block's inventory, given the block's extra data,
+// in the form of the tile entity of a chest or furnace in chunk files: the
+// "Items" list, and a furnace's progress. ok is false if the extra data is not
+// an inventory. Along with ReadBlockInventoryNbt, this allows block
+// inventories to move with their chunk between chunk servers.
+func BlockInventoryNbt(extra interface{}) (tag *nbt.Compound, ok bool) {
+	blkInv, ok := extra.(*blockInventory)
+	if !ok {
+		return
+	}
+
+	slots := blkInv.inv.MakeProtoSlots()
+	tags := make([]nbt.ITag, 0, len(slots))
+	for i, slot := range slots {
+		if slot.Count == 0 || slot.ItemTypeId == 0 {
+			continue
+		}
+		tags = append(tags, &nbt.Compound{
+			map[string]nbt.ITag{
+				"Slot":   &nbt.Byte{int8(i)},
+				"id":     &nbt.Short{int16(slot.ItemTypeId)},
+				"Count":  &nbt.Byte{int8(slot.Count)},
+				"Damage": &nbt.Short{int16(slot.Data)},
+			},
+		})
+	}
+
+	tag = &nbt.Compound{
+		map[string]nbt.ITag{
+			"Items": &nbt.List{nbt.TagCompound, tags},
+		},
+	}
+	if progress, ok := blkInv.inv.(iProgressNbt); ok {
+		progress.writeProgressNbt(tag.Tags)
+	}
+
+	return tag, true
+}
+
+// ReadBlockInventoryNbt creates the inventory for a block, holding the items
+// and progress returned by BlockInventoryNbt.
+func ReadBlockInventoryNbt(instance *BlockInstance, tag nbt.ITag) (err os.Error) {
+	aspect, ok := instance.BlockType.Aspect.(iInventoryAspect)
+	if !ok {
+		return os.NewError("block has no inventory")
+	}
+
+	itemList, ok := tag.Lookup("Items").(*nbt.List)
+	if !ok {
+		return os.NewError("bad inventory items")
+	}
+
+	blkInv := aspect.blockInv(instance, true)
+	for _, itemTag := range itemList.Value {
+		var slotId int8
+		if slotId, err = nbtutil.ReadByte(itemTag, "Slot"); err != nil {
+			return
+		}
+		if err = blkInv.inv.ReadNbtSlot(itemTag, SlotId(slotId)); err != nil {
+			return
+		}
+	}
+
+	if progress, ok := blkInv.inv.(iProgressNbt); ok {
+		err = progress.readProgressNbt(tag)
+	}
+
+	return
+}
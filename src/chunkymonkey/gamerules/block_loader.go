@@ -189,8 +189,10 @@ func LoadBlocksFromFile(filename string) (blockTypes BlockTypeList, err os.Error
 
 func init() {
 	aspectMakers = map[string]aspectMakerFn{
+		"Bed":       makeBedAspect,
 		"Chest":     makeChestAspect,
 		"Furnace":   makeFurnaceAspect,
+		"Rail":      makeRailAspect,
 		"Standard":  makeStandardAspect,
 		"Todo":      makeTodoAspect,
 		"Void":      makeVoidAspect,
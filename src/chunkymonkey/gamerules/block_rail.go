@@ -0,0 +1,197 @@
+package gamerules
+
+import (
+	"os"
+
+	. "chunkymonkey/types"
+)
+
+// Rail shapes, as stored in rail block metadata.
+const (
+	RailShapeAlongZ        = 0
+	RailShapeAlongX        = 1
+	RailShapeAscendingPosX = 2
+	RailShapeAscendingNegX = 3
+	RailShapeAscendingNegZ = 4
+	RailShapeAscendingPosZ = 5
+	RailShapeCurvePosZPosX = 6
+	RailShapeCurvePosZNegX = 7
+	RailShapeCurveNegZNegX = 8
+	RailShapeCurveNegZPosX = 9
+	railShapeMaxValid      = RailShapeCurveNegZPosX
+)
+
+// Rails that cannot curve (powered and detector rails) only use the lower bits
+// of their metadata for the shape, and the top bit as a flag. For powered
+// rails the flag indicates that the rail is powered.
+const (
+	RailDataShapeMask = 0x7
+	RailDataFlag      = 0x8
+)
+
+// RailDirection is a horizontal direction along which a rail leads.
+type RailDirection struct {
+	DX, DZ BlockCoord
+}
+
+var (
+	railPosX = RailDirection{1, 0}
+	railNegX = RailDirection{-1, 0}
+	railPosZ = RailDirection{0, 1}
+	railNegZ = RailDirection{0, -1}
+
+	// The directions in which rails can lead, in the order used by
+	// RailShapeAt.
+	railDirections = [4]RailDirection{railPosX, railNegX, railPosZ, railNegZ}
+)
+
+// railShapeExits maps the rail shape to the two directions that a rail of that
+// shape leads in.
+var railShapeExits = [railShapeMaxValid + 1][2]RailDirection{
+	RailShapeAlongZ:        {railPosZ, railNegZ},
+	RailShapeAlongX:        {railPosX, railNegX},
+	RailShapeAscendingPosX: {railPosX, railNegX},
+	RailShapeAscendingNegX: {railNegX, railPosX},
+	RailShapeAscendingNegZ: {railNegZ, railPosZ},
+	RailShapeAscendingPosZ: {railPosZ, railNegZ},
+	RailShapeCurvePosZPosX: {railPosZ, railPosX},
+	RailShapeCurvePosZNegX: {railPosZ, railNegX},
+	RailShapeCurveNegZNegX: {railNegZ, railNegX},
+	RailShapeCurveNegZPosX: {railNegZ, railPosX},
+}
+
+// IsRailBlock returns true if the block is any type of rail.
+func IsRailBlock(blockId BlockId) bool {
+	return blockId == BlockIdRail || blockId == BlockIdPoweredRail || blockId == BlockIdDetectorRail
+}
+
+// RailShape returns the shape of a rail block from its type and metadata.
+func RailShape(blockId BlockId, data byte) byte {
+	if blockId != BlockIdRail {
+		return data & RailDataShapeMask
+	}
+	return data
+}
+
+// RailExits returns the two directions in which a rail of the given shape
+// leads. For sloped rails, the first direction is uphill. ok is false for an
+// invalid shape.
+func RailExits(shape byte) (exits [2]RailDirection, ok bool) {
+	if shape > railShapeMaxValid {
+		return
+	}
+	return railShapeExits[shape], true
+}
+
+// RailAscent returns the uphill direction of a sloped rail. ok is false if the
+// rail is flat.
+func RailAscent(shape byte) (dir RailDirection, ok bool) {
+	if shape < RailShapeAscendingPosX || shape > RailShapeAscendingPosZ {
+		return
+	}
+	return railShapeExits[shape][0], true
+}
+
+// RailShapeAt chooses the shape for a rail placed at loc so that it joins up
+// with the rails around it. Rails join to neighbouring rails on the same
+// level, or one block higher (making a slope) or lower. Only rails that
+// canCurve will join to rails in two perpendicular directions.
+func RailShapeAt(loc BlockXyz, canCurve bool, query BlockIdQuery) byte {
+	var linked, raised [len(railDirections)]bool
+
+	for i, dir := range railDirections {
+		for dy := BlockYCoord(-1); dy <= 1; dy++ {
+			neighbour := loc.AddXyz(dir.DX, dy, dir.DZ)
+			if neighbour == nil {
+				continue
+			}
+			if blockId, ok := query(*neighbour); ok && IsRailBlock(blockId) {
+				linked[i] = true
+				raised[i] = dy == 1
+				break
+			}
+		}
+	}
+
+	posX, negX, posZ, negZ := 0, 1, 2, 3
+	linkedX := linked[posX] || linked[negX]
+	linkedZ := linked[posZ] || linked[negZ]
+
+	if canCurve && linkedX && linkedZ && !(linked[posX] && linked[negX]) && !(linked[posZ] && linked[negZ]) {
+		switch {
+		case linked[posZ] && linked[posX]:
+			return RailShapeCurvePosZPosX
+		case linked[posZ] && linked[negX]:
+			return RailShapeCurvePosZNegX
+		case linked[negZ] && linked[negX]:
+			return RailShapeCurveNegZNegX
+		default:
+			return RailShapeCurveNegZPosX
+		}
+	}
+
+	if linkedX && !linkedZ {
+		switch {
+		case raised[posX]:
+			return RailShapeAscendingPosX
+		case raised[negX]:
+			return RailShapeAscendingNegX
+		}
+		return RailShapeAlongX
+	}
+
+	switch {
+	case raised[posZ]:
+		return RailShapeAscendingPosZ
+	case raised[negZ]:
+		return RailShapeAscendingNegZ
+	}
+	return RailShapeAlongZ
+}
+
+func makeRailAspect() (aspect IBlockAspect) {
+	return &RailAspect{}
+}
+
+// RailAspect is the behaviour of the rail blocks that minecarts run along.
+// Breaking a rail drops it as an item. The shape of the rail is chosen when it
+// is placed (see RailShapeAt).
+type RailAspect struct {
+	blockAttrs *BlockAttrs
+	BreakOn    DigStatus
+	// CanCurve is true for rail types that can join rails at right angles.
+	CanCurve bool
+}
+
+func (aspect *RailAspect) setAttrs(blockAttrs *BlockAttrs) {
+	aspect.blockAttrs = blockAttrs
+}
+
+func (aspect *RailAspect) Name() string {
+	return "Rail"
+}
+
+func (aspect *RailAspect) Check() os.Error {
+	return nil
+}
+
+func (aspect *RailAspect) Hit(instance *BlockInstance, player IPlayerClient, digStatus DigStatus) (destroyed bool) {
+	return aspect.BreakOn == digStatus
+}
+
+func (aspect *RailAspect) Interact(instance *BlockInstance, player IPlayerClient) {
+}
+
+func (aspect *RailAspect) InventoryClick(instance *BlockInstance, player IPlayerClient, click *Click) {
+}
+
+func (aspect *RailAspect) InventoryUnsubscribed(instance *BlockInstance, player IPlayerClient) {
+}
+
+func (aspect *RailAspect) Destroy(instance *BlockInstance) {
+	spawnItemInBlock(instance, ItemTypeId(aspect.blockAttrs.id), 1, 0)
+}
+
+func (aspect *RailAspect) Tick(instance *BlockInstance) bool {
+	return false
+}
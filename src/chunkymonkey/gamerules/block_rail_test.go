@@ -0,0 +1,69 @@
+package gamerules
+
+import (
+	"testing"
+
+	. "chunkymonkey/types"
+)
+
+func TestRailShapeAt(t *testing.T) {
+	loc := BlockXyz{0, 64, 0}
+
+	type Test struct {
+		desc     string
+		rails    []BlockXyz
+		canCurve bool
+		expected byte
+	}
+
+	tests := []Test{
+		{"no neighbours", nil, true, RailShapeAlongZ},
+		{"one rail along X", []BlockXyz{{1, 64, 0}}, true, RailShapeAlongX},
+		{"rails either side along Z", []BlockXyz{{0, 64, 1}, {0, 64, -1}}, true, RailShapeAlongZ},
+		{"corner", []BlockXyz{{-1, 64, 0}, {0, 64, 1}}, true, RailShapeCurvePosZNegX},
+		{"other corner", []BlockXyz{{1, 64, 0}, {0, 64, -1}}, true, RailShapeCurveNegZPosX},
+		{"corner without curving", []BlockXyz{{-1, 64, 0}, {0, 64, 1}}, false, RailShapeAlongZ},
+		{"junction", []BlockXyz{{1, 64, 0}, {-1, 64, 0}, {0, 64, 1}}, true, RailShapeAlongZ},
+		{"rail above along X", []BlockXyz{{1, 65, 0}, {-1, 64, 0}}, true, RailShapeAscendingPosX},
+		{"rail above along Z", []BlockXyz{{0, 63, 1}, {0, 65, -1}}, false, RailShapeAscendingNegZ},
+		{"rail below", []BlockXyz{{-1, 63, 0}}, true, RailShapeAlongX},
+	}
+
+	for _, test := range tests {
+		rails := test.rails
+		query := func(queryLoc BlockXyz) (BlockId, bool) {
+			for _, rail := range rails {
+				if rail.X == queryLoc.X && rail.Y == queryLoc.Y && rail.Z == queryLoc.Z {
+					return BlockIdRail, true
+				}
+			}
+			return BlockIdAir, true
+		}
+
+		result := RailShapeAt(loc, test.canCurve, query)
+		if result != test.expected {
+			t.Errorf("%s: expected shape %d got %d", test.desc, test.expected, result)
+		}
+	}
+}
+
+func TestRailShape(t *testing.T) {
+	type Test struct {
+		blockId  BlockId
+		data     byte
+		expected byte
+	}
+
+	tests := []Test{
+		{BlockIdRail, RailShapeCurveNegZNegX, RailShapeCurveNegZNegX},
+		{BlockIdPoweredRail, RailShapeAscendingPosZ | RailDataFlag, RailShapeAscendingPosZ},
+		{BlockIdDetectorRail, RailShapeAlongX, RailShapeAlongX},
+	}
+
+	for _, test := range tests {
+		result := RailShape(test.blockId, test.data)
+		if result != test.expected {
+			t.Errorf("RailShape(%d, %#x) expected %d got %d", test.blockId, test.data, test.expected, result)
+		}
+	}
+}
@@ -18,12 +18,18 @@ type IEntity interface {
 	GetEntityId() EntityId
 	SendSpawn(io.Writer) os.Error
 	SendUpdate(io.Writer) os.Error
+	// SendTeleport writes the entity's position and look as last sent by
+	// SendSpawn or SendUpdate, in full.
+	SendTeleport(io.Writer) os.Error
 	Position() *AbsXyz
 }
 
 type INonPlayerEntity interface {
 	IEntity
 	ReadNbt(nbt.ITag) os.Error
+	// WriteNbt returns the entity's state in the form that ReadNbt reads, and
+	// that NewEntityFromNbt recreates it from.
+	WriteNbt() *nbt.Compound
 	SetEntityId(EntityId)
 	Tick(physics.IBlockQuerier) (leftBlock bool)
 }
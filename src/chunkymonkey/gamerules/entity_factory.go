@@ -1,7 +1,11 @@
 package gamerules
 
 import (
+	"fmt"
+	"os"
+
 	. "chunkymonkey/types"
+	"nbt"
 )
 
 // NewEntityByTypeName creates the appropriate entity type based on the input
@@ -34,6 +38,8 @@ func NewEntityByTypeName(typeName string) (entity INonPlayerEntity) {
 		entity = NewZombie()
 
 		// Objects
+	case "Arrow", "ThrownSnowball", "ThrownEgg":
+		entity = NewProjectile(ObjTypeMap[typeName], NoEntityId, false)
 	default:
 		// Handle all other objects
 		if objType, ok := ObjTypeMap[typeName]; ok {
@@ -45,3 +51,22 @@ func NewEntityByTypeName(typeName string) (entity INonPlayerEntity) {
 
 	return
 }
+
+// NewEntityFromNbt creates an entity from its NBT data, as found in chunk
+// files or returned by INonPlayerEntity.WriteNbt.
+func NewEntityFromNbt(tag nbt.ITag) (entity INonPlayerEntity, err os.Error) {
+	typeName, ok := tag.Lookup("id").(*nbt.String)
+	if !ok {
+		return nil, os.NewError("missing or bad entity type id")
+	}
+
+	if entity = NewEntityByTypeName(typeName.Value); entity == nil {
+		return nil, fmt.Errorf("unhandled entity type %q", typeName.Value)
+	}
+
+	if err = entity.ReadNbt(tag); err != nil {
+		return nil, err
+	}
+
+	return
+}
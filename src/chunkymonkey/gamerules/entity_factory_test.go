@@ -0,0 +1,169 @@
+package gamerules
+
+import (
+	"bytes"
+	"testing"
+
+	. "chunkymonkey/types"
+	"nbt"
+)
+
+// roundTripEntity writes the entity out as NBT data and reads it back in, as
+// happens to entities moving between shards in different processes.
+func roundTripEntity(t *testing.T, entity INonPlayerEntity) INonPlayerEntity {
+	buf := new(bytes.Buffer)
+	if err := nbt.Write(buf, entity.WriteNbt()); err != nil {
+		t.Fatalf("nbt.Write: %v", err)
+	}
+
+	tag, err := nbt.Read(buf)
+	if err != nil {
+		t.Fatalf("nbt.Read: %v", err)
+	}
+
+	result, err := NewEntityFromNbt(tag)
+	if err != nil {
+		t.Fatalf("NewEntityFromNbt: %v", err)
+	}
+
+	return result
+}
+
+func checkEntityMotion(t *testing.T, name string, got, want interface {
+	Position() *AbsXyz
+	Velocity() *AbsVelocity
+}) {
+	if p, wp := got.Position(), want.Position(); p.X != wp.X || p.Y != wp.Y || p.Z != wp.Z {
+		t.Errorf("%s: expected position %v, got %v", name, *wp, *p)
+	}
+	if v, wv := got.Velocity(), want.Velocity(); v.X != wv.X || v.Y != wv.Y || v.Z != wv.Z {
+		t.Errorf("%s: expected velocity %v, got %v", name, *wv, *v)
+	}
+}
+
+func TestItemNbtRoundTrip(t *testing.T) {
+	item := NewItem(ItemTypeIdArrow, 5, 3, &AbsXyz{1.5, 70, -2.25}, &AbsVelocity{0.5, -0.25, 0}, 10)
+
+	result, ok := roundTripEntity(t, item).(*Item)
+	if !ok {
+		t.Fatalf("expected *Item")
+	}
+
+	if result.ItemTypeId != item.ItemTypeId || result.Count != item.Count || result.Data != item.Data {
+		t.Errorf("expected item %v, got %v", item.Slot, result.Slot)
+	}
+	if result.PickupImmunity != item.PickupImmunity {
+		t.Errorf("expected pickup immunity %d, got %d", item.PickupImmunity, result.PickupImmunity)
+	}
+	checkEntityMotion(t, "item", result, item)
+}
+
+func TestMobNbtRoundTrip(t *testing.T) {
+	mobs := []IMob{
+		NewCreeper(),
+		NewSkeleton(),
+		NewSpider(),
+		NewZombie(),
+		NewPig(),
+		NewSheep(),
+		NewCow(),
+		NewHen(),
+		NewSquid(),
+		NewWolf(),
+	}
+
+	for _, entity := range mobs {
+		mob := entity.GetMob()
+		name := Mobs[mob.MobType()].Name
+		mob.PointObject.Init(&AbsXyz{10.5, 64, -3.5}, &AbsVelocity{0.1, 0, -0.1})
+		mob.SetLook(LookDegrees{90, -15})
+		mob.Damage(3)
+
+		result, ok := roundTripEntity(t, entity).(IMob)
+		if !ok {
+			t.Errorf("%s: expected a mob", name)
+			continue
+		}
+
+		resultMob := result.GetMob()
+		if resultMob.MobType() != mob.MobType() {
+			t.Errorf("%s: expected mob type %d, got %d", name, mob.MobType(), resultMob.MobType())
+		}
+		if resultMob.Health() != mob.Health() {
+			t.Errorf("%s: expected health %d, got %d", name, mob.Health(), resultMob.Health())
+		}
+		if resultMob.look.Yaw != mob.look.Yaw || resultMob.look.Pitch != mob.look.Pitch {
+			t.Errorf("%s: expected look %v, got %v", name, mob.look, resultMob.look)
+		}
+		checkEntityMotion(t, name, resultMob, mob)
+	}
+}
+
+func TestObjectNbtRoundTrip(t *testing.T) {
+	for typeName, objType := range ObjTypeMap {
+		if IsProjectileType(objType) {
+			continue
+		}
+
+		object := NewObject(objType)
+		object.PointObject.Init(&AbsXyz{-20.5, 65, 7.5}, &AbsVelocity{0, 0.5, 0})
+		if objType == ObjTypeIdBoat {
+			object.rider = 42
+		}
+
+		result, ok := roundTripEntity(t, object).(*Object)
+		if !ok {
+			t.Errorf("%s: expected *Object", typeName)
+			continue
+		}
+
+		if result.ObjTypeId != objType {
+			t.Errorf("%s: expected object type %d, got %d", typeName, objType, result.ObjTypeId)
+		}
+		if result.rider != object.rider {
+			t.Errorf("%s: expected rider %d, got %d", typeName, object.rider, result.rider)
+		}
+		checkEntityMotion(t, typeName, result, object)
+	}
+}
+
+func TestProjectileNbtRoundTrip(t *testing.T) {
+	stuckArrow := newTestProjectile(ObjTypeIdArrow, AbsXyz{0.5, 70.5, 0.5}, AbsVelocity{2, 0, 0})
+	stuckArrow.Tick(testBlockQuerier{{BlockXyz{2, 70, 0}, BlockId(1), 0}})
+	stuckArrow.Tick(testBlockQuerier{})
+
+	tests := []struct {
+		name       string
+		projectile *Projectile
+	}{
+		{"flying arrow", newTestProjectile(ObjTypeIdArrow, AbsXyz{0.5, 70, 0.5}, AbsVelocity{1, 0.5, 0})},
+		{"stuck arrow", stuckArrow},
+		{"skeleton arrow", NewProjectile(ObjTypeIdArrow, EntityId(7), false)},
+		{"snowball", newTestProjectile(ObjTypeIdThrownSnowball, AbsXyz{3, 80, 3}, AbsVelocity{0, -1, 0})},
+		{"egg", newTestProjectile(ObjTypeIdThrownEgg, AbsXyz{-3, 80, -3}, AbsVelocity{1, 0, 1})},
+	}
+
+	for _, test := range tests {
+		projectile := test.projectile
+
+		result, ok := roundTripEntity(t, projectile).(*Projectile)
+		if !ok {
+			t.Errorf("%s: expected *Projectile", test.name)
+			continue
+		}
+
+		if result.ObjTypeId != projectile.ObjTypeId {
+			t.Errorf("%s: expected object type %d, got %d", test.name, projectile.ObjTypeId, result.ObjTypeId)
+		}
+		if result.Shooter() != projectile.Shooter() {
+			t.Errorf("%s: expected shooter %d, got %d", test.name, projectile.Shooter(), result.Shooter())
+		}
+		if result.InFlight() != projectile.InFlight() || result.CanPickUp() != projectile.CanPickUp() {
+			t.Errorf("%s: expected in flight=%t and can pick up=%t", test.name, projectile.InFlight(), projectile.CanPickUp())
+		}
+		if result.stuckTime != projectile.stuckTime {
+			t.Errorf("%s: expected stuck time %d, got %d", test.name, projectile.stuckTime, result.stuckTime)
+		}
+		checkEntityMotion(t, test.name, result, projectile)
+	}
+}
@@ -1,7 +1,11 @@
 package gamerules
 
 import (
+	"os"
+
+	"chunkymonkey/nbtutil"
 	. "chunkymonkey/types"
+	"nbt"
 )
 
 const (
@@ -185,3 +189,36 @@ func (inv *FurnaceInventory) Tick() {
 		inv.sendProgressUpdates()
 	}
 }
+
+// writeProgressNbt writes the furnace's fuel and reaction progress, with the
+// names used by furnaces in chunk files. MaxBurnTime isn't in chunk files,
+// which work it out from the fuel.
+func (inv *FurnaceInventory) writeProgressNbt(tags map[string]nbt.ITag) {
+	tags["BurnTime"] = &nbt.Short{int16(inv.curFuel)}
+	tags["MaxBurnTime"] = &nbt.Short{int16(inv.maxFuel)}
+	tags["CookTime"] = &nbt.Short{int16(reactionDuration - inv.reactionRemaining)}
+}
+
+// readProgressNbt reads the progress written by writeProgressNbt.
+func (inv *FurnaceInventory) readProgressNbt(tag nbt.ITag) (err os.Error) {
+	burnTime, err := nbtutil.ReadShort(tag, "BurnTime")
+	if err != nil {
+		return
+	}
+	maxBurnTime, err := nbtutil.ReadShort(tag, "MaxBurnTime")
+	if err != nil {
+		return
+	}
+	cookTime, err := nbtutil.ReadShort(tag, "CookTime")
+	if err != nil {
+		return
+	}
+
+	inv.curFuel = Ticks(burnTime)
+	inv.maxFuel = Ticks(maxBurnTime)
+	inv.reactionRemaining = reactionDuration - Ticks(cookTime)
+	if inv.reactionRemaining < 0 || inv.reactionRemaining > reactionDuration {
+		inv.reactionRemaining = reactionDuration
+	}
+	return
+}
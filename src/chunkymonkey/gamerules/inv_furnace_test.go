@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	. "chunkymonkey/types"
+	"nbt"
 )
 
 const (
@@ -192,3 +193,25 @@ func Test_FurnaceBurnsMultipleFuel(t *testing.T) {
 	runner.runUntil(plankFuelTime * 2)
 	checkLit(t, furnace, false)
 }
+
+func Test_FurnaceProgressMovesWithNbt(t *testing.T) {
+	furnace, runner := loadedFurnace(t, 1, 1)
+	runner.runFor(100)
+
+	tags := make(map[string]nbt.ITag)
+	furnace.writeProgressNbt(tags)
+
+	moved := NewFurnaceInventory()
+	copy(moved.slots, furnace.slots)
+	if err := moved.readProgressNbt(&nbt.Compound{tags}); err != nil {
+		t.Fatalf("readProgressNbt: %v", err)
+	}
+	checkLit(t, moved, true)
+
+	// The reaction carries on from where it was, rather than restarting.
+	movedRunner := &furnaceRunner{t, moved, runner.curTicks}
+	movedRunner.runUntil(reactionDuration - 1)
+	checkSlot(t, emptySlot, moved.slots[furnaceSlotOutput])
+	movedRunner.runFor(1)
+	checkSlot(t, Slot{ironIngotId, 1, 0}, moved.slots[furnaceSlotOutput])
+}
@@ -127,6 +127,15 @@ func (inv *Inventory) Slot(slotId SlotId) Slot {
 	return inv.slots[slotId]
 }
 
+// SetSlot replaces the contents of a slot. Slot IDs out of range are ignored.
+func (inv *Inventory) SetSlot(slotId SlotId, item Slot) {
+	if slotId < 0 || int(slotId) >= len(inv.slots) {
+		return
+	}
+	inv.slots[slotId] = item
+	inv.slotUpdate(&inv.slots[slotId], slotId)
+}
+
 func (inv *Inventory) TakeOneItem(slotId SlotId, into *Slot) {
 	slot := &inv.slots[slotId]
 	if into.AddOne(slot) {
@@ -134,6 +143,18 @@ func (inv *Inventory) TakeOneItem(slotId SlotId, into *Slot) {
 	}
 }
 
+// TakeOneItemOfType takes one item of the given type from the first slot
+// containing any, and puts it in `into`.
+func (inv *Inventory) TakeOneItemOfType(itemTypeId ItemTypeId, into *Slot) {
+	for slotIndex := range inv.slots {
+		slot := &inv.slots[slotIndex]
+		if slot.Count > 0 && slot.ItemTypeId == itemTypeId {
+			inv.TakeOneItem(SlotId(slotIndex), into)
+			return
+		}
+	}
+}
+
 // PutItem attempts to put the given item into the inventory.
 func (inv *Inventory) PutItem(item *Slot) {
 	// TODO optimize this algorithm, maybe by maintaining a map of non-full
@@ -14,3 +14,27 @@ func TestInventory_Init(t *testing.T) {
 		}
 	}
 }
+
+func TestInventory_TakeOneItemOfType(t *testing.T) {
+	Items = make(ItemTypeMap)
+	makeItemType(261)
+	makeItemType(262)
+
+	var inv Inventory
+	inv.Init(3)
+	inv.slots[1] = Slot{ItemTypeId: 262, Count: 5}
+
+	var into Slot
+	inv.TakeOneItemOfType(261, &into)
+	if into.Count != 0 {
+		t.Errorf("Took item of wrong type: %+v", into)
+	}
+
+	inv.TakeOneItemOfType(262, &into)
+	if into.ItemTypeId != 262 || into.Count != 1 {
+		t.Errorf("Expected to take one item, got %+v", into)
+	}
+	if inv.slots[1].Count != 4 {
+		t.Errorf("Expected 4 items left, got %+v", inv.slots[1])
+	}
+}
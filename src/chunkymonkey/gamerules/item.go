@@ -55,9 +55,27 @@ func (item *Item) ReadNbt(tag nbt.ITag) (err os.Error) {
 		Data:       ItemData(data.Value),
 	}
 
+	if pickupDelay, ok := tag.Lookup("PickupDelay").(*nbt.Short); ok {
+		item.PickupImmunity = Ticks(pickupDelay.Value)
+	}
+
 	return nil
 }
 
+func (item *Item) WriteNbt() *nbt.Compound {
+	tag := item.PointObject.WriteNbt()
+	tag.Tags["id"] = &nbt.String{"Item"}
+	tag.Tags["Item"] = &nbt.Compound{
+		map[string]nbt.ITag{
+			"id":     &nbt.Short{int16(item.ItemTypeId)},
+			"Count":  &nbt.Byte{int8(item.Count)},
+			"Damage": &nbt.Short{int16(item.Data)},
+		},
+	}
+	tag.Tags["PickupDelay"] = &nbt.Short{int16(item.PickupImmunity)}
+	return tag
+}
+
 func (item *Item) GetSlot() *Slot {
 	return &item.Slot
 }
@@ -79,11 +97,10 @@ func (item *Item) SendSpawn(writer io.Writer) (err os.Error) {
 }
 
 func (item *Item) SendUpdate(writer io.Writer) (err os.Error) {
-	if err = proto.WriteEntity(writer, item.EntityId); err != nil {
-		return
-	}
-
-	err = item.PointObject.SendUpdate(writer, item.EntityId, &LookBytes{0, 0})
+	// TODO: Should this be the Rotation information?
+	return item.PointObject.SendUpdate(writer, item.EntityId, &LookBytes{0, 0})
+}
 
-	return
+func (item *Item) SendTeleport(writer io.Writer) os.Error {
+	return item.PointObject.SendTeleport(writer, item.EntityId)
 }
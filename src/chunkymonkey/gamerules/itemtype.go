@@ -16,6 +16,12 @@ type ItemType struct {
 	MaxStack ItemCount
 	ToolType ToolTypeId
 	ToolUses ItemData
+
+	// Food and FoodSaturation are what eating the item restores to a player's
+	// food and food saturation levels. Food is zero for items that can't be
+	// eaten.
+	Food           int16
+	FoodSaturation float32
 }
 
 type ItemTypeMap map[ItemTypeId]*ItemType
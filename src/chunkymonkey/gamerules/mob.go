@@ -30,10 +30,22 @@ type Mob struct {
 	// TODO(nictuku): Move to a more structured form.
 	metadata map[byte]byte
 	// TODO: Change to an AABB object when we have that.
+	health Health
+	// Ticks until the mob can attack again.
+	attackTimer Ticks
+}
+
+// IMob is implemented by all mob types, which embed Mob.
+type IMob interface {
+	INonPlayerEntity
+	GetMob() *Mob
 }
 
 func (mob *Mob) Init(id EntityMobType) {
 	mob.mobType = id
+	if mobType, ok := Mobs[id]; ok {
+		mob.health = mobType.MaxHealth
+	}
 	mob.metadata = map[byte]byte{
 		0:  byte(0),
 		16: byte(0),
@@ -51,6 +63,10 @@ func (mob *Mob) ReadNbt(tag nbt.ITag) (err os.Error) {
 		return
 	}
 
+	if health, ok := tag.Lookup("Health").(*nbt.Short); ok {
+		mob.health = Health(health.Value)
+	}
+
 	// TODO
 	_ = tag.Lookup("FallDistance").(*nbt.Float).Value
 	_ = tag.Lookup("Air").(*nbt.Short).Value
@@ -59,6 +75,58 @@ func (mob *Mob) ReadNbt(tag nbt.ITag) (err os.Error) {
 	return nil
 }
 
+func (mob *Mob) WriteNbt() *nbt.Compound {
+	tag := mob.PointObject.WriteNbt()
+	if mobType, ok := Mobs[mob.mobType]; ok {
+		tag.Tags["id"] = &nbt.String{mobType.NbtName}
+	}
+	tag.Tags["Rotation"] = nbtutil.WriteLookDegrees(&mob.look)
+	tag.Tags["Health"] = &nbt.Short{int16(mob.health)}
+
+	// TODO Track these rather than writing the values for a mob that isn't
+	// falling, drowning or burning.
+	tag.Tags["FallDistance"] = &nbt.Float{0}
+	tag.Tags["Air"] = &nbt.Short{300}
+	tag.Tags["Fire"] = &nbt.Short{-1}
+
+	return tag
+}
+
+func (mob *Mob) GetMob() *Mob {
+	return mob
+}
+
+func (mob *Mob) MobType() EntityMobType {
+	return mob.mobType
+}
+
+func (mob *Mob) Health() Health {
+	return mob.health
+}
+
+// Damage reduces the mob's health. Returns true if the mob has died, in which
+// case it should be removed.
+func (mob *Mob) Damage(amount Health) (dead bool) {
+	mob.health -= amount
+	return mob.health <= 0
+}
+
+// ReadyToAttack counts down the time until the mob can next attack. Returns
+// true if it is ready to attack, in which case Attacked should be called if it
+// does so.
+func (mob *Mob) ReadyToAttack() bool {
+	if mob.attackTimer > 0 {
+		mob.attackTimer--
+		return false
+	}
+	return true
+}
+
+// Attacked stops the mob attacking again for the given number of ticks.
+func (mob *Mob) Attacked(cooldown Ticks) {
+	mob.attackTimer = cooldown
+}
+
 func (mob *Mob) SetLook(look LookDegrees) {
 	mob.look = look
 }
@@ -87,13 +155,11 @@ func (mob *Mob) FormatMetadata() []proto.EntityMetadata {
 }
 
 func (mob *Mob) SendUpdate(writer io.Writer) (err os.Error) {
-	if err = proto.WriteEntity(writer, mob.EntityId); err != nil {
-		return
-	}
-
-	err = mob.PointObject.SendUpdate(writer, mob.EntityId, mob.look.ToLookBytes())
+	return mob.PointObject.SendUpdate(writer, mob.EntityId, mob.look.ToLookBytes())
+}
 
-	return
+func (mob *Mob) SendTeleport(writer io.Writer) os.Error {
+	return mob.PointObject.SendTeleport(writer, mob.EntityId)
 }
 
 func (mob *Mob) SendSpawn(writer io.Writer) (err os.Error) {
@@ -89,3 +89,16 @@ func TestMobSpawn(t *testing.T) {
 		}
 	}
 }
+
+func TestMobDamage(t *testing.T) {
+	m := NewHen()
+	if m.Health() != HenType.MaxHealth {
+		t.Fatalf("Expected new hen to have %d health, got %d", HenType.MaxHealth, m.Health())
+	}
+	if m.Damage(1) {
+		t.Errorf("Expected hen to survive one damage")
+	}
+	if !m.Damage(HenType.MaxHealth) {
+		t.Errorf("Expected hen to die after losing all its health")
+	}
+}
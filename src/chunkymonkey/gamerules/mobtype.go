@@ -5,8 +5,10 @@ import (
 )
 
 type MobType struct {
-	Id   EntityMobType
-	Name string
+	Id        EntityMobType
+	Name      string
+	NbtName   string // Entity id in NBT data, e.g in chunk files.
+	MaxHealth Health
 }
 
 type MobTypeMap map[EntityMobType]*MobType
@@ -29,17 +31,17 @@ var Mobs = MobTypeMap{
 	MobTypeIdWolf:         &WolfType,
 }
 
-var CreeperType = MobType{MobTypeIdCreeper, "creeper"}
-var SkeletonType = MobType{MobTypeIdSkeleton, "skeleton"}
-var SpiderType = MobType{MobTypeIdSpider, "spider"}
-var GiantZombieType = MobType{MobTypeIdGiantZombie, "giantzombie"}
-var ZombieType = MobType{MobTypeIdZombie, "zombie"}
-var SlimeType = MobType{MobTypeIdSlime, "slime"}
-var GhastType = MobType{MobTypeIdGhast, "ghast"}
-var ZombiePigmanType = MobType{MobTypeIdZombiePigman, "zombiepigman"}
-var PigType = MobType{MobTypeIdPig, "pig"}
-var SheepType = MobType{MobTypeIdSheep, "sheep"}
-var CowType = MobType{MobTypeIdCow, "cow"}
-var HenType = MobType{MobTypeIdHen, "hen"}
-var SquidType = MobType{MobTypeIdSquid, "squid"}
-var WolfType = MobType{MobTypeIdWolf, "wolf"}
+var CreeperType = MobType{MobTypeIdCreeper, "creeper", "Creeper", 20}
+var SkeletonType = MobType{MobTypeIdSkeleton, "skeleton", "Skeleton", 20}
+var SpiderType = MobType{MobTypeIdSpider, "spider", "Spider", 16}
+var GiantZombieType = MobType{MobTypeIdGiantZombie, "giantzombie", "Giant", 100}
+var ZombieType = MobType{MobTypeIdZombie, "zombie", "Zombie", 20}
+var SlimeType = MobType{MobTypeIdSlime, "slime", "Slime", 16}
+var GhastType = MobType{MobTypeIdGhast, "ghast", "Ghast", 10}
+var ZombiePigmanType = MobType{MobTypeIdZombiePigman, "zombiepigman", "PigZombie", 20}
+var PigType = MobType{MobTypeIdPig, "pig", "Pig", 10}
+var SheepType = MobType{MobTypeIdSheep, "sheep", "Sheep", 8}
+var CowType = MobType{MobTypeIdCow, "cow", "Cow", 10}
+var HenType = MobType{MobTypeIdHen, "hen", "Chicken", 4}
+var SquidType = MobType{MobTypeIdSquid, "squid", "Squid", 10}
+var WolfType = MobType{MobTypeIdWolf, "wolf", "Wolf", 8}
@@ -20,12 +20,22 @@ type Object struct {
 	ObjTypeId
 	physics.PointObject
 	orientation OrientationBytes
+
+	// The player riding the object, if it is a vehicle, and the push that
+	// their input gives it on the next tick.
+	rider      EntityId
+	riderInput AbsVelocity
+	// Number of times the object has been hit recently, and how long until
+	// that is forgotten.
+	hits     int
+	hitTimer Ticks
 }
 
 func NewObject(objType ObjTypeId) (object *Object) {
 	object = &Object{
 		// TODO: proper orientation
 		orientation: OrientationBytes{0, 0, 0},
+		rider:       NoEntityId,
 	}
 	object.ObjTypeId = objType
 	return
@@ -50,9 +60,30 @@ func (object *Object) ReadNbt(tag nbt.ITag) (err os.Error) {
 
 	// TODO load orientation
 
+	// Not part of the standard format, but kept so that vehicles keep their
+	// riders when moved between shards.
+	object.rider = NoEntityId
+	if rider, ok := tag.Lookup("Rider").(*nbt.Int); ok {
+		object.rider = EntityId(rider.Value)
+	}
+
 	return
 }
 
+func (object *Object) WriteNbt() *nbt.Compound {
+	tag := object.PointObject.WriteNbt()
+	for typeName, objType := range ObjTypeMap {
+		if objType == object.ObjTypeId {
+			tag.Tags["id"] = &nbt.String{typeName}
+			break
+		}
+	}
+	if object.rider != NoEntityId {
+		tag.Tags["Rider"] = &nbt.Int{int32(object.rider)}
+	}
+	return tag
+}
+
 func (object *Object) SendSpawn(writer io.Writer) (err os.Error) {
 	// TODO: Send non-nil ObjectData (is there any?)
 	err = proto.WriteObjectSpawn(writer, object.EntityId, object.ObjTypeId, &object.PointObject.LastSentPosition, nil)
@@ -65,12 +96,23 @@ func (object *Object) SendSpawn(writer io.Writer) (err os.Error) {
 }
 
 func (object *Object) SendUpdate(writer io.Writer) (err os.Error) {
-	if err = proto.WriteEntity(writer, object.EntityId); err != nil {
-		return
-	}
-
 	// TODO: Should this be the Rotation information?
-	err = object.PointObject.SendUpdate(writer, object.EntityId, &LookBytes{0, 0})
+	return object.PointObject.SendUpdate(writer, object.EntityId, &LookBytes{0, 0})
+}
 
-	return
+func (object *Object) SendTeleport(writer io.Writer) os.Error {
+	return object.PointObject.SendTeleport(writer, object.EntityId)
+}
+
+func (object *Object) Tick(blockQuerier physics.IBlockQuerier) (leftBlock bool) {
+	if querier, ok := blockQuerier.(IBlockDataQuerier); ok {
+		switch object.ObjTypeId {
+		case ObjTypeIdMinecart, ObjTypeIdStorageCart, ObjTypeIdPoweredCart:
+			return object.tickMinecart(querier)
+		case ObjTypeIdBoat:
+			return object.tickBoat(querier)
+		}
+	}
+
+	return object.PointObject.Tick(blockQuerier)
 }
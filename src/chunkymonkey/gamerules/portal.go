@@ -0,0 +1,98 @@
+package gamerules
+
+import (
+	. "chunkymonkey/types"
+)
+
+const (
+	// Size of the open space inside a portal frame.
+	PortalInteriorWidth  = 2
+	PortalInteriorHeight = 3
+)
+
+// BlockIdQuery is used to look up the block at a location. It returns ok=false
+// if the block isn't known (e.g the chunk isn't loaded).
+type BlockIdQuery func(loc BlockXyz) (blockId BlockId, ok bool)
+
+// FindPortalInterior looks for an obsidian portal frame around the given
+// location, which is assumed to be inside the frame (typically where a fire
+// was just lit). Frames can lie along either the X or Z axis, and their
+// corners need not be filled in. If a complete frame is found, the locations
+// of the blocks inside it are returned.
+func FindPortalInterior(start BlockXyz, query BlockIdQuery) (interior []BlockXyz, ok bool) {
+	if interior, ok = findPortalInteriorAlong(start, 1, 0, query); ok {
+		return
+	}
+	return findPortalInteriorAlong(start, 0, 1, query)
+}
+
+// findPortalInteriorAlong looks for a portal frame whose width runs in the
+// direction (dx, dz).
+func findPortalInteriorAlong(start BlockXyz, dx, dz BlockCoord, query BlockIdQuery) (interior []BlockXyz, ok bool) {
+	isObsidian := func(loc BlockXyz) bool {
+		blockId, known := query(loc)
+		return known && blockId == BlockIdObsidian
+	}
+	isOpen := func(loc BlockXyz) bool {
+		blockId, known := query(loc)
+		return known && (blockId == BlockIdAir || blockId == BlockIdFire)
+	}
+
+	// Find the bottom of the interior.
+	base := start
+	for i := 0; ; i++ {
+		if !isOpen(base) || i >= PortalInteriorHeight {
+			return nil, false
+		}
+		below := base
+		below.Y--
+		if isObsidian(below) {
+			break
+		}
+		base = below
+	}
+
+	// Find the low end of the interior along the frame's width.
+	for i := 0; ; i++ {
+		if i >= PortalInteriorWidth {
+			return nil, false
+		}
+		prev := BlockXyz{base.X - dx, base.Y, base.Z - dz}
+		if isObsidian(prev) {
+			break
+		}
+		if !isOpen(prev) {
+			return nil, false
+		}
+		base = prev
+	}
+
+	interior = make([]BlockXyz, 0, PortalInteriorWidth*PortalInteriorHeight)
+	for w := BlockCoord(0); w < PortalInteriorWidth; w++ {
+		x, z := base.X+w*dx, base.Z+w*dz
+
+		// Floor and roof of the frame.
+		if !isObsidian(BlockXyz{x, base.Y - 1, z}) || !isObsidian(BlockXyz{x, base.Y + PortalInteriorHeight, z}) {
+			return nil, false
+		}
+
+		for h := BlockYCoord(0); h < PortalInteriorHeight; h++ {
+			loc := BlockXyz{x, base.Y + h, z}
+			if !isOpen(loc) {
+				return nil, false
+			}
+			interior = append(interior, loc)
+		}
+	}
+
+	// Sides of the frame.
+	for h := BlockYCoord(0); h < PortalInteriorHeight; h++ {
+		low := BlockXyz{base.X - dx, base.Y + h, base.Z - dz}
+		high := BlockXyz{base.X + PortalInteriorWidth*dx, base.Y + h, base.Z + PortalInteriorWidth*dz}
+		if !isObsidian(low) || !isObsidian(high) {
+			return nil, false
+		}
+	}
+
+	return interior, true
+}
@@ -0,0 +1,144 @@
+package gamerules
+
+import (
+	"fmt"
+	"testing"
+
+	. "chunkymonkey/types"
+)
+
+// testBlocks is a sparse world of blocks for testing. Unset blocks are air.
+type testBlocks map[string]BlockId
+
+func testBlockKey(loc BlockXyz) string {
+	return fmt.Sprintf("%d,%d,%d", loc.X, loc.Y, loc.Z)
+}
+
+func (blocks testBlocks) set(loc BlockXyz, blockId BlockId) {
+	blocks[testBlockKey(loc)] = blockId
+}
+
+func (blocks testBlocks) query(loc BlockXyz) (BlockId, bool) {
+	return blocks[testBlockKey(loc)], true
+}
+
+// buildFrame puts an obsidian portal frame into blocks, with the lowest
+// interior block at base. The frame's width runs in the direction (dx, dz).
+func buildFrame(blocks testBlocks, base BlockXyz, dx, dz BlockCoord, corners bool) {
+	for w := BlockCoord(-1); w <= PortalInteriorWidth; w++ {
+		for h := BlockYCoord(-1); h <= PortalInteriorHeight; h++ {
+			isSide := w == -1 || w == PortalInteriorWidth
+			isEnd := h == -1 || h == PortalInteriorHeight
+			if !isSide && !isEnd {
+				continue
+			}
+			if isSide && isEnd && !corners {
+				continue
+			}
+			blocks.set(BlockXyz{base.X + w*dx, base.Y + h, base.Z + w*dz}, BlockIdObsidian)
+		}
+	}
+}
+
+func TestFindPortalInterior(t *testing.T) {
+	base := BlockXyz{10, 64, -20}
+
+	type Test struct {
+		desc  string
+		setup func(blocks testBlocks)
+		start BlockXyz
+		ok    bool
+		// Direction the interior is expected to run in.
+		dx, dz BlockCoord
+	}
+
+	tests := []Test{
+		{
+			"X axis frame lit at bottom left",
+			func(blocks testBlocks) { buildFrame(blocks, base, 1, 0, true) },
+			base, true, 1, 0,
+		},
+		{
+			"X axis frame lit at top right",
+			func(blocks testBlocks) { buildFrame(blocks, base, 1, 0, true) },
+			BlockXyz{base.X + 1, base.Y + 2, base.Z}, true, 1, 0,
+		},
+		{
+			"Z axis frame without corners",
+			func(blocks testBlocks) { buildFrame(blocks, base, 0, 1, false) },
+			BlockXyz{base.X, base.Y, base.Z + 1}, true, 0, 1,
+		},
+		{
+			"frame with fire inside",
+			func(blocks testBlocks) {
+				buildFrame(blocks, base, 1, 0, true)
+				blocks.set(base, BlockIdFire)
+			},
+			base, true, 1, 0,
+		},
+		{
+			"frame missing a side block",
+			func(blocks testBlocks) {
+				buildFrame(blocks, base, 1, 0, true)
+				blocks.set(BlockXyz{base.X - 1, base.Y + 1, base.Z}, BlockIdAir)
+			},
+			base, false, 0, 0,
+		},
+		{
+			"frame missing a roof block",
+			func(blocks testBlocks) {
+				buildFrame(blocks, base, 1, 0, true)
+				blocks.set(BlockXyz{base.X + 1, base.Y + PortalInteriorHeight, base.Z}, BlockIdAir)
+			},
+			base, false, 0, 0,
+		},
+		{
+			"obstructed interior",
+			func(blocks testBlocks) {
+				buildFrame(blocks, base, 1, 0, true)
+				blocks.set(BlockXyz{base.X + 1, base.Y + 1, base.Z}, BlockId(1))
+			},
+			base, false, 0, 0,
+		},
+		{
+			"no frame at all",
+			func(blocks testBlocks) {},
+			base, false, 0, 0,
+		},
+	}
+
+	for _, test := range tests {
+		blocks := make(testBlocks)
+		test.setup(blocks)
+
+		interior, ok := FindPortalInterior(test.start, func(loc BlockXyz) (BlockId, bool) {
+			return blocks.query(loc)
+		})
+		if ok != test.ok {
+			t.Errorf("%s: expected ok=%t, got ok=%t", test.desc, test.ok, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		if len(interior) != PortalInteriorWidth*PortalInteriorHeight {
+			t.Errorf("%s: expected %d interior blocks, got %d", test.desc, PortalInteriorWidth*PortalInteriorHeight, len(interior))
+			continue
+		}
+		for w := BlockCoord(0); w < PortalInteriorWidth; w++ {
+			for h := BlockYCoord(0); h < PortalInteriorHeight; h++ {
+				expected := BlockXyz{base.X + w*test.dx, base.Y + h, base.Z + w*test.dz}
+				found := false
+				for _, loc := range interior {
+					if loc.X == expected.X && loc.Y == expected.Y && loc.Z == expected.Z {
+						found = true
+					}
+				}
+				if !found {
+					t.Errorf("%s: expected %v in interior %v", test.desc, expected, interior)
+				}
+			}
+		}
+	}
+}
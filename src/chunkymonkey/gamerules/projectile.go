@@ -0,0 +1,263 @@
+// Defines the behaviour of projectiles - arrows shot from bows and by
+// skeletons, and thrown snowballs and eggs.
+
+package gamerules
+
+import (
+	"math"
+	"os"
+	"rand"
+
+	"chunkymonkey/physics"
+	. "chunkymonkey/types"
+	"nbt"
+)
+
+const (
+	// Speeds are in blocks per tick.
+	ArrowSpeed  = 1.5
+	ThrownSpeed = 1.5
+
+	arrowGravity      = 0.05
+	thrownGravity     = 0.03
+	projectileDrag    = 0.99 // Fraction of speed kept each tick.
+	projectileMaxStep = 0.25 // Furthest a projectile moves between block checks.
+
+	arrowDamage = Health(4)
+
+	// How long an arrow stays stuck in a block before disappearing.
+	arrowStuckLifetime = 60 * TicksPerSecond
+
+	// One in this many eggs hatches a chicken when it breaks.
+	eggHatchChance = 8
+)
+
+type projectileState byte
+
+const (
+	projectileFlying = projectileState(iota)
+	projectileStuck  = projectileState(iota)
+	projectileBroken = projectileState(iota)
+)
+
+// Projectile is an object that flies through the air until it hits something.
+// Arrows stick into the blocks that they hit, while snowballs and eggs break.
+type Projectile struct {
+	Object
+
+	// The entity that launched the projectile, which it cannot hit.
+	shooter EntityId
+	// True if a player can pick up the projectile after it lands.
+	canPickUp bool
+
+	state projectileState
+	// Where the projectile was at the start of its last tick.
+	from AbsXyz
+	// How long the projectile has been stuck in a block.
+	stuckTime Ticks
+}
+
+// NewProjectile creates a projectile of the given type. The caller must set
+// its EntityId and initialize its PointObject.
+func NewProjectile(objType ObjTypeId, shooter EntityId, canPickUp bool) (projectile *Projectile) {
+	projectile = &Projectile{
+		Object: Object{
+			orientation: OrientationBytes{0, 0, 0},
+			rider:       NoEntityId,
+		},
+		shooter:   shooter,
+		canPickUp: canPickUp,
+	}
+	projectile.ObjTypeId = objType
+	return
+}
+
+// IsProjectileType returns true if objects of the given type are projectiles.
+func IsProjectileType(objType ObjTypeId) bool {
+	return objType == ObjTypeIdArrow || objType == ObjTypeIdThrownSnowball || objType == ObjTypeIdThrownEgg
+}
+
+func (projectile *Projectile) ReadNbt(tag nbt.ITag) (err os.Error) {
+	if err = projectile.Object.ReadNbt(tag); err != nil {
+		return
+	}
+
+	projectile.shooter = NoEntityId
+	if inGround, ok := tag.Lookup("inGround").(*nbt.Byte); ok && inGround.Value != 0 {
+		projectile.state = projectileStuck
+		projectile.canPickUp = projectile.ObjTypeId == ObjTypeIdArrow
+	}
+	if player, ok := tag.Lookup("player").(*nbt.Byte); ok {
+		projectile.canPickUp = player.Value != 0
+	}
+	if life, ok := tag.Lookup("life").(*nbt.Short); ok {
+		projectile.stuckTime = Ticks(life.Value)
+	}
+
+	// Not part of the standard format, but kept so that a projectile in flight
+	// still can't hit its shooter after moving between shards.
+	if shooter, ok := tag.Lookup("Shooter").(*nbt.Int); ok {
+		projectile.shooter = EntityId(shooter.Value)
+	}
+
+	return
+}
+
+func (projectile *Projectile) WriteNbt() *nbt.Compound {
+	var inGround, player int8
+	if projectile.state != projectileFlying {
+		inGround = 1
+	}
+	if projectile.canPickUp {
+		player = 1
+	}
+
+	tag := projectile.Object.WriteNbt()
+	tag.Tags["inGround"] = &nbt.Byte{inGround}
+	tag.Tags["player"] = &nbt.Byte{player}
+	tag.Tags["life"] = &nbt.Short{int16(projectile.stuckTime)}
+	if projectile.shooter != NoEntityId {
+		tag.Tags["Shooter"] = &nbt.Int{int32(projectile.shooter)}
+	}
+	return tag
+}
+
+// Shooter returns the EntityId of the entity that launched the projectile.
+func (projectile *Projectile) Shooter() EntityId {
+	return projectile.shooter
+}
+
+// Path returns the line along which the projectile moved in its last tick.
+func (projectile *Projectile) Path() (from, to *AbsXyz) {
+	return &projectile.from, projectile.Position()
+}
+
+// InFlight returns true if the projectile has not yet hit a block.
+func (projectile *Projectile) InFlight() bool {
+	return projectile.state == projectileFlying
+}
+
+// CanPickUp returns true if the projectile is stuck in a block and can be
+// picked up by a player.
+func (projectile *Projectile) CanPickUp() bool {
+	return projectile.state == projectileStuck && projectile.canPickUp
+}
+
+// Expired returns true if the projectile has broken or been stuck in a block
+// for long enough to disappear, in which case it should be removed.
+func (projectile *Projectile) Expired() bool {
+	switch projectile.state {
+	case projectileBroken:
+		return true
+	case projectileStuck:
+		return projectile.stuckTime >= arrowStuckLifetime
+	}
+	return false
+}
+
+// Damage returns how much an entity hit by the projectile is hurt.
+func (projectile *Projectile) Damage() Health {
+	if projectile.ObjTypeId == ObjTypeIdArrow {
+		return arrowDamage
+	}
+	return 0
+}
+
+// PickUpItem returns the item that a player gets when picking up the
+// projectile.
+func (projectile *Projectile) PickUpItem() ItemTypeId {
+	return ItemTypeIdArrow
+}
+
+// Hatches decides if a broken egg hatches a chicken.
+func (projectile *Projectile) Hatches(rnd *rand.Rand) bool {
+	return projectile.ObjTypeId == ObjTypeIdThrownEgg && rnd.Intn(eggHatchChance) == 0
+}
+
+func (projectile *Projectile) gravity() AbsVelocityCoord {
+	if projectile.ObjTypeId == ObjTypeIdArrow {
+		return arrowGravity
+	}
+	return thrownGravity
+}
+
+func (projectile *Projectile) hitBlock() {
+	v := projectile.Velocity()
+	*v = AbsVelocity{}
+	if projectile.ObjTypeId == ObjTypeIdArrow {
+		projectile.state = projectileStuck
+	} else {
+		projectile.state = projectileBroken
+	}
+}
+
+// HitEntity is called when the projectile hits an entity, after which it
+// should be removed.
+func (projectile *Projectile) HitEntity() {
+	projectile.state = projectileBroken
+}
+
+func (projectile *Projectile) Tick(blockQuerier physics.IBlockQuerier) (leftBlock bool) {
+	p := projectile.Position()
+	projectile.from = *p
+
+	if projectile.state != projectileFlying {
+		projectile.stuckTime++
+		return false
+	}
+
+	v := projectile.Velocity()
+	oldChunkLoc := p.ToChunkXz()
+
+	// Move in small steps so that the projectile doesn't pass through the
+	// corners of blocks.
+	speed := math.Sqrt(float64(v.X*v.X + v.Y*v.Y + v.Z*v.Z))
+	steps := int(math.Ceil(speed / projectileMaxStep))
+	for i := 0; i < steps; i++ {
+		next := AbsXyz{
+			p.X + AbsCoord(float64(v.X)/float64(steps)),
+			p.Y + AbsCoord(float64(v.Y)/float64(steps)),
+			p.Z + AbsCoord(float64(v.Z)/float64(steps)),
+		}
+		if next.Y >= 0 && next.Y < ChunkSizeY {
+			if isSolid, _ := blockQuerier.BlockQuery(*next.ToBlockXyz()); isSolid {
+				projectile.hitBlock()
+				break
+			}
+		}
+		*p = next
+	}
+
+	if projectile.state == projectileFlying {
+		v.X *= projectileDrag
+		v.Y = v.Y*projectileDrag - projectile.gravity()
+		v.Z *= projectileDrag
+	}
+
+	newChunkLoc := p.ToChunkXz()
+	return p.Y < 0 || oldChunkLoc.X != newChunkLoc.X || oldChunkLoc.Z != newChunkLoc.Z
+}
+
+// AimProjectile returns the velocity to launch a projectile at from one
+// position to hit another, allowing for the projectile dropping as it flies.
+func AimProjectile(from, to *AbsXyz, speed float64) AbsVelocity {
+	dx := float64(to.X - from.X)
+	dy := float64(to.Y - from.Y)
+	dz := float64(to.Z - from.Z)
+	dist := math.Sqrt(dx*dx + dy*dy + dz*dz)
+	if dist == 0 {
+		return AbsVelocity{}
+	}
+
+	// Aim high by as far as the projectile falls in the time it takes to get
+	// there.
+	flightTime := dist / speed
+	dy += 0.5 * arrowGravity * flightTime * flightTime
+	dist = math.Sqrt(dx*dx + dy*dy + dz*dz)
+
+	return AbsVelocity{
+		AbsVelocityCoord(dx / dist * speed),
+		AbsVelocityCoord(dy / dist * speed),
+		AbsVelocityCoord(dz / dist * speed),
+	}
+}
@@ -0,0 +1,77 @@
+package gamerules
+
+import (
+	"testing"
+
+	. "chunkymonkey/types"
+)
+
+func newTestProjectile(objType ObjTypeId, position AbsXyz, velocity AbsVelocity) *Projectile {
+	projectile := NewProjectile(objType, EntityId(1), true)
+	projectile.PointObject.Init(&position, &velocity)
+	return projectile
+}
+
+func TestArrowFliesAndFalls(t *testing.T) {
+	arrow := newTestProjectile(ObjTypeIdArrow, AbsXyz{0.5, 70, 0.5}, AbsVelocity{1, 0, 0})
+
+	arrow.Tick(testBlockQuerier{})
+
+	if !arrow.InFlight() {
+		t.Fatalf("expected arrow to still be in flight")
+	}
+	from, to := arrow.Path()
+	if from.X != 0.5 || to.X != 1.5 || to.Y != 70 {
+		t.Errorf("expected arrow to move from X=0.5 to X=1.5, moved from %v to %v", *from, *to)
+	}
+	if v := arrow.Velocity(); v.X >= 1 || v.Y >= 0 {
+		t.Errorf("expected arrow to slow down and start falling, got velocity %v", *v)
+	}
+}
+
+func TestArrowSticksInBlock(t *testing.T) {
+	querier := testBlockQuerier{
+		{BlockXyz{2, 70, 0}, BlockId(1), 0},
+	}
+	arrow := newTestProjectile(ObjTypeIdArrow, AbsXyz{0.5, 70.5, 0.5}, AbsVelocity{2, 0, 0})
+
+	arrow.Tick(querier)
+
+	if arrow.InFlight() || !arrow.CanPickUp() || arrow.Expired() {
+		t.Fatalf("expected arrow to be stuck and collectable")
+	}
+	if p := arrow.Position(); p.X >= 2 || p.X < 1.5 {
+		t.Errorf("expected arrow to stop just short of the block, got %v", *p)
+	}
+
+	for i := 0; i < arrowStuckLifetime; i++ {
+		arrow.Tick(querier)
+	}
+	if !arrow.Expired() {
+		t.Errorf("expected arrow to expire after being stuck for a while")
+	}
+}
+
+func TestSnowballBreaksOnGround(t *testing.T) {
+	snowball := newTestProjectile(ObjTypeIdThrownSnowball, AbsXyz{0.5, testGroundLevel + 0.5, 0.5}, AbsVelocity{0, -1, 0})
+
+	snowball.Tick(testBlockQuerier{})
+
+	if snowball.InFlight() || snowball.CanPickUp() || !snowball.Expired() {
+		t.Errorf("expected snowball to break on hitting the ground")
+	}
+	if snowball.Damage() != 0 {
+		t.Errorf("expected snowball to do no damage")
+	}
+}
+
+func TestAimProjectileAimsHigh(t *testing.T) {
+	from := AbsXyz{0, 64, 0}
+	to := AbsXyz{10, 64, 0}
+
+	v := AimProjectile(&from, &to, ArrowSpeed)
+
+	if v.X <= 0 || v.Y <= 0 || v.Z != 0 {
+		t.Errorf("expected to aim towards and above the target, got %v", v)
+	}
+}
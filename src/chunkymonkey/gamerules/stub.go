@@ -1,6 +1,8 @@
 package gamerules
 
 import (
+	"os"
+
 	"chunkymonkey/proto"
 	. "chunkymonkey/types"
 )
@@ -29,7 +31,7 @@ type IPlayerShardClient interface {
 
 	ReqUnsubscribeChunk(chunkLoc ChunkXz)
 
-	ReqMulticastPlayers(chunkLoc ChunkXz, exclude EntityId, packet []byte)
+	ReqMulticastPlayers(chunkLoc ChunkXz, exclude EntityId, packet []byte, priority proto.PacketPriority)
 
 	ReqAddPlayerData(chunkLoc ChunkXz, name string, position AbsXyz, look LookBytes, held ItemTypeId)
 
@@ -39,6 +41,15 @@ type IPlayerShardClient interface {
 
 	ReqSetPlayerLook(chunkLoc ChunkXz, look LookBytes)
 
+	// ReqViewEntities requests that the entities in a subscribed chunk be
+	// shown to the player (view=true) with ShowEntity, or no longer be shown.
+	ReqViewEntities(chunkLoc ChunkXz, view bool)
+
+	// ReqTeleportEntities requests that the player be sent the full positions
+	// of the entities in a viewed chunk, after the client has missed some of
+	// their movement.
+	ReqTeleportEntities(chunkLoc ChunkXz)
+
 	// ReqHitBlock requests that the targetted block be hit.
 	ReqHitBlock(held Slot, target BlockXyz, digStatus DigStatus, face Face)
 
@@ -68,6 +79,34 @@ type IPlayerShardClient interface {
 	// ReqInventoryUnsubscribed requests that the inventory for the block be
 	// unsubscribed to.
 	ReqInventoryUnsubscribed(block BlockXyz)
+
+	// ReqCreatePortal requests that the player be placed by a portal in the
+	// chunk containing the target, building a new portal there if the chunk
+	// does not already have one. This is used when a player arrives in a
+	// dimension via a portal.
+	ReqCreatePortal(target BlockXyz)
+
+	// ReqLeaveBed requests that the player be taken out of the bed whose head
+	// is at the given location, so that others may sleep in it.
+	ReqLeaveBed(bedLoc BlockXyz)
+
+	// ReqCheckBed requests that the player be told with BedMissing if the bed
+	// whose head is at the given location no longer exists.
+	ReqCheckBed(bedLoc BlockXyz)
+
+	// ReqUseEntity requests that the player at the given position use the
+	// target entity, if it is in the given chunk. Using a vehicle mounts or
+	// dismounts it, and hitting it (leftClick=true) pushes or breaks it.
+	ReqUseEntity(chunkLoc ChunkXz, position AbsXyz, target EntityId, leftClick bool)
+
+	// ReqSteerVehicle requests that the vehicle that the player is riding, if
+	// it is in the given chunk, be pushed by the player's input. input is the
+	// motion that the client gives the player while riding.
+	ReqSteerVehicle(chunkLoc ChunkXz, vehicle EntityId, input AbsVelocity)
+
+	// ReqLaunchProjectile requests that a projectile of the given type be
+	// launched by the player from the given position.
+	ReqLaunchProjectile(objType ObjTypeId, position AbsXyz, velocity AbsVelocity)
 }
 
 // IShardShardClient provides an interface for shards to make requests against
@@ -96,6 +135,33 @@ type IGame interface {
 	// Return an ItemType from a numeric item. The boolean flag indicates
 	// whether or not 'id' was a valid item type.
 	ItemTypeById(id int) (ItemType, bool)
+
+	// Return the current time in the world.
+	WorldTime() Ticks
+
+	// Set the time in the world.
+	SetWorldTime(time Ticks)
+
+	// Set the weather in the world.
+	SetWeather(weather Weather)
+
+	// Return the shard connecter for the given dimension of the named world,
+	// or nil if there is no such world or dimension.
+	ShardConnecter(world string, dimension DimensionId) IShardConnecter
+
+	// Return the names of the worlds hosted by the server.
+	WorldNames() []string
+
+	// Move a player to the named world, if they have permission to.
+	MovePlayerToWorld(entityId EntityId, world string) os.Error
+
+	// Record whether a player is asleep. The night is skipped once every player
+	// is asleep.
+	SetPlayerAsleep(entityId EntityId, asleep bool)
+
+	// Record the round trip time, in nanoseconds, of a player's connection, as
+	// measured by keep-alive packets. It is shown in the player list.
+	SetPlayerLatency(entityId EntityId, latency int64)
 }
 
 // IShardClient is the interface by which shards communicate to players on
@@ -103,7 +169,19 @@ type IGame interface {
 type IPlayerClient interface {
 	GetEntityId() EntityId
 
-	TransmitPacket(packet []byte)
+	// TransmitPacket sends packet to the client. The priority is that of the
+	// packets in it, which are sent in order with others of the same priority.
+	TransmitPacket(packet []byte, priority proto.PacketPriority)
+
+	// ShowEntity informs the player that an entity is in a chunk whose
+	// entities they view. The spawn packet is sent to the client unless the
+	// entity is already shown to it from another chunk.
+	ShowEntity(chunkLoc ChunkXz, entityId EntityId, spawnPacket []byte)
+
+	// HideEntity informs the player that an entity is no longer in a chunk
+	// whose entities they view. The entity is destroyed on the client once no
+	// chunk shows it.
+	HideEntity(chunkLoc ChunkXz, entityId EntityId)
 
 	// NotifyChunkLoad informs Player that a chunk subscription request with
 	// notify=true has completed.
@@ -139,6 +217,10 @@ type IPlayerClient interface {
 	// held item).
 	PlaceHeldItem(target BlockXyz, wasHeld Slot)
 
+	// InteractDone informs the player that a ReqInteractBlock has been carried
+	// out, after any PlaceHeldItem that it led to.
+	InteractDone()
+
 	// OfferItem requests that the player check if it can take the item.  If
 	// it can then it should ReqTakeItem from the chunk.
 	OfferItem(fromChunk ChunkXz, entityId EntityId, item Slot)
@@ -158,6 +240,37 @@ type IPlayerClient interface {
 	// SetPositionLook changes the player's position and look
 	SetPositionLook(AbsXyz, LookDegrees)
 
+	// SetPosition changes the player's position, keeping their look
+	SetPosition(AbsXyz)
+
+	// EnterPortal informs the player that they are standing in a portal.
+	EnterPortal()
+
+	// Sleep requests that the player sleep in the bed whose head is at the
+	// given location.
+	Sleep(bedLoc BlockXyz)
+
+	// BedMissing informs the player that the bed whose head is at the given
+	// location, asked about with ReqCheckBed, no longer exists.
+	BedMissing(bedLoc BlockXyz)
+
+	// Mount informs the player that they are now riding the vehicle.
+	Mount(vehicleId EntityId)
+
+	// Dismount informs the player that they are no longer riding the vehicle.
+	Dismount(vehicleId EntityId)
+
+	// VehicleMoved informs the player that the vehicle they are riding has
+	// moved, and where they are now.
+	VehicleMoved(vehicleId EntityId, position AbsXyz)
+
+	// Damage informs the player that they have been hurt by the attacker.
+	Damage(amount Health, attacker EntityId)
+
+	// GiveExperience adds to the player's experience, for example for killing
+	// a mob.
+	GiveExperience(amount int16)
+
 	// EchoMessage displays a message to the player
 	EchoMessage(msg string)
 }
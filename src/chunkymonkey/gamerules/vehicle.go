@@ -0,0 +1,373 @@
+// Defines the behaviour of objects that players can ride - minecarts, which
+// run along rails, and boats, which float on water.
+
+package gamerules
+
+import (
+	"math"
+
+	"chunkymonkey/physics"
+	. "chunkymonkey/types"
+)
+
+const (
+	// Speeds are in blocks per tick.
+	minecartMaxSpeed     = 0.4
+	minecartMinSpeed     = 0.003
+	minecartFriction     = 0.996 // Fraction of speed kept each tick.
+	minecartSlopeAccel   = 0.0078125
+	minecartPoweredAccel = 0.06
+	minecartBrake        = 0.5 // Fraction of speed kept on unpowered powered rails.
+	minecartRiderPush    = 0.1 // Fraction of the rider's input added to speed.
+
+	boatDraught   = 0.3 // How far the bottom of a boat sits below the surface.
+	boatBuoyancy  = 0.1
+	boatWaterDrag = 0.9 // Fraction of speed kept each tick.
+	boatMinSpeed  = 0.003
+	boatMaxDepth  = 8   // Deepest water that a boat will rise up through.
+	boatRiderPush = 0.2 // Fraction of the rider's input added to velocity.
+
+	// Height of a rider above the position of their vehicle.
+	vehicleRiderHeight = 0.5
+	// Largest input from a rider, in either horizontal direction.
+	vehicleMaxRiderInput = 1
+
+	// Hitting a vehicle this many times in quick succession breaks it.
+	vehicleHitsToBreak = 3
+	// How long a vehicle remembers being hit for.
+	vehicleHitMemory = TicksPerSecond
+	// Speed that a vehicle is pushed at when hit.
+	vehiclePushSpeed = 0.2
+)
+
+// IBlockDataQuerier is used by objects that follow particular types of block,
+// such as minecarts on rails and boats on water. Chunks implement it in
+// addition to physics.IBlockQuerier.
+type IBlockDataQuerier interface {
+	physics.IBlockQuerier
+
+	// BlockDataQuery returns the type and metadata of the block at the given
+	// location. ok is false if the block isn't known.
+	BlockDataQuery(blockLoc BlockXyz) (blockId BlockId, blockData byte, ok bool)
+}
+
+// IsRideable returns true if a player can ride the object.
+func (object *Object) IsRideable() bool {
+	return object.ObjTypeId == ObjTypeIdMinecart || object.ObjTypeId == ObjTypeIdBoat
+}
+
+// Rider returns the EntityId of the player riding the object, or NoEntityId
+// if there is none.
+func (object *Object) Rider() EntityId {
+	return object.rider
+}
+
+func (object *Object) SetRider(rider EntityId) {
+	object.rider = rider
+}
+
+// Steer sets the input of the player riding the vehicle, which pushes it on
+// its next tick. Clients give the motion of a riding player as their input, so
+// values out of the range that they send are ignored.
+func (object *Object) Steer(input *AbsVelocity) {
+	if math.Fabs(float64(input.X)) > vehicleMaxRiderInput || math.Fabs(float64(input.Z)) > vehicleMaxRiderInput {
+		return
+	}
+	object.riderInput = AbsVelocity{input.X, 0, input.Z}
+}
+
+// takeRiderInput returns the input from the rider since the last tick, and
+// clears it.
+func (object *Object) takeRiderInput() (input AbsVelocity) {
+	input = object.riderInput
+	object.riderInput = AbsVelocity{}
+	return
+}
+
+// RiderPosition returns the position of a player riding the object.
+func (object *Object) RiderPosition() AbsXyz {
+	position := *object.Position()
+	position.Y += vehicleRiderHeight
+	return position
+}
+
+// DroppedItem returns the item that the object turns back into when broken.
+// ok is false if it doesn't become an item.
+func (object *Object) DroppedItem() (itemTypeId ItemTypeId, ok bool) {
+	switch object.ObjTypeId {
+	case ObjTypeIdBoat:
+		return ItemTypeIdBoat, true
+	case ObjTypeIdMinecart:
+		return ItemTypeIdMinecart, true
+	case ObjTypeIdStorageCart:
+		return ItemTypeIdStorageCart, true
+	case ObjTypeIdPoweredCart:
+		return ItemTypeIdPoweredCart, true
+	}
+	return
+}
+
+// Hit pushes the object away from the hitter. Hitting a vehicle repeatedly
+// breaks it, in which case destroyed is true and the object should be removed.
+func (object *Object) Hit(from *AbsXyz) (destroyed bool) {
+	if _, ok := object.DroppedItem(); !ok {
+		return false
+	}
+
+	object.hits++
+	object.hitTimer = vehicleHitMemory
+	if object.hits >= vehicleHitsToBreak {
+		return true
+	}
+
+	p := object.Position()
+	dx := float64(p.X - from.X)
+	dz := float64(p.Z - from.Z)
+	if dist := math.Sqrt(dx*dx + dz*dz); dist > 0 {
+		v := object.Velocity()
+		v.X += AbsVelocityCoord(dx / dist * vehiclePushSpeed)
+		v.Z += AbsVelocityCoord(dz / dist * vehiclePushSpeed)
+	}
+
+	return false
+}
+
+// dot returns the component of the velocity in the direction.
+func (dir RailDirection) dot(v *AbsVelocity) float64 {
+	return float64(dir.DX)*float64(v.X) + float64(dir.DZ)*float64(v.Z)
+}
+
+func (dir RailDirection) equals(other RailDirection) bool {
+	return dir.DX == other.DX && dir.DZ == other.DZ
+}
+
+// railAt finds the rail that a minecart at the given position is on. Minecarts
+// going down a slope can briefly be in the block above the rail that they're
+// on, and those going up a slope can be in the block below it.
+func railAt(querier IBlockDataQuerier, p *AbsXyz) (railLoc BlockXyz, blockId BlockId, data byte, ok bool) {
+	loc := p.ToBlockXyz()
+	for _, dy := range []BlockYCoord{0, -1, 1} {
+		checkLoc := loc.AddXyz(0, dy, 0)
+		if checkLoc == nil {
+			continue
+		}
+		if blockId, data, ok = querier.BlockDataQuery(*checkLoc); ok && IsRailBlock(blockId) {
+			return *checkLoc, blockId, data, true
+		}
+	}
+	return railLoc, 0, 0, false
+}
+
+// railHeight returns the height of the rail surface at the given position.
+func railHeight(railLoc *BlockXyz, shape byte, p *AbsXyz) AbsCoord {
+	base := AbsCoord(railLoc.Y)
+	uphill, sloped := RailAscent(shape)
+	if !sloped {
+		return base
+	}
+
+	var along AbsCoord
+	if uphill.DX != 0 {
+		along = p.X - AbsCoord(railLoc.X)
+		if uphill.DX < 0 {
+			along = 1 - along
+		}
+	} else {
+		along = p.Z - AbsCoord(railLoc.Z)
+		if uphill.DZ < 0 {
+			along = 1 - along
+		}
+	}
+
+	if along < 0 {
+		along = 0
+	} else if along > 1 {
+		along = 1
+	}
+
+	return base + along
+}
+
+// tickVehicle updates state common to all vehicles.
+func (object *Object) tickVehicle() {
+	if object.hitTimer > 0 {
+		object.hitTimer--
+		if object.hitTimer == 0 {
+			object.hits = 0
+		}
+	}
+}
+
+// tickMinecart moves a minecart along the rail that it is on, pushed along it
+// by its rider. Minecarts that aren't on a rail move as any other object.
+func (object *Object) tickMinecart(querier IBlockDataQuerier) (leftBlock bool) {
+	object.tickVehicle()
+	input := object.takeRiderInput()
+
+	p := object.Position()
+	v := object.Velocity()
+
+	railLoc, blockId, data, onRail := railAt(querier, p)
+	if !onRail {
+		return object.PointObject.Tick(querier)
+	}
+
+	shape := RailShape(blockId, data)
+	exits, ok := RailExits(shape)
+	if !ok {
+		return object.PointObject.Tick(querier)
+	}
+
+	// The rider pushes the minecart, as a hit does, and the push is turned
+	// along the rail.
+	v.X += AbsVelocityCoord(float64(input.X) * minecartRiderPush)
+	v.Z += AbsVelocityCoord(float64(input.Z) * minecartRiderPush)
+
+	// Work out which way along the rail the minecart is going. When moving
+	// onto a curve, this turns the minecart to follow it.
+	heading := exits[0]
+	if exits[1].dot(v) > exits[0].dot(v) {
+		heading = exits[1]
+	}
+	speed := math.Sqrt(float64(v.X*v.X + v.Z*v.Z))
+
+	if uphill, sloped := RailAscent(shape); sloped {
+		if heading.equals(uphill) {
+			speed -= minecartSlopeAccel
+		} else {
+			speed += minecartSlopeAccel
+		}
+		if speed < 0 {
+			// Rolling back down the slope.
+			heading = exits[1]
+			speed = -speed
+		}
+	}
+
+	if blockId == BlockIdPoweredRail {
+		if data&RailDataFlag != 0 {
+			// Powered rails only boost minecarts that are already moving.
+			if speed > minecartMinSpeed {
+				speed += minecartPoweredAccel
+			}
+		} else {
+			speed *= minecartBrake
+		}
+	}
+
+	speed *= minecartFriction
+	if speed > minecartMaxSpeed {
+		speed = minecartMaxSpeed
+	} else if speed < minecartMinSpeed {
+		speed = 0
+	}
+
+	// Move along the centre line of the rail.
+	centre := railLoc.MidPointToAbsXyz()
+	next := *p
+	if heading.DX != 0 {
+		next.X += AbsCoord(float64(heading.DX) * speed)
+		next.Z = centre.Z
+	} else {
+		next.X = centre.X
+		next.Z += AbsCoord(float64(heading.DZ) * speed)
+	}
+
+	if nextRailLoc, nextId, nextData, ok := railAt(querier, &next); ok {
+		next.Y = railHeight(&nextRailLoc, RailShape(nextId, nextData), &next)
+	} else if isSolid, _ := querier.BlockQuery(*next.ToBlockXyz()); isSolid {
+		// Reached the end of the track.
+		next = *p
+		speed = 0
+	}
+
+	oldChunkLoc := p.ToChunkXz()
+
+	*p = next
+	*v = AbsVelocity{
+		AbsVelocityCoord(float64(heading.DX) * speed),
+		0,
+		AbsVelocityCoord(float64(heading.DZ) * speed),
+	}
+
+	newChunkLoc := p.ToChunkXz()
+	return oldChunkLoc.X != newChunkLoc.X || oldChunkLoc.Z != newChunkLoc.Z
+}
+
+func isWater(querier IBlockDataQuerier, loc *BlockXyz) bool {
+	blockId, _, ok := querier.BlockDataQuery(*loc)
+	return ok && (blockId == BlockIdWater || blockId == BlockIdStationaryWater)
+}
+
+// waterSurface returns the height of the surface of the water that an object
+// at the given position is floating in or just above. ok is false if there is
+// no such water.
+func waterSurface(querier IBlockDataQuerier, p *AbsXyz) (surface AbsCoord, ok bool) {
+	loc := p.ToBlockXyz()
+	if !isWater(querier, loc) {
+		if loc = loc.AddXyz(0, -1, 0); loc == nil || !isWater(querier, loc) {
+			return 0, false
+		}
+	}
+
+	for i := 0; i < boatMaxDepth; i++ {
+		above := loc.AddXyz(0, 1, 0)
+		if above == nil || !isWater(querier, above) {
+			break
+		}
+		loc = above
+	}
+
+	return AbsCoord(loc.Y) + 1, true
+}
+
+func slowVelocityCoord(v *AbsVelocityCoord, keep, min float64) {
+	*v = AbsVelocityCoord(float64(*v) * keep)
+	if *v > -AbsVelocityCoord(min) && *v < AbsVelocityCoord(min) {
+		*v = 0
+	}
+}
+
+// tickBoat floats a boat on the water that it is in, paddled by its rider.
+// Boats that aren't in water move as any other object.
+func (object *Object) tickBoat(querier IBlockDataQuerier) (leftBlock bool) {
+	object.tickVehicle()
+	input := object.takeRiderInput()
+
+	p := object.Position()
+	v := object.Velocity()
+
+	surface, inWater := waterSurface(querier, p)
+	if !inWater {
+		return object.PointObject.Tick(querier)
+	}
+
+	v.X += AbsVelocityCoord(float64(input.X) * boatRiderPush)
+	v.Z += AbsVelocityCoord(float64(input.Z) * boatRiderPush)
+
+	// Bob towards floating height, and drift to a halt.
+	v.Y = AbsVelocityCoord((surface - boatDraught - p.Y) * boatBuoyancy)
+	slowVelocityCoord(&v.X, boatWaterDrag, boatMinSpeed)
+	slowVelocityCoord(&v.Y, 1, boatMinSpeed)
+	slowVelocityCoord(&v.Z, boatWaterDrag, boatMinSpeed)
+
+	oldChunkLoc := p.ToChunkXz()
+
+	// Move, stopping at the shore.
+	next := *p
+	next.X += AbsCoord(v.X)
+	if isSolid, _ := querier.BlockQuery(*next.ToBlockXyz()); isSolid {
+		next.X = p.X
+		v.X = 0
+	}
+	next.Z += AbsCoord(v.Z)
+	if isSolid, _ := querier.BlockQuery(*next.ToBlockXyz()); isSolid {
+		next.Z = p.Z
+		v.Z = 0
+	}
+	next.Y += AbsCoord(v.Y)
+	*p = next
+
+	newChunkLoc := p.ToChunkXz()
+	return oldChunkLoc.X != newChunkLoc.X || oldChunkLoc.Z != newChunkLoc.Z
+}
@@ -0,0 +1,234 @@
+package gamerules
+
+import (
+	"testing"
+
+	. "chunkymonkey/types"
+)
+
+const testGroundLevel = 64
+
+type testBlock struct {
+	loc     BlockXyz
+	blockId BlockId
+	data    byte
+}
+
+// testBlockQuerier is a world for vehicles to move in. Blocks that aren't
+// listed are stone below testGroundLevel, and air otherwise.
+type testBlockQuerier []testBlock
+
+func (querier testBlockQuerier) BlockDataQuery(loc BlockXyz) (blockId BlockId, blockData byte, ok bool) {
+	for _, block := range querier {
+		if block.loc.X == loc.X && block.loc.Y == loc.Y && block.loc.Z == loc.Z {
+			return block.blockId, block.data, true
+		}
+	}
+	if loc.Y < testGroundLevel {
+		return BlockId(1), 0, true
+	}
+	return BlockIdAir, 0, true
+}
+
+func (querier testBlockQuerier) BlockQuery(loc BlockXyz) (isSolid bool, isWithinChunk bool) {
+	blockId, _, _ := querier.BlockDataQuery(loc)
+	isSolid = blockId != BlockIdAir && !IsRailBlock(blockId) && blockId != BlockIdWater && blockId != BlockIdStationaryWater
+	return isSolid, true
+}
+
+func newTestVehicle(objType ObjTypeId, position AbsXyz, velocity AbsVelocity) *Object {
+	object := NewObject(objType)
+	object.PointObject.Init(&position, &velocity)
+	return object
+}
+
+func TestMinecartFollowsStraightRail(t *testing.T) {
+	querier := testBlockQuerier{
+		{BlockXyz{0, 64, 0}, BlockIdRail, RailShapeAlongX},
+		{BlockXyz{1, 64, 0}, BlockIdRail, RailShapeAlongX},
+	}
+	cart := newTestVehicle(ObjTypeIdMinecart, AbsXyz{0.5, 64, 0.3}, AbsVelocity{0.2, 0, 0.1})
+
+	cart.Tick(querier)
+
+	p := cart.Position()
+	if p.X <= 0.5 || p.Y != 64 || p.Z != 0.5 {
+		t.Errorf("expected minecart to move along X on centre of rail, got %v", *p)
+	}
+	if v := cart.Velocity(); v.X <= 0 || v.Y != 0 || v.Z != 0 {
+		t.Errorf("expected minecart velocity along X, got %v", *v)
+	}
+}
+
+func TestMinecartFollowsCurve(t *testing.T) {
+	querier := testBlockQuerier{
+		{BlockXyz{-1, 64, 0}, BlockIdRail, RailShapeAlongX},
+		{BlockXyz{0, 64, 0}, BlockIdRail, RailShapeCurvePosZNegX},
+		{BlockXyz{0, 64, 1}, BlockIdRail, RailShapeAlongZ},
+	}
+	cart := newTestVehicle(ObjTypeIdMinecart, AbsXyz{0.05, 64, 0.5}, AbsVelocity{0.2, 0, 0})
+
+	cart.Tick(querier)
+
+	if p := cart.Position(); p.X != 0.5 || p.Z <= 0.5 {
+		t.Errorf("expected minecart to turn onto Z, got position %v", *p)
+	}
+	if v := cart.Velocity(); v.X != 0 || v.Z <= 0 {
+		t.Errorf("expected minecart to turn onto Z, got velocity %v", *v)
+	}
+}
+
+func TestMinecartRollsDownSlope(t *testing.T) {
+	querier := testBlockQuerier{
+		{BlockXyz{-1, 64, 0}, BlockIdRail, RailShapeAlongX},
+		{BlockXyz{0, 64, 0}, BlockIdRail, RailShapeAscendingPosX},
+		{BlockXyz{1, 65, 0}, BlockIdRail, RailShapeAlongX},
+	}
+	cart := newTestVehicle(ObjTypeIdMinecart, AbsXyz{0.5, 64.5, 0.5}, AbsVelocity{})
+
+	for i := 0; i < 5; i++ {
+		cart.Tick(querier)
+	}
+
+	p := cart.Position()
+	if p.X >= 0.5 || p.Y >= 64.5 || p.Y < 64 {
+		t.Errorf("expected minecart to roll down the slope, got position %v", *p)
+	}
+	if v := cart.Velocity(); v.X >= 0 {
+		t.Errorf("expected minecart to roll down the slope, got velocity %v", *v)
+	}
+}
+
+func TestMinecartPoweredRail(t *testing.T) {
+	type Test struct {
+		desc    string
+		data    byte
+		speedUp bool
+	}
+
+	tests := []Test{
+		{"powered", RailShapeAlongX | RailDataFlag, true},
+		{"unpowered", RailShapeAlongX, false},
+	}
+
+	for _, test := range tests {
+		querier := testBlockQuerier{
+			{BlockXyz{0, 64, 0}, BlockIdPoweredRail, test.data},
+		}
+		cart := newTestVehicle(ObjTypeIdMinecart, AbsXyz{0.1, 64, 0.5}, AbsVelocity{0.1, 0, 0})
+
+		cart.Tick(querier)
+
+		if v := cart.Velocity(); (v.X > 0.1) != test.speedUp {
+			t.Errorf("%s: expected speed up = %t, got velocity %v", test.desc, test.speedUp, *v)
+		}
+	}
+}
+
+func TestMinecartStopsAtEndOfTrack(t *testing.T) {
+	querier := testBlockQuerier{
+		{BlockXyz{0, 64, 0}, BlockIdRail, RailShapeAlongX},
+		{BlockXyz{1, 64, 0}, BlockId(1), 0},
+	}
+	cart := newTestVehicle(ObjTypeIdMinecart, AbsXyz{0.9, 64, 0.5}, AbsVelocity{0.2, 0, 0})
+
+	cart.Tick(querier)
+
+	if p := cart.Position(); p.X != 0.9 {
+		t.Errorf("expected minecart to stop at end of track, got position %v", *p)
+	}
+	if v := cart.Velocity(); v.X != 0 {
+		t.Errorf("expected minecart to stop at end of track, got velocity %v", *v)
+	}
+}
+
+func TestBoatFloats(t *testing.T) {
+	querier := testBlockQuerier{
+		{BlockXyz{0, 62, 0}, BlockIdStationaryWater, 0},
+		{BlockXyz{0, 63, 0}, BlockIdStationaryWater, 0},
+	}
+	boat := newTestVehicle(ObjTypeIdBoat, AbsXyz{0.5, 62.2, 0.5}, AbsVelocity{})
+
+	for i := 0; i < 100; i++ {
+		boat.Tick(querier)
+	}
+
+	surface := AbsCoord(testGroundLevel)
+	if p := boat.Position(); p.Y < surface-1 || p.Y > surface {
+		t.Errorf("expected boat to float up to the surface at %v, got position %v", surface, *p)
+	}
+}
+
+func TestVehicleHit(t *testing.T) {
+	cart := newTestVehicle(ObjTypeIdMinecart, AbsXyz{0.5, 64, 0.5}, AbsVelocity{})
+	from := &AbsXyz{-1.5, 64, 0.5}
+
+	if cart.Hit(from) {
+		t.Fatalf("expected first hit not to break the minecart")
+	}
+	if v := cart.Velocity(); v.X <= 0 || v.Z != 0 {
+		t.Errorf("expected minecart to be pushed away from the hitter, got velocity %v", *v)
+	}
+
+	for i := 1; i < vehicleHitsToBreak-1; i++ {
+		cart.Hit(from)
+	}
+	if !cart.Hit(from) {
+		t.Errorf("expected minecart to break after %d hits", vehicleHitsToBreak)
+	}
+}
+
+func TestVehicleSteer(t *testing.T) {
+	rails := testBlockQuerier{
+		{BlockXyz{-1, 64, 0}, BlockIdRail, RailShapeAlongX},
+		{BlockXyz{0, 64, 0}, BlockIdRail, RailShapeAlongX},
+		{BlockXyz{1, 64, 0}, BlockIdRail, RailShapeAlongX},
+	}
+	water := testBlockQuerier{
+		{BlockXyz{0, 62, 0}, BlockIdStationaryWater, 0},
+		{BlockXyz{0, 63, 0}, BlockIdStationaryWater, 0},
+	}
+
+	type Test struct {
+		desc     string
+		objType  ObjTypeId
+		querier  testBlockQuerier
+		position AbsXyz
+		input    AbsVelocity
+		wantX    int // Sign of the expected velocity along X.
+		wantZ    int // Sign of the expected velocity along Z.
+	}
+
+	tests := []Test{
+		{"minecart pushed forward", ObjTypeIdMinecart, rails, AbsXyz{0.5, 64, 0.5}, AbsVelocity{0.1, 0, 0}, 1, 0},
+		{"minecart pushed back", ObjTypeIdMinecart, rails, AbsXyz{0.5, 64, 0.5}, AbsVelocity{-0.1, 0, 0}, -1, 0},
+		{"boat paddled", ObjTypeIdBoat, water, AbsXyz{0.5, 63.7, 0.5}, AbsVelocity{0, 0, 0.1}, 0, 1},
+		{"input out of range", ObjTypeIdBoat, water, AbsXyz{0.5, 63.7, 0.5}, AbsVelocity{0, 0, 5}, 0, 0},
+	}
+
+	sign := func(v AbsVelocityCoord) int {
+		switch {
+		case v > 0:
+			return 1
+		case v < 0:
+			return -1
+		}
+		return 0
+	}
+
+	for _, test := range tests {
+		vehicle := newTestVehicle(test.objType, test.position, AbsVelocity{})
+		vehicle.Steer(&test.input)
+		vehicle.Tick(test.querier)
+
+		v := vehicle.Velocity()
+		if sign(v.X) != test.wantX || sign(v.Z) != test.wantZ {
+			t.Errorf("%s: expected velocity with signs (%d, %d), got %v", test.desc, test.wantX, test.wantZ, *v)
+		}
+
+		// The input only pushes the vehicle once.
+		if input := vehicle.takeRiderInput(); input.X != 0 || input.Z != 0 {
+			t.Errorf("%s: expected input to be used up, got %v", test.desc, input)
+		}
+	}
+}
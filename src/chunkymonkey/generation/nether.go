@@ -0,0 +1,141 @@
+package generation
+
+import (
+	"os"
+
+	"chunkymonkey/chunkstore"
+	. "chunkymonkey/types"
+	"perlin"
+)
+
+const (
+	// Lava fills any open space in the Nether at or below this height.
+	NetherLavaLevel = 31
+
+	netherFloorBase   = 36
+	netherCeilingBase = 96
+
+	// Glowstone hangs from the ceiling where glowSource exceeds this value.
+	netherGlowThreshold = 0.55
+	netherGlowMaxLength = 4
+)
+
+// NetherGenerator implements chunkstore.IChunkStore, generating the Nether
+// dimension. It produces a netherrack cavern bounded by bedrock, with seas of
+// lava at the bottom and clusters of glowstone hanging from the roof.
+type NetherGenerator struct {
+	floorSource   ISource
+	ceilingSource ISource
+	glowSource    ISource
+}
+
+func NewNetherGenerator(seed int64) *NetherGenerator {
+	perlin := perlin.NewPerlinNoise(seed)
+
+	return &NetherGenerator{
+		floorSource: &Sum{
+			Inputs: []ISource{
+				&Scale{
+					Wavelength: 60,
+					Amplitude:  16,
+					Source:     perlin,
+				},
+				&Scale{
+					Wavelength: 8,
+					Amplitude:  3,
+					Source:     perlin,
+				},
+			},
+		},
+		ceilingSource: &Sum{
+			Inputs: []ISource{
+				&Scale{
+					Wavelength: 50,
+					Amplitude:  14,
+					Source:     &Offset{30.3, 0, perlin},
+				},
+				&Scale{
+					Wavelength: 6,
+					Amplitude:  3,
+					Source:     &Offset{30.3, 0, perlin},
+				},
+			},
+		},
+		glowSource: &Scale{
+			Wavelength: 4,
+			Amplitude:  1,
+			Source:     &Offset{0, 70.7, perlin},
+		},
+	}
+}
+
+func (gen *NetherGenerator) LoadChunk(chunkLoc ChunkXz) (reader chunkstore.IChunkReader, err os.Error) {
+	baseBlockXyz := chunkLoc.ChunkCornerBlockXY()
+
+	baseX, baseZ := baseBlockXyz.X, baseBlockXyz.Z
+
+	data := newChunkData(chunkLoc)
+
+	baseIndex := BlockIndex(0)
+	heightMapIndex := 0
+	for x := 0; x < ChunkSizeH; x++ {
+		for z := 0; z < ChunkSizeH; z++ {
+			xf, zf := float64(x)+float64(baseX), float64(z)+float64(baseZ)
+
+			floor := clampHeight(int(netherFloorBase+gen.floorSource.At2d(xf, zf)), 1, ChunkSizeY-2)
+			ceiling := clampHeight(int(netherCeilingBase+gen.ceilingSource.At2d(xf, zf)), floor+1, ChunkSizeY-2)
+
+			glowLength := 0
+			if glow := gen.glowSource.At2d(xf, zf); glow > netherGlowThreshold {
+				glowLength = 1 + int((glow-netherGlowThreshold)*10)
+				if glowLength > netherGlowMaxLength {
+					glowLength = netherGlowMaxLength
+				}
+			}
+
+			gen.setBlockStack(
+				floor, ceiling, glowLength,
+				data.blocks[baseIndex:baseIndex+ChunkSizeY])
+
+			// There is no sky in the Nether, so sky light is left at zero.
+			data.heightMap[heightMapIndex] = ChunkSizeY - 1
+
+			heightMapIndex++
+			baseIndex += ChunkSizeY
+		}
+	}
+
+	return data, nil
+}
+
+func (gen *NetherGenerator) setBlockStack(floor, ceiling, glowLength int, blocks []byte) {
+	blocks[0] = byte(BlockIdBedrock)
+	blocks[ChunkSizeY-1] = byte(BlockIdBedrock)
+
+	for y := 1; y <= floor; y++ {
+		blocks[y] = byte(BlockIdNetherrack)
+	}
+
+	for y := floor + 1; y <= NetherLavaLevel && y < ceiling; y++ {
+		blocks[y] = byte(BlockIdStationaryLava)
+	}
+
+	for y := ceiling; y < ChunkSizeY-1; y++ {
+		blocks[y] = byte(BlockIdNetherrack)
+	}
+
+	// Glowstone must hang into open air, so it stops short of the floor and
+	// any lava.
+	for y := ceiling - 1; y > ceiling-1-glowLength && y > floor+1 && y > NetherLavaLevel; y-- {
+		blocks[y] = byte(BlockIdGlowstone)
+	}
+}
+
+func clampHeight(height, min, max int) int {
+	if height < min {
+		return min
+	} else if height > max {
+		return max
+	}
+	return height
+}
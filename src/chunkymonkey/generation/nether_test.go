@@ -0,0 +1,56 @@
+package generation
+
+import (
+	"testing"
+
+	. "chunkymonkey/types"
+)
+
+func TestNetherGenerator_LoadChunk(t *testing.T) {
+	gen := NewNetherGenerator(0)
+
+	for _, loc := range []ChunkXz{{0, 0}, {-5, 3}, {100, -100}} {
+		reader, err := gen.LoadChunk(loc)
+		if err != nil {
+			t.Fatalf("LoadChunk(%v) returned error: %v", loc, err)
+		}
+
+		blocks := reader.Blocks()
+		for column := 0; column < ChunkSizeH*ChunkSizeH; column++ {
+			stack := blocks[column*ChunkSizeY : (column+1)*ChunkSizeY]
+
+			if stack[0] != byte(BlockIdBedrock) || stack[ChunkSizeY-1] != byte(BlockIdBedrock) {
+				t.Errorf("Chunk %v column %d: expected bedrock floor and roof", loc, column)
+			}
+
+			for y := 1; y < ChunkSizeY-1; y++ {
+				switch BlockId(stack[y]) {
+				case BlockIdAir:
+					if y <= NetherLavaLevel {
+						t.Errorf("Chunk %v column %d: air at y=%d below lava level", loc, column, y)
+					}
+				case BlockIdStationaryLava:
+					if y > NetherLavaLevel {
+						t.Errorf("Chunk %v column %d: lava at y=%d above lava level", loc, column, y)
+					}
+				case BlockIdNetherrack, BlockIdGlowstone:
+				default:
+					t.Errorf("Chunk %v column %d: unexpected block %d at y=%d", loc, column, stack[y], y)
+				}
+			}
+		}
+	}
+}
+
+func Benchmark_NetherGenerator_generate(b *testing.B) {
+	gen := NewNetherGenerator(0)
+	var loc ChunkXz
+
+	b.ResetTimer()
+	b.StartTimer()
+
+	for i := 0; i < b.N; i++ {
+		loc.X = ChunkCoord(i & 0xffff)
+		gen.LoadChunk(loc)
+	}
+}
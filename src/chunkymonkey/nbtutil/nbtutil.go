@@ -116,3 +116,30 @@ func ReadLookDegrees(tag nbt.ITag, path string) (pos LookDegrees, err os.Error)
 
 	return LookDegrees{AngleDegrees(x), AngleDegrees(y)}, nil
 }
+
+func WriteFloat2(x, y float32) *nbt.List {
+	return &nbt.List{nbt.TagFloat, []nbt.ITag{
+		&nbt.Float{x},
+		&nbt.Float{y},
+	}}
+}
+
+func WriteDouble3(x, y, z float64) *nbt.List {
+	return &nbt.List{nbt.TagDouble, []nbt.ITag{
+		&nbt.Double{x},
+		&nbt.Double{y},
+		&nbt.Double{z},
+	}}
+}
+
+func WriteAbsXyz(pos *AbsXyz) *nbt.List {
+	return WriteDouble3(float64(pos.X), float64(pos.Y), float64(pos.Z))
+}
+
+func WriteAbsVelocity(v *AbsVelocity) *nbt.List {
+	return WriteDouble3(float64(v.X), float64(v.Y), float64(v.Z))
+}
+
+func WriteLookDegrees(look *LookDegrees) *nbt.List {
+	return WriteFloat2(float32(look.Yaw), float32(look.Pitch))
+}
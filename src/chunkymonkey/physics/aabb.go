@@ -0,0 +1,59 @@
+package physics
+
+import (
+	. "chunkymonkey/types"
+)
+
+// Aabb is an axis-aligned bounding box.
+type Aabb struct {
+	Min, Max AbsXyz
+}
+
+// NewAabbAround returns the bounding box for an entity standing at position,
+// extending halfWidth either side of it horizontally and height above it.
+func NewAabbAround(position *AbsXyz, halfWidth, height AbsCoord) Aabb {
+	return Aabb{
+		Min: AbsXyz{position.X - halfWidth, position.Y, position.Z - halfWidth},
+		Max: AbsXyz{position.X + halfWidth, position.Y + height, position.Z + halfWidth},
+	}
+}
+
+// clipSegmentAxis narrows [tMin, tMax], the range of fractions along a line
+// segment, to the part where the segment lies between min and max on one axis.
+func clipSegmentAxis(from, to, min, max AbsCoord, tMin, tMax *float64) bool {
+	d := float64(to - from)
+	if d == 0 {
+		// Parallel to the slab, so either always or never within it.
+		return from >= min && from <= max
+	}
+
+	t1 := float64(min-from) / d
+	t2 := float64(max-from) / d
+	if t1 > t2 {
+		t1, t2 = t2, t1
+	}
+	if t1 > *tMin {
+		*tMin = t1
+	}
+	if t2 < *tMax {
+		*tMax = t2
+	}
+	return *tMin <= *tMax
+}
+
+// SegmentIntersect tests if the line segment from one point to another passes
+// through the box. If it does, t is the fraction of the way along the segment
+// at which it enters the box (0 if it starts inside it).
+func (box *Aabb) SegmentIntersect(from, to *AbsXyz) (t float64, hit bool) {
+	tMin, tMax := 0.0, 1.0
+	if !clipSegmentAxis(from.X, to.X, box.Min.X, box.Max.X, &tMin, &tMax) {
+		return 0, false
+	}
+	if !clipSegmentAxis(from.Y, to.Y, box.Min.Y, box.Max.Y, &tMin, &tMax) {
+		return 0, false
+	}
+	if !clipSegmentAxis(from.Z, to.Z, box.Min.Z, box.Max.Z, &tMin, &tMax) {
+		return 0, false
+	}
+	return tMin, true
+}
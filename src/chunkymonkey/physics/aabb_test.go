@@ -0,0 +1,38 @@
+package physics
+
+import (
+	"testing"
+
+	. "chunkymonkey/types"
+)
+
+func TestAabb_SegmentIntersect(t *testing.T) {
+	box := NewAabbAround(&AbsXyz{0, 0, 0}, 0.5, 2)
+
+	type Test struct {
+		desc     string
+		from, to AbsXyz
+		wantHit  bool
+		wantT    float64
+	}
+
+	tests := []Test{
+		{"straight through", AbsXyz{-2, 1, 0}, AbsXyz{2, 1, 0}, true, 0.375},
+		{"stops short", AbsXyz{-2, 1, 0}, AbsXyz{-1, 1, 0}, false, 0},
+		{"passes over", AbsXyz{-2, 3, 0}, AbsXyz{2, 3, 0}, false, 0},
+		{"passes beside", AbsXyz{-2, 1, 1}, AbsXyz{2, 1, 1}, false, 0},
+		{"starts inside", AbsXyz{0, 1, 0}, AbsXyz{2, 1, 0}, true, 0},
+		{"falls onto top", AbsXyz{0, 4, 0}, AbsXyz{0, 0, 0}, true, 0.5},
+		{"diagonal", AbsXyz{-1.5, 0.5, -1.5}, AbsXyz{1.5, 0.5, 1.5}, true, 1.0 / 3},
+		{"diagonal miss", AbsXyz{-1.5, 0.5, 0}, AbsXyz{0, 0.5, 1.5}, false, 0},
+	}
+
+	for _, test := range tests {
+		tHit, hit := box.SegmentIntersect(&test.from, &test.to)
+		if hit != test.wantHit {
+			t.Errorf("%s: expected hit=%t, got %t", test.desc, test.wantHit, hit)
+		} else if hit && !almostEqual(tHit, test.wantT) {
+			t.Errorf("%s: expected t=%f, got %f", test.desc, test.wantT, tHit)
+		}
+	}
+}
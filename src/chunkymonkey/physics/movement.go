@@ -0,0 +1,84 @@
+package physics
+
+import (
+	"io"
+	"os"
+
+	"chunkymonkey/proto"
+	. "chunkymonkey/types"
+)
+
+// The number of movement updates between those that send an entity's full
+// position, so that clients that missed some relative moves (e.g if they were
+// dropped for falling behind) get back in step.
+const teleportInterval = 20
+
+// Movement tracks the position and look of an entity that were last sent to
+// clients, and sends them the changes since.
+type Movement struct {
+	LastSentPosition AbsIntXyz
+	LastSentLook     LookBytes
+
+	// The number of updates sent since the full position was last sent.
+	sinceTeleport int
+}
+
+// Reset sets the position and look last sent, and that the full position is
+// to be sent next. It is used when clients may have been sent the entity's
+// position by some other means.
+func (m *Movement) Reset(position *AbsIntXyz, look *LookBytes) {
+	m.LastSentPosition = *position
+	m.LastSentLook = *look
+	m.sinceTeleport = teleportInterval
+}
+
+// SendMovement writes the packet that moves the entity from where it was last
+// sent to the given position and look, if either have changed. Small moves
+// are sent relative to the last position, and others, as well as every
+// teleportInterval'th update, in full.
+func (m *Movement) SendMovement(writer io.Writer, entityId EntityId, position *AbsIntXyz, look *LookBytes) (err os.Error) {
+	dx := position.X - m.LastSentPosition.X
+	dy := position.Y - m.LastSentPosition.Y
+	dz := position.Z - m.LastSentPosition.Z
+	moved := dx != 0 || dy != 0 || dz != 0
+	looked := look.Yaw != m.LastSentLook.Yaw || look.Pitch != m.LastSentLook.Pitch
+
+	m.sinceTeleport++
+
+	switch {
+	case m.sinceTeleport >= teleportInterval || !isRelMove(dx) || !isRelMove(dy) || !isRelMove(dz):
+		err = proto.WriteEntityTeleport(writer, entityId, position, look)
+		m.sinceTeleport = 0
+	case moved && looked:
+		err = proto.WriteEntityLookAndRelMove(
+			writer, entityId,
+			&RelMove{RelMoveCoord(dx), RelMoveCoord(dy), RelMoveCoord(dz)},
+			look)
+	case moved:
+		err = proto.WriteEntityRelMove(
+			writer, entityId,
+			&RelMove{RelMoveCoord(dx), RelMoveCoord(dy), RelMoveCoord(dz)})
+	case looked:
+		err = proto.WriteEntityLook(writer, entityId, look)
+	}
+	if err != nil {
+		return
+	}
+
+	m.LastSentPosition = *position
+	m.LastSentLook = *look
+	return
+}
+
+// SendTeleport writes the packet that moves the entity to the position and look
+// last sent, in full. It is used when a client has missed some of the
+// entity's movement.
+func (m *Movement) SendTeleport(writer io.Writer, entityId EntityId) os.Error {
+	return proto.WriteEntityTeleport(writer, entityId, &m.LastSentPosition, &m.LastSentLook)
+}
+
+// isRelMove returns true if the change in a coordinate can be sent as a
+// relative move.
+func isRelMove(d AbsIntCoord) bool {
+	return d >= -128 && d <= 127
+}
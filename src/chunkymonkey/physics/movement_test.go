@@ -0,0 +1,150 @@
+package physics
+
+import (
+	"bytes"
+	"testing"
+
+	"chunkymonkey/proto"
+	. "chunkymonkey/types"
+)
+
+func TestMovement_SendMovement(t *testing.T) {
+	type Test struct {
+		desc     string
+		position AbsIntXyz
+		look     LookBytes
+		expected func(buf *bytes.Buffer)
+	}
+
+	tests := []Test{
+		Test{
+			"unchanged",
+			AbsIntXyz{0, 0, 0}, LookBytes{0, 0},
+			func(buf *bytes.Buffer) {},
+		},
+		Test{
+			"small move",
+			AbsIntXyz{10, -5, 127}, LookBytes{0, 0},
+			func(buf *bytes.Buffer) {
+				proto.WriteEntityRelMove(buf, 1, &RelMove{10, -5, 127})
+			},
+		},
+		Test{
+			"look",
+			AbsIntXyz{10, -5, 127}, LookBytes{20, 30},
+			func(buf *bytes.Buffer) {
+				proto.WriteEntityLook(buf, 1, &LookBytes{20, 30})
+			},
+		},
+		Test{
+			"small move and look",
+			AbsIntXyz{0, 0, 0}, LookBytes{0, 0},
+			func(buf *bytes.Buffer) {
+				proto.WriteEntityLookAndRelMove(buf, 1, &RelMove{-10, 5, -127}, &LookBytes{0, 0})
+			},
+		},
+		Test{
+			"large move",
+			AbsIntXyz{0, 0, -129}, LookBytes{0, 0},
+			func(buf *bytes.Buffer) {
+				proto.WriteEntityTeleport(buf, 1, &AbsIntXyz{0, 0, -129}, &LookBytes{0, 0})
+			},
+		},
+	}
+
+	var m Movement
+	m.LastSentPosition = AbsIntXyz{0, 0, 0}
+
+	for _, test := range tests {
+		expected := new(bytes.Buffer)
+		test.expected(expected)
+
+		result := new(bytes.Buffer)
+		if err := m.SendMovement(result, 1, &test.position, &test.look); err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.desc, err)
+		}
+		if !bytes.Equal(expected.Bytes(), result.Bytes()) {
+			t.Errorf("%s: expected %x, got %x", test.desc, expected.Bytes(), result.Bytes())
+		}
+	}
+}
+
+func TestMovement_Resync(t *testing.T) {
+	var m Movement
+	m.Reset(&AbsIntXyz{0, 0, 0}, &LookBytes{0, 0})
+
+	position := AbsIntXyz{0, 0, 0}
+	teleports := 0
+	for i := 0; i < 2*teleportInterval; i++ {
+		position.X += 4
+		buf := new(bytes.Buffer)
+		m.SendMovement(buf, 1, &position, &LookBytes{0, 0})
+
+		expected := new(bytes.Buffer)
+		proto.WriteEntityTeleport(expected, 1, &position, &LookBytes{0, 0})
+		if bytes.Equal(expected.Bytes(), buf.Bytes()) {
+			teleports++
+		}
+	}
+
+	// One teleport straight after the reset, and one periodic resync.
+	if teleports != 2 {
+		t.Errorf("Expected 2 teleports, got %d", teleports)
+	}
+}
+
+// walkBytes returns the number of bytes written by send for a second of an
+// entity walking and turning, as sent to each of its viewers every tick.
+func walkBytes(send func(buf *bytes.Buffer, position *AbsIntXyz, look *LookBytes)) int {
+	buf := new(bytes.Buffer)
+	position := AbsIntXyz{0, 64 * PixelsPerBlock, 0}
+	look := LookBytes{0, 0}
+	for i := 0; i < TicksPerSecond; i++ {
+		// About walking speed (4.3 blocks/s).
+		position.X += 7
+		position.Z += 2
+		look.Yaw += 3
+		send(buf, &position, &look)
+	}
+	return buf.Len()
+}
+
+func teleportWalk(buf *bytes.Buffer, position *AbsIntXyz, look *LookBytes) {
+	proto.WriteEntityTeleport(buf, 1, position, look)
+}
+
+// The number of bytes per second sent for a walking entity are reported as the
+// bytes processed per operation, to compare with
+// Benchmark_Movement_Teleport.
+func Benchmark_Movement_SendMovement(b *testing.B) {
+	var m Movement
+	send := func(buf *bytes.Buffer, position *AbsIntXyz, look *LookBytes) {
+		m.SendMovement(buf, 1, position, look)
+	}
+
+	var n int
+	for i := 0; i < b.N; i++ {
+		n = walkBytes(send)
+	}
+	b.SetBytes(int64(n))
+}
+
+func Benchmark_Movement_Teleport(b *testing.B) {
+	var n int
+	for i := 0; i < b.N; i++ {
+		n = walkBytes(teleportWalk)
+	}
+	b.SetBytes(int64(n))
+}
+
+func TestMovement_Bandwidth(t *testing.T) {
+	var m Movement
+	relative := walkBytes(func(buf *bytes.Buffer, position *AbsIntXyz, look *LookBytes) {
+		m.SendMovement(buf, 1, position, look)
+	})
+	teleport := walkBytes(teleportWalk)
+
+	if relative*3 > teleport*2 {
+		t.Errorf("Expected relative moves to save at least a third of the %d bytes/s of teleports, got %d bytes/s", teleport, relative)
+	}
+}
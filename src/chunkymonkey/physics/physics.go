@@ -40,7 +40,7 @@ type IBlockQuerier interface {
 
 type PointObject struct {
 	// Used in knowing what to send as client updates
-	LastSentPosition AbsIntXyz
+	Movement
 	LastSentVelocity Velocity
 
 	// Used in physical modelling
@@ -54,6 +54,10 @@ func (obj *PointObject) Position() *AbsXyz {
 	return &obj.position
 }
 
+func (obj *PointObject) Velocity() *AbsVelocity {
+	return &obj.velocity
+}
+
 func (obj *PointObject) Init(position *AbsXyz, velocity *AbsVelocity) {
 	obj.LastSentPosition = *position.ToAbsIntXyz()
 	obj.LastSentVelocity = *velocity.ToVelocity()
@@ -67,7 +71,9 @@ func (obj *PointObject) ReadNbt(tag nbt.ITag) (err os.Error) {
 	if obj.position, err = nbtutil.ReadAbsXyz(tag, "Pos"); err != nil {
 		return
 	}
-	obj.LastSentPosition = *obj.position.ToAbsIntXyz()
+	// Clients may have been sent the object's position by a chunk server that
+	// it was transferred from.
+	obj.Movement.Reset(obj.position.ToAbsIntXyz(), &LookBytes{})
 
 	// Motion
 	if obj.velocity, err = nbtutil.ReadAbsVelocity(tag, "Motion"); err != nil {
@@ -82,37 +88,31 @@ func (obj *PointObject) ReadNbt(tag nbt.ITag) (err os.Error) {
 	return nil
 }
 
+// WriteNbt returns the object's position and motion, in the form that ReadNbt
+// reads them.
+func (obj *PointObject) WriteNbt() *nbt.Compound {
+	var onGround int8
+	if obj.onGround {
+		onGround = 1
+	}
+
+	return &nbt.Compound{
+		map[string]nbt.ITag{
+			"Pos":      nbtutil.WriteAbsXyz(&obj.position),
+			"Motion":   nbtutil.WriteAbsVelocity(&obj.velocity),
+			"OnGround": &nbt.Byte{onGround},
+		},
+	}
+}
+
 // Generates any packets needed to update clients as to the position and
 // velocity of the object.
 // It assumes that the clients have either been sent packets via this method
 // before, or that the previous position/velocity sent was generated from the
 // LastSentPosition and LastSentVelocity attributes.
 func (obj *PointObject) SendUpdate(writer io.Writer, entityId EntityId, look *LookBytes) (err os.Error) {
-	curPosition := obj.position.ToAbsIntXyz()
-
-	dx := curPosition.X - obj.LastSentPosition.X
-	dy := curPosition.Y - obj.LastSentPosition.Y
-	dz := curPosition.Z - obj.LastSentPosition.Z
-
-	if dx != 0 || dy != 0 || dz != 0 {
-		if dx >= -128 && dx <= 127 && dy >= -128 && dy <= 127 && dz >= -128 && dz <= 127 {
-			err = proto.WriteEntityRelMove(
-				writer, entityId,
-				&RelMove{
-					RelMoveCoord(dx),
-					RelMoveCoord(dy),
-					RelMoveCoord(dz),
-				},
-			)
-		} else {
-			err = proto.WriteEntityTeleport(
-				writer, entityId,
-				curPosition, look)
-		}
-		if err != nil {
-			return
-		}
-		obj.LastSentPosition = *curPosition
+	if err = obj.SendMovement(writer, entityId, obj.position.ToAbsIntXyz(), look); err != nil {
+		return
 	}
 
 	curVelocity := obj.velocity.ToVelocity()
@@ -0,0 +1,131 @@
+package player
+
+import (
+	"bytes"
+
+	"chunkymonkey/proto"
+	. "chunkymonkey/types"
+)
+
+// How far from the middle of a bed's head a player can get into it.
+const bedReach = AbsCoord(3)
+
+// sleep puts the player into the bed whose head is at bedLoc, and makes it
+// their spawn point. The shard has marked the bed as occupied by the player,
+// so it is left again if they can't get into it.
+func (player *Player) sleep(bedLoc *BlockXyz) {
+	if player.sleeping != 0 {
+		player.leaveBed(bedLoc)
+		return
+	}
+
+	// Players respawn in the normal dimension, so they can't make a bed
+	// elsewhere their spawn point.
+	if DimensionId(player.dimension) != DimensionNormal {
+		player.leaveBed(bedLoc)
+		buf := new(bytes.Buffer)
+		proto.WriteChatMessage(buf, "You can't sleep in this dimension")
+		player.TransmitPacket(buf.Bytes(), proto.PacketPriorityHigh)
+		return
+	}
+
+	bedPos := bedLoc.MidPointToAbsXyz()
+	if !player.position.IsWithinDistanceOf(&bedPos, bedReach) {
+		player.leaveBed(bedLoc)
+		buf := new(bytes.Buffer)
+		proto.WriteChatMessage(buf, "You may not rest now, the bed is too far away")
+		player.TransmitPacket(buf.Bytes(), proto.PacketPriorityHigh)
+		return
+	}
+
+	player.sleeping = 1
+	player.sleepTimer = 0
+	spawn := *bedLoc
+	player.bedSpawn = &spawn
+
+	buf := new(bytes.Buffer)
+	proto.WriteBedUse(buf, player.EntityId, false, bedLoc)
+	player.multicastPacket(buf.Bytes(), proto.PacketPriorityNormal, true)
+
+	player.game.SetPlayerAsleep(player.EntityId, true)
+}
+
+// WakeUp requests that the player get out of bed, if they are in one.
+func (player *Player) WakeUp() {
+	player.Enqueue(func(_ *Player) {
+		player.wakeUp()
+	})
+}
+
+// wakeUp takes the player out of bed, if they are in one.
+func (player *Player) wakeUp() {
+	if player.sleeping == 0 {
+		return
+	}
+
+	player.sleeping = 0
+	player.sleepTimer = 0
+	if player.bedSpawn != nil {
+		player.leaveBed(player.bedSpawn)
+	}
+
+	buf := new(bytes.Buffer)
+	proto.WriteEntityAnimation(buf, player.EntityId, EntityAnimationLeaveBed)
+	player.multicastPacket(buf.Bytes(), proto.PacketPriorityNormal, true)
+
+	player.game.SetPlayerAsleep(player.EntityId, false)
+}
+
+// leaveBed tells the shard that the player is no longer in the bed whose head
+// is at bedLoc.
+func (player *Player) leaveBed(bedLoc *BlockXyz) {
+	if shardClient, _, ok := player.chunkSubs.ShardClientForBlockXyz(bedLoc); ok {
+		shardClient.ReqLeaveBed(*bedLoc)
+	}
+}
+
+// respawn restores the player's health and moves them to their spawn point,
+// which is the bed they last slept in, or the world's spawn if they have not
+// slept in a bed. Both are in the normal dimension, as players can only sleep
+// there.
+func (player *Player) respawn() {
+	player.wakeUp()
+
+	var position AbsXyz
+	if player.bedSpawn != nil {
+		// Stand on top of the bed.
+		position = *player.bedSpawn.ToAbsXyz()
+		position.Y++
+	} else {
+		position = *player.spawnBlock.ToAbsXyz()
+	}
+
+	player.health = MaxHealth
+	player.food = MaxFood
+	player.foodSaturation = initialFoodSaturation
+	player.foodExhaustion = 0
+
+	// This also sends the respawn packet that the client is waiting for.
+	player.changeDimension(DimensionNormal, position)
+
+	// The player is moved to the world's spawn if the bed has gone.
+	if player.bedSpawn != nil {
+		player.chunkSubs.curShard.ReqCheckBed(*player.bedSpawn)
+	}
+}
+
+// bedMissing is told that the bed at bedLoc no longer exists. If it was the
+// player's spawn point, they are moved to the world's spawn instead.
+func (player *Player) bedMissing(bedLoc *BlockXyz) {
+	bedSpawn := player.bedSpawn
+	if bedSpawn == nil || bedSpawn.X != bedLoc.X || bedSpawn.Y != bedLoc.Y || bedSpawn.Z != bedLoc.Z {
+		return
+	}
+	player.bedSpawn = nil
+
+	buf := new(bytes.Buffer)
+	player.codec.WriteBedInvalid(buf, proto.BedInvalidReasonBed)
+	player.TransmitPacket(buf.Bytes(), proto.PacketPriorityNormal)
+
+	player.setPositionLook(*player.spawnBlock.ToAbsXyz(), player.look)
+}
@@ -0,0 +1,48 @@
+package player
+
+import (
+	"net"
+	"testing"
+
+	"chunkymonkey/gamerules"
+	"chunkymonkey/proto"
+	. "chunkymonkey/types"
+)
+
+// testBedShardClient records the beds that the player leaves.
+type testBedShardClient struct {
+	gamerules.IPlayerShardClient
+	left []BlockXyz
+}
+
+func (shard *testBedShardClient) ReqLeaveBed(headLoc BlockXyz) {
+	shard.left = append(shard.left, headLoc)
+}
+
+func TestSleepOnlyInNormalDimension(t *testing.T) {
+	client, serverConn := net.Pipe()
+	defer client.Close()
+	defer serverConn.Close()
+
+	codec, _ := proto.CodecForVersion(proto.ProtocolVersionBeta18)
+	player := NewPlayer(7, serverConn, codec, "alice", "world", BlockXyz{0, 64, 0}, nil, nil)
+	player.dimension = int32(DimensionNether)
+	player.position = AbsXyz{4.5, 64, 4.5}
+
+	bedLoc := BlockXyz{4, 64, 4}
+	shard := &testBedShardClient{}
+	shardLoc := bedLoc.ToChunkXz().ToShardXz()
+	player.chunkSubs.shardClients = map[uint64]*shardRef{
+		shardLoc.Key(): &shardRef{shard, 1},
+	}
+
+	// The bed would be a spawn point in the wrong dimension, so the player
+	// gets straight out of it.
+	player.sleep(&bedLoc)
+	if player.sleeping != 0 || player.bedSpawn != nil {
+		t.Errorf("Expected player not to sleep in the Nether")
+	}
+	if len(shard.left) != 1 || shard.left[0].X != bedLoc.X || shard.left[0].Y != bedLoc.Y || shard.left[0].Z != bedLoc.Z {
+		t.Errorf("Expected player to leave the bed at %v, got %v", bedLoc, shard.left)
+	}
+}
@@ -0,0 +1,83 @@
+package player
+
+import (
+	"bytes"
+
+	"chunkymonkey/gamerules"
+	"chunkymonkey/physics"
+	"chunkymonkey/proto"
+	. "chunkymonkey/types"
+)
+
+// useHeldItem uses the held item without it targetting a block - eating food,
+// shooting an arrow from a bow, or throwing a snowball or egg.
+func (player *Player) useHeldItem() {
+	held, _ := player.inventory.HeldItem()
+	if itemType, ok := gamerules.Items[held.ItemTypeId]; ok && itemType.Food > 0 {
+		player.startEating(&held)
+		return
+	}
+
+	position := player.position
+	position.Y += player.height
+	shardClient, _, ok := player.chunkSubs.ShardClientForBlockXyz(position.ToBlockXyz())
+	if !ok {
+		return
+	}
+
+	var objType ObjTypeId
+	var speed float64
+	var used gamerules.Slot
+	switch held.ItemTypeId {
+	case ItemTypeIdBow:
+		objType, speed = ObjTypeIdArrow, gamerules.ArrowSpeed
+		player.inventory.TakeOneItemOfType(ItemTypeIdArrow, &used)
+	case ItemTypeIdSnowball:
+		objType, speed = ObjTypeIdThrownSnowball, gamerules.ThrownSpeed
+		player.inventory.TakeOneHeldItem(&used)
+	case ItemTypeIdEgg:
+		objType, speed = ObjTypeIdThrownEgg, gamerules.ThrownSpeed
+		player.inventory.TakeOneHeldItem(&used)
+	default:
+		return
+	}
+
+	if used.IsEmpty() {
+		// Nothing to shoot or throw.
+		return
+	}
+
+	velocity := physics.VelocityFromLook(player.look, speed)
+	shardClient.ReqLaunchProjectile(objType, position, velocity)
+}
+
+// damage hurts the player. A player who loses all their health dies, and stays
+// dead until the client asks to respawn. Players in creative mode can't be
+// hurt.
+func (player *Player) damage(amount Health, attacker EntityId) {
+	if player.health <= 0 || player.gameMode == GameModeCreative {
+		// Already dead, or can't be hurt.
+		return
+	}
+
+	player.wakeUp()
+	player.stopEating()
+
+	if amount > 0 {
+		player.health -= amount
+		if player.health < 0 {
+			player.health = 0
+		}
+		player.addExhaustion(exhaustionDamage)
+		player.sendHealth()
+	}
+
+	buf := new(bytes.Buffer)
+
+	status := EntityStatusHurt
+	if player.health <= 0 {
+		status = EntityStatusDead
+	}
+	proto.WriteEntityStatus(buf, player.EntityId, status)
+	player.multicastPacket(buf.Bytes(), proto.PacketPriorityNormal, false)
+}
@@ -0,0 +1,79 @@
+package player
+
+import (
+	"bytes"
+	"os"
+
+	"chunkymonkey/nbtutil"
+	"chunkymonkey/proto"
+	"nbt"
+)
+
+// experienceForLevel returns the experience that a player at the given level
+// needs to reach the next level.
+func experienceForLevel(level int8) int16 {
+	return (int16(level) + 1) * 10
+}
+
+// readExperienceNbt reads the player's experience. It is only present if the
+// player has logged in since version 17 of the protocol was supported.
+func (player *Player) readExperienceNbt(tag nbt.ITag) (err os.Error) {
+	if tag.Lookup("XpLevel") == nil {
+		return
+	}
+
+	level, err := nbtutil.ReadInt(tag, "XpLevel")
+	if err != nil {
+		return
+	}
+	player.level = int8(level)
+
+	total, err := nbtutil.ReadInt(tag, "XpTotal")
+	if err != nil {
+		return
+	}
+	player.totalExperience = int16(total)
+
+	// The experience towards the next level is stored as a fraction of that
+	// needed.
+	progress, err := nbtutil.ReadFloat(tag, "XpP")
+	if err != nil {
+		return
+	}
+	player.experience = int16(progress * float32(experienceForLevel(player.level)))
+
+	return
+}
+
+// writeExperienceNbt adds the player's experience to the tag.
+func (player *Player) writeExperienceNbt(tag *nbt.Compound) {
+	progress := float32(player.experience) / float32(experienceForLevel(player.level))
+
+	tag.Tags["XpLevel"] = &nbt.Int{int32(player.level)}
+	tag.Tags["XpTotal"] = &nbt.Int{int32(player.totalExperience)}
+	tag.Tags["XpP"] = &nbt.Float{progress}
+}
+
+// sendExperience tells the client the player's experience.
+func (player *Player) sendExperience() {
+	buf := new(bytes.Buffer)
+	player.codec.WriteExperience(buf, int8(player.experience), player.level, player.totalExperience)
+	player.TransmitPacket(buf.Bytes(), proto.PacketPriorityHigh)
+}
+
+// giveExperience adds to the player's experience, and raises their level for
+// each level's worth of experience that they gain.
+func (player *Player) giveExperience(amount int16) {
+	if amount <= 0 {
+		return
+	}
+
+	player.totalExperience += amount
+	player.experience += amount
+	for player.experience >= experienceForLevel(player.level) {
+		player.experience -= experienceForLevel(player.level)
+		player.level++
+	}
+
+	player.sendExperience()
+}
@@ -0,0 +1,199 @@
+package player
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"time"
+
+	"chunkymonkey/gamerules"
+	"chunkymonkey/nbtutil"
+	"chunkymonkey/proto"
+	. "chunkymonkey/types"
+	"nbt"
+)
+
+const (
+	MaxFood = 20
+
+	// Ticks between the effects of a player's food level, which are healing
+	// when they are well fed, and starving when they have no food.
+	FoodTickInterval = 80
+
+	// The food saturation of a player new to the server, or respawning.
+	initialFoodSaturation = 5
+
+	// A player heals when their food level is at least this.
+	foodHealLevel = 18
+	// Starvation doesn't take a player's health below this.
+	starveMinHealth = 10
+
+	// Exhaustion at which a player loses a point of food saturation, or of food
+	// once they have no saturation left.
+	maxFoodExhaustion = 4.0
+
+	// Exhaustion caused by various activities.
+	exhaustionPerMetre = 0.01
+	exhaustionDig      = 0.025
+	exhaustionAttack   = 0.3
+	exhaustionDamage   = 0.3
+	exhaustionHeal     = 3.0
+
+	// How long it takes to eat an item.
+	eatingTicks = 32
+)
+
+// readFoodNbt reads the player's food levels. They are only present if the
+// player has logged in since version 17 of the protocol was supported.
+func (player *Player) readFoodNbt(tag nbt.ITag) (err os.Error) {
+	if tag.Lookup("foodLevel") == nil {
+		return
+	}
+
+	food, err := nbtutil.ReadInt(tag, "foodLevel")
+	if err != nil {
+		return
+	}
+	player.food = int16(food)
+
+	if player.foodSaturation, err = nbtutil.ReadFloat(tag, "foodSaturationLevel"); err != nil {
+		return
+	}
+
+	player.foodExhaustion, err = nbtutil.ReadFloat(tag, "foodExhaustionLevel")
+	return
+}
+
+// writeFoodNbt adds the player's food levels to the tag.
+func (player *Player) writeFoodNbt(tag *nbt.Compound) {
+	tag.Tags["foodLevel"] = &nbt.Int{int32(player.food)}
+	tag.Tags["foodSaturationLevel"] = &nbt.Float{player.foodSaturation}
+	tag.Tags["foodExhaustionLevel"] = &nbt.Float{player.foodExhaustion}
+}
+
+// sendHealth tells the client the player's health and food levels.
+func (player *Player) sendHealth() {
+	buf := new(bytes.Buffer)
+	player.codec.WriteUpdateHealth(buf, player.health, player.food, player.foodSaturation)
+	player.TransmitPacket(buf.Bytes(), proto.PacketPriorityHigh)
+}
+
+// addExhaustion makes the player hungrier. Players in creative mode don't get
+// hungry.
+func (player *Player) addExhaustion(exhaustion float32) {
+	if player.gameMode == GameModeCreative {
+		return
+	}
+
+	player.foodExhaustion += exhaustion
+	if player.foodExhaustion < maxFoodExhaustion {
+		return
+	}
+	player.foodExhaustion -= maxFoodExhaustion
+
+	if player.foodSaturation > 0 {
+		player.foodSaturation--
+		if player.foodSaturation < 0 {
+			player.foodSaturation = 0
+		}
+	} else if player.food > 0 {
+		player.food--
+	}
+	player.sendHealth()
+}
+
+// addMovementExhaustion makes the player hungrier for having walked from one
+// position to another.
+func (player *Player) addMovementExhaustion(from, to *AbsXyz) {
+	dx := float64(to.X - from.X)
+	dz := float64(to.Z - from.Z)
+	player.addExhaustion(exhaustionPerMetre * float32(math.Sqrt(dx*dx+dz*dz)))
+}
+
+// FoodTick requests that the player heal or starve according to their food
+// level. It is called every FoodTickInterval ticks. The request is skipped if the
+// player's main loop has fallen behind.
+func (player *Player) FoodTick() {
+	player.TryEnqueue(func(_ *Player) {
+		player.foodTick()
+	})
+}
+
+func (player *Player) foodTick() {
+	if player.gameMode == GameModeCreative || player.health <= 0 {
+		return
+	}
+
+	if player.food >= foodHealLevel && player.health < MaxHealth {
+		player.health++
+		player.addExhaustion(exhaustionHeal)
+		player.sendHealth()
+	} else if player.food <= 0 && player.health > starveMinHealth {
+		player.damage(1, NoEntityId)
+	}
+}
+
+// startEating starts the player eating the food that they hold. They finish
+// eating after eatingTicks, unless they stop or change what they hold first.
+func (player *Player) startEating(food *gamerules.Slot) {
+	if player.gameMode == GameModeCreative || player.food >= MaxFood {
+		return
+	}
+
+	player.eating = true
+	player.eatingItem = *food
+	player.eatingCount++
+	eatingCount := player.eatingCount
+
+	go func() {
+		time.Sleep(eatingTicks * NanosecondsInSecond / TicksPerSecond)
+		player.Enqueue(func(_ *Player) {
+			player.finishEating(eatingCount)
+		})
+	}()
+}
+
+// stopEating stops the player eating, if they are.
+func (player *Player) stopEating() {
+	player.eating = false
+}
+
+// finishEating takes one of the food items that the player has been eating
+// since the eatingCount'th call to startEating, and feeds the player.
+func (player *Player) finishEating(eatingCount int) {
+	if !player.eating || eatingCount != player.eatingCount {
+		// The player stopped eating, or started eating again since.
+		return
+	}
+	player.eating = false
+
+	held, _ := player.inventory.HeldItem()
+	if !held.IsSameType(&player.eatingItem) {
+		return
+	}
+
+	itemType, ok := gamerules.Items[held.ItemTypeId]
+	if !ok {
+		return
+	}
+
+	var eaten gamerules.Slot
+	player.inventory.TakeOneHeldItem(&eaten)
+	if eaten.IsEmpty() {
+		return
+	}
+
+	player.food += itemType.Food
+	if player.food > MaxFood {
+		player.food = MaxFood
+	}
+	player.foodSaturation += itemType.FoodSaturation
+	if player.foodSaturation > float32(player.food) {
+		player.foodSaturation = float32(player.food)
+	}
+
+	buf := new(bytes.Buffer)
+	proto.WriteEntityStatus(buf, player.EntityId, EntityStatusEatingAccepted)
+	player.codec.WriteUpdateHealth(buf, player.health, player.food, player.foodSaturation)
+	player.TransmitPacket(buf.Bytes(), proto.PacketPriorityHigh)
+}
@@ -0,0 +1,72 @@
+package player
+
+import (
+	"testing"
+
+	"chunkymonkey/gamerules"
+	"chunkymonkey/proto"
+	. "chunkymonkey/types"
+)
+
+func newFoodTestPlayer(t *testing.T) *Player {
+	codec, ok := proto.CodecForVersion(proto.ProtocolVersionBeta18)
+	if !ok {
+		t.Fatalf("No codec for protocol version %d", proto.ProtocolVersionBeta18)
+	}
+	return NewPlayer(1, nil, codec, "alice", "world", BlockXyz{0, 64, 0}, nil, nil)
+}
+
+func TestPlayerExhaustion(t *testing.T) {
+	player := newFoodTestPlayer(t)
+
+	// Saturation is used up before food.
+	player.addExhaustion(maxFoodExhaustion)
+	if player.food != MaxFood || player.foodSaturation != initialFoodSaturation-1 {
+		t.Errorf("Expected food %d and saturation %d, got %d and %v", MaxFood, initialFoodSaturation-1, player.food, player.foodSaturation)
+	}
+
+	player.foodSaturation = 0
+	player.addExhaustion(maxFoodExhaustion / 2)
+	player.addExhaustion(maxFoodExhaustion / 2)
+	if player.food != MaxFood-1 || player.foodExhaustion != 0 {
+		t.Errorf("Expected food %d and no exhaustion, got %d and %v", MaxFood-1, player.food, player.foodExhaustion)
+	}
+
+	// Players in creative mode don't get hungry.
+	player.gameMode = GameModeCreative
+	player.addExhaustion(maxFoodExhaustion)
+	if player.food != MaxFood-1 || player.foodExhaustion != 0 {
+		t.Errorf("Expected creative player's food to be unchanged, got %d and %v", player.food, player.foodExhaustion)
+	}
+}
+
+func TestPlayerEating(t *testing.T) {
+	gamerules.Items = gamerules.ItemTypeMap{
+		260: &gamerules.ItemType{Id: 260, Name: "apple", MaxStack: 64, Food: 4, FoodSaturation: 2.4},
+	}
+
+	player := newFoodTestPlayer(t)
+	player.food = 10
+	player.foodSaturation = 0
+	player.inventory.PutItem(&gamerules.Slot{ItemTypeId: 260, Count: 2})
+
+	held, _ := player.inventory.HeldItem()
+	player.startEating(&held)
+	eatingCount := player.eatingCount
+
+	// Letting go stops the player eating.
+	player.stopEating()
+	player.finishEating(eatingCount)
+	if player.food != 10 {
+		t.Errorf("Expected player to have stopped eating, got food %d", player.food)
+	}
+
+	player.startEating(&held)
+	player.finishEating(player.eatingCount)
+	if player.food != 14 || player.foodSaturation != 2.4 {
+		t.Errorf("Expected food 14 and saturation 2.4, got %d and %v", player.food, player.foodSaturation)
+	}
+	if held, _ = player.inventory.HeldItem(); held.Count != 1 {
+		t.Errorf("Expected one apple left, got %+v", held)
+	}
+}
@@ -0,0 +1,79 @@
+package player
+
+import (
+	"sync"
+)
+
+// inboxBehind is how many requests may wait in a player's inbox before the
+// player is taken to have fallen behind, and requests that can be skipped are
+// dropped.
+const inboxBehind = 128
+
+// inbox holds the requests made of a player by shards, the game and other
+// players, to be run by the player's main loop. Posting to it never blocks,
+// so that the player can't hold up those making requests of it while it
+// waits on them in turn. Requests posted once the main loop has finished are
+// dropped.
+type inbox struct {
+	lock   sync.Mutex
+	queue  []func(*Player)
+	closed bool
+
+	// ready has a value after requests are posted, until the main loop next
+	// takes them.
+	ready chan bool
+}
+
+func (in *inbox) Init() {
+	in.ready = make(chan bool, 1)
+}
+
+// Post queues f to be run by the main loop. It returns false if the inbox has
+// been closed.
+func (in *inbox) Post(f func(*Player)) bool {
+	in.lock.Lock()
+	defer in.lock.Unlock()
+
+	if in.closed {
+		return false
+	}
+	in.queue = append(in.queue, f)
+
+	select {
+	case in.ready <- true:
+	default:
+	}
+	return true
+}
+
+// TryPost is as Post, but drops f and returns false if inboxBehind requests
+// are already waiting.
+func (in *inbox) TryPost(f func(*Player)) bool {
+	in.lock.Lock()
+	behind := len(in.queue) >= inboxBehind
+	in.lock.Unlock()
+
+	if behind {
+		return false
+	}
+	return in.Post(f)
+}
+
+// Take returns the requests waiting, and empties the inbox.
+func (in *inbox) Take() (queue []func(*Player)) {
+	in.lock.Lock()
+	defer in.lock.Unlock()
+
+	queue = in.queue
+	in.queue = nil
+	return
+}
+
+// Close drops the requests waiting, and any posted later.
+func (in *inbox) Close() {
+	in.lock.Lock()
+	defer in.lock.Unlock()
+
+	in.closed = true
+	in.queue = nil
+}
@@ -0,0 +1,145 @@
+package player
+
+import (
+	"strings"
+	"time"
+
+	"chunkymonkey/gamerules"
+	"chunkymonkey/proto"
+	. "chunkymonkey/types"
+)
+
+// packetQueue receives the packets decoded by the player's receive loop, and
+// posts them to the player's main loop, which is the only goroutine that
+// handles them. Packets that don't affect the player are handled or dropped
+// here.
+type packetQueue struct {
+	player *Player
+}
+
+func (q *packetQueue) Init(player *Player) {
+	q.player = player
+}
+
+func (q *packetQueue) PacketKeepAlive(id int32) {
+	// The time is taken here so that the latency doesn't include the wait for
+	// the main loop.
+	receivedAt := time.Nanoseconds()
+	q.player.postPacket(func(player *Player) {
+		player.PacketKeepAlive(id, receivedAt)
+	})
+}
+
+func (q *packetQueue) PacketChatMessage(message string) {
+	player := q.player
+	if strings.HasPrefix(message, gamerules.CommandFramework.Prefix()) {
+		// Commands wait on the game and on other players, which could in turn be
+		// waiting on this player's main loop, so they are run here instead. We
+		// pass the IPlayerClient to the command framework to avoid having to
+		// fetch it as the first part of every command.
+		gamerules.CommandFramework.Process(&player.playerClient, message, player.game)
+		return
+	}
+
+	player.postPacket(func(player *Player) {
+		player.PacketChatMessage(message)
+	})
+}
+
+func (q *packetQueue) PacketEntityAction(entityId EntityId, action EntityAction) {
+	q.player.postPacket(func(player *Player) {
+		player.PacketEntityAction(entityId, action)
+	})
+}
+
+func (q *packetQueue) PacketUseEntity(user EntityId, target EntityId, leftClick bool) {
+	q.player.postPacket(func(player *Player) {
+		player.PacketUseEntity(user, target, leftClick)
+	})
+}
+
+func (q *packetQueue) PacketRespawn(dimension DimensionId) {
+	q.player.postPacket(func(player *Player) {
+		player.PacketRespawn(dimension)
+	})
+}
+
+func (q *packetQueue) PacketPlayer(onGround bool) {
+}
+
+func (q *packetQueue) PacketPlayerPosition(position *AbsXyz, stance AbsCoord, onGround bool) {
+	pos := *position
+	q.player.postPacket(func(player *Player) {
+		player.PacketPlayerPosition(&pos, stance, onGround)
+	})
+}
+
+func (q *packetQueue) PacketPlayerLook(look *LookDegrees, onGround bool) {
+	lookCopy := *look
+	q.player.postPacket(func(player *Player) {
+		player.PacketPlayerLook(&lookCopy, onGround)
+	})
+}
+
+func (q *packetQueue) PacketPlayerBlockHit(status DigStatus, target *BlockXyz, face Face) {
+	targetCopy := *target
+	q.player.postPacket(func(player *Player) {
+		player.PacketPlayerBlockHit(status, &targetCopy, face)
+	})
+}
+
+func (q *packetQueue) PacketPlayerBlockInteract(itemId ItemTypeId, target *BlockXyz, face Face, amount ItemCount, uses ItemData) {
+	targetCopy := *target
+	q.player.postPacket(func(player *Player) {
+		player.PacketPlayerBlockInteract(itemId, &targetCopy, face, amount, uses)
+	})
+}
+
+func (q *packetQueue) PacketHoldingChange(slotId SlotId) {
+	q.player.postPacket(func(player *Player) {
+		player.PacketHoldingChange(slotId)
+	})
+}
+
+func (q *packetQueue) PacketEntityAnimation(entityId EntityId, animation EntityAnimation) {
+}
+
+func (q *packetQueue) PacketUnknown0x1b(field1, field2 float32, field3, field4 bool, field5, field6 float32) {
+	q.player.PacketUnknown0x1b(field1, field2, field3, field4, field5, field6)
+}
+
+func (q *packetQueue) PacketUnknown0x3d(field1, field2 int32, field3 int8, field4, field5 int32) {
+	q.player.PacketUnknown0x3d(field1, field2, field3, field4, field5)
+}
+
+func (q *packetQueue) PacketWindowClose(windowId WindowId) {
+	q.player.postPacket(func(player *Player) {
+		player.PacketWindowClose(windowId)
+	})
+}
+
+func (q *packetQueue) PacketWindowClick(windowId WindowId, slotId SlotId, rightClick bool, txId TxId, shiftClick bool, expectedSlot *proto.WindowSlot) {
+	expected := *expectedSlot
+	q.player.postPacket(func(player *Player) {
+		player.PacketWindowClick(windowId, slotId, rightClick, txId, shiftClick, &expected)
+	})
+}
+
+func (q *packetQueue) PacketCreativeInventoryAction(slotId SlotId, itemTypeId ItemTypeId, amount ItemCount, data ItemData) {
+	q.player.postPacket(func(player *Player) {
+		player.PacketCreativeInventoryAction(slotId, itemTypeId, amount, data)
+	})
+}
+
+func (q *packetQueue) PacketWindowTransaction(windowId WindowId, txId TxId, accepted bool) {
+	q.player.PacketWindowTransaction(windowId, txId, accepted)
+}
+
+func (q *packetQueue) PacketSignUpdate(position *BlockXyz, lines [4]string) {
+}
+
+func (q *packetQueue) PacketDisconnect(reason string) {
+	q.player.postPacket(func(player *Player) {
+		player.PacketDisconnect(reason)
+	})
+}
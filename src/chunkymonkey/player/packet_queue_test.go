@@ -0,0 +1,206 @@
+package player
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"chunkymonkey/gamerules"
+	"chunkymonkey/proto"
+	. "chunkymonkey/types"
+)
+
+func TestPacketsRunInMainLoop(t *testing.T) {
+	client, serverConn := net.Pipe()
+	defer client.Close()
+
+	codec, _ := proto.CodecForVersion(proto.ProtocolVersionBeta17)
+	player := NewPlayer(1, serverConn, codec, "bob", "world", BlockXyz{0, 64, 0}, nil, nil)
+	go player.receiveLoop()
+
+	if err := proto.WriteHoldingChange(client, 3); err != nil {
+		t.Fatalf("WriteHoldingChange: %v", err)
+	}
+
+	// The receive loop only posts the packet to the main loop, which is played
+	// by the test.
+	f := <-player.mainQueue
+	if _, slotId := player.inventory.HeldItem(); slotId != 0 {
+		t.Errorf("Expected holding change to wait for the main loop, holding %d", slotId)
+	}
+
+	f(player)
+	if _, slotId := player.inventory.HeldItem(); slotId != 3 {
+		t.Errorf("Expected to hold slot 3, holding %d", slotId)
+	}
+}
+
+func TestIdleClientDisconnected(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer listener.Close()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer client.Close()
+
+	serverConn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+
+	defer func(timeout int64) {
+		IdleTimeout = timeout
+	}(IdleTimeout)
+	IdleTimeout = 1e7
+
+	codec, _ := proto.CodecForVersion(proto.ProtocolVersionBeta18)
+	player := NewPlayer(1, serverConn, codec, "bob", "world", BlockXyz{0, 64, 0}, nil, nil)
+	go player.receiveLoop()
+
+	// The silent client times out, and the receive loop asks the main loop to
+	// disconnect the player.
+	<-player.receiveDone
+	select {
+	case f := <-player.mainQueue:
+		if f == nil {
+			t.Errorf("Expected the player to be disconnected")
+		}
+	default:
+		t.Errorf("Expected the player to be disconnected")
+	}
+}
+
+func TestEnqueueDoesNotWaitOnStalledPlayer(t *testing.T) {
+	codec, _ := proto.CodecForVersion(proto.ProtocolVersionBeta18)
+	player := NewPlayer(1, nil, codec, "bob", "world", BlockXyz{0, 64, 0}, nil, nil)
+
+	// The main loop isn't running, so requests pile up.
+	for i := 0; i < inboxBehind; i++ {
+		if !player.TryEnqueue(func(_ *Player) {}) {
+			t.Fatalf("Expected room in the inbox for function %d", i)
+		}
+	}
+	if player.TryEnqueue(func(_ *Player) {}) {
+		t.Errorf("Expected TryEnqueue to fail once the player has fallen behind")
+	}
+
+	// Enqueue doesn't wait, however far behind the player is.
+	player.Enqueue(func(_ *Player) {})
+	if n := len(player.inbox.Take()); n != inboxBehind+1 {
+		t.Errorf("Expected %d functions queued, got %d", inboxBehind+1, n)
+	}
+
+	// Once the main loop has finished, Enqueue drops the function.
+	player.inbox.Close()
+	player.Enqueue(func(_ *Player) {})
+	if n := len(player.inbox.Take()); n != 0 {
+		t.Errorf("Expected functions to be dropped once closed, got %d", n)
+	}
+}
+
+const testTimeoutNs = 5 * NanosecondsInSecond
+
+// testBlockingShard is a shard that player requests wait on until the test
+// takes them from requests, as they would on a busy shard.
+type testBlockingShard struct {
+	gamerules.IPlayerShardClient
+	requests chan bool
+}
+
+func (shard *testBlockingShard) ReqMulticastPlayers(chunkLoc ChunkXz, exclude EntityId, packet []byte, priority proto.PacketPriority) {
+	shard.requests <- true
+}
+
+func waitOrFail(t *testing.T, done <-chan bool, timeout <-chan int64, what string) {
+	select {
+	case <-done:
+	case <-timeout:
+		t.Fatalf("Timed out waiting for %s", what)
+	}
+}
+
+func TestShardCallbacksDoNotWaitOnPlayer(t *testing.T) {
+	codec, _ := proto.CodecForVersion(proto.ProtocolVersionBeta18)
+	player := NewPlayer(1, nil, codec, "bob", "world", BlockXyz{0, 64, 0}, nil, nil)
+	shard := &testBlockingShard{requests: make(chan bool)}
+	player.chunkSubs.curShard = shard
+	go player.runQueue()
+
+	timeout := time.After(testTimeoutNs)
+	const numPackets = 10
+
+	// Packets from the client are posted, each of which has the main loop
+	// wait on the shard.
+	packetsDone := make(chan bool)
+	go func() {
+		for i := 0; i < numPackets; i++ {
+			player.postPacket(func(player *Player) {
+				player.sendChatMessage("hello", false)
+			})
+		}
+		player.postPacket(func(_ *Player) {
+			close(packetsDone)
+		})
+	}()
+
+	// Meanwhile the shard makes many more requests of the player than it has
+	// room to queue, before it gets round to the player's requests.
+	shardDone := make(chan bool)
+	go func() {
+		for i := 0; i < 4*inboxBehind; i++ {
+			player.Client().EchoMessage("hi")
+		}
+		close(shardDone)
+		for i := 0; i < numPackets; i++ {
+			<-shard.requests
+		}
+	}()
+
+	waitOrFail(t, shardDone, timeout, "the shard's requests to be queued")
+	waitOrFail(t, packetsDone, timeout, "the packets to be handled")
+}
+
+func TestDisconnectWhileGameEnqueues(t *testing.T) {
+	client, serverConn := net.Pipe()
+	defer client.Close()
+
+	codec, _ := proto.CodecForVersion(proto.ProtocolVersionBeta18)
+	disconnects := make(chan EntityId)
+	player := NewPlayer(1, serverConn, codec, "bob", "world", BlockXyz{0, 64, 0}, disconnects, nil)
+	player.chunkSubs.curShard = &testBlockingShard{requests: make(chan bool, 1)}
+	go player.runQueue()
+
+	timeout := time.After(testTimeoutNs)
+
+	// The game keeps making requests of the player, and doesn't read from
+	// disconnects until it has finished.
+	gameDone := make(chan bool)
+	go func() {
+		for i := 0; i < 4*inboxBehind; i++ {
+			player.WakeUp()
+			player.TryEnqueue(func(_ *Player) {})
+		}
+		close(gameDone)
+	}()
+
+	player.postPacket(func(player *Player) {
+		player.PacketDisconnect("Quitting")
+	})
+
+	waitOrFail(t, player.mainDone, timeout, "the main loop to finish")
+	waitOrFail(t, gameDone, timeout, "the game's requests to be queued")
+
+	select {
+	case entityId := <-disconnects:
+		if entityId != 1 {
+			t.Errorf("Expected player 1 to disconnect, got %d", entityId)
+		}
+	case <-timeout:
+		t.Fatalf("Timed out waiting for the disconnect notification")
+	}
+}
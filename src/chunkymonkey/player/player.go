@@ -7,7 +7,7 @@ import (
 	"log"
 	"net"
 	"os"
-	"sync"
+	"time"
 
 	"chunkymonkey/gamerules"
 	"chunkymonkey/nbtutil"
@@ -29,6 +29,21 @@ const (
 	MaxHealth    = 20
 )
 
+// Timeouts (in nanoseconds) on the connections to clients. Zero disables a
+// timeout.
+var (
+	// IdleTimeout is how long a client may send nothing before it is
+	// disconnected. Clients send position updates many times a second, and
+	// reply to the keep-alives sent each second, so a client that has been
+	// silent this long is gone.
+	IdleTimeout int64 = 30 * NanosecondsInSecond
+
+	// WriteTimeout is how long a write to a client may block before the client
+	// is disconnected, so that a client that stops reading can't hold up those
+	// sending it packets.
+	WriteTimeout int64 = 30 * NanosecondsInSecond
+)
+
 func init() {
 	expVarPlayerConnectionCount = expvar.NewInt("player-connection-count")
 	expVarPlayerDisconnectionCount = expvar.NewInt("player-disconnection-count")
@@ -38,23 +53,56 @@ func init() {
 type Player struct {
 	// These entities should be unchanged through a single login
 	EntityId
-	playerClient   playerClient
-	shardConnecter gamerules.IShardConnecter
-	conn           net.Conn
-	name           string
-	loginComplete  bool
-	spawnComplete  bool
+	playerClient  playerClient
+	packetQueue   packetQueue
+	conn          net.Conn
+	codec         *proto.Codec // Encodes packets for the client's protocol version.
+	name          string
+	loginComplete bool
+	spawnComplete bool
 
 	// Data entries that may change
-	spawnBlock BlockXyz
-	position   AbsXyz
-	height     AbsCoord
-	look       LookDegrees
-	chunkSubs  chunkSubscriptions
-	health     Health
+	world          string // The name of the world that the player is in.
+	spawnBlock     BlockXyz
+	position       AbsXyz
+	height         AbsCoord
+	look           LookDegrees
+	chunkSubs      chunkSubscriptions
+	health         Health
+	gameMode       GameMode
+	bedSpawn       *BlockXyz // Where the player last slept, if anywhere.
+	dimension      int32
+	shardConnecter gamerules.IShardConnecter // Connects to the dimension's shards.
+	vehicle        EntityId                  // What the player is riding, or NoEntityId.
+
+	// Time (in nanoseconds) that the player last travelled through a portal.
+	lastPortalTime int64
+
+	// How hungry the player is. See food.go.
+	food           int16
+	foodSaturation float32
+	foodExhaustion float32
+
+	// The food that the player is eating, if eating is set. eatingCount is
+	// incremented each time they start eating.
+	eating      bool
+	eatingItem  gamerules.Slot
+	eatingCount int
+
+	// experience is towards the next level. See experience.go.
+	experience      int16
+	level           int8
+	totalExperience int16
+
+	// The ID of the last keep-alive sent to the client, and when (in
+	// nanoseconds) it was sent.
+	keepAliveId   int32
+	keepAliveSent int64
+
+	// Where the player was in each of the other worlds that they have been in.
+	otherWorlds map[string]*worldPosition
 
 	// The following data fields are loaded, but not used yet
-	dimension    int32
 	onGround     int8
 	sleeping     int8
 	fallDistance float32
@@ -72,25 +120,49 @@ type Player struct {
 	nextWindowId WindowId
 	remoteInv    *RemoteInventory
 
-	mainQueue chan func(*Player)
-	txQueue   chan []byte
+	// Remote inventory clicks whose outcome is not yet known.
+	pendingClicks int
+	// Block interactions that the shard hasn't yet carried out. They might
+	// lead to the held item being placed.
+	pendingInteracts int
+
+	// All of the above is only accessed by the main loop, which runs the
+	// handlers of packets from the client posted to mainQueue, and the
+	// requests of shards, the game and other players posted to inbox. The
+	// player's state needs no lock as a result.
+	mainQueue   chan func(*Player)
+	inbox       inbox
+	mainDone    chan bool // Closed when the main loop finishes.
+	stopped     bool      // Set to end the main loop.
+	txQueue     txQueue
+	txDone      chan bool // Closed when the transmit loop finishes.
+	receiveDone chan bool // Closed when the receive loop finishes.
+
+	// The entities shown to the client, which chunks change concurrently.
+	visible visibleEntities
+
+	// Requests to transfer the player to another frontend.
+	transfers chan *transferRequest
+	// Set once the player has been transferred to another frontend.
+	transferred bool
+	// Set if the player was transferred from another frontend.
+	transferredIn bool
+	// The block of the window to open again after being transferred, if any.
+	windowBlock *BlockXyz
 
 	game gamerules.IGame
 
-	// TODO remove this lock, packet handling shouldn't use a lock, it should use
-	// a channel instead (ideally).
-	lock sync.Mutex
-
 	onDisconnect chan<- EntityId
 }
 
-func NewPlayer(entityId EntityId, shardConnecter gamerules.IShardConnecter, conn net.Conn, name string, spawnBlock BlockXyz, onDisconnect chan<- EntityId, game gamerules.IGame) *Player {
+func NewPlayer(entityId EntityId, conn net.Conn, codec *proto.Codec, name string, world string, spawnBlock BlockXyz, onDisconnect chan<- EntityId, game gamerules.IGame) *Player {
 	player := &Player{
-		EntityId:       entityId,
-		shardConnecter: shardConnecter,
-		conn:           conn,
-		name:           name,
-		spawnBlock:     spawnBlock,
+		EntityId:   entityId,
+		conn:       conn,
+		codec:      codec,
+		name:       name,
+		world:      world,
+		spawnBlock: spawnBlock,
 		position: AbsXyz{
 			X: AbsCoord(spawnBlock.X),
 			Y: AbsCoord(spawnBlock.Y),
@@ -99,13 +171,22 @@ func NewPlayer(entityId EntityId, shardConnecter gamerules.IShardConnecter, conn
 		height: StanceNormal,
 		look:   LookDegrees{0, 0},
 
-		health: MaxHealth,
+		health:         MaxHealth,
+		gameMode:       GameModeSurvival,
+		food:           MaxFood,
+		foodSaturation: initialFoodSaturation,
+		vehicle:        NoEntityId,
+
+		otherWorlds: make(map[string]*worldPosition),
 
 		curWindow:    nil,
 		nextWindowId: WindowIdFreeMin,
 
-		mainQueue: make(chan func(*Player), 128),
-		txQueue:   make(chan []byte, 128),
+		mainQueue:   make(chan func(*Player), 128),
+		mainDone:    make(chan bool),
+		txDone:      make(chan bool),
+		receiveDone: make(chan bool),
+		transfers:   make(chan *transferRequest, 1),
 
 		game: game,
 
@@ -113,6 +194,10 @@ func NewPlayer(entityId EntityId, shardConnecter gamerules.IShardConnecter, conn
 	}
 
 	player.playerClient.Init(player)
+	player.packetQueue.Init(player)
+	player.txQueue.Init()
+	player.inbox.Init()
+	player.visible.Init(player)
 	player.inventory.Init(player.EntityId, player)
 
 	return player
@@ -122,10 +207,27 @@ func (player *Player) Name() string {
 	return player.name
 }
 
+// Codec returns the codec for the version of the protocol that the player's
+// client speaks.
+func (player *Player) Codec() *proto.Codec {
+	return player.codec
+}
+
+// Position, Dimension, World and SetPosition must only be called by the main
+// loop (via Enqueue), or before Player.Start().
+
 func (player *Player) Position() AbsXyz {
 	return player.position
 }
 
+func (player *Player) Dimension() DimensionId {
+	return DimensionId(player.dimension)
+}
+
+func (player *Player) World() string {
+	return player.world
+}
+
 func (player *Player) SetPosition(pos AbsXyz) {
 	player.position = pos
 }
@@ -203,6 +305,29 @@ func (player *Player) ReadNbt(playerData nbt.ITag) (err os.Error) {
 		return
 	}
 
+	if err = player.readFoodNbt(playerData); err != nil {
+		return
+	}
+
+	if err = player.readExperienceNbt(playerData); err != nil {
+		return
+	}
+
+	// The spawn point is only present if the player has slept in a bed.
+	player.bedSpawn = readBedSpawnNbt(playerData)
+
+	// The world is only present if the player has logged in since there has
+	// been more than one.
+	if world, ok := playerData.Lookup("World").(*nbt.String); ok {
+		player.world = world.Value
+	}
+
+	if worlds, ok := playerData.Lookup("Worlds").(*nbt.Compound); ok {
+		if err = player.readWorldsNbt(worlds); err != nil {
+			return
+		}
+	}
+
 	return
 }
 
@@ -239,6 +364,13 @@ func (player *Player) WriteNbt() *nbt.Compound {
 		},
 	}
 
+	player.writeFoodNbt(data)
+	player.writeExperienceNbt(data)
+	writeBedSpawnNbt(data, player.bedSpawn)
+
+	data.Tags["World"] = &nbt.String{player.world}
+	data.Tags["Worlds"] = player.writeWorldsNbt()
+
 	return data
 }
 
@@ -251,13 +383,36 @@ func (player *Player) getHeldItemTypeId() ItemTypeId {
 	return heldItemId
 }
 
+// Start starts the player's session after they have logged in.
 func (player *Player) Start() {
-	buf := &bytes.Buffer{}
-	// TODO pass proper dimension. This is low priority, because we don't yet
-	// support multiple dimensions.
-	proto.ServerWriteLogin(buf, player.EntityId, 0, DimensionNormal)
-	proto.WriteSpawnPosition(buf, &player.spawnBlock)
-	player.TransmitPacket(buf.Bytes())
+	player.start(true)
+}
+
+// StartTransferred starts the session of a player who was transferred from
+// another frontend. The client has already logged in.
+func (player *Player) StartTransferred() {
+	player.start(false)
+}
+
+func (player *Player) start(sendLogin bool) {
+	dimension := DimensionId(player.dimension)
+	player.shardConnecter = player.game.ShardConnecter(player.world, dimension)
+	if player.shardConnecter == nil {
+		log.Printf("Player %s was in unsupported dimension %d, moving to spawn", player.name, dimension)
+		dimension = DimensionNormal
+		player.dimension = int32(dimension)
+		player.shardConnecter = player.game.ShardConnecter(player.world, dimension)
+		player.position = *player.spawnBlock.ToAbsXyz()
+	}
+
+	if sendLogin {
+		buf := &bytes.Buffer{}
+		player.codec.ServerWriteLogin(buf, player.EntityId, 0, player.gameMode, dimension)
+		proto.WriteSpawnPosition(buf, &player.spawnBlock)
+		// Sent ahead of anything else queued, such as keep-alives, as the
+		// client expects the login first.
+		player.TransmitPacket(buf.Bytes(), proto.PacketPriorityHigh)
+	}
 
 	go player.receiveLoop()
 	go player.transmitLoop()
@@ -265,65 +420,70 @@ func (player *Player) Start() {
 }
 
 // Start of packet handling code
-// Note: any packet handlers that could change the player state or read a
-// changeable state must use player.lock
+// Note: the packet handlers are run by the main loop, having been posted to it
+// by the receive loop via packetQueue.
+
+// PacketKeepAlive measures the round trip time of the connection from a reply
+// to the last keep-alive sent to the client, which was received at receivedAt.
+func (player *Player) PacketKeepAlive(id int32, receivedAt int64) {
+	if id == 0 || id != player.keepAliveId {
+		// Clients before ProtocolVersionBeta18 don't send the ID back.
+		return
+	}
+	player.keepAliveId = 0
 
-func (player *Player) PacketKeepAlive() {
+	player.game.SetPlayerLatency(player.EntityId, receivedAt-player.keepAliveSent)
 }
 
+// PacketChatMessage only handles chat. Commands are handled by packetQueue.
 func (player *Player) PacketChatMessage(message string) {
-	prefix := gamerules.CommandFramework.Prefix()
-	if message[0:len(prefix)] == prefix {
-		// We pass the IPlayerClient to the command framework to avoid having
-		// to fetch it as the first part of every command.
-		gamerules.CommandFramework.Process(&player.playerClient, message, player.game)
-	} else {
-		player.sendChatMessage(fmt.Sprintf("<%s> %s", player.name, message), true)
-	}
+	player.sendChatMessage(fmt.Sprintf("<%s> %s", player.name, message), true)
 }
 
 func (player *Player) PacketEntityAction(entityId EntityId, action EntityAction) {
+	if action == EntityActionLeaveBed {
+		player.wakeUp()
+	}
 }
 
 func (player *Player) PacketUseEntity(user EntityId, target EntityId, leftClick bool) {
+	player.useEntity(target, leftClick)
 }
 
 func (player *Player) PacketRespawn(dimension DimensionId) {
+	player.respawn()
 }
 
 func (player *Player) PacketPlayer(onGround bool) {
 }
 
 func (player *Player) PacketPlayerPosition(position *AbsXyz, stance AbsCoord, onGround bool) {
-	player.lock.Lock()
-	defer player.lock.Unlock()
-
 	if !player.spawnComplete {
 		// Ignore position packets from player until spawned at initial position
 		// with chunk loaded.
 		return
 	}
 
+	if player.vehicle != NoEntityId {
+		// The vehicle decides where its rider is, but is steered by them.
+		if position.Y == riderPositionY && stance == riderPositionY {
+			player.steerVehicle(&AbsVelocity{AbsVelocityCoord(position.X), 0, AbsVelocityCoord(position.Z)})
+		}
+		return
+	}
+
 	if !player.position.IsWithinDistanceOf(position, 10) {
 		log.Printf("Discarding player position that is too far removed (%.2f, %.2f, %.2f)",
 			position.X, position.Y, position.Z)
 		return
 	}
+	player.addMovementExhaustion(&player.position, position)
 	player.position = *position
 	player.height = stance - position.Y
 	player.chunkSubs.Move(position)
-
-	// TODO: Should keep track of when players enter/leave their mutual radius
-	// of "awareness". I.e a client should receive a RemoveEntity packet when
-	// the player walks out of range, and no longer receive WriteEntityTeleport
-	// packets for them. The converse should happen when players come in range
-	// of each other.
 }
 
 func (player *Player) PacketPlayerLook(look *LookDegrees, onGround bool) {
-	player.lock.Lock()
-	defer player.lock.Unlock()
-
 	// TODO input validation
 	player.look = *look
 
@@ -334,8 +494,11 @@ func (player *Player) PacketPlayerLook(look *LookDegrees, onGround bool) {
 }
 
 func (player *Player) PacketPlayerBlockHit(status DigStatus, target *BlockXyz, face Face) {
-	player.lock.Lock()
-	defer player.lock.Unlock()
+	if status == DigUseItemEnd {
+		// The player let go of the food they were eating.
+		player.stopEating()
+		return
+	}
 
 	// This packet handles 'throwing' an item as well, with status = 4, and
 	// the zero values for target and face, so check for that.
@@ -367,6 +530,10 @@ func (player *Player) PacketPlayerBlockHit(status DigStatus, target *BlockXyz, f
 	// TODO measure the dig time on the target block and relay to the shard to
 	// stop speed hacking (based on block type and tool used - non-trivial).
 
+	if status == DigBlockBroke {
+		player.addExhaustion(exhaustionDig)
+	}
+
 	shardClient, _, ok := player.chunkSubs.ShardClientForBlockXyz(target)
 	if ok {
 		held, _ := player.inventory.HeldItem()
@@ -375,15 +542,17 @@ func (player *Player) PacketPlayerBlockHit(status DigStatus, target *BlockXyz, f
 }
 
 func (player *Player) PacketPlayerBlockInteract(itemId ItemTypeId, target *BlockXyz, face Face, amount ItemCount, uses ItemData) {
+	if face == FaceNull {
+		// The player is using their held item without targetting a block.
+		player.useHeldItem()
+		return
+	}
+
 	if face < FaceMinValid || face > FaceMaxValid {
-		// TODO sometimes FaceNull means something. This case should be covered.
 		log.Printf("Player/PacketPlayerBlockInteract: invalid face %d", face)
 		return
 	}
 
-	player.lock.Lock()
-	defer player.lock.Unlock()
-
 	// Validate that the player is actually somewhere near the block.
 	targetAbsPos := target.MidPointToAbsXyz()
 	if !targetAbsPos.IsWithinDistanceOf(&player.position, MaxInteractDistance) {
@@ -395,12 +564,12 @@ func (player *Player) PacketPlayerBlockInteract(itemId ItemTypeId, target *Block
 	if ok {
 		held, _ := player.inventory.HeldItem()
 		shardClient.ReqInteractBlock(held, *target, face)
+		player.pendingInteracts++
 	}
 }
 
 func (player *Player) PacketHoldingChange(slotId SlotId) {
-	player.lock.Lock()
-	defer player.lock.Unlock()
+	player.stopEating()
 	player.inventory.SetHolding(slotId)
 }
 
@@ -421,16 +590,10 @@ func (player *Player) PacketUnknown0x3d(field1, field2 int32, field3 int8, field
 }
 
 func (player *Player) PacketWindowClose(windowId WindowId) {
-	player.lock.Lock()
-	defer player.lock.Unlock()
-
 	player.closeCurrentWindow(false)
 }
 
 func (player *Player) PacketWindowClick(windowId WindowId, slotId SlotId, rightClick bool, txId TxId, shiftClick bool, expectedSlot *proto.WindowSlot) {
-	player.lock.Lock()
-	defer player.lock.Unlock()
-
 	// Note that the expectedSlot parameter is currently ignored. The item(s)
 	// involved are worked out from the server-side data.
 	// TODO use the expectedSlot as a conditions for the click, and base the
@@ -480,9 +643,38 @@ func (player *Player) PacketWindowClick(windowId WindowId, slotId SlotId, rightC
 		proto.WriteWindowTransaction(buf, windowId, txId, txState == TxStateAccepted)
 		player.cursor = click.Cursor
 		player.cursor.SendUpdate(buf, WindowIdCursor, SlotIdCursor)
-		player.TransmitPacket(buf.Bytes())
+		player.TransmitPacket(buf.Bytes(), proto.PacketPriorityNormal)
 	case TxStateDeferred:
 		// The remote inventory should send the transaction outcome.
+		player.pendingClicks++
+	}
+}
+
+// PacketCreativeInventoryAction sets the contents of a slot in the inventory
+// of a player in creative mode, who can take any item from the client's item
+// selection.
+func (player *Player) PacketCreativeInventoryAction(slotId SlotId, itemTypeId ItemTypeId, amount ItemCount, data ItemData) {
+	if player.gameMode != GameModeCreative {
+		log.Printf("Player %s is not in creative mode, ignoring inventory action", player.name)
+		return
+	}
+
+	// The client empties a slot by sending an item type ID of -1.
+	var item gamerules.Slot
+	if itemTypeId > 0 && amount > 0 {
+		if _, ok := gamerules.Items[itemTypeId]; !ok {
+			log.Printf("Player %s tried to create unknown item %d", player.name, itemTypeId)
+			return
+		}
+		item = gamerules.Slot{
+			ItemTypeId: itemTypeId,
+			Count:      amount,
+			Data:       data,
+		}
+	}
+
+	if !player.inventory.SetSlot(slotId, &item) {
+		log.Printf("Player %s tried to set invalid creative inventory slot %d", player.name, slotId)
 	}
 }
 
@@ -502,54 +694,126 @@ func (player *Player) PacketDisconnect(reason string) {
 
 	player.sendChatMessage(fmt.Sprintf("%s has left", player.name), false)
 
-	player.onDisconnect <- player.EntityId
-	player.txQueue <- nil
-	player.mainQueue <- nil
+	player.notifyDisconnect()
+	player.txQueue.Close()
+	player.stopped = true
 	player.conn.Close()
 }
 
+// notifyDisconnect tells the game that the player has gone. It is sent from
+// another goroutine, as the game may at the same time be posting requests to
+// the player.
+func (player *Player) notifyDisconnect() {
+	if player.onDisconnect == nil {
+		return
+	}
+	onDisconnect, entityId := player.onDisconnect, player.EntityId
+	go func() {
+		onDisconnect <- entityId
+	}()
+}
+
 func (player *Player) receiveLoop() {
+	defer close(player.receiveDone)
+
+	if IdleTimeout > 0 {
+		player.conn.SetReadTimeout(IdleTimeout)
+	}
+
 	for {
-		err := proto.ServerReadPacket(player.conn, player)
+		err := player.codec.ServerReadPacket(player.conn, &player.packetQueue)
 		if err != nil {
-			if err != os.EOF {
+			reason := "Connection lost"
+			if isTimeout(err) {
+				log.Printf("Player %s sent nothing for %d seconds", player.name, IdleTimeout/NanosecondsInSecond)
+				reason = "Timed out"
+			} else if err != os.EOF {
 				log.Print("ReceiveLoop failed: ", err.String())
 			}
+			player.disconnectAfterReceive(reason)
+			return
+		}
+
+		if player.checkTransfer() {
 			return
 		}
 	}
 }
 
+// disconnectAfterReceive disconnects the player once the receive loop can read
+// no more from the client, unless the main loop has already finished.
+func (player *Player) disconnectAfterReceive(reason string) {
+	player.postPacket(func(player *Player) {
+		player.PacketDisconnect(reason)
+	})
+}
+
 // End of packet handling code
 
 func (player *Player) transmitLoop() {
-	for {
-		bs, ok := <-player.txQueue
+	defer close(player.txDone)
 
-		if !ok || bs == nil {
+	if WriteTimeout > 0 {
+		player.conn.SetWriteTimeout(WriteTimeout)
+	}
+
+	for {
+		bs, ok := player.txQueue.Pop()
+		if !ok {
 			return // txQueue closed
 		}
 		_, err := player.conn.Write(bs)
 		if err != nil {
-			if err != os.EOF {
+			if isTimeout(err) {
+				log.Printf("Player %s stopped accepting packets for %d seconds", player.name, WriteTimeout/NanosecondsInSecond)
+			} else if err != os.EOF {
 				log.Print("TransmitLoop failed: ", err.String())
 			}
+
+			// Closing the connection ends the receive loop, which disconnects the
+			// player. Until then, packets for the client are discarded.
+			player.conn.Close()
+			player.txQueue.Discard()
 			return
 		}
 	}
 }
 
-func (player *Player) TransmitPacket(packet []byte) {
+// isTimeout returns true if err is from a network operation that timed out.
+func isTimeout(err os.Error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+// ShowEntity shows an entity to the client from a chunk that the player views.
+func (player *Player) ShowEntity(chunkLoc ChunkXz, entityId EntityId, spawnPacket []byte) {
+	player.visible.Show(chunkLoc, entityId, spawnPacket)
+}
+
+// HideEntity stops showing an entity to the client from a chunk.
+func (player *Player) HideEntity(chunkLoc ChunkXz, entityId EntityId) {
+	player.visible.Hide(chunkLoc, entityId)
+}
+
+// TransmitPacket queues packet to be sent to the client with the given
+// priority. Once entity movement dropped for the client falling behind can be
+// queued again, the client is sent the full positions of the entities it sees.
+func (player *Player) TransmitPacket(packet []byte, priority proto.PacketPriority) {
 	if packet == nil {
 		return // skip empty packets
 	}
-	player.txQueue <- packet
-}
-
-func (player *Player) runQueuedCall(f func(*Player)) {
-	player.lock.Lock()
-	defer player.lock.Unlock()
-	f(player)
+	if !player.txQueue.Push(packet, priority) {
+		// Closing the connection ends the receive loop, which disconnects the
+		// player.
+		log.Printf("Player %s fell more than %d bytes behind", player.name, TxQueueMaxBytes)
+		player.conn.Close()
+		return
+	}
+	if priority == proto.PacketPriorityEntityMove && player.txQueue.TakeMovesDropped() {
+		player.inbox.Post(func(player *Player) {
+			player.chunkSubs.teleportEntities()
+		})
+	}
 }
 
 func (player *Player) mainLoop() {
@@ -559,14 +823,34 @@ func (player *Player) mainLoop() {
 	player.chunkSubs.Init(player)
 	defer player.chunkSubs.Close()
 
-	player.sendChatMessage(fmt.Sprintf("%s has joined", player.name), false)
+	if !player.transferredIn {
+		player.sendChatMessage(fmt.Sprintf("%s has joined", player.name), false)
+	}
 
-	for {
-		f, ok := <-player.mainQueue
-		if !ok || f == nil {
-			return
+	player.runQueue()
+}
+
+// runQueue runs the functions posted to mainQueue and inbox until the player
+// stops.
+func (player *Player) runQueue() {
+	defer close(player.mainDone)
+	defer player.inbox.Close()
+
+	for !player.stopped {
+		select {
+		case f, ok := <-player.mainQueue:
+			if !ok || f == nil {
+				return
+			}
+			f(player)
+		case <-player.inbox.ready:
+			for _, f := range player.inbox.Take() {
+				f(player)
+				if player.stopped {
+					return
+				}
+			}
 		}
-		player.runQueuedCall(f)
 	}
 }
 
@@ -584,9 +868,12 @@ func (player *Player) notifyChunkLoad() {
 			&player.position, player.position.Y+player.height,
 			&player.look, false)
 		player.inventory.WriteWindowItems(buf)
-		proto.WriteUpdateHealth(buf, player.health)
+		player.codec.WriteUpdateHealth(buf, player.health, player.food, player.foodSaturation)
+		player.codec.WriteExperience(buf, int8(player.experience), player.level, player.totalExperience)
+
+		player.TransmitPacket(buf.Bytes(), proto.PacketPriorityNormal)
 
-		player.TransmitPacket(buf.Bytes())
+		player.reopenWindow()
 	}
 }
 
@@ -614,7 +901,7 @@ func (player *Player) inventorySubscribed(block *BlockXyz, invTypeId InvTypeId,
 	buf := new(bytes.Buffer)
 	window.WriteWindowOpen(buf)
 	window.WriteWindowItems(buf)
-	player.TransmitPacket(buf.Bytes())
+	player.TransmitPacket(buf.Bytes(), proto.PacketPriorityNormal)
 }
 
 func (player *Player) inventorySlotUpdate(block *BlockXyz, slot *gamerules.Slot, slotId SlotId) {
@@ -634,6 +921,11 @@ func (player *Player) inventoryProgressUpdate(block *BlockXyz, prgBarId PrgBarId
 }
 
 func (player *Player) inventoryCursorUpdate(block *BlockXyz, cursor *gamerules.Slot) {
+	// Each remote inventory click ends with a cursor update.
+	if player.pendingClicks > 0 {
+		player.pendingClicks--
+	}
+
 	if player.remoteInv == nil || !player.remoteInv.IsForBlock(block) {
 		return
 	}
@@ -641,7 +933,7 @@ func (player *Player) inventoryCursorUpdate(block *BlockXyz, cursor *gamerules.S
 	player.cursor = *cursor
 	buf := new(bytes.Buffer)
 	player.cursor.SendUpdate(buf, WindowIdCursor, SlotIdCursor)
-	player.TransmitPacket(buf.Bytes())
+	player.TransmitPacket(buf.Bytes(), proto.PacketPriorityNormal)
 }
 
 func (player *Player) inventoryTxState(block *BlockXyz, txId TxId, accepted bool) {
@@ -651,7 +943,7 @@ func (player *Player) inventoryTxState(block *BlockXyz, txId TxId, accepted bool
 
 	buf := new(bytes.Buffer)
 	proto.WriteWindowTransaction(buf, player.curWindow.WindowId(), txId, accepted)
-	player.TransmitPacket(buf.Bytes())
+	player.TransmitPacket(buf.Bytes(), proto.PacketPriorityNormal)
 }
 
 func (player *Player) inventoryUnsubscribed(block *BlockXyz) {
@@ -663,6 +955,12 @@ func (player *Player) inventoryUnsubscribed(block *BlockXyz) {
 }
 
 func (player *Player) placeHeldItem(target *BlockXyz, wasHeld *gamerules.Slot) {
+	if player.transferred {
+		// Transfers wait for interactions to finish, so this can't happen.
+		log.Printf("Player %s asked to place an item after being transferred", player.name)
+		return
+	}
+
 	curHeld, _ := player.inventory.HeldItem()
 
 	// Currently held item has changed since chunk saw it.
@@ -676,17 +974,35 @@ func (player *Player) placeHeldItem(target *BlockXyz, wasHeld *gamerules.Slot) {
 	if ok {
 		var into gamerules.Slot
 
-		player.inventory.TakeOneHeldItem(&into)
+		if player.gameMode == GameModeCreative {
+			// Players in creative mode don't use up what they place.
+			into = curHeld
+			into.Count = 1
+		} else {
+			player.inventory.TakeOneHeldItem(&into)
+		}
+
+		if into.ItemTypeId == ItemTypeIdBed {
+			// Beds are placed facing away from the player, which the chunk has no
+			// way of knowing, so the direction is passed in the item data.
+			into.Data = ItemData(gamerules.BedDirectionFromYaw(player.look.Yaw))
+		}
 
 		shardClient.ReqPlaceItem(*target, into)
 	}
 }
 
+func (player *Player) interactDone() {
+	if player.pendingInteracts > 0 {
+		player.pendingInteracts--
+	}
+}
+
 // Used to receive items picked up from chunks. It is synchronous so that the
 // passed item can be looked at by the caller afterwards to see if it has been
 // consumed.
 func (player *Player) offerItem(fromChunk *ChunkXz, entityId EntityId, item *gamerules.Slot) {
-	if player.inventory.CanTakeItem(item) {
+	if !player.transferred && player.inventory.CanTakeItem(item) {
 		shardClient, ok := player.chunkSubs.ShardClientForChunkXz(fromChunk)
 		if ok {
 			shardClient.ReqTakeItem(*fromChunk, entityId)
@@ -709,37 +1025,85 @@ func (player *Player) giveItem(atPosition *AbsXyz, item *gamerules.Slot) {
 		}
 	}()
 
-	player.inventory.PutItem(item)
+	if !player.transferred {
+		// Otherwise, the item is dropped rather than being lost with this copy of
+		// the player.
+		player.inventory.PutItem(item)
+	}
+}
+
+// SendKeepAlive requests that the player's client be sent a keep-alive with
+// the given ID, which should not be zero. The request is dropped if the
+// player's main loop has fallen behind, as the next keep-alive will do as
+// well.
+func (player *Player) SendKeepAlive(id int32) {
+	player.TryEnqueue(func(_ *Player) {
+		player.keepAliveId = id
+		player.keepAliveSent = time.Nanoseconds()
+
+		buf := new(bytes.Buffer)
+		player.codec.WriteKeepAlive(buf, id)
+		player.TransmitPacket(buf.Bytes(), proto.PacketPriorityHigh)
+	})
 }
 
-// Enqueue queues a function to run with the player lock within the player's
-// mainloop.
+// Enqueue queues a function to run within the player's main loop. It never
+// waits, so that shards and the game can make requests of a player that may
+// itself be waiting on them. The function is dropped if the main loop has
+// finished.
 func (player *Player) Enqueue(f func(*Player)) {
 	if f == nil {
 		return
 	}
-	player.mainQueue <- f
+	player.inbox.Post(f)
+}
+
+// TryEnqueue is as Enqueue, but drops the function and returns false if the
+// player's main loop has fallen behind. It is for periodic requests from the
+// game loop, such as keep-alives, which needn't pile up for a player that
+// isn't keeping up.
+func (player *Player) TryEnqueue(f func(*Player)) bool {
+	if f == nil {
+		return true
+	}
+	return player.inbox.TryPost(f)
+}
+
+// postPacket queues the handler of a packet from the client to run within the
+// main loop. It waits for room in the queue, so that a client sending packets
+// faster than they can be handled is slowed down, unless the main loop has
+// finished, in which case the handler is dropped. It must only be called by
+// the receive loop.
+func (player *Player) postPacket(f func(*Player)) {
+	select {
+	case player.mainQueue <- f:
+	case <-player.mainDone:
+	}
 }
 
 func (player *Player) sendChatMessage(message string, sendToSelf bool) {
 	buf := new(bytes.Buffer)
 	proto.WriteChatMessage(buf, message)
 
-	packet := buf.Bytes()
+	player.multicastPacket(buf.Bytes(), proto.PacketPriorityHigh, sendToSelf)
+}
 
+// multicastPacket sends a packet to the players near to this one, and
+// optionally to this player as well.
+func (player *Player) multicastPacket(packet []byte, priority proto.PacketPriority, sendToSelf bool) {
 	if sendToSelf {
-		player.TransmitPacket(packet)
+		player.TransmitPacket(packet, priority)
 	}
 
 	player.chunkSubs.curShard.ReqMulticastPlayers(
 		player.chunkSubs.curChunkLoc,
 		player.EntityId,
 		packet,
+		priority,
 	)
 }
 
-// closeCurrentWindow closes any open window. It must be called with
-// player.lock held.
+// closeCurrentWindow closes any open window.
 func (player *Player) closeCurrentWindow(sendClosePacket bool) {
 	if player.curWindow != nil {
 		player.curWindow.Finalize(sendClosePacket)
@@ -769,6 +1133,6 @@ func (player *Player) setPositionLook(pos AbsXyz, look LookDegrees) {
 		// Tell the player's client about their new position
 		buf := new(bytes.Buffer)
 		proto.WritePlayerPosition(buf, &pos, StanceNormal, true)
-		player.TransmitPacket(buf.Bytes())
+		player.TransmitPacket(buf.Bytes(), proto.PacketPriorityNormal)
 	}
 }
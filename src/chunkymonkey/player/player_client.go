@@ -8,7 +8,8 @@ import (
 )
 
 // playerClient presents a thread-safe interface for interacting with a Player
-// object.
+// object. Its requests are posted to the player's inbox, so shards never wait
+// on the player.
 type playerClient struct {
 	player *Player
 }
@@ -21,8 +22,16 @@ func (p *playerClient) GetEntityId() EntityId {
 	return p.player.EntityId
 }
 
-func (p *playerClient) TransmitPacket(packet []byte) {
-	p.player.TransmitPacket(packet)
+func (p *playerClient) TransmitPacket(packet []byte, priority proto.PacketPriority) {
+	p.player.TransmitPacket(packet, priority)
+}
+
+func (p *playerClient) ShowEntity(chunkLoc ChunkXz, entityId EntityId, spawnPacket []byte) {
+	p.player.ShowEntity(chunkLoc, entityId, spawnPacket)
+}
+
+func (p *playerClient) HideEntity(chunkLoc ChunkXz, entityId EntityId) {
+	p.player.HideEntity(chunkLoc, entityId)
 }
 
 func (p *playerClient) NotifyChunkLoad() {
@@ -73,6 +82,12 @@ func (p *playerClient) PlaceHeldItem(target BlockXyz, wasHeld gamerules.Slot) {
 	})
 }
 
+func (p *playerClient) InteractDone() {
+	p.player.Enqueue(func(_ *Player) {
+		p.player.interactDone()
+	})
+}
+
 func (p *playerClient) OfferItem(fromChunk ChunkXz, entityId EntityId, item gamerules.Slot) {
 	p.player.Enqueue(func(_ *Player) {
 		p.player.offerItem(&fromChunk, entityId, &item)
@@ -95,23 +110,28 @@ func (p *playerClient) EchoMessage(msg string) {
 	p.player.Enqueue(func(_ *Player) {
 		buf := new(bytes.Buffer)
 		proto.WriteChatMessage(buf, msg)
-		p.TransmitPacket(buf.Bytes())
+		p.TransmitPacket(buf.Bytes(), proto.PacketPriorityHigh)
 	})
 }
 
-func (p *playerClient) PositionLook() (AbsXyz, LookDegrees) {
-	posChan := make(chan AbsXyz)
-	lookChan := make(chan LookDegrees)
+// PositionLook waits for the player's main loop to give its position and look.
+// It must not be called by shards, which the main loop may be waiting on. The
+// zero position and look are returned if the player has disconnected.
+func (p *playerClient) PositionLook() (pos AbsXyz, look LookDegrees) {
+	// Buffered so that the main loop never waits on the caller.
+	posChan := make(chan AbsXyz, 1)
+	lookChan := make(chan LookDegrees, 1)
 
 	p.player.Enqueue(func(player *Player) {
 		posChan <- player.position
 		lookChan <- player.look
-		close(posChan)
-		close(lookChan)
 	})
 
-	pos := <-posChan
-	look := <-lookChan
+	select {
+	case pos = <-posChan:
+		look = <-lookChan
+	case <-p.player.mainDone:
+	}
 
 	return pos, look
 }
@@ -121,3 +141,57 @@ func (p *playerClient) SetPositionLook(pos AbsXyz, look LookDegrees) {
 		player.setPositionLook(pos, look)
 	})
 }
+
+func (p *playerClient) SetPosition(pos AbsXyz) {
+	p.player.Enqueue(func(player *Player) {
+		player.setPositionLook(pos, player.look)
+	})
+}
+
+func (p *playerClient) Sleep(bedLoc BlockXyz) {
+	p.player.Enqueue(func(player *Player) {
+		player.sleep(&bedLoc)
+	})
+}
+
+func (p *playerClient) BedMissing(bedLoc BlockXyz) {
+	p.player.Enqueue(func(player *Player) {
+		player.bedMissing(&bedLoc)
+	})
+}
+
+func (p *playerClient) EnterPortal() {
+	p.player.Enqueue(func(player *Player) {
+		player.enterPortal()
+	})
+}
+
+func (p *playerClient) Mount(vehicleId EntityId) {
+	p.player.Enqueue(func(player *Player) {
+		player.mount(vehicleId)
+	})
+}
+
+func (p *playerClient) Dismount(vehicleId EntityId) {
+	p.player.Enqueue(func(player *Player) {
+		player.dismount(vehicleId)
+	})
+}
+
+func (p *playerClient) VehicleMoved(vehicleId EntityId, position AbsXyz) {
+	p.player.Enqueue(func(player *Player) {
+		player.vehicleMoved(vehicleId, &position)
+	})
+}
+
+func (p *playerClient) Damage(amount Health, attacker EntityId) {
+	p.player.Enqueue(func(player *Player) {
+		player.damage(amount, attacker)
+	})
+}
+
+func (p *playerClient) GiveExperience(amount int16) {
+	p.player.Enqueue(func(player *Player) {
+		player.giveExperience(amount)
+	})
+}
@@ -49,6 +49,8 @@ func (sub *chunkSubscriptions) Init(player *Player) {
 		*player.look.ToLookBytes(),
 		player.getHeldItemTypeId(),
 	)
+
+	sub.viewChunks(orderedChunkSquare(sub.curChunkLoc, EntityRadius))
 }
 
 // Move should be called as the player moves around the world. It replicates
@@ -73,7 +75,8 @@ func (sub *chunkSubscriptions) Move(newLoc *AbsXyz) (notify bool) {
 }
 
 // Close closes down all shard connections. Use when the player is
-// disconnected.
+// disconnected, or changes dimension, which discards the entities on the
+// client.
 func (sub *chunkSubscriptions) Close() {
 	curShardLoc := sub.curChunkLoc.ToShardXz()
 	if ref, ok := sub.shardClients[curShardLoc.Key()]; ok {
@@ -84,6 +87,8 @@ func (sub *chunkSubscriptions) Close() {
 		ref.shard.Disconnect()
 		sub.shardClients[key] = nil, false
 	}
+
+	sub.player.visible.Reset()
 }
 
 // CurrentShardClient is a convenience function to get a client shard
@@ -178,8 +183,41 @@ func (sub *chunkSubscriptions) unsubscribeFromChunks(chunkLocs []ChunkXz) {
 	}
 }
 
+// viewChunks requests that the entities in the subscribed chunks given be
+// shown to the player.
+func (sub *chunkSubscriptions) viewChunks(chunkLocs []ChunkXz) {
+	for _, chunkLoc := range chunkLocs {
+		if shard, ok := sub.ShardClientForChunkXz(&chunkLoc); ok {
+			sub.player.visible.ViewChunk(chunkLoc)
+			shard.ReqViewEntities(chunkLoc, true)
+		}
+	}
+}
+
+// teleportEntities requests that the client be sent the full positions of the
+// entities in the chunks that the player views, after it has missed some of
+// their movement.
+func (sub *chunkSubscriptions) teleportEntities() {
+	for _, chunkLoc := range orderedChunkSquare(sub.curChunkLoc, EntityRadius) {
+		if shard, ok := sub.ShardClientForChunkXz(&chunkLoc); ok {
+			shard.ReqTeleportEntities(chunkLoc)
+		}
+	}
+}
+
+// unviewChunks hides the entities in the chunks given from the player.
+func (sub *chunkSubscriptions) unviewChunks(chunkLocs []ChunkXz) {
+	for _, chunkLoc := range chunkLocs {
+		if shard, ok := sub.ShardClientForChunkXz(&chunkLoc); ok {
+			shard.ReqViewEntities(chunkLoc, false)
+		}
+		sub.player.visible.UnviewChunk(chunkLoc)
+	}
+}
+
 // moveToChunk subscribes to chunks that are newly in range, and unsubscribes
-// to those that have just left.
+// to those that have just left. Likewise, it views the entities of chunks that
+// are newly within EntityRadius, and stops viewing those that have left it.
 func (sub *chunkSubscriptions) moveToChunk(newChunkLoc ChunkXz, newLoc *AbsXyz) (notify bool) {
 	addChunkLocs := squareDifference(newChunkLoc, sub.curChunkLoc, ChunkRadius)
 	notify = sub.subscribeToChunks(newChunkLoc, addChunkLocs)
@@ -200,6 +238,9 @@ func (sub *chunkSubscriptions) moveToChunk(newChunkLoc ChunkXz, newLoc *AbsXyz)
 		ref.shard.ReqRemovePlayerData(sub.curChunkLoc, false)
 	}
 
+	sub.viewChunks(squareDifference(newChunkLoc, sub.curChunkLoc, EntityRadius))
+	sub.unviewChunks(squareDifference(sub.curChunkLoc, newChunkLoc, EntityRadius))
+
 	delChunkLocs := squareDifference(sub.curChunkLoc, newChunkLoc, ChunkRadius)
 	sub.unsubscribeFromChunks(delChunkLocs)
 
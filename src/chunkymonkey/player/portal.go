@@ -0,0 +1,82 @@
+package player
+
+import (
+	"bytes"
+	"log"
+	"time"
+
+	"chunkymonkey/proto"
+	. "chunkymonkey/types"
+)
+
+// Minimum time between a player travelling through portals, so that they
+// don't bounce straight back through the portal they arrive by.
+const portalCooldown = 4 * NanosecondsInSecond
+
+// portalDestination returns the dimension that a portal in the given dimension
+// leads to, and the position in that dimension corresponding to pos.
+func portalDestination(from DimensionId, pos AbsXyz) (to DimensionId, dest AbsXyz) {
+	dest = pos
+	if from == DimensionNether {
+		to = DimensionNormal
+		dest.X *= NetherScale
+		dest.Z *= NetherScale
+	} else {
+		to = DimensionNether
+		dest.X /= NetherScale
+		dest.Z /= NetherScale
+	}
+	return
+}
+
+// enterPortal is called when the player stands in a portal block, and moves
+// them to the other dimension.
+func (player *Player) enterPortal() {
+	if !player.spawnComplete {
+		return
+	}
+
+	now := time.Nanoseconds()
+	if now-player.lastPortalTime < portalCooldown {
+		return
+	}
+
+	dimension, position := portalDestination(DimensionId(player.dimension), player.position)
+	if player.game.ShardConnecter(player.world, dimension) == nil {
+		log.Printf("Player %s entered a portal to unsupported dimension %d", player.name, dimension)
+		return
+	}
+
+	player.lastPortalTime = now
+	player.changeDimension(dimension, position)
+
+	// The player is moved again once the destination chunk has found or built a
+	// portal for them to arrive by.
+	player.chunkSubs.curShard.ReqCreatePortal(*position.ToBlockXyz())
+}
+
+// changeDimension moves the player to the given position in another
+// dimension. The client is told to discard the world it has, and the player is
+// subscribed to chunks in the new dimension.
+func (player *Player) changeDimension(dimension DimensionId, position AbsXyz) {
+	if player.curWindow != nil || player.remoteInv != nil {
+		player.closeCurrentWindow(true)
+	}
+
+	player.chunkSubs.Close()
+
+	// Any vehicle is left behind, and lets go of the player when they
+	// unsubscribe from its chunk.
+	player.vehicle = NoEntityId
+
+	player.dimension = int32(dimension)
+	player.shardConnecter = player.game.ShardConnecter(player.world, dimension)
+	player.position = position
+	player.spawnComplete = false
+
+	buf := new(bytes.Buffer)
+	player.codec.WriteRespawn(buf, dimension, player.gameMode)
+	player.TransmitPacket(buf.Bytes(), proto.PacketPriorityNormal)
+
+	player.chunkSubs.Init(player)
+}
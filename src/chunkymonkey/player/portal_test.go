@@ -0,0 +1,32 @@
+package player
+
+import (
+	"testing"
+
+	. "chunkymonkey/types"
+)
+
+func TestPortalDestination(t *testing.T) {
+	type Test struct {
+		from       DimensionId
+		pos        AbsXyz
+		expectedTo DimensionId
+		expected   AbsXyz
+	}
+
+	tests := []Test{
+		{DimensionNormal, AbsXyz{800, 70, -160}, DimensionNether, AbsXyz{100, 70, -20}},
+		{DimensionNormal, AbsXyz{4, 64, 12}, DimensionNether, AbsXyz{0.5, 64, 1.5}},
+		{DimensionNether, AbsXyz{100, 70, -20}, DimensionNormal, AbsXyz{800, 70, -160}},
+		{DimensionNether, AbsXyz{-0.5, 40, 3}, DimensionNormal, AbsXyz{-4, 40, 24}},
+	}
+
+	for _, test := range tests {
+		to, dest := portalDestination(test.from, test.pos)
+		if to != test.expectedTo || dest.X != test.expected.X || dest.Y != test.expected.Y || dest.Z != test.expected.Z {
+			t.Errorf(
+				"portalDestination(%d, %v) expected (%d, %v) got (%d, %v)",
+				test.from, test.pos, test.expectedTo, test.expected, to, dest)
+		}
+	}
+}
@@ -0,0 +1,416 @@
+package player
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+
+	"chunkymonkey/gamerules"
+	"chunkymonkey/nbtutil"
+	"chunkymonkey/proto"
+	. "chunkymonkey/types"
+	"chunkymonkey/util"
+	"nbt"
+)
+
+// A player's session is transferred to another frontend as follows:
+//
+// 1. The receive loop notices the transfer request between packets from the
+//    client, and waits while the main loop hands over the player, once no
+//    remote inventory clicks are awaiting their outcome, and no block
+//    interactions that might place the held item are awaiting the shard.
+// 2. The main loop connects to the other frontend and sends the player's
+//    state (as written by WriteTransferNbt) as an NBT compound, preceded by
+//    its size in bytes as a big-endian int32.
+// 3. The other frontend replies in the same way with a compound containing an
+//    "Error" string, which is empty if it has accepted the player.
+// 4. This frontend then forwards data between the client and the other
+//    frontend until either side closes its connection.
+//
+// The client is not told of the transfer, so the other frontend must keep the
+// player's EntityId.
+//
+// The player's state carries TransferSecret, which the other frontend checks
+// against its own, as otherwise anyone could log in a player of their choosing
+// by way of a transfer.
+
+// TransferSecret is shared by the frontends that transfer players between each
+// other. Transfers are refused while it is empty.
+var TransferSecret string
+
+// Limits on the connections between frontends that transfer players.
+var (
+	// TransferDialTimeout is how long to wait to connect to the other frontend.
+	TransferDialTimeout int64 = 10 * NanosecondsInSecond
+
+	// TransferTimeout is how long a frontend waits on the other to send or
+	// receive a player's state, or the reply to it.
+	TransferTimeout int64 = 30 * NanosecondsInSecond
+
+	// TransferMaxSize is the size in bytes of the largest message that is read
+	// from the other frontend.
+	TransferMaxSize = 1 << 20
+)
+
+type transferRequest struct {
+	addr   string
+	result chan os.Error
+}
+
+// errRequestsPending is returned by the main loop to the receive loop to retry
+// a transfer after the next packet.
+var errRequestsPending = os.NewError("remote inventory clicks or block interactions are pending")
+
+// Transfer hands the player's session to the frontend accepting transfers at
+// addr. It returns once the other frontend has accepted the player, or the
+// transfer has failed, in which case the player stays on this frontend.
+func (player *Player) Transfer(addr string) os.Error {
+	req := &transferRequest{addr, make(chan os.Error, 1)}
+	select {
+	case player.transfers <- req:
+	default:
+		return fmt.Errorf("player %q is already being transferred", player.name)
+	}
+
+	select {
+	case err := <-req.result:
+		return err
+	case <-player.receiveDone:
+	}
+	return fmt.Errorf("player %q has disconnected", player.name)
+}
+
+// Transferred returns true if the player's session has been handed to another
+// frontend, which is then responsible for storing the player's data. It must
+// only be called by the main loop, or once it has sent to onDisconnect.
+func (player *Player) Transferred() bool {
+	return player.transferred
+}
+
+// checkTransfer carries out any pending transfer request. It must only be
+// called by the receive loop, between packets. It returns true if the session
+// has been handed over and the connections have since closed.
+func (player *Player) checkTransfer() bool {
+	var req *transferRequest
+	select {
+	case req = <-player.transfers:
+	default:
+		return false
+	}
+
+	// The main loop hands over the player's state, while the receive loop waits
+	// so that no more packets are read from the client.
+	type handOverResult struct {
+		remote net.Conn
+		err    os.Error
+	}
+	result := make(chan handOverResult, 1)
+	player.postPacket(func(player *Player) {
+		if player.pendingClicks > 0 || player.pendingInteracts > 0 {
+			// The outcome of the clicks might change the cursor, and the
+			// interactions might take the held item, so wait for them.
+			result <- handOverResult{nil, errRequestsPending}
+			return
+		}
+		remote, err := player.handOver(req.addr)
+		result <- handOverResult{remote, err}
+	})
+
+	var r handOverResult
+	select {
+	case r = <-result:
+	case <-player.mainDone:
+		r.err = fmt.Errorf("player %q has disconnected", player.name)
+	}
+
+	if r.err == errRequestsPending {
+		select {
+		case player.transfers <- req:
+		default:
+			req.result <- fmt.Errorf("player %q is already being transferred", player.name)
+		}
+		return false
+	}
+
+	req.result <- r.err
+	if r.err != nil {
+		return false
+	}
+
+	player.forward(r.remote)
+	return true
+}
+
+// handOver sends the player's state to the frontend at addr, and stops this
+// frontend from acting for the player if it is accepted.
+func (player *Player) handOver(addr string) (remote net.Conn, err os.Error) {
+	if remote, err = util.DialTimeout(addr, TransferDialTimeout); err != nil {
+		return
+	}
+
+	remote.SetTimeout(TransferTimeout)
+	data := player.WriteTransferNbt()
+	data.Tags["Secret"] = &nbt.String{TransferSecret}
+	if err = writeTransferMsg(remote, data); err == nil {
+		err = readTransferAck(remote)
+	}
+	if err != nil {
+		remote.Close()
+		return nil, err
+	}
+	// The forwarded connection may be idle for as long as the client is.
+	remote.SetTimeout(0)
+
+	log.Printf("Player %s transferred to %s", player.name, addr)
+
+	player.transferred = true
+	if player.remoteInv != nil {
+		// The other frontend opens the window again.
+		player.remoteInv.Close()
+		player.remoteInv = nil
+		player.curWindow = nil
+	}
+
+	player.notifyDisconnect()
+	player.txQueue.Close()
+	player.stopped = true
+
+	return remote, nil
+}
+
+// forward passes data between the client and the frontend that the player
+// has been transferred to.
+func (player *Player) forward(remote net.Conn) {
+	// Packets already queued for the client must reach it first.
+	<-player.txDone
+
+	go func() {
+		io.Copy(player.conn, remote)
+		player.conn.Close()
+	}()
+
+	io.Copy(remote, player.conn)
+	remote.Close()
+}
+
+// WriteTransferNbt serializes the player's state, including that which is only
+// kept while they are logged in, so that another frontend can take over their
+// session.
+func (player *Player) WriteTransferNbt() *nbt.Compound {
+	data := player.WriteNbt()
+
+	_, holding := player.inventory.HeldItem()
+	data.Tags["Name"] = &nbt.String{player.name}
+	data.Tags["EntityId"] = &nbt.Int{int32(player.EntityId)}
+	data.Tags["ProtocolVersion"] = &nbt.Int{player.codec.Version}
+	data.Tags["SelectedItemSlot"] = &nbt.Byte{int8(holding)}
+	data.Tags["NextWindowId"] = &nbt.Byte{int8(player.nextWindowId)}
+	data.Tags["Crafting"] = player.inventory.WriteCraftingNbt()
+
+	if !player.cursor.IsEmpty() {
+		data.Tags["Cursor"] = &nbt.Compound{
+			map[string]nbt.ITag{
+				"id":     &nbt.Short{int16(player.cursor.ItemTypeId)},
+				"Count":  &nbt.Byte{int8(player.cursor.Count)},
+				"Damage": &nbt.Short{int16(player.cursor.Data)},
+			},
+		}
+	}
+
+	if player.remoteInv != nil && player.curWindow != nil {
+		block := &player.remoteInv.blockLoc
+		data.Tags["Window"] = &nbt.Compound{
+			map[string]nbt.ITag{
+				"Id": &nbt.Byte{int8(player.curWindow.WindowId())},
+				"X":  &nbt.Int{int32(block.X)},
+				"Y":  &nbt.Int{int32(block.Y)},
+				"Z":  &nbt.Int{int32(block.Z)},
+			},
+		}
+	}
+
+	return data
+}
+
+// ReadTransferNbt reads the player's state as written by WriteTransferNbt. It
+// must only be called before Player.StartTransferred().
+func (player *Player) ReadTransferNbt(data nbt.ITag) (err os.Error) {
+	if err = player.ReadNbt(data); err != nil {
+		return
+	}
+
+	holding, err := nbtutil.ReadByte(data, "SelectedItemSlot")
+	if err != nil {
+		return
+	}
+	player.inventory.SetHolding(SlotId(holding))
+
+	nextWindowId, err := nbtutil.ReadByte(data, "NextWindowId")
+	if err != nil {
+		return
+	}
+	player.nextWindowId = WindowId(nextWindowId)
+
+	if crafting := data.Lookup("Crafting"); crafting != nil {
+		if err = player.inventory.ReadCraftingNbt(crafting); err != nil {
+			return
+		}
+	}
+
+	if cursor := data.Lookup("Cursor"); cursor != nil {
+		if err = player.cursor.ReadNbt(cursor); err != nil {
+			return
+		}
+	}
+
+	if window := data.Lookup("Window"); window != nil {
+		var windowId int8
+		var x, y, z int32
+		if windowId, err = nbtutil.ReadByte(window, "Id"); err != nil {
+			return
+		}
+		if x, err = nbtutil.ReadInt(window, "X"); err != nil {
+			return
+		}
+		if y, err = nbtutil.ReadInt(window, "Y"); err != nil {
+			return
+		}
+		if z, err = nbtutil.ReadInt(window, "Z"); err != nil {
+			return
+		}
+		// The window is opened again with the same ID as the client has.
+		player.nextWindowId = WindowId(windowId)
+		player.windowBlock = &BlockXyz{BlockCoord(x), BlockYCoord(y), BlockCoord(z)}
+	}
+
+	player.transferredIn = true
+
+	return
+}
+
+// reopenWindow opens the window that the player had open on the frontend that
+// they were transferred from, once the chunks around them have loaded.
+func (player *Player) reopenWindow() {
+	if player.windowBlock == nil {
+		return
+	}
+	block := *player.windowBlock
+	player.windowBlock = nil
+
+	if shardClient, _, ok := player.chunkSubs.ShardClientForBlockXyz(&block); ok {
+		shardClient.ReqInteractBlock(gamerules.Slot{}, block, FaceNull)
+		player.pendingInteracts++
+	}
+}
+
+// ReadTransfer reads the state of a player being transferred from another
+// frontend, and the codec for their client's protocol version. The transfer
+// must then be accepted or refused with AckTransfer. The connection has
+// TransferTimeout set until then.
+func ReadTransfer(conn net.Conn) (name string, entityId EntityId, codec *proto.Codec, data nbt.ITag, err os.Error) {
+	conn.SetTimeout(TransferTimeout)
+	if data, err = readTransferMsg(conn); err != nil {
+		return
+	}
+
+	if TransferSecret == "" {
+		return "", 0, nil, nil, os.NewError("transfers are not accepted")
+	}
+	secretTag, ok := data.Lookup("Secret").(*nbt.String)
+	if !ok || subtle.ConstantTimeCompare([]byte(secretTag.Value), []byte(TransferSecret)) != 1 {
+		return "", 0, nil, nil, os.NewError("transfer has the wrong secret")
+	}
+
+	nameTag, ok := data.Lookup("Name").(*nbt.String)
+	if !ok {
+		return "", 0, nil, nil, os.NewError("transferred player has no name")
+	}
+
+	entityIdTag, ok := data.Lookup("EntityId").(*nbt.Int)
+	if !ok {
+		return "", 0, nil, nil, os.NewError("transferred player has no EntityId")
+	}
+
+	versionTag, ok := data.Lookup("ProtocolVersion").(*nbt.Int)
+	if !ok {
+		return "", 0, nil, nil, os.NewError("transferred player has no ProtocolVersion")
+	}
+	if codec, ok = proto.CodecForVersion(versionTag.Value); !ok {
+		return "", 0, nil, nil, fmt.Errorf("transferred player has unsupported protocol version %d", versionTag.Value)
+	}
+
+	return nameTag.Value, EntityId(entityIdTag.Value), codec, data, nil
+}
+
+// AckTransfer tells the frontend that a player is being transferred from that
+// the transfer has been accepted, if refusal is nil, or refused. It clears the
+// timeout set by ReadTransfer.
+func AckTransfer(conn net.Conn, refusal os.Error) os.Error {
+	var reason string
+	if refusal != nil {
+		reason = refusal.String()
+	}
+
+	err := writeTransferMsg(conn, &nbt.Compound{
+		map[string]nbt.ITag{
+			"Error": &nbt.String{reason},
+		},
+	})
+	conn.SetTimeout(0)
+	return err
+}
+
+func readTransferAck(conn net.Conn) os.Error {
+	ack, err := readTransferMsg(conn)
+	if err != nil {
+		return err
+	}
+
+	reason, ok := ack.Lookup("Error").(*nbt.String)
+	if !ok {
+		return os.NewError("bad transfer acknowledgement")
+	}
+	if reason.Value != "" {
+		return fmt.Errorf("transfer refused: %s", reason.Value)
+	}
+
+	return nil
+}
+
+// writeTransferMsg sends an NBT compound to the other frontend, preceded by its
+// size.
+func writeTransferMsg(conn net.Conn, tag *nbt.Compound) (err os.Error) {
+	var buf bytes.Buffer
+	if err = nbt.Write(&buf, tag); err != nil {
+		return
+	}
+
+	if err = binary.Write(conn, binary.BigEndian, int32(buf.Len())); err != nil {
+		return
+	}
+	_, err = conn.Write(buf.Bytes())
+	return
+}
+
+// readTransferMsg reads an NBT compound sent by writeTransferMsg. Messages
+// larger than TransferMaxSize are refused without being read.
+func readTransferMsg(conn net.Conn) (tag nbt.ITag, err os.Error) {
+	var size int32
+	if err = binary.Read(conn, binary.BigEndian, &size); err != nil {
+		return
+	}
+	if size < 0 || int(size) > TransferMaxSize {
+		return nil, fmt.Errorf("transfer message size %d is out of range", size)
+	}
+
+	buf := make([]byte, size)
+	if _, err = io.ReadFull(conn, buf); err != nil {
+		return
+	}
+	return nbt.Read(bytes.NewBuffer(buf))
+}
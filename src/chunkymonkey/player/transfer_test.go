@@ -0,0 +1,317 @@
+package player
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"chunkymonkey/gamerules"
+	"chunkymonkey/proto"
+	. "chunkymonkey/types"
+	"nbt"
+)
+
+// acceptTransfer accepts one player transferred to listener, as another
+// frontend would.
+func acceptTransfer(t *testing.T, listener net.Listener, accepted chan<- *Player) {
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Errorf("Accept: %v", err)
+		accepted <- nil
+		return
+	}
+
+	name, entityId, codec, data, err := ReadTransfer(conn)
+	if err != nil {
+		t.Errorf("ReadTransfer: %v", err)
+		accepted <- nil
+		return
+	}
+
+	player := NewPlayer(entityId, conn, codec, name, "world", BlockXyz{0, 64, 0}, nil, nil)
+	if err = player.ReadTransferNbt(data); err != nil {
+		AckTransfer(conn, err)
+		t.Errorf("ReadTransferNbt: %v", err)
+		accepted <- nil
+		return
+	}
+
+	if err = AckTransfer(conn, nil); err != nil {
+		t.Errorf("AckTransfer: %v", err)
+	}
+	accepted <- player
+}
+
+func TestPlayerTransfer(t *testing.T) {
+	TransferSecret = "secret"
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer listener.Close()
+
+	accepted := make(chan *Player, 1)
+	go acceptTransfer(t, listener, accepted)
+
+	client, serverConn := net.Pipe()
+	defer client.Close()
+
+	// Transferred players keep the protocol version that they logged in with.
+	codec, _ := proto.CodecForVersion(proto.ProtocolVersionBeta18)
+	disconnects := make(chan EntityId, 1)
+	oldPlayer := NewPlayer(7, serverConn, codec, "alice", "world", BlockXyz{0, 64, 0}, disconnects, nil)
+	oldPlayer.position = AbsXyz{10, 70, 20}
+	oldPlayer.health = 15
+	oldPlayer.cursor = gamerules.Slot{ItemTypeId: 3, Count: 5, Data: 0}
+	oldPlayer.nextWindowId = 4
+	err = oldPlayer.inventory.ReadNbt(&nbt.List{nbt.TagCompound, []nbt.ITag{
+		&nbt.Compound{map[string]nbt.ITag{
+			"Slot":   &nbt.Byte{2},
+			"id":     &nbt.Short{276},
+			"Count":  &nbt.Byte{1},
+			"Damage": &nbt.Short{9},
+		}},
+	}})
+	if err != nil {
+		t.Fatalf("Inventory ReadNbt: %v", err)
+	}
+	oldPlayer.inventory.SetHolding(2)
+	// The client still shows the items in the crafting grid after the transfer.
+	err = oldPlayer.inventory.ReadCraftingNbt(&nbt.List{nbt.TagCompound, []nbt.ITag{
+		&nbt.Compound{map[string]nbt.ITag{
+			"Slot":   &nbt.Byte{3},
+			"id":     &nbt.Short{5},
+			"Count":  &nbt.Byte{2},
+			"Damage": &nbt.Short{0},
+		}},
+	}})
+	if err != nil {
+		t.Fatalf("Inventory ReadCraftingNbt: %v", err)
+	}
+
+	go oldPlayer.receiveLoop()
+	go oldPlayer.transmitLoop()
+	go oldPlayer.runQueue()
+
+	// The transfer happens after the next packet from the client.
+	req := &transferRequest{listener.Addr().String(), make(chan os.Error, 1)}
+	oldPlayer.transfers <- req
+	if err = codec.WriteKeepAlive(client, 1); err != nil {
+		t.Fatalf("WriteKeepAlive: %v", err)
+	}
+	if err = <-req.result; err != nil {
+		t.Fatalf("Transfer: %v", err)
+	}
+
+	newPlayer := <-accepted
+	if newPlayer == nil {
+		t.FailNow()
+	}
+
+	if entityId := <-disconnects; entityId != 7 {
+		t.Errorf("Expected disconnect of entity 7, got %d", entityId)
+	}
+	if !oldPlayer.Transferred() {
+		t.Errorf("Expected old player to be marked as transferred")
+	}
+
+	if newPlayer.name != "alice" || newPlayer.EntityId != 7 || !newPlayer.transferredIn {
+		t.Errorf("Expected transferred player alice with entity 7, got %q with %d", newPlayer.name, newPlayer.EntityId)
+	}
+	if newPlayer.codec != codec {
+		t.Errorf("Expected protocol version %d, got %d", codec.Version, newPlayer.codec.Version)
+	}
+	if newPlayer.position.X != 10 || newPlayer.position.Y != 70 || newPlayer.position.Z != 20 || newPlayer.health != 15 {
+		t.Errorf("Expected player at (10, 70, 20) with health 15, got %v with %d", newPlayer.position, newPlayer.health)
+	}
+	if !newPlayer.cursor.Equals(&oldPlayer.cursor) {
+		t.Errorf("Expected cursor %v, got %v", oldPlayer.cursor, newPlayer.cursor)
+	}
+	if held, slotId := newPlayer.inventory.HeldItem(); slotId != 2 || held.ItemTypeId != 276 || held.Count != 1 || held.Data != 9 {
+		t.Errorf("Expected to hold item 276 in slot 2, got %v in %d", held, slotId)
+	}
+	crafting, _ := newPlayer.inventory.WriteCraftingNbt().(*nbt.List)
+	if crafting == nil || len(crafting.Value) != 1 {
+		t.Errorf("Expected one stack in the crafting grid, got %v", crafting)
+	} else {
+		var item gamerules.Slot
+		slotId, _ := crafting.Value[0].Lookup("Slot").(*nbt.Byte)
+		if err = item.ReadNbt(crafting.Value[0]); err != nil || slotId == nil || slotId.Value != 3 || item.ItemTypeId != 5 || item.Count != 2 {
+			t.Errorf("Expected 2 of item 5 in crafting slot 3, got %v in %v (%v)", item, slotId, err)
+		}
+	}
+	if newPlayer.nextWindowId != 4 {
+		t.Errorf("Expected next window ID 4, got %d", newPlayer.nextWindowId)
+	}
+
+	// Data is now forwarded in both directions.
+	newConn := newPlayer.conn
+	defer newConn.Close()
+	if _, err = client.Write([]byte("to server")); err != nil {
+		t.Fatalf("Write to server: %v", err)
+	}
+	received := make([]byte, len("to server"))
+	if _, err = io.ReadFull(newConn, received); err != nil || !bytes.Equal(received, []byte("to server")) {
+		t.Errorf("Expected %q at new frontend, got %q (%v)", "to server", received, err)
+	}
+
+	if _, err = newConn.Write([]byte("to client")); err != nil {
+		t.Fatalf("Write to client: %v", err)
+	}
+	received = make([]byte, len("to client"))
+	if _, err = io.ReadFull(client, received); err != nil || !bytes.Equal(received, []byte("to client")) {
+		t.Errorf("Expected %q at client, got %q (%v)", "to client", received, err)
+	}
+}
+
+func TestPlayerTransferWaitsForInteracts(t *testing.T) {
+	TransferSecret = "secret"
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer listener.Close()
+
+	client, serverConn := net.Pipe()
+	defer client.Close()
+
+	codec, _ := proto.CodecForVersion(proto.ProtocolVersionBeta18)
+	disconnects := make(chan EntityId, 1)
+	player := NewPlayer(7, serverConn, codec, "alice", "world", BlockXyz{0, 64, 0}, disconnects, nil)
+	player.pendingInteracts = 1
+	go player.runQueue()
+
+	// The shard might yet ask for the held item to be placed, so the transfer
+	// waits.
+	req := &transferRequest{listener.Addr().String(), make(chan os.Error, 1)}
+	player.transfers <- req
+	if player.checkTransfer() {
+		t.Fatalf("Expected transfer to wait for the interaction")
+	}
+	select {
+	case err = <-req.result:
+		t.Fatalf("Expected transfer to be retried, got result %v", err)
+	default:
+	}
+
+	accepted := make(chan *Player, 1)
+	go acceptTransfer(t, listener, accepted)
+
+	// Once the shard has carried it out, the transfer goes ahead. The
+	// forwarding that follows is not tested here.
+	shardReply := &playerClient{}
+	shardReply.Init(player)
+	shardReply.InteractDone()
+
+	go player.checkTransfer()
+	if err = <-req.result; err != nil {
+		t.Fatalf("Transfer: %v", err)
+	}
+	if newPlayer := <-accepted; newPlayer != nil {
+		newPlayer.conn.Close()
+	}
+}
+
+func TestReadTransferSecret(t *testing.T) {
+	TransferSecret = "secret"
+
+	tests := []struct {
+		secret  nbt.ITag
+		wantErr bool
+	}{
+		{&nbt.String{"secret"}, false},
+		{&nbt.String{"guess"}, true},
+		{&nbt.String{""}, true},
+		{nil, true},
+	}
+
+	for _, test := range tests {
+		tags := map[string]nbt.ITag{
+			"Name":            &nbt.String{"alice"},
+			"EntityId":        &nbt.Int{7},
+			"ProtocolVersion": &nbt.Int{proto.ProtocolVersionBeta18},
+		}
+		if test.secret != nil {
+			tags["Secret"] = test.secret
+		}
+
+		sender, receiver := net.Pipe()
+		go func() {
+			writeTransferMsg(sender, &nbt.Compound{tags})
+			sender.Close()
+		}()
+		_, _, _, _, err := ReadTransfer(receiver)
+		receiver.Close()
+
+		if (err != nil) != test.wantErr {
+			t.Errorf("Secret %v: expected error %t, got %v", test.secret, test.wantErr, err)
+		}
+	}
+}
+
+func TestReadTransferLimits(t *testing.T) {
+	TransferSecret = "secret"
+	oldTimeout := TransferTimeout
+	TransferTimeout = NanosecondsInSecond / 10
+	defer func() {
+		TransferTimeout = oldTimeout
+	}()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer listener.Close()
+
+	tests := []struct {
+		desc string
+		send func(conn net.Conn)
+	}{
+		{"oversized message", func(conn net.Conn) {
+			binary.Write(conn, binary.BigEndian, int32(TransferMaxSize+1))
+		}},
+		{"negative size", func(conn net.Conn) {
+			binary.Write(conn, binary.BigEndian, int32(-1))
+		}},
+		{"stalled sender", func(conn net.Conn) {
+			binary.Write(conn, binary.BigEndian, int32(100))
+		}},
+	}
+
+	for _, test := range tests {
+		sender, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		receiver, err := listener.Accept()
+		if err != nil {
+			t.Fatalf("Accept: %v", err)
+		}
+
+		// The sender keeps its connection open, so only the limits end the read.
+		test.send(sender)
+		result := make(chan os.Error, 1)
+		go func() {
+			_, _, _, _, err := ReadTransfer(receiver)
+			result <- err
+		}()
+
+		select {
+		case err = <-result:
+			if err == nil {
+				t.Errorf("%s: expected an error", test.desc)
+			}
+		case <-time.After(5 * NanosecondsInSecond):
+			t.Errorf("%s: ReadTransfer did not return", test.desc)
+		}
+
+		sender.Close()
+		receiver.Close()
+	}
+}
@@ -0,0 +1,192 @@
+package player
+
+import (
+	"sync"
+
+	"chunkymonkey/proto"
+)
+
+// Limits on the bytes waiting to be sent to each client.
+var (
+	// TxQueueDropBytes is how much may be queued for a client before updates
+	// to the movement of entities are dropped rather than queued. The client
+	// is sent the full positions of the entities it sees once it has room for
+	// them again.
+	TxQueueDropBytes = 1 << 20
+
+	// TxQueueMaxBytes is how much may be queued for a client before it is
+	// disconnected for having fallen too far behind. A client that has just
+	// logged in is sent several megabytes of chunks at once.
+	TxQueueMaxBytes = 16 << 20
+)
+
+// txQueue holds the packets waiting to be sent to a client. Adding to it never
+// blocks, so that a slow client can't hold up the shards and other players
+// sending it packets.
+//
+// High priority packets are sent first, then the rest in the order that they
+// were queued, so that an entity's movement never reaches the client before
+// the entity is spawned, or after it is destroyed.
+type txQueue struct {
+	lock  sync.Mutex
+	ready *sync.Cond // Signalled when packets are queued, or the queue closed.
+
+	high    [][]byte
+	ordered []txPacket
+
+	bytes      int // Total length of the queued packets.
+	movesBytes int // Total length of the entity movement in ordered.
+	closed     bool
+
+	// Set when entity movement is dropped, until movement is next queued.
+	movesDropped bool
+	// Set when entity movement is queued after some was dropped, until
+	// TakeMovesDropped is called.
+	movesResumed bool
+}
+
+// txPacket is a packet waiting to be sent after the high priority packets.
+type txPacket struct {
+	packet []byte
+	move   bool // Whether the packet is entity movement, which may be dropped.
+}
+
+func (q *txQueue) Init() {
+	q.ready = sync.NewCond(&q.lock)
+}
+
+// Push queues packet to be sent with the given priority. It returns false if
+// the client has fallen too far behind, in which case the queue is discarded
+// and closed. Packets pushed after the queue is closed are dropped.
+func (q *txQueue) Push(packet []byte, priority proto.PacketPriority) (ok bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.closed {
+		return true
+	}
+
+	move := priority == proto.PacketPriorityEntityMove
+	if q.bytes+len(packet) > TxQueueDropBytes {
+		if move {
+			q.movesDropped = true
+			return true
+		}
+		q.dropEntityMoves()
+	}
+
+	if q.bytes+len(packet) > TxQueueMaxBytes {
+		q.discard()
+		return false
+	}
+
+	if priority == proto.PacketPriorityHigh {
+		q.high = append(q.high, packet)
+	} else {
+		q.ordered = append(q.ordered, txPacket{packet, move})
+	}
+	if move {
+		q.movesBytes += len(packet)
+		if q.movesDropped {
+			q.movesDropped = false
+			q.movesResumed = true
+		}
+	}
+	q.bytes += len(packet)
+	q.ready.Signal()
+
+	return true
+}
+
+// TakeMovesDropped returns true once if entity movement has been dropped, and
+// the queue has since had room for more. The client is then to be sent the
+// full positions of the entities that it sees, as it no longer has them.
+func (q *txQueue) TakeMovesDropped() (dropped bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	dropped = q.movesResumed
+	q.movesResumed = false
+	return
+}
+
+// Pop waits for a packet to be queued and removes it from the queue. It
+// returns false once the queue is closed and everything queued before has
+// been removed.
+func (q *txQueue) Pop() (packet []byte, ok bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for len(q.high) == 0 && len(q.ordered) == 0 {
+		if q.closed {
+			return nil, false
+		}
+		q.ready.Wait()
+	}
+
+	if len(q.high) > 0 {
+		packet = q.high[0]
+		q.high[0] = nil
+		q.high = q.high[1:]
+	} else {
+		next := q.ordered[0]
+		q.ordered[0] = txPacket{}
+		q.ordered = q.ordered[1:]
+		packet = next.packet
+		if next.move {
+			q.movesBytes -= len(packet)
+		}
+	}
+	q.bytes -= len(packet)
+
+	return packet, true
+}
+
+// Close stops further packets being queued. Those already queued are still
+// removed by Pop.
+func (q *txQueue) Close() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.closed = true
+	q.ready.Broadcast()
+}
+
+// Discard drops the queued packets and closes the queue.
+func (q *txQueue) Discard() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.discard()
+}
+
+func (q *txQueue) discard() {
+	q.high = nil
+	q.ordered = nil
+	q.bytes = 0
+	q.movesBytes = 0
+	q.closed = true
+	q.ready.Broadcast()
+}
+
+// dropEntityMoves removes the queued packets that move entities.
+func (q *txQueue) dropEntityMoves() {
+	if q.movesBytes == 0 {
+		return
+	}
+
+	ordered := q.ordered[:0]
+	for _, p := range q.ordered {
+		if !p.move {
+			ordered = append(ordered, p)
+		}
+	}
+	for i := len(ordered); i < len(q.ordered); i++ {
+		q.ordered[i] = txPacket{}
+	}
+	q.ordered = ordered
+
+	q.bytes -= q.movesBytes
+	q.movesBytes = 0
+	q.movesDropped = true
+}
@@ -0,0 +1,104 @@
+package player
+
+import (
+	"bytes"
+	"testing"
+
+	"chunkymonkey/proto"
+	. "chunkymonkey/types"
+)
+
+func testTxPackets() (chunk, chat, move []byte) {
+	buf := new(bytes.Buffer)
+	proto.WritePreChunk(buf, &ChunkXz{0, 0}, ChunkInit)
+	chunk = buf.Bytes()
+
+	buf = new(bytes.Buffer)
+	proto.WriteChatMessage(buf, "hello")
+	chat = buf.Bytes()
+
+	buf = new(bytes.Buffer)
+	proto.WriteEntityRelMove(buf, 2, &RelMove{1, 0, 1})
+	move = buf.Bytes()
+
+	return
+}
+
+func popAll(q *txQueue) (packets [][]byte) {
+	q.Close()
+	for {
+		packet, ok := q.Pop()
+		if !ok {
+			return
+		}
+		packets = append(packets, packet)
+	}
+	return
+}
+
+func TestTxQueuePriority(t *testing.T) {
+	chunk, chat, move := testTxPackets()
+
+	var q txQueue
+	q.Init()
+	q.Push(chunk, proto.PacketPriorityNormal)
+	q.Push(move, proto.PacketPriorityEntityMove)
+	q.Push(chat, proto.PacketPriorityHigh)
+
+	// Chat goes ahead of the rest. Entity movement keeps its place behind the
+	// chunk, as it might also be behind the entity's spawn.
+	expected := [][]byte{chat, chunk, move}
+	packets := popAll(&q)
+	if len(packets) != len(expected) {
+		t.Fatalf("Expected %d packets, got %d", len(expected), len(packets))
+	}
+	for i := range expected {
+		if !bytes.Equal(expected[i], packets[i]) {
+			t.Errorf("Packet %d: expected %x, got %x", i, expected[i], packets[i])
+		}
+	}
+}
+
+func TestTxQueueBehind(t *testing.T) {
+	chunk, chat, move := testTxPackets()
+
+	defer func(dropBytes, maxBytes int) {
+		TxQueueDropBytes, TxQueueMaxBytes = dropBytes, maxBytes
+	}(TxQueueDropBytes, TxQueueMaxBytes)
+	TxQueueDropBytes = 2*len(move) + len(chunk)
+	TxQueueMaxBytes = 2*len(chunk) + len(move)
+
+	var q txQueue
+	q.Init()
+	q.Push(move, proto.PacketPriorityEntityMove)
+	q.Push(chunk, proto.PacketPriorityNormal)
+	q.Push(move, proto.PacketPriorityEntityMove)
+
+	// The client has fallen behind, so entity movement is dropped to make room
+	// for the chunk.
+	if !q.Push(chunk, proto.PacketPriorityNormal) {
+		t.Fatalf("Expected chunk to be queued")
+	}
+	if q.Push(move, proto.PacketPriorityEntityMove); q.bytes != 2*len(chunk) {
+		t.Errorf("Expected only chunks to be queued, got %d bytes", q.bytes)
+	}
+	if q.TakeMovesDropped() {
+		t.Errorf("Expected no full positions to be sent while there is no room for them")
+	}
+
+	// Once movement can be queued again, the full positions are sent, once.
+	q.Pop()
+	q.Push(move, proto.PacketPriorityEntityMove)
+	if !q.TakeMovesDropped() || q.TakeMovesDropped() {
+		t.Errorf("Expected full positions to be sent once after movement was dropped")
+	}
+	q.Push(chunk, proto.PacketPriorityNormal)
+
+	// Too far behind.
+	if q.Push(chat, proto.PacketPriorityHigh) || !q.closed || q.bytes != 0 {
+		t.Errorf("Expected queue to be discarded")
+	}
+	if packets := popAll(&q); len(packets) != 0 {
+		t.Errorf("Expected no packets after discarding, got %d", len(packets))
+	}
+}
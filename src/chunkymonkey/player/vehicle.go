@@ -0,0 +1,79 @@
+package player
+
+import (
+	"bytes"
+
+	"chunkymonkey/proto"
+	. "chunkymonkey/types"
+)
+
+// Clients riding a vehicle send their motion in place of their position, with
+// this as the Y coordinate and stance.
+const riderPositionY = -999
+
+// useEntity asks the chunks around the player to use the target entity. The
+// player doesn't know which chunk the entity is in, but it must be nearby.
+// Players riding a vehicle get off it by using anything.
+func (player *Player) useEntity(target EntityId, leftClick bool) {
+	if player.vehicle != NoEntityId && !leftClick {
+		target = player.vehicle
+	}
+
+	if leftClick {
+		player.addExhaustion(exhaustionAttack)
+	}
+
+	centre := player.position.ToChunkXz()
+	for x := centre.X - 1; x <= centre.X+1; x++ {
+		for z := centre.Z - 1; z <= centre.Z+1; z++ {
+			chunkLoc := ChunkXz{x, z}
+			if shardClient, ok := player.chunkSubs.ShardClientForChunkXz(&chunkLoc); ok {
+				shardClient.ReqUseEntity(chunkLoc, player.position, target, leftClick)
+			}
+		}
+	}
+}
+
+// mount puts the player into the vehicle.
+func (player *Player) mount(vehicleId EntityId) {
+	player.vehicle = vehicleId
+
+	buf := new(bytes.Buffer)
+	proto.WriteEntityAttach(buf, player.EntityId, vehicleId)
+	player.multicastPacket(buf.Bytes(), proto.PacketPriorityNormal, true)
+}
+
+// dismount takes the player out of the vehicle, if they are in it.
+func (player *Player) dismount(vehicleId EntityId) {
+	if player.vehicle != vehicleId {
+		return
+	}
+
+	player.vehicle = NoEntityId
+
+	buf := new(bytes.Buffer)
+	proto.WriteEntityAttach(buf, player.EntityId, NoEntityId)
+	player.multicastPacket(buf.Bytes(), proto.PacketPriorityNormal, true)
+
+	// Leave the player where the vehicle took them.
+	player.setPositionLook(player.position, player.look)
+}
+
+// steerVehicle passes the input of the player to the vehicle that they are
+// riding, which is in the same chunk as them.
+func (player *Player) steerVehicle(input *AbsVelocity) {
+	chunkLoc := player.position.ToChunkXz()
+	if shardClient, ok := player.chunkSubs.ShardClientForChunkXz(&chunkLoc); ok {
+		shardClient.ReqSteerVehicle(chunkLoc, player.vehicle, *input)
+	}
+}
+
+// vehicleMoved moves the player along with the vehicle that they are riding.
+func (player *Player) vehicleMoved(vehicleId EntityId, position *AbsXyz) {
+	if player.vehicle != vehicleId {
+		return
+	}
+
+	player.position = *position
+	player.chunkSubs.Move(&player.position)
+}
@@ -0,0 +1,119 @@
+package player
+
+import (
+	"bytes"
+	"sync"
+
+	"chunkymonkey/proto"
+	. "chunkymonkey/types"
+)
+
+// visibleEntities tracks the entities shown to the client, and the chunks
+// showing each of them. An entity is spawned on the client when the first
+// chunk shows it, and destroyed when the last stops. So an entity moving
+// between chunks that the player views stays spawned, and one that moves out
+// of view, or is in a chunk that the player stops viewing, doesn't linger on
+// the client.
+//
+// Chunks show and hide entities from their shards' goroutines, so it has a
+// lock. This is held while the resulting packets are queued, so that they
+// reach the client in the order that the changes were made.
+type visibleEntities struct {
+	player *Player
+
+	lock     sync.Mutex
+	viewing  map[uint64]bool              // Chunks the player views, by ChunkKey.
+	entities map[EntityId]map[uint64]bool // Chunks showing each entity.
+}
+
+func (v *visibleEntities) Init(player *Player) {
+	v.player = player
+	v.viewing = make(map[uint64]bool)
+	v.entities = make(map[EntityId]map[uint64]bool)
+}
+
+// ViewChunk starts accepting the entities shown from the chunk. It is called
+// before the chunk is asked to show them.
+func (v *visibleEntities) ViewChunk(chunkLoc ChunkXz) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.viewing[chunkLoc.ChunkKey()] = true
+}
+
+// UnviewChunk hides all of the entities shown from the chunk.
+func (v *visibleEntities) UnviewChunk(chunkLoc ChunkXz) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	key := chunkLoc.ChunkKey()
+	v.viewing[key] = false, false
+
+	buf := new(bytes.Buffer)
+	for entityId, chunks := range v.entities {
+		if chunks[key] {
+			v.hide(buf, key, entityId)
+		}
+	}
+	if buf.Len() > 0 {
+		v.player.TransmitPacket(buf.Bytes(), proto.PacketPriorityNormal)
+	}
+}
+
+// Show shows the entity from the chunk, spawning it on the client if no other
+// chunk shows it already. Entities shown from chunks that the player doesn't
+// view are ignored.
+func (v *visibleEntities) Show(chunkLoc ChunkXz, entityId EntityId, spawnPacket []byte) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	key := chunkLoc.ChunkKey()
+	if !v.viewing[key] {
+		return
+	}
+
+	chunks, ok := v.entities[entityId]
+	if !ok {
+		chunks = make(map[uint64]bool)
+		v.entities[entityId] = chunks
+		v.player.TransmitPacket(spawnPacket, proto.PacketPriorityNormal)
+	}
+	chunks[key] = true
+}
+
+// Hide stops showing the entity from the chunk, destroying it on the client if
+// no other chunk shows it.
+func (v *visibleEntities) Hide(chunkLoc ChunkXz, entityId EntityId) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	buf := new(bytes.Buffer)
+	v.hide(buf, chunkLoc.ChunkKey(), entityId)
+	if buf.Len() > 0 {
+		v.player.TransmitPacket(buf.Bytes(), proto.PacketPriorityNormal)
+	}
+}
+
+func (v *visibleEntities) hide(buf *bytes.Buffer, key uint64, entityId EntityId) {
+	chunks, ok := v.entities[entityId]
+	if !ok || !chunks[key] {
+		return
+	}
+
+	chunks[key] = false, false
+	if len(chunks) == 0 {
+		v.entities[entityId] = nil, false
+		proto.WriteEntityDestroy(buf, entityId)
+	}
+}
+
+// Reset forgets all of the entities shown, without destroying them on the
+// client. It is used when the client discards them itself, on changing
+// dimension.
+func (v *visibleEntities) Reset() {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.viewing = make(map[uint64]bool)
+	v.entities = make(map[EntityId]map[uint64]bool)
+}
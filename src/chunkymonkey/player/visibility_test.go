@@ -0,0 +1,56 @@
+package player
+
+import (
+	"bytes"
+	"testing"
+
+	"chunkymonkey/proto"
+	. "chunkymonkey/types"
+)
+
+// queuedPackets returns the packets queued for the client, concatenated.
+func queuedPackets(player *Player) []byte {
+	packets := popAll(&player.txQueue)
+	player.txQueue = txQueue{}
+	player.txQueue.Init()
+	return bytes.Join(packets, nil)
+}
+
+func TestVisibleEntities(t *testing.T) {
+	codec, _ := proto.CodecForVersion(proto.ProtocolVersionBeta18)
+	player := NewPlayer(1, nil, codec, "alice", "world", BlockXyz{0, 64, 0}, nil, nil)
+
+	spawn := []byte("spawn")
+	destroy := new(bytes.Buffer)
+	proto.WriteEntityDestroy(destroy, 2)
+
+	a, b, c := ChunkXz{0, 0}, ChunkXz{1, 0}, ChunkXz{2, 0}
+	player.visible.ViewChunk(a)
+	player.visible.ViewChunk(b)
+
+	// Chunks that the player doesn't view can't show entities.
+	player.ShowEntity(c, 2, spawn)
+	if packets := queuedPackets(player); len(packets) != 0 {
+		t.Errorf("Expected entity from unviewed chunk to be ignored, got %x", packets)
+	}
+
+	// The entity moves from one chunk to another, and is spawned only once.
+	player.ShowEntity(a, 2, spawn)
+	player.ShowEntity(b, 2, spawn)
+	player.HideEntity(a, 2)
+	if packets := queuedPackets(player); !bytes.Equal(packets, spawn) {
+		t.Errorf("Expected entity to be spawned once, got %x", packets)
+	}
+
+	// The player stops viewing the chunk that the entity is in.
+	player.visible.UnviewChunk(b)
+	if packets := queuedPackets(player); !bytes.Equal(packets, destroy.Bytes()) {
+		t.Errorf("Expected entity to be destroyed, got %x", packets)
+	}
+
+	// A late hide from the chunk has no effect.
+	player.HideEntity(b, 2)
+	if packets := queuedPackets(player); len(packets) != 0 {
+		t.Errorf("Expected no packets, got %x", packets)
+	}
+}
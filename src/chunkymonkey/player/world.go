@@ -0,0 +1,189 @@
+package player
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"chunkymonkey/nbtutil"
+	"chunkymonkey/proto"
+	. "chunkymonkey/types"
+	"nbt"
+)
+
+// worldPosition is where a player was in a world that they have left, so that
+// they return there.
+type worldPosition struct {
+	dimension int32
+	position  AbsXyz
+	look      LookDegrees
+	bedSpawn  *BlockXyz
+}
+
+// readBedSpawnNbt returns the bed spawn point in the tag, or nil if there is
+// none.
+func readBedSpawnNbt(tag nbt.ITag) *BlockXyz {
+	spawnX, xok := tag.Lookup("SpawnX").(*nbt.Int)
+	spawnY, yok := tag.Lookup("SpawnY").(*nbt.Int)
+	spawnZ, zok := tag.Lookup("SpawnZ").(*nbt.Int)
+	if !xok || !yok || !zok {
+		return nil
+	}
+
+	return &BlockXyz{
+		BlockCoord(spawnX.Value),
+		BlockYCoord(spawnY.Value),
+		BlockCoord(spawnZ.Value),
+	}
+}
+
+// writeBedSpawnNbt adds the bed spawn point, if any, to the tag.
+func writeBedSpawnNbt(tag *nbt.Compound, bedSpawn *BlockXyz) {
+	if bedSpawn != nil {
+		tag.Tags["SpawnX"] = &nbt.Int{int32(bedSpawn.X)}
+		tag.Tags["SpawnY"] = &nbt.Int{int32(bedSpawn.Y)}
+		tag.Tags["SpawnZ"] = &nbt.Int{int32(bedSpawn.Z)}
+	}
+}
+
+// readWorldsNbt reads where the player was in each of the other worlds that
+// they have been in.
+func (player *Player) readWorldsNbt(worlds *nbt.Compound) (err os.Error) {
+	for world, tag := range worlds.Tags {
+		pos := new(worldPosition)
+
+		if pos.dimension, err = nbtutil.ReadInt(tag, "Dimension"); err != nil {
+			return
+		}
+		if pos.position, err = nbtutil.ReadAbsXyz(tag, "Pos"); err != nil {
+			return
+		}
+		if pos.look, err = nbtutil.ReadLookDegrees(tag, "Rotation"); err != nil {
+			return
+		}
+		pos.bedSpawn = readBedSpawnNbt(tag)
+
+		player.otherWorlds[world] = pos
+	}
+
+	return
+}
+
+// writeWorldsNbt returns where the player was in each of the other worlds that
+// they have been in.
+func (player *Player) writeWorldsNbt() *nbt.Compound {
+	worlds := &nbt.Compound{make(map[string]nbt.ITag)}
+
+	for world, pos := range player.otherWorlds {
+		tag := &nbt.Compound{
+			map[string]nbt.ITag{
+				"Dimension": &nbt.Int{pos.dimension},
+				"Pos":       nbtutil.WriteAbsXyz(&pos.position),
+				"Rotation":  nbtutil.WriteLookDegrees(&pos.look),
+			},
+		}
+		writeBedSpawnNbt(tag, pos.bedSpawn)
+		worlds.Tags[world] = tag
+	}
+
+	return worlds
+}
+
+// SetWorld sets the world that the player logs in to, its spawn point, and
+// the game mode that it is played in (by clients that have game modes). If it isn't the world that the player
+// was last in, they start where they last were in it, or at its spawn point.
+// It must only be called before Player.Start().
+func (player *Player) SetWorld(world string, spawnBlock BlockXyz, gameMode GameMode) {
+	if world != player.world {
+		player.leaveWorld(world, spawnBlock)
+	} else {
+		player.spawnBlock = spawnBlock
+	}
+	player.gameMode = player.codec.GameMode(gameMode)
+}
+
+// ChangeWorld requests that the player move to the named world, whose spawn
+// point and game mode are given.
+func (player *Player) ChangeWorld(world string, spawnBlock BlockXyz, gameMode GameMode) {
+	player.Enqueue(func(_ *Player) {
+		player.changeWorld(world, spawnBlock, gameMode)
+	})
+}
+
+// changeWorld moves the player to another world. They arrive where they last
+// were in it, or at its spawn point if they have not been there before.
+func (player *Player) changeWorld(world string, spawnBlock BlockXyz, gameMode GameMode) {
+	if world == player.world {
+		player.sendMessage(fmt.Sprintf("You are already in world %s", world))
+		return
+	}
+
+	player.wakeUp()
+	player.stopEating()
+	player.gameMode = player.codec.GameMode(gameMode)
+
+	fromDimension := DimensionId(player.dimension)
+	player.leaveWorld(world, spawnBlock)
+
+	dimension := DimensionId(player.dimension)
+	if player.game.ShardConnecter(world, dimension) == nil {
+		dimension = DimensionNormal
+		player.position = *spawnBlock.ToAbsXyz()
+	}
+
+	// The client only discards the world that it has when it respawns in
+	// another dimension, so it first visits the other dimension when arriving
+	// in the same one.
+	if dimension == fromDimension {
+		otherDimension := DimensionNether
+		if dimension == DimensionNether {
+			otherDimension = DimensionNormal
+		}
+		buf := new(bytes.Buffer)
+		player.codec.WriteRespawn(buf, otherDimension, player.gameMode)
+		player.TransmitPacket(buf.Bytes(), proto.PacketPriorityNormal)
+	}
+
+	player.changeDimension(dimension, player.position)
+
+	buf := new(bytes.Buffer)
+	proto.WriteSpawnPosition(buf, &player.spawnBlock)
+	player.TransmitPacket(buf.Bytes(), proto.PacketPriorityNormal)
+
+	player.sendMessage(fmt.Sprintf("Moved to world %s", world))
+}
+
+// sendMessage sends a chat message to the player alone.
+func (player *Player) sendMessage(message string) {
+	buf := new(bytes.Buffer)
+	proto.WriteChatMessage(buf, message)
+	player.TransmitPacket(buf.Bytes(), proto.PacketPriorityHigh)
+}
+
+// leaveWorld records where the player is in their current world, and sets
+// their position in the named world to where they last were in it, or to its
+// spawn point if they have not been there before.
+func (player *Player) leaveWorld(world string, spawnBlock BlockXyz) {
+	player.otherWorlds[player.world] = &worldPosition{
+		dimension: player.dimension,
+		position:  player.position,
+		look:      player.look,
+		bedSpawn:  player.bedSpawn,
+	}
+
+	if pos, ok := player.otherWorlds[world]; ok {
+		player.dimension = pos.dimension
+		player.position = pos.position
+		player.look = pos.look
+		player.bedSpawn = pos.bedSpawn
+		player.otherWorlds[world] = nil, false
+	} else {
+		player.dimension = int32(DimensionNormal)
+		player.position = *spawnBlock.ToAbsXyz()
+		player.look = LookDegrees{0, 0}
+		player.bedSpawn = nil
+	}
+
+	player.world = world
+	player.spawnBlock = spawnBlock
+}
@@ -0,0 +1,45 @@
+package player
+
+import (
+	"testing"
+
+	. "chunkymonkey/types"
+)
+
+func TestPlayerWorlds(t *testing.T) {
+	player := NewPlayer(1, nil, nil, "alice", "world", BlockXyz{0, 64, 0}, nil, nil)
+	player.dimension = int32(DimensionNether)
+	player.position = AbsXyz{10, 70, 20}
+	player.bedSpawn = &BlockXyz{1, 2, 3}
+
+	// The player arrives at the spawn of a world that they haven't been in.
+	player.leaveWorld("creative", BlockXyz{100, 80, 100})
+	if player.world != "creative" || player.Dimension() != DimensionNormal || player.bedSpawn != nil {
+		t.Errorf("Expected player in normal dimension of creative without bed, got %q %d %v", player.world, player.dimension, player.bedSpawn)
+	}
+	if player.position.X != 100 || player.position.Y != 80 || player.position.Z != 100 {
+		t.Errorf("Expected player at creative spawn, got %v", player.position)
+	}
+
+	// Where they were in the first world survives being written and read.
+	data := player.writeWorldsNbt()
+	player.otherWorlds = make(map[string]*worldPosition)
+	if err := player.readWorldsNbt(data); err != nil {
+		t.Fatalf("readWorldsNbt: %v", err)
+	}
+
+	// The player returns to where they were in the first world.
+	player.leaveWorld("world", BlockXyz{0, 64, 0})
+	if player.world != "world" || player.Dimension() != DimensionNether {
+		t.Errorf("Expected player in nether of world, got %q %d", player.world, player.dimension)
+	}
+	if player.position.X != 10 || player.position.Y != 70 || player.position.Z != 20 {
+		t.Errorf("Expected player back at (10, 70, 20), got %v", player.position)
+	}
+	if player.bedSpawn == nil || player.bedSpawn.X != 1 || player.bedSpawn.Y != 2 || player.bedSpawn.Z != 3 {
+		t.Errorf("Expected bed spawn at (1, 2, 3), got %v", player.bedSpawn)
+	}
+	if _, ok := player.otherWorlds["creative"]; !ok || len(player.otherWorlds) != 1 {
+		t.Errorf("Expected only creative in other worlds, got %v", player.otherWorlds)
+	}
+}
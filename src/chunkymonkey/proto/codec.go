@@ -0,0 +1,185 @@
+package proto
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	. "chunkymonkey/types"
+)
+
+// Protocol versions that can be spoken to clients.
+const (
+	ProtocolVersionBeta17 = 14
+	ProtocolVersionBeta18 = 17
+)
+
+// Codec reads and writes the packets of one version of the protocol. A server
+// selects the codec for a connection by the version that the client sends in
+// its login (see ServerReadLogin). Packets that are the same in every version
+// are read and written by the functions of this package instead.
+type Codec struct {
+	Version int32
+
+	// Set for versions without game modes, whose clients always play in
+	// survival mode.
+	survivalOnly bool
+
+	commonReadFns commonPacketReaderMap
+	serverReadFns serverPacketReaderMap
+	clientReadFns clientPacketReaderMap
+
+	serverReadLoginBody func(reader io.Reader) (username string, err os.Error)
+	serverWriteLogin    func(writer io.Writer, entityId EntityId, mapSeed RandomSeed, gameMode GameMode, dimension DimensionId) os.Error
+	clientWriteLogin    func(writer io.Writer, version int32, username string) os.Error
+	writeKeepAlive      func(writer io.Writer, id int32) os.Error
+	writeUpdateHealth   func(writer io.Writer, health Health, food int16, foodSaturation float32) os.Error
+	writeRespawn        func(writer io.Writer, dimension DimensionId, gameMode GameMode) os.Error
+	writeBedInvalid     func(writer io.Writer, reason byte) os.Error
+	writeExperience     func(writer io.Writer, experience, level int8, totalExperience int16) os.Error
+	writePlayerListItem func(writer io.Writer, name string, online bool, ping int16) os.Error
+}
+
+var codecBeta17 = &Codec{
+	Version:      ProtocolVersionBeta17,
+	survivalOnly: true,
+
+	commonReadFns: commonReadFns,
+	serverReadFns: serverReadFns,
+	clientReadFns: clientReadFns,
+
+	serverReadLoginBody: func(reader io.Reader) (username string, err os.Error) {
+		username, _, _, err = readLoginBody(reader)
+		return
+	},
+	// The client plays in survival mode whatever gameMode is (see
+	// Codec.GameMode).
+	serverWriteLogin: func(writer io.Writer, entityId EntityId, mapSeed RandomSeed, gameMode GameMode, dimension DimensionId) os.Error {
+		return writeLogin(writer, int32(entityId), "", mapSeed, dimension)
+	},
+	clientWriteLogin: func(writer io.Writer, version int32, username string) os.Error {
+		return writeLogin(writer, version, username, 0, 0)
+	},
+	writeKeepAlive: func(writer io.Writer, id int32) os.Error {
+		return writeKeepAlive(writer)
+	},
+	writeUpdateHealth: func(writer io.Writer, health Health, food int16, foodSaturation float32) os.Error {
+		return writeUpdateHealth(writer, health)
+	},
+	writeRespawn: func(writer io.Writer, dimension DimensionId, gameMode GameMode) os.Error {
+		return writeRespawn(writer, dimension)
+	},
+	writeBedInvalid: writeBedInvalid,
+
+	// Experience and the player list are not in this version.
+	writeExperience: func(writer io.Writer, experience, level int8, totalExperience int16) os.Error {
+		return nil
+	},
+	writePlayerListItem: func(writer io.Writer, name string, online bool, ping int16) os.Error {
+		return nil
+	},
+}
+
+var codecs = map[int32]*Codec{
+	ProtocolVersionBeta17: codecBeta17,
+	ProtocolVersionBeta18: codecBeta18,
+}
+
+// CodecForVersion returns the codec for the given protocol version, and false
+// if the version is unsupported.
+func CodecForVersion(version int32) (codec *Codec, ok bool) {
+	codec, ok = codecs[version]
+	return
+}
+
+// A server should call this to receive a single packet from a client. It will
+// block until a packet was successfully handled, or there was an error.
+func (codec *Codec) ServerReadPacket(reader io.Reader, handler IServerPacketHandler) os.Error {
+	var packetId byte
+
+	if err := binary.Read(reader, binary.BigEndian, &packetId); err != nil {
+		return err
+	}
+
+	if commonFn, ok := codec.commonReadFns[packetId]; ok {
+		return commonFn(reader, handler)
+	}
+
+	if serverFn, ok := codec.serverReadFns[packetId]; ok {
+		return serverFn(reader, handler)
+	}
+
+	return os.NewError(fmt.Sprintf("unhandled packet type %#x", packetId))
+}
+
+// A client should call this to receive a single packet from a server. It will
+// block until a packet was successfully handled, or there was an error.
+func (codec *Codec) ClientReadPacket(reader io.Reader, handler IClientPacketHandler) os.Error {
+	var packetId byte
+
+	if err := binary.Read(reader, binary.BigEndian, &packetId); err != nil {
+		return err
+	}
+
+	if commonFn, ok := codec.commonReadFns[packetId]; ok {
+		return commonFn(reader, handler)
+	}
+
+	if clientFn, ok := codec.clientReadFns[packetId]; ok {
+		return clientFn(reader, handler)
+	}
+
+	return os.NewError(fmt.Sprintf("unhandled packet type %#x", packetId))
+}
+
+// GameMode returns the mode that the codec's clients play in, in a world whose
+// players play in worldMode.
+func (codec *Codec) GameMode(worldMode GameMode) GameMode {
+	if codec.survivalOnly {
+		return GameModeSurvival
+	}
+	return worldMode
+}
+
+// ServerWriteLogin writes the server's login packet. gameMode is not sent in
+// versions before ProtocolVersionBeta18, whose clients are always in survival
+// mode, so it should be given by GameMode.
+func (codec *Codec) ServerWriteLogin(writer io.Writer, entityId EntityId, mapSeed RandomSeed, gameMode GameMode, dimension DimensionId) os.Error {
+	return codec.serverWriteLogin(writer, entityId, mapSeed, gameMode, dimension)
+}
+
+func (codec *Codec) ClientWriteLogin(writer io.Writer, username string) os.Error {
+	return codec.clientWriteLogin(writer, codec.Version, username)
+}
+
+// WriteKeepAlive writes a keep-alive packet. The client replies with the same
+// id in ProtocolVersionBeta18 and later, which measures the round trip time.
+func (codec *Codec) WriteKeepAlive(writer io.Writer, id int32) os.Error {
+	return codec.writeKeepAlive(writer, id)
+}
+
+func (codec *Codec) WriteUpdateHealth(writer io.Writer, health Health, food int16, foodSaturation float32) os.Error {
+	return codec.writeUpdateHealth(writer, health, food, foodSaturation)
+}
+
+func (codec *Codec) WriteRespawn(writer io.Writer, dimension DimensionId, gameMode GameMode) os.Error {
+	return codec.writeRespawn(writer, dimension, gameMode)
+}
+
+func (codec *Codec) WriteBedInvalid(writer io.Writer, reason byte) os.Error {
+	return codec.writeBedInvalid(writer, reason)
+}
+
+// WriteExperience writes the player's experience. It writes nothing in
+// versions before ProtocolVersionBeta18.
+func (codec *Codec) WriteExperience(writer io.Writer, experience, level int8, totalExperience int16) os.Error {
+	return codec.writeExperience(writer, experience, level, totalExperience)
+}
+
+// WritePlayerListItem adds a player to, updates their ping in, or removes them
+// from the list of players shown by the client. It writes nothing in versions
+// before ProtocolVersionBeta18.
+func (codec *Codec) WritePlayerListItem(writer io.Writer, name string, online bool, ping int16) os.Error {
+	return codec.writePlayerListItem(writer, name, online, ping)
+}
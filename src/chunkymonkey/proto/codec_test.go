@@ -0,0 +1,466 @@
+package proto
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	. "chunkymonkey/types"
+	te "testencoding"
+)
+
+func codecForTest(t *testing.T, version int32) *Codec {
+	codec, ok := CodecForVersion(version)
+	if !ok {
+		t.Fatalf("No codec for protocol version %d", version)
+	}
+	return codec
+}
+
+type codecTestCase struct {
+	name    string
+	version int32
+	result  func(codec *Codec, writer io.Writer) os.Error
+	want    te.IBytesMatcher
+}
+
+func TestCodecWrite(t *testing.T) {
+	const seed = "\x01\x02\x03\x04\x05\x06\x07\x08"
+
+	tests := []codecTestCase{
+		{
+			"keep alive",
+			ProtocolVersionBeta17,
+			func(codec *Codec, writer io.Writer) os.Error {
+				return codec.WriteKeepAlive(writer, 0x1234)
+			},
+			te.LiteralString("\x00"),
+		},
+		{
+			"keep alive",
+			ProtocolVersionBeta18,
+			func(codec *Codec, writer io.Writer) os.Error {
+				return codec.WriteKeepAlive(writer, 0x1234)
+			},
+			te.InOrder(
+				te.LiteralString("\x00"),             // Packet ID
+				te.LiteralString("\x00\x00\x12\x34"), // ID
+			),
+		},
+		{
+			"server login",
+			ProtocolVersionBeta17,
+			func(codec *Codec, writer io.Writer) os.Error {
+				return codec.ServerWriteLogin(writer, 0x1234, 0x0102030405060708, GameModeCreative, DimensionNether)
+			},
+			te.InOrder(
+				te.LiteralString("\x01"),             // Packet ID
+				te.LiteralString("\x00\x00\x12\x34"), // EntityId
+				te.LiteralString("\x00\x00"),         // Unused string
+				te.LiteralString(seed),               // Map seed
+				te.LiteralString("\xff"),             // Dimension
+			),
+		},
+		{
+			"server login",
+			ProtocolVersionBeta18,
+			func(codec *Codec, writer io.Writer) os.Error {
+				return codec.ServerWriteLogin(writer, 0x1234, 0x0102030405060708, GameModeCreative, DimensionNether)
+			},
+			te.InOrder(
+				te.LiteralString("\x01"),             // Packet ID
+				te.LiteralString("\x00\x00\x12\x34"), // EntityId
+				te.LiteralString("\x00\x00"),         // Unused string
+				te.LiteralString(seed),               // Map seed
+				te.LiteralString("\x00\x00\x00\x01"), // Game mode
+				te.LiteralString("\xff"),             // Dimension
+				te.LiteralString("\x01"),             // Difficulty
+				te.LiteralString("\x80"),             // World height
+				te.LiteralString("\x14"),             // Max players
+			),
+		},
+		{
+			"client login",
+			ProtocolVersionBeta17,
+			func(codec *Codec, writer io.Writer) os.Error {
+				return codec.ClientWriteLogin(writer, "bob")
+			},
+			te.InOrder(
+				te.LiteralString("\x01"),                                 // Packet ID
+				te.LiteralString("\x00\x00\x00\x0e"),                     // Protocol version
+				te.LiteralString("\x00\x03\x00b\x00o\x00b"),              // Username
+				te.LiteralString("\x00\x00\x00\x00\x00\x00\x00\x00\x00"), // Unused
+			),
+		},
+		{
+			"client login",
+			ProtocolVersionBeta18,
+			func(codec *Codec, writer io.Writer) os.Error {
+				return codec.ClientWriteLogin(writer, "bob")
+			},
+			te.InOrder(
+				te.LiteralString("\x01"),                    // Packet ID
+				te.LiteralString("\x00\x00\x00\x11"),        // Protocol version
+				te.LiteralString("\x00\x03\x00b\x00o\x00b"), // Username
+				te.LiteralString("\x00\x00\x00\x00\x00\x00\x00\x00"+
+					"\x00\x00\x00\x00\x00\x00\x00\x00"), // Unused
+			),
+		},
+		{
+			"update health",
+			ProtocolVersionBeta17,
+			func(codec *Codec, writer io.Writer) os.Error {
+				return codec.WriteUpdateHealth(writer, 15, 17, 2.5)
+			},
+			te.LiteralString("\x08\x00\x0f"),
+		},
+		{
+			"update health",
+			ProtocolVersionBeta18,
+			func(codec *Codec, writer io.Writer) os.Error {
+				return codec.WriteUpdateHealth(writer, 15, 17, 2.5)
+			},
+			te.InOrder(
+				te.LiteralString("\x08"),             // Packet ID
+				te.LiteralString("\x00\x0f"),         // Health
+				te.LiteralString("\x00\x11"),         // Food
+				te.LiteralString("\x40\x20\x00\x00"), // Food saturation
+			),
+		},
+		{
+			"respawn",
+			ProtocolVersionBeta17,
+			func(codec *Codec, writer io.Writer) os.Error {
+				return codec.WriteRespawn(writer, DimensionNether, GameModeCreative)
+			},
+			te.LiteralString("\x09\xff"),
+		},
+		{
+			"respawn",
+			ProtocolVersionBeta18,
+			func(codec *Codec, writer io.Writer) os.Error {
+				return codec.WriteRespawn(writer, DimensionNether, GameModeCreative)
+			},
+			te.InOrder(
+				te.LiteralString("\x09"),                             // Packet ID
+				te.LiteralString("\xff"),                             // Dimension
+				te.LiteralString("\x01"),                             // Difficulty
+				te.LiteralString("\x01"),                             // Game mode
+				te.LiteralString("\x00\x80"),                         // World height
+				te.LiteralString("\x00\x00\x00\x00\x00\x00\x00\x00"), // Map seed
+			),
+		},
+		{
+			"bed invalid",
+			ProtocolVersionBeta17,
+			func(codec *Codec, writer io.Writer) os.Error {
+				return codec.WriteBedInvalid(writer, BedInvalidReasonRainStart)
+			},
+			te.LiteralString("\x46\x01"),
+		},
+		{
+			"bed invalid",
+			ProtocolVersionBeta18,
+			func(codec *Codec, writer io.Writer) os.Error {
+				return codec.WriteBedInvalid(writer, BedInvalidReasonRainStart)
+			},
+			te.LiteralString("\x46\x01\x00"),
+		},
+		{
+			"experience",
+			ProtocolVersionBeta17,
+			func(codec *Codec, writer io.Writer) os.Error {
+				return codec.WriteExperience(writer, 5, 3, 42)
+			},
+			te.LiteralString(""),
+		},
+		{
+			"experience",
+			ProtocolVersionBeta18,
+			func(codec *Codec, writer io.Writer) os.Error {
+				return codec.WriteExperience(writer, 5, 3, 42)
+			},
+			te.InOrder(
+				te.LiteralString("\x2b"),     // Packet ID
+				te.LiteralString("\x05"),     // Experience
+				te.LiteralString("\x03"),     // Level
+				te.LiteralString("\x00\x2a"), // Total experience
+			),
+		},
+		{
+			"player list item",
+			ProtocolVersionBeta17,
+			func(codec *Codec, writer io.Writer) os.Error {
+				return codec.WritePlayerListItem(writer, "bob", true, 42)
+			},
+			te.LiteralString(""),
+		},
+		{
+			"player list item",
+			ProtocolVersionBeta18,
+			func(codec *Codec, writer io.Writer) os.Error {
+				return codec.WritePlayerListItem(writer, "bob", true, 42)
+			},
+			te.InOrder(
+				te.LiteralString("\xc9"),                    // Packet ID
+				te.LiteralString("\x00\x03\x00b\x00o\x00b"), // Name
+				te.LiteralString("\x01"),                    // Online
+				te.LiteralString("\x00\x2a"),                // Ping
+			),
+		},
+	}
+
+	for _, x := range tests {
+		codec := codecForTest(t, x.version)
+		buf := new(bytes.Buffer)
+		if err := x.result(codec, buf); err != nil {
+			t.Errorf("Error when writing %s in version %d: %v", x.name, x.version, err)
+			continue
+		}
+		result := buf.Bytes()
+		if err := te.Matches(x.want, result); err != nil {
+			t.Errorf("Resulting raw data mismatch for %s in version %d: %v\nGot bytes: %x", x.name, x.version, err, result)
+		}
+	}
+}
+
+// recordingClientHandler records the packets of interest to these tests that
+// it receives.
+type recordingClientHandler struct {
+	IClientPacketHandler
+	packets []string
+}
+
+func (h *recordingClientHandler) record(format string, v ...interface{}) {
+	h.packets = append(h.packets, fmt.Sprintf(format, v...))
+}
+
+func (h *recordingClientHandler) PacketKeepAlive(id int32) {
+	h.record("KeepAlive(%d)", id)
+}
+
+func (h *recordingClientHandler) ClientPacketLogin(entityId EntityId, mapSeed RandomSeed, gameMode GameMode, dimension DimensionId) {
+	h.record("Login(%d, %d, %d, %d)", entityId, mapSeed, gameMode, dimension)
+}
+
+func (h *recordingClientHandler) PacketUpdateHealth(health Health, food int16, foodSaturation float32) {
+	h.record("UpdateHealth(%d, %d, %.1f)", health, food, foodSaturation)
+}
+
+func (h *recordingClientHandler) PacketExperience(experience, level int8, totalExperience int16) {
+	h.record("Experience(%d, %d, %d)", experience, level, totalExperience)
+}
+
+func (h *recordingClientHandler) PacketPlayerListItem(name string, online bool, ping int16) {
+	h.record("PlayerListItem(%q, %t, %d)", name, online, ping)
+}
+
+func (h *recordingClientHandler) PacketRespawn(dimension DimensionId) {
+	h.record("Respawn(%d)", dimension)
+}
+
+func (h *recordingClientHandler) PacketBedInvalid(field1 byte) {
+	h.record("BedInvalid(%d)", field1)
+}
+
+func TestCodecClientRead(t *testing.T) {
+	// Earlier versions don't carry the game mode, food, keep-alive IDs,
+	// experience or player list.
+	wants := map[int32][]string{
+		ProtocolVersionBeta17: []string{
+			"Login(4660, 1234567, 0, -1)",
+			"KeepAlive(0)",
+			"UpdateHealth(15, 0, 0.0)",
+			"Respawn(0)",
+			"BedInvalid(2)",
+		},
+		ProtocolVersionBeta18: []string{
+			"Login(4660, 1234567, 1, -1)",
+			"KeepAlive(7)",
+			"UpdateHealth(15, 17, 2.5)",
+			"Respawn(0)",
+			"BedInvalid(2)",
+			"Experience(5, 3, 42)",
+			"PlayerListItem(\"bob\", false, 0)",
+		},
+	}
+
+	for version, want := range wants {
+		codec := codecForTest(t, version)
+
+		// The packets are read from a single stream, so any packet that is read
+		// with a different length to that written misreads those that follow.
+		buf := new(bytes.Buffer)
+		codec.ServerWriteLogin(buf, 0x1234, 1234567, GameModeCreative, DimensionNether)
+		codec.WriteKeepAlive(buf, 7)
+		codec.WriteUpdateHealth(buf, 15, 17, 2.5)
+		codec.WriteRespawn(buf, DimensionNormal, GameModeCreative)
+		codec.WriteBedInvalid(buf, BedInvalidReasonRainStop)
+		codec.WriteExperience(buf, 5, 3, 42)
+		codec.WritePlayerListItem(buf, "bob", false, 0)
+
+		handler := &recordingClientHandler{}
+		for i := range want {
+			if err := codec.ClientReadPacket(buf, handler); err != nil {
+				t.Errorf("Version %d: error reading packet %d: %v", version, i, err)
+				break
+			}
+		}
+
+		if buf.Len() != 0 {
+			t.Errorf("Version %d: %d trailing bytes", version, buf.Len())
+		}
+		if fmt.Sprint(handler.packets) != fmt.Sprint(want) {
+			t.Errorf("Version %d: expected packets %v, got %v", version, want, handler.packets)
+		}
+	}
+}
+
+// recordingServerHandler records the packets of interest to these tests that
+// it receives.
+type recordingServerHandler struct {
+	IServerPacketHandler
+	keepAliveId int32
+	dimension   DimensionId
+}
+
+func (h *recordingServerHandler) PacketKeepAlive(id int32) {
+	h.keepAliveId = id
+}
+
+func (h *recordingServerHandler) PacketRespawn(dimension DimensionId) {
+	h.dimension = dimension
+}
+
+func TestCodecServerRead(t *testing.T) {
+	for _, version := range []int32{ProtocolVersionBeta17, ProtocolVersionBeta18} {
+		codec := codecForTest(t, version)
+
+		buf := new(bytes.Buffer)
+		codec.ClientWriteLogin(buf, "bob")
+		codec.WriteKeepAlive(buf, 7)
+		codec.WriteRespawn(buf, DimensionNether, GameModeSurvival)
+
+		// The server finds the codec from the client's login.
+		username, loginCodec, err := ServerReadLogin(buf)
+		if err != nil {
+			t.Errorf("Version %d: ServerReadLogin: %v", version, err)
+			continue
+		}
+		if username != "bob" || loginCodec != codec {
+			t.Errorf("Version %d: expected login by bob, got %q with version %d", version, username, loginCodec.Version)
+			continue
+		}
+
+		handler := &recordingServerHandler{}
+		for i := 0; i < 2; i++ {
+			if err = loginCodec.ServerReadPacket(buf, handler); err != nil {
+				t.Errorf("Version %d: error reading packet %d: %v", version, i, err)
+				break
+			}
+		}
+
+		if buf.Len() != 0 {
+			t.Errorf("Version %d: %d trailing bytes", version, buf.Len())
+		}
+		if handler.dimension != DimensionNether {
+			t.Errorf("Version %d: expected respawn in the nether, got %d", version, handler.dimension)
+		}
+		if version >= ProtocolVersionBeta18 && handler.keepAliveId != 7 {
+			t.Errorf("Version %d: expected keep alive 7, got %d", version, handler.keepAliveId)
+		}
+	}
+}
+
+func TestServerReadLoginUnsupportedVersion(t *testing.T) {
+	buf := bytes.NewBuffer([]byte("\x01" + // Packet ID
+		"\x00\x00\x00\x0d" + // Protocol version 13
+		"\x00\x03\x00b\x00o\x00b" + // Username
+		"\x00\x00\x00\x00\x00\x00\x00\x00\x00"))
+
+	if _, codec, err := ServerReadLogin(buf); err == nil {
+		t.Errorf("Expected unsupported protocol version to be refused, got version %d", codec.Version)
+	}
+}
+
+func TestCodecGameMode(t *testing.T) {
+	tests := []struct {
+		version   int32
+		worldMode GameMode
+		want      GameMode
+	}{
+		{ProtocolVersionBeta17, GameModeSurvival, GameModeSurvival},
+		{ProtocolVersionBeta17, GameModeCreative, GameModeSurvival},
+		{ProtocolVersionBeta18, GameModeSurvival, GameModeSurvival},
+		{ProtocolVersionBeta18, GameModeCreative, GameModeCreative},
+	}
+
+	for _, test := range tests {
+		codec := codecForTest(t, test.version)
+		if got := codec.GameMode(test.worldMode); got != test.want {
+			t.Errorf("Version %d, world mode %d: expected game mode %d, got %d", test.version, test.worldMode, test.want, got)
+		}
+	}
+}
+
+type creativeInventoryHandler struct {
+	IServerPacketHandler
+	result string
+}
+
+func (h *creativeInventoryHandler) PacketCreativeInventoryAction(slot SlotId, itemTypeId ItemTypeId, amount ItemCount, data ItemData) {
+	h.result = fmt.Sprintf("CreativeInventoryAction(%d, %d, %d, %d)", slot, itemTypeId, amount, data)
+}
+
+func TestCreativeInventoryActionRead(t *testing.T) {
+	codec := codecForTest(t, ProtocolVersionBeta18)
+
+	buf := new(bytes.Buffer)
+	ClientWriteCreativeInventoryAction(buf, 36, 1, 64, 0)
+
+	handler := &creativeInventoryHandler{}
+	if err := codec.ServerReadPacket(buf, handler); err != nil {
+		t.Fatalf("Error reading packet: %v", err)
+	}
+
+	want := "CreativeInventoryAction(36, 1, 64, 0)"
+	if handler.result != want {
+		t.Errorf("Expected %s, got %s", want, handler.result)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("%d trailing bytes", buf.Len())
+	}
+}
+
+func (h *recordingClientHandler) PacketBlockChangeMulti(chunkLoc *ChunkXz, blockCoords []SubChunkXyz, blockTypes []BlockId, blockMetaData []byte) {
+	h.record("BlockChangeMulti(%v, %v, %v, %v)", *chunkLoc, blockCoords, blockTypes, blockMetaData)
+}
+
+func TestBlockChangeMultiRead(t *testing.T) {
+	codec := codecForTest(t, ProtocolVersionBeta18)
+
+	buf := new(bytes.Buffer)
+	err := WriteBlockChangeMulti(
+		buf, &ChunkXz{-1, 2},
+		[]SubChunkXyz{SubChunkXyz{15, 127, 8}, SubChunkXyz{0, 1, 2}},
+		[]BlockId{1, BlockIdAir},
+		[]byte{3, 0})
+	if err != nil {
+		t.Fatalf("Error writing packet: %v", err)
+	}
+
+	handler := &recordingClientHandler{}
+	if err := codec.ClientReadPacket(buf, handler); err != nil {
+		t.Fatalf("Error reading packet: %v", err)
+	}
+
+	want := "[BlockChangeMulti({-1 2}, [{15 127 8} {0 1 2}], [1 0], [3 0])]"
+	if got := fmt.Sprint(handler.packets); got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("%d trailing bytes", buf.Len())
+	}
+}
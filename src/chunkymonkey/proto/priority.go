@@ -0,0 +1,25 @@
+package proto
+
+// PacketPriority is how urgently a client needs a packet sent to it. It is
+// given by whatever writes the packet, as a single buffer may hold several
+// packets.
+type PacketPriority byte
+
+const (
+	// Packets that mean the same whenever the client receives them, which can
+	// be sent ahead of others already waiting, such as chat, keep-alives and
+	// the player's own position. The client holds the player still until it
+	// has received the chunk that they are in.
+	PacketPriorityHigh = PacketPriority(iota)
+
+	// Updates to the movement of entities. They are sent in order with normal
+	// packets, so never ahead of the entity appearing, but the client can do
+	// without them when it has fallen behind. It is then sent the full
+	// positions of the entities that it sees.
+	PacketPriorityEntityMove
+
+	// Packets that must reach the client in the order that they were sent,
+	// such as chunk data, the blocks changed within chunks, and entities
+	// appearing and disappearing.
+	PacketPriorityNormal
+)
@@ -14,9 +14,6 @@ import (
 )
 
 const (
-	// Currently only this protocol version is supported.
-	protocolVersion = 14
-
 	maxUcs2Char  = 0xffff
 	ucs2ReplChar = 0xfffd
 
@@ -56,7 +53,9 @@ const (
 	packetIdEntityLookAndRelMove = 0x21
 	packetIdEntityTeleport       = 0x22
 	packetIdEntityStatus         = 0x26
+	packetIdEntityAttach         = 0x27
 	packetIdEntityMetadata       = 0x28
+	packetIdExperience           = 0x2b
 	packetIdPreChunk             = 0x32
 	packetIdMapChunk             = 0x33
 	packetIdBlockChangeMulti     = 0x34
@@ -73,9 +72,11 @@ const (
 	packetIdWindowItems          = 0x68
 	packetIdWindowProgressBar    = 0x69
 	packetIdWindowTransaction    = 0x6a
+	packetIdCreativeInventory    = 0x6b
 	packetIdSignUpdate           = 0x82
 	packetIdUnknown0x83          = 0x83
 	packetIdIncrementStatistic   = 0xc8
+	packetIdPlayerListItem       = 0xc9
 	packetIdDisconnect           = 0xff
 )
 
@@ -89,7 +90,8 @@ var colorTagEndErr = os.NewError("Found a color tag at the end of a message. Thi
 
 // Packets commonly received by both client and server
 type IPacketHandler interface {
-	PacketKeepAlive()
+	// id is always 0 in versions before ProtocolVersionBeta18.
+	PacketKeepAlive(id int32)
 	PacketChatMessage(message string)
 	PacketEntityAction(entityId EntityId, action EntityAction)
 	PacketUseEntity(user EntityId, target EntityId, leftClick bool)
@@ -113,18 +115,22 @@ type IServerPacketHandler interface {
 	PacketHoldingChange(slotId SlotId)
 	PacketWindowClose(windowId WindowId)
 	PacketWindowClick(windowId WindowId, slot SlotId, rightClick bool, txId TxId, shiftClick bool, expectedSlot *WindowSlot)
+	PacketCreativeInventoryAction(slot SlotId, itemTypeId ItemTypeId, amount ItemCount, data ItemData)
 }
 
 // Clients to the protocol must implement this interface to receive packets
 type IClientPacketHandler interface {
 	IPacketHandler
-	ClientPacketLogin(entityId EntityId, mapSeed RandomSeed, dimension DimensionId)
+	ClientPacketLogin(entityId EntityId, mapSeed RandomSeed, gameMode GameMode, dimension DimensionId)
 	PacketTimeUpdate(time Ticks)
-	PacketBedUse(flag bool, bedLoc *BlockXyz)
+	PacketBedUse(entityId EntityId, flag bool, bedLoc *BlockXyz)
 	PacketNamedEntitySpawn(entityId EntityId, name string, position *AbsIntXyz, look *LookBytes, currentItem ItemTypeId)
 	PacketEntityEquipment(entityId EntityId, slot SlotId, itemTypeId ItemTypeId, data ItemData)
 	PacketSpawnPosition(position *BlockXyz)
-	PacketUpdateHealth(health Health)
+	// food and foodSaturation are always 0 in versions before
+	// ProtocolVersionBeta18.
+	PacketUpdateHealth(health Health, food int16, foodSaturation float32)
+	PacketExperience(experience, level int8, totalExperience int16)
 	PacketItemSpawn(entityId EntityId, itemTypeId ItemTypeId, count ItemCount, data ItemData, location *AbsIntXyz, orientation *OrientationBytes)
 	PacketItemCollect(collectedItem EntityId, collector EntityId)
 	PacketObjectSpawn(entityId EntityId, objType ObjTypeId, position *AbsIntXyz, objectData *ObjectData)
@@ -137,6 +143,7 @@ type IClientPacketHandler interface {
 	PacketEntityLook(entityId EntityId, look *LookBytes)
 	PacketEntityTeleport(entityId EntityId, position *AbsIntXyz, look *LookBytes)
 	PacketEntityStatus(entityId EntityId, status EntityStatus)
+	PacketEntityAttach(entityId EntityId, vehicleId EntityId)
 	PacketEntityMetadata(entityId EntityId, metadata []EntityMetadata)
 
 	PacketPreChunk(position *ChunkXz, mode ChunkLoadMode)
@@ -157,6 +164,7 @@ type IClientPacketHandler interface {
 	PacketWindowProgressBar(windowId WindowId, prgBarId PrgBarId, value PrgBarValue)
 	PacketUnknown0x83(field1, field2 int16, field3 string)
 	PacketIncrementStatistic(statisticId StatisticId, delta int8)
+	PacketPlayerListItem(name string, online bool, ping int16)
 }
 
 // Common protocol helper functions
@@ -388,21 +396,20 @@ type ObjectData struct {
 
 // packetIdKeepAlive
 
-func WriteKeepAlive(writer io.Writer) os.Error {
+func writeKeepAlive(writer io.Writer) os.Error {
 	return binary.Write(writer, binary.BigEndian, byte(packetIdKeepAlive))
 }
 
 func readKeepAlive(reader io.Reader, handler IPacketHandler) (err os.Error) {
-	handler.PacketKeepAlive()
+	handler.PacketKeepAlive(0)
 	return
 }
 
 // packetIdLogin
 
-func commonReadLogin(reader io.Reader) (versionOrEntityId int32, str string, mapSeed RandomSeed, dimension DimensionId, err os.Error) {
-	if err = binary.Read(reader, binary.BigEndian, &versionOrEntityId); err != nil {
-		return
-	}
+// readLoginBody reads the fields of a login packet that follow the protocol
+// version or EntityId.
+func readLoginBody(reader io.Reader) (str string, mapSeed RandomSeed, dimension DimensionId, err os.Error) {
 	if str, err = readString16(reader); err != nil {
 		return
 	}
@@ -421,7 +428,9 @@ func commonReadLogin(reader io.Reader) (versionOrEntityId int32, str string, map
 	return
 }
 
-func ServerReadLogin(reader io.Reader) (username string, err os.Error) {
+// ServerReadLogin reads the client's login, and returns the codec for the
+// version of the protocol that the client speaks.
+func ServerReadLogin(reader io.Reader) (username string, codec *Codec, err os.Error) {
 	var packetId byte
 	if err = binary.Read(reader, binary.BigEndian, &packetId); err != nil {
 		return
@@ -431,39 +440,50 @@ func ServerReadLogin(reader io.Reader) (username string, err os.Error) {
 		return
 	}
 
-	version, username, _, _, err := commonReadLogin(reader)
-	if err != nil {
+	var version int32
+	if err = binary.Read(reader, binary.BigEndian, &version); err != nil {
 		return
 	}
 
-	if version != protocolVersion {
+	codec, ok := CodecForVersion(version)
+	if !ok {
 		err = os.NewError(fmt.Sprintf("serverLogin: unsupported protocol version %#x", version))
 		return
 	}
+
+	username, err = codec.serverReadLoginBody(reader)
+
 	return
 }
 
 func clientReadLogin(reader io.Reader, handler IClientPacketHandler) (err os.Error) {
-	entityId, _, mapSeed, dimension, err := commonReadLogin(reader)
+	var entityId int32
+	if err = binary.Read(reader, binary.BigEndian, &entityId); err != nil {
+		return
+	}
+
+	_, mapSeed, dimension, err := readLoginBody(reader)
 	if err != nil {
 		return
 	}
 
-	handler.ClientPacketLogin(EntityId(entityId), mapSeed, dimension)
+	handler.ClientPacketLogin(EntityId(entityId), mapSeed, GameModeSurvival, dimension)
 
 	return
 }
 
-func commonWriteLogin(writer io.Writer, str1, str2 string, entityId EntityId, mapSeed RandomSeed, dimension DimensionId) (err os.Error) {
-	if err = binary.Write(writer, binary.BigEndian, entityId); err != nil {
+// writeLogin writes a login packet, which carries the protocol version from the
+// client, or the player's EntityId from the server.
+func writeLogin(writer io.Writer, versionOrEntityId int32, str string, mapSeed RandomSeed, dimension DimensionId) (err os.Error) {
+	if err = binary.Write(writer, binary.BigEndian, byte(packetIdLogin)); err != nil {
 		return
 	}
-
-	// These strings are currently unused
-	if err = writeString16(writer, str1); err != nil {
+	if err = binary.Write(writer, binary.BigEndian, versionOrEntityId); err != nil {
 		return
 	}
-	if err = writeString16(writer, str2); err != nil {
+
+	// The username from the client. This is unused from the server.
+	if err = writeString16(writer, str); err != nil {
 		return
 	}
 
@@ -477,22 +497,6 @@ func commonWriteLogin(writer io.Writer, str1, str2 string, entityId EntityId, ma
 	return binary.Write(writer, binary.BigEndian, &packetEnd)
 }
 
-func ServerWriteLogin(writer io.Writer, entityId EntityId, mapSeed RandomSeed, dimension DimensionId) (err os.Error) {
-	if err = binary.Write(writer, binary.BigEndian, byte(packetIdLogin)); err != nil {
-		return
-	}
-
-	return commonWriteLogin(writer, "", "", entityId, mapSeed, dimension)
-}
-
-func ClientWriteLogin(writer io.Writer, username, password string) (err os.Error) {
-	if err = binary.Write(writer, binary.BigEndian, byte(packetIdLogin)); err != nil {
-		return
-	}
-
-	return commonWriteLogin(writer, username, password, protocolVersion, 0, 0)
-}
-
 // packetIdHandshake
 
 func ServerReadHandshake(reader io.Reader) (username string, err os.Error) {
@@ -523,6 +527,15 @@ func ClientReadHandshake(reader io.Reader) (serverId string, err os.Error) {
 	return readString16(reader)
 }
 
+func ClientWriteHandshake(writer io.Writer, username string) (err os.Error) {
+	err = binary.Write(writer, binary.BigEndian, byte(packetIdHandshake))
+	if err != nil {
+		return
+	}
+
+	return writeString16(writer, username)
+}
+
 func ServerWriteHandshake(writer io.Writer, reply string) (err os.Error) {
 	err = binary.Write(writer, binary.BigEndian, byte(packetIdHandshake))
 	if err != nil {
@@ -709,7 +722,7 @@ func readUseEntity(reader io.Reader, handler IPacketHandler) (err os.Error) {
 
 // packetIdUpdateHealth
 
-func WriteUpdateHealth(writer io.Writer, health Health) (err os.Error) {
+func writeUpdateHealth(writer io.Writer, health Health) (err os.Error) {
 	var packet = struct {
 		PacketId byte
 		health   Health
@@ -729,13 +742,13 @@ func readUpdateHealth(reader io.Reader, handler IClientPacketHandler) (err os.Er
 		return
 	}
 
-	handler.PacketUpdateHealth(health)
+	handler.PacketUpdateHealth(health, 0, 0)
 	return
 }
 
 // packetIdRespawn
 
-func WriteRespawn(writer io.Writer, dimension DimensionId) os.Error {
+func writeRespawn(writer io.Writer, dimension DimensionId) os.Error {
 	var packet = struct {
 		packetId  byte
 		dimension DimensionId
@@ -1118,15 +1131,17 @@ func readHoldingChange(reader io.Reader, handler IServerPacketHandler) (err os.E
 
 // packetIdBedUse
 
-func WriteBedUse(writer io.Writer, flag bool, bedLoc *BlockXyz) (err os.Error) {
+func WriteBedUse(writer io.Writer, entityId EntityId, flag bool, bedLoc *BlockXyz) (err os.Error) {
 	var packet = struct {
 		PacketId byte
+		EntityId EntityId
 		Flag     byte
 		X        BlockCoord
 		Y        BlockYCoord
 		Z        BlockCoord
 	}{
 		packetIdBedUse,
+		entityId,
 		boolToByte(flag),
 		bedLoc.X,
 		bedLoc.Y,
@@ -1138,14 +1153,16 @@ func WriteBedUse(writer io.Writer, flag bool, bedLoc *BlockXyz) (err os.Error) {
 
 func readBedUse(reader io.Reader, handler IClientPacketHandler) (err os.Error) {
 	var packet struct {
-		Flag byte
-		X    BlockCoord
-		Y    BlockYCoord
-		Z    BlockCoord
+		EntityId EntityId
+		Flag     byte
+		X        BlockCoord
+		Y        BlockYCoord
+		Z        BlockCoord
 	}
 
-	if err = binary.Read(reader, binary.BigEndian, &packet); err != nil {
+	if err = binary.Read(reader, binary.BigEndian, &packet); err == nil {
 		handler.PacketBedUse(
+			packet.EntityId,
 			byteToBool(packet.Flag),
 			&BlockXyz{packet.X, packet.Y, packet.Z})
 	}
@@ -1871,6 +1888,40 @@ func readEntityStatus(reader io.Reader, handler IClientPacketHandler) (err os.Er
 	return
 }
 
+// packetIdEntityAttach
+
+// WriteEntityAttach tells the client that the entity is riding the vehicle.
+// A vehicleId of NoEntityId dismounts the entity.
+func WriteEntityAttach(writer io.Writer, entityId EntityId, vehicleId EntityId) (err os.Error) {
+	var packet = struct {
+		PacketId  byte
+		EntityId  EntityId
+		VehicleId EntityId
+	}{
+		packetIdEntityAttach,
+		entityId,
+		vehicleId,
+	}
+
+	return binary.Write(writer, binary.BigEndian, &packet)
+}
+
+func readEntityAttach(reader io.Reader, handler IClientPacketHandler) (err os.Error) {
+	var packet struct {
+		EntityId  EntityId
+		VehicleId EntityId
+	}
+
+	err = binary.Read(reader, binary.BigEndian, &packet)
+	if err != nil {
+		return
+	}
+
+	handler.PacketEntityAttach(packet.EntityId, packet.VehicleId)
+
+	return
+}
+
 // packetIdEntityMetadata
 
 func WriteEntityMetadata(writer io.Writer, entityId EntityId, data []EntityMetadata) (err os.Error) {
@@ -2019,8 +2070,9 @@ func readMapChunk(reader io.Reader, handler IClientPacketHandler) (err os.Error)
 // packetIdBlockChangeMulti
 
 func WriteBlockChangeMulti(writer io.Writer, chunkLoc *ChunkXz, blockCoords []SubChunkXyz, blockTypes []BlockId, blockMetaData []byte) (err os.Error) {
-	// NOTE that we don't yet check that blockCoords, blockTypes and
-	// blockMetaData are of the same length.
+	if len(blockTypes) != len(blockCoords) || len(blockMetaData) != len(blockCoords) {
+		return os.NewError("block change lists differ in length")
+	}
 
 	var packet = struct {
 		PacketId byte
@@ -2037,16 +2089,22 @@ func WriteBlockChangeMulti(writer io.Writer, chunkLoc *ChunkXz, blockCoords []Su
 		return
 	}
 
-	rawBlockLocs := make([]int16, packet.Count)
+	rawBlockLocs := make([]uint16, packet.Count)
 	for index, blockCoord := range blockCoords {
-		rawBlockCoord := int16(0)
-		rawBlockCoord |= int16((blockCoord.X & 0x0f) << 12)
-		rawBlockCoord |= int16((blockCoord.Y & 0xff))
-		rawBlockCoord |= int16((blockCoord.Z & 0x0f) << 8)
+		rawBlockCoord := uint16(0)
+		rawBlockCoord |= uint16(blockCoord.X&0x0f) << 12
+		rawBlockCoord |= uint16(blockCoord.Y & 0xff)
+		rawBlockCoord |= uint16(blockCoord.Z&0x0f) << 8
 		rawBlockLocs[index] = rawBlockCoord
 	}
 
-	binary.Write(writer, binary.BigEndian, rawBlockLocs)
+	if err = binary.Write(writer, binary.BigEndian, rawBlockLocs); err != nil {
+		return
+	}
+	if err = binary.Write(writer, binary.BigEndian, blockTypes); err != nil {
+		return
+	}
+	err = binary.Write(writer, binary.BigEndian, blockMetaData)
 
 	return
 }
@@ -2062,14 +2120,20 @@ func readBlockChangeMulti(reader io.Reader, handler IClientPacketHandler) (err o
 		return
 	}
 
-	rawBlockLocs := make([]int16, packet.Count)
+	rawBlockLocs := make([]uint16, packet.Count)
 	blockTypes := make([]BlockId, packet.Count)
 	// blockMetadata array appears to represent one block per byte
 	blockMetadata := make([]byte, packet.Count)
 
-	err = binary.Read(reader, binary.BigEndian, rawBlockLocs)
-	err = binary.Read(reader, binary.BigEndian, blockTypes)
-	err = binary.Read(reader, binary.BigEndian, blockMetadata)
+	if err = binary.Read(reader, binary.BigEndian, rawBlockLocs); err != nil {
+		return
+	}
+	if err = binary.Read(reader, binary.BigEndian, blockTypes); err != nil {
+		return
+	}
+	if err = binary.Read(reader, binary.BigEndian, blockMetadata); err != nil {
+		return
+	}
 
 	blockLocs := make([]SubChunkXyz, packet.Count)
 	for index, rawLoc := range rawBlockLocs {
@@ -2274,8 +2338,16 @@ func readUnknown0x3d(reader io.Reader, handler IPacketHandler) (err os.Error) {
 
 // packetIdBedInvalid
 
+// Reasons sent in packetIdBedInvalid. Despite its name, the packet is also
+// used to tell the client when rain starts and stops.
+const (
+	BedInvalidReasonBed       = 0
+	BedInvalidReasonRainStart = 1
+	BedInvalidReasonRainStop  = 2
+)
+
 // TODO Revise this when packet better understood.
-func WriteBedInvalid(writer io.Writer, field1 byte) (err os.Error) {
+func writeBedInvalid(writer io.Writer, field1 byte) (err os.Error) {
 	var packet = struct {
 		PacketId byte
 		Field1   byte
@@ -2877,6 +2949,9 @@ type commonPacketReaderMap map[byte]commonPacketHandler
 type serverPacketReaderMap map[byte]serverPacketHandler
 type clientPacketReaderMap map[byte]clientPacketHandler
 
+// The following packet mappings are those of protocol version 14. Codecs for
+// other versions override the packets that differ.
+
 // Common packet mapping
 var commonReadFns = commonPacketReaderMap{
 	packetIdKeepAlive:           readKeepAlive,
@@ -2927,6 +3002,7 @@ var clientReadFns = clientPacketReaderMap{
 	packetIdEntityLookAndRelMove: readEntityLookAndRelMove,
 	packetIdEntityTeleport:       readEntityTeleport,
 	packetIdEntityStatus:         readEntityStatus,
+	packetIdEntityAttach:         readEntityAttach,
 	packetIdEntityMetadata:       readEntityMetadata,
 	packetIdPreChunk:             readPreChunk,
 	packetIdMapChunk:             readMapChunk,
@@ -2943,43 +3019,3 @@ var clientReadFns = clientPacketReaderMap{
 	packetIdUnknown0x83:          readUnknown0x83,
 	packetIdIncrementStatistic:   readIncrementStatistic,
 }
-
-// A server should call this to receive a single packet from a client. It will
-// block until a packet was successfully handled, or there was an error.
-func ServerReadPacket(reader io.Reader, handler IServerPacketHandler) os.Error {
-	var packetId byte
-
-	if err := binary.Read(reader, binary.BigEndian, &packetId); err != nil {
-		return err
-	}
-
-	if commonFn, ok := commonReadFns[packetId]; ok {
-		return commonFn(reader, handler)
-	}
-
-	if serverFn, ok := serverReadFns[packetId]; ok {
-		return serverFn(reader, handler)
-	}
-
-	return os.NewError(fmt.Sprintf("unhandled packet type %#x", packetId))
-}
-
-// A client should call this to receive a single packet from a client. It will
-// block until a packet was successfully handled, or there was an error.
-func ClientReadPacket(reader io.Reader, handler IClientPacketHandler) os.Error {
-	var packetId byte
-
-	if err := binary.Read(reader, binary.BigEndian, &packetId); err != nil {
-		return err
-	}
-
-	if commonFn, ok := commonReadFns[packetId]; ok {
-		return commonFn(reader, handler)
-	}
-
-	if clientFn, ok := clientReadFns[packetId]; ok {
-		return clientFn(reader, handler)
-	}
-
-	return os.NewError(fmt.Sprintf("unhandled packet type %#x", packetId))
-}
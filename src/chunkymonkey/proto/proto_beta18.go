@@ -0,0 +1,389 @@
+package proto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+
+	. "chunkymonkey/types"
+)
+
+// Packets that differ in protocol version 17 (Beta 1.8) from version 14.
+
+// Values written by this server in version 17 packets that it doesn't yet
+// have its own state for.
+const (
+	beta18Difficulty  = 1
+	beta18WorldHeight = 128
+	beta18MaxPlayers  = 20
+)
+
+var codecBeta18 = &Codec{
+	Version: ProtocolVersionBeta18,
+
+	commonReadFns: commonReadFns.override(commonPacketReaderMap{
+		packetIdKeepAlive: readKeepAliveBeta18,
+		packetIdRespawn:   readRespawnBeta18,
+	}),
+	serverReadFns: serverReadFns.override(serverPacketReaderMap{
+		packetIdCreativeInventory: readCreativeInventoryActionBeta18,
+	}),
+	clientReadFns: clientReadFns.override(clientPacketReaderMap{
+		packetIdLogin:          clientReadLoginBeta18,
+		packetIdUpdateHealth:   readUpdateHealthBeta18,
+		packetIdBedInvalid:     readBedInvalidBeta18,
+		packetIdExperience:     readExperienceBeta18,
+		packetIdPlayerListItem: readPlayerListItemBeta18,
+	}),
+
+	serverReadLoginBody: serverReadLoginBodyBeta18,
+	serverWriteLogin: func(writer io.Writer, entityId EntityId, mapSeed RandomSeed, gameMode GameMode, dimension DimensionId) os.Error {
+		return writeLoginBeta18(writer, int32(entityId), "", mapSeed, gameMode, dimension, beta18Difficulty, beta18WorldHeight, beta18MaxPlayers)
+	},
+	clientWriteLogin: func(writer io.Writer, version int32, username string) os.Error {
+		return writeLoginBeta18(writer, version, username, 0, 0, 0, 0, 0, 0)
+	},
+	writeKeepAlive:      writeKeepAliveBeta18,
+	writeUpdateHealth:   writeUpdateHealthBeta18,
+	writeRespawn:        writeRespawnBeta18,
+	writeBedInvalid:     writeBedInvalidBeta18,
+	writeExperience:     writeExperienceBeta18,
+	writePlayerListItem: writePlayerListItemBeta18,
+}
+
+func (m commonPacketReaderMap) override(overrides commonPacketReaderMap) commonPacketReaderMap {
+	result := make(commonPacketReaderMap, len(m))
+	for packetId, fn := range m {
+		result[packetId] = fn
+	}
+	for packetId, fn := range overrides {
+		result[packetId] = fn
+	}
+	return result
+}
+
+func (m serverPacketReaderMap) override(overrides serverPacketReaderMap) serverPacketReaderMap {
+	result := make(serverPacketReaderMap, len(m))
+	for packetId, fn := range m {
+		result[packetId] = fn
+	}
+	for packetId, fn := range overrides {
+		result[packetId] = fn
+	}
+	return result
+}
+
+func (m clientPacketReaderMap) override(overrides clientPacketReaderMap) clientPacketReaderMap {
+	result := make(clientPacketReaderMap, len(m))
+	for packetId, fn := range m {
+		result[packetId] = fn
+	}
+	for packetId, fn := range overrides {
+		result[packetId] = fn
+	}
+	return result
+}
+
+// packetIdKeepAlive
+
+func writeKeepAliveBeta18(writer io.Writer, id int32) os.Error {
+	var packet = struct {
+		PacketId byte
+		Id       int32
+	}{
+		packetIdKeepAlive,
+		id,
+	}
+
+	return binary.Write(writer, binary.BigEndian, &packet)
+}
+
+func readKeepAliveBeta18(reader io.Reader, handler IPacketHandler) (err os.Error) {
+	var id int32
+	if err = binary.Read(reader, binary.BigEndian, &id); err != nil {
+		return
+	}
+
+	handler.PacketKeepAlive(id)
+	return
+}
+
+// packetIdLogin
+
+type loginEndBeta18 struct {
+	MapSeed     RandomSeed
+	GameMode    GameMode
+	Dimension   DimensionId
+	Difficulty  int8
+	WorldHeight byte
+	MaxPlayers  byte
+}
+
+func writeLoginBeta18(writer io.Writer, versionOrEntityId int32, str string, mapSeed RandomSeed, gameMode GameMode, dimension DimensionId, difficulty int8, worldHeight, maxPlayers byte) (err os.Error) {
+	var packetStart = struct {
+		PacketId          byte
+		VersionOrEntityId int32
+	}{
+		packetIdLogin,
+		versionOrEntityId,
+	}
+	if err = binary.Write(writer, binary.BigEndian, &packetStart); err != nil {
+		return
+	}
+
+	// The username from the client. This is unused from the server.
+	if err = writeString16(writer, str); err != nil {
+		return
+	}
+
+	var packetEnd = loginEndBeta18{
+		mapSeed,
+		gameMode,
+		dimension,
+		difficulty,
+		worldHeight,
+		maxPlayers,
+	}
+	return binary.Write(writer, binary.BigEndian, &packetEnd)
+}
+
+func readLoginBodyBeta18(reader io.Reader) (str string, packetEnd loginEndBeta18, err os.Error) {
+	if str, err = readString16(reader); err != nil {
+		return
+	}
+
+	err = binary.Read(reader, binary.BigEndian, &packetEnd)
+	return
+}
+
+func serverReadLoginBodyBeta18(reader io.Reader) (username string, err os.Error) {
+	username, _, err = readLoginBodyBeta18(reader)
+	return
+}
+
+func clientReadLoginBeta18(reader io.Reader, handler IClientPacketHandler) (err os.Error) {
+	var entityId int32
+	if err = binary.Read(reader, binary.BigEndian, &entityId); err != nil {
+		return
+	}
+
+	_, packetEnd, err := readLoginBodyBeta18(reader)
+	if err != nil {
+		return
+	}
+
+	handler.ClientPacketLogin(EntityId(entityId), packetEnd.MapSeed, packetEnd.GameMode, packetEnd.Dimension)
+
+	return
+}
+
+// packetIdUpdateHealth
+
+func writeUpdateHealthBeta18(writer io.Writer, health Health, food int16, foodSaturation float32) (err os.Error) {
+	var packet = struct {
+		PacketId       byte
+		Health         Health
+		Food           int16
+		FoodSaturation float32
+	}{
+		packetIdUpdateHealth,
+		health,
+		food,
+		foodSaturation,
+	}
+
+	return binary.Write(writer, binary.BigEndian, &packet)
+}
+
+func readUpdateHealthBeta18(reader io.Reader, handler IClientPacketHandler) (err os.Error) {
+	var packet struct {
+		Health         Health
+		Food           int16
+		FoodSaturation float32
+	}
+
+	if err = binary.Read(reader, binary.BigEndian, &packet); err != nil {
+		return
+	}
+
+	handler.PacketUpdateHealth(packet.Health, packet.Food, packet.FoodSaturation)
+	return
+}
+
+// packetIdRespawn
+
+func writeRespawnBeta18(writer io.Writer, dimension DimensionId, gameMode GameMode) os.Error {
+	var packet = struct {
+		PacketId    byte
+		Dimension   DimensionId
+		Difficulty  int8
+		GameMode    int8
+		WorldHeight int16
+		MapSeed     RandomSeed
+	}{
+		packetIdRespawn,
+		dimension,
+		beta18Difficulty,
+		int8(gameMode),
+		beta18WorldHeight,
+		0,
+	}
+
+	return binary.Write(writer, binary.BigEndian, &packet)
+}
+
+func readRespawnBeta18(reader io.Reader, handler IPacketHandler) (err os.Error) {
+	var packet struct {
+		Dimension   DimensionId
+		Difficulty  int8
+		GameMode    int8
+		WorldHeight int16
+		MapSeed     RandomSeed
+	}
+
+	if err = binary.Read(reader, binary.BigEndian, &packet); err != nil {
+		return
+	}
+
+	handler.PacketRespawn(packet.Dimension)
+
+	return
+}
+
+// packetIdBedInvalid
+
+func writeBedInvalidBeta18(writer io.Writer, reason byte) (err os.Error) {
+	var packet = struct {
+		PacketId byte
+		Reason   byte
+		GameMode byte
+	}{
+		packetIdBedInvalid,
+		reason,
+		byte(GameModeSurvival),
+	}
+
+	return binary.Write(writer, binary.BigEndian, &packet)
+}
+
+func readBedInvalidBeta18(reader io.Reader, handler IClientPacketHandler) (err os.Error) {
+	var packet struct {
+		Reason   byte
+		GameMode byte
+	}
+	if err = binary.Read(reader, binary.BigEndian, &packet); err != nil {
+		return
+	}
+
+	handler.PacketBedInvalid(packet.Reason)
+	return
+}
+
+// packetIdExperience
+
+func writeExperienceBeta18(writer io.Writer, experience, level int8, totalExperience int16) (err os.Error) {
+	var packet = struct {
+		PacketId        byte
+		Experience      int8
+		Level           int8
+		TotalExperience int16
+	}{
+		packetIdExperience,
+		experience,
+		level,
+		totalExperience,
+	}
+
+	return binary.Write(writer, binary.BigEndian, &packet)
+}
+
+func readExperienceBeta18(reader io.Reader, handler IClientPacketHandler) (err os.Error) {
+	var packet struct {
+		Experience      int8
+		Level           int8
+		TotalExperience int16
+	}
+	if err = binary.Read(reader, binary.BigEndian, &packet); err != nil {
+		return
+	}
+
+	handler.PacketExperience(packet.Experience, packet.Level, packet.TotalExperience)
+	return
+}
+
+// packetIdCreativeInventory
+
+// ClientWriteCreativeInventoryAction sets the contents of a slot in the
+// inventory of a player in creative mode. It is only in ProtocolVersionBeta18
+// and later.
+func ClientWriteCreativeInventoryAction(writer io.Writer, slot SlotId, itemTypeId ItemTypeId, amount ItemCount, data ItemData) (err os.Error) {
+	var packet = struct {
+		PacketId   byte
+		Slot       SlotId
+		ItemTypeId ItemTypeId
+		Amount     int16
+		Data       ItemData
+	}{
+		packetIdCreativeInventory,
+		slot,
+		itemTypeId,
+		int16(amount),
+		data,
+	}
+
+	return binary.Write(writer, binary.BigEndian, &packet)
+}
+
+func readCreativeInventoryActionBeta18(reader io.Reader, handler IServerPacketHandler) (err os.Error) {
+	var packet struct {
+		Slot       SlotId
+		ItemTypeId ItemTypeId
+		Amount     int16
+		Data       ItemData
+	}
+	if err = binary.Read(reader, binary.BigEndian, &packet); err != nil {
+		return
+	}
+
+	handler.PacketCreativeInventoryAction(packet.Slot, packet.ItemTypeId, ItemCount(packet.Amount), packet.Data)
+	return
+}
+
+// packetIdPlayerListItem
+
+func writePlayerListItemBeta18(writer io.Writer, name string, online bool, ping int16) (err os.Error) {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, byte(packetIdPlayerListItem))
+	writeString16(buf, name)
+
+	var packetEnd = struct {
+		Online byte
+		Ping   int16
+	}{
+		boolToByte(online),
+		ping,
+	}
+	binary.Write(buf, binary.BigEndian, &packetEnd)
+
+	_, err = writer.Write(buf.Bytes())
+	return
+}
+
+func readPlayerListItemBeta18(reader io.Reader, handler IClientPacketHandler) (err os.Error) {
+	name, err := readString16(reader)
+	if err != nil {
+		return
+	}
+
+	var packetEnd struct {
+		Online byte
+		Ping   int16
+	}
+	if err = binary.Read(reader, binary.BigEndian, &packetEnd); err != nil {
+		return
+	}
+
+	handler.PacketPlayerListItem(name, byteToBool(packetEnd.Online), packetEnd.Ping)
+	return
+}
@@ -0,0 +1,93 @@
+package record
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+
+	"chunkymonkey/proto"
+)
+
+// Record is a single read from a recorded connection.
+type Record struct {
+	DelayNs int64 // Delay since the previous record.
+	Data    []byte
+}
+
+// ReadRecords reads all of the records written by a ReaderRecorder.
+func ReadRecords(log io.Reader) (records []Record, err os.Error) {
+	var header header
+	for {
+		if err = binary.Read(log, binary.BigEndian, &header); err != nil {
+			if err == os.EOF {
+				err = nil
+			}
+			return
+		}
+		if header.Length < 0 {
+			return nil, os.NewError("negative record length")
+		}
+
+		data := make([]byte, header.Length)
+		if _, err = io.ReadFull(log, data); err != nil {
+			return
+		}
+		records = append(records, Record{header.Timestamp, data})
+	}
+	return
+}
+
+// Session is a recording of a client's connection to a server, split into the
+// client's login and what the client sent after it. The login is split off so
+// that the session can be replayed under another username, waiting on the
+// server's replies to the login as a client would.
+type Session struct {
+	Username string
+	Codec    *proto.Codec
+	Records  []Record // What the client sent after logging in.
+}
+
+// ReadSession reads the records of a client connection written by a
+// ReaderRecorder.
+func ReadSession(log io.Reader) (session *Session, err os.Error) {
+	records, err := ReadRecords(log)
+	if err != nil {
+		return
+	}
+
+	var size int
+	for i := range records {
+		size += len(records[i].Data)
+	}
+	data := bytes.NewBuffer(make([]byte, 0, size))
+	for i := range records {
+		data.Write(records[i].Data)
+	}
+
+	if _, err = proto.ServerReadHandshake(data); err != nil {
+		return
+	}
+	username, codec, err := proto.ServerReadLogin(data)
+	if err != nil {
+		return
+	}
+
+	// Drop the records holding the handshake and login. What follows the
+	// login in the record that it ends in is kept, with that record's delay.
+	skip := size - data.Len()
+	for len(records) > 0 && skip >= len(records[0].Data) {
+		skip -= len(records[0].Data)
+		records = records[1:]
+	}
+	if len(records) > 0 {
+		records[0].Data = records[0].Data[skip:]
+	}
+
+	session = &Session{
+		Username: username,
+		Codec:    codec,
+		Records:  records,
+	}
+	return
+}
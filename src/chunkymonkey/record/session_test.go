@@ -0,0 +1,85 @@
+package record
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+
+	"chunkymonkey/proto"
+)
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() os.Error {
+	return nil
+}
+
+// shortReader reads at most n bytes at a time, so that packets are split
+// across records.
+type shortReader struct {
+	reader io.Reader
+	n      int
+}
+
+func (r *shortReader) Read(p []byte) (int, os.Error) {
+	if len(p) > r.n {
+		p = p[:r.n]
+	}
+	return r.reader.Read(p)
+}
+
+func TestReadSession(t *testing.T) {
+	codec, _ := proto.CodecForVersion(proto.ProtocolVersionBeta18)
+
+	sent := new(bytes.Buffer)
+	proto.ClientWriteHandshake(sent, "alice")
+	codec.ClientWriteLogin(sent, "alice")
+	proto.WriteChatMessage(sent, "hello")
+	proto.WriteDisconnect(sent, "Quitting")
+
+	after := new(bytes.Buffer)
+	proto.WriteChatMessage(after, "hello")
+	proto.WriteDisconnect(after, "Quitting")
+
+	log := new(bytes.Buffer)
+	recorder := NewReaderRecorder(nopCloser{log}, &shortReader{sent, 7})
+	if _, err := io.Copy(new(bytes.Buffer), recorder); err != nil {
+		t.Fatalf("Error recording: %v", err)
+	}
+
+	session, err := ReadSession(log)
+	if err != nil {
+		t.Fatalf("Error reading session: %v", err)
+	}
+
+	if session.Username != "alice" {
+		t.Errorf("Expected username alice, got %q", session.Username)
+	}
+	if session.Codec.Version != proto.ProtocolVersionBeta18 {
+		t.Errorf("Expected protocol version %d, got %d", proto.ProtocolVersionBeta18, session.Codec.Version)
+	}
+
+	replayed := new(bytes.Buffer)
+	for _, record := range session.Records {
+		replayed.Write(record.Data)
+	}
+	if !bytes.Equal(replayed.Bytes(), after.Bytes()) {
+		t.Errorf("Expected records after login to be\n%x\ngot\n%x", after.Bytes(), replayed.Bytes())
+	}
+}
+
+func TestReadSessionTruncated(t *testing.T) {
+	sent := new(bytes.Buffer)
+	proto.ClientWriteHandshake(sent, "alice")
+
+	log := new(bytes.Buffer)
+	recorder := NewReaderRecorder(nopCloser{log}, sent)
+	io.Copy(new(bytes.Buffer), recorder)
+
+	if _, err := ReadSession(log); err == nil {
+		t.Errorf("Expected error reading session without a login")
+	}
+}
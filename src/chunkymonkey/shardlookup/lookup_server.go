@@ -0,0 +1,142 @@
+package shardlookup
+
+import (
+	"gob"
+	"log"
+	"net"
+	"os"
+
+	. "chunkymonkey/types"
+)
+
+// The wire protocol between RemoteLookup and LookupServer. The client sends a
+// gob-encoded lookupRequest for each call, and the server replies with a
+// lookupResponse.
+
+type lookupRequest struct {
+	Body iLookupReq
+}
+
+type lookupResponse struct {
+	ServerAddr string
+	Ok         bool
+	Err        string // Empty if there was no error.
+}
+
+type iLookupReq interface {
+	apply(lookup IShardLookup) (resp *lookupResponse)
+}
+
+func init() {
+	gob.Register(&reqHeartbeat{})
+	gob.Register(&reqLookup{})
+	gob.Register(&reqOwner{})
+	gob.Register(&reqAssign{})
+}
+
+func newLookupResponse(serverAddr string, ok bool, err os.Error) *lookupResponse {
+	resp := &lookupResponse{ServerAddr: serverAddr, Ok: ok}
+	if err != nil {
+		resp.Err = err.String()
+	}
+	return resp
+}
+
+// remoteError recreates the error that the server's IShardLookup returned.
+// Errors that this package defines are returned as themselves.
+func (resp *lookupResponse) remoteError() os.Error {
+	switch resp.Err {
+	case "":
+		return nil
+	case ErrNoServers.String():
+		return ErrNoServers
+	case ErrUnknownServer.String():
+		return ErrUnknownServer
+	}
+	return os.NewError(resp.Err)
+}
+
+type reqHeartbeat struct {
+	ServerAddr string
+}
+
+func (req *reqHeartbeat) apply(lookup IShardLookup) *lookupResponse {
+	return newLookupResponse("", true, lookup.Heartbeat(req.ServerAddr))
+}
+
+type reqLookup struct {
+	Dimension DimensionId
+	ShardLoc  ShardXz
+}
+
+func (req *reqLookup) apply(lookup IShardLookup) *lookupResponse {
+	serverAddr, err := lookup.Lookup(req.Dimension, req.ShardLoc)
+	return newLookupResponse(serverAddr, true, err)
+}
+
+type reqOwner struct {
+	Dimension DimensionId
+	ShardLoc  ShardXz
+}
+
+func (req *reqOwner) apply(lookup IShardLookup) *lookupResponse {
+	serverAddr, ok, err := lookup.Owner(req.Dimension, req.ShardLoc)
+	return newLookupResponse(serverAddr, ok, err)
+}
+
+type reqAssign struct {
+	Dimension  DimensionId
+	ShardLoc   ShardXz
+	ServerAddr string
+}
+
+func (req *reqAssign) apply(lookup IShardLookup) *lookupResponse {
+	return newLookupResponse("", true, lookup.Assign(req.Dimension, req.ShardLoc, req.ServerAddr))
+}
+
+// LookupServer serves an IShardLookup (typically a MemLookup) to
+// RemoteLookups in frontend and chunk servers.
+type LookupServer struct {
+	lookup IShardLookup
+}
+
+func NewLookupServer(lookup IShardLookup) *LookupServer {
+	return &LookupServer{
+		lookup: lookup,
+	}
+}
+
+// Serve accepts connections on the listener until it fails.
+func (server *LookupServer) Serve(listener net.Listener) os.Error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		go server.serveConn(conn)
+	}
+	return nil
+}
+
+func (server *LookupServer) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	decoder := gob.NewDecoder(conn)
+	encoder := gob.NewEncoder(conn)
+
+	for {
+		var req lookupRequest
+		if err := decoder.Decode(&req); err != nil {
+			if err != os.EOF {
+				log.Printf("LookupServer: error reading from %v: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+
+		if err := encoder.Encode(req.Body.apply(server.lookup)); err != nil {
+			log.Printf("LookupServer: error writing to %v: %v", conn.RemoteAddr(), err)
+			return
+		}
+	}
+}
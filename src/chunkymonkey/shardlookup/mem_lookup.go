@@ -0,0 +1,144 @@
+package shardlookup
+
+import (
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	. "chunkymonkey/types"
+)
+
+type serverLease struct {
+	expires   int64 // In nanoseconds since the epoch.
+	numShards int
+}
+
+// MemLookup implements IShardLookup, holding the assignments of shards in
+// memory.
+type MemLookup struct {
+	leaseTime int64 // In nanoseconds.
+	now       func() int64
+
+	lock    sync.Mutex
+	servers map[string]*serverLease
+	owners  map[DimensionId]map[uint64]string
+}
+
+// NewMemLookup creates a MemLookup that grants leases lasting leaseTime
+// nanoseconds from each heartbeat.
+func NewMemLookup(leaseTime int64) *MemLookup {
+	return &MemLookup{
+		leaseTime: leaseTime,
+		now:       time.Nanoseconds,
+		servers:   make(map[string]*serverLease),
+		owners:    make(map[DimensionId]map[uint64]string),
+	}
+}
+
+func (lookup *MemLookup) Heartbeat(serverAddr string) os.Error {
+	lookup.lock.Lock()
+	defer lookup.lock.Unlock()
+
+	lease, ok := lookup.servers[serverAddr]
+	if !ok {
+		lease = new(serverLease)
+		lookup.servers[serverAddr] = lease
+	}
+	lease.expires = lookup.now() + lookup.leaseTime
+
+	return nil
+}
+
+func (lookup *MemLookup) Lookup(dimension DimensionId, shardLoc ShardXz) (serverAddr string, err os.Error) {
+	lookup.lock.Lock()
+	defer lookup.lock.Unlock()
+
+	if serverAddr, ok := lookup.owner(dimension, &shardLoc); ok {
+		return serverAddr, nil
+	}
+
+	if serverAddr, err = lookup.leastLoadedServer(); err != nil {
+		return
+	}
+	lookup.assign(dimension, &shardLoc, serverAddr)
+
+	return
+}
+
+func (lookup *MemLookup) Owner(dimension DimensionId, shardLoc ShardXz) (serverAddr string, ok bool, err os.Error) {
+	lookup.lock.Lock()
+	defer lookup.lock.Unlock()
+
+	serverAddr, ok = lookup.owner(dimension, &shardLoc)
+	return
+}
+
+func (lookup *MemLookup) Assign(dimension DimensionId, shardLoc ShardXz, serverAddr string) os.Error {
+	lookup.lock.Lock()
+	defer lookup.lock.Unlock()
+
+	if !lookup.isLive(serverAddr) {
+		return ErrUnknownServer
+	}
+	lookup.assign(dimension, &shardLoc, serverAddr)
+
+	return nil
+}
+
+// isLive returns true if the server holds an unexpired lease.
+func (lookup *MemLookup) isLive(serverAddr string) bool {
+	lease, ok := lookup.servers[serverAddr]
+	return ok && lease.expires > lookup.now()
+}
+
+func (lookup *MemLookup) owner(dimension DimensionId, shardLoc *ShardXz) (serverAddr string, ok bool) {
+	if serverAddr, ok = lookup.owners[dimension][shardLoc.Key()]; !ok {
+		return
+	}
+	ok = lookup.isLive(serverAddr)
+	return
+}
+
+func (lookup *MemLookup) assign(dimension DimensionId, shardLoc *ShardXz, serverAddr string) {
+	owners, ok := lookup.owners[dimension]
+	if !ok {
+		owners = make(map[uint64]string)
+		lookup.owners[dimension] = owners
+	}
+
+	shardKey := shardLoc.Key()
+	if oldAddr, ok := owners[shardKey]; ok {
+		lookup.servers[oldAddr].numShards--
+	}
+	owners[shardKey] = serverAddr
+	lookup.servers[serverAddr].numShards++
+}
+
+// leastLoadedServer returns the live server that owns the fewest shards.
+// Servers are considered in order of address, so that the choice is
+// predictable.
+func (lookup *MemLookup) leastLoadedServer() (serverAddr string, err os.Error) {
+	addrs := make([]string, 0, len(lookup.servers))
+	for addr := range lookup.servers {
+		addrs = append(addrs, addr)
+	}
+	sort.SortStrings(addrs)
+
+	var best *serverLease
+	for _, addr := range addrs {
+		if !lookup.isLive(addr) {
+			continue
+		}
+		if lease := lookup.servers[addr]; best == nil || lease.numShards < best.numShards {
+			best = lease
+			serverAddr = addr
+		}
+	}
+
+	if best == nil {
+		return "", ErrNoServers
+	}
+
+	return
+}
@@ -0,0 +1,98 @@
+package shardlookup
+
+import (
+	"gob"
+	"net"
+	"os"
+	"sync"
+
+	. "chunkymonkey/types"
+)
+
+// RemoteLookup implements IShardLookup for a LookupServer in another process.
+// It connects to the server when first used, and again on the next call after
+// the connection fails.
+type RemoteLookup struct {
+	addr string
+
+	// lock guards the connection, and is held for the duration of each call.
+	lock    sync.Mutex
+	conn    net.Conn
+	encoder *gob.Encoder
+	decoder *gob.Decoder
+}
+
+// NewRemoteLookup creates a RemoteLookup for the LookupServer at the given
+// address.
+func NewRemoteLookup(addr string) *RemoteLookup {
+	return &RemoteLookup{
+		addr: addr,
+	}
+}
+
+// Close disconnects from the LookupServer.
+func (lookup *RemoteLookup) Close() {
+	lookup.lock.Lock()
+	defer lookup.lock.Unlock()
+
+	lookup.disconnect()
+}
+
+func (lookup *RemoteLookup) disconnect() {
+	if lookup.conn != nil {
+		lookup.conn.Close()
+		lookup.conn = nil
+	}
+}
+
+// call sends a request to the LookupServer and waits for its response.
+func (lookup *RemoteLookup) call(req iLookupReq) (resp *lookupResponse, err os.Error) {
+	lookup.lock.Lock()
+	defer lookup.lock.Unlock()
+
+	if lookup.conn == nil {
+		if lookup.conn, err = net.Dial("tcp", lookup.addr); err != nil {
+			lookup.conn = nil
+			return
+		}
+		lookup.encoder = gob.NewEncoder(lookup.conn)
+		lookup.decoder = gob.NewDecoder(lookup.conn)
+	}
+
+	resp = new(lookupResponse)
+	if err = lookup.encoder.Encode(&lookupRequest{req}); err == nil {
+		err = lookup.decoder.Decode(resp)
+	}
+	if err != nil {
+		lookup.disconnect()
+		return nil, err
+	}
+
+	return resp, resp.remoteError()
+}
+
+func (lookup *RemoteLookup) Heartbeat(serverAddr string) (err os.Error) {
+	_, err = lookup.call(&reqHeartbeat{serverAddr})
+	return
+}
+
+func (lookup *RemoteLookup) Lookup(dimension DimensionId, shardLoc ShardXz) (serverAddr string, err os.Error) {
+	resp, err := lookup.call(&reqLookup{dimension, shardLoc})
+	if err != nil {
+		return
+	}
+	return resp.ServerAddr, nil
+}
+
+func (lookup *RemoteLookup) Owner(dimension DimensionId, shardLoc ShardXz) (serverAddr string, ok bool, err os.Error) {
+	resp, err := lookup.call(&reqOwner{dimension, shardLoc})
+	if err != nil {
+		return
+	}
+	return resp.ServerAddr, resp.Ok, nil
+}
+
+func (lookup *RemoteLookup) Assign(dimension DimensionId, shardLoc ShardXz, serverAddr string) (err os.Error) {
+	_, err = lookup.call(&reqAssign{dimension, shardLoc, serverAddr})
+	return
+}
@@ -0,0 +1,42 @@
+// The shardlookup package allows frontend and chunk servers to find the chunk
+// server that hosts a given shard.
+//
+// Chunk servers hold a lease with the lookup service, which they keep alive by
+// sending heartbeats. Shards are assigned to chunk servers with live leases as
+// they are first looked up, and are reassigned if the lease of the server that
+// owns them expires (typically because the server has failed).
+package shardlookup
+
+import (
+	"os"
+
+	. "chunkymonkey/types"
+)
+
+var (
+	ErrNoServers     = os.NewError("no chunk servers are available")
+	ErrUnknownServer = os.NewError("chunk server does not hold a lease")
+)
+
+// IShardLookup is the interface to the lookup service. MemLookup implements
+// it in the local process, and RemoteLookup implements it for a LookupServer in
+// another process.
+type IShardLookup interface {
+	// Heartbeat takes out or renews the lease of the chunk server at the given
+	// address. Shards are only assigned to servers that hold a lease.
+	Heartbeat(serverAddr string) os.Error
+
+	// Lookup returns the address of the chunk server that owns the given shard.
+	// If the shard has no owner, or its owner's lease has expired, it is first
+	// assigned to the server with a lease that owns the fewest shards.
+	Lookup(dimension DimensionId, shardLoc ShardXz) (serverAddr string, err os.Error)
+
+	// Owner returns the address of the chunk server that owns the given
+	// shard, without assigning it. ok is false if it has no owner with a
+	// lease.
+	Owner(dimension DimensionId, shardLoc ShardXz) (serverAddr string, ok bool, err os.Error)
+
+	// Assign makes the chunk server at the given address the owner of the
+	// given shard, e.g when moving a shard between servers.
+	Assign(dimension DimensionId, shardLoc ShardXz, serverAddr string) os.Error
+}
@@ -0,0 +1,130 @@
+package shardlookup
+
+import (
+	"net"
+	"testing"
+
+	. "chunkymonkey/types"
+)
+
+const testLeaseTime = 10 * NanosecondsInSecond
+
+// newTestMemLookup creates a MemLookup with a clock that the test controls.
+func newTestMemLookup() (lookup *MemLookup, clock *int64) {
+	clock = new(int64)
+	lookup = NewMemLookup(testLeaseTime)
+	lookup.now = func() int64 {
+		return *clock
+	}
+	return
+}
+
+func checkLookup(t *testing.T, lookup IShardLookup, shardLoc ShardXz, expected string) {
+	serverAddr, err := lookup.Lookup(DimensionNormal, shardLoc)
+	if err != nil {
+		t.Errorf("Lookup %v: %v", shardLoc, err)
+	} else if serverAddr != expected {
+		t.Errorf("Lookup %v: expected %q, got %q", shardLoc, expected, serverAddr)
+	}
+}
+
+func TestMemLookupNoServers(t *testing.T) {
+	lookup, _ := newTestMemLookup()
+
+	if _, err := lookup.Lookup(DimensionNormal, ShardXz{0, 0}); err != ErrNoServers {
+		t.Errorf("expected ErrNoServers, got %v", err)
+	}
+	if err := lookup.Assign(DimensionNormal, ShardXz{0, 0}, "a:1"); err != ErrUnknownServer {
+		t.Errorf("expected ErrUnknownServer, got %v", err)
+	}
+}
+
+func TestMemLookupAssignsToLeastLoaded(t *testing.T) {
+	lookup, _ := newTestMemLookup()
+	lookup.Heartbeat("a:1")
+	lookup.Heartbeat("b:1")
+
+	checkLookup(t, lookup, ShardXz{0, 0}, "a:1")
+	checkLookup(t, lookup, ShardXz{0, 1}, "b:1")
+	checkLookup(t, lookup, ShardXz{1, 0}, "a:1")
+
+	// Shards keep their owners.
+	checkLookup(t, lookup, ShardXz{0, 0}, "a:1")
+	checkLookup(t, lookup, ShardXz{0, 1}, "b:1")
+
+	// The same shard location in another dimension is a different shard.
+	if serverAddr, _ := lookup.Lookup(DimensionNether, ShardXz{0, 0}); serverAddr != "b:1" {
+		t.Errorf("expected nether shard to be assigned to b:1, got %q", serverAddr)
+	}
+}
+
+func TestMemLookupReassignsOnLeaseExpiry(t *testing.T) {
+	lookup, clock := newTestMemLookup()
+	lookup.Heartbeat("a:1")
+	checkLookup(t, lookup, ShardXz{0, 0}, "a:1")
+
+	// b joins, and a stops sending heartbeats.
+	*clock += testLeaseTime / 2
+	lookup.Heartbeat("b:1")
+	checkLookup(t, lookup, ShardXz{0, 0}, "a:1")
+
+	*clock += testLeaseTime / 2
+	if serverAddr, ok, err := lookup.Owner(DimensionNormal, ShardXz{0, 0}); err != nil || ok {
+		t.Errorf("expected no owner after lease expiry, got %q, %t, %v", serverAddr, ok, err)
+	}
+	checkLookup(t, lookup, ShardXz{0, 0}, "b:1")
+
+	// a coming back doesn't take the shard back.
+	lookup.Heartbeat("a:1")
+	checkLookup(t, lookup, ShardXz{0, 0}, "b:1")
+}
+
+func TestMemLookupAssign(t *testing.T) {
+	lookup, _ := newTestMemLookup()
+	lookup.Heartbeat("a:1")
+	lookup.Heartbeat("b:1")
+	checkLookup(t, lookup, ShardXz{0, 0}, "a:1")
+
+	if err := lookup.Assign(DimensionNormal, ShardXz{0, 0}, "b:1"); err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+	if serverAddr, ok, err := lookup.Owner(DimensionNormal, ShardXz{0, 0}); err != nil || !ok || serverAddr != "b:1" {
+		t.Errorf("expected owner b:1, got %q, %t, %v", serverAddr, ok, err)
+	}
+
+	// a no longer owns any shards, so is given the next one.
+	checkLookup(t, lookup, ShardXz{5, 5}, "a:1")
+}
+
+func TestRemoteLookup(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer listener.Close()
+
+	memLookup, _ := newTestMemLookup()
+	go NewLookupServer(memLookup).Serve(listener)
+
+	lookup := NewRemoteLookup(listener.Addr().String())
+	defer lookup.Close()
+
+	if _, err := lookup.Lookup(DimensionNormal, ShardXz{0, 0}); err != ErrNoServers {
+		t.Errorf("expected ErrNoServers, got %v", err)
+	}
+
+	if err := lookup.Heartbeat("a:1"); err != nil {
+		t.Fatalf("Heartbeat: %v", err)
+	}
+	checkLookup(t, lookup, ShardXz{0, 0}, "a:1")
+
+	if serverAddr, ok, err := lookup.Owner(DimensionNormal, ShardXz{0, 0}); err != nil || !ok || serverAddr != "a:1" {
+		t.Errorf("expected owner a:1, got %q, %t, %v", serverAddr, ok, err)
+	}
+	if _, ok, err := lookup.Owner(DimensionNormal, ShardXz{1, 1}); err != nil || ok {
+		t.Errorf("expected no owner, got %t, %v", ok, err)
+	}
+	if err := lookup.Assign(DimensionNormal, ShardXz{0, 0}, "b:1"); err != ErrUnknownServer {
+		t.Errorf("expected ErrUnknownServer, got %v", err)
+	}
+}
@@ -0,0 +1,168 @@
+package shardserver
+
+import (
+	"chunkymonkey/gamerules"
+	. "chunkymonkey/types"
+)
+
+// placeBed places the two blocks of a bed, with the foot at the given location
+// and the head in the given direction from it. Both blocks must be replaceable
+// and rest on solid blocks. Returns true if the bed was placed.
+func (chunk *Chunk) placeBed(footLoc *BlockXyz, direction byte) bool {
+	direction &= gamerules.BedDataDirectionMask
+	dx, dz := gamerules.BedHeadOffset(direction)
+	headLoc := footLoc.AddXyz(dx, 0, dz)
+	if headLoc == nil {
+		return false
+	}
+
+	shard := chunk.shard
+	for _, loc := range []*BlockXyz{footLoc, headLoc} {
+		blockId, ok := shard.blockIdAt(*loc)
+		if !ok {
+			return false
+		}
+		if blockType, ok := gamerules.Blocks.Get(blockId); !ok || !blockType.Replaceable {
+			return false
+		}
+
+		belowLoc := loc.AddXyz(0, -1, 0)
+		if belowLoc == nil {
+			return false
+		}
+		belowId, ok := shard.blockIdAt(*belowLoc)
+		if !ok {
+			return false
+		}
+		if blockType, ok := gamerules.Blocks.Get(belowId); !ok || !blockType.Solid {
+			return false
+		}
+	}
+
+	shard.setBlockAt(*footLoc, BlockIdBed, direction)
+	shard.setBlockAt(*headLoc, BlockIdBed, direction|gamerules.BedDataHead)
+
+	return true
+}
+
+// bedOccupant is kept as the block extra of the head of an occupied bed. It is
+// registered with the head's chunk to take the sleeper out of the bed if they
+// unsubscribe from the chunk without leaving it, such as by disconnecting.
+type bedOccupant struct {
+	chunk    *Chunk
+	headLoc  BlockXyz
+	entityId EntityId
+}
+
+func (occupant *bedOccupant) Unsubscribed(entityId EntityId) {
+	if occupant.chunk.bedOccupant(&occupant.headLoc) == occupant {
+		occupant.chunk.shard.setBedOccupied(occupant.headLoc, false)
+	}
+}
+
+// bedOccupant returns the occupant of the bed whose head is at headLoc, which
+// must be in the chunk, or nil if the bed is empty.
+func (chunk *Chunk) bedOccupant(headLoc *BlockXyz) *bedOccupant {
+	index, _, ok := chunk.getBlockIndexByBlockXyz(headLoc)
+	if !ok {
+		return nil
+	}
+	occupant, _ := chunk.blockExtra[index].(*bedOccupant)
+	return occupant
+}
+
+// interactBed puts the player in the bed that they used, if it is night and
+// nobody else is in it. The bed is marked as occupied until they leave it.
+func (chunk *Chunk) interactBed(player gamerules.IPlayerClient, instance *gamerules.BlockInstance) {
+	if !chunk.shard.worldTime.IsNight() {
+		player.EchoMessage("You can only sleep at night")
+		return
+	}
+
+	headLoc := &instance.BlockLoc
+	if instance.Data&gamerules.BedDataHead == 0 {
+		dx, dz := gamerules.BedHeadOffset(instance.Data)
+		if headLoc = headLoc.AddXyz(dx, 0, dz); headLoc == nil {
+			return
+		}
+	}
+
+	headChunk := chunk.shard.chunkAt(*headLoc.ToChunkXz())
+	if headChunk == nil {
+		return
+	}
+
+	// The occupied flag is only trusted while the bed has an occupant, as
+	// occupants aren't kept when the chunk is stored or handed over.
+	if instance.Data&gamerules.BedDataOccupied != 0 && headChunk.bedOccupant(headLoc) != nil {
+		player.EchoMessage("This bed is occupied")
+		return
+	}
+
+	if !chunk.shard.setBedOccupied(*headLoc, true) {
+		return
+	}
+
+	occupant := &bedOccupant{
+		chunk:    headChunk,
+		headLoc:  *headLoc,
+		entityId: player.GetEntityId(),
+	}
+	index, _, _ := headChunk.getBlockIndexByBlockXyz(headLoc)
+	headChunk.blockExtra[index] = occupant
+	headChunk.AddOnUnsubscribe(occupant.entityId, occupant)
+
+	player.Sleep(*headLoc)
+}
+
+// reqLeaveBed takes the player out of the bed whose head is at headLoc, unless
+// someone else is in it.
+func (chunk *Chunk) reqLeaveBed(player gamerules.IPlayerClient, headLoc *BlockXyz) {
+	occupant := chunk.bedOccupant(headLoc)
+	if occupant != nil {
+		if occupant.entityId != player.GetEntityId() {
+			return
+		}
+		chunk.RemoveOnUnsubscribe(occupant.entityId, occupant)
+	}
+
+	chunk.shard.setBedOccupied(*headLoc, false)
+}
+
+// reqCheckBed tells the player if the bed whose head is at headLoc is gone.
+func (chunk *Chunk) reqCheckBed(player gamerules.IPlayerClient, headLoc *BlockXyz) {
+	chunkLoc, subLoc := headLoc.ToChunkLocal()
+	blockId, data, ok := chunk.shard.blockDataQuery(*chunkLoc, subLoc)
+	if ok && (blockId != BlockIdBed || data&gamerules.BedDataHead == 0) {
+		player.BedMissing(*headLoc)
+	}
+}
+
+// setBedOccupied sets or clears the occupied flag on both halves of the bed
+// whose head is at headLoc. Setting the blocks removes any occupant. Returns
+// true if the bed was found.
+func (shard *ChunkShard) setBedOccupied(headLoc BlockXyz, occupied bool) bool {
+	chunkLoc, subLoc := headLoc.ToChunkLocal()
+	blockId, data, ok := shard.blockDataQuery(*chunkLoc, subLoc)
+	if !ok || blockId != BlockIdBed || data&gamerules.BedDataHead == 0 {
+		return false
+	}
+
+	dx, dz := gamerules.BedHeadOffset(data)
+	footLoc := headLoc.AddXyz(-dx, 0, -dz)
+	if footLoc == nil {
+		return false
+	}
+	if footId, ok := shard.blockIdAt(*footLoc); !ok || footId != BlockIdBed {
+		return false
+	}
+
+	data &^= gamerules.BedDataOccupied
+	if occupied {
+		data |= gamerules.BedDataOccupied
+	}
+	shard.setBlockAt(headLoc, BlockIdBed, data)
+	shard.setBlockAt(*footLoc, BlockIdBed, data&^gamerules.BedDataHead)
+
+	return true
+}
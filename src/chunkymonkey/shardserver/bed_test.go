@@ -0,0 +1,100 @@
+package shardserver
+
+import (
+	"testing"
+
+	"chunkymonkey/gamerules"
+	. "chunkymonkey/types"
+)
+
+type testSleepPlayerClient struct {
+	gamerules.IPlayerClient
+	entityId EntityId
+	slept    []BlockXyz
+	missing  []BlockXyz
+	messages []string
+}
+
+func (player *testSleepPlayerClient) GetEntityId() EntityId {
+	return player.entityId
+}
+
+func (player *testSleepPlayerClient) Sleep(bedLoc BlockXyz) {
+	player.slept = append(player.slept, bedLoc)
+}
+
+func (player *testSleepPlayerClient) BedMissing(bedLoc BlockXyz) {
+	player.missing = append(player.missing, bedLoc)
+}
+
+func (player *testSleepPlayerClient) EchoMessage(msg string) {
+	player.messages = append(player.messages, msg)
+}
+
+func checkBedOccupied(t *testing.T, shard *ChunkShard, footLoc, headLoc BlockXyz, expected bool) {
+	for _, loc := range []BlockXyz{footLoc, headLoc} {
+		chunkLoc, subLoc := loc.ToChunkLocal()
+		_, data, _ := shard.blockDataQuery(*chunkLoc, subLoc)
+		if occupied := data&gamerules.BedDataOccupied != 0; occupied != expected {
+			t.Errorf("Expected bed block at %v to be occupied=%v, got data %#x", loc, expected, data)
+		}
+	}
+}
+
+func TestBedOccupancy(t *testing.T) {
+	chunkLoc := ChunkXz{0, 0}
+	shard := NewChunkShard(nil, nil, nil, ShardXz{0, 0}, TimeOfDayDuskEnd, WeatherClear)
+	chunk := newChunkFromNbt(testChunkNbt(chunkLoc), shard)
+	chunkIndex, _, _, _ := shard.chunkIndexAndRelLoc(chunkLoc)
+	shard.chunks[chunkIndex] = chunk
+
+	// A bed with its head towards positive Z.
+	footLoc := BlockXyz{4, 64, 4}
+	headLoc := BlockXyz{4, 64, 5}
+	shard.setBlockAt(footLoc, BlockIdBed, 0)
+	shard.setBlockAt(headLoc, BlockIdBed, gamerules.BedDataHead)
+
+	alice := &testSleepPlayerClient{entityId: 1}
+	bob := &testSleepPlayerClient{entityId: 2}
+	chunk.subscribers[alice.entityId] = alice
+	chunk.subscribers[bob.entityId] = bob
+
+	useBed := func(player *testSleepPlayerClient) {
+		chunkLoc, subLoc := footLoc.ToChunkLocal()
+		_, data, _ := shard.blockDataQuery(*chunkLoc, subLoc)
+		chunk.interactBed(player, &gamerules.BlockInstance{Chunk: chunk, BlockLoc: footLoc, Data: data})
+	}
+
+	useBed(alice)
+	if len(alice.slept) != 1 || alice.slept[0].X != headLoc.X || alice.slept[0].Y != headLoc.Y || alice.slept[0].Z != headLoc.Z {
+		t.Fatalf("Expected alice to sleep in the bed at %v, got %v", headLoc, alice.slept)
+	}
+	checkBedOccupied(t, shard, footLoc, headLoc, true)
+
+	// Nobody else can get into the bed, or take alice out of it.
+	useBed(bob)
+	if len(bob.slept) != 0 || len(bob.messages) != 1 {
+		t.Errorf("Expected bob to be told that the bed is occupied, got slept=%v messages=%v", bob.slept, bob.messages)
+	}
+	chunk.reqLeaveBed(bob, &headLoc)
+	checkBedOccupied(t, shard, footLoc, headLoc, true)
+
+	chunk.reqLeaveBed(alice, &headLoc)
+	checkBedOccupied(t, shard, footLoc, headLoc, false)
+
+	// The bed is left if its sleeper unsubscribes without getting out of it.
+	useBed(bob)
+	if len(bob.slept) != 1 {
+		t.Fatalf("Expected bob to sleep in the empty bed, got %v", bob.slept)
+	}
+	chunk.reqUnsubscribeChunk(bob.entityId, false)
+	checkBedOccupied(t, shard, footLoc, headLoc, false)
+
+	// Players only hear about beds that have gone.
+	chunk.reqCheckBed(alice, &headLoc)
+	shard.setBlockAt(headLoc, BlockIdAir, 0)
+	chunk.reqCheckBed(alice, &headLoc)
+	if len(alice.missing) != 1 {
+		t.Errorf("Expected alice to be told once that the bed is missing, got %v", alice.missing)
+	}
+}
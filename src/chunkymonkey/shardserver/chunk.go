@@ -13,6 +13,20 @@ import (
 	. "chunkymonkey/types"
 )
 
+// The chance (1 in N) of a lightning strike setting fire to the block it hits.
+const lightningFireChance = 2
+
+// maxBlockChangesPerPacket is the most blocks that a multiple block change
+// packet tells of.
+const maxBlockChangesPerPacket = 1 << 12
+
+// chunkPacketWaiter is a subscriber waiting for the chunk packet to be
+// compressed.
+type chunkPacketWaiter struct {
+	player gamerules.IPlayerClient
+	notify bool // Whether to call NotifyChunkLoad once it is sent.
+}
+
 // A chunk is slice of the world map.
 type Chunk struct {
 	shard        *ChunkShard
@@ -27,11 +41,27 @@ type Chunk struct {
 	rand         *rand.Rand
 	cachedPacket []byte                                 // Cached packet data for this chunk.
 	subscribers  map[EntityId]gamerules.IPlayerClient   // Players getting updates from the chunk.
+	viewers      map[EntityId]gamerules.IPlayerClient   // Subscribers shown the chunk's entities.
 	playersData  map[EntityId]*playerData               // Some player data for player(s) in the chunk.
 	onUnsub      map[EntityId][]gamerules.IUnsubscribed // Functions to be called when unsubscribed.
 
 	activeBlocks    map[BlockIndex]bool // Blocks that need to "tick".
 	newActiveBlocks map[BlockIndex]bool // Blocks added as active for next "tick".
+	changedBlocks   map[BlockIndex]bool // Blocks changed during the tick.
+
+	// The chunk packet is compressed in the background. blocksVersion is
+	// incremented whenever a block changes, so that a packet compressed from
+	// blocks that have since changed is not cached. changedSinceCompress
+	// holds the blocks whose changes have been sent to subscribers since the
+	// packet being compressed was taken, which those waiting for it are sent
+	// after it.
+	blocksVersion        int
+	compressing          bool
+	changedSinceCompress map[BlockIndex]bool
+	awaitingPacket       map[EntityId]chunkPacketWaiter
+
+	// The blocksVersion that was last written to the chunk store.
+	savedVersion int
 }
 
 func newChunkFromReader(reader chunkstore.IChunkReader, shard *ChunkShard) (chunk *Chunk) {
@@ -47,11 +77,15 @@ func newChunkFromReader(reader chunkstore.IChunkReader, shard *ChunkShard) (chun
 		blockExtra:  make(map[BlockIndex]interface{}),
 		rand:        rand.New(rand.NewSource(time.UTC().Seconds())),
 		subscribers: make(map[EntityId]gamerules.IPlayerClient),
+		viewers:     make(map[EntityId]gamerules.IPlayerClient),
 		playersData: make(map[EntityId]*playerData),
 		onUnsub:     make(map[EntityId][]gamerules.IUnsubscribed),
 
 		activeBlocks:    make(map[BlockIndex]bool),
 		newActiveBlocks: make(map[BlockIndex]bool),
+		changedBlocks:   make(map[BlockIndex]bool),
+
+		awaitingPacket: make(map[EntityId]chunkPacketWaiter),
 	}
 
 	chunk.addEntities(reader.Entities())
@@ -68,18 +102,15 @@ func (chunk *Chunk) setBlock(blockLoc *BlockXyz, subLoc *SubChunkXyz, index Bloc
 
 	// Invalidate cached packet.
 	chunk.cachedPacket = nil
+	chunk.blocksVersion++
 
 	index.SetBlockId(chunk.blocks, blockType)
 	index.SetBlockData(chunk.blockData, blockData)
 
 	chunk.blockExtra[index] = nil, false
 
-	// Tell players that the block changed.
-	packet := new(bytes.Buffer)
-	proto.WriteBlockChange(packet, blockLoc, blockType, blockData)
-	chunk.reqMulticastPlayers(-1, packet.Bytes())
-
-	return
+	// Players are told that the block changed at the end of the tick.
+	chunk.changedBlocks[index] = true
 }
 
 func (chunk *Chunk) blockId(index BlockIndex) BlockId {
@@ -102,14 +133,42 @@ func (chunk *Chunk) Rand() *rand.Rand {
 	return chunk.rand
 }
 
+// SkyLight returns the current level of light from the sky, taking into
+// account the time of day and the weather.
+func (chunk *Chunk) SkyLight() byte {
+	return chunk.shard.skyLight()
+}
+
+// SkyLightAt returns the current level of light from the sky reaching the
+// given block. The stored sky light is the level at full daylight, so it is
+// reduced by however far the sky is below full brightness.
+func (chunk *Chunk) SkyLightAt(blockIndex BlockIndex) byte {
+	stored := blockIndex.BlockData(chunk.skyLight)
+	darkening := LightLevelMax - chunk.SkyLight()
+	if stored <= darkening {
+		return 0
+	}
+	return stored - darkening
+}
+
+func (chunk *Chunk) SetBlockAt(blockLoc *BlockXyz, blockId BlockId, blockData byte) bool {
+	return chunk.shard.setBlockAt(*blockLoc, blockId, blockData)
+}
+
 func (chunk *Chunk) ItemType(itemTypeId ItemTypeId) (itemType *gamerules.ItemType, ok bool) {
 	itemType, ok = gamerules.Items[itemTypeId]
 	return
 }
 
-// Tells the chunk to take posession of the item/mob from another chunk.
+// Tells the chunk to take posession of the item/mob from another chunk. The
+// sending chunk released the entity's EntityId, which may have come from
+// another process, so it is reserved here.
 func (chunk *Chunk) transferEntity(s gamerules.INonPlayerEntity) {
-	chunk.entities[s.GetEntityId()] = s
+	entityId := chunk.shard.reserveEntityId(s.GetEntityId())
+	s.SetEntityId(entityId)
+
+	chunk.entities[entityId] = s
+	chunk.showEntity(s)
 }
 
 // AddEntity creates a mob or item in this chunk and notifies all chunk
@@ -120,19 +179,39 @@ func (chunk *Chunk) AddEntity(s gamerules.INonPlayerEntity) {
 	chunk.entities[newEntityId] = s
 
 	// Spawn new item/mob for players.
-	buf := &bytes.Buffer{}
-	s.SendSpawn(buf)
-	chunk.reqMulticastPlayers(-1, buf.Bytes())
+	chunk.showEntity(s)
 }
 
 func (chunk *Chunk) removeEntity(s gamerules.INonPlayerEntity) {
 	e := s.GetEntityId()
+	if object, ok := s.(*gamerules.Object); ok {
+		if rider, ok := chunk.subscribers[object.Rider()]; ok {
+			rider.Dismount(e)
+		}
+	}
 	chunk.shard.entityMgr.RemoveEntityById(e)
 	chunk.entities[e] = nil, false
-	// Tell all subscribers that the spawn's entity is destroyed.
+	// Tell all viewers that the spawn's entity is destroyed.
+	chunk.hideEntity(e, -1)
+}
+
+// showEntity shows an entity that has entered the chunk to its viewers.
+func (chunk *Chunk) showEntity(s gamerules.INonPlayerEntity) {
 	buf := new(bytes.Buffer)
-	proto.WriteEntityDestroy(buf, e)
-	chunk.reqMulticastPlayers(-1, buf.Bytes())
+	s.SendSpawn(buf)
+	for _, viewer := range chunk.viewers {
+		viewer.ShowEntity(chunk.loc, s.GetEntityId(), buf.Bytes())
+	}
+}
+
+// hideEntity hides an entity that has left the chunk from its viewers, other
+// than the one given.
+func (chunk *Chunk) hideEntity(entityId EntityId, exclude EntityId) {
+	for viewerId, viewer := range chunk.viewers {
+		if viewerId != exclude {
+			viewer.HideEntity(chunk.loc, entityId)
+		}
+	}
 }
 
 func (chunk *Chunk) BlockExtra(index BlockIndex) interface{} {
@@ -231,7 +310,24 @@ func (chunk *Chunk) reqInteractBlock(player gamerules.IPlayerClient, held gameru
 		return
 	}
 
-	if _, isBlockHeld := held.ItemTypeId.ToBlockId(); isBlockHeld && blockType.Attachable {
+	if held.ItemTypeId == ItemTypeIdFlintAndSteel && blockType.Solid {
+		chunk.lightFire(target, againstFace)
+	} else if held.ItemTypeId == ItemTypeIdBed && blockType.Solid && againstFace == FaceTop {
+		// Beds can only be placed on top of blocks.
+		if destLoc := target.AddXyz(0, 1, 0); destLoc != nil {
+			player.PlaceHeldItem(*destLoc, held)
+		}
+	} else if _, isVehicle := vehicleItems[held.ItemTypeId]; isVehicle {
+		// Minecarts are placed on the rail itself, boats on top of the block.
+		destLoc := target
+		if _, isRail := blockType.Aspect.(*gamerules.RailAspect); !isRail {
+			dx, dy, dz := againstFace.Dxyz()
+			if destLoc = target.AddXyz(dx, dy, dz); destLoc == nil {
+				return
+			}
+		}
+		player.PlaceHeldItem(*destLoc, held)
+	} else if _, isBlockHeld := held.ItemTypeId.ToBlockId(); isBlockHeld && blockType.Attachable {
 		// The player is interacting with a block that can be attached to.
 
 		// Work out the position to put the block at.
@@ -243,6 +339,10 @@ func (chunk *Chunk) reqInteractBlock(player gamerules.IPlayerClient, held gameru
 		}
 
 		player.PlaceHeldItem(*destLoc, held)
+	} else if _, isBed := blockType.Aspect.(*gamerules.BedAspect); isBed {
+		// Getting into bed affects both of its halves, which may be in
+		// different chunks.
+		chunk.interactBed(player, blockInstance)
 	} else {
 		// Player is otherwise interacting with the block.
 		blockType.Aspect.Interact(blockInstance, player)
@@ -262,6 +362,20 @@ func (chunk *Chunk) reqPlaceItem(player gamerules.IPlayerClient, target *BlockXy
 	// items on farmland doesn't fit this current simplistic model). The block
 	// type for the block being placed against should probably contain this logic
 	// (i.e farmland block should know about the seed item).
+	if slot.ItemTypeId == ItemTypeIdBed && slot.Count > 0 {
+		if chunk.placeBed(target, byte(slot.Data)) {
+			slot.Decrement()
+		}
+		return
+	}
+
+	if objType, ok := vehicleItems[slot.ItemTypeId]; ok && slot.Count > 0 {
+		if chunk.placeVehicle(target, objType) {
+			slot.Decrement()
+		}
+		return
+	}
+
 	heldBlockType, ok := slot.ItemTypeId.ToBlockId()
 	if !ok || slot.Count < 1 {
 		// Not a placeable item.
@@ -280,8 +394,18 @@ func (chunk *Chunk) reqPlaceItem(player gamerules.IPlayerClient, target *BlockXy
 		return
 	}
 
+	blockData := byte(slot.Data)
+	if heldType, ok := gamerules.Blocks.Get(heldBlockType); ok {
+		if rail, ok := heldType.Aspect.(*gamerules.RailAspect); ok {
+			// Rails join up with the rails around them.
+			blockData = gamerules.RailShapeAt(*target, rail.CanCurve, func(loc BlockXyz) (BlockId, bool) {
+				return chunk.shard.blockIdAt(loc)
+			})
+		}
+	}
+
 	// Safe to replace block.
-	chunk.setBlock(target, subLoc, index, heldBlockType, byte(slot.Data))
+	chunk.setBlock(target, subLoc, index, heldBlockType, blockData)
 
 	slot.Decrement()
 }
@@ -291,12 +415,19 @@ func (chunk *Chunk) reqTakeItem(player gamerules.IPlayerClient, entityId EntityI
 		if item, ok := entity.(*gamerules.Item); ok {
 			player.GiveItemAtPosition(*item.Position(), *item.GetSlot())
 
-			// Tell all subscribers to animate the item flying at the
+			// Tell all viewers to animate the item flying at the
 			// player.
 			buf := new(bytes.Buffer)
 			proto.WriteItemCollect(buf, entityId, player.GetEntityId())
-			chunk.reqMulticastPlayers(-1, buf.Bytes())
+			chunk.multicastViewers(-1, buf.Bytes())
 			chunk.removeEntity(item)
+		} else if projectile, ok := entity.(*gamerules.Projectile); ok && projectile.CanPickUp() {
+			player.GiveItemAtPosition(*projectile.Position(), gamerules.Slot{ItemTypeId: projectile.PickUpItem(), Count: 1})
+
+			buf := new(bytes.Buffer)
+			proto.WriteItemCollect(buf, entityId, player.GetEntityId())
+			chunk.multicastViewers(-1, buf.Bytes())
+			chunk.removeEntity(projectile)
 		}
 	}
 }
@@ -379,6 +510,16 @@ func (chunk *Chunk) BlockQuery(blockLoc BlockXyz) (isSolid bool, isWithinChunk b
 	return
 }
 
+// BlockDataQuery reads the BlockId and metadata of a block that's either in
+// the chunk, or in a loaded chunk elsewhere in the shard.
+func (chunk *Chunk) BlockDataQuery(blockLoc BlockXyz) (blockId BlockId, blockData byte, ok bool) {
+	if blockLoc.Y < 0 {
+		return
+	}
+	chunkLoc, subLoc := blockLoc.ToChunkLocal()
+	return chunk.shard.blockDataQuery(*chunkLoc, subLoc)
+}
+
 func (chunk *Chunk) tick() {
 	chunk.spawnTick()
 
@@ -395,7 +536,31 @@ func (chunk *Chunk) spawnTick() {
 	outgoingEntities := []gamerules.INonPlayerEntity{}
 
 	for _, e := range chunk.entities {
-		if e.Tick(chunk) {
+		object, isObject := e.(*gamerules.Object)
+		isRidden := isObject && object.Rider() != NoEntityId
+		var before AbsXyz
+		if isRidden {
+			before = *e.Position()
+		}
+
+		if mob, ok := e.(gamerules.IMob); ok && mob.GetMob().MobType() == MobTypeIdSkeleton {
+			chunk.skeletonTick(mob.GetMob())
+		}
+
+		leftBlock := e.Tick(chunk)
+
+		if isRidden {
+			// The rider goes wherever the vehicle does.
+			after := e.Position()
+			chunk.moveRider(object, after.X != before.X || after.Y != before.Y || after.Z != before.Z)
+		}
+
+		if projectile, ok := e.(*gamerules.Projectile); ok && chunk.projectileTick(projectile) {
+			// The projectile hit something and is gone.
+			continue
+		}
+
+		if leftBlock {
 			if e.Position().Y <= 0 {
 				// Item or mob fell out of the world.
 				chunk.removeEntity(e)
@@ -408,8 +573,11 @@ func (chunk *Chunk) spawnTick() {
 	if len(outgoingEntities) > 0 {
 		// Transfer spawns to new chunk.
 		for _, e := range outgoingEntities {
-			// Remove mob/items from this chunk.
+			// Remove mob/items from this chunk. The receiving chunk reserves
+			// the EntityId again, which may be in another process.
 			chunk.entities[e.GetEntityId()] = nil, false
+			chunk.hideEntity(e.GetEntityId(), -1)
+			chunk.shard.entityMgr.RemoveEntityById(e.GetEntityId())
 
 			// Transfer to other chunk.
 			chunkLoc := e.Position().ToChunkXz()
@@ -475,8 +643,19 @@ func (chunk *Chunk) mobs() (s []*gamerules.Mob) {
 	s = make([]*gamerules.Mob, 0, 3)
 	for _, e := range chunk.entities {
 		switch e.(type) {
-		case *gamerules.Mob:
-			s = append(s, e.(*gamerules.Mob))
+		case gamerules.IMob:
+			s = append(s, e.(gamerules.IMob).GetMob())
+		}
+	}
+	return
+}
+
+func (chunk *Chunk) projectiles() (s []*gamerules.Projectile) {
+	s = make([]*gamerules.Projectile, 0, 3)
+	for _, e := range chunk.entities {
+		switch e.(type) {
+		case *gamerules.Projectile:
+			s = append(s, e.(*gamerules.Projectile))
 		}
 	}
 	return
@@ -503,37 +682,78 @@ func (chunk *Chunk) reqSubscribeChunk(entityId EntityId, player gamerules.IPlaye
 
 	buf := new(bytes.Buffer)
 	proto.WritePreChunk(buf, &chunk.loc, ChunkInit)
-	player.TransmitPacket(buf.Bytes())
+	player.TransmitPacket(buf.Bytes(), proto.PacketPriorityNormal)
 
-	player.TransmitPacket(chunk.chunkPacket())
+	if chunk.cachedPacket == nil {
+		chunk.awaitingPacket[entityId] = chunkPacketWaiter{player, notify}
+		chunk.compressPacket()
+		return
+	}
+
+	player.TransmitPacket(chunk.cachedPacket, proto.PacketPriorityNormal)
 	if notify {
 		player.NotifyChunkLoad()
 	}
+}
+
+// reqViewEntities shows the entities in the chunk to a subscriber, or stops
+// showing them. The subscriber hides them itself when it stops viewing.
+func (chunk *Chunk) reqViewEntities(entityId EntityId, view bool) {
+	player, ok := chunk.subscribers[entityId]
+	if !ok {
+		return
+	}
+
+	if !view {
+		chunk.viewers[entityId] = nil, false
+		return
+	}
+
+	if _, ok := chunk.viewers[entityId]; ok {
+		// Already viewing.
+		return
+	}
+	chunk.viewers[entityId] = player
 
-	// Send spawns packets for all entities in the chunk.
-	if len(chunk.entities) > 0 {
+	for _, e := range chunk.entities {
 		buf := new(bytes.Buffer)
-		for _, e := range chunk.entities {
-			e.SendSpawn(buf)
+		e.SendSpawn(buf)
+		player.ShowEntity(chunk.loc, e.GetEntityId(), buf.Bytes())
+	}
+
+	for _, existing := range chunk.playersData {
+		if existing.entityId != entityId {
+			buf := new(bytes.Buffer)
+			existing.sendSpawn(buf)
+			player.ShowEntity(chunk.loc, existing.entityId, buf.Bytes())
 		}
-		player.TransmitPacket(buf.Bytes())
 	}
+}
 
-	// Spawn existing players for new player.
-	if len(chunk.playersData) > 0 {
-		playersPacket := new(bytes.Buffer)
-		for _, existing := range chunk.playersData {
-			if existing.entityId != entityId {
-				existing.sendSpawn(playersPacket)
-			}
+// reqTeleportEntities sends a viewer the full positions of the entities in the
+// chunk, as last sent, after the client has missed some of their movement.
+func (chunk *Chunk) reqTeleportEntities(entityId EntityId) {
+	player, ok := chunk.viewers[entityId]
+	if !ok {
+		return
+	}
+
+	buf := chunk.shard.updateBuffer(entityId, player)
+	for _, e := range chunk.entities {
+		e.SendTeleport(buf)
+	}
+	for _, data := range chunk.playersData {
+		if data.entityId != entityId {
+			data.movement.SendTeleport(buf, data.entityId)
 		}
-		player.TransmitPacket(playersPacket.Bytes())
 	}
 }
 
 func (chunk *Chunk) reqUnsubscribeChunk(entityId EntityId, sendPacket bool) {
 	if player, ok := chunk.subscribers[entityId]; ok {
 		chunk.subscribers[entityId] = nil, false
+		chunk.viewers[entityId] = nil, false
+		chunk.awaitingPacket[entityId] = chunkPacketWaiter{}, false
 
 		// Call any observers registered with AddOnUnsubscribe.
 		if observers, ok := chunk.onUnsub[entityId]; ok {
@@ -546,8 +766,7 @@ func (chunk *Chunk) reqUnsubscribeChunk(entityId EntityId, sendPacket bool) {
 		if sendPacket {
 			buf := new(bytes.Buffer)
 			proto.WritePreChunk(buf, &chunk.loc, ChunkUnload)
-			// TODO send PacketEntityDestroy packets for spawns in this chunk.
-			player.TransmitPacket(buf.Bytes())
+			player.TransmitPacket(buf.Bytes(), proto.PacketPriorityNormal)
 		}
 	}
 }
@@ -583,10 +802,66 @@ func (chunk *Chunk) RemoveOnUnsubscribe(entityId EntityId, observer gamerules.IU
 	}
 }
 
-func (chunk *Chunk) reqMulticastPlayers(exclude EntityId, packet []byte) {
+// reqStrikeLightning strikes lightning onto the highest non-air block in the
+// given column, and possibly sets fire to the block above it.
+func (chunk *Chunk) reqStrikeLightning(x, z BlockCoord) {
+	_, subX := x.ToChunkLocalCoord()
+	_, subZ := z.ToChunkLocalCoord()
+
+	subLoc := SubChunkXyz{X: subX, Y: ChunkSizeY - 1, Z: subZ}
+	for ; subLoc.Y > 0; subLoc.Y-- {
+		index, ok := subLoc.BlockIndex()
+		if !ok {
+			return
+		}
+		if index.BlockId(chunk.blocks) != BlockIdAir {
+			break
+		}
+	}
+
+	strikeLoc := chunk.loc.ToBlockXyz(&subLoc)
+
+	// The thunderbolt is an entity as far as the client is concerned, but it
+	// only exists for the duration of the packet.
+	entityId := chunk.shard.entityMgr.NewEntity()
+	buf := new(bytes.Buffer)
+	proto.WriteWeather(buf, entityId, true, strikeLoc.ToAbsIntXyz())
+	chunk.reqMulticastPlayers(-1, buf.Bytes(), proto.PacketPriorityNormal)
+	chunk.shard.entityMgr.RemoveEntityById(entityId)
+
+	if subLoc.Y+1 >= ChunkSizeY || chunk.rand.Intn(lightningFireChance) != 0 {
+		return
+	}
+
+	index, ok := subLoc.BlockIndex()
+	if !ok {
+		return
+	}
+	if blockType, ok := gamerules.Blocks.Get(index.BlockId(chunk.blocks)); !ok || !blockType.Solid {
+		return
+	}
+
+	fireLoc := subLoc
+	fireLoc.Y++
+	if fireIndex, ok := fireLoc.BlockIndex(); ok {
+		chunk.SetBlockByIndex(fireIndex, BlockIdFire, 0)
+	}
+}
+
+func (chunk *Chunk) reqMulticastPlayers(exclude EntityId, packet []byte, priority proto.PacketPriority) {
 	for entityId, player := range chunk.subscribers {
 		if entityId != exclude {
-			player.TransmitPacket(packet)
+			player.TransmitPacket(packet, priority)
+		}
+	}
+}
+
+// multicastViewers sends a packet about the chunk's entities to the players
+// that they are shown to.
+func (chunk *Chunk) multicastViewers(exclude EntityId, packet []byte) {
+	for entityId, player := range chunk.viewers {
+		if entityId != exclude {
+			player.TransmitPacket(packet, proto.PacketPriorityNormal)
 		}
 	}
 }
@@ -600,22 +875,30 @@ func (chunk *Chunk) reqAddPlayerData(entityId EntityId, name string, pos AbsXyz,
 		look:       look,
 		heldItemId: held,
 	}
+	// Players viewing the chunk that the player moved from were sent its
+	// position from there.
+	newPlayerData.movement.Reset(pos.ToAbsIntXyz(), &look)
 	chunk.playersData[entityId] = newPlayerData
 
 	// Spawn new player for existing players.
 	newPlayerPacket := new(bytes.Buffer)
 	newPlayerData.sendSpawn(newPlayerPacket)
-	chunk.reqMulticastPlayers(entityId, newPlayerPacket.Bytes())
+	for viewerId, viewer := range chunk.viewers {
+		if viewerId != entityId {
+			viewer.ShowEntity(chunk.loc, entityId, newPlayerPacket.Bytes())
+		}
+	}
 }
 
+// reqRemovePlayerData removes the player from the chunk, when they disconnect
+// or move to another chunk. Players that also view the chunk moved to continue
+// to be shown the player from there.
 func (chunk *Chunk) reqRemovePlayerData(entityId EntityId, isDisconnect bool) {
-	chunk.playersData[entityId] = nil, false
-
-	if isDisconnect {
-		buf := new(bytes.Buffer)
-		proto.WriteEntityDestroy(buf, entityId)
-		chunk.reqMulticastPlayers(entityId, buf.Bytes())
+	if _, ok := chunk.playersData[entityId]; !ok {
+		return
 	}
+	chunk.playersData[entityId] = nil, false
+	chunk.hideEntity(entityId, entityId)
 }
 
 func (chunk *Chunk) reqSetPlayerPosition(entityId EntityId, pos AbsXyz) {
@@ -629,16 +912,18 @@ func (chunk *Chunk) reqSetPlayerPosition(entityId EntityId, pos AbsXyz) {
 		return
 	}
 
+	// Viewers are sent the movement at the end of the tick.
 	data.position = pos
 
-	// Update subscribers.
-	buf := new(bytes.Buffer)
-	data.sendPositionLook(buf)
-	chunk.reqMulticastPlayers(entityId, buf.Bytes())
-
 	player, ok := chunk.subscribers[entityId]
 
 	if ok {
+		// Is the player standing in a portal?
+		_, subLoc := pos.ToBlockXyz().ToChunkLocal()
+		if index, ok := subLoc.BlockIndex(); ok && index.BlockId(chunk.blocks) == BlockIdPortal {
+			player.EnterPortal()
+		}
+
 		// Does the player overlap with any items?
 		for _, item := range chunk.items() {
 			if item.PickupImmunity > 0 {
@@ -651,6 +936,13 @@ func (chunk *Chunk) reqSetPlayerPosition(entityId EntityId, pos AbsXyz) {
 				player.OfferItem(chunk.loc, item.EntityId, *slot)
 			}
 		}
+
+		// Does the player overlap with any arrows stuck in the ground?
+		for _, projectile := range chunk.projectiles() {
+			if projectile.CanPickUp() && data.overlaps(projectile.Position()) {
+				player.OfferItem(chunk.loc, projectile.EntityId, gamerules.Slot{ItemTypeId: projectile.PickUpItem(), Count: 1})
+			}
+		}
 	}
 }
 
@@ -665,30 +957,161 @@ func (chunk *Chunk) reqSetPlayerLook(entityId EntityId, look LookBytes) {
 		return
 	}
 
+	// Viewers are sent the movement at the end of the tick.
 	data.look = look
+}
 
-	// Update subscribers.
-	buf := new(bytes.Buffer)
-	data.sendPositionLook(buf)
-	chunk.reqMulticastPlayers(entityId, buf.Bytes())
+// compressPacket starts compressing the chunk packet for the subscribers
+// waiting for it, unless it is already being compressed.
+func (chunk *Chunk) compressPacket() {
+	if chunk.compressing {
+		return
+	}
+	chunk.compressing = true
+
+	chunk.changedSinceCompress = make(map[BlockIndex]bool)
+
+	version := chunk.blocksVersion
+	chunk.shard.compressChunkPacket(&compressJob{
+		loc:        chunk.loc,
+		blocks:     copyBytes(chunk.blocks),
+		blockData:  copyBytes(chunk.blockData),
+		blockLight: copyBytes(chunk.blockLight),
+		skyLight:   copyBytes(chunk.skyLight),
+		done: func(packet []byte) {
+			chunk.setChunkPacket(version, packet)
+		},
+	})
 }
 
-func (chunk *Chunk) chunkPacket() []byte {
-	if chunk.cachedPacket == nil {
+// setChunkPacket receives the chunk packet compressed from the blocks as they
+// were at the given version, and sends it to the subscribers waiting for it.
+// If the blocks have changed since, they are sent the changes after it, rather
+// than waiting on the packet to be compressed again, which might never catch
+// up with blocks that change often.
+func (chunk *Chunk) setChunkPacket(version int, packet []byte) {
+	changed := chunk.changedSinceCompress
+	chunk.compressing = false
+	chunk.changedSinceCompress = nil
+
+	var changes []byte
+	if version == chunk.blocksVersion {
+		chunk.cachedPacket = packet
+	} else if len(changed) > 0 {
 		buf := new(bytes.Buffer)
-		proto.WriteMapChunk(buf, &chunk.loc, chunk.blocks, chunk.blockData, chunk.blockLight, chunk.skyLight)
-		chunk.cachedPacket = buf.Bytes()
+		chunk.writeBlockChanges(buf, changed)
+		changes = buf.Bytes()
 	}
 
-	return chunk.cachedPacket
+	for entityId, waiter := range chunk.awaitingPacket {
+		chunk.awaitingPacket[entityId] = chunkPacketWaiter{}, false
+		waiter.player.TransmitPacket(packet, proto.PacketPriorityNormal)
+		if changes != nil {
+			waiter.player.TransmitPacket(changes, proto.PacketPriorityNormal)
+		}
+		if waiter.notify {
+			waiter.player.NotifyChunkLoad()
+		}
+	}
 }
 
-func (chunk *Chunk) sendUpdate() {
-	buf := &bytes.Buffer{}
-	for _, e := range chunk.entities {
-		e.SendUpdate(buf)
+// sendBlockChanges tells subscribers about the blocks changed during the tick.
+// Those waiting for the chunk packet are left out, and are sent the changes
+// along with it.
+func (chunk *Chunk) sendBlockChanges() {
+	if len(chunk.changedBlocks) == 0 {
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	chunk.writeBlockChanges(buf, chunk.changedBlocks)
+
+	for index := range chunk.changedBlocks {
+		if chunk.compressing {
+			chunk.changedSinceCompress[index] = true
+		}
+		chunk.changedBlocks[index] = false, false
+	}
+
+	for entityId, player := range chunk.subscribers {
+		if _, waiting := chunk.awaitingPacket[entityId]; !waiting {
+			player.TransmitPacket(buf.Bytes(), proto.PacketPriorityNormal)
+		}
+	}
+}
+
+// writeBlockChanges writes the packets that tell a subscriber of the current
+// state of the given blocks. Many blocks are written in as few packets as
+// possible.
+func (chunk *Chunk) writeBlockChanges(buf *bytes.Buffer, indices map[BlockIndex]bool) {
+	if len(indices) == 1 {
+		for index := range indices {
+			subLoc := index.ToSubChunkXyz()
+			proto.WriteBlockChange(
+				buf, chunk.loc.ToBlockXyz(&subLoc),
+				index.BlockId(chunk.blocks), index.BlockData(chunk.blockData))
+		}
+		return
+	}
+
+	count := len(indices)
+	if count > maxBlockChangesPerPacket {
+		count = maxBlockChangesPerPacket
+	}
+	subLocs := make([]SubChunkXyz, 0, count)
+	blockIds := make([]BlockId, 0, count)
+	blockData := make([]byte, 0, count)
+	for index := range indices {
+		subLocs = append(subLocs, index.ToSubChunkXyz())
+		blockIds = append(blockIds, index.BlockId(chunk.blocks))
+		blockData = append(blockData, index.BlockData(chunk.blockData))
+
+		if len(subLocs) == maxBlockChangesPerPacket {
+			proto.WriteBlockChangeMulti(buf, &chunk.loc, subLocs, blockIds, blockData)
+			subLocs, blockIds, blockData = subLocs[:0], blockIds[:0], blockData[:0]
+		}
+	}
+	if len(subLocs) > 0 {
+		proto.WriteBlockChangeMulti(buf, &chunk.loc, subLocs, blockIds, blockData)
+	}
+}
+
+// sendUpdate adds the movement of the players in the chunk during the tick,
+// and that of its other entities if sendEntities is set, to the shard's
+// updates for each viewer. A player isn't sent its own movement.
+func (chunk *Chunk) sendUpdate(sendEntities bool) {
+	entityUpdates := new(bytes.Buffer)
+	if sendEntities {
+		for _, e := range chunk.entities {
+			e.SendUpdate(entityUpdates)
+		}
+	}
+
+	var playerUpdates map[EntityId][]byte
+	if len(chunk.playersData) > 0 {
+		playerUpdates = make(map[EntityId][]byte, len(chunk.playersData))
+		for entityId, data := range chunk.playersData {
+			buf := new(bytes.Buffer)
+			data.sendMovement(buf)
+			if buf.Len() > 0 {
+				playerUpdates[entityId] = buf.Bytes()
+			}
+		}
+	}
+
+	if entityUpdates.Len() == 0 && len(playerUpdates) == 0 {
+		return
+	}
+
+	for viewerId, viewer := range chunk.viewers {
+		buf := chunk.shard.updateBuffer(viewerId, viewer)
+		buf.Write(entityUpdates.Bytes())
+		for entityId, packet := range playerUpdates {
+			if entityId != viewerId {
+				buf.Write(packet)
+			}
+		}
 	}
-	chunk.reqMulticastPlayers(-1, buf.Bytes())
 }
 
 func (chunk *Chunk) isSameChunk(otherChunkLoc *ChunkXz) bool {
@@ -702,3 +1125,7 @@ func (chunk *Chunk) addEntities(entities []gamerules.INonPlayerEntity) {
 		chunk.entities[entityId] = entity
 	}
 }
+
+func copyBytes(b []byte) []byte {
+	return append([]byte(nil), b...)
+}
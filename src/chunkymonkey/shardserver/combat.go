@@ -0,0 +1,189 @@
+package shardserver
+
+import (
+	"bytes"
+
+	"chunkymonkey/gamerules"
+	"chunkymonkey/physics"
+	"chunkymonkey/proto"
+	. "chunkymonkey/types"
+)
+
+const (
+	// Assumed values for size of mob axis-aligned bounding box (AAB).
+	mobAabH = AbsCoord(0.3) // Each side of mob.
+	mobAabY = AbsCoord(1.8) // From mob's feet position upwards.
+
+	// TODO Damage according to the held weapon.
+	meleeDamage = Health(1)
+
+	// TODO Experience according to the type of mob.
+	mobKillExperience = int16(5)
+
+	skeletonRange          = AbsCoord(16)
+	skeletonEyeHeight      = AbsCoord(1.5)
+	skeletonAttackCooldown = 2 * TicksPerSecond
+)
+
+// damageEntity hurts a mob or player in the chunk. This is the path by which
+// all attacks, melee or projectile, are resolved.
+func (chunk *Chunk) damageEntity(target EntityId, amount Health, attacker EntityId) {
+	if _, ok := chunk.playersData[target]; ok {
+		if player, ok := chunk.subscribers[target]; ok {
+			player.Damage(amount, attacker)
+		}
+		return
+	}
+
+	entity, ok := chunk.entities[target]
+	if !ok {
+		return
+	}
+	mob, ok := entity.(gamerules.IMob)
+	if !ok {
+		return
+	}
+
+	dead := mob.GetMob().Damage(amount)
+
+	status := EntityStatusHurt
+	if dead {
+		status = EntityStatusDead
+	}
+	buf := new(bytes.Buffer)
+	proto.WriteEntityStatus(buf, target, status)
+	chunk.multicastViewers(-1, buf.Bytes())
+
+	if dead {
+		chunk.removeEntity(entity)
+
+		if player, ok := chunk.subscribers[attacker]; ok {
+			player.GiveExperience(mobKillExperience)
+		}
+	}
+}
+
+// attack handles a player at the given position hitting a mob or another
+// player in the chunk. Returns false if the target isn't something that can be
+// attacked.
+func (chunk *Chunk) attack(player gamerules.IPlayerClient, position *AbsXyz, target EntityId) bool {
+	var targetPos *AbsXyz
+	if data, ok := chunk.playersData[target]; ok {
+		targetPos = &data.position
+	} else if entity, ok := chunk.entities[target]; ok {
+		if _, isMob := entity.(gamerules.IMob); isMob {
+			targetPos = entity.Position()
+		}
+	}
+
+	if targetPos == nil {
+		return false
+	}
+
+	if targetPos.IsWithinDistanceOf(position, MaxInteractDistance) {
+		chunk.damageEntity(target, meleeDamage, player.GetEntityId())
+	}
+	return true
+}
+
+// launchProjectile creates a projectile in flight.
+func (chunk *Chunk) launchProjectile(objType ObjTypeId, shooter EntityId, position *AbsXyz, velocity *AbsVelocity, canPickUp bool) {
+	if !gamerules.IsProjectileType(objType) {
+		return
+	}
+
+	projectile := gamerules.NewProjectile(objType, shooter, canPickUp)
+	projectile.PointObject.Init(position, velocity)
+	chunk.AddEntity(projectile)
+}
+
+// projectileTick checks if a projectile hit any mob or player as it moved,
+// and removes it if it has broken or otherwise expired. Returns true if the
+// projectile was removed.
+func (chunk *Chunk) projectileTick(projectile *gamerules.Projectile) (removed bool) {
+	from, to := projectile.Path()
+
+	if from.X != to.X || from.Y != to.Y || from.Z != to.Z {
+		// Find the first entity along the projectile's path.
+		// TODO Check entities in neighbouring chunks.
+		hitId := NoEntityId
+		var hitT float64
+		check := func(entityId EntityId, box physics.Aabb) {
+			if entityId == projectile.Shooter() {
+				return
+			}
+			if t, ok := box.SegmentIntersect(from, to); ok && (hitId == NoEntityId || t < hitT) {
+				hitId, hitT = entityId, t
+			}
+		}
+
+		for entityId, entity := range chunk.entities {
+			if _, isMob := entity.(gamerules.IMob); isMob {
+				check(entityId, physics.NewAabbAround(entity.Position(), mobAabH, mobAabY))
+			}
+		}
+		for entityId, data := range chunk.playersData {
+			check(entityId, data.aabb())
+		}
+
+		if hitId != NoEntityId {
+			projectile.HitEntity()
+			chunk.damageEntity(hitId, projectile.Damage(), projectile.Shooter())
+		}
+	}
+
+	if !projectile.Expired() {
+		return false
+	}
+
+	chunk.removeEntity(projectile)
+	if projectile.Hatches(chunk.Rand()) {
+		hen := gamerules.NewHen()
+		hen.PointObject.Init(projectile.Position(), &AbsVelocity{})
+		chunk.AddEntity(hen)
+	}
+	return true
+}
+
+// skeletonTick has a skeleton shoot arrows at the nearest player in range.
+// Players are looked for in the loaded chunks within range that are in the
+// same shard, as those in other shards can't be seen from this one.
+func (chunk *Chunk) skeletonTick(mob *gamerules.Mob) {
+	if !mob.ReadyToAttack() {
+		return
+	}
+
+	from := *mob.Position()
+	from.Y += skeletonEyeHeight
+
+	minCorner := AbsXyz{from.X - skeletonRange, from.Y, from.Z - skeletonRange}
+	maxCorner := AbsXyz{from.X + skeletonRange, from.Y, from.Z + skeletonRange}
+	minLoc, maxLoc := minCorner.ToChunkXz(), maxCorner.ToChunkXz()
+
+	var target *playerData
+	var targetDist AbsCoord
+	for x := minLoc.X; x <= maxLoc.X; x++ {
+		for z := minLoc.Z; z <= maxLoc.Z; z++ {
+			other := chunk.shard.loadedChunkAt(ChunkXz{x, z})
+			if other == nil {
+				continue
+			}
+			for _, data := range other.playersData {
+				dx, dy, dz := data.position.X-from.X, data.position.Y-from.Y, data.position.Z-from.Z
+				dist := dx*dx + dy*dy + dz*dz
+				if dist <= skeletonRange*skeletonRange && (target == nil || dist < targetDist) {
+					target, targetDist = data, dist
+				}
+			}
+		}
+	}
+	if target == nil {
+		return
+	}
+
+	aimAt := target.position
+	aimAt.Y += playerAabY / 2
+	velocity := gamerules.AimProjectile(&from, &aimAt, gamerules.ArrowSpeed)
+	chunk.launchProjectile(ObjTypeIdArrow, mob.EntityId, &from, &velocity, false)
+	mob.Attacked(skeletonAttackCooldown)
+}
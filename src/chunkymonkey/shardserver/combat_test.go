@@ -0,0 +1,47 @@
+package shardserver
+
+import (
+	"testing"
+
+	"chunkymonkey/entity"
+	"chunkymonkey/gamerules"
+	. "chunkymonkey/types"
+)
+
+func TestSkeletonTargetsNeighbouringChunks(t *testing.T) {
+	entityMgr := new(entity.EntityManager)
+	entityMgr.Init()
+	shard := NewChunkShard(nil, nil, entityMgr, ShardXz{0, 0}, 0, WeatherClear)
+	for _, chunkLoc := range []ChunkXz{{0, 0}, {1, 0}} {
+		chunkIndex, _, _, _ := shard.chunkIndexAndRelLoc(chunkLoc)
+		shard.chunks[chunkIndex] = newChunkFromNbt(testChunkNbt(chunkLoc), shard)
+	}
+	chunk := shard.loadedChunkAt(ChunkXz{0, 0})
+	neighbour := shard.loadedChunkAt(ChunkXz{1, 0})
+
+	arrows := func() (count int) {
+		for _, e := range chunk.entities {
+			if _, ok := e.(*gamerules.Projectile); ok {
+				count++
+			}
+		}
+		return
+	}
+
+	skeleton := gamerules.NewSkeleton()
+	skeleton.PointObject.Init(&AbsXyz{15, 64, 8}, &AbsVelocity{})
+
+	// Nobody in range.
+	neighbour.playersData[1] = &playerData{entityId: 1, position: AbsXyz{30, 64, 8}}
+	chunk.skeletonTick(&skeleton.Mob)
+	if n := arrows(); n != 0 {
+		t.Fatalf("Expected no arrows with nobody in range, got %d", n)
+	}
+
+	// A player in range across the chunk boundary.
+	neighbour.playersData[2] = &playerData{entityId: 2, position: AbsXyz{20, 64, 8}}
+	chunk.skeletonTick(&skeleton.Mob)
+	if n := arrows(); n != 1 {
+		t.Errorf("Expected an arrow at the player in the neighbouring chunk, got %d", n)
+	}
+}
@@ -0,0 +1,117 @@
+package shardserver
+
+import (
+	"bytes"
+	"log"
+	"sync"
+
+	"chunkymonkey/proto"
+	. "chunkymonkey/types"
+)
+
+// ChunkCompressWorkers is the number of goroutines that compress map chunk
+// packets, shared by all shards. It may only be changed before any shards are
+// created.
+var ChunkCompressWorkers = 2
+
+var (
+	compressJobs      chan *compressJob
+	compressWorkersUp sync.Once
+)
+
+// compressJob is a copy of a chunk's blocks to be compressed into a map chunk
+// packet.
+type compressJob struct {
+	shard                                   *ChunkShard
+	loc                                     ChunkXz
+	blocks, blockData, blockLight, skyLight []byte
+
+	// done is called with the packet, from the shard's goroutine.
+	done   func(packet []byte)
+	packet []byte
+}
+
+// compressChunkPacket queues the job to be compressed by a worker, so that
+// compression doesn't hold up the shard. If the workers are busy, the job
+// waits on the shard until they have room for it.
+func (shard *ChunkShard) compressChunkPacket(job *compressJob) {
+	startCompressWorkers()
+
+	job.shard = shard
+	shard.compressPending = append(shard.compressPending, job)
+	shard.submitCompressJobs()
+}
+
+func startCompressWorkers() {
+	compressWorkersUp.Do(func() {
+		compressJobs = make(chan *compressJob, 256)
+		for i := 0; i < ChunkCompressWorkers; i++ {
+			go compressWorker(compressJobs)
+		}
+	})
+}
+
+// submitCompressJobs passes the shard's pending jobs to the workers, for as
+// long as they have room for them.
+func (shard *ChunkShard) submitCompressJobs() {
+	for len(shard.compressPending) > 0 {
+		select {
+		case compressJobs <- shard.compressPending[0]:
+			shard.compressPending[0] = nil
+			shard.compressPending = shard.compressPending[1:]
+		default:
+			return
+		}
+	}
+}
+
+// jobCompressed returns a job to its shard, from the worker's goroutine. It
+// doesn't wait on the shard, which may be busy or no longer serving.
+func (shard *ChunkShard) jobCompressed(job *compressJob) {
+	shard.compressLock.Lock()
+	shard.compressed = append(shard.compressed, job)
+	shard.compressLock.Unlock()
+
+	select {
+	case shard.compressReady <- true:
+	default:
+		// The shard has yet to take the jobs already signalled.
+	}
+}
+
+// runCompressed passes the packets compressed by the workers to the chunks that
+// they are for. Packets compressed while the shard is frozen wait until it
+// resumes, and those for a shard that has moved are dropped along with its
+// chunks.
+func (shard *ChunkShard) runCompressed() {
+	if shard.handoff == shardFrozen {
+		return
+	}
+
+	shard.compressLock.Lock()
+	jobs := shard.compressed
+	shard.compressed = nil
+	shard.compressLock.Unlock()
+
+	if shard.handoff == shardMoved {
+		return
+	}
+
+	for _, job := range jobs {
+		job.done(job.packet)
+	}
+
+	shard.submitCompressJobs()
+}
+
+func compressWorker(jobs <-chan *compressJob) {
+	for job := range jobs {
+		buf := new(bytes.Buffer)
+		err := proto.WriteMapChunk(buf, &job.loc, job.blocks, job.blockData, job.blockLight, job.skyLight)
+		if err != nil {
+			log.Printf("Error compressing chunk %#v: %v", job.loc, err)
+		}
+		job.packet = buf.Bytes()
+		job.shard.jobCompressed(job)
+	}
+}
@@ -0,0 +1,200 @@
+package shardserver
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"chunkymonkey/gamerules"
+	"chunkymonkey/proto"
+	. "chunkymonkey/types"
+)
+
+// packetRecorder is a subscriber that records the packets sent to it.
+type packetRecorder struct {
+	gamerules.IPlayerClient
+	packets    [][]byte
+	chunkLoads int
+}
+
+func (p *packetRecorder) TransmitPacket(packet []byte, priority proto.PacketPriority) {
+	p.packets = append(p.packets, packet)
+}
+
+func (p *packetRecorder) NotifyChunkLoad() {
+	p.chunkLoads++
+}
+
+func newTestChunk(shard *ChunkShard, loc ChunkXz) *Chunk {
+	return &Chunk{
+		shard:          shard,
+		loc:            loc,
+		blocks:         make([]byte, ChunkSizeH*ChunkSizeH*ChunkSizeY),
+		blockData:      make([]byte, (ChunkSizeH*ChunkSizeH*ChunkSizeY)>>1),
+		blockLight:     make([]byte, (ChunkSizeH*ChunkSizeH*ChunkSizeY)>>1),
+		skyLight:       make([]byte, (ChunkSizeH*ChunkSizeH*ChunkSizeY)>>1),
+		blockExtra:     make(map[BlockIndex]interface{}),
+		subscribers:    make(map[EntityId]gamerules.IPlayerClient),
+		viewers:        make(map[EntityId]gamerules.IPlayerClient),
+		changedBlocks:  make(map[BlockIndex]bool),
+		awaitingPacket: make(map[EntityId]chunkPacketWaiter),
+	}
+}
+
+func setTestBlock(chunk *Chunk, subLoc SubChunkXyz, blockId BlockId) {
+	index, _ := subLoc.BlockIndex()
+	chunk.setBlock(chunk.loc.ToBlockXyz(&subLoc), &subLoc, index, blockId, 0)
+}
+
+// runCompressedPacket waits for a worker to compress a chunk packet, and
+// passes it to its chunk.
+func runCompressedPacket(t *testing.T, shard *ChunkShard) {
+	select {
+	case <-shard.compressReady:
+	case <-time.After(remoteTestTimeout):
+		t.Fatalf("Timed out waiting for the chunk packet to be compressed")
+	}
+	shard.runCompressed()
+}
+
+func TestChunkPacketCompression(t *testing.T) {
+	shard := NewChunkShard(nil, nil, nil, ShardXz{0, 0}, 0, WeatherClear)
+	chunk := newTestChunk(shard, ChunkXz{1, 2})
+
+	player := &packetRecorder{}
+	chunk.reqSubscribeChunk(5, player, true)
+	if len(player.packets) != 1 {
+		t.Fatalf("Expected only the pre-chunk packet while compressing, got %d packets", len(player.packets))
+	}
+
+	expected := new(bytes.Buffer)
+	proto.WriteMapChunk(expected, &chunk.loc, chunk.blocks, chunk.blockData, chunk.blockLight, chunk.skyLight)
+
+	// The block changes while the packet is being compressed. The subscriber
+	// isn't sent the change until it has been sent the packet, which is then
+	// not compressed again.
+	setTestBlock(chunk, SubChunkXyz{1, 2, 3}, 1)
+	chunk.sendBlockChanges()
+	if len(player.packets) != 1 {
+		t.Fatalf("Expected no block change before the chunk packet, got %d packets", len(player.packets))
+	}
+
+	runCompressedPacket(t, shard)
+	if len(player.packets) != 3 || !bytes.Equal(expected.Bytes(), player.packets[1]) {
+		t.Fatalf("Expected the chunk packet and the block change to be sent, got %d packets", len(player.packets))
+	}
+	// Packet ID, then the block location, ID and data.
+	if packet := player.packets[2]; packet[0] != 0x35 || len(packet) != 1+4+1+4+1+1 {
+		t.Errorf("Expected block change packet, got %x", packet)
+	}
+	if chunk.compressing || chunk.cachedPacket != nil {
+		t.Errorf("Expected the out of date packet to be neither compressed again nor cached")
+	}
+	if player.chunkLoads != 1 {
+		t.Errorf("Expected chunk load to be notified once, got %d", player.chunkLoads)
+	}
+
+	// Blocks changed in the same tick are sent together.
+	setTestBlock(chunk, SubChunkXyz{1, 2, 3}, 0)
+	setTestBlock(chunk, SubChunkXyz{4, 5, 6}, 1)
+	chunk.sendBlockChanges()
+	if len(player.packets) != 4 {
+		t.Fatalf("Expected one block change packet, got %d packets", len(player.packets)-3)
+	}
+	// Packet ID, chunk location and count, then 4 bytes for each block.
+	if packet := player.packets[3]; packet[0] != 0x34 || len(packet) != 11+2*4 {
+		t.Errorf("Expected multiple block change packet, got %x", packet)
+	}
+}
+
+func TestCompressJobsWaitOnShard(t *testing.T) {
+	// The workers keep the channel that they were started with.
+	startCompressWorkers()
+	defer func(jobs chan *compressJob) {
+		compressJobs = jobs
+	}(compressJobs)
+
+	shard := NewChunkShard(nil, nil, nil, ShardXz{0, 0}, 0, WeatherClear)
+	chunk := newTestChunk(shard, ChunkXz{1, 2})
+
+	// The workers have no room, so the job waits on the shard rather than
+	// holding it up.
+	compressJobs = make(chan *compressJob)
+	chunk.compressPacket()
+	if len(shard.compressPending) != 1 {
+		t.Fatalf("Expected the job to wait on the shard, got %d waiting", len(shard.compressPending))
+	}
+
+	// Once they have room, it is passed on.
+	compressJobs = make(chan *compressJob, 1)
+	shard.submitCompressJobs()
+	if len(shard.compressPending) != 0 || len(compressJobs) != 1 {
+		t.Fatalf("Expected the job to be passed to the workers")
+	}
+
+	// A compressed packet is returned without waiting on the shard, even when
+	// it has yet to take the last one.
+	job := <-compressJobs
+	job.packet = []byte("packet")
+	shard.jobCompressed(job)
+	shard.jobCompressed(job)
+	if len(shard.compressed) != 2 {
+		t.Errorf("Expected both packets to be returned, got %d", len(shard.compressed))
+	}
+}
+
+func TestBlockChangesSplitIntoPackets(t *testing.T) {
+	chunk := newTestChunk(nil, ChunkXz{1, 2})
+
+	indices := make(map[BlockIndex]bool)
+	for i := 0; i <= maxBlockChangesPerPacket; i++ {
+		indices[BlockIndex(i)] = true
+	}
+
+	buf := new(bytes.Buffer)
+	chunk.writeBlockChanges(buf, indices)
+
+	// Each multiple block change packet has 11 bytes of header, then 4 bytes
+	// for each block.
+	if expected := 2*11 + 4*len(indices); buf.Len() != expected {
+		t.Errorf("Expected two packets of %d bytes in all, got %d bytes", expected, buf.Len())
+	}
+	if packet := buf.Bytes(); packet[0] != 0x34 || packet[11+4*maxBlockChangesPerPacket] != 0x34 {
+		t.Errorf("Expected the changes to be split into two multiple block change packets")
+	}
+}
+
+func TestChunkTeleportsEntitiesToViewer(t *testing.T) {
+	shard := NewChunkShard(nil, nil, nil, ShardXz{0, 0}, 0, WeatherClear)
+	chunk := newTestChunk(shard, ChunkXz{0, 0})
+	chunk.entities = make(map[EntityId]gamerules.INonPlayerEntity)
+	chunk.playersData = make(map[EntityId]*playerData)
+
+	item := gamerules.NewItem(1, 1, 0, &AbsXyz{1, 2, 3}, &AbsVelocity{}, 0)
+	item.SetEntityId(9)
+	chunk.entities[9] = item
+
+	viewer := &packetRecorder{}
+	chunk.subscribers[5] = viewer
+	chunk.viewers[5] = viewer
+
+	// The item's position as last sent is sent in full with the movement at
+	// the end of the tick.
+	chunk.reqTeleportEntities(5)
+	shard.flushUpdates()
+
+	expected := new(bytes.Buffer)
+	proto.WriteEntityTeleport(expected, 9, &item.LastSentPosition, &LookBytes{})
+	if len(viewer.packets) != 1 || !bytes.Equal(expected.Bytes(), viewer.packets[0]) {
+		t.Errorf("Expected the item's full position to be sent, got %x", viewer.packets)
+	}
+
+	// Subscribers not viewing the chunk's entities are not sent them.
+	other := &packetRecorder{}
+	chunk.subscribers[6] = other
+	chunk.reqTeleportEntities(6)
+	shard.flushUpdates()
+	if len(other.packets) != 0 {
+		t.Errorf("Expected nothing to be sent to a subscriber not viewing entities, got %x", other.packets)
+	}
+}
@@ -0,0 +1,398 @@
+package shardserver
+
+// Handing off a shard moves it from one chunk server to another without
+// disconnecting the players connected to it:
+//
+// 1. The shard is frozen. It stops ticking, and writes its state (chunks,
+//    entities, active blocks and block inventories) as NBT data. Requests
+//    that it receives while frozen are held back.
+// 2. The state is sent to the new chunk server, which starts the shard from
+//    it. If that fails, the shard is resumed, and performs the held back
+//    requests.
+// 3. The lookup service is updated to give the shard's new server.
+// 4. The old shard is marked as moved. It forwards requests from other shards
+//    to the new server, fails requests from players that they are waiting on
+//    or gave up items for, and tells connected players that it has moved.
+//    Players then reconnect to the new server through the lookup service, and
+//    resubscribe to their chunks.
+
+import (
+	"bytes"
+	"log"
+	"os"
+
+	"chunkymonkey/chunkstore"
+	"chunkymonkey/gamerules"
+	"chunkymonkey/nbtutil"
+	"chunkymonkey/storage"
+	. "chunkymonkey/types"
+	"nbt"
+)
+
+type shardHandoffState byte
+
+const (
+	shardRunning = shardHandoffState(iota)
+	shardFrozen  = shardHandoffState(iota)
+	shardMoved   = shardHandoffState(iota)
+)
+
+// IShardReceiver is implemented by shard managers that can take over shards
+// handed off from other chunk servers.
+type IShardReceiver interface {
+	// ReceiveShard starts the shard at the given location from the state
+	// written by the chunk server that it was handed off from.
+	ReceiveShard(shardLoc ShardXz, state []byte) os.Error
+
+	// DiscardShard stops a shard started by ReceiveShard, if the chunk server
+	// that handed it off fails to make this one its owner.
+	DiscardShard(shardLoc ShardXz)
+}
+
+// iShardMovedListener is implemented by IPlayerClients that can follow a
+// shard to the chunk server that it is handed off to.
+type iShardMovedListener interface {
+	shardMoved(shardLoc ShardXz)
+}
+
+// notifyShardMoved tells the player that the shard has moved, if it is able
+// to follow it.
+func notifyShardMoved(player gamerules.IPlayerClient, shardLoc ShardXz) {
+	if listener, ok := player.(iShardMovedListener); ok {
+		listener.shardMoved(shardLoc)
+	}
+}
+
+// performRequest performs a request, or holds it back or forwards it if the
+// shard is being handed off.
+func (shard *ChunkShard) performRequest(request iShardRequest) {
+	if shard.handoff == shardRunning {
+		request.perform(shard)
+		return
+	}
+
+	switch req := request.(type) {
+	case *runControl, *setWorldState, *addPlayerClient, *removePlayerClient:
+		request.perform(shard)
+	case iForwardableRequest:
+		if shard.handoff == shardFrozen {
+			shard.deferred = append(shard.deferred, request)
+		} else if shard.movedClient != nil {
+			req.forward(shard.movedClient)
+		}
+	case iFailableRequest:
+		if shard.handoff == shardFrozen {
+			shard.deferred = append(shard.deferred, request)
+		} else {
+			req.failed()
+		}
+	default:
+		// The remaining requests are about players' chunk subscriptions and
+		// presence in the shard, which they make again once they have
+		// reconnected to the new server, or have no outcome (such as hitting a
+		// block).
+		if shard.handoff == shardFrozen {
+			shard.deferred = append(shard.deferred, request)
+		}
+	}
+}
+
+// freeze stops the shard and returns its state, as NBT data. It must be
+// followed by either resume or moved.
+func (shard *ChunkShard) freeze() (state []byte, err os.Error) {
+	done := make(chan bool)
+	shard.enqueueRequest(&runControl{func() {
+		state, err = shard.reqFreeze()
+		done <- true
+	}})
+	<-done
+	return
+}
+
+// resume restarts a frozen shard, when handing it off has failed.
+func (shard *ChunkShard) resume() {
+	shard.enqueueRequest(&runControl{func() {
+		shard.reqResume()
+	}})
+}
+
+// moved marks a frozen shard as having moved to another chunk server.
+// Requests from other shards are forwarded to it through movedClient.
+func (shard *ChunkShard) moved(movedClient gamerules.IShardShardClient) {
+	shard.enqueueRequest(&runControl{func() {
+		shard.reqMoved(movedClient)
+	}})
+}
+
+// suspend freezes the shard without handing it off, while this server may no
+// longer own it. It writes nothing to the chunk store until unsuspended.
+func (shard *ChunkShard) suspend() {
+	shard.enqueueRequest(&runControl{func() {
+		if shard.handoff == shardRunning {
+			shard.handoff = shardFrozen
+			shard.suspended = true
+		}
+	}})
+}
+
+// unsuspend restarts a shard frozen by suspend.
+func (shard *ChunkShard) unsuspend() {
+	shard.enqueueRequest(&runControl{func() {
+		if shard.suspended && shard.handoff == shardFrozen {
+			shard.reqResume()
+		}
+		shard.suspended = false
+	}})
+}
+
+// stop makes the shard's goroutine return, after it has performed the
+// requests queued before the call. The goroutine may be started again with
+// serve.
+func (shard *ChunkShard) stop() {
+	done := make(chan bool)
+	shard.enqueueRequest(&runControl{func() {
+		shard.stopping = true
+		done <- true
+	}})
+	<-done
+}
+
+func (shard *ChunkShard) reqFreeze() (state []byte, err os.Error) {
+	if shard.handoff != shardRunning {
+		return nil, os.NewError("shard is already being handed off")
+	}
+
+	// Send any blocks made active in other shards on their way first.
+	shard.transferActiveBlocks()
+
+	buf := new(bytes.Buffer)
+	if err = nbt.Write(buf, shard.writeNbt()); err != nil {
+		return
+	}
+	shard.handoff = shardFrozen
+
+	return buf.Bytes(), nil
+}
+
+func (shard *ChunkShard) reqResume() {
+	shard.handoff = shardRunning
+
+	deferred := shard.deferred
+	shard.deferred = nil
+	for _, request := range deferred {
+		request.perform(shard)
+	}
+
+	// Chunk packets compressed while frozen.
+	shard.runCompressed()
+}
+
+func (shard *ChunkShard) reqMoved(movedClient gamerules.IShardShardClient) {
+	shard.handoff = shardMoved
+	shard.movedClient = movedClient
+
+	deferred := shard.deferred
+	shard.deferred = nil
+	for _, request := range deferred {
+		shard.performRequest(request)
+	}
+
+	shard.releaseEntityIds()
+	for i := range shard.chunks {
+		shard.chunks[i] = nil
+	}
+	shard.compressPending = nil
+
+	for _, player := range shard.players {
+		notifyShardMoved(player, shard.loc)
+	}
+}
+
+// writeNbt returns the state of the shard that moves with it to another chunk
+// server. Each chunk carries the version that the chunk store last read or
+// wrote, which the new server writes over.
+func (shard *ChunkShard) writeNbt() *nbt.Compound {
+	versions, hasVersions := shard.chunkStore.(chunkstore.IChunkVersions)
+
+	chunks := make([]nbt.ITag, 0, len(shard.chunks))
+	for _, chunk := range shard.chunks {
+		if chunk == nil {
+			continue
+		}
+		chunkTag := chunk.writeNbt()
+		if hasVersions {
+			if version, ok := versions.ChunkVersion(chunk.loc); ok {
+				chunkTag.Tags["StoreVersion"] = &nbt.Long{int64(version)}
+			}
+		}
+		chunks = append(chunks, chunkTag)
+	}
+
+	return &nbt.Compound{
+		map[string]nbt.ITag{
+			"WorldTime": &nbt.Long{int64(shard.worldTime)},
+			"Weather":   &nbt.Byte{int8(shard.weather)},
+			"Chunks":    &nbt.List{nbt.TagCompound, chunks},
+		},
+	}
+}
+
+// readNbt restores the state written by writeNbt, before the shard is
+// started.
+func (shard *ChunkShard) readNbt(tag nbt.ITag) os.Error {
+	worldTime, ok := tag.Lookup("WorldTime").(*nbt.Long)
+	if !ok {
+		return os.NewError("missing shard world time")
+	}
+	shard.worldTime = Ticks(worldTime.Value)
+
+	weather, err := nbtutil.ReadByte(tag, "Weather")
+	if err != nil {
+		return err
+	}
+	shard.weather = Weather(weather)
+
+	chunks, ok := tag.Lookup("Chunks").(*nbt.List)
+	if !ok {
+		return os.NewError("missing shard chunks")
+	}
+
+	versions, hasVersions := shard.chunkStore.(chunkstore.IChunkVersions)
+
+	for _, chunkTag := range chunks.Value {
+		chunk := newChunkFromNbt(chunkTag, shard)
+		chunkIndex, _, _, ok := shard.chunkIndexAndRelLoc(chunk.loc)
+		if !ok {
+			return os.NewError("chunk outside of shard")
+		}
+		shard.chunks[chunkIndex] = chunk
+
+		// Without its version, the chunk store refuses to write the chunk.
+		if version, ok := chunkTag.Lookup("StoreVersion").(*nbt.Long); ok && hasVersions {
+			versions.SetChunkVersion(chunk.loc, storage.Version(version.Value))
+		}
+	}
+
+	return nil
+}
+
+// writeNbt returns the chunk's state in the format of chunk files, along with
+// its active blocks and the EntityIds of its entities. The block arrays are
+// copied, so the result can be written out while the chunk changes.
+func (chunk *Chunk) writeNbt() *nbt.Compound {
+	entities := make([]nbt.ITag, 0, len(chunk.entities))
+	for entityId, entity := range chunk.entities {
+		entityTag := entity.WriteNbt()
+		entityTag.Tags["EntityId"] = &nbt.Int{int32(entityId)}
+		entities = append(entities, entityTag)
+	}
+
+	tileEntities := make([]nbt.ITag, 0)
+	for index, extra := range chunk.blockExtra {
+		if tileTag, ok := gamerules.BlockInventoryNbt(extra); ok {
+			subLoc := index.ToSubChunkXyz()
+			blockLoc := chunk.loc.ToBlockXyz(&subLoc)
+			tileTag.Tags["x"] = &nbt.Int{int32(blockLoc.X)}
+			tileTag.Tags["y"] = &nbt.Int{int32(blockLoc.Y)}
+			tileTag.Tags["z"] = &nbt.Int{int32(blockLoc.Z)}
+			tileEntities = append(tileEntities, tileTag)
+		}
+	}
+
+	activeBlocks := make([]nbt.ITag, 0, len(chunk.activeBlocks)+len(chunk.newActiveBlocks))
+	for index := range chunk.activeBlocks {
+		activeBlocks = append(activeBlocks, &nbt.Int{int32(index)})
+	}
+	for index := range chunk.newActiveBlocks {
+		activeBlocks = append(activeBlocks, &nbt.Int{int32(index)})
+	}
+
+	return &nbt.Compound{
+		map[string]nbt.ITag{
+			"Level": &nbt.Compound{
+				map[string]nbt.ITag{
+					"xPos":         &nbt.Int{int32(chunk.loc.X)},
+					"zPos":         &nbt.Int{int32(chunk.loc.Z)},
+					"Blocks":       &nbt.ByteArray{copyBytes(chunk.blocks)},
+					"Data":         &nbt.ByteArray{copyBytes(chunk.blockData)},
+					"BlockLight":   &nbt.ByteArray{copyBytes(chunk.blockLight)},
+					"SkyLight":     &nbt.ByteArray{copyBytes(chunk.skyLight)},
+					"HeightMap":    &nbt.ByteArray{copyBytes(chunk.heightMap)},
+					"Entities":     &nbt.List{nbt.TagCompound, entities},
+					"TileEntities": &nbt.List{nbt.TagCompound, tileEntities},
+					"ActiveBlocks": &nbt.List{nbt.TagInt, activeBlocks},
+				},
+			},
+		},
+	}
+}
+
+// handoffChunkReader reads a chunk written by Chunk.writeNbt. Its entities are
+// read separately by newChunkFromNbt, to keep their EntityIds.
+type handoffChunkReader struct {
+	chunkstore.IChunkReader
+}
+
+func (r handoffChunkReader) Entities() []gamerules.INonPlayerEntity {
+	return nil
+}
+
+// newChunkFromNbt creates a chunk from the state written by Chunk.writeNbt.
+// Entities keep their EntityIds, which are reserved here, unless they are in
+// use, in which case they are given new ones. Entities and block inventories
+// that can't be read are logged and dropped.
+func newChunkFromNbt(tag nbt.ITag, shard *ChunkShard) (chunk *Chunk) {
+	chunk = newChunkFromReader(handoffChunkReader{chunkstore.NewNbtChunkReader(tag)}, shard)
+
+	// The previous holder may not have written the chunk's latest changes.
+	chunk.savedVersion = -1
+
+	if entities, ok := tag.Lookup("Level/Entities").(*nbt.List); ok {
+		for _, entityTag := range entities.Value {
+			entity, err := gamerules.NewEntityFromNbt(entityTag)
+			if err != nil {
+				log.Printf("%v: error reading entity NBT: %v", chunk, err)
+				continue
+			}
+			entityId, err := nbtutil.ReadInt(entityTag, "EntityId")
+			if err != nil {
+				log.Printf("%v: error reading entity NBT: %v", chunk, err)
+				continue
+			}
+			reserved := shard.reserveEntityId(EntityId(entityId))
+			entity.SetEntityId(reserved)
+			chunk.entities[reserved] = entity
+		}
+	}
+
+	if tileEntities, ok := tag.Lookup("Level/TileEntities").(*nbt.List); ok {
+		for _, tileTag := range tileEntities.Value {
+			x, xErr := nbtutil.ReadInt(tileTag, "x")
+			y, yErr := nbtutil.ReadInt(tileTag, "y")
+			z, zErr := nbtutil.ReadInt(tileTag, "z")
+			if xErr != nil || yErr != nil || zErr != nil {
+				log.Printf("%v: bad block inventory location", chunk)
+				continue
+			}
+
+			blockLoc := BlockXyz{BlockCoord(x), BlockYCoord(y), BlockCoord(z)}
+			blockInstance, _, ok := chunk.blockInstanceAndType(&blockLoc)
+			if !ok {
+				continue
+			}
+			if err := gamerules.ReadBlockInventoryNbt(blockInstance, tileTag); err != nil {
+				log.Printf("%v: error reading block inventory at %v: %v", chunk, blockLoc, err)
+			}
+		}
+	}
+
+	if activeBlocks, ok := tag.Lookup("Level/ActiveBlocks").(*nbt.List); ok {
+		for _, indexTag := range activeBlocks.Value {
+			if index, ok := indexTag.(*nbt.Int); ok {
+				chunk.activeBlocks[BlockIndex(index.Value)] = true
+			}
+		}
+	}
+
+	return
+}
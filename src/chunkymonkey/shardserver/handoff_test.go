@@ -0,0 +1,345 @@
+package shardserver
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"chunkymonkey/chunkstore"
+	"chunkymonkey/entity"
+	"chunkymonkey/gamerules"
+	"chunkymonkey/shardlookup"
+	"chunkymonkey/storage"
+	. "chunkymonkey/types"
+	"nbt"
+)
+
+// testChunkNbt returns the NBT data for an empty chunk.
+func testChunkNbt(loc ChunkXz) nbt.ITag {
+	numBlocks := ChunkSizeH * ChunkSizeH * ChunkSizeY
+
+	return &nbt.Compound{
+		map[string]nbt.ITag{
+			"Level": &nbt.Compound{
+				map[string]nbt.ITag{
+					"xPos":       &nbt.Int{int32(loc.X)},
+					"zPos":       &nbt.Int{int32(loc.Z)},
+					"Blocks":     &nbt.ByteArray{make([]byte, numBlocks)},
+					"Data":       &nbt.ByteArray{make([]byte, numBlocks/2)},
+					"BlockLight": &nbt.ByteArray{make([]byte, numBlocks/2)},
+					"SkyLight":   &nbt.ByteArray{make([]byte, numBlocks/2)},
+					"HeightMap":  &nbt.ByteArray{make([]byte, ChunkSizeH*ChunkSizeH)},
+				},
+			},
+		},
+	}
+}
+
+type testMovedPlayerClient struct {
+	gamerules.IPlayerClient
+	moved chan ShardXz
+}
+
+func (player *testMovedPlayerClient) shardMoved(shardLoc ShardXz) {
+	player.moved <- shardLoc
+}
+
+type testForwardShardClient struct {
+	gamerules.IShardShardClient
+	blocks []BlockXyz
+}
+
+func (client *testForwardShardClient) ReqSetActiveBlocks(blocks []BlockXyz) {
+	client.blocks = append(client.blocks, blocks...)
+}
+
+func TestShardStateRoundTrip(t *testing.T) {
+	chunkLoc := ChunkXz{1, 2}
+
+	shard := NewChunkShard(nil, nil, nil, ShardXz{0, 0}, 1234, WeatherRain)
+	chunk := newChunkFromNbt(testChunkNbt(chunkLoc), shard)
+	chunk.blocks[5] = 1
+	chunk.activeBlocks[10] = true
+	chunk.newActiveBlocks[11] = true
+
+	item := gamerules.NewItem(ItemTypeIdArrow, 3, 0, &AbsXyz{17.5, 64, 33.5}, &AbsVelocity{}, 0)
+	item.SetEntityId(77)
+	chunk.entities[77] = item
+
+	chunkIndex, _, _, _ := shard.chunkIndexAndRelLoc(chunkLoc)
+	shard.chunks[chunkIndex] = chunk
+
+	state, err := shard.reqFreeze()
+	if err != nil {
+		t.Fatalf("reqFreeze: %v", err)
+	}
+	if shard.handoff != shardFrozen {
+		t.Errorf("Expected shard to be frozen")
+	}
+
+	tag, err := nbt.Read(bytes.NewBuffer(state))
+	if err != nil {
+		t.Fatalf("nbt.Read: %v", err)
+	}
+
+	entityMgr := new(entity.EntityManager)
+	entityMgr.Init()
+	received := NewChunkShard(nil, nil, entityMgr, ShardXz{0, 0}, 0, WeatherClear)
+	if err = received.readNbt(tag); err != nil {
+		t.Fatalf("readNbt: %v", err)
+	}
+
+	if received.worldTime != 1234 || received.weather != WeatherRain {
+		t.Errorf("Expected world time 1234 and rain, got %d and %d", received.worldTime, received.weather)
+	}
+
+	result := received.chunks[chunkIndex]
+	if result == nil {
+		t.Fatalf("Expected chunk %v to be received", chunkLoc)
+	}
+	if result.loc.X != chunkLoc.X || result.loc.Z != chunkLoc.Z {
+		t.Errorf("Expected chunk at %v, got %v", chunkLoc, result.loc)
+	}
+	if result.blocks[5] != 1 {
+		t.Errorf("Expected block 5 to be 1, got %d", result.blocks[5])
+	}
+	if !result.activeBlocks[10] || !result.activeBlocks[11] {
+		t.Errorf("Expected blocks 10 and 11 to be active, got %v", result.activeBlocks)
+	}
+
+	if entity, ok := result.entities[77].(*gamerules.Item); !ok {
+		t.Errorf("Expected *gamerules.Item with EntityId 77, got %T", result.entities[77])
+	} else if entity.GetEntityId() != 77 || entity.ItemTypeId != ItemTypeIdArrow || entity.Count != 3 {
+		t.Errorf("Expected 3 arrows with EntityId 77, got %v with EntityId %d", entity.Slot, entity.GetEntityId())
+	}
+	if entityMgr.AddEntityId(77) {
+		t.Errorf("Expected EntityId 77 to be reserved by the received shard")
+	}
+}
+
+type testVersionedChunkStore struct {
+	chunkstore.IChunkStore
+	versions map[uint64]storage.Version
+}
+
+func (s *testVersionedChunkStore) ChunkVersion(chunkLoc ChunkXz) (version storage.Version, ok bool) {
+	version, ok = s.versions[chunkLoc.ChunkKey()]
+	return
+}
+
+func (s *testVersionedChunkStore) SetChunkVersion(chunkLoc ChunkXz, version storage.Version) bool {
+	s.versions[chunkLoc.ChunkKey()] = version
+	return true
+}
+
+func TestShardStateCarriesChunkVersions(t *testing.T) {
+	chunkLoc := ChunkXz{1, 2}
+	unversionedLoc := ChunkXz{3, 4}
+
+	oldStore := &testVersionedChunkStore{versions: make(map[uint64]storage.Version)}
+	oldStore.versions[chunkLoc.ChunkKey()] = 7
+
+	shard := NewChunkShard(nil, oldStore, nil, ShardXz{0, 0}, 0, WeatherClear)
+	for _, loc := range []ChunkXz{chunkLoc, unversionedLoc} {
+		chunkIndex, _, _, _ := shard.chunkIndexAndRelLoc(loc)
+		shard.chunks[chunkIndex] = newChunkFromNbt(testChunkNbt(loc), shard)
+	}
+
+	newStore := &testVersionedChunkStore{versions: make(map[uint64]storage.Version)}
+	received := NewChunkShard(nil, newStore, nil, ShardXz{0, 0}, 0, WeatherClear)
+	if err := received.readNbt(shard.writeNbt()); err != nil {
+		t.Fatalf("readNbt: %v", err)
+	}
+
+	if version, ok := newStore.versions[chunkLoc.ChunkKey()]; !ok || version != 7 {
+		t.Errorf("Expected chunk %v to be received with version 7, got %d (%t)", chunkLoc, version, ok)
+	}
+	if version, ok := newStore.versions[unversionedLoc.ChunkKey()]; ok {
+		t.Errorf("Expected chunk %v to be received without a version, got %d", unversionedLoc, version)
+	}
+}
+
+func TestMovedShardForwardsRequests(t *testing.T) {
+	shard := NewChunkShard(nil, nil, nil, ShardXz{0, 0}, 0, WeatherClear)
+
+	player := &testMovedPlayerClient{moved: make(chan ShardXz, 2)}
+	shard.performRequest(&addPlayerClient{42, player})
+
+	if _, err := shard.reqFreeze(); err != nil {
+		t.Fatalf("reqFreeze: %v", err)
+	}
+
+	// Requests are held back while frozen.
+	ran, failed := false, false
+	shard.performRequest(&runFailable{ChunkXz{0, 0}, func(chunk *Chunk) { ran = true }, func() { failed = true }})
+	shard.performRequest(&setBlocksActive{[]BlockXyz{{1, 2, 3}}})
+	if ran || failed || len(shard.deferred) != 2 {
+		t.Fatalf("Expected requests to be deferred")
+	}
+
+	// Once moved, requests from other shards are forwarded, and those from
+	// players are failed back to them.
+	forward := &testForwardShardClient{}
+	shard.reqMoved(forward)
+	if ran || !failed {
+		t.Errorf("Expected player request to fail, got ran=%t failed=%t", ran, failed)
+	}
+	if len(forward.blocks) != 1 || forward.blocks[0].X != 1 || forward.blocks[0].Y != 2 || forward.blocks[0].Z != 3 {
+		t.Errorf("Expected active block to be forwarded, got %v", forward.blocks)
+	}
+
+	select {
+	case shardLoc := <-player.moved:
+		if shardLoc.X != 0 || shardLoc.Z != 0 {
+			t.Errorf("Expected shard {0 0} to move, got %v", shardLoc)
+		}
+	default:
+		t.Errorf("Expected player to be told that the shard moved")
+	}
+
+	// Player requests made after the shard has moved fail straight away.
+	failed = false
+	shard.performRequest(&runFailable{ChunkXz{0, 0}, func(chunk *Chunk) { ran = true }, func() { failed = true }})
+	if ran || !failed {
+		t.Errorf("Expected late player request to fail, got ran=%t failed=%t", ran, failed)
+	}
+
+	// Players connecting afterwards are told straight away.
+	latePlayer := &testMovedPlayerClient{moved: make(chan ShardXz, 1)}
+	shard.performRequest(&addPlayerClient{43, latePlayer})
+	if len(latePlayer.moved) != 1 {
+		t.Errorf("Expected late player to be told that the shard moved")
+	}
+}
+
+func TestReceiveAndDiscardShard(t *testing.T) {
+	shardLoc := ShardXz{0, 0}
+	shardKey := shardLoc.Key()
+
+	mgr := NewLocalShardManager(nil, nil)
+	moved := mgr.getShard(shardLoc, true)
+	if _, err := mgr.freezeShard(shardLoc); err != nil {
+		t.Fatalf("freezeShard: %v", err)
+	}
+	mgr.shardMoved(shardLoc, &testForwardShardClient{})
+
+	// The shard comes back, replacing the moved one, which stops.
+	buf := new(bytes.Buffer)
+	if err := nbt.Write(buf, NewChunkShard(nil, nil, nil, shardLoc, 0, WeatherClear).writeNbt()); err != nil {
+		t.Fatalf("nbt.Write: %v", err)
+	}
+	if err := mgr.ReceiveShard(shardLoc, buf.Bytes()); err != nil {
+		t.Fatalf("ReceiveShard: %v", err)
+	}
+	received := mgr.shards[shardKey]
+	if received == moved || mgr.movedShards[shardKey] {
+		t.Fatalf("Expected the moved shard to be replaced")
+	}
+
+	// Requests to the moved shard reach the one that replaced it.
+	ran := make(chan bool, 1)
+	moved.enqueue(func() {
+		ran <- true
+	})
+	select {
+	case <-ran:
+	case <-time.After(remoteTestTimeout):
+		t.Fatalf("Timed out waiting for the received shard to take the request")
+	}
+
+	// Discarding the received shard puts back the moved one, which fails
+	// requests from players again.
+	mgr.DiscardShard(shardLoc)
+	if mgr.shards[shardKey] != moved || !mgr.movedShards[shardKey] {
+		t.Fatalf("Expected the moved shard to be put back")
+	}
+	failed := make(chan bool, 1)
+	moved.enqueueFailable(ChunkXz{0, 0}, func(chunk *Chunk) {}, func() {
+		failed <- true
+	})
+	select {
+	case <-failed:
+	case <-time.After(remoteTestTimeout):
+		t.Fatalf("Timed out waiting for the moved shard to fail the request")
+	}
+}
+
+func TestLookupPlayerShardClientReconnects(t *testing.T) {
+	serverMgrA, addrA := startTestShardServer(t)
+	serverMgrB, addrB := startTestShardServer(t)
+
+	lookup := shardlookup.NewMemLookup(remoteTestTimeout)
+	lookup.Heartbeat(addrA)
+	mgr := NewLookupShardManager(lookup, DimensionNormal, "", nil)
+
+	player := &testPlayerClient{packets: make(chan []byte, 1)}
+	shardClient := mgr.PlayerShardConnect(42, player, ShardXz{0, 0})
+	shardClient.ReqSubscribeChunk(ChunkXz{1, 2}, true)
+
+	timeout := time.After(remoteTestTimeout)
+
+	var remotePlayer gamerules.IPlayerClient
+	select {
+	case remotePlayer = <-serverMgrA.players:
+	case <-timeout:
+		t.Fatalf("Timed out waiting for player to connect")
+	}
+	select {
+	case <-serverMgrA.subscriptions:
+	case <-timeout:
+		t.Fatalf("Timed out waiting for subscription")
+	}
+
+	// The shard is handed off to B, and A tells the player.
+	lookup.Heartbeat(addrB)
+	if err := lookup.Assign(DimensionNormal, ShardXz{0, 0}, addrB); err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+	remotePlayer.(iShardMovedListener).shardMoved(ShardXz{0, 0})
+
+	select {
+	case <-serverMgrB.players:
+	case <-timeout:
+		t.Fatalf("Timed out waiting for player to reconnect")
+	}
+	select {
+	case chunkLoc := <-serverMgrB.subscriptions:
+		if chunkLoc.X != 1 || chunkLoc.Z != 2 {
+			t.Errorf("Expected resubscription to chunk {1 2}, got %v", chunkLoc)
+		}
+	case <-timeout:
+		t.Fatalf("Timed out waiting for resubscription")
+	}
+}
+
+func TestSetWorldStateDoesNotWaitOnBusyShard(t *testing.T) {
+	mgr := NewLocalShardManager(nil, nil)
+
+	// Neither shard is served. One has requests backed up.
+	busyLoc, idleLoc := ShardXz{0, 0}, ShardXz{1, 0}
+	busy := NewChunkShard(nil, nil, nil, busyLoc, 0, WeatherClear)
+	for len(busy.requests) < cap(busy.requests) {
+		busy.enqueue(func() {})
+	}
+	idle := NewChunkShard(nil, nil, nil, idleLoc, 0, WeatherClear)
+	mgr.shards[busyLoc.Key()] = busy
+	mgr.shards[idleLoc.Key()] = idle
+
+	done := make(chan bool)
+	go func() {
+		mgr.SetWorldState(100, WeatherRain)
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(remoteTestTimeout):
+		t.Fatalf("Timed out waiting for SetWorldState")
+	}
+
+	if len(idle.requests) != 1 {
+		t.Errorf("Expected the idle shard to be sent the world state")
+	}
+	if mgr.worldTime != 100 || mgr.weather != WeatherRain {
+		t.Errorf("Expected the manager to keep the world state for new shards")
+	}
+}
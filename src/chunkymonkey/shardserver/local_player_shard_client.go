@@ -2,6 +2,7 @@ package shardserver
 
 import (
 	"chunkymonkey/gamerules"
+	"chunkymonkey/proto"
 	. "chunkymonkey/types"
 )
 
@@ -13,6 +14,7 @@ type localPlayerShardClient struct {
 }
 
 func newLocalPlayerShardClient(entityId EntityId, player gamerules.IPlayerClient, shard *ChunkShard) *localPlayerShardClient {
+	shard.enqueueRequest(&addPlayerClient{entityId, player})
 	return &localPlayerShardClient{
 		entityId: entityId,
 		player:   player,
@@ -26,6 +28,7 @@ func (conn *localPlayerShardClient) Disconnect() {
 	conn.shard.enqueueAllChunks(func(chunk *Chunk) {
 		chunk.reqUnsubscribeChunk(conn.entityId, false)
 	})
+	conn.shard.enqueueRequest(&removePlayerClient{conn.entityId})
 }
 
 func (conn *localPlayerShardClient) ReqSubscribeChunk(chunkLoc ChunkXz, notify bool) {
@@ -40,9 +43,9 @@ func (conn *localPlayerShardClient) ReqUnsubscribeChunk(chunkLoc ChunkXz) {
 	})
 }
 
-func (conn *localPlayerShardClient) ReqMulticastPlayers(chunkLoc ChunkXz, exclude EntityId, packet []byte) {
+func (conn *localPlayerShardClient) ReqMulticastPlayers(chunkLoc ChunkXz, exclude EntityId, packet []byte, priority proto.PacketPriority) {
 	conn.shard.enqueueOnChunk(chunkLoc, func(chunk *Chunk) {
-		chunk.reqMulticastPlayers(exclude, packet)
+		chunk.reqMulticastPlayers(exclude, packet, priority)
 	})
 }
 
@@ -70,6 +73,18 @@ func (conn *localPlayerShardClient) ReqSetPlayerLook(chunkLoc ChunkXz, look Look
 	})
 }
 
+func (conn *localPlayerShardClient) ReqViewEntities(chunkLoc ChunkXz, view bool) {
+	conn.shard.enqueueOnChunk(chunkLoc, func(chunk *Chunk) {
+		chunk.reqViewEntities(conn.entityId, view)
+	})
+}
+
+func (conn *localPlayerShardClient) ReqTeleportEntities(chunkLoc ChunkXz) {
+	conn.shard.enqueueOnChunk(chunkLoc, func(chunk *Chunk) {
+		chunk.reqTeleportEntities(conn.entityId)
+	})
+}
+
 func (conn *localPlayerShardClient) ReqHitBlock(held gamerules.Slot, target BlockXyz, digStatus DigStatus, face Face) {
 	chunkLoc := target.ToChunkXz()
 
@@ -81,16 +96,28 @@ func (conn *localPlayerShardClient) ReqHitBlock(held gamerules.Slot, target Bloc
 func (conn *localPlayerShardClient) ReqInteractBlock(held gamerules.Slot, target BlockXyz, face Face) {
 	chunkLoc := target.ToChunkXz()
 
-	conn.shard.enqueueOnChunk(*chunkLoc, func(chunk *Chunk) {
-		chunk.reqInteractBlock(conn.player, held, &target, face)
+	// The player is told once the interaction is done, even if the chunk
+	// doesn't exist or the shard has moved.
+	conn.shard.enqueueFailable(*chunkLoc, func(chunk *Chunk) {
+		if chunk != nil {
+			chunk.reqInteractBlock(conn.player, held, &target, face)
+		}
+		conn.player.InteractDone()
+	}, func() {
+		conn.player.InteractDone()
 	})
 }
 
 func (conn *localPlayerShardClient) ReqPlaceItem(target BlockXyz, slot gamerules.Slot) {
 	chunkLoc, _ := target.ToChunkLocal()
 
-	conn.shard.enqueueOnChunk(*chunkLoc, func(chunk *Chunk) {
-		chunk.reqPlaceItem(conn.player, &target, &slot)
+	// The player gets the item back if the shard has moved.
+	conn.shard.enqueueFailable(*chunkLoc, func(chunk *Chunk) {
+		if chunk != nil {
+			chunk.reqPlaceItem(conn.player, &target, &slot)
+		}
+	}, func() {
+		conn.player.GiveItem(slot)
 	})
 }
 
@@ -102,15 +129,26 @@ func (conn *localPlayerShardClient) ReqTakeItem(chunkLoc ChunkXz, entityId Entit
 
 func (conn *localPlayerShardClient) ReqDropItem(content gamerules.Slot, position AbsXyz, velocity AbsVelocity, pickupImmunity Ticks) {
 	chunkLoc := position.ToChunkXz()
-	conn.shard.enqueueOnChunk(chunkLoc, func(chunk *Chunk) {
-		chunk.reqDropItem(conn.player, &content, &position, &velocity, pickupImmunity)
+	conn.shard.enqueueFailable(chunkLoc, func(chunk *Chunk) {
+		if chunk != nil {
+			chunk.reqDropItem(conn.player, &content, &position, &velocity, pickupImmunity)
+		}
+	}, func() {
+		conn.player.GiveItem(content)
 	})
 }
 
 func (conn *localPlayerShardClient) ReqInventoryClick(block BlockXyz, click gamerules.Click) {
 	chunkLoc := block.ToChunkXz()
-	conn.shard.enqueueOnChunk(*chunkLoc, func(chunk *Chunk) {
-		chunk.reqInventoryClick(conn.player, &block, &click)
+	// A click that can't be made is rejected, and ends with the cursor as it
+	// was, as a remote inventory click always does.
+	conn.shard.enqueueFailable(*chunkLoc, func(chunk *Chunk) {
+		if chunk != nil {
+			chunk.reqInventoryClick(conn.player, &block, &click)
+		}
+	}, func() {
+		conn.player.InventoryTxState(block, click.TxId, false)
+		conn.player.InventoryCursorUpdate(block, click.Cursor)
 	})
 }
 
@@ -120,3 +158,44 @@ func (conn *localPlayerShardClient) ReqInventoryUnsubscribed(block BlockXyz) {
 		chunk.reqInventoryUnsubscribed(conn.player, &block)
 	})
 }
+
+func (conn *localPlayerShardClient) ReqCreatePortal(target BlockXyz) {
+	chunkLoc := target.ToChunkXz()
+	conn.shard.enqueueOnChunk(*chunkLoc, func(chunk *Chunk) {
+		chunk.reqCreatePortal(conn.player, &target)
+	})
+}
+
+func (conn *localPlayerShardClient) ReqLeaveBed(bedLoc BlockXyz) {
+	chunkLoc := bedLoc.ToChunkXz()
+	conn.shard.enqueueOnChunk(*chunkLoc, func(chunk *Chunk) {
+		chunk.reqLeaveBed(conn.player, &bedLoc)
+	})
+}
+
+func (conn *localPlayerShardClient) ReqCheckBed(bedLoc BlockXyz) {
+	chunkLoc := bedLoc.ToChunkXz()
+	conn.shard.enqueueOnChunk(*chunkLoc, func(chunk *Chunk) {
+		chunk.reqCheckBed(conn.player, &bedLoc)
+	})
+}
+
+func (conn *localPlayerShardClient) ReqUseEntity(chunkLoc ChunkXz, position AbsXyz, target EntityId, leftClick bool) {
+	conn.shard.enqueueOnChunk(chunkLoc, func(chunk *Chunk) {
+		chunk.reqUseEntity(conn.player, &position, target, leftClick)
+	})
+}
+
+func (conn *localPlayerShardClient) ReqSteerVehicle(chunkLoc ChunkXz, vehicle EntityId, input AbsVelocity) {
+	conn.shard.enqueueOnChunk(chunkLoc, func(chunk *Chunk) {
+		chunk.reqSteerVehicle(conn.entityId, vehicle, &input)
+	})
+}
+
+func (conn *localPlayerShardClient) ReqLaunchProjectile(objType ObjTypeId, position AbsXyz, velocity AbsVelocity) {
+	chunkLoc := position.ToChunkXz()
+	conn.shard.enqueueOnChunk(chunkLoc, func(chunk *Chunk) {
+		// Players can pick their arrows back up once they've landed.
+		chunk.launchProjectile(objType, conn.entityId, &position, &velocity, objType == ObjTypeIdArrow)
+	})
+}
@@ -20,16 +20,9 @@ func (client *localShardShardClient) Disconnect() {
 }
 
 func (client *localShardShardClient) ReqSetActiveBlocks(blocks []BlockXyz) {
-	client.serverShard.enqueue(func() {
-		client.serverShard.reqSetBlocksActive(blocks)
-	})
+	client.serverShard.enqueueRequest(&setBlocksActive{blocks})
 }
 
 func (client *localShardShardClient) ReqTransferEntity(loc ChunkXz, entity gamerules.INonPlayerEntity) {
-	client.serverShard.enqueue(func() {
-		chunk := client.serverShard.chunkAt(loc)
-		if chunk != nil {
-			chunk.transferEntity(entity)
-		}
-	})
+	client.serverShard.enqueueRequest(&transferEntity{loc, entity})
 }
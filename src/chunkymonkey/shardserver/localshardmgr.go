@@ -1,12 +1,15 @@
 package shardserver
 
 import (
+	"bytes"
+	"os"
 	"sync"
 
 	"chunkymonkey/chunkstore"
 	"chunkymonkey/entity"
 	"chunkymonkey/gamerules"
 	. "chunkymonkey/types"
+	"nbt"
 )
 
 // LocalShardManager contains all chunk shards and can look them up. It
@@ -17,14 +20,89 @@ type LocalShardManager struct {
 	chunkStore chunkstore.IChunkStore
 	shards     map[uint64]*ChunkShard
 	lock       sync.Mutex
+
+	// Keys of shards that have been handed off to other chunk servers.
+	movedShards map[uint64]bool
+
+	// Moved shards that have been replaced by ReceiveShard, by key. Each is
+	// kept until the next handoff of its shard, in case the shard that
+	// replaced it is discarded.
+	replacedShards map[uint64]*ChunkShard
+
+	// Used by shards to connect to other shards.
+	shardConnecter gamerules.IShardConnecter
+
+	// If set, shards are only hosted while it says that they are assigned to
+	// this server.
+	owner iShardOwner
+	// Set while this server's lease with the lookup service may have
+	// expired. Shards are suspended, including those created meanwhile.
+	suspended bool
+
+	// Most recent world state, given to newly created shards.
+	worldTime Ticks
+	weather   Weather
+}
+
+// iShardOwner tells a LocalShardManager whether shards are assigned to this
+// server, when they are spread over many servers.
+type iShardOwner interface {
+	ownsShard(shardLoc ShardXz) bool
 }
 
 func NewLocalShardManager(chunkStore chunkstore.IChunkStore, entityMgr *entity.EntityManager) *LocalShardManager {
-	return &LocalShardManager{
+	mgr := &LocalShardManager{
 		entityMgr:  entityMgr,
 		chunkStore: chunkStore,
 		shards:     make(map[uint64]*ChunkShard),
+
+		movedShards:    make(map[uint64]bool),
+		replacedShards: make(map[uint64]*ChunkShard),
 	}
+	mgr.shardConnecter = mgr
+	return mgr
+}
+
+// SetShardConnecter sets what shards created after the call use to connect to
+// other shards, such as a LookupShardManager when shards are spread over many
+// chunk servers. By default, shards only connect to others in this manager.
+func (mgr *LocalShardManager) SetShardConnecter(shardConnecter gamerules.IShardConnecter) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	mgr.shardConnecter = shardConnecter
+}
+
+func (mgr *LocalShardManager) setOwner(owner iShardOwner) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	mgr.owner = owner
+}
+
+// ownsShard returns true if the shard may be hosted by this server. It must
+// not be called with the lock held, as it may ask the lookup service.
+func (mgr *LocalShardManager) ownsShard(shardLoc ShardXz) bool {
+	mgr.lock.Lock()
+	owner := mgr.owner
+	mgr.lock.Unlock()
+
+	return owner == nil || owner.ownsShard(shardLoc)
+}
+
+// newUnownedShard creates a shard to turn away players that connect to a
+// shard that isn't assigned to this server. It acts as a shard that has moved,
+// so they look up its server again, and it stops once they have disconnected.
+func (mgr *LocalShardManager) newUnownedShard(loc ShardXz) *ChunkShard {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	shard := NewChunkShard(mgr.shardConnecter, mgr.chunkStore, mgr.entityMgr, loc, mgr.worldTime, mgr.weather)
+	shard.handoff = shardMoved
+	shard.unowned = true
+	go shard.serve()
+
+	return shard
 }
 
 func (mgr *LocalShardManager) getShard(loc ShardXz, create bool) *ChunkShard {
@@ -39,7 +117,11 @@ func (mgr *LocalShardManager) getShard(loc ShardXz, create bool) *ChunkShard {
 	}
 
 	// Create shard.
-	shard := NewChunkShard(mgr, mgr.chunkStore, mgr.entityMgr, loc)
+	shard := NewChunkShard(mgr.shardConnecter, mgr.chunkStore, mgr.entityMgr, loc, mgr.worldTime, mgr.weather)
+	if mgr.suspended {
+		shard.handoff = shardFrozen
+		shard.suspended = true
+	}
 	mgr.shards[shardKey] = shard
 	go shard.serve()
 
@@ -47,6 +129,10 @@ func (mgr *LocalShardManager) getShard(loc ShardXz, create bool) *ChunkShard {
 }
 
 func (mgr *LocalShardManager) PlayerShardConnect(entityId EntityId, player gamerules.IPlayerClient, shardLoc ShardXz) gamerules.IPlayerShardClient {
+	if !mgr.ownsShard(shardLoc) {
+		return newLocalPlayerShardClient(entityId, player, mgr.newUnownedShard(shardLoc))
+	}
+
 	mgr.lock.Lock()
 	defer mgr.lock.Unlock()
 
@@ -55,6 +141,10 @@ func (mgr *LocalShardManager) PlayerShardConnect(entityId EntityId, player gamer
 }
 
 func (mgr *LocalShardManager) ShardShardConnect(shardLoc ShardXz) gamerules.IShardShardClient {
+	if !mgr.ownsShard(shardLoc) {
+		return nil
+	}
+
 	mgr.lock.Lock()
 	defer mgr.lock.Unlock()
 
@@ -67,6 +157,44 @@ func (mgr *LocalShardManager) ShardShardConnect(shardLoc ShardXz) gamerules.ISha
 	return newLocalShardShardClient(shard)
 }
 
+// SetWorldState informs all shards of the current time and weather in the
+// world.
+func (mgr *LocalShardManager) SetWorldState(worldTime Ticks, weather Weather) {
+	mgr.lock.Lock()
+	mgr.worldTime = worldTime
+	mgr.weather = weather
+
+	shards := make([]*ChunkShard, 0, len(mgr.shards))
+	for _, shard := range mgr.shards {
+		shards = append(shards, shard)
+	}
+	mgr.lock.Unlock()
+
+	// The state is sent every second, so shards that are backed up with
+	// requests aren't waited on, and catch up with a later update instead.
+	for _, shard := range shards {
+		shard.tryEnqueueRequest(&setWorldState{worldTime, weather})
+	}
+}
+
+// StrikeLightning causes lightning to strike the highest block in the
+// column at the given location. It does nothing if the shard for that location
+// is not running.
+func (mgr *LocalShardManager) StrikeLightning(x, z BlockCoord) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	chunkLoc := (&BlockXyz{x, 0, z}).ToChunkXz()
+	shard := mgr.getShard(chunkLoc.ToShardXz(), false)
+	if shard == nil {
+		return
+	}
+
+	shard.enqueueOnChunk(*chunkLoc, func(chunk *Chunk) {
+		chunk.reqStrikeLightning(x, z)
+	})
+}
+
 // TODO remove Enqueue* methods
 
 // EnqueueAllChunks runs a given function on all loaded chunks.
@@ -88,3 +216,174 @@ func (mgr *LocalShardManager) EnqueueOnChunk(loc ChunkXz, fn func(chunk *Chunk))
 	shard := mgr.getShard(loc.ToShardXz(), true)
 	shard.enqueueOnChunk(loc, fn)
 }
+
+// freezeShard stops the shard at the given location so that it can be handed
+// off to another chunk server, and returns its state.
+func (mgr *LocalShardManager) freezeShard(shardLoc ShardXz) (state []byte, err os.Error) {
+	mgr.lock.Lock()
+	shard := mgr.getShard(shardLoc, false)
+	mgr.lock.Unlock()
+
+	if shard == nil {
+		return nil, os.NewError("shard is not hosted here")
+	}
+
+	return shard.freeze()
+}
+
+// resumeShard restarts a frozen shard, when handing it off has failed.
+func (mgr *LocalShardManager) resumeShard(shardLoc ShardXz) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	if shard := mgr.getShard(shardLoc, false); shard != nil {
+		shard.resume()
+	}
+}
+
+// shardMoved marks a frozen shard as having been handed off to another chunk
+// server. The shard is kept to forward requests to its new server through
+// movedClient, and to tell players that connect to it that it has moved.
+func (mgr *LocalShardManager) shardMoved(shardLoc ShardXz, movedClient gamerules.IShardShardClient) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	if shard := mgr.getShard(shardLoc, false); shard != nil {
+		shard.moved(movedClient)
+		mgr.movedShards[shardLoc.Key()] = true
+	}
+}
+
+// ReceiveShard starts a shard handed off from another chunk server. It
+// replaces a shard that had previously moved away from this manager, which is
+// stopped. The new shard takes over the requests of the moved shard, so that
+// those still connected to it reach the new one.
+func (mgr *LocalShardManager) ReceiveShard(shardLoc ShardXz, state []byte) os.Error {
+	tag, err := nbt.Read(bytes.NewBuffer(state))
+	if err != nil {
+		return err
+	}
+
+	mgr.lock.Lock()
+	shardKey := shardLoc.Key()
+	moved, ok := mgr.shards[shardKey]
+	if ok && !mgr.movedShards[shardKey] {
+		mgr.lock.Unlock()
+		return os.NewError("shard is already hosted here")
+	}
+
+	shard := NewChunkShard(mgr.shardConnecter, mgr.chunkStore, mgr.entityMgr, shardLoc, mgr.worldTime, mgr.weather)
+	if err = shard.readNbt(tag); err != nil {
+		mgr.lock.Unlock()
+		shard.releaseEntityIds()
+		return err
+	}
+	mgr.movedShards[shardKey] = false, false
+	mgr.lock.Unlock()
+
+	if moved != nil {
+		// The moved shard may be talking to players that would need the lock.
+		moved.stop()
+		shard.requests = moved.requests
+	}
+
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	mgr.shards[shardKey] = shard
+	if moved != nil {
+		mgr.replacedShards[shardKey] = moved
+	} else {
+		mgr.replacedShards[shardKey] = nil, false
+	}
+	go shard.serve()
+
+	return nil
+}
+
+// DiscardShard stops a shard started by ReceiveShard, when the chunk server
+// that handed it off has failed to make this server its owner, and so carries
+// on with the shard itself. A moved shard that it replaced is started again.
+func (mgr *LocalShardManager) DiscardShard(shardLoc ShardXz) {
+	mgr.lock.Lock()
+	shardKey := shardLoc.Key()
+	shard, ok := mgr.shards[shardKey]
+	if !ok || mgr.movedShards[shardKey] {
+		mgr.lock.Unlock()
+		return
+	}
+	replaced := mgr.replacedShards[shardKey]
+	mgr.replacedShards[shardKey] = nil, false
+	mgr.lock.Unlock()
+
+	shard.stop()
+	shard.releaseEntityIds()
+
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	if replaced != nil {
+		mgr.shards[shardKey] = replaced
+		mgr.movedShards[shardKey] = true
+		go replaced.serve()
+	} else {
+		mgr.shards[shardKey] = nil, false
+	}
+}
+
+// hostedShards returns the locations of the shards hosted here, other than
+// those that have moved.
+func (mgr *LocalShardManager) hostedShards() (shardLocs []ShardXz) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	for shardKey, shard := range mgr.shards {
+		if !mgr.movedShards[shardKey] {
+			shardLocs = append(shardLocs, shard.loc)
+		}
+	}
+	return
+}
+
+// suspendShards freezes the shards hosted here, when this server's lease with
+// the lookup service may have expired, and the shards may be assigned to
+// other servers.
+func (mgr *LocalShardManager) suspendShards() {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	mgr.suspended = true
+	for _, shard := range mgr.shards {
+		shard.suspend()
+	}
+}
+
+// resumeShards restarts the shards frozen by suspendShards.
+func (mgr *LocalShardManager) resumeShards() {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	mgr.suspended = false
+	for _, shard := range mgr.shards {
+		shard.unsuspend()
+	}
+}
+
+// releaseShard stops hosting a shard that the lookup service has assigned to
+// another server without a handoff, such as after this server's lease
+// expired. The shard acts as one that has moved, forwarding requests through
+// movedClient, but the manager forgets it, so that it is loaded afresh if it
+// is assigned back to this server.
+func (mgr *LocalShardManager) releaseShard(shardLoc ShardXz, movedClient gamerules.IShardShardClient) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	shardKey := shardLoc.Key()
+	shard, ok := mgr.shards[shardKey]
+	if !ok || mgr.movedShards[shardKey] {
+		return
+	}
+	mgr.shards[shardKey] = nil, false
+	mgr.replacedShards[shardKey] = nil, false
+	shard.moved(movedClient)
+}
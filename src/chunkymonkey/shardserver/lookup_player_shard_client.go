@@ -0,0 +1,361 @@
+package shardserver
+
+import (
+	"sync"
+
+	"chunkymonkey/gamerules"
+	"chunkymonkey/proto"
+	. "chunkymonkey/types"
+)
+
+// lookupPlayerShardClient implements IPlayerShardClient for
+// LookupShardManager. It connects to the server that hosts the shard when
+// first used, and looks the shard up again with each request until that
+// succeeds (e.g while no chunk servers hold a lease, or while the server that
+// hosted the shard is down). Requests made while it is not connected are
+// dropped.
+//
+// When the shard is handed off to another server, or the connection to its
+// server is lost, it reconnects, and resubscribes to the chunks that the
+// player was subscribed to. The entities shown from the shard are hidden
+// first, and shown again by the new server, so that those which have gone in
+// the meantime don't linger on the client.
+type lookupPlayerShardClient struct {
+	mgr      *LookupShardManager
+	entityId EntityId
+	player   *lookupPlayerClient
+	shardLoc ShardXz
+
+	// lock guards the following fields.
+	lock   sync.Mutex
+	client gamerules.IPlayerShardClient
+
+	// Subscriptions to chunks in the shard, keyed by ChunkKey, those whose
+	// entities the player views, and the player's data in the shard if any.
+	// They are sent again on reconnecting.
+	subscriptions map[uint64]ChunkXz
+	views         map[uint64]ChunkXz
+	playerData    *psReqAddPlayerData
+}
+
+// lookupPlayerClient passes requests from shards on to the player, and tells
+// its lookupPlayerShardClient when the shard moves to another server. It notes
+// the entities shown to the player from each chunk, so that they can be hidden
+// on reconnecting.
+type lookupPlayerClient struct {
+	gamerules.IPlayerClient
+	client *lookupPlayerShardClient
+
+	lock  sync.Mutex
+	shown map[uint64]map[EntityId]ChunkXz // Entities shown, by ChunkKey.
+}
+
+func (p *lookupPlayerClient) shardMoved(shardLoc ShardXz) {
+	go p.client.reconnect()
+}
+
+func (p *lookupPlayerClient) ShowEntity(chunkLoc ChunkXz, entityId EntityId, spawnPacket []byte) {
+	p.lock.Lock()
+	key := chunkLoc.ChunkKey()
+	entities, ok := p.shown[key]
+	if !ok {
+		entities = make(map[EntityId]ChunkXz)
+		p.shown[key] = entities
+	}
+	entities[entityId] = chunkLoc
+	p.lock.Unlock()
+
+	p.IPlayerClient.ShowEntity(chunkLoc, entityId, spawnPacket)
+}
+
+func (p *lookupPlayerClient) HideEntity(chunkLoc ChunkXz, entityId EntityId) {
+	p.lock.Lock()
+	if entities, ok := p.shown[chunkLoc.ChunkKey()]; ok {
+		entities[entityId] = chunkLoc, false
+	}
+	p.lock.Unlock()
+
+	p.IPlayerClient.HideEntity(chunkLoc, entityId)
+}
+
+// forgetChunk forgets the entities shown from a chunk that the player no
+// longer views, which the player hides itself.
+func (p *lookupPlayerClient) forgetChunk(chunkLoc ChunkXz) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.shown[chunkLoc.ChunkKey()] = nil, false
+}
+
+// hideAll hides all of the entities shown to the player from the shard.
+func (p *lookupPlayerClient) hideAll() {
+	p.lock.Lock()
+	shown := p.shown
+	p.shown = make(map[uint64]map[EntityId]ChunkXz)
+	p.lock.Unlock()
+
+	for _, entities := range shown {
+		for entityId, chunkLoc := range entities {
+			p.IPlayerClient.HideEntity(chunkLoc, entityId)
+		}
+	}
+}
+
+func newLookupPlayerShardClient(mgr *LookupShardManager, entityId EntityId, player gamerules.IPlayerClient, shardLoc ShardXz) *lookupPlayerShardClient {
+	client := &lookupPlayerShardClient{
+		mgr:           mgr,
+		entityId:      entityId,
+		shardLoc:      shardLoc,
+		subscriptions: make(map[uint64]ChunkXz),
+		views:         make(map[uint64]ChunkXz),
+	}
+	client.player = &lookupPlayerClient{
+		IPlayerClient: player,
+		client:        client,
+		shown:         make(map[uint64]map[EntityId]ChunkXz),
+	}
+	client.shardClient()
+	return client
+}
+
+// shardClient returns the connection to the shard, connecting first if need
+// be. Returns nil if the shard can't be connected to.
+func (c *lookupPlayerShardClient) shardClient() gamerules.IPlayerShardClient {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.connect()
+}
+
+// connect is as shardClient, but expects the lock to be held. The player's
+// subscriptions and data are restored on the server connected to, so that a
+// failed reconnect is made good by the next request. Entities still shown from
+// an earlier connection are hidden, as the server shows those in the chunks
+// viewed again.
+func (c *lookupPlayerShardClient) connect() gamerules.IPlayerShardClient {
+	if c.client != nil {
+		return c.client
+	}
+
+	shardMgr := c.mgr.managerForShard(c.shardLoc, true)
+	if shardMgr == nil {
+		return nil
+	}
+	c.player.hideAll()
+	client := shardMgr.PlayerShardConnect(c.entityId, c.player, c.shardLoc)
+	c.client = client
+
+	for _, chunkLoc := range c.subscriptions {
+		client.ReqSubscribeChunk(chunkLoc, false)
+	}
+	for _, chunkLoc := range c.views {
+		client.ReqViewEntities(chunkLoc, true)
+	}
+	if data := c.playerData; data != nil {
+		client.ReqAddPlayerData(data.ChunkLoc, data.Name, data.Position, data.Look, data.Held)
+	}
+
+	return client
+}
+
+// reconnect looks up the server that now hosts the shard, and connects to it.
+func (c *lookupPlayerShardClient) reconnect() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.client == nil {
+		// Disconnected in the meantime.
+		return
+	}
+	c.client.Disconnect()
+	c.client = nil
+
+	c.connect()
+}
+
+func (c *lookupPlayerShardClient) Disconnect() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.client != nil {
+		c.client.Disconnect()
+		c.client = nil
+	}
+}
+
+func (c *lookupPlayerShardClient) ReqSubscribeChunk(chunkLoc ChunkXz, notify bool) {
+	// Connecting first restores the earlier subscriptions, but not this one.
+	c.lock.Lock()
+	client := c.connect()
+	c.subscriptions[chunkLoc.ChunkKey()] = chunkLoc
+	c.lock.Unlock()
+
+	if client != nil {
+		client.ReqSubscribeChunk(chunkLoc, notify)
+	}
+}
+
+func (c *lookupPlayerShardClient) ReqUnsubscribeChunk(chunkLoc ChunkXz) {
+	c.lock.Lock()
+	c.subscriptions[chunkLoc.ChunkKey()] = chunkLoc, false
+	c.views[chunkLoc.ChunkKey()] = chunkLoc, false
+	c.lock.Unlock()
+	c.player.forgetChunk(chunkLoc)
+
+	if client := c.shardClient(); client != nil {
+		client.ReqUnsubscribeChunk(chunkLoc)
+	}
+}
+
+func (c *lookupPlayerShardClient) ReqMulticastPlayers(chunkLoc ChunkXz, exclude EntityId, packet []byte, priority proto.PacketPriority) {
+	if client := c.shardClient(); client != nil {
+		client.ReqMulticastPlayers(chunkLoc, exclude, packet, priority)
+	}
+}
+
+func (c *lookupPlayerShardClient) ReqAddPlayerData(chunkLoc ChunkXz, name string, position AbsXyz, look LookBytes, held ItemTypeId) {
+	c.lock.Lock()
+	client := c.connect()
+	c.playerData = &psReqAddPlayerData{chunkLoc, name, position, look, held}
+	c.lock.Unlock()
+
+	if client != nil {
+		client.ReqAddPlayerData(chunkLoc, name, position, look, held)
+	}
+}
+
+func (c *lookupPlayerShardClient) ReqRemovePlayerData(chunkLoc ChunkXz, isDisconnect bool) {
+	c.lock.Lock()
+	// Players moving between chunks add their data to the new chunk before
+	// removing it from the old.
+	if c.playerData != nil && c.playerData.ChunkLoc.X == chunkLoc.X && c.playerData.ChunkLoc.Z == chunkLoc.Z {
+		c.playerData = nil
+	}
+	c.lock.Unlock()
+
+	if client := c.shardClient(); client != nil {
+		client.ReqRemovePlayerData(chunkLoc, isDisconnect)
+	}
+}
+
+func (c *lookupPlayerShardClient) ReqSetPlayerPosition(chunkLoc ChunkXz, position AbsXyz) {
+	c.lock.Lock()
+	if c.playerData != nil {
+		c.playerData.Position = position
+	}
+	c.lock.Unlock()
+
+	if client := c.shardClient(); client != nil {
+		client.ReqSetPlayerPosition(chunkLoc, position)
+	}
+}
+
+func (c *lookupPlayerShardClient) ReqSetPlayerLook(chunkLoc ChunkXz, look LookBytes) {
+	c.lock.Lock()
+	if c.playerData != nil {
+		c.playerData.Look = look
+	}
+	c.lock.Unlock()
+
+	if client := c.shardClient(); client != nil {
+		client.ReqSetPlayerLook(chunkLoc, look)
+	}
+}
+
+func (c *lookupPlayerShardClient) ReqViewEntities(chunkLoc ChunkXz, view bool) {
+	c.lock.Lock()
+	client := c.connect()
+	c.views[chunkLoc.ChunkKey()] = chunkLoc, view
+	c.lock.Unlock()
+	if !view {
+		c.player.forgetChunk(chunkLoc)
+	}
+
+	if client != nil {
+		client.ReqViewEntities(chunkLoc, view)
+	}
+}
+
+func (c *lookupPlayerShardClient) ReqTeleportEntities(chunkLoc ChunkXz) {
+	if client := c.shardClient(); client != nil {
+		client.ReqTeleportEntities(chunkLoc)
+	}
+}
+
+func (c *lookupPlayerShardClient) ReqHitBlock(held gamerules.Slot, target BlockXyz, digStatus DigStatus, face Face) {
+	if client := c.shardClient(); client != nil {
+		client.ReqHitBlock(held, target, digStatus, face)
+	}
+}
+
+func (c *lookupPlayerShardClient) ReqInteractBlock(held gamerules.Slot, target BlockXyz, face Face) {
+	if client := c.shardClient(); client != nil {
+		client.ReqInteractBlock(held, target, face)
+	}
+}
+
+func (c *lookupPlayerShardClient) ReqPlaceItem(target BlockXyz, slot gamerules.Slot) {
+	if client := c.shardClient(); client != nil {
+		client.ReqPlaceItem(target, slot)
+	}
+}
+
+func (c *lookupPlayerShardClient) ReqTakeItem(chunkLoc ChunkXz, entityId EntityId) {
+	if client := c.shardClient(); client != nil {
+		client.ReqTakeItem(chunkLoc, entityId)
+	}
+}
+
+func (c *lookupPlayerShardClient) ReqDropItem(content gamerules.Slot, position AbsXyz, velocity AbsVelocity, pickupImmunity Ticks) {
+	if client := c.shardClient(); client != nil {
+		client.ReqDropItem(content, position, velocity, pickupImmunity)
+	}
+}
+
+func (c *lookupPlayerShardClient) ReqInventoryClick(block BlockXyz, click gamerules.Click) {
+	if client := c.shardClient(); client != nil {
+		client.ReqInventoryClick(block, click)
+	}
+}
+
+func (c *lookupPlayerShardClient) ReqInventoryUnsubscribed(block BlockXyz) {
+	if client := c.shardClient(); client != nil {
+		client.ReqInventoryUnsubscribed(block)
+	}
+}
+
+func (c *lookupPlayerShardClient) ReqCreatePortal(target BlockXyz) {
+	if client := c.shardClient(); client != nil {
+		client.ReqCreatePortal(target)
+	}
+}
+
+func (c *lookupPlayerShardClient) ReqLeaveBed(bedLoc BlockXyz) {
+	if client := c.shardClient(); client != nil {
+		client.ReqLeaveBed(bedLoc)
+	}
+}
+
+func (c *lookupPlayerShardClient) ReqCheckBed(bedLoc BlockXyz) {
+	if client := c.shardClient(); client != nil {
+		client.ReqCheckBed(bedLoc)
+	}
+}
+
+func (c *lookupPlayerShardClient) ReqUseEntity(chunkLoc ChunkXz, position AbsXyz, target EntityId, leftClick bool) {
+	if client := c.shardClient(); client != nil {
+		client.ReqUseEntity(chunkLoc, position, target, leftClick)
+	}
+}
+
+func (c *lookupPlayerShardClient) ReqSteerVehicle(chunkLoc ChunkXz, vehicle EntityId, input AbsVelocity) {
+	if client := c.shardClient(); client != nil {
+		client.ReqSteerVehicle(chunkLoc, vehicle, input)
+	}
+}
+
+func (c *lookupPlayerShardClient) ReqLaunchProjectile(objType ObjTypeId, position AbsXyz, velocity AbsVelocity) {
+	if client := c.shardClient(); client != nil {
+		client.ReqLaunchProjectile(objType, position, velocity)
+	}
+}
@@ -0,0 +1,313 @@
+package shardserver
+
+import (
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"chunkymonkey/gamerules"
+	"chunkymonkey/shardlookup"
+	. "chunkymonkey/types"
+)
+
+// LookupShardManager implements IShardManager for shards spread over many
+// chunk servers, using a lookup service to find the server that hosts each
+// shard. Frontends use it to connect players to shards, and chunk servers use
+// it to connect their shards to those on other servers.
+type LookupShardManager struct {
+	lookup    shardlookup.IShardLookup
+	dimension DimensionId
+
+	// The address that this process serves shards on, and the manager for
+	// them. Both are empty for frontends, which host no shards.
+	selfAddr string
+	local    *LocalShardManager
+
+	// lock guards the following fields.
+	lock    sync.Mutex
+	remotes map[string]*RemoteShardManager
+
+	// Most recent world state, given to newly connected chunk servers.
+	worldTime Ticks
+	weather   Weather
+}
+
+// NewLookupShardManager creates a LookupShardManager for the shards in the
+// given dimension. If this process hosts shards, selfAddr is the address that
+// it serves them on and local is the manager for them; shards that the lookup
+// service assigns to selfAddr are connected to through local.
+//
+// The local manager only hosts the shards that the lookup service assigns to
+// selfAddr.
+func NewLookupShardManager(lookup shardlookup.IShardLookup, dimension DimensionId, selfAddr string, local *LocalShardManager) *LookupShardManager {
+	mgr := &LookupShardManager{
+		lookup:    lookup,
+		dimension: dimension,
+		selfAddr:  selfAddr,
+		local:     local,
+		remotes:   make(map[string]*RemoteShardManager),
+	}
+	if local != nil {
+		local.setOwner(mgr)
+	}
+	return mgr
+}
+
+// ownsShard returns true if the lookup service has assigned the shard to this
+// server. Errors looking it up are taken as not.
+func (mgr *LookupShardManager) ownsShard(shardLoc ShardXz) bool {
+	owner, ok, err := mgr.lookup.Owner(mgr.dimension, shardLoc)
+	if err != nil {
+		log.Printf("LookupShardManager: error looking up owner of shard %v: %v", shardLoc, err)
+		return false
+	}
+	return ok && owner == mgr.selfAddr
+}
+
+// managerFor returns the IShardManager for the shards hosted by the server at
+// the given address, connecting to it if need be.
+func (mgr *LookupShardManager) managerFor(serverAddr string) (shardMgr IShardManager, err os.Error) {
+	if mgr.local != nil && serverAddr == mgr.selfAddr {
+		return mgr.local, nil
+	}
+
+	if remote := mgr.liveRemote(serverAddr); remote != nil {
+		return remote, nil
+	}
+
+	// The lock isn't held while connecting, so that requests for other
+	// servers aren't held up by one that is slow to answer.
+	remote, err := NewRemoteShardManager(serverAddr, mgr.dimension)
+	if err != nil {
+		return
+	}
+
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	if existing, ok := mgr.remotes[serverAddr]; ok && !existing.isClosed() {
+		// Another connection was made meanwhile.
+		remote.Close()
+		return existing, nil
+	}
+	remote.SetWorldState(mgr.worldTime, mgr.weather)
+	mgr.remotes[serverAddr] = remote
+
+	return remote, nil
+}
+
+// liveRemote returns the connection to the server at the given address, or
+// nil if there is none or it has been lost.
+func (mgr *LookupShardManager) liveRemote(serverAddr string) *RemoteShardManager {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	remote, ok := mgr.remotes[serverAddr]
+	if !ok {
+		return nil
+	}
+	if remote.isClosed() {
+		mgr.remotes[serverAddr] = nil, false
+		return nil
+	}
+	return remote
+}
+
+// managerForShard returns the IShardManager for the server that hosts the
+// shard. If assign is true, the shard is assigned to a server if it has none.
+// Returns nil if the shard has no server, or on error.
+func (mgr *LookupShardManager) managerForShard(shardLoc ShardXz, assign bool) IShardManager {
+	var serverAddr string
+	var err os.Error
+
+	if assign {
+		serverAddr, err = mgr.lookup.Lookup(mgr.dimension, shardLoc)
+	} else {
+		var ok bool
+		if serverAddr, ok, err = mgr.lookup.Owner(mgr.dimension, shardLoc); err == nil && !ok {
+			return nil
+		}
+	}
+	if err != nil {
+		log.Printf("LookupShardManager: error looking up shard %v: %v", shardLoc, err)
+		return nil
+	}
+
+	shardMgr, err := mgr.managerFor(serverAddr)
+	if err != nil {
+		log.Printf("LookupShardManager: error connecting to %s for shard %v: %v", serverAddr, shardLoc, err)
+		return nil
+	}
+
+	return shardMgr
+}
+
+func (mgr *LookupShardManager) PlayerShardConnect(entityId EntityId, player gamerules.IPlayerClient, shardLoc ShardXz) gamerules.IPlayerShardClient {
+	return newLookupPlayerShardClient(mgr, entityId, player, shardLoc)
+}
+
+func (mgr *LookupShardManager) ShardShardConnect(shardLoc ShardXz) gamerules.IShardShardClient {
+	shardMgr := mgr.managerForShard(shardLoc, false)
+	if shardMgr == nil {
+		return nil
+	}
+
+	return shardMgr.ShardShardConnect(shardLoc)
+}
+
+// SetWorldState informs all connected chunk servers of the current time and
+// weather in the world.
+func (mgr *LookupShardManager) SetWorldState(worldTime Ticks, weather Weather) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	mgr.worldTime = worldTime
+	mgr.weather = weather
+
+	if mgr.local != nil {
+		mgr.local.SetWorldState(worldTime, weather)
+	}
+	for _, remote := range mgr.remotes {
+		remote.SetWorldState(worldTime, weather)
+	}
+}
+
+func (mgr *LookupShardManager) StrikeLightning(x, z BlockCoord) {
+	chunkLoc := (&BlockXyz{x, 0, z}).ToChunkXz()
+	if shardMgr := mgr.managerForShard(chunkLoc.ToShardXz(), false); shardMgr != nil {
+		shardMgr.StrikeLightning(x, z)
+	}
+}
+
+// HandOff moves a shard hosted by this process to the chunk server at the
+// given address, without disconnecting its players. The shard stops while its
+// state is sent, and carries on from where it was if that fails. Players
+// connected to it reconnect to the new server.
+func (mgr *LookupShardManager) HandOff(shardLoc ShardXz, serverAddr string) (err os.Error) {
+	if mgr.local == nil {
+		return os.NewError("no shards are hosted by this process")
+	}
+	if serverAddr == mgr.selfAddr {
+		return os.NewError("cannot hand off shard to its own server")
+	}
+
+	if owner, ok, err := mgr.lookup.Owner(mgr.dimension, shardLoc); err != nil {
+		return err
+	} else if !ok || owner != mgr.selfAddr {
+		return os.NewError("shard is not owned by this server")
+	}
+
+	shardMgr, err := mgr.managerFor(serverAddr)
+	if err != nil {
+		return
+	}
+	receiver, ok := shardMgr.(IShardReceiver)
+	if !ok {
+		return os.NewError("server cannot receive shards")
+	}
+
+	state, err := mgr.local.freezeShard(shardLoc)
+	if err != nil {
+		return
+	}
+
+	if err = receiver.ReceiveShard(shardLoc, state); err != nil {
+		mgr.local.resumeShard(shardLoc)
+		return
+	}
+
+	if err = mgr.lookup.Assign(mgr.dimension, shardLoc, serverAddr); err != nil {
+		receiver.DiscardShard(shardLoc)
+		mgr.local.resumeShard(shardLoc)
+		return
+	}
+
+	mgr.local.shardMoved(shardLoc, shardMgr.ShardShardConnect(shardLoc))
+
+	return nil
+}
+
+// checkOwnership releases the local shards that the lookup service has
+// assigned to other servers, and resumes the rest if they were suspended.
+// Shards whose owner can't be looked up are kept.
+func (mgr *LookupShardManager) checkOwnership() {
+	if mgr.local == nil {
+		return
+	}
+
+	for _, shardLoc := range mgr.local.hostedShards() {
+		owner, ok, err := mgr.lookup.Owner(mgr.dimension, shardLoc)
+		if err != nil {
+			log.Printf("LookupShardManager: error looking up owner of shard %v: %v", shardLoc, err)
+			continue
+		}
+		if ok && owner == mgr.selfAddr {
+			continue
+		}
+
+		log.Printf("LookupShardManager: shard %v is no longer assigned to this server", shardLoc)
+		var movedClient gamerules.IShardShardClient
+		if ok {
+			if shardMgr, err := mgr.managerFor(owner); err == nil {
+				movedClient = shardMgr.ShardShardConnect(shardLoc)
+			}
+		}
+		mgr.local.releaseShard(shardLoc, movedClient)
+	}
+
+	mgr.local.resumeShards()
+}
+
+// KeepLease keeps the lease of the server at selfAddr with the lookup service
+// alive, by sending heartbeats every interval. After each heartbeat, the
+// managers release the shards that are no longer assigned to this server. If
+// no heartbeat has been answered for leaseTime, the lease may have expired
+// and the shards been assigned elsewhere, so the managers' shards are
+// suspended until one is. Times are in nanoseconds. It never returns.
+func KeepLease(lookup shardlookup.IShardLookup, selfAddr string, interval, leaseTime int64, mgrs []*LookupShardManager) {
+	// The send times of heartbeats that were answered.
+	heartbeats := make(chan int64)
+	go func() {
+		ticker := time.NewTicker(interval)
+		for {
+			sent := time.Nanoseconds()
+			if err := lookup.Heartbeat(selfAddr); err != nil {
+				log.Printf("KeepLease: error sending heartbeat to lookup server: %v", err)
+			} else {
+				heartbeats <- sent
+			}
+			<-ticker.C
+		}
+	}()
+
+	suspended := false
+	// The lease is taken to run from when the last answered heartbeat was
+	// sent, as the lookup service may have received it at any time after.
+	expires := time.Nanoseconds() + leaseTime
+
+	for {
+		var expired <-chan int64
+		if !suspended {
+			expired = time.After(expires - time.Nanoseconds())
+		}
+
+		select {
+		case sent := <-heartbeats:
+			expires = sent + leaseTime
+			suspended = false
+			for _, mgr := range mgrs {
+				mgr.checkOwnership()
+			}
+
+		case <-expired:
+			log.Printf("KeepLease: no heartbeat answered for %d seconds; suspending shards", leaseTime/NanosecondsInSecond)
+			suspended = true
+			for _, mgr := range mgrs {
+				if mgr.local != nil {
+					mgr.local.suspendShards()
+				}
+			}
+		}
+	}
+}
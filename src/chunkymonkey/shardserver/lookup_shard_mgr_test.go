@@ -0,0 +1,276 @@
+package shardserver
+
+import (
+	"testing"
+	"time"
+
+	"chunkymonkey/gamerules"
+	"chunkymonkey/shardlookup"
+	. "chunkymonkey/types"
+)
+
+func TestLookupShardManager(t *testing.T) {
+	serverMgr, addr := startTestShardServer(t)
+
+	lookup := shardlookup.NewMemLookup(remoteTestTimeout)
+	mgr := NewLookupShardManager(lookup, DimensionNormal, "", nil)
+
+	// Requests are dropped while no chunk server holds a lease.
+	player := &testPlayerClient{packets: make(chan []byte, 1)}
+	shardClient := mgr.PlayerShardConnect(42, player, ShardXz{1, 2})
+	shardClient.ReqSetPlayerPosition(ChunkXz{3, 4}, AbsXyz{1, 64, 1})
+
+	if _, ok, _ := lookup.Owner(DimensionNormal, ShardXz{1, 2}); ok {
+		t.Fatalf("Expected shard to have no owner")
+	}
+
+	// Once a chunk server holds a lease, the shard is assigned to it and the
+	// player connects to it.
+	lookup.Heartbeat(addr)
+	shardClient.ReqSetPlayerPosition(ChunkXz{3, 4}, AbsXyz{1.5, 64, -2.5})
+
+	timeout := time.After(remoteTestTimeout)
+
+	select {
+	case remotePlayer := <-serverMgr.players:
+		if remotePlayer.GetEntityId() != 42 {
+			t.Errorf("Expected player with EntityId 42, got %d", remotePlayer.GetEntityId())
+		}
+	case <-timeout:
+		t.Fatalf("Timed out waiting for player to connect")
+	}
+
+	select {
+	case position := <-serverMgr.positions:
+		if position.X != 1.5 || position.Y != 64 || position.Z != -2.5 {
+			t.Errorf("Expected position {1.5 64 -2.5}, got %v", position)
+		}
+	case <-timeout:
+		t.Fatalf("Timed out waiting for player position")
+	}
+
+	if owner, ok, _ := lookup.Owner(DimensionNormal, ShardXz{1, 2}); !ok || owner != addr {
+		t.Errorf("Expected shard to be owned by %s, got %q", addr, owner)
+	}
+
+	// World state is passed to the chunk servers that have been connected to.
+	mgr.SetWorldState(1234, WeatherClear)
+	select {
+	case worldTime := <-serverMgr.worldTimes:
+		// The world state given when first connecting may arrive first.
+		if worldTime == 0 {
+			worldTime = <-serverMgr.worldTimes
+		}
+		if worldTime != 1234 {
+			t.Errorf("Expected world time 1234, got %d", worldTime)
+		}
+	case <-timeout:
+		t.Fatalf("Timed out waiting for world state")
+	}
+}
+
+func TestLookupShardManagerOnlyHostsOwnedShards(t *testing.T) {
+	lookup := shardlookup.NewMemLookup(remoteTestTimeout)
+	lookup.Heartbeat("self")
+	lookup.Heartbeat("other")
+	if err := lookup.Assign(DimensionNormal, ShardXz{0, 0}, "self"); err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+	if err := lookup.Assign(DimensionNormal, ShardXz{1, 0}, "other"); err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+
+	local := NewLocalShardManager(nil, nil)
+	NewLookupShardManager(lookup, DimensionNormal, "self", local)
+
+	if local.ShardShardConnect(ShardXz{0, 0}) == nil {
+		t.Errorf("Expected to connect to a shard owned by this server")
+	}
+	if local.ShardShardConnect(ShardXz{1, 0}) != nil {
+		t.Errorf("Expected not to connect to a shard owned by another server")
+	}
+
+	// Players connecting to a shard owned by another server are told to look
+	// it up again, and the shard isn't created.
+	player := &testMovedPlayerClient{moved: make(chan ShardXz, 1)}
+	shardClient := local.PlayerShardConnect(42, player, ShardXz{1, 0})
+	select {
+	case <-player.moved:
+	case <-time.After(remoteTestTimeout):
+		t.Fatalf("Timed out waiting for the player to be turned away")
+	}
+	shardClient.Disconnect()
+
+	otherLoc := ShardXz{1, 0}
+	local.lock.Lock()
+	_, ok := local.shards[otherLoc.Key()]
+	local.lock.Unlock()
+	if ok {
+		t.Errorf("Expected no shard to be created for a shard owned by another server")
+	}
+}
+
+// handoffStateOf returns the shard's handoff state, from its goroutine.
+func handoffStateOf(shard *ChunkShard) (state shardHandoffState, suspended bool) {
+	done := make(chan bool)
+	shard.enqueueRequest(&runControl{func() {
+		state, suspended = shard.handoff, shard.suspended
+		done <- true
+	}})
+	<-done
+	return
+}
+
+func TestLookupShardManagerSuspendsAndReleasesShards(t *testing.T) {
+	lookup := shardlookup.NewMemLookup(remoteTestTimeout)
+	lookup.Heartbeat("self")
+	lookup.Heartbeat("other")
+	for _, shardLoc := range []ShardXz{{0, 0}, {1, 0}} {
+		if err := lookup.Assign(DimensionNormal, shardLoc, "self"); err != nil {
+			t.Fatalf("Assign: %v", err)
+		}
+	}
+
+	local := NewLocalShardManager(nil, nil)
+	mgr := NewLookupShardManager(lookup, DimensionNormal, "self", local)
+
+	player := &testMovedPlayerClient{moved: make(chan ShardXz, 1)}
+	local.PlayerShardConnect(42, player, ShardXz{0, 0})
+
+	// While the lease may have expired, shards are suspended, including those
+	// created meanwhile.
+	local.suspendShards()
+	local.ShardShardConnect(ShardXz{1, 0})
+
+	local.lock.Lock()
+	released := local.shards[(&ShardXz{0, 0}).Key()]
+	kept := local.shards[(&ShardXz{1, 0}).Key()]
+	local.lock.Unlock()
+	if released == nil || kept == nil {
+		t.Fatalf("Expected both shards to be hosted")
+	}
+	for _, shard := range []*ChunkShard{released, kept} {
+		if state, suspended := handoffStateOf(shard); state != shardFrozen || !suspended {
+			t.Errorf("Expected shard %v to be suspended, got state %d", shard.loc, state)
+		}
+	}
+
+	// Once the lease is renewed, a shard assigned to another server meanwhile
+	// is released, and its players told that it has moved. The rest resume.
+	if err := lookup.Assign(DimensionNormal, ShardXz{0, 0}, "other"); err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+	mgr.checkOwnership()
+
+	select {
+	case <-player.moved:
+	case <-time.After(remoteTestTimeout):
+		t.Fatalf("Timed out waiting for the player to be told that the shard moved")
+	}
+	if state, _ := handoffStateOf(released); state != shardMoved {
+		t.Errorf("Expected the released shard to have moved, got state %d", state)
+	}
+	if state, suspended := handoffStateOf(kept); state != shardRunning || suspended {
+		t.Errorf("Expected the kept shard to be running, got state %d", state)
+	}
+
+	hosted := local.hostedShards()
+	if len(hosted) != 1 || hosted[0].X != 1 || hosted[0].Z != 0 {
+		t.Errorf("Expected only shard {1 0} to be hosted, got %v", hosted)
+	}
+}
+
+func TestLookupPlayerShardClientReconnectsAfterConnectionLoss(t *testing.T) {
+	serverMgr, addr := startTestShardServer(t)
+
+	lookup := shardlookup.NewMemLookup(remoteTestTimeout)
+	lookup.Heartbeat(addr)
+	mgr := NewLookupShardManager(lookup, DimensionNormal, "", nil)
+
+	player := &testPlayerClient{packets: make(chan []byte, 1)}
+	shardClient := mgr.PlayerShardConnect(42, player, ShardXz{0, 0})
+	shardClient.ReqSubscribeChunk(ChunkXz{1, 2}, true)
+
+	timeout := time.After(remoteTestTimeout)
+
+	select {
+	case <-serverMgr.players:
+	case <-timeout:
+		t.Fatalf("Timed out waiting for player to connect")
+	}
+	select {
+	case <-serverMgr.subscriptions:
+	case <-timeout:
+		t.Fatalf("Timed out waiting for subscription")
+	}
+
+	// The connection is lost, so a new one is made, and the player
+	// resubscribes over it.
+	mgr.lock.Lock()
+	lost := mgr.remotes[addr]
+	mgr.lock.Unlock()
+	lost.Close()
+
+	select {
+	case <-serverMgr.players:
+	case <-timeout:
+		t.Fatalf("Timed out waiting for player to reconnect")
+	}
+	select {
+	case chunkLoc := <-serverMgr.subscriptions:
+		if chunkLoc.X != 1 || chunkLoc.Z != 2 {
+			t.Errorf("Expected resubscription to chunk {1 2}, got %v", chunkLoc)
+		}
+	case <-timeout:
+		t.Fatalf("Timed out waiting for resubscription")
+	}
+
+	mgr.lock.Lock()
+	remote := mgr.remotes[addr]
+	mgr.lock.Unlock()
+	if remote == lost {
+		t.Errorf("Expected the lost connection to be replaced")
+	}
+}
+
+type testViewPlayerClient struct {
+	gamerules.IPlayerClient
+	hidden []EntityId
+}
+
+func (player *testViewPlayerClient) ShowEntity(chunkLoc ChunkXz, entityId EntityId, spawnPacket []byte) {
+}
+
+func (player *testViewPlayerClient) HideEntity(chunkLoc ChunkXz, entityId EntityId) {
+	player.hidden = append(player.hidden, entityId)
+}
+
+func TestLookupPlayerClientHidesShownEntities(t *testing.T) {
+	player := &testViewPlayerClient{}
+	client := &lookupPlayerClient{
+		IPlayerClient: player,
+		shown:         make(map[uint64]map[EntityId]ChunkXz),
+	}
+
+	client.ShowEntity(ChunkXz{0, 0}, 1, nil)
+	client.ShowEntity(ChunkXz{0, 0}, 2, nil)
+	client.ShowEntity(ChunkXz{1, 0}, 3, nil)
+	client.ShowEntity(ChunkXz{2, 0}, 4, nil)
+
+	// Entity 2 is hidden by the shard, and chunk {1 0} is no longer viewed.
+	client.HideEntity(ChunkXz{0, 0}, 2)
+	client.forgetChunk(ChunkXz{1, 0})
+	player.hidden = nil
+
+	// On reconnecting, the entities still shown are hidden, once.
+	client.hideAll()
+	client.hideAll()
+
+	hidden := make(map[EntityId]bool)
+	for _, entityId := range player.hidden {
+		hidden[entityId] = true
+	}
+	if len(player.hidden) != 2 || !hidden[1] || !hidden[4] {
+		t.Errorf("Expected entities 1 and 4 to be hidden, got %v", player.hidden)
+	}
+}
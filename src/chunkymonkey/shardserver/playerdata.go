@@ -5,6 +5,7 @@ import (
 	"os"
 
 	"chunkymonkey/gamerules"
+	"chunkymonkey/physics"
 	"chunkymonkey/proto"
 	. "chunkymonkey/types"
 )
@@ -25,21 +26,26 @@ type playerData struct {
 	look       LookBytes
 	heldItemId ItemTypeId
 	// TODO Armor data.
+
+	// The position and look last sent to the players viewing the chunk.
+	movement physics.Movement
 }
 
 func (player *playerData) sendSpawn(writer io.Writer) os.Error {
 	return proto.WriteNamedEntitySpawn(
 		writer,
 		player.entityId, player.name,
-		player.position.ToAbsIntXyz(),
-		&player.look,
+		&player.movement.LastSentPosition,
+		&player.movement.LastSentLook,
 		player.heldItemId,
 	)
 	// TODO Armor packet(s).
 }
 
-func (player *playerData) sendPositionLook(writer io.Writer) os.Error {
-	return proto.WriteEntityTeleport(
+// sendMovement writes the movement of the player since it was last sent, if
+// any.
+func (player *playerData) sendMovement(writer io.Writer) os.Error {
+	return player.movement.SendMovement(
 		writer,
 		player.entityId,
 		player.position.ToAbsIntXyz(),
@@ -47,6 +53,16 @@ func (player *playerData) sendPositionLook(writer io.Writer) os.Error {
 }
 
 func (player *playerData) OverlapsItem(item *gamerules.Item) bool {
+	return player.overlaps(item.Position())
+}
+
+// aabb returns the player's bounding box.
+func (player *playerData) aabb() physics.Aabb {
+	return physics.NewAabbAround(&player.position, playerAabH, playerAabY)
+}
+
+// overlaps returns true if the position is within the player's bounding box.
+func (player *playerData) overlaps(pos *AbsXyz) bool {
 	// TODO note that calling this function repeatedly is not as efficient as it
 	// could be.
 
@@ -57,7 +73,5 @@ func (player *playerData) OverlapsItem(item *gamerules.Item) bool {
 	minY := player.position.Y
 	maxY := player.position.Y + playerAabY
 
-	pos := item.Position()
-
 	return pos.X >= minX && pos.X <= maxX && pos.Y >= minY && pos.Y <= maxY && pos.Z >= minZ && pos.Z <= maxZ
 }
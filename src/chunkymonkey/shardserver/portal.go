@@ -0,0 +1,157 @@
+package shardserver
+
+import (
+	"chunkymonkey/gamerules"
+	. "chunkymonkey/types"
+)
+
+// lightFire sets fire to the block against the given face of the target, and
+// activates a portal if the fire was lit inside an obsidian frame.
+func (chunk *Chunk) lightFire(target *BlockXyz, againstFace Face) {
+	dx, dy, dz := againstFace.Dxyz()
+	fireLoc := target.AddXyz(dx, dy, dz)
+	if fireLoc == nil {
+		return
+	}
+
+	shard := chunk.shard
+	if blockId, ok := shard.blockIdAt(*fireLoc); !ok || blockId != BlockIdAir {
+		return
+	}
+
+	// TODO Use up the flint and steel.
+	shard.setBlockAt(*fireLoc, BlockIdFire, 0)
+
+	// TODO Frames that cross into another shard are not detected.
+	interior, ok := gamerules.FindPortalInterior(*fireLoc, func(loc BlockXyz) (BlockId, bool) {
+		return shard.blockIdAt(loc)
+	})
+	if !ok {
+		return
+	}
+
+	for _, loc := range interior {
+		shard.setBlockAt(loc, BlockIdPortal, 0)
+	}
+}
+
+// reqCreatePortal is requested when a player arrives in a dimension via a
+// portal. If the chunk already contains a portal, the player is moved to stand
+// in front of it. Otherwise a new portal is built near the target location.
+func (chunk *Chunk) reqCreatePortal(player gamerules.IPlayerClient, target *BlockXyz) {
+	base, alongX, ok := chunk.findPortal()
+	if !ok {
+		base, alongX = chunk.buildPortal(target)
+	}
+
+	arrival := chunk.portalArrival(base, alongX)
+	position := arrival.MidPointToAbsXyz()
+	position.Y = AbsCoord(arrival.Y)
+	player.SetPosition(position)
+}
+
+// findPortal looks for the lowest portal block in the chunk. alongX is true if
+// the portal's width runs along the X axis.
+func (chunk *Chunk) findPortal() (base BlockXyz, alongX bool, ok bool) {
+	var subLoc SubChunkXyz
+	for subLoc.X = 0; subLoc.X < ChunkSizeH; subLoc.X++ {
+		for subLoc.Z = 0; subLoc.Z < ChunkSizeH; subLoc.Z++ {
+			for subLoc.Y = 0; subLoc.Y < ChunkSizeY; subLoc.Y++ {
+				index, _ := subLoc.BlockIndex()
+				if index.BlockId(chunk.blocks) != BlockIdPortal {
+					continue
+				}
+				base = *chunk.loc.ToBlockXyz(&subLoc)
+				// A portal along Z has portal or obsidian blocks on its Z sides,
+				// and open space in front of and behind it on the X axis.
+				sideId, _ := chunk.shard.blockIdAt(BlockXyz{base.X + 1, base.Y, base.Z})
+				alongX = sideId == BlockIdPortal || sideId == BlockIdObsidian
+				return base, alongX, true
+			}
+		}
+	}
+	return
+}
+
+// buildPortal builds an activated portal frame along the X axis, with its
+// lowest interior block as close to the target as will fit within the chunk.
+// Space is cleared either side of the portal, and an obsidian platform placed
+// beneath that space so that players don't arrive in solid rock or lava.
+func (chunk *Chunk) buildPortal(target *BlockXyz) (base BlockXyz, alongX bool) {
+	_, subLoc := target.ToChunkLocal()
+
+	subLoc.X = clampSubCoord(subLoc.X, 1, ChunkSizeH-1-gamerules.PortalInteriorWidth)
+	subLoc.Z = clampSubCoord(subLoc.Z, 1, ChunkSizeH-2)
+	subLoc.Y = clampSubCoord(subLoc.Y, 2, ChunkSizeY-2-gamerules.PortalInteriorHeight)
+
+	base = *chunk.loc.ToBlockXyz(subLoc)
+	shard := chunk.shard
+
+	for dx := BlockCoord(-1); dx <= gamerules.PortalInteriorWidth; dx++ {
+		for dz := BlockCoord(-1); dz <= 1; dz++ {
+			for dy := BlockYCoord(-1); dy <= gamerules.PortalInteriorHeight; dy++ {
+				loc := BlockXyz{base.X + dx, base.Y + dy, base.Z + dz}
+
+				isFrameSide := dx == -1 || dx == gamerules.PortalInteriorWidth
+				isFrameEnd := dy == -1 || dy == gamerules.PortalInteriorHeight
+
+				var blockId BlockId
+				switch {
+				case dz == 0 && (isFrameSide || isFrameEnd):
+					blockId = BlockIdObsidian
+				case dz == 0:
+					blockId = BlockIdPortal
+				case dy == -1:
+					blockId = BlockIdObsidian
+				default:
+					blockId = BlockIdAir
+				}
+				shard.setBlockAt(loc, blockId, 0)
+			}
+		}
+	}
+
+	return base, true
+}
+
+// portalArrival picks the block that a player arriving through the portal
+// should stand at, in front of or behind the portal.
+func (chunk *Chunk) portalArrival(base BlockXyz, alongX bool) BlockXyz {
+	var dx, dz BlockCoord
+	if alongX {
+		dz = 1
+	} else {
+		dx = 1
+	}
+
+	front := BlockXyz{base.X + dx, base.Y, base.Z + dz}
+	back := BlockXyz{base.X - dx, base.Y, base.Z - dz}
+	if chunk.isStandable(front) || !chunk.isStandable(back) {
+		return front
+	}
+	return back
+}
+
+// isStandable returns true if a player could stand at the given location
+// without being inside solid blocks.
+func (chunk *Chunk) isStandable(loc BlockXyz) bool {
+	for dy := BlockYCoord(0); dy < 2; dy++ {
+		blockId, ok := chunk.shard.blockIdAt(BlockXyz{loc.X, loc.Y + dy, loc.Z})
+		if !ok {
+			return false
+		}
+		if blockType, ok := gamerules.Blocks.Get(blockId); !ok || blockType.Solid {
+			return false
+		}
+	}
+	return true
+}
+
+func clampSubCoord(coord SubChunkCoord, min, max SubChunkCoord) SubChunkCoord {
+	if coord < min {
+		return min
+	} else if coord > max {
+		return max
+	}
+	return coord
+}
@@ -0,0 +1,665 @@
+package shardserver
+
+// Defines the wire protocol spoken between a RemoteShardManager (in a frontend
+// server) and a ShardServer (in a chunk server). Each end of a TCP connection
+// sends a stream of gob-encoded remoteMsg values. A single connection carries
+// the traffic for many players' and shards' clients, each identified by a
+// ClientId chosen by the frontend.
+//
+// Requests that frontends make of shards mirror the methods of
+// IPlayerShardClient and IShardShardClient, and those that shards make of
+// players mirror the methods of IPlayerClient.
+
+import (
+	"bytes"
+	"gob"
+	"log"
+	"os"
+
+	"chunkymonkey/gamerules"
+	"chunkymonkey/proto"
+	. "chunkymonkey/types"
+	"nbt"
+)
+
+type remoteClientId uint32
+
+// remoteMsg is the unit of communication in both directions.
+type remoteMsg struct {
+	// The client that the message is to or from. Zero for messages that
+	// concern the connection as a whole.
+	ClientId remoteClientId
+	Body     interface{}
+}
+
+// iPlayerShardReq is a request from a player to a shard, sent from the
+// frontend to the chunk server.
+type iPlayerShardReq interface {
+	applyToShard(client gamerules.IPlayerShardClient)
+}
+
+// iShardShardReq is a request from one shard to another.
+type iShardShardReq interface {
+	applyToShard(client gamerules.IShardShardClient)
+}
+
+// iPlayerReq is a request from a shard to a player, sent from the chunk server
+// to the frontend.
+type iPlayerReq interface {
+	applyToPlayer(player gamerules.IPlayerClient)
+}
+
+func init() {
+	for _, body := range []interface{}{
+		&msgHello{},
+		&msgPlayerConnect{},
+		&msgShardConnect{},
+		msgDisconnect(0),
+		&msgSetWorldState{},
+		&msgStrikeLightning{},
+		&msgReceiveShard{},
+		&msgShardReceived{},
+		&msgDiscardShard{},
+
+		&psReqSubscribeChunk{},
+		&psReqUnsubscribeChunk{},
+		&psReqMulticastPlayers{},
+		&psReqAddPlayerData{},
+		&psReqRemovePlayerData{},
+		&psReqSetPlayerPosition{},
+		&psReqSetPlayerLook{},
+		&psReqViewEntities{},
+		&psReqTeleportEntities{},
+		&psReqHitBlock{},
+		&psReqInteractBlock{},
+		&psReqPlaceItem{},
+		&psReqTakeItem{},
+		&psReqDropItem{},
+		&psReqInventoryClick{},
+		&psReqInventoryUnsubscribed{},
+		&psReqCreatePortal{},
+		&psReqLeaveBed{},
+		&psReqCheckBed{},
+		&psReqUseEntity{},
+		&psReqSteerVehicle{},
+		&psReqLaunchProjectile{},
+
+		&ssReqSetActiveBlocks{},
+		&ssReqTransferEntity{},
+
+		&pReqTransmitPacket{},
+		&pReqShowEntity{},
+		&pReqHideEntity{},
+		pReqNotifyChunkLoad(0),
+		&pReqInventorySubscribed{},
+		&pReqInventorySlotUpdate{},
+		&pReqInventoryProgressUpdate{},
+		&pReqInventoryCursorUpdate{},
+		&pReqInventoryTxState{},
+		&pReqInventoryUnsubscribed{},
+		&pReqPlaceHeldItem{},
+		pReqInteractDone(0),
+		&pReqOfferItem{},
+		&pReqGiveItemAtPosition{},
+		&pReqGiveItem{},
+		&pReqSetPositionLook{},
+		&pReqSetPosition{},
+		pReqEnterPortal(0),
+		&pReqSleep{},
+		&pReqBedMissing{},
+		&pReqMount{},
+		&pReqDismount{},
+		&pReqVehicleMoved{},
+		&pReqDamage{},
+		&pReqEchoMessage{},
+		&pReqShardMoved{},
+		&pReqGiveExperience{},
+	} {
+		gob.Register(body)
+	}
+}
+
+// Connection management messages.
+
+// msgHello is the first message sent by the frontend, and chooses which
+// dimension's shards the connection is for. The frontend and chunk server must
+// agree on the shard size.
+type msgHello struct {
+	Dimension DimensionId
+	ShardSize ChunkCoord
+}
+
+// msgPlayerConnect creates a client for a player to talk to a shard.
+type msgPlayerConnect struct {
+	EntityId EntityId
+	ShardLoc ShardXz
+}
+
+// msgShardConnect creates a client for talking to a shard on behalf of another
+// shard.
+type msgShardConnect struct {
+	ShardLoc ShardXz
+}
+
+// msgDisconnect disconnects a client created by msgPlayerConnect or
+// msgShardConnect. Messages without arguments are not structs, as gob cannot
+// send structs without exported fields.
+type msgDisconnect byte
+
+type msgSetWorldState struct {
+	WorldTime Ticks
+	Weather   Weather
+}
+
+type msgStrikeLightning struct {
+	X, Z BlockCoord
+}
+
+// msgReceiveShard hands off a shard to the chunk server, with the state
+// written by the chunk server that is giving it up. The chunk server replies
+// with msgShardReceived, sent with the same ClientId as the request so that
+// the reply can be matched to it.
+type msgReceiveShard struct {
+	ShardLoc ShardXz
+	State    []byte
+}
+
+// msgShardReceived replies to msgReceiveShard. Err is empty if the shard was
+// started.
+type msgShardReceived struct {
+	ShardLoc ShardXz
+	Err      string
+}
+
+// msgDiscardShard stops a shard received with msgReceiveShard, whose handoff
+// has failed since.
+type msgDiscardShard struct {
+	ShardLoc ShardXz
+}
+
+// Player to shard requests.
+
+type psReqSubscribeChunk struct {
+	ChunkLoc ChunkXz
+	Notify   bool
+}
+
+func (req *psReqSubscribeChunk) applyToShard(client gamerules.IPlayerShardClient) {
+	client.ReqSubscribeChunk(req.ChunkLoc, req.Notify)
+}
+
+type psReqUnsubscribeChunk struct {
+	ChunkLoc ChunkXz
+}
+
+func (req *psReqUnsubscribeChunk) applyToShard(client gamerules.IPlayerShardClient) {
+	client.ReqUnsubscribeChunk(req.ChunkLoc)
+}
+
+type psReqMulticastPlayers struct {
+	ChunkLoc ChunkXz
+	Exclude  EntityId
+	Packet   []byte
+	Priority proto.PacketPriority
+}
+
+func (req *psReqMulticastPlayers) applyToShard(client gamerules.IPlayerShardClient) {
+	client.ReqMulticastPlayers(req.ChunkLoc, req.Exclude, req.Packet, req.Priority)
+}
+
+type psReqAddPlayerData struct {
+	ChunkLoc ChunkXz
+	Name     string
+	Position AbsXyz
+	Look     LookBytes
+	Held     ItemTypeId
+}
+
+func (req *psReqAddPlayerData) applyToShard(client gamerules.IPlayerShardClient) {
+	client.ReqAddPlayerData(req.ChunkLoc, req.Name, req.Position, req.Look, req.Held)
+}
+
+type psReqRemovePlayerData struct {
+	ChunkLoc     ChunkXz
+	IsDisconnect bool
+}
+
+func (req *psReqRemovePlayerData) applyToShard(client gamerules.IPlayerShardClient) {
+	client.ReqRemovePlayerData(req.ChunkLoc, req.IsDisconnect)
+}
+
+type psReqSetPlayerPosition struct {
+	ChunkLoc ChunkXz
+	Position AbsXyz
+}
+
+func (req *psReqSetPlayerPosition) applyToShard(client gamerules.IPlayerShardClient) {
+	client.ReqSetPlayerPosition(req.ChunkLoc, req.Position)
+}
+
+type psReqSetPlayerLook struct {
+	ChunkLoc ChunkXz
+	Look     LookBytes
+}
+
+func (req *psReqSetPlayerLook) applyToShard(client gamerules.IPlayerShardClient) {
+	client.ReqSetPlayerLook(req.ChunkLoc, req.Look)
+}
+
+type psReqViewEntities struct {
+	ChunkLoc ChunkXz
+	View     bool
+}
+
+func (req *psReqViewEntities) applyToShard(client gamerules.IPlayerShardClient) {
+	client.ReqViewEntities(req.ChunkLoc, req.View)
+}
+
+type psReqTeleportEntities struct {
+	ChunkLoc ChunkXz
+}
+
+func (req *psReqTeleportEntities) applyToShard(client gamerules.IPlayerShardClient) {
+	client.ReqTeleportEntities(req.ChunkLoc)
+}
+
+type psReqHitBlock struct {
+	Held      gamerules.Slot
+	Target    BlockXyz
+	DigStatus DigStatus
+	Face      Face
+}
+
+func (req *psReqHitBlock) applyToShard(client gamerules.IPlayerShardClient) {
+	client.ReqHitBlock(req.Held, req.Target, req.DigStatus, req.Face)
+}
+
+type psReqInteractBlock struct {
+	Held   gamerules.Slot
+	Target BlockXyz
+	Face   Face
+}
+
+func (req *psReqInteractBlock) applyToShard(client gamerules.IPlayerShardClient) {
+	client.ReqInteractBlock(req.Held, req.Target, req.Face)
+}
+
+type psReqPlaceItem struct {
+	Target BlockXyz
+	Slot   gamerules.Slot
+}
+
+func (req *psReqPlaceItem) applyToShard(client gamerules.IPlayerShardClient) {
+	client.ReqPlaceItem(req.Target, req.Slot)
+}
+
+type psReqTakeItem struct {
+	ChunkLoc ChunkXz
+	EntityId EntityId
+}
+
+func (req *psReqTakeItem) applyToShard(client gamerules.IPlayerShardClient) {
+	client.ReqTakeItem(req.ChunkLoc, req.EntityId)
+}
+
+type psReqDropItem struct {
+	Content        gamerules.Slot
+	Position       AbsXyz
+	Velocity       AbsVelocity
+	PickupImmunity Ticks
+}
+
+func (req *psReqDropItem) applyToShard(client gamerules.IPlayerShardClient) {
+	client.ReqDropItem(req.Content, req.Position, req.Velocity, req.PickupImmunity)
+}
+
+type psReqInventoryClick struct {
+	Block BlockXyz
+	Click gamerules.Click
+}
+
+func (req *psReqInventoryClick) applyToShard(client gamerules.IPlayerShardClient) {
+	client.ReqInventoryClick(req.Block, req.Click)
+}
+
+type psReqInventoryUnsubscribed struct {
+	Block BlockXyz
+}
+
+func (req *psReqInventoryUnsubscribed) applyToShard(client gamerules.IPlayerShardClient) {
+	client.ReqInventoryUnsubscribed(req.Block)
+}
+
+type psReqCreatePortal struct {
+	Target BlockXyz
+}
+
+func (req *psReqCreatePortal) applyToShard(client gamerules.IPlayerShardClient) {
+	client.ReqCreatePortal(req.Target)
+}
+
+type psReqLeaveBed struct {
+	BedLoc BlockXyz
+}
+
+func (req *psReqLeaveBed) applyToShard(client gamerules.IPlayerShardClient) {
+	client.ReqLeaveBed(req.BedLoc)
+}
+
+type psReqCheckBed struct {
+	BedLoc BlockXyz
+}
+
+func (req *psReqCheckBed) applyToShard(client gamerules.IPlayerShardClient) {
+	client.ReqCheckBed(req.BedLoc)
+}
+
+type psReqUseEntity struct {
+	ChunkLoc  ChunkXz
+	Position  AbsXyz
+	Target    EntityId
+	LeftClick bool
+}
+
+func (req *psReqUseEntity) applyToShard(client gamerules.IPlayerShardClient) {
+	client.ReqUseEntity(req.ChunkLoc, req.Position, req.Target, req.LeftClick)
+}
+
+type psReqSteerVehicle struct {
+	ChunkLoc ChunkXz
+	Vehicle  EntityId
+	Input    AbsVelocity
+}
+
+func (req *psReqSteerVehicle) applyToShard(client gamerules.IPlayerShardClient) {
+	client.ReqSteerVehicle(req.ChunkLoc, req.Vehicle, req.Input)
+}
+
+type psReqLaunchProjectile struct {
+	ObjType  ObjTypeId
+	Position AbsXyz
+	Velocity AbsVelocity
+}
+
+func (req *psReqLaunchProjectile) applyToShard(client gamerules.IPlayerShardClient) {
+	client.ReqLaunchProjectile(req.ObjType, req.Position, req.Velocity)
+}
+
+// Shard to shard requests.
+
+type ssReqSetActiveBlocks struct {
+	Blocks []BlockXyz
+}
+
+func (req *ssReqSetActiveBlocks) applyToShard(client gamerules.IShardShardClient) {
+	client.ReqSetActiveBlocks(req.Blocks)
+}
+
+// ssReqTransferEntity carries an entity in its NBT form, as the entity types
+// hold unexported state that gob can't encode. The EntityId is sent
+// separately, as it isn't part of the NBT data.
+type ssReqTransferEntity struct {
+	Loc      ChunkXz
+	EntityId EntityId
+	Entity   []byte
+}
+
+func newSsReqTransferEntity(loc ChunkXz, entity gamerules.INonPlayerEntity) (req *ssReqTransferEntity, err os.Error) {
+	buf := new(bytes.Buffer)
+	if err = nbt.Write(buf, entity.WriteNbt()); err != nil {
+		return
+	}
+
+	return &ssReqTransferEntity{loc, entity.GetEntityId(), buf.Bytes()}, nil
+}
+
+func (req *ssReqTransferEntity) applyToShard(client gamerules.IShardShardClient) {
+	tag, err := nbt.Read(bytes.NewBuffer(req.Entity))
+	if err != nil {
+		log.Printf("ssReqTransferEntity: error reading entity %d: %v", req.EntityId, err)
+		return
+	}
+
+	entity, err := gamerules.NewEntityFromNbt(tag)
+	if err != nil {
+		log.Printf("ssReqTransferEntity: error reading entity %d: %v", req.EntityId, err)
+		return
+	}
+	entity.SetEntityId(req.EntityId)
+
+	client.ReqTransferEntity(req.Loc, entity)
+}
+
+// Shard to player requests.
+
+type pReqTransmitPacket struct {
+	Packet   []byte
+	Priority proto.PacketPriority
+}
+
+func (req *pReqTransmitPacket) applyToPlayer(player gamerules.IPlayerClient) {
+	player.TransmitPacket(req.Packet, req.Priority)
+}
+
+type pReqShowEntity struct {
+	ChunkLoc    ChunkXz
+	EntityId    EntityId
+	SpawnPacket []byte
+}
+
+func (req *pReqShowEntity) applyToPlayer(player gamerules.IPlayerClient) {
+	player.ShowEntity(req.ChunkLoc, req.EntityId, req.SpawnPacket)
+}
+
+type pReqHideEntity struct {
+	ChunkLoc ChunkXz
+	EntityId EntityId
+}
+
+func (req *pReqHideEntity) applyToPlayer(player gamerules.IPlayerClient) {
+	player.HideEntity(req.ChunkLoc, req.EntityId)
+}
+
+type pReqNotifyChunkLoad byte
+
+func (req pReqNotifyChunkLoad) applyToPlayer(player gamerules.IPlayerClient) {
+	player.NotifyChunkLoad()
+}
+
+type pReqInventorySubscribed struct {
+	Block     BlockXyz
+	InvTypeId InvTypeId
+	Slots     []proto.WindowSlot
+}
+
+func (req *pReqInventorySubscribed) applyToPlayer(player gamerules.IPlayerClient) {
+	player.InventorySubscribed(req.Block, req.InvTypeId, req.Slots)
+}
+
+type pReqInventorySlotUpdate struct {
+	Block  BlockXyz
+	Slot   gamerules.Slot
+	SlotId SlotId
+}
+
+func (req *pReqInventorySlotUpdate) applyToPlayer(player gamerules.IPlayerClient) {
+	player.InventorySlotUpdate(req.Block, req.Slot, req.SlotId)
+}
+
+type pReqInventoryProgressUpdate struct {
+	Block    BlockXyz
+	PrgBarId PrgBarId
+	Value    PrgBarValue
+}
+
+func (req *pReqInventoryProgressUpdate) applyToPlayer(player gamerules.IPlayerClient) {
+	player.InventoryProgressUpdate(req.Block, req.PrgBarId, req.Value)
+}
+
+type pReqInventoryCursorUpdate struct {
+	Block  BlockXyz
+	Cursor gamerules.Slot
+}
+
+func (req *pReqInventoryCursorUpdate) applyToPlayer(player gamerules.IPlayerClient) {
+	player.InventoryCursorUpdate(req.Block, req.Cursor)
+}
+
+type pReqInventoryTxState struct {
+	Block    BlockXyz
+	TxId     TxId
+	Accepted bool
+}
+
+func (req *pReqInventoryTxState) applyToPlayer(player gamerules.IPlayerClient) {
+	player.InventoryTxState(req.Block, req.TxId, req.Accepted)
+}
+
+type pReqInventoryUnsubscribed struct {
+	Block BlockXyz
+}
+
+func (req *pReqInventoryUnsubscribed) applyToPlayer(player gamerules.IPlayerClient) {
+	player.InventoryUnsubscribed(req.Block)
+}
+
+type pReqPlaceHeldItem struct {
+	Target  BlockXyz
+	WasHeld gamerules.Slot
+}
+
+func (req *pReqPlaceHeldItem) applyToPlayer(player gamerules.IPlayerClient) {
+	player.PlaceHeldItem(req.Target, req.WasHeld)
+}
+
+type pReqInteractDone byte
+
+func (req pReqInteractDone) applyToPlayer(player gamerules.IPlayerClient) {
+	player.InteractDone()
+}
+
+type pReqOfferItem struct {
+	FromChunk ChunkXz
+	EntityId  EntityId
+	Item      gamerules.Slot
+}
+
+func (req *pReqOfferItem) applyToPlayer(player gamerules.IPlayerClient) {
+	player.OfferItem(req.FromChunk, req.EntityId, req.Item)
+}
+
+type pReqGiveItemAtPosition struct {
+	AtPosition AbsXyz
+	Item       gamerules.Slot
+}
+
+func (req *pReqGiveItemAtPosition) applyToPlayer(player gamerules.IPlayerClient) {
+	player.GiveItemAtPosition(req.AtPosition, req.Item)
+}
+
+type pReqGiveItem struct {
+	Item gamerules.Slot
+}
+
+func (req *pReqGiveItem) applyToPlayer(player gamerules.IPlayerClient) {
+	player.GiveItem(req.Item)
+}
+
+type pReqSetPositionLook struct {
+	Position AbsXyz
+	Look     LookDegrees
+}
+
+func (req *pReqSetPositionLook) applyToPlayer(player gamerules.IPlayerClient) {
+	player.SetPositionLook(req.Position, req.Look)
+}
+
+type pReqSetPosition struct {
+	Position AbsXyz
+}
+
+func (req *pReqSetPosition) applyToPlayer(player gamerules.IPlayerClient) {
+	player.SetPosition(req.Position)
+}
+
+type pReqEnterPortal byte
+
+func (req pReqEnterPortal) applyToPlayer(player gamerules.IPlayerClient) {
+	player.EnterPortal()
+}
+
+type pReqSleep struct {
+	BedLoc BlockXyz
+}
+
+func (req *pReqSleep) applyToPlayer(player gamerules.IPlayerClient) {
+	player.Sleep(req.BedLoc)
+}
+
+type pReqBedMissing struct {
+	BedLoc BlockXyz
+}
+
+func (req *pReqBedMissing) applyToPlayer(player gamerules.IPlayerClient) {
+	player.BedMissing(req.BedLoc)
+}
+
+type pReqMount struct {
+	VehicleId EntityId
+}
+
+func (req *pReqMount) applyToPlayer(player gamerules.IPlayerClient) {
+	player.Mount(req.VehicleId)
+}
+
+type pReqDismount struct {
+	VehicleId EntityId
+}
+
+func (req *pReqDismount) applyToPlayer(player gamerules.IPlayerClient) {
+	player.Dismount(req.VehicleId)
+}
+
+type pReqVehicleMoved struct {
+	VehicleId EntityId
+	Position  AbsXyz
+}
+
+func (req *pReqVehicleMoved) applyToPlayer(player gamerules.IPlayerClient) {
+	player.VehicleMoved(req.VehicleId, req.Position)
+}
+
+type pReqDamage struct {
+	Amount   Health
+	Attacker EntityId
+}
+
+func (req *pReqDamage) applyToPlayer(player gamerules.IPlayerClient) {
+	player.Damage(req.Amount, req.Attacker)
+}
+
+type pReqGiveExperience struct {
+	Amount int16
+}
+
+func (req *pReqGiveExperience) applyToPlayer(player gamerules.IPlayerClient) {
+	player.GiveExperience(req.Amount)
+}
+
+type pReqEchoMessage struct {
+	Msg string
+}
+
+func (req *pReqEchoMessage) applyToPlayer(player gamerules.IPlayerClient) {
+	player.EchoMessage(req.Msg)
+}
+
+// pReqShardMoved tells the player that the shard it is connected to has been
+// handed off to another chunk server.
+type pReqShardMoved struct {
+	ShardLoc ShardXz
+}
+
+func (req *pReqShardMoved) applyToPlayer(player gamerules.IPlayerClient) {
+	notifyShardMoved(player, req.ShardLoc)
+}
@@ -0,0 +1,444 @@
+package shardserver
+
+import (
+	"gob"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"chunkymonkey/gamerules"
+	"chunkymonkey/proto"
+	. "chunkymonkey/types"
+	"chunkymonkey/util"
+)
+
+// handoffTimeout is how long to wait for a ShardServer to start a shard
+// handed off to it.
+const handoffTimeout = 30 * NanosecondsInSecond
+
+// RemoteShardManager implements IShardManager for shards that are hosted by a
+// ShardServer in another process (typically a chunk server on another host).
+// All requests to the shards for a dimension are carried over a single TCP
+// connection.
+type RemoteShardManager struct {
+	conn net.Conn
+	// closed is closed when the connection is lost.
+	closed chan bool
+
+	writer remoteWriter
+
+	// lock guards the following fields, and the requests waiting for replies
+	// in each remotePlayer.
+	lock         sync.Mutex
+	lost         bool // Set once the connection is lost.
+	nextClientId remoteClientId
+	players      map[remoteClientId]*remotePlayer
+	// handoffs receive the replies to shards being handed off to the
+	// ShardServer. Each handoff is sent with its own ID, which the reply
+	// carries back.
+	handoffs map[remoteClientId]chan *msgShardReceived
+}
+
+// remotePlayer is a player connected to a shard through a
+// RemoteShardManager.
+type remotePlayer struct {
+	player   gamerules.IPlayerClient
+	shardLoc ShardXz
+
+	// Requests that the player is waiting for replies to, which are failed if
+	// the connection is lost: the number of block interactions not yet done,
+	// and the inventory clicks not yet accepted or rejected.
+	interacts int
+	clicks    []remoteClick
+}
+
+type remoteClick struct {
+	block BlockXyz
+	click gamerules.Click
+}
+
+// replied removes the request that a reply from the shard answers.
+func (p *remotePlayer) replied(req iPlayerReq) {
+	switch req := req.(type) {
+	case pReqInteractDone:
+		if p.interacts > 0 {
+			p.interacts--
+		}
+	case *pReqInventoryTxState:
+		for i, pending := range p.clicks {
+			block := &pending.block
+			if pending.click.TxId == req.TxId && block.X == req.Block.X && block.Y == req.Block.Y && block.Z == req.Block.Z {
+				p.clicks = append(p.clicks[:i], p.clicks[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// failPending fails the requests that the player is waiting for replies to,
+// as the shard would had it been unable to perform them.
+func (p *remotePlayer) failPending() {
+	for ; p.interacts > 0; p.interacts-- {
+		p.player.InteractDone()
+	}
+	for _, pending := range p.clicks {
+		p.player.InventoryTxState(pending.block, pending.click.TxId, false)
+		p.player.InventoryCursorUpdate(pending.block, pending.click.Cursor)
+	}
+	p.clicks = nil
+}
+
+// NewRemoteShardManager connects to the ShardServer at the given address, to
+// drive the shards for the given dimension.
+func NewRemoteShardManager(addr string, dimension DimensionId) (mgr *RemoteShardManager, err os.Error) {
+	conn, err := util.DialTimeout(addr, RemoteDialTimeout)
+	if err != nil {
+		return
+	}
+
+	mgr = &RemoteShardManager{
+		conn:         conn,
+		closed:       make(chan bool),
+		nextClientId: 1,
+		players:      make(map[remoteClientId]*remotePlayer),
+		handoffs:     make(map[remoteClientId]chan *msgShardReceived),
+	}
+
+	mgr.writer.Init(conn, "RemoteShardManager")
+	mgr.send(0, &msgHello{dimension, ShardSize})
+
+	go mgr.receiveLoop()
+
+	return
+}
+
+// Close disconnects from the ShardServer.
+func (mgr *RemoteShardManager) Close() {
+	mgr.writer.Close()
+	mgr.conn.Close()
+}
+
+// isClosed returns true if the connection to the ShardServer has been lost,
+// or closed by Close.
+func (mgr *RemoteShardManager) isClosed() bool {
+	select {
+	case <-mgr.closed:
+		return true
+	default:
+	}
+	return false
+}
+
+// send queues a message to the ShardServer. Errors are logged rather than
+// returned, as the requests it carries have no way of reporting them. The
+// receive loop notices when the connection fails.
+func (mgr *RemoteShardManager) send(clientId remoteClientId, body interface{}) {
+	mgr.writer.Send(clientId, body)
+}
+
+func (mgr *RemoteShardManager) newClientId() remoteClientId {
+	id := mgr.nextClientId
+	mgr.nextClientId++
+	return id
+}
+
+// receiveLoop passes requests from shards on to the players that they are
+// for. Players queue the requests rather than carrying them out, so a player
+// that has fallen behind doesn't hold up the others on the connection. When the connection is lost, the requests that players are waiting for
+// replies to are failed, and players are told that their shards have moved, so
+// that those that can look up their shards again do so.
+func (mgr *RemoteShardManager) receiveLoop() {
+	defer mgr.connectionLost()
+
+	decoder := gob.NewDecoder(mgr.conn)
+
+	for {
+		var msg remoteMsg
+		if err := decoder.Decode(&msg); err != nil {
+			log.Printf("RemoteShardManager: connection to %v lost: %v", mgr.conn.RemoteAddr(), err)
+			return
+		}
+
+		if reply, ok := msg.Body.(*msgShardReceived); ok {
+			mgr.lock.Lock()
+			result, ok := mgr.handoffs[msg.ClientId]
+			mgr.handoffs[msg.ClientId] = nil, false
+			mgr.lock.Unlock()
+
+			if ok {
+				result <- reply
+			} else {
+				// Such as a reply that arrives after the handoff timed out.
+				log.Printf("RemoteShardManager: unexpected reply for shard %v", reply.ShardLoc)
+			}
+			continue
+		}
+
+		req, ok := msg.Body.(iPlayerReq)
+		if !ok {
+			log.Printf("RemoteShardManager: unexpected message %T", msg.Body)
+			continue
+		}
+
+		mgr.lock.Lock()
+		player, ok := mgr.players[msg.ClientId]
+		if ok {
+			player.replied(req)
+		}
+		mgr.lock.Unlock()
+
+		if ok {
+			req.applyToPlayer(player.player)
+		}
+	}
+}
+
+func (mgr *RemoteShardManager) connectionLost() {
+	mgr.writer.Close()
+	mgr.conn.Close()
+	close(mgr.closed)
+
+	mgr.lock.Lock()
+	mgr.lost = true
+	players := make([]*remotePlayer, 0, len(mgr.players))
+	for _, player := range mgr.players {
+		players = append(players, player)
+	}
+	mgr.lock.Unlock()
+
+	// Nothing else changes the players' pending requests once the connection
+	// is marked as lost.
+	for _, player := range players {
+		player.failPending()
+		notifyShardMoved(player.player, player.shardLoc)
+	}
+}
+
+// addPending records a request that the player waits for a reply to. It
+// returns false if the connection has been lost, in which case the request
+// is to be failed rather than sent.
+func (mgr *RemoteShardManager) addPending(clientId remoteClientId, add func(player *remotePlayer)) bool {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	if mgr.lost {
+		return false
+	}
+	if player, ok := mgr.players[clientId]; ok {
+		add(player)
+	}
+	return true
+}
+
+func (mgr *RemoteShardManager) PlayerShardConnect(entityId EntityId, player gamerules.IPlayerClient, shardLoc ShardXz) gamerules.IPlayerShardClient {
+	mgr.lock.Lock()
+	clientId := mgr.newClientId()
+	mgr.players[clientId] = &remotePlayer{player, shardLoc}
+	mgr.lock.Unlock()
+
+	mgr.send(clientId, &msgPlayerConnect{entityId, shardLoc})
+
+	return &remotePlayerShardClient{
+		mgr:      mgr,
+		clientId: clientId,
+		player:   player,
+	}
+}
+
+func (mgr *RemoteShardManager) ShardShardConnect(shardLoc ShardXz) gamerules.IShardShardClient {
+	mgr.lock.Lock()
+	clientId := mgr.newClientId()
+	mgr.lock.Unlock()
+
+	mgr.send(clientId, &msgShardConnect{shardLoc})
+
+	return &remoteShardShardClient{
+		mgr:      mgr,
+		clientId: clientId,
+	}
+}
+
+func (mgr *RemoteShardManager) SetWorldState(worldTime Ticks, weather Weather) {
+	mgr.send(0, &msgSetWorldState{worldTime, weather})
+}
+
+func (mgr *RemoteShardManager) StrikeLightning(x, z BlockCoord) {
+	mgr.send(0, &msgStrikeLightning{x, z})
+}
+
+// ReceiveShard hands off a shard to the ShardServer, and waits for it to start
+// the shard.
+func (mgr *RemoteShardManager) ReceiveShard(shardLoc ShardXz, state []byte) os.Error {
+	result := make(chan *msgShardReceived, 1)
+
+	mgr.lock.Lock()
+	handoffId := mgr.newClientId()
+	mgr.handoffs[handoffId] = result
+	mgr.lock.Unlock()
+
+	mgr.send(handoffId, &msgReceiveShard{shardLoc, state})
+
+	select {
+	case reply := <-result:
+		if reply.Err != "" {
+			return os.NewError(reply.Err)
+		}
+	case <-time.After(handoffTimeout):
+		mgr.lock.Lock()
+		mgr.handoffs[handoffId] = nil, false
+		mgr.lock.Unlock()
+		return os.NewError("timed out handing off shard")
+	}
+
+	return nil
+}
+
+func (mgr *RemoteShardManager) DiscardShard(shardLoc ShardXz) {
+	mgr.send(0, &msgDiscardShard{shardLoc})
+}
+
+// remotePlayerShardClient implements IPlayerShardClient for
+// RemoteShardManager.
+type remotePlayerShardClient struct {
+	mgr      *RemoteShardManager
+	clientId remoteClientId
+	player   gamerules.IPlayerClient
+}
+
+func (conn *remotePlayerShardClient) Disconnect() {
+	conn.mgr.send(conn.clientId, msgDisconnect(0))
+
+	conn.mgr.lock.Lock()
+	defer conn.mgr.lock.Unlock()
+	conn.mgr.players[conn.clientId] = nil, false
+}
+
+func (conn *remotePlayerShardClient) ReqSubscribeChunk(chunkLoc ChunkXz, notify bool) {
+	conn.mgr.send(conn.clientId, &psReqSubscribeChunk{chunkLoc, notify})
+}
+
+func (conn *remotePlayerShardClient) ReqUnsubscribeChunk(chunkLoc ChunkXz) {
+	conn.mgr.send(conn.clientId, &psReqUnsubscribeChunk{chunkLoc})
+}
+
+func (conn *remotePlayerShardClient) ReqMulticastPlayers(chunkLoc ChunkXz, exclude EntityId, packet []byte, priority proto.PacketPriority) {
+	conn.mgr.send(conn.clientId, &psReqMulticastPlayers{chunkLoc, exclude, packet, priority})
+}
+
+func (conn *remotePlayerShardClient) ReqAddPlayerData(chunkLoc ChunkXz, name string, position AbsXyz, look LookBytes, held ItemTypeId) {
+	conn.mgr.send(conn.clientId, &psReqAddPlayerData{chunkLoc, name, position, look, held})
+}
+
+func (conn *remotePlayerShardClient) ReqRemovePlayerData(chunkLoc ChunkXz, isDisconnect bool) {
+	conn.mgr.send(conn.clientId, &psReqRemovePlayerData{chunkLoc, isDisconnect})
+}
+
+func (conn *remotePlayerShardClient) ReqSetPlayerPosition(chunkLoc ChunkXz, position AbsXyz) {
+	conn.mgr.send(conn.clientId, &psReqSetPlayerPosition{chunkLoc, position})
+}
+
+func (conn *remotePlayerShardClient) ReqSetPlayerLook(chunkLoc ChunkXz, look LookBytes) {
+	conn.mgr.send(conn.clientId, &psReqSetPlayerLook{chunkLoc, look})
+}
+
+func (conn *remotePlayerShardClient) ReqViewEntities(chunkLoc ChunkXz, view bool) {
+	conn.mgr.send(conn.clientId, &psReqViewEntities{chunkLoc, view})
+}
+
+func (conn *remotePlayerShardClient) ReqTeleportEntities(chunkLoc ChunkXz) {
+	conn.mgr.send(conn.clientId, &psReqTeleportEntities{chunkLoc})
+}
+
+func (conn *remotePlayerShardClient) ReqHitBlock(held gamerules.Slot, target BlockXyz, digStatus DigStatus, face Face) {
+	conn.mgr.send(conn.clientId, &psReqHitBlock{held, target, digStatus, face})
+}
+
+func (conn *remotePlayerShardClient) ReqInteractBlock(held gamerules.Slot, target BlockXyz, face Face) {
+	if !conn.mgr.addPending(conn.clientId, func(player *remotePlayer) { player.interacts++ }) {
+		// The player is told from another goroutine, as it may be the one
+		// making the request.
+		go conn.player.InteractDone()
+		return
+	}
+	conn.mgr.send(conn.clientId, &psReqInteractBlock{held, target, face})
+}
+
+func (conn *remotePlayerShardClient) ReqPlaceItem(target BlockXyz, slot gamerules.Slot) {
+	conn.mgr.send(conn.clientId, &psReqPlaceItem{target, slot})
+}
+
+func (conn *remotePlayerShardClient) ReqTakeItem(chunkLoc ChunkXz, entityId EntityId) {
+	conn.mgr.send(conn.clientId, &psReqTakeItem{chunkLoc, entityId})
+}
+
+func (conn *remotePlayerShardClient) ReqDropItem(content gamerules.Slot, position AbsXyz, velocity AbsVelocity, pickupImmunity Ticks) {
+	conn.mgr.send(conn.clientId, &psReqDropItem{content, position, velocity, pickupImmunity})
+}
+
+func (conn *remotePlayerShardClient) ReqInventoryClick(block BlockXyz, click gamerules.Click) {
+	added := conn.mgr.addPending(conn.clientId, func(player *remotePlayer) {
+		player.clicks = append(player.clicks, remoteClick{block, click})
+	})
+	if !added {
+		go func() {
+			conn.player.InventoryTxState(block, click.TxId, false)
+			conn.player.InventoryCursorUpdate(block, click.Cursor)
+		}()
+		return
+	}
+	conn.mgr.send(conn.clientId, &psReqInventoryClick{block, click})
+}
+
+func (conn *remotePlayerShardClient) ReqInventoryUnsubscribed(block BlockXyz) {
+	conn.mgr.send(conn.clientId, &psReqInventoryUnsubscribed{block})
+}
+
+func (conn *remotePlayerShardClient) ReqCreatePortal(target BlockXyz) {
+	conn.mgr.send(conn.clientId, &psReqCreatePortal{target})
+}
+
+func (conn *remotePlayerShardClient) ReqLeaveBed(bedLoc BlockXyz) {
+	conn.mgr.send(conn.clientId, &psReqLeaveBed{bedLoc})
+}
+
+func (conn *remotePlayerShardClient) ReqCheckBed(bedLoc BlockXyz) {
+	conn.mgr.send(conn.clientId, &psReqCheckBed{bedLoc})
+}
+
+func (conn *remotePlayerShardClient) ReqUseEntity(chunkLoc ChunkXz, position AbsXyz, target EntityId, leftClick bool) {
+	conn.mgr.send(conn.clientId, &psReqUseEntity{chunkLoc, position, target, leftClick})
+}
+
+func (conn *remotePlayerShardClient) ReqSteerVehicle(chunkLoc ChunkXz, vehicle EntityId, input AbsVelocity) {
+	conn.mgr.send(conn.clientId, &psReqSteerVehicle{chunkLoc, vehicle, input})
+}
+
+func (conn *remotePlayerShardClient) ReqLaunchProjectile(objType ObjTypeId, position AbsXyz, velocity AbsVelocity) {
+	conn.mgr.send(conn.clientId, &psReqLaunchProjectile{objType, position, velocity})
+}
+
+// remoteShardShardClient implements IShardShardClient for RemoteShardManager.
+type remoteShardShardClient struct {
+	mgr      *RemoteShardManager
+	clientId remoteClientId
+}
+
+func (client *remoteShardShardClient) Disconnect() {
+	client.mgr.send(client.clientId, msgDisconnect(0))
+}
+
+func (client *remoteShardShardClient) ReqSetActiveBlocks(blocks []BlockXyz) {
+	client.mgr.send(client.clientId, &ssReqSetActiveBlocks{blocks})
+}
+
+func (client *remoteShardShardClient) ReqTransferEntity(loc ChunkXz, entity gamerules.INonPlayerEntity) {
+	req, err := newSsReqTransferEntity(loc, entity)
+	if err != nil {
+		log.Printf("remoteShardShardClient: dropping entity %d transferred to remote chunk %v: %v", entity.GetEntityId(), loc, err)
+		return
+	}
+
+	client.mgr.send(client.clientId, req)
+}
@@ -0,0 +1,349 @@
+package shardserver
+
+import (
+	"gob"
+	"net"
+	"testing"
+	"time"
+
+	"chunkymonkey/entity"
+	"chunkymonkey/gamerules"
+	"chunkymonkey/proto"
+	. "chunkymonkey/types"
+)
+
+const remoteTestTimeout = 5 * NanosecondsInSecond
+
+// Test doubles embed the interface that they implement, so that only the
+// methods used by the test need defining.
+
+type testShardManager struct {
+	IShardManager
+	players       chan gamerules.IPlayerClient
+	subscriptions chan ChunkXz
+	positions     chan AbsXyz
+	worldTimes    chan Ticks
+	entities      chan gamerules.INonPlayerEntity
+}
+
+func (mgr *testShardManager) PlayerShardConnect(entityId EntityId, player gamerules.IPlayerClient, shardLoc ShardXz) gamerules.IPlayerShardClient {
+	mgr.players <- player
+	return &testPlayerShardClient{mgr: mgr}
+}
+
+func (mgr *testShardManager) ShardShardConnect(shardLoc ShardXz) gamerules.IShardShardClient {
+	return &testShardShardClient{mgr: mgr}
+}
+
+func (mgr *testShardManager) SetWorldState(worldTime Ticks, weather Weather) {
+	mgr.worldTimes <- worldTime
+}
+
+type testPlayerShardClient struct {
+	gamerules.IPlayerShardClient
+	mgr *testShardManager
+}
+
+func (client *testPlayerShardClient) Disconnect() {
+}
+
+func (client *testPlayerShardClient) ReqSubscribeChunk(chunkLoc ChunkXz, notify bool) {
+	client.mgr.subscriptions <- chunkLoc
+}
+
+func (client *testPlayerShardClient) ReqSetPlayerPosition(chunkLoc ChunkXz, position AbsXyz) {
+	client.mgr.positions <- position
+}
+
+type testShardShardClient struct {
+	gamerules.IShardShardClient
+	mgr *testShardManager
+}
+
+func (client *testShardShardClient) Disconnect() {
+}
+
+func (client *testShardShardClient) ReqTransferEntity(loc ChunkXz, entity gamerules.INonPlayerEntity) {
+	client.mgr.entities <- entity
+}
+
+type testPlayerClient struct {
+	gamerules.IPlayerClient
+	packets  chan []byte
+	priority proto.PacketPriority // Of the last packet sent on packets.
+}
+
+func (player *testPlayerClient) TransmitPacket(packet []byte, priority proto.PacketPriority) {
+	player.priority = priority
+	player.packets <- packet
+}
+
+func startTestShardServer(t *testing.T) (mgr *testShardManager, addr string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+
+	mgr = &testShardManager{
+		players:       make(chan gamerules.IPlayerClient, 1),
+		subscriptions: make(chan ChunkXz, 1),
+		positions:     make(chan AbsXyz, 1),
+		worldTimes:    make(chan Ticks, 1),
+		entities:      make(chan gamerules.INonPlayerEntity, 1),
+	}
+	server := NewShardServer(map[DimensionId]IShardManager{DimensionNormal: mgr})
+	go server.Serve(listener)
+
+	return mgr, listener.Addr().String()
+}
+
+func TestRemoteShardManager(t *testing.T) {
+	serverMgr, addr := startTestShardServer(t)
+
+	remoteMgr, err := NewRemoteShardManager(addr, DimensionNormal)
+	if err != nil {
+		t.Fatalf("NewRemoteShardManager: %v", err)
+	}
+	defer remoteMgr.Close()
+
+	timeout := time.After(remoteTestTimeout)
+
+	// Requests for the whole dimension.
+	remoteMgr.SetWorldState(1234, WeatherRain)
+	select {
+	case worldTime := <-serverMgr.worldTimes:
+		if worldTime != 1234 {
+			t.Errorf("Expected world time 1234, got %d", worldTime)
+		}
+	case <-timeout:
+		t.Fatalf("Timed out waiting for world state")
+	}
+
+	// Requests from a player to a shard.
+	player := &testPlayerClient{packets: make(chan []byte, 1)}
+	shardClient := remoteMgr.PlayerShardConnect(42, player, ShardXz{1, 2})
+	shardClient.ReqSetPlayerPosition(ChunkXz{3, 4}, AbsXyz{1.5, 64, -2.5})
+
+	var remotePlayer gamerules.IPlayerClient
+	select {
+	case remotePlayer = <-serverMgr.players:
+		if remotePlayer.GetEntityId() != 42 {
+			t.Errorf("Expected player with EntityId 42, got %d", remotePlayer.GetEntityId())
+		}
+	case <-timeout:
+		t.Fatalf("Timed out waiting for player to connect")
+	}
+
+	select {
+	case position := <-serverMgr.positions:
+		if position.X != 1.5 || position.Y != 64 || position.Z != -2.5 {
+			t.Errorf("Expected position {1.5 64 -2.5}, got %v", position)
+		}
+	case <-timeout:
+		t.Fatalf("Timed out waiting for player position")
+	}
+
+	// Requests from a shard to the player.
+	remotePlayer.TransmitPacket([]byte("packet"), proto.PacketPriorityHigh)
+	select {
+	case packet := <-player.packets:
+		if string(packet) != "packet" {
+			t.Errorf("Expected packet %q, got %q", "packet", packet)
+		}
+		if player.priority != proto.PacketPriorityHigh {
+			t.Errorf("Expected packet priority %d, got %d", proto.PacketPriorityHigh, player.priority)
+		}
+	case <-timeout:
+		t.Fatalf("Timed out waiting for packet")
+	}
+}
+
+func TestRemoteTransferEntity(t *testing.T) {
+	serverMgr, addr := startTestShardServer(t)
+
+	remoteMgr, err := NewRemoteShardManager(addr, DimensionNormal)
+	if err != nil {
+		t.Fatalf("NewRemoteShardManager: %v", err)
+	}
+	defer remoteMgr.Close()
+
+	item := gamerules.NewItem(ItemTypeIdArrow, 3, 0, &AbsXyz{1.5, 64, -2.5}, &AbsVelocity{}, 0)
+	item.SetEntityId(99)
+
+	shardClient := remoteMgr.ShardShardConnect(ShardXz{0, 0})
+	shardClient.ReqTransferEntity(ChunkXz{0, -1}, item)
+
+	select {
+	case entity := <-serverMgr.entities:
+		if entity.GetEntityId() != 99 {
+			t.Errorf("Expected entity with EntityId 99, got %d", entity.GetEntityId())
+		}
+		if result, ok := entity.(*gamerules.Item); !ok {
+			t.Errorf("Expected *gamerules.Item, got %T", entity)
+		} else if result.ItemTypeId != ItemTypeIdArrow || result.Count != 3 {
+			t.Errorf("Expected 3 arrows, got %v", result.Slot)
+		}
+	case <-time.After(remoteTestTimeout):
+		t.Fatalf("Timed out waiting for entity transfer")
+	}
+}
+
+func TestTransferredEntityIdsAreReserved(t *testing.T) {
+	entityMgr := new(entity.EntityManager)
+	entityMgr.InitRange(1, 10)
+	shard := NewChunkShard(nil, nil, entityMgr, ShardXz{0, 0}, 0, WeatherClear)
+	chunkIndex, _, _, _ := shard.chunkIndexAndRelLoc(ChunkXz{0, 0})
+	chunk := newChunkFromNbt(testChunkNbt(ChunkXz{0, 0}), shard)
+	shard.chunks[chunkIndex] = chunk
+
+	newArrow := func(entityId EntityId) *gamerules.Item {
+		item := gamerules.NewItem(ItemTypeIdArrow, 1, 0, &AbsXyz{1.5, 64, 1.5}, &AbsVelocity{}, 0)
+		item.SetEntityId(entityId)
+		return item
+	}
+
+	// An entity from another process keeps its EntityId, which is no longer
+	// given out here.
+	chunk.transferEntity(newArrow(1))
+	if chunk.entities[1] == nil {
+		t.Fatalf("Expected the entity to keep EntityId 1")
+	}
+	if entityId := entityMgr.NewEntity(); entityId == 1 {
+		t.Errorf("Expected EntityId 1 to be reserved")
+	}
+
+	// An entity whose EntityId is in use here is given a new one.
+	clashing := newArrow(1)
+	chunk.transferEntity(clashing)
+	if clashing.GetEntityId() == 1 || chunk.entities[clashing.GetEntityId()] != clashing {
+		t.Errorf("Expected the clashing entity to be given a new EntityId, got %d", clashing.GetEntityId())
+	}
+	if len(chunk.entities) != 2 {
+		t.Errorf("Expected 2 entities in the chunk, got %d", len(chunk.entities))
+	}
+}
+
+type testPendingPlayerClient struct {
+	gamerules.IPlayerClient
+	interactsDone chan bool
+	txStates      chan TxId
+	cursors       chan gamerules.Slot
+}
+
+func (player *testPendingPlayerClient) InteractDone() {
+	player.interactsDone <- true
+}
+
+func (player *testPendingPlayerClient) InventoryTxState(block BlockXyz, txId TxId, accepted bool) {
+	if !accepted {
+		player.txStates <- txId
+	}
+}
+
+func (player *testPendingPlayerClient) InventoryCursorUpdate(block BlockXyz, cursor gamerules.Slot) {
+	player.cursors <- cursor
+}
+
+func TestRemoteShardManagerFailsPendingRequests(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer listener.Close()
+
+	// The server reads the hello, the player connecting and both requests,
+	// then drops the connection without replying.
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		decoder := gob.NewDecoder(conn)
+		for i := 0; i < 4; i++ {
+			var msg remoteMsg
+			if decoder.Decode(&msg) != nil {
+				return
+			}
+		}
+	}()
+
+	remoteMgr, err := NewRemoteShardManager(listener.Addr().String(), DimensionNormal)
+	if err != nil {
+		t.Fatalf("NewRemoteShardManager: %v", err)
+	}
+	defer remoteMgr.Close()
+
+	player := &testPendingPlayerClient{
+		interactsDone: make(chan bool, 1),
+		txStates:      make(chan TxId, 1),
+		cursors:       make(chan gamerules.Slot, 1),
+	}
+	shardClient := remoteMgr.PlayerShardConnect(42, player, ShardXz{0, 0})
+	shardClient.ReqInteractBlock(gamerules.Slot{}, BlockXyz{1, 64, 1}, FaceTop)
+	shardClient.ReqInventoryClick(BlockXyz{1, 64, 2}, gamerules.Click{TxId: 7, Cursor: gamerules.Slot{ItemTypeId: ItemTypeIdArrow, Count: 3}})
+
+	timeout := time.After(remoteTestTimeout)
+	select {
+	case <-player.interactsDone:
+	case <-timeout:
+		t.Fatalf("Timed out waiting for the interaction to be done")
+	}
+	select {
+	case txId := <-player.txStates:
+		if txId != 7 {
+			t.Errorf("Expected transaction 7 to be rejected, got %d", txId)
+		}
+	case <-timeout:
+		t.Fatalf("Timed out waiting for the click to be rejected")
+	}
+	select {
+	case cursor := <-player.cursors:
+		if cursor.ItemTypeId != ItemTypeIdArrow || cursor.Count != 3 {
+			t.Errorf("Expected the cursor to be restored to 3 arrows, got %v", cursor)
+		}
+	case <-timeout:
+		t.Fatalf("Timed out waiting for the cursor to be restored")
+	}
+
+	// Requests made after the connection is lost fail straight away.
+	shardClient.ReqInteractBlock(gamerules.Slot{}, BlockXyz{1, 64, 1}, FaceTop)
+	select {
+	case <-player.interactsDone:
+	case <-timeout:
+		t.Fatalf("Timed out waiting for the later interaction to be done")
+	}
+}
+
+func TestRemoteWriterDoesNotWaitOnStalledPeer(t *testing.T) {
+	defer func(maxQueued int) { RemoteMaxQueued = maxQueued }(RemoteMaxQueued)
+	RemoteMaxQueued = 4
+
+	// Nothing reads from peer, so the first message sent blocks the writer.
+	conn, peer := net.Pipe()
+	defer peer.Close()
+
+	var writer remoteWriter
+	writer.Init(conn, "test")
+
+	sent := make(chan bool)
+	go func() {
+		for i := 0; i < 4*RemoteMaxQueued; i++ {
+			writer.Send(1, msgDisconnect(0))
+		}
+		close(sent)
+	}()
+
+	select {
+	case <-sent:
+	case <-time.After(remoteTestTimeout):
+		t.Fatalf("Timed out sending to a stalled peer")
+	}
+
+	writer.lock.Lock()
+	closed, queued := writer.closed, len(writer.queue)
+	writer.lock.Unlock()
+	if !closed || queued != 0 {
+		t.Errorf("Expected the writer to be closed and emptied once too far behind, got closed=%t with %d queued", closed, queued)
+	}
+}
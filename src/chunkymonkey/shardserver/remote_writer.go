@@ -0,0 +1,125 @@
+package shardserver
+
+import (
+	"gob"
+	"log"
+	"net"
+	"sync"
+
+	. "chunkymonkey/types"
+)
+
+// Limits on the connections between frontends and ShardServers.
+var (
+	// RemoteDialTimeout is how long to wait to connect to a ShardServer.
+	RemoteDialTimeout int64 = 10 * NanosecondsInSecond
+
+	// RemoteWriteTimeout is how long a write to the other end of a connection
+	// may block before the connection is closed as lost.
+	RemoteWriteTimeout int64 = 30 * NanosecondsInSecond
+
+	// RemoteMaxQueued is how many messages may wait to be sent on a
+	// connection before it is closed for having fallen too far behind.
+	RemoteMaxQueued = 1 << 16
+)
+
+// remoteWriter sends the messages for a connection from its own goroutine.
+// Queueing a message never blocks, so that shards and players sending
+// messages aren't held up by the network. If a write fails, the connection is
+// closed, which ends the reading side of it, and later messages are dropped.
+type remoteWriter struct {
+	conn    net.Conn
+	encoder *gob.Encoder
+
+	lock   sync.Mutex
+	ready  *sync.Cond // Signalled when messages are queued, or the writer closed.
+	queue  []*remoteMsg
+	closed bool
+}
+
+// Init starts the writer's goroutine. name identifies the sender in logs.
+func (w *remoteWriter) Init(conn net.Conn, name string) {
+	w.conn = conn
+	w.encoder = gob.NewEncoder(conn)
+	w.ready = sync.NewCond(&w.lock)
+
+	go w.writeLoop(name)
+}
+
+// Send queues a message to be sent.
+func (w *remoteWriter) Send(clientId remoteClientId, body interface{}) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.closed {
+		return
+	}
+
+	if len(w.queue) >= RemoteMaxQueued {
+		log.Printf("remoteWriter: %v has fallen %d messages behind", w.conn.RemoteAddr(), len(w.queue))
+		w.conn.Close()
+		w.close()
+		return
+	}
+
+	w.queue = append(w.queue, &remoteMsg{clientId, body})
+	w.ready.Signal()
+}
+
+// Close stops the writer once the messages already queued are sent.
+func (w *remoteWriter) Close() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.closed = true
+	w.ready.Broadcast()
+}
+
+func (w *remoteWriter) close() {
+	w.queue = nil
+	w.closed = true
+	w.ready.Broadcast()
+}
+
+// take waits for messages to be queued and removes them from the queue. It
+// returns nil once the writer is closed and everything queued has been taken.
+func (w *remoteWriter) take() (queue []*remoteMsg) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	for len(w.queue) == 0 {
+		if w.closed {
+			return nil
+		}
+		w.ready.Wait()
+	}
+
+	queue = w.queue
+	w.queue = nil
+	return
+}
+
+func (w *remoteWriter) writeLoop(name string) {
+	if RemoteWriteTimeout > 0 {
+		w.conn.SetWriteTimeout(RemoteWriteTimeout)
+	}
+
+	for {
+		queue := w.take()
+		if queue == nil {
+			return
+		}
+
+		for _, msg := range queue {
+			if err := w.encoder.Encode(msg); err != nil {
+				log.Printf("%s: error sending %T to %v: %v", name, msg.Body, w.conn.RemoteAddr(), err)
+
+				w.conn.Close()
+				w.lock.Lock()
+				w.close()
+				w.lock.Unlock()
+				return
+			}
+		}
+	}
+}
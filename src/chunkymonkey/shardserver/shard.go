@@ -1,22 +1,44 @@
 package shardserver
 
 import (
+	"bytes"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	"chunkymonkey/chunkstore"
 	"chunkymonkey/entity"
 	"chunkymonkey/gamerules"
+	"chunkymonkey/proto"
 	. "chunkymonkey/types"
 )
 
-const chunksPerShard = ShardSize * ShardSize
+// The duration of a tick, in nanoseconds.
+const tickNs = NanosecondsInSecond / TicksPerSecond
+
+// How often a shard that is falling behind logs that it has skipped ticks.
+const lagLogIntervalNs = 10 * NanosecondsInSecond
+
+// How often a shard writes the chunks whose blocks have changed to the chunk
+// store.
+const saveIntervalTicks = 60 * TicksPerSecond
+
+// MaxCatchUpTicks is the most extra ticks that a shard runs at once when it has
+// fallen behind. Ticks that it falls further behind by are skipped, although
+// its world time still counts them. It may only be changed before any shards
+// are created.
+var MaxCatchUpTicks = 0
+
+// chunksPerShard returns the number of chunks in each shard.
+func chunksPerShard() int {
+	return int(ShardSize * ShardSize)
+}
 
 // chunkXzToChunkIndex assumes that locDelta is offset relative to the shard
 // origin.
 func chunkXzToChunkIndex(locDelta *ChunkXz) int {
-	return int(locDelta.X)*ShardSize + int(locDelta.Z)
+	return int(locDelta.X*ShardSize + locDelta.Z)
 }
 
 // ChunkShard represents a square shard of chunks that share a master
@@ -27,30 +49,84 @@ type ChunkShard struct {
 	entityMgr        *entity.EntityManager
 	loc              ShardXz
 	originChunkLoc   ChunkXz // The lowest X and Z located chunk in the shard.
-	chunks           [chunksPerShard]*Chunk
+	chunks           []*Chunk
 	requests         chan iShardRequest
 	ticksSinceUpdate int
+	ticksSinceSave   int
+
+	// The time at which the next tick is due, and when the shard last logged
+	// that it had skipped ticks.
+	nextTickNs int64
+	lagLogNs   int64
+
+	// The time and weather in the world, as last told by the game (and kept
+	// ticking between updates).
+	worldTime Ticks
+	weather   Weather
 
 	newActiveBlocks []BlockXyz
 	newActiveShards map[uint64]*destActiveShard
 
 	shardClients map[uint64]gamerules.IShardShardClient
 	selfClient   shardSelfClient
+
+	// Players connected to the shard, to be told if it moves.
+	players map[EntityId]gamerules.IPlayerClient
+
+	// The entity movement to send to each player at the end of the tick, so
+	// that each is sent a single packet for all of the chunks that it views.
+	updates map[EntityId]*playerUpdate
+
+	// State for handing the shard off to another chunk server. Requests
+	// received while frozen are held in deferred, and requests from other
+	// shards received after moving are forwarded through movedClient.
+	handoff     shardHandoffState
+	deferred    []iShardRequest
+	movedClient gamerules.IShardShardClient
+
+	// Set while the shard is frozen because this server's lease with the
+	// lookup service may have expired, rather than for a handoff.
+	suspended bool
+
+	// Chunk packets waiting for room with the compression workers, and those
+	// that the workers have compressed. Workers add to compressed, and signal
+	// compressReady, without waiting on the shard.
+	compressPending []*compressJob
+	compressLock    sync.Mutex
+	compressed      []*compressJob
+	compressReady   chan bool
+
+	stats shardStats
+
+	// Set to make serve return.
+	stopping bool
+
+	// Set if the shard only turns players away (see
+	// LocalShardManager.newUnownedShard).
+	unowned bool
 }
 
-func NewChunkShard(shardConnecter gamerules.IShardConnecter, chunkStore chunkstore.IChunkStore, entityMgr *entity.EntityManager, loc ShardXz) (shard *ChunkShard) {
+func NewChunkShard(shardConnecter gamerules.IShardConnecter, chunkStore chunkstore.IChunkStore, entityMgr *entity.EntityManager, loc ShardXz, worldTime Ticks, weather Weather) (shard *ChunkShard) {
 	shard = &ChunkShard{
 		shardConnecter:   shardConnecter,
 		chunkStore:       chunkStore,
 		entityMgr:        entityMgr,
 		loc:              loc,
 		originChunkLoc:   loc.ToChunkXz(),
+		chunks:           make([]*Chunk, chunksPerShard()),
 		requests:         make(chan iShardRequest, 256),
 		ticksSinceUpdate: 0,
+		compressReady:    make(chan bool, 1),
+
+		worldTime: worldTime,
+		weather:   weather,
 
 		newActiveShards: make(map[uint64]*destActiveShard),
 
 		shardClients: make(map[uint64]gamerules.IShardShardClient),
+
+		players: make(map[EntityId]gamerules.IPlayerClient),
+		updates: make(map[EntityId]*playerUpdate),
 	}
 
 	shard.selfClient.shard = shard
@@ -58,41 +134,158 @@ func NewChunkShard(shardConnecter gamerules.IShardConnecter, chunkStore chunksto
 	return
 }
 
-// serve services shard requests in the foreground.
+// serve services shard requests in the foreground, until the shard is
+// stopped.
 func (shard *ChunkShard) serve() {
-	ticker := time.NewTicker(NanosecondsInSecond / TicksPerSecond)
+	ticker := time.NewTicker(tickNs)
+	defer ticker.Stop()
+	shard.nextTickNs = time.Nanoseconds() + tickNs
+	shard.stopping = false
 
-	for {
+	for !shard.stopping {
 		select {
 		case <-ticker.C:
-			shard.tick()
+			shard.tickDue(time.Nanoseconds())
+			shard.submitCompressJobs()
+
+		case <-shard.compressReady:
+			shard.runCompressed()
 
 		case request := <-shard.requests:
-			request.perform(shard)
+			shard.performRequest(request)
 		}
 	}
 }
 
+// tickDue runs the ticks that have fallen due by nowNs. The ticker drops ticks
+// when the shard is too busy to receive them, so the number due is worked out
+// from the time instead. Up to MaxCatchUpTicks extra ticks are run to catch
+// up, and the rest are skipped.
+func (shard *ChunkShard) tickDue(nowNs int64) {
+	if nowNs < shard.nextTickNs {
+		return
+	}
+
+	due := (nowNs-shard.nextTickNs)/tickNs + 1
+	shard.nextTickNs += due * tickNs
+
+	if shard.handoff != shardRunning {
+		return
+	}
+
+	run := due
+	if run > int64(1+MaxCatchUpTicks) {
+		run = int64(1 + MaxCatchUpTicks)
+	}
+	for i := int64(0); i < run; i++ {
+		shard.tick()
+	}
+
+	skipped := due - run
+	if skipped > 0 {
+		shard.worldTime += Ticks(skipped)
+
+		if nowNs-shard.lagLogNs >= lagLogIntervalNs {
+			log.Printf("%v: fell %d ticks behind, and skipped %d of them", shard, due-1, skipped)
+			shard.lagLogNs = nowNs
+		}
+	}
+
+	shard.stats.recordLag(due-1, run-1, skipped)
+}
+
 // tick runs the shard for a single tick.
 func (shard *ChunkShard) tick() {
+	startTime := time.Nanoseconds()
+
+	shard.worldTime++
 	shard.ticksSinceUpdate++
 
+	var loadedChunks, activeBlocks, entities int
 	for _, chunk := range shard.chunks {
 		if chunk != nil {
 			chunk.tick()
+			loadedChunks++
+			activeBlocks += len(chunk.activeBlocks) + len(chunk.newActiveBlocks)
+			entities += len(chunk.entities)
 		}
 	}
 
-	if shard.ticksSinceUpdate >= TicksPerSecond {
-		for _, chunk := range shard.chunks {
-			if chunk != nil {
-				chunk.sendUpdate()
-			}
+	// Block changes and players' movement are sent every tick, and the
+	// movement of other entities once a second.
+	sendEntities := shard.ticksSinceUpdate >= TicksPerSecond
+	for _, chunk := range shard.chunks {
+		if chunk != nil {
+			chunk.sendBlockChanges()
+			chunk.sendUpdate(sendEntities)
 		}
+	}
+	shard.flushUpdates()
+	if sendEntities {
 		shard.ticksSinceUpdate = 0
 	}
 
 	shard.transferActiveBlocks()
+
+	shard.ticksSinceSave++
+	if shard.ticksSinceSave >= saveIntervalTicks {
+		shard.saveChunks()
+		shard.ticksSinceSave = 0
+	}
+
+	shard.stats.recordTick(time.Nanoseconds()-startTime, loadedChunks, activeBlocks, entities)
+}
+
+// saveChunks writes the chunks whose blocks have changed since they were last
+// written to the chunk store. Their entities are written with them. The shard
+// doesn't wait for the writes to finish.
+func (shard *ChunkShard) saveChunks() {
+	if shard.chunkStore == nil {
+		return
+	}
+
+	for _, chunk := range shard.chunks {
+		if chunk == nil || chunk.savedVersion == chunk.blocksVersion {
+			continue
+		}
+		result := shard.chunkStore.WriteChunk(chunk.loc, chunk.writeNbt())
+		chunk.savedVersion = chunk.blocksVersion
+
+		go func(loc ChunkXz) {
+			if err := <-result; err != nil {
+				if _, ok := err.(chunkstore.ReadOnlyStoreError); !ok {
+					log.Printf("%v: error writing chunk %v: %v", shard, loc, err)
+				}
+			}
+		}(chunk.loc)
+	}
+}
+
+// playerUpdate holds the entity movement to send to a player.
+type playerUpdate struct {
+	player gamerules.IPlayerClient
+	buf    bytes.Buffer
+}
+
+// updateBuffer returns the buffer of entity movement to send to the player at
+// the end of the tick.
+func (shard *ChunkShard) updateBuffer(entityId EntityId, player gamerules.IPlayerClient) *bytes.Buffer {
+	update, ok := shard.updates[entityId]
+	if !ok {
+		update = &playerUpdate{player: player}
+		shard.updates[entityId] = update
+	}
+	return &update.buf
+}
+
+// flushUpdates sends each player the entity movement added during the tick.
+func (shard *ChunkShard) flushUpdates() {
+	for entityId, update := range shard.updates {
+		if update.buf.Len() > 0 {
+			update.player.TransmitPacket(update.buf.Bytes(), proto.PacketPriorityEntityMove)
+		}
+		shard.updates[entityId] = nil, false
+	}
 }
 
 // clientForShard is used to get a IShardShardClient for a given shard, reusing
@@ -120,6 +313,12 @@ func (shard *ChunkShard) clientForShard(shardLoc ShardXz) (client gamerules.ISha
 // blockQuery performs a relatively fast query of the BlockId at the given
 // location. known=true if the returned blockTypeId is valid.
 func (shard *ChunkShard) blockQuery(chunkLoc ChunkXz, subLoc *SubChunkXyz) (blockTypeId BlockId, known bool) {
+	blockTypeId, _, known = shard.blockDataQuery(chunkLoc, subLoc)
+	return
+}
+
+// blockDataQuery is as blockQuery, but also returns the block's metadata.
+func (shard *ChunkShard) blockDataQuery(chunkLoc ChunkXz, subLoc *SubChunkXyz) (blockTypeId BlockId, blockData byte, known bool) {
 
 	chunkIndex, _, _, ok := shard.chunkIndexAndRelLoc(chunkLoc)
 
@@ -138,11 +337,57 @@ func (shard *ChunkShard) blockQuery(chunkLoc ChunkXz, subLoc *SubChunkXyz) (bloc
 
 	blockIndex, _ := subLoc.BlockIndex()
 	blockTypeId = chunk.blockId(blockIndex)
+	blockData = blockIndex.BlockData(chunk.blockData)
 	known = true
 
 	return
 }
 
+// blockIdAt returns the BlockId at the given location, provided that the block
+// is within a loaded chunk in the shard.
+func (shard *ChunkShard) blockIdAt(loc BlockXyz) (blockId BlockId, ok bool) {
+	if loc.Y < 0 {
+		return
+	}
+	chunkLoc, subLoc := loc.ToChunkLocal()
+	return shard.blockQuery(*chunkLoc, subLoc)
+}
+
+// setBlockAt sets the block at the given location, provided that the block is
+// within a loaded chunk in the shard. Returns true if the block was set.
+func (shard *ChunkShard) setBlockAt(loc BlockXyz, blockId BlockId, blockData byte) bool {
+	if loc.Y < 0 {
+		return false
+	}
+	chunkLoc, subLoc := loc.ToChunkLocal()
+	chunkIndex, _, _, ok := shard.chunkIndexAndRelLoc(*chunkLoc)
+	if !ok {
+		return false
+	}
+	chunk := shard.chunks[chunkIndex]
+	if chunk == nil {
+		return false
+	}
+	index, ok := subLoc.BlockIndex()
+	if !ok {
+		return false
+	}
+	chunk.setBlock(&loc, subLoc, index, blockId, blockData)
+	return true
+}
+
+// skyLight returns the current level of light from the sky in the world.
+func (shard *ChunkShard) skyLight() byte {
+	return shard.worldTime.SkyLight(shard.weather)
+}
+
+// reqSetWorldState updates the shard's knowledge of the time and weather.
+func (shard *ChunkShard) reqSetWorldState(worldTime Ticks, weather Weather) {
+	shard.stats.recordClockDrift(int64(shard.worldTime) - int64(worldTime))
+	shard.worldTime = worldTime
+	shard.weather = weather
+}
+
 // transferActiveBlocks takes blocks marked as newly active by addActiveBlock,
 // and informs the chunk in the destination shards.
 func (shard *ChunkShard) transferActiveBlocks() {
@@ -245,6 +490,16 @@ func (shard *ChunkShard) chunkAt(loc ChunkXz) *Chunk {
 	return chunk
 }
 
+// loadedChunkAt returns the Chunk at the given coordinates if it is in the
+// shard and loaded, or nil otherwise.
+func (shard *ChunkShard) loadedChunkAt(loc ChunkXz) *Chunk {
+	chunkIndex, _, _, ok := shard.chunkIndexAndRelLoc(loc)
+	if !ok {
+		return nil
+	}
+	return shard.chunks[chunkIndex]
+}
+
 // loadChunk loads the specified chunk from store, and returns it.
 // loc - The absolute world position of the chunk.
 // locDelta - The relative position of the chunk within the shard.
@@ -281,10 +536,54 @@ func (shard *ChunkShard) enqueue(fn func()) {
 	shard.requests <- &runGeneric{fn}
 }
 
+// enqueueFailable runs fn on the chunk at the given location, or nil if the
+// chunk does not exist. If the shard has moved to another chunk server, fail
+// is run instead.
+func (shard *ChunkShard) enqueueFailable(loc ChunkXz, fn func(chunk *Chunk), fail func()) {
+	shard.requests <- &runFailable{loc, fn, fail}
+}
+
 func (shard *ChunkShard) enqueueRequest(req iShardRequest) {
 	shard.requests <- req
 }
 
+// tryEnqueueRequest is as enqueueRequest, but drops the request and returns
+// false if the shard's requests are backed up.
+func (shard *ChunkShard) tryEnqueueRequest(req iShardRequest) bool {
+	select {
+	case shard.requests <- req:
+		return true
+	default:
+	}
+	return false
+}
+
+// reserveEntityId reserves the EntityId of an entity that has come from
+// another chunk or chunk server. If it is already in use here, a new EntityId
+// is returned instead.
+func (shard *ChunkShard) reserveEntityId(entityId EntityId) EntityId {
+	if shard.entityMgr.AddEntityId(entityId) {
+		return entityId
+	}
+
+	newEntityId := shard.entityMgr.NewEntity()
+	log.Printf("%v: EntityId %d is already in use, using %d", shard, entityId, newEntityId)
+	return newEntityId
+}
+
+// releaseEntityIds releases the EntityIds of the entities in the shard's
+// chunks, once they have gone with the shard to another chunk server.
+func (shard *ChunkShard) releaseEntityIds() {
+	for _, chunk := range shard.chunks {
+		if chunk == nil {
+			continue
+		}
+		for entityId := range chunk.entities {
+			shard.entityMgr.RemoveEntityById(entityId)
+		}
+	}
+}
+
 type destActiveShard struct {
 	loc    ShardXz
 	blocks []BlockXyz
@@ -0,0 +1,294 @@
+package shardserver
+
+import (
+	"gob"
+	"log"
+	"net"
+	"os"
+
+	"chunkymonkey/gamerules"
+	"chunkymonkey/proto"
+	. "chunkymonkey/types"
+)
+
+// ShardServer serves the shards of a chunk server to RemoteShardManagers in
+// frontend servers. The shards themselves are hosted by an IShardManager for
+// each dimension, typically a LocalShardManager.
+type ShardServer struct {
+	managers map[DimensionId]IShardManager
+}
+
+func NewShardServer(managers map[DimensionId]IShardManager) *ShardServer {
+	return &ShardServer{
+		managers: managers,
+	}
+}
+
+// Serve accepts connections from frontends on the listener until it fails.
+func (server *ShardServer) Serve(listener net.Listener) os.Error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		go server.serveConn(conn)
+	}
+	return nil
+}
+
+func (server *ShardServer) serveConn(netConn net.Conn) {
+	conn := &shardServerConn{
+		conn:          netConn,
+		playerClients: make(map[remoteClientId]gamerules.IPlayerShardClient),
+		shardClients:  make(map[remoteClientId]gamerules.IShardShardClient),
+	}
+	conn.writer.Init(netConn, "ShardServer")
+
+	defer conn.close()
+
+	decoder := gob.NewDecoder(netConn)
+
+	var hello remoteMsg
+	if err := decoder.Decode(&hello); err != nil {
+		log.Printf("ShardServer: error reading from %v: %v", netConn.RemoteAddr(), err)
+		return
+	}
+	if body, ok := hello.Body.(*msgHello); !ok {
+		log.Printf("ShardServer: expected hello from %v, got %T", netConn.RemoteAddr(), hello.Body)
+		return
+	} else if body.ShardSize != ShardSize {
+		log.Printf("ShardServer: %v uses shard size %d, expected %d", netConn.RemoteAddr(), body.ShardSize, ShardSize)
+		return
+	} else if conn.mgr, ok = server.managers[body.Dimension]; !ok {
+		log.Printf("ShardServer: %v asked for unknown dimension %d", netConn.RemoteAddr(), body.Dimension)
+		return
+	}
+
+	for {
+		var msg remoteMsg
+		if err := decoder.Decode(&msg); err != nil {
+			if err != os.EOF {
+				log.Printf("ShardServer: error reading from %v: %v", netConn.RemoteAddr(), err)
+			}
+			return
+		}
+
+		conn.handle(&msg)
+	}
+}
+
+// shardServerConn is a ShardServer's connection to a RemoteShardManager. The
+// client maps are only used by the connection's receiving goroutine.
+type shardServerConn struct {
+	conn   net.Conn
+	mgr    IShardManager
+	writer remoteWriter
+
+	playerClients map[remoteClientId]gamerules.IPlayerShardClient
+	shardClients  map[remoteClientId]gamerules.IShardShardClient
+}
+
+// send queues a request to a player on the frontend. It never blocks, so that
+// the shard making the request isn't held up by the network.
+func (conn *shardServerConn) send(clientId remoteClientId, body interface{}) {
+	conn.writer.Send(clientId, body)
+}
+
+func (conn *shardServerConn) handle(msg *remoteMsg) {
+	switch body := msg.Body.(type) {
+	case *msgPlayerConnect:
+		player := &remotePlayerClient{
+			conn:     conn,
+			clientId: msg.ClientId,
+			entityId: body.EntityId,
+		}
+		conn.playerClients[msg.ClientId] = conn.mgr.PlayerShardConnect(body.EntityId, player, body.ShardLoc)
+
+	case *msgShardConnect:
+		if client := conn.mgr.ShardShardConnect(body.ShardLoc); client != nil {
+			conn.shardClients[msg.ClientId] = client
+		}
+
+	case msgDisconnect:
+		if client, ok := conn.playerClients[msg.ClientId]; ok {
+			client.Disconnect()
+			conn.playerClients[msg.ClientId] = nil, false
+		} else if client, ok := conn.shardClients[msg.ClientId]; ok {
+			client.Disconnect()
+			conn.shardClients[msg.ClientId] = nil, false
+		}
+
+	case *msgSetWorldState:
+		conn.mgr.SetWorldState(body.WorldTime, body.Weather)
+
+	case *msgStrikeLightning:
+		conn.mgr.StrikeLightning(body.X, body.Z)
+
+	case *msgReceiveShard:
+		reply := &msgShardReceived{ShardLoc: body.ShardLoc}
+		if receiver, ok := conn.mgr.(IShardReceiver); !ok {
+			reply.Err = "chunk server cannot receive shards"
+		} else if err := receiver.ReceiveShard(body.ShardLoc, body.State); err != nil {
+			reply.Err = err.String()
+		}
+		conn.send(msg.ClientId, reply)
+
+	case *msgDiscardShard:
+		if receiver, ok := conn.mgr.(IShardReceiver); ok {
+			receiver.DiscardShard(body.ShardLoc)
+		}
+
+	case iPlayerShardReq:
+		if client, ok := conn.playerClients[msg.ClientId]; ok {
+			body.applyToShard(client)
+		}
+
+	case iShardShardReq:
+		if client, ok := conn.shardClients[msg.ClientId]; ok {
+			body.applyToShard(client)
+		}
+
+	default:
+		log.Printf("ShardServer: unexpected message %T from %v", msg.Body, conn.conn.RemoteAddr())
+	}
+}
+
+// close disconnects all of the connection's clients from their shards.
+func (conn *shardServerConn) close() {
+	for _, client := range conn.playerClients {
+		client.Disconnect()
+	}
+	for _, client := range conn.shardClients {
+		client.Disconnect()
+	}
+	conn.writer.Close()
+	conn.conn.Close()
+}
+
+// remotePlayerClient implements IPlayerClient for ShardServer, passing
+// requests from shards to a player on the frontend.
+type remotePlayerClient struct {
+	conn     *shardServerConn
+	clientId remoteClientId
+	entityId EntityId
+}
+
+func (p *remotePlayerClient) GetEntityId() EntityId {
+	return p.entityId
+}
+
+func (p *remotePlayerClient) TransmitPacket(packet []byte, priority proto.PacketPriority) {
+	p.conn.send(p.clientId, &pReqTransmitPacket{packet, priority})
+}
+
+func (p *remotePlayerClient) ShowEntity(chunkLoc ChunkXz, entityId EntityId, spawnPacket []byte) {
+	p.conn.send(p.clientId, &pReqShowEntity{chunkLoc, entityId, spawnPacket})
+}
+
+func (p *remotePlayerClient) HideEntity(chunkLoc ChunkXz, entityId EntityId) {
+	p.conn.send(p.clientId, &pReqHideEntity{chunkLoc, entityId})
+}
+
+func (p *remotePlayerClient) NotifyChunkLoad() {
+	p.conn.send(p.clientId, pReqNotifyChunkLoad(0))
+}
+
+func (p *remotePlayerClient) InventorySubscribed(block BlockXyz, invTypeId InvTypeId, slots []proto.WindowSlot) {
+	p.conn.send(p.clientId, &pReqInventorySubscribed{block, invTypeId, slots})
+}
+
+func (p *remotePlayerClient) InventorySlotUpdate(block BlockXyz, slot gamerules.Slot, slotId SlotId) {
+	p.conn.send(p.clientId, &pReqInventorySlotUpdate{block, slot, slotId})
+}
+
+func (p *remotePlayerClient) InventoryProgressUpdate(block BlockXyz, prgBarId PrgBarId, value PrgBarValue) {
+	p.conn.send(p.clientId, &pReqInventoryProgressUpdate{block, prgBarId, value})
+}
+
+func (p *remotePlayerClient) InventoryCursorUpdate(block BlockXyz, cursor gamerules.Slot) {
+	p.conn.send(p.clientId, &pReqInventoryCursorUpdate{block, cursor})
+}
+
+func (p *remotePlayerClient) InventoryTxState(block BlockXyz, txId TxId, accepted bool) {
+	p.conn.send(p.clientId, &pReqInventoryTxState{block, txId, accepted})
+}
+
+func (p *remotePlayerClient) InventoryUnsubscribed(block BlockXyz) {
+	p.conn.send(p.clientId, &pReqInventoryUnsubscribed{block})
+}
+
+func (p *remotePlayerClient) PlaceHeldItem(target BlockXyz, wasHeld gamerules.Slot) {
+	p.conn.send(p.clientId, &pReqPlaceHeldItem{target, wasHeld})
+}
+
+func (p *remotePlayerClient) InteractDone() {
+	p.conn.send(p.clientId, pReqInteractDone(0))
+}
+
+func (p *remotePlayerClient) OfferItem(fromChunk ChunkXz, entityId EntityId, item gamerules.Slot) {
+	p.conn.send(p.clientId, &pReqOfferItem{fromChunk, entityId, item})
+}
+
+func (p *remotePlayerClient) GiveItemAtPosition(atPosition AbsXyz, item gamerules.Slot) {
+	p.conn.send(p.clientId, &pReqGiveItemAtPosition{atPosition, item})
+}
+
+func (p *remotePlayerClient) GiveItem(item gamerules.Slot) {
+	p.conn.send(p.clientId, &pReqGiveItem{item})
+}
+
+func (p *remotePlayerClient) PositionLook() (AbsXyz, LookDegrees) {
+	// Shards never ask this of players, and answering would need a round trip
+	// to the frontend.
+	log.Printf("remotePlayerClient: PositionLook is not supported")
+	return AbsXyz{}, LookDegrees{}
+}
+
+func (p *remotePlayerClient) SetPositionLook(position AbsXyz, look LookDegrees) {
+	p.conn.send(p.clientId, &pReqSetPositionLook{position, look})
+}
+
+func (p *remotePlayerClient) SetPosition(position AbsXyz) {
+	p.conn.send(p.clientId, &pReqSetPosition{position})
+}
+
+func (p *remotePlayerClient) EnterPortal() {
+	p.conn.send(p.clientId, pReqEnterPortal(0))
+}
+
+func (p *remotePlayerClient) Sleep(bedLoc BlockXyz) {
+	p.conn.send(p.clientId, &pReqSleep{bedLoc})
+}
+
+func (p *remotePlayerClient) BedMissing(bedLoc BlockXyz) {
+	p.conn.send(p.clientId, &pReqBedMissing{bedLoc})
+}
+
+func (p *remotePlayerClient) Mount(vehicleId EntityId) {
+	p.conn.send(p.clientId, &pReqMount{vehicleId})
+}
+
+func (p *remotePlayerClient) Dismount(vehicleId EntityId) {
+	p.conn.send(p.clientId, &pReqDismount{vehicleId})
+}
+
+func (p *remotePlayerClient) VehicleMoved(vehicleId EntityId, position AbsXyz) {
+	p.conn.send(p.clientId, &pReqVehicleMoved{vehicleId, position})
+}
+
+func (p *remotePlayerClient) Damage(amount Health, attacker EntityId) {
+	p.conn.send(p.clientId, &pReqDamage{amount, attacker})
+}
+
+func (p *remotePlayerClient) GiveExperience(amount int16) {
+	p.conn.send(p.clientId, &pReqGiveExperience{amount})
+}
+
+func (p *remotePlayerClient) EchoMessage(msg string) {
+	p.conn.send(p.clientId, &pReqEchoMessage{msg})
+}
+
+func (p *remotePlayerClient) shardMoved(shardLoc ShardXz) {
+	p.conn.send(p.clientId, &pReqShardMoved{shardLoc})
+}
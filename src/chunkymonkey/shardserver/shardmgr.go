@@ -0,0 +1,21 @@
+package shardserver
+
+import (
+	"chunkymonkey/gamerules"
+	. "chunkymonkey/types"
+)
+
+// IShardManager is the interface by which the game drives the shards for a
+// dimension. LocalShardManager hosts the shards in the local process, and
+// RemoteShardManager drives shards hosted by a ShardServer in another process.
+type IShardManager interface {
+	gamerules.IShardConnecter
+
+	// SetWorldState informs all shards of the current time and weather in the
+	// world.
+	SetWorldState(worldTime Ticks, weather Weather)
+
+	// StrikeLightning causes lightning to strike the highest block in the
+	// column at the given location.
+	StrikeLightning(x, z BlockCoord)
+}
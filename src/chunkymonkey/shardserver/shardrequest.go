@@ -1,6 +1,7 @@
 package shardserver
 
 import (
+	"chunkymonkey/gamerules"
 	. "chunkymonkey/types"
 )
 
@@ -46,3 +47,111 @@ type runGeneric struct {
 func (req *runGeneric) perform(shard *ChunkShard) {
 	req.fn()
 }
+
+// runFailable runs a function for a player on the chunk at a location, which is
+// nil if the chunk does not exist. It is failed instead if the shard has moved
+// to another chunk server.
+type runFailable struct {
+	loc  ChunkXz
+	fn   func(chunk *Chunk)
+	fail func()
+}
+
+func (req *runFailable) perform(shard *ChunkShard) {
+	req.fn(shard.chunkAt(req.loc))
+}
+
+func (req *runFailable) failed() {
+	req.fail()
+}
+
+// setWorldState updates the shard's time and weather.
+type setWorldState struct {
+	worldTime Ticks
+	weather   Weather
+}
+
+func (req *setWorldState) perform(shard *ChunkShard) {
+	shard.reqSetWorldState(req.worldTime, req.weather)
+}
+
+// runControl runs a function that controls the shard itself. Unlike runGeneric,
+// it is performed even while the shard is being handed off.
+type runControl struct {
+	fn func()
+}
+
+func (req *runControl) perform(shard *ChunkShard) {
+	req.fn()
+}
+
+// addPlayerClient records a player connected to the shard, to be told if the
+// shard moves to another chunk server.
+type addPlayerClient struct {
+	entityId EntityId
+	player   gamerules.IPlayerClient
+}
+
+func (req *addPlayerClient) perform(shard *ChunkShard) {
+	shard.players[req.entityId] = req.player
+	if shard.handoff == shardMoved {
+		notifyShardMoved(req.player, shard.loc)
+	}
+}
+
+// removePlayerClient forgets a player that has disconnected from the shard.
+type removePlayerClient struct {
+	entityId EntityId
+}
+
+func (req *removePlayerClient) perform(shard *ChunkShard) {
+	shard.players[req.entityId] = nil, false
+	if shard.unowned && len(shard.players) == 0 {
+		shard.stopping = true
+	}
+}
+
+// iForwardableRequest is a request from another shard. It is forwarded to the
+// shard's new chunk server if the shard has moved.
+type iForwardableRequest interface {
+	iShardRequest
+	forward(client gamerules.IShardShardClient)
+}
+
+// iFailableRequest is a request from a player that has an outcome that they
+// wait for, or that they have given up an item for. If the shard has moved, it
+// can't be forwarded, so the player is told that it failed instead.
+type iFailableRequest interface {
+	iShardRequest
+	failed()
+}
+
+// setBlocksActive makes blocks within the shard active.
+type setBlocksActive struct {
+	blocks []BlockXyz
+}
+
+func (req *setBlocksActive) perform(shard *ChunkShard) {
+	shard.reqSetBlocksActive(req.blocks)
+}
+
+func (req *setBlocksActive) forward(client gamerules.IShardShardClient) {
+	client.ReqSetActiveBlocks(req.blocks)
+}
+
+// transferEntity moves an entity into a chunk within the shard.
+type transferEntity struct {
+	loc    ChunkXz
+	entity gamerules.INonPlayerEntity
+}
+
+func (req *transferEntity) perform(shard *ChunkShard) {
+	chunk := shard.chunkAt(req.loc)
+	if chunk != nil {
+		chunk.transferEntity(req.entity)
+	}
+}
+
+func (req *transferEntity) forward(client gamerules.IShardShardClient) {
+	client.ReqTransferEntity(req.loc, req.entity)
+}
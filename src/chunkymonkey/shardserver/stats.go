@@ -0,0 +1,256 @@
+package shardserver
+
+import (
+	"expvar"
+	"fmt"
+	"http"
+	"json"
+	"sort"
+	"strconv"
+	"sync"
+
+	. "chunkymonkey/types"
+)
+
+// tickHistogramBounds are the upper bounds, in nanoseconds, of the buckets
+// that shard tick durations are counted in. Ticks that take longer are counted
+// in a final bucket.
+var tickHistogramBounds = [...]int64{
+	1e6, 2e6, 5e6, 10e6, 20e6, 50e6, 100e6,
+}
+
+// ShardStats is a snapshot of a shard's statistics.
+type ShardStats struct {
+	Loc ShardXz
+
+	// The number of ticks run, and how many of them fell in each bucket of
+	// tickHistogramBounds.
+	Ticks         int64
+	TickHistogram []int64
+	LastTickNs    int64
+	MaxTickNs     int64
+
+	// How many ticks late the shard was when it last ticked, and how many
+	// extra ticks it has run to catch up, or skipped, in total.
+	TicksBehind  int64
+	CatchUpTicks int64
+	SkippedTicks int64
+
+	// How many ticks the shard's world time was ahead of the game's when the
+	// game last told it the time.
+	ClockDrift int64
+
+	// The number of requests waiting for the shard.
+	QueueDepth int
+
+	// As of the end of the last tick.
+	LoadedChunks int
+	ActiveBlocks int
+	Entities     int
+}
+
+// shardStats is kept by a shard's goroutine, and read by others.
+type shardStats struct {
+	lock          sync.Mutex
+	ticks         int64
+	tickHistogram [len(tickHistogramBounds) + 1]int64
+	lastTickNs    int64
+	maxTickNs     int64
+	ticksBehind   int64
+	catchUpTicks  int64
+	skippedTicks  int64
+	clockDrift    int64
+	loadedChunks  int
+	activeBlocks  int
+	entities      int
+}
+
+func (stats *shardStats) recordTick(tickNs int64, loadedChunks, activeBlocks, entities int) {
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+
+	bucket := len(tickHistogramBounds)
+	for i, bound := range tickHistogramBounds {
+		if tickNs < bound {
+			bucket = i
+			break
+		}
+	}
+
+	stats.ticks++
+	stats.tickHistogram[bucket]++
+	stats.lastTickNs = tickNs
+	if tickNs > stats.maxTickNs {
+		stats.maxTickNs = tickNs
+	}
+	stats.loadedChunks = loadedChunks
+	stats.activeBlocks = activeBlocks
+	stats.entities = entities
+}
+
+func (stats *shardStats) recordLag(ticksBehind, catchUpTicks, skippedTicks int64) {
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+
+	stats.ticksBehind = ticksBehind
+	stats.catchUpTicks += catchUpTicks
+	stats.skippedTicks += skippedTicks
+}
+
+func (stats *shardStats) recordClockDrift(clockDrift int64) {
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+
+	stats.clockDrift = clockDrift
+}
+
+// Stats returns a snapshot of the shard's statistics.
+func (shard *ChunkShard) Stats() ShardStats {
+	stats := &shard.stats
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+
+	histogram := make([]int64, len(stats.tickHistogram))
+	copy(histogram, stats.tickHistogram[:])
+
+	return ShardStats{
+		Loc:           shard.loc,
+		Ticks:         stats.ticks,
+		TickHistogram: histogram,
+		LastTickNs:    stats.lastTickNs,
+		MaxTickNs:     stats.maxTickNs,
+		TicksBehind:   stats.ticksBehind,
+		CatchUpTicks:  stats.catchUpTicks,
+		SkippedTicks:  stats.skippedTicks,
+		ClockDrift:    stats.clockDrift,
+		QueueDepth:    len(shard.requests),
+		LoadedChunks:  stats.loadedChunks,
+		ActiveBlocks:  stats.activeBlocks,
+		Entities:      stats.entities,
+	}
+}
+
+type shardStatsByLoc []ShardStats
+
+func (s shardStatsByLoc) Len() int {
+	return len(s)
+}
+
+func (s shardStatsByLoc) Less(i, j int) bool {
+	if s[i].Loc.X != s[j].Loc.X {
+		return s[i].Loc.X < s[j].Loc.X
+	}
+	return s[i].Loc.Z < s[j].Loc.Z
+}
+
+func (s shardStatsByLoc) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+// Stats returns the statistics of the shards running in the manager, ordered
+// by location. Shards that have moved to other chunk servers are left out.
+func (mgr *LocalShardManager) Stats() []ShardStats {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	stats := make([]ShardStats, 0, len(mgr.shards))
+	for shardKey, shard := range mgr.shards {
+		if !mgr.movedShards[shardKey] {
+			stats = append(stats, shard.Stats())
+		}
+	}
+	sort.Sort(shardStatsByLoc(stats))
+
+	return stats
+}
+
+// shardStatsVar is an expvar.Var of the statistics of the shards in each
+// dimension of each world.
+type shardStatsVar map[string]map[DimensionId]*LocalShardManager
+
+func (v shardStatsVar) String() string {
+	stats := make(map[string]map[string][]ShardStats)
+	for worldName, managers := range v {
+		stats[worldName] = make(map[string][]ShardStats)
+		for dimension, mgr := range managers {
+			stats[worldName][strconv.Itoa(int(dimension))] = mgr.Stats()
+		}
+	}
+
+	result, err := json.Marshal(stats)
+	if err != nil {
+		return strconv.Quote(err.String())
+	}
+	return string(result)
+}
+
+func (v shardStatsVar) serveHttp(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintf(w, "<html><head><title>Shards</title></head><body>\n")
+	fmt.Fprintf(w, "<p>Shard size: %d chunks</p>\n", ShardSize)
+
+	worldNames := make([]string, 0, len(v))
+	for worldName := range v {
+		worldNames = append(worldNames, worldName)
+	}
+	sort.SortStrings(worldNames)
+
+	for _, worldName := range worldNames {
+		for _, dimension := range []DimensionId{DimensionNormal, DimensionNether} {
+			if mgr, ok := v[worldName][dimension]; ok {
+				writeShardStatsTable(w, worldName, dimension, mgr.Stats())
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "</body></html>\n")
+}
+
+// writeShardStatsTable writes an HTML table of the statistics of the shards in
+// a dimension of a world.
+func writeShardStatsTable(w http.ResponseWriter, worldName string, dimension DimensionId, shardStats []ShardStats) {
+	fmt.Fprintf(w, "<h2>World %s, dimension %d</h2>\n<table border=\"1\">\n<tr>", worldName, dimension)
+	fmt.Fprintf(w, "<th>Shard</th><th>Ticks</th><th>Last tick</th><th>Max tick</th>")
+	for _, bound := range tickHistogramBounds {
+		fmt.Fprintf(w, "<th>&lt;%dms</th>", bound/1e6)
+	}
+	fmt.Fprintf(w, "<th>&gt;=%dms</th>", tickHistogramBounds[len(tickHistogramBounds)-1]/1e6)
+	fmt.Fprintf(w, "<th>Behind</th><th>Caught up</th><th>Skipped</th><th>Drift</th>")
+	fmt.Fprintf(w, "<th>Queue</th><th>Chunks</th><th>Active blocks</th><th>Entities</th></tr>\n")
+
+	for _, stats := range shardStats {
+		fmt.Fprintf(w, "<tr><td>%d,%d</td><td>%d</td><td>%.3fms</td><td>%.3fms</td>",
+			stats.Loc.X, stats.Loc.Z, stats.Ticks,
+			float64(stats.LastTickNs)/1e6, float64(stats.MaxTickNs)/1e6)
+		for _, count := range stats.TickHistogram {
+			fmt.Fprintf(w, "<td>%d</td>", count)
+		}
+		fmt.Fprintf(w, "<td>%d</td><td>%d</td><td>%d</td><td>%d</td>",
+			stats.TicksBehind, stats.CatchUpTicks, stats.SkippedTicks, stats.ClockDrift)
+		fmt.Fprintf(w, "<td>%d</td><td>%d</td><td>%d</td><td>%d</td></tr>\n",
+			stats.QueueDepth, stats.LoadedChunks, stats.ActiveBlocks, stats.Entities)
+	}
+
+	fmt.Fprintf(w, "</table>\n")
+}
+
+// PublishShardStats exports the statistics of the shards hosted in this
+// process, given the shard managers of each dimension of each world, as the
+// expvar "shards" and as an HTML page at "/shards" on the default HTTP server.
+// Managers other than LocalShardManagers are ignored. It must only be called
+// once.
+func PublishShardStats(worlds map[string]map[DimensionId]IShardManager) {
+	v := make(shardStatsVar)
+	for worldName, managers := range worlds {
+		v[worldName] = make(map[DimensionId]*LocalShardManager)
+		for dimension, mgr := range managers {
+			if localMgr, ok := mgr.(*LocalShardManager); ok {
+				v[worldName][dimension] = localMgr
+			}
+		}
+	}
+
+	expvar.Publish("shards", v)
+	http.HandleFunc("/shards", func(w http.ResponseWriter, r *http.Request) {
+		v.serveHttp(w, r)
+	})
+}
@@ -0,0 +1,102 @@
+package shardserver
+
+import (
+	"testing"
+
+	. "chunkymonkey/types"
+)
+
+func TestShardStatsRecordTick(t *testing.T) {
+	shard := NewChunkShard(nil, nil, nil, ShardXz{1, -2}, 0, WeatherClear)
+
+	shard.stats.recordTick(500e3, 1, 2, 3)
+	shard.stats.recordTick(3e6, 4, 5, 6)
+	shard.stats.recordTick(1e9, 7, 8, 9)
+	shard.stats.recordTick(2e6, 10, 11, 12)
+	shard.enqueueRequest(&runGeneric{func() {}})
+
+	stats := shard.Stats()
+
+	if stats.Loc.X != 1 || stats.Loc.Z != -2 {
+		t.Errorf("Expected shard {1 -2}, got %v", stats.Loc)
+	}
+	if stats.Ticks != 4 || stats.LastTickNs != 2e6 || stats.MaxTickNs != 1e9 {
+		t.Errorf("Expected 4 ticks, last 2ms and max 1s, got %d, %d and %d", stats.Ticks, stats.LastTickNs, stats.MaxTickNs)
+	}
+
+	expectedHistogram := []int64{1, 0, 2, 0, 0, 0, 0, 1}
+	if len(stats.TickHistogram) != len(expectedHistogram) {
+		t.Fatalf("Expected %d histogram buckets, got %v", len(expectedHistogram), stats.TickHistogram)
+	}
+	for i := range expectedHistogram {
+		if stats.TickHistogram[i] != expectedHistogram[i] {
+			t.Errorf("Expected histogram %v, got %v", expectedHistogram, stats.TickHistogram)
+			break
+		}
+	}
+
+	if stats.QueueDepth != 1 {
+		t.Errorf("Expected queue depth 1, got %d", stats.QueueDepth)
+	}
+	if stats.LoadedChunks != 10 || stats.ActiveBlocks != 11 || stats.Entities != 12 {
+		t.Errorf("Expected counts from the last tick, got %v", stats)
+	}
+}
+
+func TestShardSizeChunkIndex(t *testing.T) {
+	defer func(size ChunkCoord) {
+		ShardSize = size
+	}(ShardSize)
+	ShardSize = 4
+
+	shard := NewChunkShard(nil, nil, nil, ShardXz{1, 0}, 0, WeatherClear)
+	if len(shard.chunks) != 16 {
+		t.Fatalf("Expected 16 chunks in shard, got %d", len(shard.chunks))
+	}
+
+	if _, _, _, ok := shard.chunkIndexAndRelLoc(ChunkXz{3, 0}); ok {
+		t.Errorf("Expected chunk {3 0} to be outside of shard {1 0}")
+	}
+	if index, _, _, ok := shard.chunkIndexAndRelLoc(ChunkXz{7, 3}); !ok || index != 15 {
+		t.Errorf("Expected chunk {7 3} to have index 15, got %d (%t)", index, ok)
+	}
+}
+
+func TestShardTickDue(t *testing.T) {
+	defer func(max int) {
+		MaxCatchUpTicks = max
+	}(MaxCatchUpTicks)
+	MaxCatchUpTicks = 2
+
+	shard := NewChunkShard(nil, nil, nil, ShardXz{0, 0}, 100, WeatherClear)
+	shard.nextTickNs = tickNs
+
+	// Woken early, so no ticks are due.
+	shard.tickDue(tickNs / 2)
+	if shard.worldTime != 100 {
+		t.Errorf("Expected no ticks to run, world time is %d", shard.worldTime)
+	}
+
+	// On time.
+	shard.tickDue(tickNs)
+	if shard.worldTime != 101 || shard.nextTickNs != 2*tickNs {
+		t.Errorf("Expected one tick, got world time %d and next tick at %d", shard.worldTime, shard.nextTickNs)
+	}
+
+	// Four ticks late. Two extra ticks are run, and the other two are skipped.
+	shard.tickDue(6*tickNs + tickNs/2)
+	if shard.worldTime != 106 || shard.nextTickNs != 7*tickNs {
+		t.Errorf("Expected five ticks to pass, got world time %d and next tick at %d", shard.worldTime, shard.nextTickNs)
+	}
+
+	stats := shard.Stats()
+	if stats.Ticks != 4 || stats.TicksBehind != 4 || stats.CatchUpTicks != 2 || stats.SkippedTicks != 2 {
+		t.Errorf("Expected 4 ticks run, 4 behind, 2 caught up and 2 skipped, got %v", stats)
+	}
+
+	// The game's clock wins.
+	shard.reqSetWorldState(104, WeatherClear)
+	if stats = shard.Stats(); stats.ClockDrift != 2 || shard.worldTime != 104 {
+		t.Errorf("Expected clock drift of 2 and world time 104, got %d and %d", stats.ClockDrift, shard.worldTime)
+	}
+}
@@ -0,0 +1,115 @@
+package shardserver
+
+import (
+	"chunkymonkey/gamerules"
+	. "chunkymonkey/types"
+)
+
+// vehicleItems maps the items that are placed as vehicles to the type of
+// object that they become.
+var vehicleItems = map[ItemTypeId]ObjTypeId{
+	ItemTypeIdMinecart:    ObjTypeIdMinecart,
+	ItemTypeIdBoat:        ObjTypeIdBoat,
+	ItemTypeIdStorageCart: ObjTypeIdStorageCart,
+	ItemTypeIdPoweredCart: ObjTypeIdPoweredCart,
+}
+
+// placeVehicle creates a vehicle of the given type in the given block.
+// Minecarts must be placed on a rail, and boats in an empty or water block.
+// Returns true if the vehicle was placed.
+func (chunk *Chunk) placeVehicle(loc *BlockXyz, objType ObjTypeId) bool {
+	blockId, _, ok := chunk.BlockDataQuery(*loc)
+	if !ok {
+		return false
+	}
+
+	if objType == ObjTypeIdBoat {
+		if blockType, ok := gamerules.Blocks.Get(blockId); !ok || !blockType.Replaceable {
+			return false
+		}
+	} else if !gamerules.IsRailBlock(blockId) {
+		return false
+	}
+
+	position := loc.MidPointToAbsXyz()
+	position.Y = AbsCoord(loc.Y)
+
+	object := gamerules.NewObject(objType)
+	object.PointObject.Init(&position, &AbsVelocity{})
+	chunk.AddEntity(object)
+
+	return true
+}
+
+// reqUseEntity handles a player using or hitting an entity in the chunk.
+func (chunk *Chunk) reqUseEntity(player gamerules.IPlayerClient, position *AbsXyz, target EntityId, leftClick bool) {
+	if leftClick && chunk.attack(player, position, target) {
+		return
+	}
+
+	entity, ok := chunk.entities[target]
+	if !ok {
+		return
+	}
+
+	object, ok := entity.(*gamerules.Object)
+	if !ok {
+		return
+	}
+
+	if !object.Position().IsWithinDistanceOf(position, MaxInteractDistance) {
+		return
+	}
+
+	if leftClick {
+		if object.Hit(position) {
+			// Broken up. Leave behind the item it was made from.
+			chunk.removeEntity(object)
+			if itemTypeId, ok := object.DroppedItem(); ok {
+				chunk.AddEntity(gamerules.NewItem(itemTypeId, 1, 0, object.Position(), &AbsVelocity{}, 0))
+			}
+		}
+		return
+	}
+
+	if !object.IsRideable() {
+		return
+	}
+
+	playerId := player.GetEntityId()
+	switch object.Rider() {
+	case NoEntityId:
+		object.SetRider(playerId)
+		player.Mount(target)
+	case playerId:
+		object.SetRider(NoEntityId)
+		player.Dismount(target)
+	}
+}
+
+// reqSteerVehicle pushes the vehicle with the input of the player riding it.
+func (chunk *Chunk) reqSteerVehicle(playerId EntityId, vehicle EntityId, input *AbsVelocity) {
+	entity, ok := chunk.entities[vehicle]
+	if !ok {
+		return
+	}
+
+	if object, ok := entity.(*gamerules.Object); ok && object.Rider() == playerId {
+		object.Steer(input)
+	}
+}
+
+// moveRider tells the player riding a vehicle where it has moved to, if it has
+// moved. Riders that are no longer subscribed to the chunk (e.g they have
+// disconnected or gone elsewhere) are taken off the vehicle.
+func (chunk *Chunk) moveRider(object *gamerules.Object, moved bool) {
+	rider, ok := chunk.subscribers[object.Rider()]
+	if !ok {
+		object.SetRider(NoEntityId)
+		return
+	}
+
+	if moved {
+		rider.VehicleMoved(object.EntityId, object.RiderPosition())
+	}
+}
@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+
+	. "chunkymonkey/types"
+	"chunkymonkey/util"
+	"nbt"
+)
+
+// DiskStorage implements IStorage, holding each chunk and player in a gzipped
+// NBT file under a directory. Each file holds a compound with the value's
+// Version and Data.
+type DiskStorage struct {
+	dir string
+
+	// lock guards the files, and versions, which caches the version of each
+	// file that has been read or written.
+	lock     sync.Mutex
+	versions map[string]Version
+}
+
+// NewDiskStorage creates a DiskStorage that keeps its files under the given
+// directory.
+func NewDiskStorage(dir string) *DiskStorage {
+	return &DiskStorage{
+		dir:      dir,
+		versions: make(map[string]Version),
+	}
+}
+
+func (s *DiskStorage) chunkPath(dimension DimensionId, chunkLoc *ChunkXz) string {
+	return path.Join(s.dir, "chunks", fmt.Sprintf("DIM%d", dimension), fmt.Sprintf("c.%d.%d.dat", chunkLoc.X, chunkLoc.Z))
+}
+
+func (s *DiskStorage) playerPath(name string) string {
+	return path.Join(s.dir, "players", name+".dat")
+}
+
+func (s *DiskStorage) GetChunk(dimension DimensionId, chunkLoc ChunkXz) (chunkTag nbt.ITag, version Version, err os.Error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.read(s.chunkPath(dimension, &chunkLoc))
+}
+
+func (s *DiskStorage) PutChunk(dimension DimensionId, chunkLoc ChunkXz, expected Version, chunkTag nbt.ITag) (version Version, err os.Error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.write(s.chunkPath(dimension, &chunkLoc), expected, chunkTag)
+}
+
+func (s *DiskStorage) GetPlayer(name string) (playerTag nbt.ITag, version Version, err os.Error) {
+	if err = checkPlayerName(name); err != nil {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.read(s.playerPath(name))
+}
+
+func (s *DiskStorage) PutPlayer(name string, expected Version, playerTag nbt.ITag) (version Version, err os.Error) {
+	if err = checkPlayerName(name); err != nil {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.write(s.playerPath(name), expected, playerTag)
+}
+
+// read returns the data and version held in the file. It expects the lock to
+// be held.
+func (s *DiskStorage) read(filename string) (data nbt.ITag, version Version, err os.Error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		if errno, ok := util.Errno(err); ok && errno == os.ENOENT {
+			s.versions[filename] = NoVersion
+			return nil, NoVersion, nil
+		}
+		return
+	}
+	defer file.Close()
+
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		return
+	}
+	defer gzipReader.Close()
+
+	root, err := nbt.Read(gzipReader)
+	if err != nil {
+		return
+	}
+
+	versionTag, ok := root.Lookup("Version").(*nbt.Long)
+	if !ok {
+		return nil, NoVersion, fmt.Errorf("%s: missing version", filename)
+	}
+	if data = root.Lookup("Data"); data == nil {
+		return nil, NoVersion, fmt.Errorf("%s: missing data", filename)
+	}
+
+	version = Version(versionTag.Value)
+	s.versions[filename] = version
+
+	return
+}
+
+// version returns the current version of the file. It expects the lock to be
+// held.
+func (s *DiskStorage) version(filename string) (version Version, err os.Error) {
+	if version, ok := s.versions[filename]; ok {
+		return version, nil
+	}
+	_, version, err = s.read(filename)
+	return
+}
+
+// write replaces the data in the file if it has the expected version. It
+// writes via a temporary file, so that a failure part way through doesn't
+// leave a corrupt file behind. It expects the lock to be held.
+func (s *DiskStorage) write(filename string, expected Version, data nbt.ITag) (version Version, err os.Error) {
+	current, err := s.version(filename)
+	if err != nil {
+		return
+	}
+	if expected != AnyVersion && expected != current {
+		return current, ErrVersionMismatch
+	}
+	version = current + 1
+
+	if err = os.MkdirAll(path.Dir(filename), 0777); err != nil {
+		return
+	}
+
+	tmpFilename := filename + ".tmp"
+	file, err := os.OpenFile(tmpFilename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+	if err != nil {
+		return
+	}
+
+	gzipWriter, err := gzip.NewWriter(file)
+	if err != nil {
+		file.Close()
+		return
+	}
+
+	err = nbt.Write(gzipWriter, &nbt.Compound{
+		map[string]nbt.ITag{
+			"Version": &nbt.Long{int64(version)},
+			"Data":    data,
+		},
+	})
+	gzipWriter.Close()
+	file.Close()
+	if err != nil {
+		return
+	}
+
+	if err = os.Rename(tmpFilename, filename); err != nil {
+		return
+	}
+	s.versions[filename] = version
+
+	return version, nil
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"gob"
+	"net"
+	"os"
+	"sync"
+
+	. "chunkymonkey/types"
+	"nbt"
+)
+
+// RemoteStorage implements IStorage for a StorageServer in another process.
+// It connects to the server when first used, and again on the next call after
+// the connection fails.
+type RemoteStorage struct {
+	addr string
+
+	// lock guards the connection, and is held for the duration of each call.
+	lock    sync.Mutex
+	conn    net.Conn
+	encoder *gob.Encoder
+	decoder *gob.Decoder
+}
+
+// NewRemoteStorage creates a RemoteStorage for the StorageServer at the given
+// address.
+func NewRemoteStorage(addr string) *RemoteStorage {
+	return &RemoteStorage{
+		addr: addr,
+	}
+}
+
+// Close disconnects from the StorageServer.
+func (s *RemoteStorage) Close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.disconnect()
+}
+
+func (s *RemoteStorage) disconnect() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+// call sends a request to the StorageServer and waits for its response.
+func (s *RemoteStorage) call(req iStorageReq) (resp *storageResponse, err os.Error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.conn == nil {
+		if s.conn, err = net.Dial("tcp", s.addr); err != nil {
+			s.conn = nil
+			return
+		}
+		s.encoder = gob.NewEncoder(s.conn)
+		s.decoder = gob.NewDecoder(s.conn)
+	}
+
+	resp = new(storageResponse)
+	if err = s.encoder.Encode(&storageRequest{req}); err == nil {
+		err = s.decoder.Decode(resp)
+	}
+	if err != nil {
+		s.disconnect()
+		return nil, err
+	}
+
+	return resp, resp.remoteError()
+}
+
+// get makes a request that returns NBT data.
+func (s *RemoteStorage) get(req iStorageReq) (tag nbt.ITag, version Version, err os.Error) {
+	resp, err := s.call(req)
+	if err != nil {
+		return
+	}
+	if tag, err = decodeNbt(resp.Data); err != nil {
+		return
+	}
+	return tag, resp.Version, nil
+}
+
+func (s *RemoteStorage) GetChunk(dimension DimensionId, chunkLoc ChunkXz) (chunkTag nbt.ITag, version Version, err os.Error) {
+	return s.get(&reqGetChunk{dimension, chunkLoc})
+}
+
+func (s *RemoteStorage) PutChunk(dimension DimensionId, chunkLoc ChunkXz, expected Version, chunkTag nbt.ITag) (version Version, err os.Error) {
+	data, err := encodeNbt(chunkTag)
+	if err != nil {
+		return
+	}
+	resp, err := s.call(&reqPutChunk{dimension, chunkLoc, expected, data})
+	if resp != nil {
+		version = resp.Version
+	}
+	return
+}
+
+func (s *RemoteStorage) GetPlayer(name string) (playerTag nbt.ITag, version Version, err os.Error) {
+	return s.get(&reqGetPlayer{name})
+}
+
+func (s *RemoteStorage) PutPlayer(name string, expected Version, playerTag nbt.ITag) (version Version, err os.Error) {
+	data, err := encodeNbt(playerTag)
+	if err != nil {
+		return
+	}
+	resp, err := s.call(&reqPutPlayer{name, expected, data})
+	if resp != nil {
+		version = resp.Version
+	}
+	return
+}
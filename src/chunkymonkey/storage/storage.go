@@ -0,0 +1,66 @@
+// The storage package holds the long-term state of the world: the NBT data of
+// chunks and of players.
+//
+// Each stored value has a version, which changes each time that it is written.
+// Writes give the version that they expect to replace, and fail if the value
+// has been written by someone else since it was read. This stops servers that
+// share a store from silently losing each others' changes.
+package storage
+
+import (
+	"os"
+	"strings"
+
+	. "chunkymonkey/types"
+	"nbt"
+)
+
+// Version identifies a revision of a stored value.
+type Version int64
+
+const (
+	// NoVersion is the version of values that do not exist. Writing with
+	// NoVersion expected only succeeds if the value does not yet exist.
+	NoVersion = Version(0)
+
+	// AnyVersion can be given as the expected version to write a value
+	// regardless of its current version.
+	AnyVersion = Version(-1)
+)
+
+var (
+	ErrVersionMismatch = os.NewError("stored value has changed since it was read")
+	ErrBadName         = os.NewError("bad player name")
+)
+
+// IStorage is the interface to the storage service. DiskStorage implements it
+// in the local process, and RemoteStorage implements it for a StorageServer in
+// another process.
+type IStorage interface {
+	// GetChunk returns the NBT data of a chunk and its version. chunkTag is
+	// nil and version is NoVersion if the chunk has not been stored.
+	GetChunk(dimension DimensionId, chunkLoc ChunkXz) (chunkTag nbt.ITag, version Version, err os.Error)
+
+	// PutChunk stores the NBT data of a chunk, if its stored version is the
+	// expected one, and returns its new version. Otherwise it returns
+	// ErrVersionMismatch.
+	PutChunk(dimension DimensionId, chunkLoc ChunkXz, expected Version, chunkTag nbt.ITag) (version Version, err os.Error)
+
+	// GetPlayer returns the NBT data of the named player and its version.
+	// playerTag is nil and version is NoVersion if the player has not been
+	// stored.
+	GetPlayer(name string) (playerTag nbt.ITag, version Version, err os.Error)
+
+	// PutPlayer stores the NBT data of the named player, if its stored
+	// version is the expected one, and returns its new version. Otherwise it
+	// returns ErrVersionMismatch.
+	PutPlayer(name string, expected Version, playerTag nbt.ITag) (version Version, err os.Error)
+}
+
+// checkPlayerName returns ErrBadName for names that can't safely be stored.
+func checkPlayerName(name string) os.Error {
+	if name == "" || strings.IndexAny(name, "/\\.") >= 0 {
+		return ErrBadName
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"bytes"
+	"gob"
+	"log"
+	"net"
+	"os"
+
+	. "chunkymonkey/types"
+	"nbt"
+)
+
+// The wire protocol between RemoteStorage and StorageServer. The client sends
+// a gob-encoded storageRequest for each call, and the server replies with a
+// storageResponse. NBT data is sent in its binary form.
+
+type storageRequest struct {
+	Body iStorageReq
+}
+
+type storageResponse struct {
+	Data    []byte // Empty if there is no data.
+	Version Version
+	Err     string // Empty if there was no error.
+}
+
+type iStorageReq interface {
+	apply(storage IStorage) (resp *storageResponse)
+}
+
+var errMissingRequest = os.NewError("storage request has no body")
+
+func init() {
+	gob.Register(&reqGetChunk{})
+	gob.Register(&reqPutChunk{})
+	gob.Register(&reqGetPlayer{})
+	gob.Register(&reqPutPlayer{})
+}
+
+func encodeNbt(tag nbt.ITag) (data []byte, err os.Error) {
+	if tag == nil {
+		return nil, nil
+	}
+	buf := new(bytes.Buffer)
+	if err = nbt.Write(buf, tag); err != nil {
+		return
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeNbt(data []byte) (tag nbt.ITag, err os.Error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	return nbt.Read(bytes.NewBuffer(data))
+}
+
+func newStorageResponse(tag nbt.ITag, version Version, err os.Error) *storageResponse {
+	resp := &storageResponse{Version: version}
+	if err == nil {
+		resp.Data, err = encodeNbt(tag)
+	}
+	if err != nil {
+		resp.Err = err.String()
+	}
+	return resp
+}
+
+// remoteError recreates the error that the server's IStorage returned. Errors
+// that this package defines are returned as themselves.
+func (resp *storageResponse) remoteError() os.Error {
+	switch resp.Err {
+	case "":
+		return nil
+	case ErrVersionMismatch.String():
+		return ErrVersionMismatch
+	case ErrBadName.String():
+		return ErrBadName
+	}
+	return os.NewError(resp.Err)
+}
+
+type reqGetChunk struct {
+	Dimension DimensionId
+	ChunkLoc  ChunkXz
+}
+
+func (req *reqGetChunk) apply(storage IStorage) *storageResponse {
+	return newStorageResponse(storage.GetChunk(req.Dimension, req.ChunkLoc))
+}
+
+type reqPutChunk struct {
+	Dimension DimensionId
+	ChunkLoc  ChunkXz
+	Expected  Version
+	Data      []byte
+}
+
+func (req *reqPutChunk) apply(storage IStorage) *storageResponse {
+	chunkTag, err := decodeNbt(req.Data)
+	if err != nil {
+		return newStorageResponse(nil, NoVersion, err)
+	}
+	version, err := storage.PutChunk(req.Dimension, req.ChunkLoc, req.Expected, chunkTag)
+	return newStorageResponse(nil, version, err)
+}
+
+type reqGetPlayer struct {
+	Name string
+}
+
+func (req *reqGetPlayer) apply(storage IStorage) *storageResponse {
+	return newStorageResponse(storage.GetPlayer(req.Name))
+}
+
+type reqPutPlayer struct {
+	Name     string
+	Expected Version
+	Data     []byte
+}
+
+func (req *reqPutPlayer) apply(storage IStorage) *storageResponse {
+	playerTag, err := decodeNbt(req.Data)
+	if err != nil {
+		return newStorageResponse(nil, NoVersion, err)
+	}
+	version, err := storage.PutPlayer(req.Name, req.Expected, playerTag)
+	return newStorageResponse(nil, version, err)
+}
+
+// StorageServer serves an IStorage (typically a DiskStorage) to
+// RemoteStorages in frontend and chunk servers.
+type StorageServer struct {
+	storage IStorage
+}
+
+func NewStorageServer(storage IStorage) *StorageServer {
+	return &StorageServer{
+		storage: storage,
+	}
+}
+
+// Serve accepts connections on the listener until it fails.
+func (server *StorageServer) Serve(listener net.Listener) os.Error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		go server.serveConn(conn)
+	}
+	return nil
+}
+
+func (server *StorageServer) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	decoder := gob.NewDecoder(conn)
+	encoder := gob.NewEncoder(conn)
+
+	for {
+		var req storageRequest
+		if err := decoder.Decode(&req); err != nil {
+			if err != os.EOF {
+				log.Printf("StorageServer: error reading from %v: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+
+		var resp *storageResponse
+		if req.Body == nil {
+			resp = newStorageResponse(nil, NoVersion, errMissingRequest)
+		} else {
+			resp = req.Body.apply(server.storage)
+		}
+
+		if err := encoder.Encode(resp); err != nil {
+			log.Printf("StorageServer: error writing to %v: %v", conn.RemoteAddr(), err)
+			return
+		}
+	}
+}
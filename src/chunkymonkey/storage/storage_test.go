@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"gob"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+
+	. "chunkymonkey/types"
+	"nbt"
+)
+
+func newTestDiskStorage(t *testing.T) (storage *DiskStorage, dir string) {
+	dir, err := ioutil.TempDir("", "storage_test")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	return NewDiskStorage(dir), dir
+}
+
+func testTag(value int32) *nbt.Compound {
+	return &nbt.Compound{
+		map[string]nbt.ITag{
+			"Value": &nbt.Int{value},
+		},
+	}
+}
+
+func checkTagValue(t *testing.T, name string, tag nbt.ITag, expected int32) {
+	if tag == nil {
+		t.Errorf("%s: expected value %d, got no data", name, expected)
+	} else if value, ok := tag.Lookup("Value").(*nbt.Int); !ok || value.Value != expected {
+		t.Errorf("%s: expected value %d, got %v", name, expected, tag.Lookup("Value"))
+	}
+}
+
+// testChunks checks the versioning of chunks in the given storage.
+func testChunks(t *testing.T, storage IStorage) {
+	if tag, version, err := storage.GetChunk(DimensionNormal, ChunkXz{1, -2}); err != nil || tag != nil || version != NoVersion {
+		t.Fatalf("expected no chunk, got %v, %d, %v", tag, version, err)
+	}
+
+	version, err := storage.PutChunk(DimensionNormal, ChunkXz{1, -2}, NoVersion, testTag(1))
+	if err != nil {
+		t.Fatalf("PutChunk: %v", err)
+	}
+
+	tag, gotVersion, err := storage.GetChunk(DimensionNormal, ChunkXz{1, -2})
+	if err != nil {
+		t.Fatalf("GetChunk: %v", err)
+	}
+	checkTagValue(t, "chunk", tag, 1)
+	if gotVersion != version {
+		t.Errorf("expected version %d, got %d", version, gotVersion)
+	}
+
+	// The same chunk location in another dimension is a different chunk.
+	if tag, _, _ := storage.GetChunk(DimensionNether, ChunkXz{1, -2}); tag != nil {
+		t.Errorf("expected no nether chunk, got %v", tag)
+	}
+
+	// Writing with an out of date version fails.
+	if _, err := storage.PutChunk(DimensionNormal, ChunkXz{1, -2}, NoVersion, testTag(2)); err != ErrVersionMismatch {
+		t.Errorf("expected ErrVersionMismatch, got %v", err)
+	}
+	newVersion, err := storage.PutChunk(DimensionNormal, ChunkXz{1, -2}, version, testTag(3))
+	if err != nil {
+		t.Fatalf("PutChunk: %v", err)
+	}
+	if newVersion == version {
+		t.Errorf("expected version to change")
+	}
+
+	if _, err := storage.PutChunk(DimensionNormal, ChunkXz{1, -2}, AnyVersion, testTag(4)); err != nil {
+		t.Errorf("PutChunk with AnyVersion: %v", err)
+	}
+	tag, _, _ = storage.GetChunk(DimensionNormal, ChunkXz{1, -2})
+	checkTagValue(t, "chunk", tag, 4)
+}
+
+// testPlayers checks the versioning of players in the given storage.
+func testPlayers(t *testing.T, storage IStorage) {
+	if tag, version, err := storage.GetPlayer("bob"); err != nil || tag != nil || version != NoVersion {
+		t.Fatalf("expected no player, got %v, %d, %v", tag, version, err)
+	}
+
+	version, err := storage.PutPlayer("bob", NoVersion, testTag(1))
+	if err != nil {
+		t.Fatalf("PutPlayer: %v", err)
+	}
+	if _, err := storage.PutPlayer("bob", NoVersion, testTag(2)); err != ErrVersionMismatch {
+		t.Errorf("expected ErrVersionMismatch, got %v", err)
+	}
+	if _, err := storage.PutPlayer("bob", version, testTag(3)); err != nil {
+		t.Errorf("PutPlayer: %v", err)
+	}
+
+	tag, _, err := storage.GetPlayer("bob")
+	if err != nil {
+		t.Fatalf("GetPlayer: %v", err)
+	}
+	checkTagValue(t, "player", tag, 3)
+
+	if _, _, err := storage.GetPlayer("../bob"); err != ErrBadName {
+		t.Errorf("expected ErrBadName, got %v", err)
+	}
+}
+
+func TestDiskStorage(t *testing.T) {
+	storage, dir := newTestDiskStorage(t)
+	defer os.RemoveAll(dir)
+
+	testChunks(t, storage)
+	testPlayers(t, storage)
+
+	// Versions are kept on disk.
+	storage = NewDiskStorage(dir)
+	if _, err := storage.PutPlayer("bob", NoVersion, testTag(5)); err != ErrVersionMismatch {
+		t.Errorf("expected ErrVersionMismatch, got %v", err)
+	}
+}
+
+func TestRemoteStorage(t *testing.T) {
+	diskStorage, dir := newTestDiskStorage(t)
+	defer os.RemoveAll(dir)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer listener.Close()
+
+	go NewStorageServer(diskStorage).Serve(listener)
+
+	storage := NewRemoteStorage(listener.Addr().String())
+	defer storage.Close()
+
+	testChunks(t, storage)
+	testPlayers(t, storage)
+}
+
+func TestStorageServerMissingRequest(t *testing.T) {
+	diskStorage, dir := newTestDiskStorage(t)
+	defer os.RemoveAll(dir)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer listener.Close()
+
+	go NewStorageServer(diskStorage).Serve(listener)
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	encoder := gob.NewEncoder(conn)
+	decoder := gob.NewDecoder(conn)
+
+	// A request without a body is answered with an error, and the connection
+	// can still be used.
+	var resp storageResponse
+	if err := encoder.Encode(&storageRequest{}); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if err := decoder.Decode(&resp); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if resp.Err != errMissingRequest.String() {
+		t.Errorf("expected error %q, got %q", errMissingRequest, resp.Err)
+	}
+
+	resp = storageResponse{}
+	if err := encoder.Encode(&storageRequest{&reqGetPlayer{"bob"}}); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if err := decoder.Decode(&resp); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if resp.Err != "" || resp.Version != NoVersion {
+		t.Errorf("expected no player, got version %d, error %q", resp.Version, resp.Err)
+	}
+}
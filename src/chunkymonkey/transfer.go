@@ -0,0 +1,139 @@
+package chunkymonkey
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+
+	"chunkymonkey/player"
+	"chunkymonkey/proto"
+	. "chunkymonkey/types"
+	"nbt"
+)
+
+// ServeTransfers accepts players transferred from other frontends on addr.
+// Frontends that players are transferred between must share the same worlds
+// and player storage.
+func (game *Game) ServeTransfers(addr string) {
+	listener, e := net.Listen("tcp", addr)
+	if e != nil {
+		log.Fatalf("Listen: %s", e.String())
+	}
+	log.Print("Accepting player transfers on ", addr)
+
+	for {
+		conn, e2 := listener.Accept()
+		if e2 != nil {
+			log.Print("Accept: ", e2.String())
+			break
+		}
+
+		go game.acceptTransfer(conn)
+	}
+}
+
+// acceptTransfer takes over the session of a player being transferred from
+// another frontend. Like login(), it runs in its own goroutine.
+func (game *Game) acceptTransfer(conn net.Conn) {
+	name, entityId, codec, playerData, err := player.ReadTransfer(conn)
+	if err != nil {
+		log.Printf("Failed to read transfer from %v: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+
+	p, err := game.newTransferredPlayer(conn, codec, name, entityId, playerData)
+	if err == nil {
+		if err = player.AckTransfer(conn, nil); err != nil {
+			game.entityManager.RemoveEntityById(entityId)
+		}
+	} else {
+		player.AckTransfer(conn, err)
+	}
+	if err != nil {
+		log.Printf("Failed to accept transfer of player %q: %v", name, err)
+		conn.Close()
+		return
+	}
+
+	log.Print("Player ", name, " transferred from ", conn.RemoteAddr())
+
+	game.playerConnect <- p
+	p.StartTransferred()
+}
+
+// newTransferredPlayer creates the player being transferred, keeping the
+// EntityId that their client knows them by.
+func (game *Game) newTransferredPlayer(conn net.Conn, codec *proto.Codec, name string, entityId EntityId, playerData nbt.ITag) (p *player.Player, err os.Error) {
+	if !validPlayerUsername.MatchString(name) {
+		return nil, os.NewError("Bad username")
+	}
+
+	if !game.entityManager.AddEntityId(entityId) {
+		return nil, fmt.Errorf("EntityId %d is already in use", entityId)
+	}
+
+	p = player.NewPlayer(entityId, conn, codec, name, game.defaultWorld.name, game.defaultWorld.store.SpawnPosition, game.playerDisconnect, game)
+	if err = p.ReadTransferNbt(playerData); err != nil {
+		game.entityManager.RemoveEntityById(entityId)
+		return nil, err
+	}
+
+	w, ok := game.worlds[p.World()]
+	if !ok {
+		log.Printf("Player %s was in unknown world %q, moving to %q", name, p.World(), game.defaultWorld.name)
+		w = game.defaultWorld
+	}
+	p.SetWorld(w.name, w.store.SpawnPosition, w.store.GameMode)
+
+	return p, nil
+}
+
+// TransferPlayer hands the session of the named player to the frontend
+// accepting transfers at addr.
+func (game *Game) TransferPlayer(name string, addr string) os.Error {
+	result := make(chan *player.Player)
+	game.enqueue(func(_ *Game) {
+		result <- game.playerNames[name]
+	})
+
+	p := <-result
+	if p == nil {
+		return fmt.Errorf("player %q is not logged in", name)
+	}
+	return p.Transfer(addr)
+}
+
+// DrainPlayers stops players logging in, and transfers every player to the
+// frontend accepting transfers at addr. It returns the number of players
+// transferred, and the last error from those that could not be.
+func (game *Game) DrainPlayers(addr string) (transferred int, err os.Error) {
+	result := make(chan []*player.Player)
+	game.enqueue(func(_ *Game) {
+		game.UnderMaintenanceMsg = "Server is shutting down."
+		players := make([]*player.Player, 0, len(game.players))
+		for _, p := range game.players {
+			players = append(players, p)
+		}
+		result <- players
+	})
+	players := <-result
+
+	errs := make(chan os.Error)
+	for _, p := range players {
+		go func(p *player.Player) {
+			errs <- p.Transfer(addr)
+		}(p)
+	}
+
+	for _ = range players {
+		if e := <-errs; e != nil {
+			err = e
+		} else {
+			transferred++
+		}
+	}
+
+	return
+}
@@ -0,0 +1,293 @@
+package chunkymonkey
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"chunkymonkey/client"
+	. "chunkymonkey/entity"
+	"chunkymonkey/gamerules"
+	"chunkymonkey/player"
+	"chunkymonkey/proto"
+	"chunkymonkey/shardserver"
+	. "chunkymonkey/types"
+	"chunkymonkey/worldstore"
+	"nbt"
+)
+
+const (
+	testTimeoutNs = 10 * NanosecondsInSecond
+
+	blockIdChest    = BlockId(54)
+	itemTypeIdChest = ItemTypeId(54)
+	itemTypeIdDirt  = ItemTypeId(3)
+	itemTypeIdStick = ItemTypeId(280)
+)
+
+func init() {
+	if err := gamerules.LoadGameRules("blocks.json", "items.json", "recipes.json", "furnace.json", "users.json", "groups.json"); err != nil {
+		panic(err)
+	}
+}
+
+// listen listens on a free local port, and calls accept in a new goroutine
+// for each connection made to it.
+func listen(t *testing.T, accept func(conn net.Conn)) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go accept(conn)
+		}
+	}()
+
+	return listener
+}
+
+// startChunkServer hosts the shards of the world at worldPath, so that more
+// than one frontend can share them as they would in production.
+func startChunkServer(t *testing.T, worldPath string) net.Listener {
+	worldStore, err := worldstore.LoadWorldStore(worldPath, nil)
+	if err != nil {
+		t.Fatalf("LoadWorldStore: %v", err)
+	}
+
+	entityMgr := new(EntityManager)
+	entityMgr.InitRange(1<<30, 1<<31-1)
+
+	managers := make(map[DimensionId]shardserver.IShardManager)
+	for _, dimension := range []DimensionId{DimensionNormal, DimensionNether} {
+		managers[dimension] = shardserver.NewLocalShardManager(worldStore.ChunkStoreFor(dimension), entityMgr)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	go shardserver.NewShardServer(managers).Serve(listener)
+
+	return listener
+}
+
+func startFrontend(t *testing.T, worldPath string, chunkServerAddr string) *Game {
+	game, err := NewGame([]string{worldPath}, chunkServerAddr, "", "")
+	if err != nil {
+		t.Fatalf("NewGame: %v", err)
+	}
+	// The test's client doesn't authenticate with minecraft.net.
+	game.serverId = "-"
+	return game
+}
+
+// waitFor polls cond until it returns true, failing the test if it hasn't
+// within testTimeoutNs.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Nanoseconds() + testTimeoutNs
+	for !cond() {
+		if time.Nanoseconds() > deadline {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(1e7)
+	}
+}
+
+func findPlayer(game *Game, name string) *player.Player {
+	result := make(chan *player.Player)
+	game.enqueue(func(_ *Game) {
+		result <- game.playerNames[name]
+	})
+	return <-result
+}
+
+// playerState returns everything that would be transferred of the named
+// player, or nil if they aren't in the game.
+func playerState(game *Game, name string) *nbt.Compound {
+	p := findPlayer(game, name)
+	if p == nil {
+		return nil
+	}
+
+	result := make(chan *nbt.Compound, 1)
+	p.Enqueue(func(p *player.Player) {
+		result <- p.WriteTransferNbt()
+	})
+	select {
+	case data := <-result:
+		return data
+	case <-time.After(testTimeoutNs):
+	}
+	return nil
+}
+
+func inventorySlotNbt(slot int8, itemTypeId ItemTypeId, count int8) nbt.ITag {
+	return &nbt.Compound{
+		map[string]nbt.ITag{
+			"Slot":   &nbt.Byte{slot},
+			"id":     &nbt.Short{int16(itemTypeId)},
+			"Count":  &nbt.Byte{count},
+			"Damage": &nbt.Short{0},
+		},
+	}
+}
+
+// findChestLoc finds where a chest can be placed on top of the ground near
+// the spawn point, from the chunks that the client has been sent.
+func findChestLoc(c *client.Client) (loc BlockXyz, ok bool) {
+	for x := BlockCoord(-8); x < 8; x++ {
+		for z := BlockCoord(-8); z < 8; z++ {
+			loc = BlockXyz{x, 0, z}
+			ground, groundOk := c.GroundAt(&loc)
+			if !groundOk || ground < 1 || ground >= ChunkSizeY-2 {
+				continue
+			}
+			loc.Y = BlockYCoord(ground)
+
+			below := BlockXyz{x, loc.Y - 1, z}
+			blockId, _, _ := c.BlockAt(&below)
+			if blockType, ok := gamerules.Blocks.Get(blockId); ok && blockType.Attachable {
+				return loc, true
+			}
+		}
+	}
+	return
+}
+
+func TestTransferPlayerBetweenFrontends(t *testing.T) {
+	dir, err := ioutil.TempDir("", "transfer_test")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	worldPath := dir + "/world"
+	if err = worldstore.CreateWorld(worldPath); err != nil {
+		t.Fatalf("CreateWorld: %v", err)
+	}
+
+	defer func(secret string) {
+		player.TransferSecret = secret
+	}(player.TransferSecret)
+	player.TransferSecret = "secret"
+
+	// Both frontends use the same chunk server, so that the chest that is
+	// opened on one can be opened again on the other.
+	chunkServer := startChunkServer(t, worldPath)
+	defer chunkServer.Close()
+	gameA := startFrontend(t, worldPath, chunkServer.Addr().String())
+	gameB := startFrontend(t, worldPath, chunkServer.Addr().String())
+
+	logins := listen(t, func(conn net.Conn) {
+		gameA.login(conn)
+	})
+	defer logins.Close()
+	transfers := listen(t, func(conn net.Conn) {
+		gameB.acceptTransfer(conn)
+	})
+	defer transfers.Close()
+
+	// alice has a chest to place, a stick to open it with, and some dirt to
+	// hold on the cursor. She isn't at full health, and isn't fed enough to
+	// heal while she is being transferred.
+	data := player.NewPlayer(0, nil, nil, "alice", gameA.defaultWorld.name, gameA.defaultWorld.store.SpawnPosition, nil, nil).WriteNbt()
+	data.Tags["Health"] = &nbt.Short{13}
+	data.Tags["foodLevel"] = &nbt.Int{10}
+	data.Tags["Inventory"] = &nbt.List{nbt.TagCompound, []nbt.ITag{
+		inventorySlotNbt(0, itemTypeIdChest, 1),
+		inventorySlotNbt(1, itemTypeIdStick, 1),
+		inventorySlotNbt(9, itemTypeIdDirt, 5),
+	}}
+	if err = gameA.defaultWorld.store.WritePlayerData("alice", data); err != nil {
+		t.Fatalf("WritePlayerData: %v", err)
+	}
+
+	c, err := client.Dial(logins.Addr().String(), "alice", proto.ProtocolVersionBeta18)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer c.Close()
+	if err = c.WaitSpawn(testTimeoutNs); err != nil {
+		t.Fatalf("WaitSpawn: %v", err)
+	}
+
+	var chestLoc BlockXyz
+	waitFor(t, "somewhere to place the chest", func() (ok bool) {
+		chestLoc, ok = findChestLoc(c)
+		return
+	})
+
+	// alice is moved to stand above where the chest is placed. The move is
+	// repeated in case the client confirming where she spawned arrives after
+	// it.
+	pA := findPlayer(gameA, "alice")
+	standAt := AbsXyz{AbsCoord(chestLoc.X) + 0.5, AbsCoord(chestLoc.Y) + 1, AbsCoord(chestLoc.Z) + 0.5}
+	waitFor(t, "alice to move", func() bool {
+		pA.Client().SetPosition(standAt)
+		time.Sleep(5e7)
+		position, _ := pA.Client().PositionLook()
+		return position == standAt
+	})
+
+	below := BlockXyz{chestLoc.X, chestLoc.Y - 1, chestLoc.Z}
+	if err = c.Place(below, FaceTop); err != nil {
+		t.Fatalf("Place: %v", err)
+	}
+	waitFor(t, "the chest to be placed", func() bool {
+		blockId, _, _ := c.BlockAt(&chestLoc)
+		return blockId == blockIdChest
+	})
+
+	// The dirt is picked up onto the cursor, as the client would by clicking
+	// on it.
+	pA.Enqueue(func(p *player.Player) {
+		p.PacketWindowClick(WindowIdInventory, 9, false, 1, false, &proto.WindowSlot{itemTypeIdDirt, 5, 0})
+	})
+
+	if err = c.Hold(1); err != nil {
+		t.Fatalf("Hold: %v", err)
+	}
+	if err = c.Place(chestLoc, FaceTop); err != nil {
+		t.Fatalf("Place: %v", err)
+	}
+
+	var before *nbt.Compound
+	waitFor(t, "the chest to open", func() bool {
+		before = playerState(gameA, "alice")
+		return before != nil && before.Lookup("Window") != nil
+	})
+	if before.Lookup("Cursor") == nil {
+		t.Fatalf("alice isn't holding anything on the cursor")
+	}
+
+	if err = gameA.TransferPlayer("alice", transfers.Addr().String()); err != nil {
+		t.Fatalf("TransferPlayer: %v", err)
+	}
+
+	// The chest is opened again once alice's chunks have loaded on the other
+	// frontend.
+	var after *nbt.Compound
+	waitFor(t, "alice to arrive with the chest open", func() bool {
+		after = playerState(gameB, "alice")
+		return after != nil && after.Lookup("Window") != nil
+	})
+
+	for _, tag := range []string{"Inventory", "Pos", "Health", "Cursor", "Window"} {
+		if !reflect.DeepEqual(before.Lookup(tag), after.Lookup(tag)) {
+			t.Errorf("%s: transferred as %v, want %v", tag, after.Lookup(tag), before.Lookup(tag))
+		}
+	}
+
+	if p := findPlayer(gameA, "alice"); p != nil {
+		t.Errorf("alice is still in the game that she was transferred from")
+	}
+}
@@ -14,6 +14,101 @@ const (
 	NanosecondsInSecond = 1e9
 )
 
+// Times of day, as ticks into the day.
+const (
+	TimeOfDayDawnEnd   = Ticks(0)
+	TimeOfDayDuskStart = Ticks(12000)
+	TimeOfDayDuskEnd   = Ticks(13800)
+	TimeOfDayDawnStart = Ticks(22200)
+)
+
+// Light levels.
+const (
+	LightLevelMax    = 15
+	SkyLightLevelMin = 4
+)
+
+// TimeOfDay returns the number of ticks into the current day.
+func (t Ticks) TimeOfDay() Ticks {
+	tod := t % TicksPerDay
+	if tod < 0 {
+		tod += TicksPerDay
+	}
+	return tod
+}
+
+// IsNight returns true if the time is between the end of dusk and the start
+// of dawn.
+func (t Ticks) IsNight() bool {
+	tod := t.TimeOfDay()
+	return tod >= TimeOfDayDuskEnd && tod < TimeOfDayDawnStart
+}
+
+// SkyLight returns the level of light from the sky for the time of day, after
+// any darkening due to the given weather. The result is never lower than
+// SkyLightLevelMin.
+func (t Ticks) SkyLight(weather Weather) (level byte) {
+	const lightRange = LightLevelMax - SkyLightLevelMin
+
+	tod := t.TimeOfDay()
+
+	switch {
+	case tod < TimeOfDayDuskStart:
+		level = LightLevelMax
+	case tod < TimeOfDayDuskEnd:
+		elapsed := tod - TimeOfDayDuskStart
+		level = byte(LightLevelMax - elapsed*lightRange/(TimeOfDayDuskEnd-TimeOfDayDuskStart))
+	case tod < TimeOfDayDawnStart:
+		level = SkyLightLevelMin
+	default:
+		elapsed := tod - TimeOfDayDawnStart
+		level = byte(SkyLightLevelMin + elapsed*lightRange/(TicksPerDay-TimeOfDayDawnStart))
+	}
+
+	darkening := weather.SkyDarkening()
+	if level < SkyLightLevelMin+darkening {
+		level = SkyLightLevelMin
+	} else {
+		level -= darkening
+	}
+
+	return
+}
+
+// Weather conditions in the world. Note that rain falls as snow in cold
+// places, but that is decided by the client.
+type Weather byte
+
+const (
+	WeatherClear   = Weather(0)
+	WeatherRain    = Weather(1)
+	WeatherThunder = Weather(2)
+)
+
+// SkyDarkening returns the number of light levels by which the weather reduces
+// light from the sky.
+func (w Weather) SkyDarkening() byte {
+	switch w {
+	case WeatherRain:
+		return 3
+	case WeatherThunder:
+		return 5
+	}
+	return 0
+}
+
+func (w Weather) String() string {
+	switch w {
+	case WeatherClear:
+		return "clear"
+	case WeatherRain:
+		return "rain"
+	case WeatherThunder:
+		return "thunder"
+	}
+	return "unknown"
+}
+
 // 1 "TickTime" is the duration of a server "tick". This value is intended for
 // use in sub-tick physics calculations.
 type TickTime float64
@@ -28,9 +123,21 @@ const (
 	DimensionNormal = DimensionId(0)
 )
 
+// Each block travelled in the Nether is worth this many blocks travelled in
+// the normal world.
+const NetherScale = 8
+
 // Player/mob health.
 type Health int16
 
+// Whether a player is in survival or creative mode.
+type GameMode int32
+
+const (
+	GameModeSurvival = GameMode(0)
+	GameModeCreative = GameMode(1)
+)
+
 // Item-related types
 
 // Item type ID
@@ -45,6 +152,19 @@ func (id ItemTypeId) ToBlockId() (blockId BlockId, ok bool) {
 	return 0, false
 }
 
+const (
+	ItemTypeIdFlintAndSteel = ItemTypeId(259)
+	ItemTypeIdBow           = ItemTypeId(261)
+	ItemTypeIdArrow         = ItemTypeId(262)
+	ItemTypeIdMinecart      = ItemTypeId(328)
+	ItemTypeIdSnowball      = ItemTypeId(332)
+	ItemTypeIdBoat          = ItemTypeId(333)
+	ItemTypeIdStorageCart   = ItemTypeId(342)
+	ItemTypeIdPoweredCart   = ItemTypeId(343)
+	ItemTypeIdEgg           = ItemTypeId(344)
+	ItemTypeIdBed           = ItemTypeId(355)
+)
+
 // Item metadata. The meaning of this varies depending upon the item type. In
 // the case of tools/armor it indicates "uses" or "damage".
 type ItemData int16
@@ -56,6 +176,10 @@ type ItemCount int8
 
 type EntityId int32
 
+// NoEntityId is used in place of an EntityId where there is no entity, for
+// example the vehicle of an entity that isn't riding anything.
+const NoEntityId = EntityId(-1)
+
 func (e EntityId) GetEntityId() EntityId {
 	return e
 }
@@ -86,12 +210,19 @@ const (
 
 type EntityStatus byte
 
+const (
+	EntityStatusHurt           = EntityStatus(2)
+	EntityStatusDead           = EntityStatus(3)
+	EntityStatusEatingAccepted = EntityStatus(9)
+)
+
 type EntityAnimation byte
 
 const (
 	EntityAnimationNone     = EntityAnimation(0)
 	EntityAnimationSwingArm = EntityAnimation(1)
 	EntityAnimationDamage   = EntityAnimation(2)
+	EntityAnimationLeaveBed = EntityAnimation(3)
 	EntityAnimationUnknown1 = EntityAnimation(102)
 	EntityAnimationCrouch   = EntityAnimation(104)
 	EntityAnimationUncrouch = EntityAnimation(105)
@@ -102,6 +233,7 @@ type EntityAction byte
 const (
 	EntityActionCrouch   = EntityAction(1)
 	EntityActionUncrouch = EntityAction(2)
+	EntityActionLeaveBed = EntityAction(3)
 )
 
 type ObjTypeId int8
@@ -158,9 +290,23 @@ const (
 type BlockId byte
 
 const (
-	BlockIdMin = 0
-	BlockIdAir = BlockId(0)
-	BlockIdMax = 255
+	BlockIdMin             = 0
+	BlockIdAir             = BlockId(0)
+	BlockIdBedrock         = BlockId(7)
+	BlockIdWater           = BlockId(8)
+	BlockIdStationaryWater = BlockId(9)
+	BlockIdLava            = BlockId(10)
+	BlockIdStationaryLava  = BlockId(11)
+	BlockIdBed             = BlockId(26)
+	BlockIdPoweredRail     = BlockId(27)
+	BlockIdDetectorRail    = BlockId(28)
+	BlockIdObsidian        = BlockId(49)
+	BlockIdFire            = BlockId(51)
+	BlockIdRail            = BlockId(66)
+	BlockIdNetherrack      = BlockId(87)
+	BlockIdGlowstone       = BlockId(89)
+	BlockIdPortal          = BlockId(90)
+	BlockIdMax             = 255
 )
 
 // Block face (0-5)
@@ -206,6 +352,7 @@ const (
 	DigStarted    = DigStatus(0)
 	DigBlockBroke = DigStatus(2)
 	DigDropItem   = DigStatus(4)
+	DigUseItemEnd = DigStatus(5) // Eating or drawing a bow stopped.
 )
 
 const (
@@ -373,6 +520,9 @@ const (
 
 	// The area within which a client receives updates.
 	ChunkRadius = 10
+	// The area within which a client is shown entities. It must not exceed
+	// ChunkRadius, as entities are only shown from subscribed chunks.
+	EntityRadius = 4
 	// The radius in which all chunks must be sent before completing a client's
 	// login process.
 	MinChunkRadius = 2
@@ -428,8 +578,8 @@ func (p *AbsXyz) ToBlockXyz() *BlockXyz {
 
 func (p *AbsXyz) ToShardXz() ShardXz {
 	return ShardXz{
-		X: ShardCoord(math.Floor(float64(p.X / (ChunkSizeH * ShardSize)))),
-		Z: ShardCoord(math.Floor(float64(p.Z / (ChunkSizeH * ShardSize)))),
+		X: ShardCoord(math.Floor(float64(p.X / (ChunkSizeH * AbsCoord(ShardSize))))),
+		Z: ShardCoord(math.Floor(float64(p.Z / (ChunkSizeH * AbsCoord(ShardSize))))),
 	}
 }
 
@@ -471,10 +621,13 @@ func (abs *AbsIntXyz) IAdd(dx, dy, dz AbsIntCoord) {
 
 // Shard types and data.
 
-const (
-	// Each shard is ShardSize * ShardSize chunks square.
-	ShardSize = 16
-)
+// DefaultShardSize is the default value of ShardSize.
+const DefaultShardSize = 16
+
+// Each shard is ShardSize * ShardSize chunks square. It may only be changed
+// before any shards are created, and all servers sharing a world must use the
+// same size.
+var ShardSize ChunkCoord = DefaultShardSize
 
 type ShardCoord int32
 
@@ -484,8 +637,8 @@ type ShardXz struct {
 
 func (loc *ShardXz) ToChunkXz() ChunkXz {
 	return ChunkXz{
-		X: ChunkCoord(loc.X * ShardSize),
-		Z: ChunkCoord(loc.Z * ShardSize),
+		X: ChunkCoord(loc.X) * ShardSize,
+		Z: ChunkCoord(loc.Z) * ShardSize,
 	}
 }
 
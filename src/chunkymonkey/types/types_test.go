@@ -518,3 +518,39 @@ func TestBlockXyz_ToAbsIntXyz(t *testing.T) {
 		}
 	}
 }
+
+func TestTicks_SkyLight(t *testing.T) {
+	type Test struct {
+		time     Ticks
+		weather  Weather
+		expected byte
+	}
+
+	var tests = []Test{
+		{0, WeatherClear, 15},
+		{6000, WeatherClear, 15},
+		{11999, WeatherClear, 15},
+		{12000, WeatherClear, 15},
+		{12900, WeatherClear, 10},
+		{13800, WeatherClear, 4},
+		{18000, WeatherClear, 4},
+		{22200, WeatherClear, 4},
+		{23100, WeatherClear, 9},
+		{TicksPerDay, WeatherClear, 15},
+		{TicksPerDay*3 + 18000, WeatherClear, 4},
+		{-6000, WeatherClear, 4},
+		{6000, WeatherRain, 12},
+		{6000, WeatherThunder, 10},
+		{18000, WeatherRain, 4},
+		{18000, WeatherThunder, 4},
+		{12500, WeatherThunder, 7},
+	}
+
+	for _, r := range tests {
+		result := r.time.SkyLight(r.weather)
+		if r.expected != result {
+			t.Errorf("Ticks(%d).SkyLight(%v) expected %d got %d",
+				r.time, r.weather, r.expected, result)
+		}
+	}
+}
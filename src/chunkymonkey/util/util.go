@@ -1,7 +1,9 @@
 package util
 
 import (
+	"net"
 	"os"
+	"time"
 )
 
 func Errno(err os.Error) (errno os.Errno, ok bool) {
@@ -11,3 +13,33 @@ func Errno(err os.Error) (errno os.Errno, ok bool) {
 	errno, ok = err.(os.Errno)
 	return
 }
+
+// DialTimeout connects over TCP to the given address, giving up after timeout
+// nanoseconds. A connection made after giving up is closed.
+func DialTimeout(addr string, timeout int64) (conn net.Conn, err os.Error) {
+	type dialResult struct {
+		conn net.Conn
+		err  os.Error
+	}
+
+	result := make(chan dialResult)
+	abandoned := make(chan bool)
+	go func() {
+		conn, err := net.Dial("tcp", addr)
+		select {
+		case result <- dialResult{conn, err}:
+		case <-abandoned:
+			if conn != nil {
+				conn.Close()
+			}
+		}
+	}()
+
+	select {
+	case r := <-result:
+		return r.conn, r.err
+	case <-time.After(timeout):
+		close(abandoned)
+	}
+	return nil, os.NewError("timed out connecting to " + addr)
+}
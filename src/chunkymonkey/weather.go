@@ -0,0 +1,99 @@
+package chunkymonkey
+
+import (
+	"rand"
+
+	. "chunkymonkey/types"
+	"chunkymonkey/worldstore"
+)
+
+const (
+	// Ranges of durations for each weather state, in ticks.
+	minRainTicks     = TicksPerDay / 2
+	randRainTicks    = TicksPerDay / 2
+	minNoRainTicks   = TicksPerDay / 2
+	randNoRainTicks  = TicksPerDay * 7
+	minThunderTicks  = 3600
+	randThunderTicks = TicksPerDay / 2
+	minCalmTicks     = TicksPerDay / 2
+	randCalmTicks    = TicksPerDay * 7
+
+	// The chance per tick (1 in N) of lightning striking near each player while
+	// there is a thunderstorm.
+	lightningChancePerPlayer = 2000
+
+	// Maximum horizontal distance from a player that lightning will strike.
+	lightningRadius = 48
+)
+
+// weather maintains the state of rain and thunderstorms. Rain and thunder have
+// separate timers, as in the Notchian server. A thunderstorm only happens when
+// both are active.
+type weather struct {
+	worldstore.WeatherData
+}
+
+// Weather returns the current visible weather.
+func (w *weather) Weather() Weather {
+	switch {
+	case w.Raining && w.Thundering:
+		return WeatherThunder
+	case w.Raining:
+		return WeatherRain
+	}
+	return WeatherClear
+}
+
+// tick advances the weather by one tick. It returns changed=true if the visible
+// weather changed as a result.
+func (w *weather) tick(rand *rand.Rand) (changed bool) {
+	prior := w.Weather()
+
+	// A timer that is already at zero has never been set (e.g a newly created
+	// world), so a duration is picked for the current state rather than
+	// toggling it.
+	if w.ThunderTime <= 0 {
+		w.ThunderTime = w.thunderDuration(rand)
+	} else {
+		w.ThunderTime--
+		if w.ThunderTime <= 0 {
+			w.Thundering = !w.Thundering
+			w.ThunderTime = w.thunderDuration(rand)
+		}
+	}
+
+	if w.RainTime <= 0 {
+		w.RainTime = w.rainDuration(rand)
+	} else {
+		w.RainTime--
+		if w.RainTime <= 0 {
+			w.Raining = !w.Raining
+			w.RainTime = w.rainDuration(rand)
+		}
+	}
+
+	return prior != w.Weather()
+}
+
+// set forces the weather to the given state, and picks new durations for it
+// to last.
+func (w *weather) set(weather Weather, rand *rand.Rand) {
+	w.Raining = weather == WeatherRain || weather == WeatherThunder
+	w.Thundering = weather == WeatherThunder
+	w.RainTime = w.rainDuration(rand)
+	w.ThunderTime = w.thunderDuration(rand)
+}
+
+func (w *weather) rainDuration(rand *rand.Rand) int32 {
+	if w.Raining {
+		return int32(minRainTicks + rand.Intn(randRainTicks))
+	}
+	return int32(minNoRainTicks + rand.Intn(randNoRainTicks))
+}
+
+func (w *weather) thunderDuration(rand *rand.Rand) int32 {
+	if w.Thundering {
+		return int32(minThunderTicks + rand.Intn(randThunderTicks))
+	}
+	return int32(minCalmTicks + rand.Intn(randCalmTicks))
+}
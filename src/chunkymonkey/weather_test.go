@@ -0,0 +1,144 @@
+package chunkymonkey
+
+import (
+	"rand"
+	"testing"
+
+	. "chunkymonkey/types"
+	"chunkymonkey/worldstore"
+)
+
+// inRange returns true if the duration is one that could be picked from min
+// and the random range above it.
+func inRange(duration int32, min, random int) bool {
+	return duration >= int32(min) && duration < int32(min+random)
+}
+
+func TestWeatherTick(t *testing.T) {
+	type durations struct{ min, random int }
+	var (
+		rain    = durations{minRainTicks, randRainTicks}
+		noRain  = durations{minNoRainTicks, randNoRainTicks}
+		thunder = durations{minThunderTicks, randThunderTicks}
+		calm    = durations{minCalmTicks, randCalmTicks}
+	)
+
+	tests := []struct {
+		name        string
+		before      worldstore.WeatherData
+		wantWeather Weather
+		wantChanged bool
+		// The timers expected after the tick. A fixed time is given by min
+		// with a random range of zero.
+		wantRain    durations
+		wantThunder durations
+	}{
+		{
+			"new world picks durations for clear weather",
+			worldstore.WeatherData{},
+			WeatherClear, false,
+			noRain, calm,
+		},
+		{
+			"timers count down",
+			worldstore.WeatherData{RainTime: 10, ThunderTime: 20},
+			WeatherClear, false,
+			durations{9, 1}, durations{19, 1},
+		},
+		{
+			"rain starts",
+			worldstore.WeatherData{RainTime: 1, ThunderTime: 20},
+			WeatherRain, true,
+			rain, durations{19, 1},
+		},
+		{
+			"rain stops",
+			worldstore.WeatherData{Raining: true, RainTime: 1, ThunderTime: 20},
+			WeatherClear, true,
+			noRain, durations{19, 1},
+		},
+		{
+			"thunder starts while raining",
+			worldstore.WeatherData{Raining: true, RainTime: 10, ThunderTime: 1},
+			WeatherThunder, true,
+			durations{9, 1}, thunder,
+		},
+		{
+			"thunder without rain isn't seen",
+			worldstore.WeatherData{RainTime: 10, ThunderTime: 1},
+			WeatherClear, false,
+			durations{9, 1}, thunder,
+		},
+		{
+			"rain stopping ends a thunderstorm",
+			worldstore.WeatherData{Raining: true, RainTime: 1, Thundering: true, ThunderTime: 20},
+			WeatherClear, true,
+			noRain, durations{19, 1},
+		},
+		{
+			"thunder stops but rain continues",
+			worldstore.WeatherData{Raining: true, RainTime: 10, Thundering: true, ThunderTime: 1},
+			WeatherRain, true,
+			durations{9, 1}, calm,
+		},
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for _, test := range tests {
+		w := weather{test.before}
+		changed := w.tick(r)
+		if changed != test.wantChanged {
+			t.Errorf("%s: expected changed=%t, got %t", test.name, test.wantChanged, changed)
+		}
+		if got := w.Weather(); got != test.wantWeather {
+			t.Errorf("%s: expected weather %d, got %d", test.name, test.wantWeather, got)
+		}
+		if !inRange(w.RainTime, test.wantRain.min, test.wantRain.random) {
+			t.Errorf("%s: rain time %d out of range %+v", test.name, w.RainTime, test.wantRain)
+		}
+		if !inRange(w.ThunderTime, test.wantThunder.min, test.wantThunder.random) {
+			t.Errorf("%s: thunder time %d out of range %+v", test.name, w.ThunderTime, test.wantThunder)
+		}
+	}
+}
+
+func TestWeatherSet(t *testing.T) {
+	tests := []struct {
+		weather        Weather
+		wantRaining    bool
+		wantThundering bool
+	}{
+		{WeatherClear, false, false},
+		{WeatherRain, true, false},
+		{WeatherThunder, true, true},
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for _, test := range tests {
+		// Start from the opposite of the weather being set, with timers about
+		// to expire.
+		w := weather{worldstore.WeatherData{
+			Raining:     !test.wantRaining,
+			RainTime:    1,
+			Thundering:  !test.wantThundering,
+			ThunderTime: 1,
+		}}
+		w.set(test.weather, r)
+
+		if got := w.Weather(); got != test.weather {
+			t.Errorf("Weather %d: got weather %d", test.weather, got)
+		}
+		if w.Raining != test.wantRaining || w.Thundering != test.wantThundering {
+			t.Errorf("Weather %d: expected raining=%t thundering=%t, got %t %t",
+				test.weather, test.wantRaining, test.wantThundering, w.Raining, w.Thundering)
+		}
+		// New durations are picked for the weather set, so it lasts.
+		if w.RainTime <= 1 || w.ThunderTime <= 1 {
+			t.Errorf("Weather %d: expected new durations, got rain %d, thunder %d",
+				test.weather, w.RainTime, w.ThunderTime)
+		}
+		if w.tick(r) {
+			t.Errorf("Weather %d: changed on the next tick", test.weather)
+		}
+	}
+}
@@ -101,6 +101,30 @@ func (w *PlayerInventory) TakeOneHeldItem(into *gamerules.Slot) {
 	w.holding.TakeOneItem(w.holdingIndex, into)
 }
 
+// TakeOneItemOfType takes one item of the given type from anywhere in the
+// player's inventory, preferring the items they hold, and puts it in `into`.
+func (w *PlayerInventory) TakeOneItemOfType(itemTypeId ItemTypeId, into *gamerules.Slot) {
+	w.holding.TakeOneItemOfType(itemTypeId, into)
+	if into.Count == 0 {
+		w.main.TakeOneItemOfType(itemTypeId, into)
+	}
+}
+
+// SetSlot replaces the contents of a slot in the inventory window, as a player
+// in creative mode may. Returns false if the slot is out of range or is a
+// crafting slot.
+func (w *PlayerInventory) SetSlot(slotId SlotId, item *gamerules.Slot) bool {
+	// The crafting inventory is the first view of the window.
+	for i, inv := range []*gamerules.Inventory{&w.armor, &w.main, &w.holding} {
+		view := &w.Window.views[i+1]
+		if slotId >= view.startSlot && slotId < view.endSlot {
+			inv.SetSlot(slotId-view.startSlot, *item)
+			return true
+		}
+	}
+	return false
+}
+
 // Writes packets for other players to see the equipped items.
 func (w *PlayerInventory) SendFullEquipmentUpdate(writer io.Writer) (err os.Error) {
 	slot, _ := w.HeldItem()
@@ -229,3 +253,47 @@ func (w *PlayerInventory) WriteNbt() nbt.ITag {
 
 	return &nbt.List{nbt.TagCompound, slots}
 }
+
+// WriteCraftingNbt serializes the contents of the crafting grid, which WriteNbt
+// leaves out. It is used when the player is transferred to another frontend
+// without the client being told, so that the grid stays as the client sees it.
+func (w *PlayerInventory) WriteCraftingNbt() nbt.ITag {
+	slots := make([]nbt.ITag, 0, 0)
+
+	for i := 0; i < int(w.crafting.NumSlots()); i++ {
+		slot := w.crafting.Slot(SlotId(i))
+		if !slot.IsEmpty() {
+			slots = append(slots, &nbt.Compound{
+				map[string]nbt.ITag{
+					"Slot":   &nbt.Byte{int8(i)},
+					"id":     &nbt.Short{int16(slot.ItemTypeId)},
+					"Count":  &nbt.Byte{int8(slot.Count)},
+					"Damage": &nbt.Short{int16(slot.Data)},
+				},
+			})
+		}
+	}
+
+	return &nbt.List{nbt.TagCompound, slots}
+}
+
+// ReadCraftingNbt reads the contents of the crafting grid as written by
+// WriteCraftingNbt.
+func (w *PlayerInventory) ReadCraftingNbt(tag nbt.ITag) (err os.Error) {
+	list, ok := tag.(*nbt.List)
+	if !ok {
+		return os.NewError("Bad crafting inventory - not a list")
+	}
+
+	for _, slotTag := range list.Value {
+		var slotIdTag *nbt.Byte
+		if slotIdTag, ok = slotTag.Lookup("Slot").(*nbt.Byte); !ok {
+			return os.NewError("Slot ID not a byte")
+		}
+		if err = w.crafting.ReadNbtSlot(slotTag, SlotId(slotIdTag.Value)); err != nil {
+			return
+		}
+	}
+
+	return
+}
@@ -33,7 +33,7 @@ type IWindow interface {
 // updates from changes to inventories viewed inside a window. Typically
 // *player.Player implements this.
 type IWindowViewer interface {
-	TransmitPacket(packet []byte)
+	TransmitPacket(packet []byte, priority proto.PacketPriority)
 }
 
 // inventoryView provides a single mapping between a window view onto an
@@ -66,13 +66,13 @@ func (iv *inventoryView) Finalize() {
 func (iv *inventoryView) SlotUpdate(slot *gamerules.Slot, slotId SlotId) {
 	buf := new(bytes.Buffer)
 	slot.SendUpdate(buf, iv.window.windowId, iv.startSlot+slotId)
-	iv.window.viewer.TransmitPacket(buf.Bytes())
+	iv.window.viewer.TransmitPacket(buf.Bytes(), proto.PacketPriorityNormal)
 }
 
 func (iv *inventoryView) ProgressUpdate(prgBarId PrgBarId, value PrgBarValue) {
 	buf := new(bytes.Buffer)
 	proto.WriteWindowProgressBar(buf, iv.window.windowId, prgBarId, value)
-	iv.window.viewer.TransmitPacket(buf.Bytes())
+	iv.window.viewer.TransmitPacket(buf.Bytes(), proto.PacketPriorityNormal)
 }
 
 // Window represents the common base behaviour of an inventory window. It acts
@@ -127,7 +127,7 @@ func (w *Window) Finalize(sendClosePacket bool) {
 	if sendClosePacket {
 		buf := new(bytes.Buffer)
 		proto.WriteWindowClose(buf, w.windowId)
-		w.viewer.TransmitPacket(buf.Bytes())
+		w.viewer.TransmitPacket(buf.Bytes(), proto.PacketPriorityNormal)
 	}
 }
 
@@ -0,0 +1,56 @@
+package chunkymonkey
+
+import (
+	"os"
+	"path"
+
+	. "chunkymonkey/entity"
+	"chunkymonkey/shardlookup"
+	"chunkymonkey/shardserver"
+	"chunkymonkey/storage"
+	. "chunkymonkey/types"
+	"chunkymonkey/worldstore"
+)
+
+// world is one of the worlds hosted by the game. Each world has its own
+// directory, chunk generator (seeded from its level data) and shards. The time
+// and weather are shared by all worlds.
+type world struct {
+	name          string
+	store         *worldstore.WorldStore
+	shardManagers map[DimensionId]shardserver.IShardManager
+}
+
+// worldName returns the name of the world stored at worldPath, which is the
+// name of its directory.
+func worldName(worldPath string) string {
+	return path.Base(path.Clean(worldPath))
+}
+
+// loadWorld loads the world at worldPath, and creates managers for the shards
+// in each of its dimensions. See NewGame for how lookup and chunkServerAddr
+// choose where the shards are hosted.
+func loadWorld(worldPath string, store storage.IStorage, entityMgr *EntityManager, lookup shardlookup.IShardLookup, chunkServerAddr string) (w *world, err os.Error) {
+	worldStore, err := worldstore.LoadWorldStore(worldPath, store)
+	if err != nil {
+		return
+	}
+
+	w = &world{
+		name:          worldName(worldPath),
+		store:         worldStore,
+		shardManagers: make(map[DimensionId]shardserver.IShardManager),
+	}
+
+	for _, dimension := range []DimensionId{DimensionNormal, DimensionNether} {
+		if lookup != nil {
+			w.shardManagers[dimension] = shardserver.NewLookupShardManager(lookup, dimension, "", nil)
+		} else if chunkServerAddr == "" {
+			w.shardManagers[dimension] = shardserver.NewLocalShardManager(worldStore.ChunkStoreFor(dimension), entityMgr)
+		} else if w.shardManagers[dimension], err = shardserver.NewRemoteShardManager(chunkServerAddr, dimension); err != nil {
+			return nil, err
+		}
+	}
+
+	return
+}
@@ -9,27 +9,52 @@ import (
 	"os"
 	"path"
 	"rand"
+	"sync"
 	"time"
 
 	"chunkymonkey/chunkstore"
 	"chunkymonkey/generation"
+	"chunkymonkey/storage"
 	. "chunkymonkey/types"
 	"chunkymonkey/util"
 	"nbt"
 )
 
+// WeatherData is the weather state persisted in level.dat. RainTime and
+// ThunderTime are the number of ticks until the respective state next toggles.
+type WeatherData struct {
+	Raining     bool
+	RainTime    int32
+	Thundering  bool
+	ThunderTime int32
+}
+
 type WorldStore struct {
 	WorldPath string
 
-	Seed int64
-	Time Ticks
+	Seed     int64
+	Time     Ticks
+	Weather  WeatherData
+	GameMode GameMode // The mode that players in the world play in.
+
+	LevelData        nbt.ITag
+	ChunkStore       chunkstore.IChunkStore
+	NetherChunkStore chunkstore.IChunkStore
+	SpawnPosition    BlockXyz
 
-	LevelData     nbt.ITag
-	ChunkStore    chunkstore.IChunkStore
-	SpawnPosition BlockXyz
+	// Storage, if not nil, is read for chunks and player data before the
+	// world's own files, and player data is written to it instead of them.
+	Storage storage.IStorage
+
+	// playerVersionsLock guards playerVersions, which holds the version of
+	// each player's data as read from Storage, to be replaced when written.
+	playerVersionsLock sync.Mutex
+	playerVersions     map[string]storage.Version
 }
 
-func LoadWorldStore(worldPath string) (world *WorldStore, err os.Error) {
+// LoadWorldStore loads the world at worldPath. If store is not nil, chunks and
+// player data are read from it in preference to the world's files.
+func LoadWorldStore(worldPath string, store storage.IStorage) (world *WorldStore, err os.Error) {
 	levelData, err := loadLevelData(worldPath)
 	if err != nil {
 		return
@@ -56,12 +81,26 @@ func LoadWorldStore(worldPath string) (world *WorldStore, err os.Error) {
 		timeTicks = Ticks(timeTag.Value)
 	}
 
-	var chunkStores []chunkstore.IChunkStore
-	persistantChunkStore, err := chunkstore.ChunkStoreForLevel(worldPath, levelData, DimensionNormal)
-	if err != nil {
-		return
+	var weather WeatherData
+	if raining, ok := levelData.Lookup("Data/raining").(*nbt.Byte); ok {
+		weather.Raining = raining.Value != 0
+	}
+	if rainTime, ok := levelData.Lookup("Data/rainTime").(*nbt.Int); ok {
+		weather.RainTime = rainTime.Value
+	}
+	if thundering, ok := levelData.Lookup("Data/thundering").(*nbt.Byte); ok {
+		weather.Thundering = thundering.Value != 0
+	}
+	if thunderTime, ok := levelData.Lookup("Data/thunderTime").(*nbt.Int); ok {
+		weather.ThunderTime = thunderTime.Value
+	}
+
+	// Game types that this server doesn't know of are played in survival
+	// mode.
+	gameMode := GameModeSurvival
+	if gameType, ok := levelData.Lookup("Data/GameType").(*nbt.Int); ok && GameMode(gameType.Value) == GameModeCreative {
+		gameMode = GameModeCreative
 	}
-	chunkStores = append(chunkStores, chunkstore.NewChunkService(persistantChunkStore))
 
 	var seed int64
 	if seedNbt, ok := levelData.Lookup("Data/RandomSeed").(*nbt.Long); ok {
@@ -70,26 +109,72 @@ func LoadWorldStore(worldPath string) (world *WorldStore, err os.Error) {
 		seed = rand.NewSource(time.Seconds()).Int63()
 	}
 
-	chunkStores = append(chunkStores, chunkstore.NewChunkService(generation.NewTestGenerator(seed)))
+	chunkStore, err := newChunkStore(worldPath, levelData, store, DimensionNormal, generation.NewTestGenerator(seed))
+	if err != nil {
+		return
+	}
 
-	for _, store := range chunkStores {
-		go store.Serve()
+	netherChunkStore, err := newChunkStore(worldPath, levelData, store, DimensionNether, generation.NewNetherGenerator(seed))
+	if err != nil {
+		return
 	}
 
 	world = &WorldStore{
-		WorldPath:     worldPath,
-		Seed:          seed,
-		Time:          timeTicks,
-		LevelData:     levelData,
-		ChunkStore:    chunkstore.NewChunkService(chunkstore.NewMultiStore(chunkStores)),
-		SpawnPosition: spawnPosition,
+		WorldPath:        worldPath,
+		Seed:             seed,
+		Time:             timeTicks,
+		Weather:          weather,
+		GameMode:         gameMode,
+		LevelData:        levelData,
+		ChunkStore:       chunkStore,
+		NetherChunkStore: netherChunkStore,
+		SpawnPosition:    spawnPosition,
+		Storage:          store,
+		playerVersions:   make(map[string]storage.Version),
+	}
+
+	return
+}
+
+// newChunkStore creates a chunk store for a dimension that reads chunks from
+// the storage service (if any) and the world, and falls back to the generator
+// for chunks that don't exist yet.
+func newChunkStore(worldPath string, levelData nbt.ITag, storageStore storage.IStorage, dimension DimensionId, generator chunkstore.IChunkStoreForeground) (store chunkstore.IChunkStore, err os.Error) {
+	var chunkStores []chunkstore.IChunkStore
+	if storageStore != nil {
+		chunkStores = append(chunkStores, chunkstore.NewChunkService(chunkstore.NewChunkStoreStorage(storageStore, dimension)))
+	}
+
+	persistantChunkStore, err := chunkstore.ChunkStoreForLevel(worldPath, levelData, dimension)
+	if err != nil {
+		return
+	}
+	chunkStores = append(chunkStores, chunkstore.NewChunkService(persistantChunkStore))
+
+	chunkStores = append(chunkStores, chunkstore.NewChunkService(generator))
+
+	for _, s := range chunkStores {
+		go s.Serve()
 	}
 
-	go world.ChunkStore.Serve()
+	store = chunkstore.NewChunkService(chunkstore.NewMultiStore(chunkStores))
+	go store.Serve()
 
 	return
 }
 
+// ChunkStoreFor returns the chunk store for the given dimension, or nil if the
+// dimension isn't supported.
+func (world *WorldStore) ChunkStoreFor(dimension DimensionId) chunkstore.IChunkStore {
+	switch dimension {
+	case DimensionNormal:
+		return world.ChunkStore
+	case DimensionNether:
+		return world.NetherChunkStore
+	}
+	return nil
+}
+
 func loadLevelData(worldPath string) (levelData nbt.ITag, err os.Error) {
 	filename := path.Join(worldPath, "level.dat")
 	file, err := os.Open(filename)
@@ -109,6 +194,57 @@ func loadLevelData(worldPath string) (levelData nbt.ITag, err os.Error) {
 	return
 }
 
+// WriteLevelData updates the time and weather in the level data, and writes
+// it to level.dat.
+func (world *WorldStore) WriteLevelData(time Ticks, weather *WeatherData) (err os.Error) {
+	data, ok := world.LevelData.Lookup("Data").(*nbt.Compound)
+	if !ok {
+		return BadType("Data")
+	}
+
+	data.Tags["Time"] = &nbt.Long{int64(time)}
+	data.Tags["raining"] = &nbt.Byte{boolToNbtByte(weather.Raining)}
+	data.Tags["rainTime"] = &nbt.Int{weather.RainTime}
+	data.Tags["thundering"] = &nbt.Byte{boolToNbtByte(weather.Thundering)}
+	data.Tags["thunderTime"] = &nbt.Int{weather.ThunderTime}
+
+	return writeLevelData(world.WorldPath, world.LevelData)
+}
+
+// writeLevelData writes level.dat via a temporary file, so that a failure part
+// way through doesn't leave a corrupt level.dat behind.
+func writeLevelData(worldPath string, levelData nbt.ITag) (err os.Error) {
+	filename := path.Join(worldPath, "level.dat")
+	tmpFilename := filename + ".tmp"
+
+	file, err := os.OpenFile(tmpFilename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+	if err != nil {
+		return
+	}
+
+	gzipWriter, err := gzip.NewWriter(file)
+	if err != nil {
+		file.Close()
+		return
+	}
+
+	err = nbt.Write(gzipWriter, levelData)
+	gzipWriter.Close()
+	file.Close()
+	if err != nil {
+		return
+	}
+
+	return os.Rename(tmpFilename, filename)
+}
+
+func boolToNbtByte(b bool) int8 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
 // NOTE: ChunkStoreForDimension shouldn't really be used in the server just
 // yet.
 func (world *WorldStore) ChunkStoreForDimension(dimension DimensionId) (store chunkstore.IChunkStore, err os.Error) {
@@ -121,7 +257,26 @@ func (world *WorldStore) ChunkStoreForDimension(dimension DimensionId) (store ch
 	return
 }
 
+// PlayerData returns the stored data for the named player, or nil if there is
+// none.
 func (world *WorldStore) PlayerData(user string) (playerData nbt.ITag, err os.Error) {
+	if world.Storage != nil {
+		var version storage.Version
+		if playerData, version, err = world.Storage.GetPlayer(user); err != nil {
+			return
+		}
+
+		world.playerVersionsLock.Lock()
+		world.playerVersions[user] = version
+		world.playerVersionsLock.Unlock()
+
+		if playerData != nil {
+			return
+		}
+		// Fall back to data in the world's files, for players that have not
+		// been stored yet.
+	}
+
 	file, err := os.Open(path.Join(world.WorldPath, "players", user+".dat"))
 	if err != nil {
 		if errno, ok := util.Errno(err); ok && errno == os.ENOENT {
@@ -144,7 +299,26 @@ func (world *WorldStore) PlayerData(user string) (playerData nbt.ITag, err os.Er
 	return
 }
 
+// WritePlayerData stores the data for the named player. When writing to
+// Storage, it fails if the data has been written elsewhere since PlayerData
+// read it.
 func (world *WorldStore) WritePlayerData(user string, data *nbt.Compound) (err os.Error) {
+	if world.Storage != nil {
+		world.playerVersionsLock.Lock()
+		defer world.playerVersionsLock.Unlock()
+
+		expected, ok := world.playerVersions[user]
+		if !ok {
+			expected = storage.AnyVersion
+		}
+		var version storage.Version
+		if version, err = world.Storage.PutPlayer(user, expected, data); err != nil {
+			return
+		}
+		world.playerVersions[user] = version
+		return
+	}
+
 	playerDir := path.Join(world.WorldPath, "players")
 	if err = os.MkdirAll(playerDir, 0777); err != nil {
 		return
@@ -200,22 +374,7 @@ func CreateWorld(worldPath string) (err os.Error) {
 		return
 	}
 
-	filename := path.Join(worldPath, "level.dat")
-	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY, 0666)
-	if err != nil {
-		return err
-	}
-
-	gzipWriter, err := gzip.NewWriter(file)
-	if err != nil {
-		return err
-	}
-
-	err = nbt.Write(gzipWriter, data)
-	gzipWriter.Close()
-	file.Close()
-
-	return nil
+	return writeLevelData(worldPath, data)
 }
 
 
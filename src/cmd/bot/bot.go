@@ -0,0 +1,234 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"rand"
+	"sync"
+	"time"
+
+	"chunkymonkey/client"
+	"chunkymonkey/proto"
+	. "chunkymonkey/types"
+)
+
+var numBots = flag.Int(
+	"n", 1,
+	"The number of bots to run.")
+
+var namePrefix = flag.String(
+	"name", "bot",
+	"Bots log in as this followed by their number.")
+
+var version = flag.Int(
+	"version", proto.ProtocolVersionBeta18,
+	"The protocol version that the bots speak.")
+
+var staggerMs = flag.Int(
+	"stagger_ms", 200,
+	"Milliseconds between each bot connecting.")
+
+var wanderRadius = flag.Float64(
+	"radius", 16,
+	"How far from where they spawn that the bots wander, in blocks.")
+
+var digChance = flag.Float64(
+	"dig_chance", 0.2,
+	"The chance of a bot digging out and replacing a block after each walk.")
+
+var chatChance = flag.Float64(
+	"chat_chance", 0.05,
+	"The chance of a bot saying something after each walk.")
+
+var duration = flag.Int(
+	"duration", 0,
+	"Seconds to run the bots for, or 0 to run until interrupted.")
+
+var reportInterval = flag.Int(
+	"report_interval", 10,
+	"Seconds between logging the bots' statistics.")
+
+const (
+	spawnTimeoutNs   = 30 * NanosecondsInSecond
+	reconnectDelayNs = 5 * NanosecondsInSecond
+)
+
+func usage() {
+	os.Stderr.WriteString("usage: " + os.Args[0] + " [flags] server:port\n")
+	flag.PrintDefaults()
+}
+
+// botStats totals what the bots have done, for soak testing.
+type botStats struct {
+	lock          sync.Mutex
+	connected     int
+	connects      int
+	disconnects   int
+	walks         int
+	packets       int64
+	bytesReceived int64
+}
+
+func (stats *botStats) update(fn func(stats *botStats)) {
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+	fn(stats)
+}
+
+func (stats *botStats) report(intervalNs int64) {
+	var lastPackets, lastBytes int64
+	for {
+		time.Sleep(intervalNs)
+
+		stats.lock.Lock()
+		log.Printf(
+			"%d bots connected (%d connects, %d disconnects), %d walks, %d packets (%.0f/s), %d bytes (%.0f/s) received",
+			stats.connected, stats.connects, stats.disconnects, stats.walks,
+			stats.packets, float64(stats.packets-lastPackets)*NanosecondsInSecond/float64(intervalNs),
+			stats.bytesReceived, float64(stats.bytesReceived-lastBytes)*NanosecondsInSecond/float64(intervalNs))
+		lastPackets, lastBytes = stats.packets, stats.bytesReceived
+		stats.lock.Unlock()
+	}
+}
+
+// bot is a player that wanders around near where it spawns, now and then
+// digging out a block and putting it back, and chatting.
+type bot struct {
+	name  string
+	addr  string
+	rand  *rand.Rand
+	stats *botStats
+}
+
+// run connects the bot to the server, reconnecting whenever it is
+// disconnected.
+func (b *bot) run() {
+	for {
+		err := b.session()
+		log.Printf("%s: %v", b.name, err)
+		time.Sleep(reconnectDelayNs)
+	}
+}
+
+// session runs the bot for a single connection to the server, and returns
+// why it ended.
+func (b *bot) session() (err os.Error) {
+	c, err := client.Dial(b.addr, b.name, int32(*version))
+	if err != nil {
+		return
+	}
+	defer c.Close()
+
+	if err = c.WaitSpawn(spawnTimeoutNs); err != nil {
+		return
+	}
+
+	b.stats.update(func(stats *botStats) {
+		stats.connected++
+		stats.connects++
+	})
+	var last client.Stats
+	defer func() {
+		received := c.Stats()
+		b.stats.update(func(stats *botStats) {
+			stats.connected--
+			stats.disconnects++
+			stats.packets += received.Packets - last.Packets
+			stats.bytesReceived += received.Bytes - last.Bytes
+		})
+	}()
+
+	home, _ := c.Position()
+	for {
+		if err = c.WalkTo(b.wanderTarget(&home)); err != nil {
+			return
+		}
+
+		received := c.Stats()
+		b.stats.update(func(stats *botStats) {
+			stats.walks++
+			stats.packets += received.Packets - last.Packets
+			stats.bytesReceived += received.Bytes - last.Bytes
+		})
+		last = received
+
+		if b.rand.Float64() < *digChance {
+			if err = b.digAndReplace(c); err != nil {
+				return
+			}
+		}
+		if b.rand.Float64() < *chatChance {
+			if err = c.Chat(fmt.Sprintf("%s here, %d entities in view", b.name, len(c.Entities()))); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+func (b *bot) wanderTarget(home *AbsXyz) AbsXyz {
+	radius := *wanderRadius
+	return AbsXyz{
+		home.X + AbsCoord((b.rand.Float64()*2-1)*radius),
+		home.Y,
+		home.Z + AbsCoord((b.rand.Float64()*2-1)*radius),
+	}
+}
+
+// digAndReplace digs out the block that the bot is standing on, and puts the
+// block that it is holding in its place.
+func (b *bot) digAndReplace(c *client.Client) os.Error {
+	position, _ := c.Position()
+	below := *position.ToBlockXyz()
+	below.Y--
+	if blockId, _, ok := c.BlockAt(&below); !ok || blockId == BlockIdAir {
+		return nil
+	}
+
+	if err := c.Dig(below, FaceTop); err != nil {
+		return err
+	}
+
+	under := below
+	under.Y--
+	if err := c.Place(under, FaceTop); err != nil && err != client.ErrEmptyHand {
+		return err
+	}
+	return nil
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *numBots < 1 || *staggerMs < 0 || *reportInterval < 1 {
+		log.Print("-n and -report_interval must be positive, and -stagger_ms not negative")
+		os.Exit(1)
+	}
+
+	stats := &botStats{}
+	go stats.report(int64(*reportInterval) * NanosecondsInSecond)
+
+	for i := 0; i < *numBots; i++ {
+		b := &bot{
+			name:  fmt.Sprintf("%s%d", *namePrefix, i),
+			addr:  flag.Arg(0),
+			rand:  rand.New(rand.NewSource(time.Nanoseconds() + int64(i))),
+			stats: stats,
+		}
+		go b.run()
+		time.Sleep(int64(*staggerMs) * 1e6)
+	}
+
+	if *duration > 0 {
+		time.Sleep(int64(*duration) * NanosecondsInSecond)
+	} else {
+		select {}
+	}
+}
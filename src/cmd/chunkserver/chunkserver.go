@@ -0,0 +1,246 @@
+package main
+
+import (
+	_ "expvar"
+	"flag"
+	"fmt"
+	"http"
+	_ "http/pprof"
+	"log"
+	"net"
+	"os"
+	"path"
+	"strconv"
+
+	"chunkymonkey/entity"
+	"chunkymonkey/gamerules"
+	"chunkymonkey/shardlookup"
+	"chunkymonkey/shardserver"
+	"chunkymonkey/storage"
+	. "chunkymonkey/types"
+	"chunkymonkey/worldstore"
+)
+
+var addr = flag.String(
+	"addr", ":25567",
+	"Serves shards to frontend servers on the given address:port.")
+
+var httpAddr = flag.String(
+	"http_addr", ":25568",
+	"Serves HTTP diagnostics on the given address:port.")
+
+var lookupServerAddr = flag.String(
+	"lookup_server", "",
+	"Registers with the shard lookup server at the given address:port, and "+
+		"only hosts the shards that it assigns to this server.")
+
+var advertiseAddr = flag.String(
+	"advertise_addr", "",
+	"The address:port that other servers connect to this server on, as given "+
+		"to the lookup server. Defaults to the value of -addr.")
+
+var heartbeatSecs = flag.Int(
+	"heartbeat_secs", 10,
+	"Seconds between heartbeats sent to the lookup server. This must be "+
+		"less than -lease_secs.")
+
+var leaseSecs = flag.Int(
+	"lease_secs", 30,
+	"Seconds that the lookup server's leases last, as given by its "+
+		"-lease_secs flag. Shards are suspended when no heartbeat has been "+
+		"answered for this long, as they may have been assigned elsewhere.")
+
+var storageServerAddr = flag.String(
+	"storage_server", "",
+	"Reads chunks via the storage server at the given address:port, in "+
+		"preference to the world's files.")
+
+var firstEntityId = flag.Int(
+	"first_entity_id", 1<<30,
+	"The lowest EntityId given to entities created by this server. It must not "+
+		"overlap with the EntityIds given out by frontend servers (below 1<<30 "+
+		"by default) or other chunk servers.")
+
+var lastEntityId = flag.Int(
+	"last_entity_id", 1<<31-1,
+	"The highest EntityId given to entities created by this server.")
+
+var shardSize = flag.Int(
+	"shard_size", DefaultShardSize,
+	"The width of each shard, in chunks. All servers sharing a world must "+
+		"use the same size.")
+
+var maxCatchUpTicks = flag.Int(
+	"max_catchup_ticks", 0,
+	"The most extra ticks that a shard runs at once to catch up when it falls "+
+		"behind. Ticks that it falls further behind by are skipped.")
+
+var blockDefs = flag.String(
+	"blocks", "blocks.json",
+	"The JSON file containing block type definitions.")
+
+var itemDefs = flag.String(
+	"items", "items.json",
+	"The JSON file containing item type definitions.")
+
+var recipeDefs = flag.String(
+	"recipes", "recipes.json",
+	"The JSON file containing recipe definitions.")
+
+var furnaceDefs = flag.String(
+	"furnace", "furnace.json",
+	"The JSON file containing furnace fuel and reaction definitions.")
+
+var userDefs = flag.String(
+	"users", "users.json",
+	"The JSON file container user permissions.")
+
+var groupDefs = flag.String(
+	"groups", "groups.json",
+	"The JSON file containing group permissions.")
+
+func usage() {
+	os.Stderr.WriteString("usage: " + os.Args[0] + " [flags] <world>\n")
+	flag.PrintDefaults()
+}
+
+func startHttpServer(addr string) (err os.Error) {
+	httpPort, err := net.Listen("tcp", addr)
+	if err != nil {
+		return
+	}
+	go http.Serve(httpPort, nil)
+	return
+}
+
+// serveHandoff hands off a shard to another chunk server, given its dimension,
+// shard coordinates and the address of the server, e.g
+// "/handoff?dimension=0&x=1&z=-2&to=chunkserver2:25567".
+func serveHandoff(handoffMgrs map[DimensionId]*shardserver.LookupShardManager, w http.ResponseWriter, r *http.Request) {
+	dimension, dErr := strconv.Atoi(r.FormValue("dimension"))
+	x, xErr := strconv.Atoi(r.FormValue("x"))
+	z, zErr := strconv.Atoi(r.FormValue("z"))
+	serverAddr := r.FormValue("to")
+	if dErr != nil || xErr != nil || zErr != nil || serverAddr == "" {
+		http.Error(w, "expected dimension, x, z and to parameters", http.StatusBadRequest)
+		return
+	}
+
+	mgr, ok := handoffMgrs[DimensionId(dimension)]
+	if !ok {
+		http.Error(w, "unknown dimension", http.StatusBadRequest)
+		return
+	}
+
+	shardLoc := ShardXz{ShardCoord(x), ShardCoord(z)}
+	if err := mgr.HandOff(shardLoc, serverAddr); err != nil {
+		http.Error(w, err.String(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Handed off shard %v in dimension %d to %s", shardLoc, dimension, serverAddr)
+	fmt.Fprintf(w, "Handed off shard %v to %s\n", shardLoc, serverAddr)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if *shardSize <= 0 {
+		log.Print("-shard_size must be positive")
+		os.Exit(1)
+	}
+	if *firstEntityId > *lastEntityId {
+		log.Print("-first_entity_id must not be greater than -last_entity_id")
+		os.Exit(1)
+	}
+	if *heartbeatSecs <= 0 || *heartbeatSecs >= *leaseSecs {
+		log.Print("-heartbeat_secs must be positive and less than -lease_secs")
+		os.Exit(1)
+	}
+	if *maxCatchUpTicks < 0 {
+		log.Print("-max_catchup_ticks must not be negative")
+		os.Exit(1)
+	}
+	ShardSize = ChunkCoord(*shardSize)
+	shardserver.MaxCatchUpTicks = *maxCatchUpTicks
+
+	err := gamerules.LoadGameRules(*blockDefs, *itemDefs, *recipeDefs, *furnaceDefs, *userDefs, *groupDefs)
+	if err != nil {
+		log.Print("Error loading game rules: ", err)
+		os.Exit(1)
+	}
+
+	var store storage.IStorage
+	if *storageServerAddr != "" {
+		store = storage.NewRemoteStorage(*storageServerAddr)
+	}
+
+	worldStore, err := worldstore.LoadWorldStore(flag.Arg(0), store)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	entityMgr := new(entity.EntityManager)
+	entityMgr.InitRange(EntityId(*firstEntityId), EntityId(*lastEntityId))
+
+	var lookup shardlookup.IShardLookup
+	if *lookupServerAddr != "" {
+		lookup = shardlookup.NewRemoteLookup(*lookupServerAddr)
+		if *advertiseAddr == "" {
+			*advertiseAddr = *addr
+		}
+	}
+
+	managers := make(map[DimensionId]shardserver.IShardManager)
+	handoffMgrs := make(map[DimensionId]*shardserver.LookupShardManager)
+	for _, dimension := range []DimensionId{DimensionNormal, DimensionNether} {
+		mgr := shardserver.NewLocalShardManager(worldStore.ChunkStoreFor(dimension), entityMgr)
+		if lookup != nil {
+			// Shards connect to neighbouring shards on other servers, and can
+			// be handed off to them.
+			lookupMgr := shardserver.NewLookupShardManager(lookup, dimension, *advertiseAddr, mgr)
+			mgr.SetShardConnecter(lookupMgr)
+			handoffMgrs[dimension] = lookupMgr
+		}
+		managers[dimension] = mgr
+	}
+	shardserver.PublishShardStats(map[string]map[DimensionId]shardserver.IShardManager{
+		path.Base(path.Clean(flag.Arg(0))): managers,
+	})
+
+	if lookup != nil {
+		http.HandleFunc("/handoff", func(w http.ResponseWriter, r *http.Request) {
+			serveHandoff(handoffMgrs, w, r)
+		})
+	}
+
+	if err = startHttpServer(*httpAddr); err != nil {
+		log.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatalf("Listen: %v", err)
+	}
+	log.Print("Listening on ", *addr)
+
+	if lookup != nil {
+		lookupMgrs := make([]*shardserver.LookupShardManager, 0, len(handoffMgrs))
+		for _, lookupMgr := range handoffMgrs {
+			lookupMgrs = append(lookupMgrs, lookupMgr)
+		}
+		go shardserver.KeepLease(lookup, *advertiseAddr,
+			int64(*heartbeatSecs)*NanosecondsInSecond,
+			int64(*leaseSecs)*NanosecondsInSecond,
+			lookupMgrs)
+	}
+
+	err = shardserver.NewShardServer(managers).Serve(listener)
+	log.Fatalf("Serve: %v", err)
+}
@@ -1,16 +1,22 @@
 package main
 
 import (
+	"crypto/subtle"
 	_ "expvar"
 	"flag"
+	"fmt"
 	"http"
 	_ "http/pprof"
 	"log"
 	"net"
 	"os"
+	"strings"
 
 	"chunkymonkey"
 	"chunkymonkey/gamerules"
+	"chunkymonkey/player"
+	"chunkymonkey/shardserver"
+	"chunkymonkey/types"
 	"chunkymonkey/worldstore"
 )
 
@@ -22,6 +28,73 @@ var httpAddr = flag.String(
 	"http_addr", ":25566",
 	"Serves HTTP diagnostics on the given address:port.")
 
+var chunkServerAddr = flag.String(
+	"chunk_server", "",
+	"Hosts chunks on the chunk server at the given address:port, instead of in this process.")
+
+var lookupServerAddr = flag.String(
+	"lookup_server", "",
+	"Hosts chunks on the chunk servers found via the shard lookup server at "+
+		"the given address:port, instead of in this process.")
+
+var storageServerAddr = flag.String(
+	"storage_server", "",
+	"Reads chunks and reads and writes player data via the storage server at "+
+		"the given address:port, in preference to the world's files.")
+
+var extraWorlds = flag.String(
+	"worlds", "",
+	"A comma separated list of the directories of more worlds to host, "+
+		"besides the default world. Each world is named after its directory, "+
+		"and is created if it doesn't exist. Only supported when hosting "+
+		"chunks in this process.")
+
+var shardSize = flag.Int(
+	"shard_size", types.DefaultShardSize,
+	"The width of each shard, in chunks. All servers sharing a world must "+
+		"use the same size.")
+
+var transferAddr = flag.String(
+	"transfer_addr", "",
+	"If set, accepts players transferred from other frontends on the given "+
+		"address:port. Requires -transfer_secret.")
+
+var transferSecret = flag.String(
+	"transfer_secret", "",
+	"The secret shared by frontends that transfer players between each "+
+		"other. If set, players are transferred via the /transfer and /drain "+
+		"HTTP handlers, which must be given it as the secret parameter.")
+
+var firstEntityId = flag.Int(
+	"first_entity_id", 1,
+	"The lowest EntityId given to players and other entities created by this "+
+		"server. The range must not overlap with those of chunk servers or "+
+		"other frontends.")
+
+var lastEntityId = flag.Int(
+	"last_entity_id", 1<<30-1,
+	"The highest EntityId given to players and other entities created by "+
+		"this server.")
+
+var maxCatchUpTicks = flag.Int(
+	"max_catchup_ticks", 0,
+	"The most extra ticks that a shard runs at once to catch up when it falls "+
+		"behind. Ticks that it falls further behind by are skipped.")
+
+var chunkCompressWorkers = flag.Int(
+	"chunk_compress_workers", 2,
+	"The number of goroutines that compress chunks to send to clients.")
+
+var idleTimeout = flag.Int(
+	"idle_timeout", 30,
+	"Disconnects clients that send nothing for this many seconds. Zero "+
+		"disables the timeout.")
+
+var writeTimeout = flag.Int(
+	"write_timeout", 30,
+	"Disconnects clients that accept no data for this many seconds. Zero "+
+		"disables the timeout.")
+
 var blockDefs = flag.String(
 	"blocks", "blocks.json",
 	"The JSON file containing block type definitions.")
@@ -55,6 +128,45 @@ func usage() {
 	flag.PrintDefaults()
 }
 
+// handleTransfers registers HTTP handlers that transfer players to another
+// frontend: "/transfer?player=<name>&to=<addr>&secret=<secret>" transfers one
+// player, and "/drain?to=<addr>&secret=<secret>" transfers every player and
+// stops further logins.
+func handleTransfers(game *chunkymonkey.Game, secret string) {
+	authorized := func(w http.ResponseWriter, r *http.Request) bool {
+		if subtle.ConstantTimeCompare([]byte(r.FormValue("secret")), []byte(secret)) != 1 {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, "Wrong secret\n")
+			return false
+		}
+		return true
+	}
+
+	http.HandleFunc("/transfer", func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(w, r) {
+			return
+		}
+		name, to := r.FormValue("player"), r.FormValue("to")
+		if err := game.TransferPlayer(name, to); err != nil {
+			fmt.Fprintf(w, "Failed to transfer %s to %s: %s\n", name, to, err.String())
+			return
+		}
+		fmt.Fprintf(w, "Transferred %s to %s\n", name, to)
+	})
+
+	http.HandleFunc("/drain", func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(w, r) {
+			return
+		}
+		to := r.FormValue("to")
+		transferred, err := game.DrainPlayers(to)
+		fmt.Fprintf(w, "Transferred %d players to %s\n", transferred, to)
+		if err != nil {
+			fmt.Fprintf(w, "Some players could not be transferred: %s\n", err.String())
+		}
+	})
+}
+
 func startHttpServer(addr string) (err os.Error) {
 	httpPort, err := net.Listen("tcp", addr)
 	if err != nil {
@@ -64,6 +176,26 @@ func startHttpServer(addr string) (err os.Error) {
 	return
 }
 
+// loadOrCreateWorld checks that there is a world at worldPath, and creates
+// one if there isn't.
+func loadOrCreateWorld(worldPath string) (err os.Error) {
+	fi, err := os.Stat(worldPath)
+	if err != nil {
+		log.Printf("Could not load world from directory %v: %v", worldPath, err)
+		log.Printf("Creating a new world in directory %v", worldPath)
+		err = worldstore.CreateWorld(worldPath)
+	}
+	if err != nil {
+		return fmt.Errorf("Error creating new world: %v", err)
+	}
+
+	if fi, err = os.Stat(worldPath); err != nil || !fi.IsDirectory() {
+		return fmt.Errorf("Error loading world %v: Not a directory", worldPath)
+	}
+
+	return nil
+}
+
 func main() {
 	var err os.Error
 
@@ -75,35 +207,67 @@ func main() {
 		os.Exit(1)
 	}
 
+	if *shardSize <= 0 {
+		log.Print("-shard_size must be positive")
+		os.Exit(1)
+	}
+	if *firstEntityId > *lastEntityId {
+		log.Print("-first_entity_id must not be greater than -last_entity_id")
+		os.Exit(1)
+	}
+	if *maxCatchUpTicks < 0 {
+		log.Print("-max_catchup_ticks must not be negative")
+		os.Exit(1)
+	}
+	if *chunkCompressWorkers < 1 {
+		log.Print("-chunk_compress_workers must be at least 1")
+		os.Exit(1)
+	}
+	if *transferAddr != "" && *transferSecret == "" {
+		log.Print("-transfer_addr requires -transfer_secret")
+		os.Exit(1)
+	}
+	if *idleTimeout < 0 || *writeTimeout < 0 {
+		log.Print("-idle_timeout and -write_timeout must not be negative")
+		os.Exit(1)
+	}
+	types.ShardSize = types.ChunkCoord(*shardSize)
+	chunkymonkey.FirstEntityId = types.EntityId(*firstEntityId)
+	chunkymonkey.LastEntityId = types.EntityId(*lastEntityId)
+	shardserver.MaxCatchUpTicks = *maxCatchUpTicks
+	shardserver.ChunkCompressWorkers = *chunkCompressWorkers
+	player.IdleTimeout = int64(*idleTimeout) * types.NanosecondsInSecond
+	player.WriteTimeout = int64(*writeTimeout) * types.NanosecondsInSecond
+	player.TransferSecret = *transferSecret
+
 	err = gamerules.LoadGameRules(*blockDefs, *itemDefs, *recipeDefs, *furnaceDefs, *userDefs, *groupDefs)
 	if err != nil {
 		log.Print("Error loading game rules: ", err)
 		os.Exit(1)
 	}
 
-	worldPath := flag.Arg(0)
-	fi, err := os.Stat(worldPath)
-	if err != nil {
-		log.Printf("Could not load world from directory %v: %v", worldPath, err)
-		log.Printf("Creating a new world in directory %v", worldPath)
-		err = worldstore.CreateWorld(worldPath)
+	worldPaths := []string{flag.Arg(0)}
+	if *extraWorlds != "" {
+		worldPaths = append(worldPaths, strings.Split(*extraWorlds, ",")...)
 	}
-	if err != nil {
-		log.Printf("Error creating new world: %v", err)
-	} else {
-		fi, err = os.Stat(worldPath)
-	}
-
-	if fi == nil || !fi.IsDirectory() {
-		log.Printf("Error loading world %v: Not a directory", worldPath)
-		os.Exit(1)
+	for _, worldPath := range worldPaths {
+		if err = loadOrCreateWorld(worldPath); err != nil {
+			log.Print(err)
+			os.Exit(1)
+		}
 	}
 
-	game, err := chunkymonkey.NewGame(worldPath)
+	game, err := chunkymonkey.NewGame(worldPaths, *chunkServerAddr, *lookupServerAddr, *storageServerAddr)
 	if err != nil {
 		log.Fatal(err)
 	}
 	game.UnderMaintenanceMsg = *underMaintenaceMsg
+	if *transferSecret != "" {
+		handleTransfers(game, *transferSecret)
+	}
+	if *transferAddr != "" {
+		go game.ServeTransfers(*transferAddr)
+	}
 	err = startHttpServer(*httpAddr)
 	if err != nil {
 		log.Fatal(err)
@@ -60,7 +60,7 @@ func cmdLevel(args []string) (err os.Error) {
 
 	worldPath := args[0]
 
-	worldStore, err := worldstore.LoadWorldStore(worldPath)
+	worldStore, err := worldstore.LoadWorldStore(worldPath, nil)
 	if err != nil {
 		return
 	}
@@ -96,7 +96,7 @@ func cmdChunk(args []string) (err os.Error) {
 		dimension = DimensionId(dimInt)
 	}
 
-	worldStore, err := worldstore.LoadWorldStore(worldPath)
+	worldStore, err := worldstore.LoadWorldStore(worldPath, nil)
 	if err != nil {
 		return
 	}
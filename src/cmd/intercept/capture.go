@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+
+	"chunkymonkey/record"
+)
+
+// A capture of a connection is a pair of record files, of what the client and
+// the server sent. Both are recorded from when the connection was made, so
+// their times line up.
+func captureFilenames(prefix string) (clientFilename, serverFilename string) {
+	return prefix + ".client.record", prefix + ".server.record"
+}
+
+func createCaptureFiles(clientFilename, serverFilename string) (clientOutput, serverOutput *os.File, err os.Error) {
+	if clientOutput, err = os.Create(clientFilename); err != nil {
+		return
+	}
+	if serverOutput, err = os.Create(serverFilename); err != nil {
+		clientOutput.Close()
+		return nil, nil, err
+	}
+	return
+}
+
+// recordReader reads the data of records, noting the time at which the data
+// being read was recorded.
+type recordReader struct {
+	records []record.Record
+	data    []byte
+	timeNs  int64
+}
+
+func (r *recordReader) Read(b []byte) (n int, err os.Error) {
+	for len(r.data) == 0 {
+		if len(r.records) == 0 {
+			return 0, os.EOF
+		}
+		r.timeNs += r.records[0].DelayNs
+		r.data = r.records[0].Data
+		r.records = r.records[1:]
+	}
+	n = copy(b, r.data)
+	r.data = r.data[n:]
+	return
+}
+
+func readRecordFile(filename string) (records []record.Record, err os.Error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	return record.ReadRecords(bufio.NewReader(file))
+}
+
+// decodeCapture parses the capture with the given prefix, and dumps its
+// packets in the order in which they were sent.
+func decodeCapture(prefix string, connNumber int, d *dumper) (err os.Error) {
+	clientFilename, serverFilename := captureFilenames(prefix)
+	clientRecords, err := readRecordFile(clientFilename)
+	if err != nil {
+		return
+	}
+	serverRecords, err := readRecordFile(serverFilename)
+	if err != nil {
+		return
+	}
+
+	decodeRecords(clientRecords, serverRecords, connNumber, d.dump)
+	return
+}
+
+// decodeRecords parses the records of what the client and the server sent on
+// a connection, and passes their packets to dump in the order in which they
+// were sent.
+func decodeRecords(clientRecords, serverRecords []record.Record, connNumber int, dump func(pd *packetDump)) {
+	var clientDumps, serverDumps []*packetDump
+	clientParser, serverParser := NewMessageParsers(connNumber, nil)
+	clientParser.dump = func(pd *packetDump) {
+		clientDumps = append(clientDumps, pd)
+	}
+	serverParser.dump = func(pd *packetDump) {
+		serverDumps = append(serverDumps, pd)
+	}
+
+	// The client's packets are parsed first, as the server's can't be parsed
+	// without the client's login.
+	logPrefix := fmt.Sprintf("[%d]", connNumber)
+	clientReader := &recordReader{records: clientRecords}
+	clientParser.CsParse(clientReader, log.New(os.Stderr, logPrefix+"(C->S) ", 0),
+		func() int64 { return clientReader.timeNs })
+	serverReader := &recordReader{records: serverRecords}
+	serverParser.ScParse(serverReader, log.New(os.Stderr, logPrefix+"(S->C) ", 0),
+		func() int64 { return serverReader.timeNs })
+
+	mergeDumps(clientDumps, serverDumps, dump)
+}
+
+// mergeDumps passes the packets of both sides of a connection to dump in time
+// order. The packets of each side are already in order, and the client's come
+// first when both were sent at the same time.
+func mergeDumps(clientDumps, serverDumps []*packetDump, dump func(pd *packetDump)) {
+	for len(clientDumps) > 0 || len(serverDumps) > 0 {
+		if len(serverDumps) == 0 || (len(clientDumps) > 0 && clientDumps[0].TimeNs <= serverDumps[0].TimeNs) {
+			dump(clientDumps[0])
+			clientDumps = clientDumps[1:]
+		} else {
+			dump(serverDumps[0])
+			serverDumps = serverDumps[1:]
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"chunkymonkey/proto"
+	"chunkymonkey/record"
+	. "chunkymonkey/types"
+)
+
+func TestMergeDumps(t *testing.T) {
+	pd := func(dir string, timeNs int64) *packetDump {
+		return &packetDump{Dir: dir, TimeNs: timeNs}
+	}
+
+	tests := []struct {
+		name        string
+		clientDumps []*packetDump
+		serverDumps []*packetDump
+		want        string
+	}{
+		{"empty", nil, nil, ""},
+		{"client only", []*packetDump{pd("C", 0), pd("C", 5)}, nil, "C0 C5 "},
+		{"server only", nil, []*packetDump{pd("S", 0), pd("S", 5)}, "S0 S5 "},
+		{
+			"interleaved",
+			[]*packetDump{pd("C", 0), pd("C", 30), pd("C", 31)},
+			[]*packetDump{pd("S", 10), pd("S", 20), pd("S", 40)},
+			"C0 S10 S20 C30 C31 S40 ",
+		},
+		{
+			"client first at the same time",
+			[]*packetDump{pd("C", 10), pd("C", 20)},
+			[]*packetDump{pd("S", 10), pd("S", 20)},
+			"C10 S10 C20 S20 ",
+		},
+	}
+
+	for _, test := range tests {
+		buf := new(bytes.Buffer)
+		mergeDumps(test.clientDumps, test.serverDumps, func(pd *packetDump) {
+			fmt.Fprintf(buf, "%s%d ", pd.Dir, pd.TimeNs)
+		})
+		if got := buf.String(); got != test.want {
+			t.Errorf("%s: expected %q, got %q", test.name, test.want, got)
+		}
+	}
+}
+
+// packetRecord returns a record holding what write writes.
+func packetRecord(t *testing.T, delayNs int64, write func(w io.Writer) os.Error) record.Record {
+	buf := new(bytes.Buffer)
+	if err := write(buf); err != nil {
+		t.Fatalf("Writing record: %v", err)
+	}
+	return record.Record{DelayNs: delayNs, Data: buf.Bytes()}
+}
+
+func TestDecodeRecords(t *testing.T) {
+	codec, ok := proto.CodecForVersion(proto.ProtocolVersionBeta18)
+	if !ok {
+		t.Fatalf("No codec for protocol version %d", proto.ProtocolVersionBeta18)
+	}
+
+	// Times since the connection was made: the client sends at 0, 0, 30 and
+	// 35, and the server at 10, 20 and 40.
+	clientRecords := []record.Record{
+		packetRecord(t, 0, func(w io.Writer) os.Error {
+			return proto.ClientWriteHandshake(w, "alice")
+		}),
+		packetRecord(t, 0, func(w io.Writer) os.Error {
+			return codec.ClientWriteLogin(w, "alice")
+		}),
+		packetRecord(t, 30, func(w io.Writer) os.Error {
+			return proto.WriteChatMessage(w, "client 1")
+		}),
+		packetRecord(t, 5, func(w io.Writer) os.Error {
+			return proto.WriteChatMessage(w, "client 2")
+		}),
+	}
+	serverRecords := []record.Record{
+		packetRecord(t, 10, func(w io.Writer) os.Error {
+			return proto.ServerWriteHandshake(w, "-")
+		}),
+		packetRecord(t, 10, func(w io.Writer) os.Error {
+			return codec.ServerWriteLogin(w, EntityId(7), RandomSeed(0), GameModeSurvival, DimensionNormal)
+		}),
+		packetRecord(t, 20, func(w io.Writer) os.Error {
+			return proto.WriteChatMessage(w, "server 1")
+		}),
+	}
+
+	want := []string{
+		"C->S 0 PacketHandshake",
+		"C->S 0 PacketLogin",
+		"S->C 10 PacketHandshake",
+		"S->C 20 PacketLogin",
+		"C->S 30 PacketChatMessage",
+		"C->S 35 PacketChatMessage",
+		"S->C 40 PacketChatMessage",
+	}
+
+	var got []string
+	decodeRecords(clientRecords, serverRecords, 1, func(pd *packetDump) {
+		if pd.Conn != 1 {
+			t.Errorf("%s: expected connection 1, got %d", pd.Name, pd.Conn)
+		}
+		got = append(got, fmt.Sprintf("%s %d %s", pd.Dir, pd.TimeNs, pd.Name))
+	})
+
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d packets, got %d: %v", len(want), len(got), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Packet %d: expected %q, got %q", i, want[i], got[i])
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"json"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	. "chunkymonkey/types"
+)
+
+// packetDump is a single packet parsed from a connection.
+type packetDump struct {
+	Conn   int
+	Dir    string // "C->S" or "S->C".
+	TimeNs int64  // Since the connection was made.
+	Id     byte
+	Name   string
+	Fields []interface{} // Alternating field names and values.
+}
+
+// String formats the packet like a call, e.g. PacketChatMessage(message="hi").
+func (pd *packetDump) String() string {
+	buf := new(bytes.Buffer)
+	buf.WriteString(pd.Name)
+	buf.WriteByte('(')
+	for i := 0; i+1 < len(pd.Fields); i += 2 {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		if s, ok := pd.Fields[i+1].(string); ok {
+			fmt.Fprintf(buf, "%s=%q", pd.Fields[i], s)
+		} else {
+			fmt.Fprintf(buf, "%s=%v", pd.Fields[i], pd.Fields[i+1])
+		}
+	}
+	buf.WriteByte(')')
+	return buf.String()
+}
+
+// writeJson writes the packet as a single line of JSON. The fields are
+// written in the order that the packet holds them.
+func (pd *packetDump) writeJson(writer io.Writer) os.Error {
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, `{"conn":%d,"dir":%q,"time":%d,"id":%d,"packet":%q,"fields":{`,
+		pd.Conn, pd.Dir, pd.TimeNs, pd.Id, pd.Name)
+	for i := 0; i+1 < len(pd.Fields); i += 2 {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(buf, "%q:", pd.Fields[i])
+		value, err := json.Marshal(pd.Fields[i+1])
+		if err != nil {
+			// Such as for NaN, which JSON can't hold.
+			value, _ = json.Marshal(fmt.Sprint(pd.Fields[i+1]))
+		}
+		buf.Write(value)
+	}
+	buf.WriteString("}}\n")
+
+	_, err := writer.Write(buf.Bytes())
+	return err
+}
+
+// hasEntity returns true if any of the packet's fields is one of the given
+// entities.
+func (pd *packetDump) hasEntity(entityIds map[EntityId]bool) bool {
+	for i := 1; i < len(pd.Fields); i += 2 {
+		if entityId, ok := pd.Fields[i].(EntityId); ok && entityIds[entityId] {
+			return true
+		}
+	}
+	return false
+}
+
+// packetSet is a set of packets, given by ID or by name.
+type packetSet struct {
+	ids   map[byte]bool
+	names map[string]bool
+}
+
+// parsePacketSet parses a comma separated list of packet IDs (e.g. 0x03) and
+// names. Names are matched ignoring case and the "Packet" prefix, so that
+// ChatMessage matches PacketChatMessage.
+func parsePacketSet(list string) (set *packetSet, err os.Error) {
+	set = &packetSet{
+		ids:   make(map[byte]bool),
+		names: make(map[string]bool),
+	}
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if id, idErr := strconv.Btoui64(item, 0); idErr == nil {
+			if id > 0xff {
+				return nil, fmt.Errorf("packet ID %s out of range", item)
+			}
+			set.ids[byte(id)] = true
+		} else {
+			set.names[packetKey(item)] = true
+		}
+	}
+	return
+}
+
+func packetKey(name string) string {
+	name = strings.ToLower(name)
+	if strings.HasPrefix(name, "packet") {
+		name = name[len("packet"):]
+	}
+	return name
+}
+
+func (set *packetSet) empty() bool {
+	return len(set.ids) == 0 && len(set.names) == 0
+}
+
+func (set *packetSet) contains(pd *packetDump) bool {
+	return set.ids[pd.Id] || set.names[packetKey(pd.Name)]
+}
+
+// parseEntityIds parses a comma separated list of entity IDs.
+func parseEntityIds(list string) (entityIds map[EntityId]bool, err os.Error) {
+	entityIds = make(map[EntityId]bool)
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		id, err := strconv.Atoi(item)
+		if err != nil {
+			return nil, fmt.Errorf("bad entity ID %q", item)
+		}
+		entityIds[EntityId(id)] = true
+	}
+	return
+}
+
+// dumper writes the packets that pass its filters, as text or JSON lines. Its
+// methods may be called from any goroutine.
+type dumper struct {
+	lock      sync.Mutex
+	writer    io.Writer
+	json      bool
+	packets   *packetSet // Only these, if not empty.
+	skip      *packetSet
+	entityIds map[EntityId]bool // Only packets with these, if not empty.
+}
+
+func (d *dumper) matches(pd *packetDump) bool {
+	if !d.packets.empty() && !d.packets.contains(pd) {
+		return false
+	}
+	if d.skip.contains(pd) {
+		return false
+	}
+	if len(d.entityIds) > 0 && !pd.hasEntity(d.entityIds) {
+		return false
+	}
+	return true
+}
+
+func (d *dumper) dump(pd *packetDump) {
+	if !d.matches(pd) {
+		return
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.json {
+		pd.writeJson(d.writer)
+	} else {
+		fmt.Fprintf(d.writer, "[%d](%s) %12.6f %v\n", pd.Conn, pd.Dir, float64(pd.TimeNs)/1e9, pd)
+	}
+}
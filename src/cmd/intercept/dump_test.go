@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	. "chunkymonkey/types"
+)
+
+func TestParsePacketSet(t *testing.T) {
+	tests := []struct {
+		list      string
+		wantIds   map[byte]bool
+		wantNames map[string]bool
+		wantErr   bool
+	}{
+		{"", map[byte]bool{}, map[string]bool{}, false},
+		{"0x03", map[byte]bool{0x03: true}, map[string]bool{}, false},
+		{"3, 0x0d", map[byte]bool{0x03: true, 0x0d: true}, map[string]bool{}, false},
+		{"ChatMessage", map[byte]bool{}, map[string]bool{"chatmessage": true}, false},
+		{"PacketChatMessage,login", map[byte]bool{}, map[string]bool{"chatmessage": true, "login": true}, false},
+		{" 0xff , , PACKETLOGIN ", map[byte]bool{0xff: true}, map[string]bool{"login": true}, false},
+		{"0x100", nil, nil, true},
+	}
+
+	for _, test := range tests {
+		set, err := parsePacketSet(test.list)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%q: expected an error", test.list)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", test.list, err)
+			continue
+		}
+		if !reflect.DeepEqual(set.ids, test.wantIds) {
+			t.Errorf("%q: expected IDs %v, got %v", test.list, test.wantIds, set.ids)
+		}
+		if !reflect.DeepEqual(set.names, test.wantNames) {
+			t.Errorf("%q: expected names %v, got %v", test.list, test.wantNames, set.names)
+		}
+	}
+}
+
+func TestPacketSetContains(t *testing.T) {
+	set, err := parsePacketSet("0x03,Login")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		pd   *packetDump
+		want bool
+	}{
+		{&packetDump{Id: 0x03, Name: "PacketChatMessage"}, true},
+		{&packetDump{Id: 0x01, Name: "PacketLogin"}, true},
+		{&packetDump{Id: 0x00, Name: "PacketKeepAlive"}, false},
+	}
+
+	for _, test := range tests {
+		if got := set.contains(test.pd); got != test.want {
+			t.Errorf("%s: expected %t, got %t", test.pd.Name, test.want, got)
+		}
+	}
+}
+
+func TestParseEntityIds(t *testing.T) {
+	tests := []struct {
+		list    string
+		want    map[EntityId]bool
+		wantErr bool
+	}{
+		{"", map[EntityId]bool{}, false},
+		{"5", map[EntityId]bool{5: true}, false},
+		{"1, 2,3", map[EntityId]bool{1: true, 2: true, 3: true}, false},
+		{"1,,2,", map[EntityId]bool{1: true, 2: true}, false},
+		{"1,x", nil, true},
+		{"0x10", nil, true},
+	}
+
+	for _, test := range tests {
+		got, err := parseEntityIds(test.list)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%q: expected an error", test.list)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", test.list, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: expected %v, got %v", test.list, test.want, got)
+		}
+	}
+}
+
+func TestPacketDumpHasEntity(t *testing.T) {
+	entityIds := map[EntityId]bool{5: true, 7: true}
+
+	tests := []struct {
+		fields []interface{}
+		want   bool
+	}{
+		{nil, false},
+		{[]interface{}{"entityId", EntityId(5)}, true},
+		{[]interface{}{"entityId", EntityId(6)}, false},
+		{[]interface{}{"x", int32(1), "entityId", EntityId(7)}, true},
+		// Only fields that are entity IDs match, not other numbers.
+		{[]interface{}{"count", int32(5)}, false},
+	}
+
+	for _, test := range tests {
+		pd := &packetDump{Name: "PacketTest", Fields: test.fields}
+		if got := pd.hasEntity(entityIds); got != test.want {
+			t.Errorf("%v: expected %t, got %t", test.fields, test.want, got)
+		}
+	}
+}
+
+func TestPacketDumpWriteJson(t *testing.T) {
+	tests := []struct {
+		pd   *packetDump
+		want string
+	}{
+		{
+			&packetDump{Conn: 1, Dir: "S->C", TimeNs: 0, Id: 0x00, Name: "PacketKeepAlive"},
+			`{"conn":1,"dir":"S->C","time":0,"id":0,"packet":"PacketKeepAlive","fields":{}}` + "\n",
+		},
+		{
+			&packetDump{Conn: 3, Dir: "C->S", TimeNs: 1500, Id: 0x03, Name: "PacketChatMessage",
+				Fields: []interface{}{"message", `say "hi"`}},
+			`{"conn":3,"dir":"C->S","time":1500,"id":3,"packet":"PacketChatMessage","fields":{"message":"say \"hi\""}}` + "\n",
+		},
+		{
+			// Fields are written in the order that the packet holds them.
+			&packetDump{Conn: 2, Dir: "S->C", TimeNs: 42, Id: 0x1f, Name: "PacketEntityRelMove",
+				Fields: []interface{}{"entityId", EntityId(9), "dy", int8(-1), "dx", int8(2)}},
+			`{"conn":2,"dir":"S->C","time":42,"id":31,"packet":"PacketEntityRelMove","fields":{"entityId":9,"dy":-1,"dx":2}}` + "\n",
+		},
+	}
+
+	for _, test := range tests {
+		buf := new(bytes.Buffer)
+		if err := test.pd.writeJson(buf); err != nil {
+			t.Errorf("%s: unexpected error: %v", test.pd.Name, err)
+			continue
+		}
+		if got := buf.String(); got != test.want {
+			t.Errorf("%s:\nexpected %s\ngot      %s", test.pd.Name, test.want, got)
+		}
+	}
+}
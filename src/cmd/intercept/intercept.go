@@ -7,6 +7,7 @@ import (
 	"log"
 	"net"
 	"os"
+	"time"
 
 	"chunkymonkey/record"
 )
@@ -14,6 +15,31 @@ import (
 var recordBase = flag.String(
 	"record", "", "Record player connections to files with this prefix")
 
+var captureBase = flag.String(
+	"capture", "",
+	"Record both directions of connections to pairs of files with this "+
+		"prefix, which -decode reads")
+
+var decode = flag.Bool(
+	"decode", false,
+	"Dump the packets of the captures with the given prefixes, instead of "+
+		"relaying connections")
+
+var jsonOutput = flag.Bool(
+	"json", false, "Dump packets as JSON, one per line")
+
+var packetsFlag = flag.String(
+	"packets", "",
+	"Only dump these packets, as a comma separated list of IDs (e.g. 0x03) "+
+		"or names (e.g. ChatMessage)")
+
+var skipPacketsFlag = flag.String(
+	"skip_packets", "KeepAlive,Player", "Don't dump these packets")
+
+var entitiesFlag = flag.String(
+	"entities", "",
+	"Only dump packets about these entities, as a comma separated list of IDs")
+
 type RelayReport struct {
 	written int64
 	err     os.Error
@@ -35,7 +61,7 @@ func spliceParser(parser func(reader io.Reader), dst io.Writer, src io.Reader) (
 	return
 }
 
-func serveConn(clientConn net.Conn, remoteaddr string, connNumber int) {
+func serveConn(clientConn net.Conn, remoteaddr string, connNumber int, d *dumper) {
 	defer clientConn.Close()
 
 	clientAddr := clientConn.RemoteAddr().String()
@@ -54,8 +80,16 @@ func serveConn(clientConn net.Conn, remoteaddr string, connNumber int) {
 	defer serverConn.Close()
 	logger.Print("Connected to server")
 
-	// clientReader reads data sent from the client.
+	// Packets are timed from when the connection was made.
+	start := time.Nanoseconds()
+	clock := func() int64 {
+		return time.Nanoseconds() - start
+	}
+
+	// clientReader reads data sent from the client, and serverReader data sent
+	// from the server.
 	clientReader := io.Reader(clientConn)
+	serverReader := io.Reader(serverConn)
 	if *recordBase != "" {
 		recordFilename := fmt.Sprintf("%s-%d.record", *recordBase, connNumber)
 		recordOutput, err := os.Create(recordFilename)
@@ -69,20 +103,34 @@ func serveConn(clientConn net.Conn, remoteaddr string, connNumber int) {
 			clientReader = recorder
 		}
 	}
+	if *captureBase != "" {
+		clientFilename, serverFilename := captureFilenames(
+			fmt.Sprintf("%s-%d", *captureBase, connNumber))
+		clientOutput, serverOutput, err := createCaptureFiles(clientFilename, serverFilename)
+		if err != nil {
+			logger.Printf("Failed to open files to capture connection: %v", err)
+		} else {
+			clientRecorder := record.NewReaderRecorder(clientOutput, clientReader)
+			defer clientRecorder.Close()
+			clientReader = clientRecorder
+			serverRecorder := record.NewReaderRecorder(serverOutput, serverReader)
+			defer serverRecorder.Close()
+			serverReader = serverRecorder
+		}
+	}
 
-	clientParser := new(MessageParser)
-	serverParser := new(MessageParser)
+	clientParser, serverParser := NewMessageParsers(connNumber, func(pd *packetDump) { d.dump(pd) })
 
 	// Set up for parsing messages from server to client
 	scLogger := log.New(os.Stderr, logPrefix+"(S->C) ", log.Ldate|log.Ltime|log.Lmicroseconds)
 	serverToClientReportChan := spliceParser(
-		func(reader io.Reader) { serverParser.ScParse(reader, scLogger) },
-		clientConn, serverConn)
+		func(reader io.Reader) { serverParser.ScParse(reader, scLogger, clock) },
+		clientConn, serverReader)
 
 	// Set up for parsing messages from client to server
 	csLogger := log.New(os.Stderr, logPrefix+"(C->S) ", log.Ldate|log.Ltime|log.Lmicroseconds)
 	clientToServerReportChan := spliceParser(
-		func(reader io.Reader) { clientParser.CsParse(reader, csLogger) },
+		func(reader io.Reader) { clientParser.CsParse(reader, csLogger, clock) },
 		serverConn, clientReader)
 
 	// Wait for the both relay/splices to stop, then we let the connections
@@ -95,7 +143,7 @@ func serveConn(clientConn net.Conn, remoteaddr string, connNumber int) {
 	logger.Print("Client disconnected")
 }
 
-func serve(localaddr, remoteaddr string) (err os.Error) {
+func serve(localaddr, remoteaddr string, d *dumper) (err os.Error) {
 	listener, err := net.Listen("tcp", localaddr)
 	if err != nil {
 		log.Fatal("Listen: ", err.String())
@@ -113,7 +161,7 @@ func serve(localaddr, remoteaddr string) (err os.Error) {
 			log.Printf("Accept error: %s", acceptErr.String())
 			break
 		} else {
-			go serveConn(clientConn, remoteaddr, connNumber)
+			go serveConn(clientConn, remoteaddr, connNumber, d)
 			connNumber++
 		}
 	}
@@ -122,6 +170,7 @@ func serve(localaddr, remoteaddr string) (err os.Error) {
 
 func usage() {
 	os.Stderr.WriteString("usage: " + os.Args[0] + " [options] localaddr:port remoteaddr:port\n")
+	os.Stderr.WriteString("       " + os.Args[0] + " -decode [options] capture-prefix...\n")
 	flag.PrintDefaults()
 }
 
@@ -129,16 +178,40 @@ func main() {
 	flag.Usage = usage
 	flag.Parse()
 
-	if flag.NArg() != 2 {
+	if (*decode && flag.NArg() < 1) || (!*decode && flag.NArg() != 2) {
 		flag.Usage()
 		os.Exit(1)
 	}
 
+	d := &dumper{
+		writer: os.Stdout,
+		json:   *jsonOutput,
+	}
+	var err os.Error
+	if d.packets, err = parsePacketSet(*packetsFlag); err != nil {
+		log.Fatalf("Bad -packets: %v", err)
+	}
+	if d.skip, err = parsePacketSet(*skipPacketsFlag); err != nil {
+		log.Fatalf("Bad -skip_packets: %v", err)
+	}
+	if d.entityIds, err = parseEntityIds(*entitiesFlag); err != nil {
+		log.Fatalf("Bad -entities: %v", err)
+	}
+
+	if *decode {
+		for i := 0; i < flag.NArg(); i++ {
+			if err = decodeCapture(flag.Arg(i), i+1, d); err != nil {
+				log.Fatalf("Failed to decode capture %q: %v", flag.Arg(i), err)
+			}
+		}
+		return
+	}
+
 	localaddr := flag.Arg(0)
 	remoteaddr := flag.Arg(1)
 
 	// It's nice to have high time precision when looking at packets
 	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
 
-	serve(localaddr, remoteaddr)
+	serve(localaddr, remoteaddr, d)
 }
@@ -38,262 +38,334 @@ func (p *MessageParser) consumeUnrecognizedInput(reader io.Reader) {
 
 type MessageParser struct {
 	logger *log.Logger
+
+	// Passes the codec for the client's protocol version from the client parser
+	// to the server parser, once the client has logged in. It is shared by both
+	// parsers of a connection.
+	codecs chan *proto.Codec
+
+	// Each packet parsed is passed to dump.
+	connNumber int
+	dir        string
+	dump       func(pd *packetDump)
+
+	// Returns the time since the connection was made.
+	clock  func() int64
+	reader *packetIdReader
+}
+
+// NewMessageParsers creates the parsers for the messages from the client and
+// server of a single connection. The packets that they parse are passed to
+// dump.
+func NewMessageParsers(connNumber int, dump func(pd *packetDump)) (clientParser, serverParser *MessageParser) {
+	codecs := make(chan *proto.Codec, 1)
+	clientParser = &MessageParser{
+		codecs:     codecs,
+		connNumber: connNumber,
+		dir:        "C->S",
+		dump:       dump,
+	}
+	serverParser = &MessageParser{
+		codecs:     codecs,
+		connNumber: connNumber,
+		dir:        "S->C",
+		dump:       dump,
+	}
+	return
 }
 
 func (p *MessageParser) printf(format string, v ...interface{}) {
 	p.logger.Printf(format, v...)
 }
 
-func (p *MessageParser) PacketKeepAlive() {
-	// Not logging this packet as it's a bit spammy
+// packet dumps the packet that has just been parsed. fields alternate between
+// the name and value of each of the packet's fields.
+func (p *MessageParser) packet(name string, fields ...interface{}) {
+	p.dump(&packetDump{
+		Conn:   p.connNumber,
+		Dir:    p.dir,
+		TimeNs: p.clock(),
+		Id:     p.reader.id,
+		Name:   name,
+		Fields: fields,
+	})
+}
+
+// packetIdReader notes the first byte read after next is called, which is the
+// ID of the packet that is read next.
+type packetIdReader struct {
+	reader io.Reader
+	start  bool
+	id     byte
+}
+
+func (r *packetIdReader) next() {
+	r.start = true
+}
+
+func (r *packetIdReader) Read(b []byte) (n int, err os.Error) {
+	n, err = r.reader.Read(b)
+	if r.start && n > 0 {
+		r.id = b[0]
+		r.start = false
+	}
+	return
+}
+
+func (p *MessageParser) PacketKeepAlive(id int32) {
+	p.packet("PacketKeepAlive", "id", id)
 }
 
 func (p *MessageParser) PacketChatMessage(message string) {
-	p.printf("PacketChatMessage(%q)", message)
+	p.packet("PacketChatMessage", "message", message)
 }
 
 func (p *MessageParser) PacketRespawn(dimension DimensionId) {
-	p.printf("PacketRespawn(dimension=%d)")
+	p.packet("PacketRespawn", "dimension", dimension)
 }
 
 func (p *MessageParser) PacketPlayer(onGround bool) {
-	// Not logging this packet as it's a bit spammy
+	p.packet("PacketPlayer", "onGround", onGround)
 }
 
 func (p *MessageParser) PacketPlayerPosition(position *AbsXyz, stance AbsCoord, onGround bool) {
-	p.printf("PacketPlayerPosition(position=%v, stance=%v, onGround=%t)", position, stance, onGround)
+	p.packet("PacketPlayerPosition", "position", position, "stance", stance, "onGround", onGround)
 }
 
 func (p *MessageParser) PacketPlayerLook(look *LookDegrees, onGround bool) {
-	p.printf("PacketPlayerLook(look=%v, onGround=%t)", look, onGround)
+	p.packet("PacketPlayerLook", "look", look, "onGround", onGround)
 }
 
 func (p *MessageParser) PacketPlayerBlockHit(status DigStatus, blockLoc *BlockXyz, face Face) {
-	p.printf("PacketPlayerBlockHit(status=%v, blockLoc=%v, face=%v)", status, blockLoc, face)
+	p.packet("PacketPlayerBlockHit", "status", status, "blockLoc", blockLoc, "face", face)
 }
 
 func (p *MessageParser) PacketPlayerBlockInteract(itemId ItemTypeId, blockLoc *BlockXyz, face Face, amount ItemCount, data ItemData) {
-	p.printf("PacketPlayerBlockInteract(itemId=%d, blockLoc=%v, face=%d, amount=%d, data=%d)",
-		itemId, blockLoc, face, amount, data)
+	p.packet("PacketPlayerBlockInteract",
+		"itemId", itemId, "blockLoc", blockLoc, "face", face, "amount", amount, "data", data)
 }
 
 func (p *MessageParser) PacketHoldingChange(slotId SlotId) {
-	p.printf("PacketHoldingChange(slotId=%d)", slotId)
+	p.packet("PacketHoldingChange", "slotId", slotId)
 }
 
-func (p *MessageParser) PacketBedUse(flag bool, bedLoc *BlockXyz) {
-	p.printf("PacketBedUse(flag=%v, bedLoc=%v)", flag, bedLoc)
+func (p *MessageParser) PacketBedUse(entityId EntityId, flag bool, bedLoc *BlockXyz) {
+	p.packet("PacketBedUse", "entityId", entityId, "flag", flag, "bedLoc", bedLoc)
 }
 
 func (p *MessageParser) PacketEntityAnimation(entityId EntityId, animation EntityAnimation) {
-	p.printf("PacketEntityAnimation(entityId=%d, animation=%v)", entityId, animation)
+	p.packet("PacketEntityAnimation", "entityId", entityId, "animation", animation)
 }
 
 func (p *MessageParser) PacketEntityAction(entityId EntityId, action EntityAction) {
-	p.printf("PacketEntityAction(entityId=%d, action=%d)",
-		entityId, action)
+	p.packet("PacketEntityAction", "entityId", entityId, "action", action)
 }
 
 func (p *MessageParser) PacketSignUpdate(position *BlockXyz, lines [4]string) {
-	p.printf("PacketSignUpdate(position=%v, lines=[%q, %q, %q, %q])",
-		position,
-		lines[0], lines[1], lines[2], lines[3])
+	p.packet("PacketSignUpdate", "position", position, "lines", lines)
 }
 
-func (p *MessageParser) ClientPacketLogin(entityId EntityId, mapSeed RandomSeed, dimension DimensionId) {
-	p.printf("PacketLogin(entityId=%d, mapSeed=%d, dimension=%d)",
-		entityId, mapSeed, dimension)
+func (p *MessageParser) ClientPacketLogin(entityId EntityId, mapSeed RandomSeed, gameMode GameMode, dimension DimensionId) {
+	p.packet("PacketLogin",
+		"entityId", entityId, "mapSeed", mapSeed, "gameMode", gameMode, "dimension", dimension)
 }
 
 func (p *MessageParser) PacketTimeUpdate(time Ticks) {
-	p.printf("PacketTime(time=%d)", time)
+	p.packet("PacketTimeUpdate", "time", time)
 }
 
 func (p *MessageParser) PacketEntityEquipment(entityId EntityId, slot SlotId, itemId ItemTypeId, data ItemData) {
-	p.printf("PacketEntityEquipment(entityId=%d, slot=%d, itemId=%d, data=%d)",
-		entityId, slot, itemId, data)
+	p.packet("PacketEntityEquipment",
+		"entityId", entityId, "slot", slot, "itemId", itemId, "data", data)
 }
 
 func (p *MessageParser) PacketSpawnPosition(position *BlockXyz) {
-	p.printf("PacketSpawnPosition(position=%v)", position)
+	p.packet("PacketSpawnPosition", "position", position)
 }
 
 func (p *MessageParser) PacketUseEntity(user EntityId, target EntityId, leftClick bool) {
-	p.printf("PacketUseEntity(user=%d, target=%d, leftClick=%t)", user, target, leftClick)
+	p.packet("PacketUseEntity", "user", user, "target", target, "leftClick", leftClick)
 }
 
-func (p *MessageParser) PacketUpdateHealth(health Health) {
-	p.printf("PacketUpdateHealth(health=%d)", health)
+func (p *MessageParser) PacketUpdateHealth(health Health, food int16, foodSaturation float32) {
+	p.packet("PacketUpdateHealth", "health", health, "food", food, "foodSaturation", foodSaturation)
+}
+
+func (p *MessageParser) PacketExperience(experience, level int8, totalExperience int16) {
+	p.packet("PacketExperience",
+		"experience", experience, "level", level, "totalExperience", totalExperience)
 }
 
 func (p *MessageParser) PacketNamedEntitySpawn(entityId EntityId, name string, position *AbsIntXyz, look *LookBytes, currentItem ItemTypeId) {
-	p.printf("PacketNamedEntitySpawn(entityId=%d, name=%q, position=%v, look=%v, currentItem=%d)",
-		entityId, name, position, look, currentItem)
+	p.packet("PacketNamedEntitySpawn",
+		"entityId", entityId, "name", name, "position", position, "look", look, "currentItem", currentItem)
 }
 
 func (p *MessageParser) PacketItemSpawn(entityId EntityId, itemId ItemTypeId, count ItemCount, data ItemData, location *AbsIntXyz, orientation *OrientationBytes) {
-	p.printf("PacketItemSpawn(entityId=%d, itemId=%d, count=%d, data=%d, location=%v, orientation=%v)",
-		entityId, itemId, count, data, location, orientation)
+	p.packet("PacketItemSpawn",
+		"entityId", entityId, "itemId", itemId, "count", count, "data", data, "location", location, "orientation", orientation)
 }
 
 func (p *MessageParser) PacketItemCollect(collectedItem EntityId, collector EntityId) {
-	p.printf("PacketItemCollect(collectedItem=%d, collector=%d)",
-		collectedItem, collector)
+	p.packet("PacketItemCollect", "collectedItem", collectedItem, "collector", collector)
 }
 
 func (p *MessageParser) PacketObjectSpawn(entityId EntityId, objType ObjTypeId, position *AbsIntXyz, objectData *proto.ObjectData) {
-	p.printf("PacketObjectSpawn(entityId=%d, objType=%d, position=%v, objectData=%#v)",
-		entityId, objType, position, objectData)
+	p.packet("PacketObjectSpawn",
+		"entityId", entityId, "objType", objType, "position", position, "objectData", objectData)
 }
 
 func (p *MessageParser) PacketEntitySpawn(entityId EntityId, mobType EntityMobType, position *AbsIntXyz, look *LookBytes, metadata []proto.EntityMetadata) {
-	p.printf("PacketEntitySpawn(entityId=%d, mobType=%d, position=%v, look=%v, metadata=%v)",
-		entityId, mobType, position, look, metadata)
+	p.packet("PacketEntitySpawn",
+		"entityId", entityId, "mobType", mobType, "position", position, "look", look, "metadata", metadata)
 }
 
 func (p *MessageParser) PacketPaintingSpawn(entityId EntityId, title string, position *BlockXyz, paintingType PaintingTypeId) {
-	p.printf("PacketPaintingSpawn(entityId=%d, title=%q, position=%v, paintingType=%d)",
-		entityId, title, position, position, paintingType)
+	p.packet("PacketPaintingSpawn",
+		"entityId", entityId, "title", title, "position", position, "paintingType", paintingType)
 }
 
 func (p *MessageParser) PacketUnknown0x1b(field1, field2 float32, field3, field4 bool, field5, field6 float32) {
-	p.printf(
-		"PacketUnknown0x1b(field1=%v, field2=%v, field3=%t, field4=%t, field5=%v, field6=%v)",
-		field1, field2, field3, field4, field5, field6)
+	p.packet("PacketUnknown0x1b",
+		"field1", field1, "field2", field2, "field3", field3, "field4", field4, "field5", field5, "field6", field6)
 }
 
 func (p *MessageParser) PacketEntityVelocity(entityId EntityId, velocity *Velocity) {
-	p.printf("PacketEntityVelocity(entityId=%d, velocity=%v)",
-		entityId, velocity)
+	p.packet("PacketEntityVelocity", "entityId", entityId, "velocity", velocity)
 }
 
 func (p *MessageParser) PacketEntityDestroy(entityId EntityId) {
-	p.printf("PacketEntityDestroy(entityId=%d)", entityId)
+	p.packet("PacketEntityDestroy", "entityId", entityId)
 }
 
 func (p *MessageParser) PacketEntity(entityId EntityId) {
-	p.printf("PacketEntity(entityId=%d)", entityId)
+	p.packet("PacketEntity", "entityId", entityId)
 }
 
 func (p *MessageParser) PacketEntityRelMove(entityId EntityId, movement *RelMove) {
-	p.printf("PacketEntityRelMove(entityId=%d, movement=%v)",
-		entityId, movement)
+	p.packet("PacketEntityRelMove", "entityId", entityId, "movement", movement)
 }
 
 func (p *MessageParser) PacketEntityLook(entityId EntityId, look *LookBytes) {
-	p.printf("PacketEntityLook(entityId=%d, look=%v)",
-		entityId, look)
+	p.packet("PacketEntityLook", "entityId", entityId, "look", look)
 }
 
 func (p *MessageParser) PacketEntityTeleport(entityId EntityId, position *AbsIntXyz, look *LookBytes) {
-	p.printf("PacketEntityTeleport(entityId=%d, position=%v, look=%v)",
-		entityId, position, look)
+	p.packet("PacketEntityTeleport", "entityId", entityId, "position", position, "look", look)
 }
 
 func (p *MessageParser) PacketEntityStatus(entityId EntityId, status EntityStatus) {
-	p.printf("PacketEntityStatus(entityId=%d, status=%d)",
-		entityId, status)
+	p.packet("PacketEntityStatus", "entityId", entityId, "status", status)
+}
+
+func (p *MessageParser) PacketEntityAttach(entityId EntityId, vehicleId EntityId) {
+	p.packet("PacketEntityAttach", "entityId", entityId, "vehicleId", vehicleId)
 }
 
 func (p *MessageParser) PacketEntityMetadata(entityId EntityId, metadata []proto.EntityMetadata) {
-	p.printf("PacketEntityMetadata(entityId=%d, metadata=%v)", entityId, metadata)
+	p.packet("PacketEntityMetadata", "entityId", entityId, "metadata", metadata)
 }
 
 func (p *MessageParser) PacketPreChunk(position *ChunkXz, mode ChunkLoadMode) {
-	p.printf("PacketPreChunk(position=%v, mode=%d)", position, mode)
+	p.packet("PacketPreChunk", "position", position, "mode", mode)
 }
 
 func (p *MessageParser) PacketMapChunk(position *BlockXyz, size *SubChunkSize, data []byte) {
-	p.printf("PacketMapChunk(position=%v, size=%v, len(data)=%d)",
-		position, size, len(data))
+	p.packet("PacketMapChunk", "position", position, "size", size, "dataLength", len(data))
 }
 
 func (p *MessageParser) PacketBlockChangeMulti(chunkLoc *ChunkXz, blockCoords []SubChunkXyz, blockTypes []BlockId, blockMetaData []byte) {
-	p.printf("PacketBlockChangeMulti(chunkLoc=%v, blockCoords=(%d) %v, blockTypes=%v, blockMetaData=%v)",
-		chunkLoc, len(blockCoords), blockCoords, blockTypes, blockMetaData)
+	p.packet("PacketBlockChangeMulti",
+		"chunkLoc", chunkLoc, "blockCoords", blockCoords, "blockTypes", blockTypes, "blockMetaData", blockMetaData)
 }
 
 func (p *MessageParser) PacketBlockChange(blockLoc *BlockXyz, blockType BlockId, blockMetaData byte) {
-	p.printf("PacketBlockChange(blockLoc=%v, blockType=%d, blockMetaData=%d)",
-		blockLoc, blockType, blockMetaData)
+	p.packet("PacketBlockChange",
+		"blockLoc", blockLoc, "blockType", blockType, "blockMetaData", blockMetaData)
 }
 
 func (p *MessageParser) PacketNoteBlockPlay(position *BlockXyz, instrument InstrumentId, pitch NotePitch) {
-	p.printf("PacketNoteBlockPlay(position=%v, instrument=%d, pitch=%d)",
-		position, instrument, pitch)
+	p.packet("PacketNoteBlockPlay", "position", position, "instrument", instrument, "pitch", pitch)
 }
 
 func (p *MessageParser) PacketExplosion(position *AbsXyz, power float32, blockOffsets []proto.ExplosionOffsetXyz) {
-	p.printf("PacketExplosion(position=%v, power=%f, blockOffsets=(%d) %v)",
-		position, power, len(blockOffsets), blockOffsets)
+	p.packet("PacketExplosion", "position", position, "power", power, "blockOffsets", blockOffsets)
 }
 
 func (p *MessageParser) PacketUnknown0x3d(field1, field2 int32, field3 int8, field4, field5 int32) {
-	p.printf(
-		"PacketUnknown0x3d(field1=%d, field2=%d, field3=%d, field4=%d, field5=%d)",
-		field1, field2, field3, field4, field5)
+	p.packet("PacketUnknown0x3d",
+		"field1", field1, "field2", field2, "field3", field3, "field4", field4, "field5", field5)
 }
 
 func (p *MessageParser) PacketBedInvalid(field1 byte) {
-	p.printf("PacketBedInvalid(field1=%t)", field1)
+	p.packet("PacketBedInvalid", "field1", field1)
 }
 
 func (p *MessageParser) PacketWeather(entityId EntityId, raining bool, position *AbsIntXyz) {
-	p.printf("PacketWeather(entityId=%d, raining=%t, position=%#v)",
-		entityId, raining, position)
+	p.packet("PacketWeather", "entityId", entityId, "raining", raining, "position", position)
 }
 
 func (p *MessageParser) PacketWindowOpen(windowId WindowId, invTypeId InvTypeId, windowTitle string, numSlots byte) {
-	p.printf("PacketWindowOpen(windowId=%d, invTypeId=%d, windowTitle=%q, numSlots=%d)",
-		windowId, invTypeId, windowTitle, numSlots)
+	p.packet("PacketWindowOpen",
+		"windowId", windowId, "invTypeId", invTypeId, "windowTitle", windowTitle, "numSlots", numSlots)
 }
 
 func (p *MessageParser) PacketWindowClose(windowId WindowId) {
-	p.printf("PacketWindowClose(windowId=%d)", windowId)
+	p.packet("PacketWindowClose", "windowId", windowId)
 }
 
 func (p *MessageParser) PacketWindowClick(windowId WindowId, slot SlotId, rightClick bool, txId TxId, shiftClick bool, expectedSlot *proto.WindowSlot) {
-	p.printf("PacketWindowClick(windowId=%d, slot=%d, rightClick=%t, txId=%d, shiftClick=%t, expectedSlot=%#v)",
-		windowId, slot, rightClick, txId, shiftClick, expectedSlot)
+	p.packet("PacketWindowClick",
+		"windowId", windowId, "slot", slot, "rightClick", rightClick, "txId", txId, "shiftClick", shiftClick, "expectedSlot", expectedSlot)
+}
+
+func (p *MessageParser) PacketCreativeInventoryAction(slot SlotId, itemId ItemTypeId, amount ItemCount, data ItemData) {
+	p.packet("PacketCreativeInventoryAction",
+		"slot", slot, "itemId", itemId, "amount", amount, "data", data)
 }
 
 func (p *MessageParser) PacketWindowSetSlot(windowId WindowId, slot SlotId, itemId ItemTypeId, amount ItemCount, data ItemData) {
-	p.printf("PacketWindowSetSlot(windowId=%d, slot=%d, itemId=%d, amount=%d, data=%d)",
-		windowId, slot, itemId, amount, data)
+	p.packet("PacketWindowSetSlot",
+		"windowId", windowId, "slot", slot, "itemId", itemId, "amount", amount, "data", data)
 }
 
 func (p *MessageParser) PacketWindowItems(windowId WindowId, items []proto.WindowSlot) {
-	p.printf("PacketWindowItems(windowId=%d, items=(%d) %v)",
-		windowId, len(items), items)
+	p.packet("PacketWindowItems", "windowId", windowId, "items", items)
 }
 
 func (p *MessageParser) PacketWindowProgressBar(windowId WindowId, prgBarId PrgBarId, value PrgBarValue) {
-	p.printf("PacketWindowProgressBar(windowId=%d, prgBarId=%d, value=%d)",
-		windowId, prgBarId, value)
+	p.packet("PacketWindowProgressBar", "windowId", windowId, "prgBarId", prgBarId, "value", value)
 }
 
 func (p *MessageParser) PacketWindowTransaction(windowId WindowId, txId TxId, accepted bool) {
-	p.printf("PacketWindowTransaction(windowId=%d, txId=%d, accepted=%t)",
-		windowId, txId, accepted)
+	p.packet("PacketWindowTransaction", "windowId", windowId, "txId", txId, "accepted", accepted)
 }
 
 func (p *MessageParser) PacketIncrementStatistic(statisticId StatisticId, delta int8) {
-	p.printf("PacketIncrementStatistic(statisticId=%d, delta=%d)",
-		statisticId, delta)
+	p.packet("PacketIncrementStatistic", "statisticId", statisticId, "delta", delta)
+}
+
+func (p *MessageParser) PacketPlayerListItem(name string, online bool, ping int16) {
+	p.packet("PacketPlayerListItem", "name", name, "online", online, "ping", ping)
 }
 
 func (p *MessageParser) PacketUnknown0x83(field1, field2 int16, field3 string) {
-	p.printf("PacketUnknown0x83(field1=%d, field2=%d, field3=%q)",
-		field1, field2, field3)
+	p.packet("PacketUnknown0x83", "field1", field1, "field2", field2, "field3", field3)
 }
 
 func (p *MessageParser) PacketDisconnect(reason string) {
-	p.printf("PacketDisconnect(%q)", reason)
+	p.packet("PacketDisconnect", "reason", reason)
 }
 
-// Parses messages from the client
-func (p *MessageParser) CsParse(reader io.Reader, logger *log.Logger) {
+// Parses messages from the client. clock returns the time since the
+// connection was made.
+func (p *MessageParser) CsParse(reader io.Reader, logger *log.Logger, clock func() int64) {
 	p.logger = logger
+	p.clock = clock
+	p.reader = &packetIdReader{reader: reader}
+
+	// Stops the server parser waiting for the codec if the login fails.
+	defer close(p.codecs)
 
 	// If we return, we should consume all input to avoid blocking the pipe
 	// we're listening on. TODO Maybe we could just close it?
@@ -305,22 +377,26 @@ func (p *MessageParser) CsParse(reader io.Reader, logger *log.Logger) {
 		}
 	}()
 
-	username, err := proto.ServerReadHandshake(reader)
+	p.reader.next()
+	username, err := proto.ServerReadHandshake(p.reader)
 	if err != nil {
 		p.printf("ServerReadHandshake error: %v", err)
 		return
 	}
-	p.printf("ServerReadHandshake(username=%v)", username)
+	p.packet("PacketHandshake", "username", username)
 
-	loginUsername, err := proto.ServerReadLogin(reader)
+	p.reader.next()
+	loginUsername, codec, err := proto.ServerReadLogin(p.reader)
 	if err != nil {
 		p.printf("ServerReadLogin error: %v", err)
 		return
 	}
-	p.printf("ServerReadLogin(username=%v)", loginUsername)
+	p.packet("PacketLogin", "username", loginUsername, "version", codec.Version)
+	p.codecs <- codec
 
 	for {
-		err := proto.ServerReadPacket(reader, p)
+		p.reader.next()
+		err := codec.ServerReadPacket(p.reader, p)
 		if err != nil {
 			if err != os.EOF {
 				p.printf("ReceiveLoop failed: %v", err)
@@ -332,9 +408,12 @@ func (p *MessageParser) CsParse(reader io.Reader, logger *log.Logger) {
 	}
 }
 
-// Parses messages from the server
-func (p *MessageParser) ScParse(reader io.Reader, logger *log.Logger) {
+// Parses messages from the server. clock returns the time since the
+// connection was made.
+func (p *MessageParser) ScParse(reader io.Reader, logger *log.Logger, clock func() int64) {
 	p.logger = logger
+	p.clock = clock
+	p.reader = &packetIdReader{reader: reader}
 
 	// If we return, we should consume all input to avoid blocking the pipe
 	// we're listening on. TODO Maybe we could just close it?
@@ -346,15 +425,25 @@ func (p *MessageParser) ScParse(reader io.Reader, logger *log.Logger) {
 		}
 	}()
 
-	serverId, err := proto.ClientReadHandshake(reader)
+	p.reader.next()
+	serverId, err := proto.ClientReadHandshake(p.reader)
 	if err != nil {
 		p.printf("ClientReadHandshake error: %v", err)
 		return
 	}
-	p.printf("ClientReadHandshake(serverId=%v)", serverId)
+	p.packet("PacketHandshake", "serverId", serverId)
+
+	// The server's packets can't be parsed until the client's login says which
+	// version of the protocol they are in.
+	codec, ok := <-p.codecs
+	if !ok {
+		p.printf("Client login failed")
+		return
+	}
 
 	for {
-		err := proto.ClientReadPacket(reader, p)
+		p.reader.next()
+		err := codec.ClientReadPacket(p.reader, p)
 		if err != nil {
 			if err != os.EOF {
 				p.printf("ReceiveLoop failed: %v", err)
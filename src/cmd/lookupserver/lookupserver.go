@@ -0,0 +1,54 @@
+package main
+
+import (
+	_ "expvar"
+	"flag"
+	"http"
+	_ "http/pprof"
+	"log"
+	"net"
+	"os"
+
+	"chunkymonkey/shardlookup"
+	. "chunkymonkey/types"
+)
+
+var addr = flag.String(
+	"addr", ":25569",
+	"Serves shard lookups to frontend and chunk servers on the given address:port.")
+
+var httpAddr = flag.String(
+	"http_addr", ":25570",
+	"Serves HTTP diagnostics on the given address:port.")
+
+var leaseSecs = flag.Int(
+	"lease_secs", 30,
+	"Seconds that a chunk server's lease lasts after each heartbeat. Its "+
+		"shards are reassigned to other chunk servers once it expires.")
+
+func startHttpServer(addr string) (err os.Error) {
+	httpPort, err := net.Listen("tcp", addr)
+	if err != nil {
+		return
+	}
+	go http.Serve(httpPort, nil)
+	return
+}
+
+func main() {
+	flag.Parse()
+
+	if err := startHttpServer(*httpAddr); err != nil {
+		log.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatalf("Listen: %v", err)
+	}
+	log.Print("Listening on ", *addr)
+
+	lookup := shardlookup.NewMemLookup(int64(*leaseSecs) * NanosecondsInSecond)
+	err = shardlookup.NewLookupServer(lookup).Serve(listener)
+	log.Fatalf("Serve: %v", err)
+}
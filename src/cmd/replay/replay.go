@@ -1,56 +1,222 @@
 package main
 
 import (
+	"bufio"
 	"flag"
+	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
+	"time"
 
+	"chunkymonkey/proto"
 	"chunkymonkey/record"
 )
 
+var copies = flag.Int(
+	"n", 1,
+	"The number of times to replay each recording, all at once.")
+
+var namePrefix = flag.String(
+	"name", "replay",
+	"Replayed sessions log in as this followed by their number. If empty, "+
+		"they log in with the recorded usernames.")
+
+var speed = flag.Float64(
+	"speed", 1,
+	"How fast to replay the recordings, relative to how they were recorded.")
+
+var staggerMs = flag.Int(
+	"stagger_ms", 100,
+	"Milliseconds between each session connecting.")
+
+// The server's reply to a login is a login packet, or a disconnect packet if
+// the server refuses the player.
+const (
+	packetIdLogin      = 0x01
+	packetIdDisconnect = 0xff
+)
+
 func usage() {
-	os.Stderr.WriteString("usage: " + os.Args[0] + " server:port file.record\n")
+	os.Stderr.WriteString("usage: " + os.Args[0] + " [flags] server:port file.record...\n")
 	flag.PrintDefaults()
 }
 
-func readAndDiscard(reader io.Reader) {
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (r *countingReader) Read(p []byte) (n int, err os.Error) {
+	n, err = r.reader.Read(p)
+	r.count += int64(n)
+	return
+}
+
+// readAndDiscard reads everything the server sends until the connection ends,
+// then sets err to why and closes closed.
+func readAndDiscard(reader io.Reader, err *os.Error, closed chan bool) {
 	buf := make([]byte, 8192)
 	for {
-		_, err := reader.Read(buf)
+		if _, *err = reader.Read(buf); *err != nil {
+			close(closed)
+			return
+		}
+	}
+}
+
+// replay connects to the server at addr, logs in as username, and sends it
+// what the client sent in the recorded session.
+func replay(addr, username string, session *record.Session) (result *sessionResult) {
+	result = &sessionResult{outcome: outcomeFailed}
+
+	start := time.Nanoseconds()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		result.err = err.String()
+		return
+	}
+	defer conn.Close()
+	result.connectNs = time.Nanoseconds() - start
+
+	counter := &countingReader{reader: conn}
+	reader := bufio.NewReader(counter)
+	defer func() {
+		result.bytesReceived = counter.count
+	}()
+
+	// Log in as a client would, waiting for the server's replies.
+	start = time.Nanoseconds()
+	if err = proto.ClientWriteHandshake(conn, username); err != nil {
+		result.err = err.String()
+		return
+	}
+	serverId, err := proto.ClientReadHandshake(reader)
+	if err != nil {
+		result.err = err.String()
+		return
+	}
+	if serverId != "-" {
+		result.err = "server requires minecraft.net authentication"
+		return
+	}
+	result.handshakeNs = time.Nanoseconds() - start
+
+	start = time.Nanoseconds()
+	if err = session.Codec.ClientWriteLogin(conn, username); err != nil {
+		result.err = err.String()
+		return
+	}
+	packetId, err := reader.ReadByte()
+	if err != nil {
+		result.err = err.String()
+		return
+	}
+	switch packetId {
+	case packetIdLogin:
+	case packetIdDisconnect:
+		result.err = "refused login"
+		return
+	default:
+		result.err = fmt.Sprintf("unexpected reply to login %#x", packetId)
+		return
+	}
+	result.loginNs = time.Nanoseconds() - start
+
+	// Everything else that the server sends is discarded.
+	var readErr os.Error
+	closed := make(chan bool)
+	go readAndDiscard(reader, &readErr, closed)
+	defer func() {
+		conn.Close()
+		<-closed
+	}()
+
+	// Send the records on the recorded schedule. Sending falls behind it if
+	// the server doesn't keep up with reading them, which is measured as lag.
+	result.outcome = outcomeDisconnected
+	result.lagNs = make([]int64, 0, len(session.Records))
+	due := time.Nanoseconds()
+	for _, rec := range session.Records {
+		due += int64(float64(rec.DelayNs) / *speed)
+		if wait := due - time.Nanoseconds(); wait > 0 {
+			select {
+			case <-closed:
+				result.err = readErr.String()
+				return
+			case <-time.After(wait):
+			}
+		}
+
+		n, err := conn.Write(rec.Data)
+		result.bytesSent += int64(n)
 		if err != nil {
+			result.err = err.String()
 			return
 		}
+		result.lagNs = append(result.lagNs, time.Nanoseconds()-due)
 	}
+
+	result.outcome = outcomeCompleted
+	return
 }
 
 func main() {
 	flag.Usage = usage
 	flag.Parse()
 
-	if flag.NArg() != 2 {
+	if flag.NArg() < 2 {
 		flag.Usage()
 		os.Exit(1)
 	}
+	if *copies < 1 || *speed <= 0 || *staggerMs < 0 {
+		log.Print("-n and -speed must be positive, and -stagger_ms not negative")
+		os.Exit(1)
+	}
 
 	serverAddr := flag.Arg(0)
-	recordFilename := flag.Arg(1)
 
-	recordInput, err := os.Open(recordFilename)
-	if err != nil {
-		log.Fatalf("Failed to open record file %q: %v", recordFilename, err)
+	sessions := make([]*record.Session, flag.NArg()-1)
+	for i := range sessions {
+		recordFilename := flag.Arg(i + 1)
+		recordInput, err := os.Open(recordFilename)
+		if err != nil {
+			log.Fatalf("Failed to open record file %q: %v", recordFilename, err)
+		}
+		sessions[i], err = record.ReadSession(bufio.NewReader(recordInput))
+		recordInput.Close()
+		if err != nil {
+			log.Fatalf("Failed to read record file %q: %v", recordFilename, err)
+		}
 	}
-	defer recordInput.Close()
 
-	conn, err := net.Dial("tcp", serverAddr)
-	if err != nil {
-		log.Fatalf("Failed to connect to server %q: %v", serverAddr, err)
+	stats := newReplayStats()
+	results := make(chan *sessionResult)
+	numSessions := *copies * len(sessions)
+
+	start := time.Nanoseconds()
+	go func() {
+		for i := 0; i < numSessions; i++ {
+			session := sessions[i%len(sessions)]
+			username := session.Username
+			if *namePrefix != "" {
+				username = fmt.Sprintf("%s%d", *namePrefix, i)
+			}
+			go func() {
+				results <- replay(serverAddr, username, session)
+			}()
+			time.Sleep(int64(*staggerMs) * 1e6)
+		}
+	}()
+
+	for i := 0; i < numSessions; i++ {
+		stats.add(<-results)
 	}
-	defer conn.Close()
 
-	replayer := record.NewReaderReplayer(recordInput, conn)
-	// Discard everything the server sends us.
-	go readAndDiscard(conn)
-	replayer.Replay()
+	stats.report(os.Stdout, time.Nanoseconds()-start)
+	if !stats.ok() {
+		os.Exit(1)
+	}
 }
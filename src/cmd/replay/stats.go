@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// How a replayed session ended.
+const (
+	outcomeCompleted    = iota // The whole recording was replayed.
+	outcomeDisconnected        // The server closed the connection first.
+	outcomeFailed              // The session couldn't connect or log in.
+	numOutcomes
+)
+
+var outcomeNames = [numOutcomes]string{
+	"completed",
+	"disconnected by server",
+	"failed",
+}
+
+// sessionResult is what was measured of a single replayed session.
+type sessionResult struct {
+	outcome       int
+	err           string
+	connectNs     int64
+	handshakeNs   int64 // From sending the handshake to the server's reply.
+	loginNs       int64 // From sending the login to the server's reply.
+	lagNs         []int64
+	bytesSent     int64
+	bytesReceived int64
+}
+
+// replayStats collects the results of the replayed sessions.
+type replayStats struct {
+	lock          sync.Mutex
+	outcomes      [numOutcomes]int
+	errors        map[string]int
+	connectNs     []int64
+	handshakeNs   []int64
+	loginNs       []int64
+	lagNs         []int64
+	bytesSent     int64
+	bytesReceived int64
+}
+
+func newReplayStats() *replayStats {
+	return &replayStats{
+		errors: make(map[string]int),
+	}
+}
+
+func (stats *replayStats) add(result *sessionResult) {
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+
+	stats.outcomes[result.outcome]++
+	if result.err != "" {
+		stats.errors[result.err]++
+	}
+	if result.outcome != outcomeFailed {
+		stats.connectNs = append(stats.connectNs, result.connectNs)
+		stats.handshakeNs = append(stats.handshakeNs, result.handshakeNs)
+		stats.loginNs = append(stats.loginNs, result.loginNs)
+	}
+	stats.lagNs = append(stats.lagNs, result.lagNs...)
+	stats.bytesSent += result.bytesSent
+	stats.bytesReceived += result.bytesReceived
+}
+
+// ok returns true if every session was replayed to the end.
+func (stats *replayStats) ok() bool {
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+
+	return stats.outcomes[outcomeDisconnected] == 0 && stats.outcomes[outcomeFailed] == 0
+}
+
+// report writes a summary of the sessions replayed over elapsedNs.
+func (stats *replayStats) report(writer io.Writer, elapsedNs int64) {
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+
+	var sessions int
+	for _, count := range stats.outcomes {
+		sessions += count
+	}
+	fmt.Fprintf(writer, "Replayed %d sessions in %.1fs:", sessions, float64(elapsedNs)/1e9)
+	for outcome, count := range stats.outcomes {
+		fmt.Fprintf(writer, " %d %s", count, outcomeNames[outcome])
+		if outcome < numOutcomes-1 {
+			fmt.Fprint(writer, ",")
+		}
+	}
+	fmt.Fprintf(writer, "\nSent %d bytes, received %d bytes\n\n", stats.bytesSent, stats.bytesReceived)
+
+	fmt.Fprintf(writer, "%-10s %8s %8s %8s %8s %8s %8s\n", "(ms)", "samples", "min", "median", "95%", "99%", "max")
+	writeLatencies(writer, "connect", stats.connectNs)
+	writeLatencies(writer, "handshake", stats.handshakeNs)
+	writeLatencies(writer, "login", stats.loginNs)
+	writeLatencies(writer, "write lag", stats.lagNs)
+
+	if len(stats.errors) > 0 {
+		fmt.Fprint(writer, "\nErrors:\n")
+		for err, count := range stats.errors {
+			fmt.Fprintf(writer, "%8d %s\n", count, err)
+		}
+	}
+}
+
+// writeLatencies writes a row of the latency table. The samples are sorted.
+func writeLatencies(writer io.Writer, name string, samplesNs []int64) {
+	if len(samplesNs) == 0 {
+		fmt.Fprintf(writer, "%-10s %8d\n", name, 0)
+		return
+	}
+
+	sort.Sort(int64Slice(samplesNs))
+	fmt.Fprintf(writer, "%-10s %8d %8.1f %8.1f %8.1f %8.1f %8.1f\n",
+		name, len(samplesNs),
+		msOf(samplesNs[0]),
+		msOf(percentile(samplesNs, 50)),
+		msOf(percentile(samplesNs, 95)),
+		msOf(percentile(samplesNs, 99)),
+		msOf(samplesNs[len(samplesNs)-1]))
+}
+
+// percentile returns the sample below which p percent of the sorted samples
+// lie.
+func percentile(sorted []int64, p int) int64 {
+	return sorted[(len(sorted)-1)*p/100]
+}
+
+func msOf(ns int64) float64 {
+	return float64(ns) / 1e6
+}
+
+type int64Slice []int64
+
+func (s int64Slice) Len() int           { return len(s) }
+func (s int64Slice) Less(i, j int) bool { return s[i] < s[j] }
+func (s int64Slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
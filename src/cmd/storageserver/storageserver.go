@@ -0,0 +1,59 @@
+package main
+
+import (
+	_ "expvar"
+	"flag"
+	"http"
+	_ "http/pprof"
+	"log"
+	"net"
+	"os"
+
+	"chunkymonkey/storage"
+)
+
+var addr = flag.String(
+	"addr", ":25571",
+	"Serves chunk and player data to frontend and chunk servers on the given address:port.")
+
+var httpAddr = flag.String(
+	"http_addr", ":25572",
+	"Serves HTTP diagnostics on the given address:port.")
+
+func usage() {
+	os.Stderr.WriteString("usage: " + os.Args[0] + " [flags] <storage directory>\n")
+	flag.PrintDefaults()
+}
+
+func startHttpServer(addr string) (err os.Error) {
+	httpPort, err := net.Listen("tcp", addr)
+	if err != nil {
+		return
+	}
+	go http.Serve(httpPort, nil)
+	return
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if err := startHttpServer(*httpAddr); err != nil {
+		log.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatalf("Listen: %v", err)
+	}
+	log.Print("Listening on ", *addr)
+
+	store := storage.NewDiskStorage(flag.Arg(0))
+	err = storage.NewStorageServer(store).Serve(listener)
+	log.Fatalf("Serve: %v", err)
+}
@@ -38,6 +38,41 @@ import (
 	"strings"
 )
 
+// readAllocSize is the most memory that is allocated ahead of reading the data
+// that fills it, in bytes or list items.
+const readAllocSize = 1 << 16
+
+// readBytes reads length bytes from reader. Beyond readAllocSize, the bytes
+// are allocated as they are read, so that a bad length can't use up memory.
+func readBytes(reader io.Reader, length int) (bs []byte, err os.Error) {
+	if length < 0 {
+		return nil, fmt.Errorf("Negative length %d", length)
+	}
+
+	size := length
+	if size > readAllocSize {
+		size = readAllocSize
+	}
+	bs = make([]byte, size)
+	if _, err = io.ReadFull(reader, bs); err != nil {
+		return nil, err
+	}
+
+	for len(bs) < length {
+		start := len(bs)
+		more := start
+		if more > length-start {
+			more = length - start
+		}
+		bs = append(bs, make([]byte, more)...)
+		if _, err = io.ReadFull(reader, bs[start:]); err != nil {
+			return nil, err
+		}
+	}
+
+	return
+}
+
 // ITag is the interface for all tags that can be represented in an NBT tree.
 type ITag interface {
 	Type() TagType
@@ -239,8 +274,7 @@ func (b *ByteArray) Read(reader io.Reader) (err os.Error) {
 		return
 	}
 
-	bs := make([]byte, length.Value)
-	_, err = io.ReadFull(reader, bs)
+	bs, err := readBytes(reader, int(length.Value))
 	if err != nil {
 		return
 	}
@@ -280,8 +314,7 @@ func (s *String) Read(reader io.Reader) (err os.Error) {
 		return
 	}
 
-	bs := make([]byte, length.Value)
-	_, err = io.ReadFull(reader, bs)
+	bs, err := readBytes(reader, int(length.Value))
 	if err != nil {
 		return
 	}
@@ -325,8 +358,18 @@ func (l *List) Read(reader io.Reader) (err os.Error) {
 		return
 	}
 
-	list := make([]ITag, length.Value)
-	for i, _ := range list {
+	if length.Value < 0 {
+		return fmt.Errorf("Negative list length %d", length.Value)
+	}
+
+	// The list grows as its items are read, so that a bad length can't use up
+	// memory.
+	capacity := int(length.Value)
+	if capacity > readAllocSize {
+		capacity = readAllocSize
+	}
+	list := make([]ITag, 0, capacity)
+	for i := int32(0); i < length.Value; i++ {
 		var tag ITag
 		if tag, err = l.TagType.NewTag(); err != nil {
 			return
@@ -336,7 +379,7 @@ func (l *List) Read(reader io.Reader) (err os.Error) {
 			return
 		}
 
-		list[i] = tag
+		list = append(list, tag)
 	}
 
 	l.Value = list
@@ -214,3 +214,44 @@ func Test_Lookup(t *testing.T) {
 		t.Fatalf("Failed to look up Byte, got: %#v", tag)
 	}
 }
+
+func Test_ReadBadLengths(t *testing.T) {
+	tests := []struct {
+		desc       string
+		value      ITag
+		serialized string
+	}{
+		{"negative byte array length", &ByteArray{}, "\xff\xff\xff\xff"},
+		{"byte array longer than the data", &ByteArray{}, "\x7f\xff\xff\xff\x01\x02"},
+		{"negative string length", &String{}, "\xff\xff"},
+		{"negative list length", &List{}, "\x01\xff\xff\xff\xff"},
+		{"list longer than the data", &List{}, "\x01\x7f\xff\xff\xff\x01\x02"},
+	}
+
+	for _, test := range tests {
+		if err := test.value.Read(bytes.NewBufferString(test.serialized)); err == nil {
+			t.Errorf("%s: expected an error, got %#v", test.desc, test.value)
+		}
+	}
+}
+
+func Test_ReadLongByteArray(t *testing.T) {
+	data := make([]byte, 3*readAllocSize+5)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	buf := new(bytes.Buffer)
+	original := &ByteArray{data}
+	if err := original.Write(buf); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	result := new(ByteArray)
+	if err := result.Read(buf); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if !bytes.Equal(data, result.Value) {
+		t.Errorf("Got %d bytes differing from the %d written", len(result.Value), len(data))
+	}
+}